@@ -1,22 +1,69 @@
 package agent
 
+import (
+	"time"
+
+	"github.com/buildkite/agent/process"
+)
+
 type AgentConfiguration struct {
 	ConfigPath                string
 	BootstrapScript           string
 	BuildPath                 string
+	BuildPathTemplate         string
 	HooksPath                 string
 	PluginsPath               string
 	GitCloneFlags             string
 	GitCleanFlags             string
+	GitURLRewrites            []string
+	DotenvAllowedVars         []string
 	GitSubmodules             bool
 	SSHKeyscan                bool
 	CommandEval               bool
 	PluginsEnabled            bool
 	PluginValidation          bool
 	LocalHooksEnabled         bool
+	SkipRepositoryHooks       bool
 	RunInPty                  bool
+	WindowsPTY                bool
 	TimestampLines            bool
+	TimestampLinesFormat      string
+	TimestampLinesLocation    *time.Location
 	DisconnectAfterJob        bool
 	DisconnectAfterJobTimeout int
 	Shell                     string
+	EventSinks                []string
+	TracingOTLPEndpoint       string
+	SecretScanning            string
+	JobScopedTokens           bool
+	SeccompProfile            string
+	AppArmorProfile           string
+	SELinuxContext            string
+	CommandSandbox            bool
+	AuditLogPath              string
+	AuditLogUpload            bool
+	InfraFailureExitCodes     []int
+	InfraFailureRetries       int
+	CrashArtifactPaths        string
+	DebugSessionTimeout       int
+	DebugSessionKeys          string
+	CollapseGroupsAfter       int
+	MaxLogSizeBytes           int
+	MaxArtifactBytesPerJob    int
+	JobCPUWeight              int
+	JobMemoryMax              int64
+	JobCgroupParent           string
+	KillSequence              []process.KillStep
+	JobEnvAllowlist           []string
+	JobEnvDenylist            []string
+	JobUser                   string
+	JobIdleTimeout            time.Duration
+	Executor                  string
+	KubernetesNamespace       string
+	KubernetesImage           string
+	KubernetesAgentImage      string
+	Isolation                 string
+	IsolationOptions          []string
+	IsolationAPIProxyAddress  string
+	ConcurrencyLimits         []ConcurrencyLimit
 }
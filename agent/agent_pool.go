@@ -10,10 +10,12 @@ import (
 	"time"
 
 	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/events"
 	"github.com/buildkite/agent/logger"
 	"github.com/buildkite/agent/retry"
 	"github.com/buildkite/agent/signalwatcher"
 	"github.com/buildkite/agent/system"
+	"github.com/buildkite/agent/tracing"
 	"github.com/denisbrodbeck/machineid"
 )
 
@@ -28,10 +30,19 @@ type AgentPool struct {
 	TagsFromEC2Tags       bool
 	TagsFromGCP           bool
 	TagsFromHost          bool
+	TagsFromEnvPrefix     string
 	WaitForEC2TagsTimeout time.Duration
 	Endpoint              string
 	DisableHTTP2          bool
 	AgentConfiguration    *AgentConfiguration
+	Events                *events.Bus
+	Tracer                *tracing.Tracer
+
+	// Where this agent says it's running, so that another can replace it,
+	// and whether to replace the agent that's there already. See
+	// AgentHandover.
+	HandoverFile string
+	Replace      bool
 
 	interruptCount int
 	signalLock     sync.Mutex
@@ -48,9 +59,24 @@ func (r *AgentPool) Start() error {
 		DisableHTTP2: r.DisableHTTP2,
 	}.Create()
 
+	handover := AgentHandover{Path: r.HandoverFile}
+
+	// Wait for the agent we're replacing to finish its job, so that its
+	// name can be used again
+	var replacedName string
+	if r.Replace {
+		var err error
+		if replacedName, err = handover.Replace(); err != nil {
+			logger.Fatal("Failed to replace the running agent: %v", err)
+		}
+	}
+
 	// Create the agent template. We use pass this template to the register
 	// call, at which point we get back a real agent.
 	template := r.CreateAgentTemplate()
+	if replacedName != "" {
+		template.Name = replacedName
+	}
 
 	logger.Info("Registering agent with Buildkite...")
 
@@ -60,9 +86,19 @@ func (r *AgentPool) Start() error {
 		logger.Fatal("%s", err)
 	}
 
+	// The access token is just as sensitive as the registration token
+	logger.RedactValue(registered.AccessToken)
+
 	logger.Info("Successfully registered agent \"%s\" with tags [%s]", registered.Name,
 		strings.Join(registered.Tags, ", "))
 
+	if r.HandoverFile != "" {
+		if err := handover.Claim(registered.Name); err != nil {
+			logger.Warn("Failed to write agent handover file %s: %v", r.HandoverFile, err)
+		}
+		defer handover.Release()
+	}
+
 	logger.Debug("Ping interval: %ds", registered.PingInterval)
 	logger.Debug("Job status interval: %ds", registered.JobStatusInterval)
 	logger.Debug("Heartbeat interval: %ds", registered.HearbeatInterval)
@@ -74,6 +110,8 @@ func (r *AgentPool) Start() error {
 		AgentConfiguration: r.AgentConfiguration,
 		Endpoint:           r.Endpoint,
 		DisableHTTP2:       r.DisableHTTP2,
+		Events:             r.Events,
+		Tracer:             r.Tracer,
 	}.Create()
 
 	logger.Info("Connecting to Buildkite...")
@@ -114,6 +152,21 @@ func (r *AgentPool) Start() error {
 		}
 	})
 
+	// Stop the same way as the first Ctrl-C if another agent is replacing
+	// this one, so that the job it's running can finish
+	if r.HandoverFile != "" {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		go handover.WatchForReplacement(stop, func() {
+			r.signalLock.Lock()
+			defer r.signalLock.Unlock()
+
+			logger.Info("Another agent is replacing this one, stopping once the current job has finished")
+			worker.Stop(true)
+		})
+	}
+
 	// Starts the agent worker. This will block until the agent has
 	// finished or is stopped.
 	if err := worker.Start(); err != nil {
@@ -214,6 +267,11 @@ func (r *AgentPool) CreateAgentTemplate() *api.Agent {
 		}
 	}
 
+	// Add tags from the environment
+	if r.TagsFromEnvPrefix != "" {
+		agent.Tags = append(agent.Tags, EnvTags{Prefix: r.TagsFromEnvPrefix}.Get(os.Environ())...)
+	}
+
 	var err error
 
 	// Add the hostname
@@ -264,8 +322,13 @@ func (r *AgentPool) RegisterAgent(agent *api.Agent) (*api.Agent, error) {
 		return err
 	}
 
-	// Try to register, retrying every 10 seconds for a maximum of 30 attempts (5 minutes)
-	err = retry.Do(register, &retry.Config{Maximum: 30, Interval: 10 * time.Second})
+	// Try to register, backing off up to 30 seconds between attempts for a
+	// maximum of 5 minutes
+	config := retry.ExponentialBackoff(0)
+	config.Forever = true
+	config.MaxElapsed = 5 * time.Minute
+
+	err = retry.Do(register, config)
 
 	return registered, err
 }
@@ -284,10 +347,13 @@ func (r *AgentPool) ShowBanner() {
 			"                                                __/ |\n" +
 			" http://buildkite.com/agent                    |___/\n%s\n"
 
-	if logger.ColorsEnabled() {
-		fmt.Fprintf(logger.OutputPipe(), welcomeMessage, "\x1b[32m", "\x1b[0m")
-	} else {
-		fmt.Fprintf(logger.OutputPipe(), welcomeMessage, "", "")
+	// The banner isn't valid JSON, so leave it out of structured logs
+	if logger.GetFormat() != logger.JSONFormat {
+		if logger.ColorsEnabled() {
+			fmt.Fprintf(logger.OutputPipe(), welcomeMessage, "\x1b[32m", "\x1b[0m")
+		} else {
+			fmt.Fprintf(logger.OutputPipe(), welcomeMessage, "", "")
+		}
 	}
 
 	logger.Notice("Starting buildkite-agent v%s with PID: %s", Version(), fmt.Sprintf("%d", os.Getpid()))
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
@@ -8,9 +9,11 @@ import (
 	"time"
 
 	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/events"
 	"github.com/buildkite/agent/logger"
 	"github.com/buildkite/agent/proctitle"
 	"github.com/buildkite/agent/retry"
+	"github.com/buildkite/agent/tracing"
 )
 
 type AgentWorker struct {
@@ -35,6 +38,12 @@ type AgentWorker struct {
 	// The configuration of the agent from the CLI
 	AgentConfiguration *AgentConfiguration
 
+	// Where job lifecycle events are sent
+	Events *events.Bus
+
+	// Traces each job the agent runs
+	Tracer *tracing.Tracer
+
 	// Whether or not the agent is running
 	running bool
 
@@ -44,6 +53,11 @@ type AgentWorker struct {
 	// Tracking the auto disconnect timer
 	disconnectTimeoutTimer *time.Timer
 
+	// Cancelled when the agent is forcefully stopped, so that anything
+	// retrying in the background gives up straight away
+	context       context.Context
+	contextCancel context.CancelFunc
+
 	// Stop controls
 	stop      chan struct{}
 	stopping  bool
@@ -52,6 +66,13 @@ type AgentWorker struct {
 	// When this worker runs a job, we'll store an instance of the
 	// JobRunner here
 	jobRunner *JobRunner
+
+	// Logs lines with the agent name attached
+	logger *logger.Logger
+
+	// The last job that was left for another agent because its tag
+	// expression didn't match
+	skippedJobID string
 }
 
 // Creates the agent worker and initializes it's API Client
@@ -69,6 +90,10 @@ func (a AgentWorker) Create() AgentWorker {
 		DisableHTTP2: a.DisableHTTP2,
 	}.Create()
 
+	a.logger = logger.WithFields(logger.Fields{"agent": a.Agent.Name})
+
+	a.context, a.contextCancel = context.WithCancel(context.Background())
+
 	return a
 }
 
@@ -90,7 +115,7 @@ func (a *AgentWorker) Start() error {
 				// Get the last heartbeat time to the nearest microsecond
 				lastHeartbeat := time.Unix(atomic.LoadInt64(&a.lastPing), 0)
 
-				logger.Error("Failed to heartbeat %s. Will try again in %s. (Last successful was %v ago)",
+				a.logger.Error("Failed to heartbeat %s. Will try again in %s. (Last successful was %v ago)",
 					err, heartbeatInterval, time.Now().Sub(lastHeartbeat))
 			}
 
@@ -107,7 +132,7 @@ func (a *AgentWorker) Start() error {
 		a.disconnectTimeoutTimer = time.NewTimer(time.Second * time.Duration(a.AgentConfiguration.DisconnectAfterJobTimeout))
 		go func() {
 			<-a.disconnectTimeoutTimer.C
-			logger.Debug("[DisconnectionTimer] Reached %d seconds...", a.AgentConfiguration.DisconnectAfterJobTimeout)
+			a.logger.Debug("[DisconnectionTimer] Reached %d seconds...", a.AgentConfiguration.DisconnectAfterJobTimeout)
 
 			// Just double check that the agent isn't running a
 			// job. The timer is stopped just after this is
@@ -115,14 +140,14 @@ func (a *AgentWorker) Start() error {
 			// where in between accepting the job, and creating the
 			// `jobRunner`, the timer pops.
 			if a.jobRunner == nil && !a.stopping {
-				logger.Debug("[DisconnectionTimer] The agent isn't running a job, going to signal a stop")
+				a.logger.Debug("[DisconnectionTimer] The agent isn't running a job, going to signal a stop")
 				a.Stop(true)
 			} else {
-				logger.Debug("[DisconnectionTimer] Agent is running a job, going to just ignore and let it finish it's work")
+				a.logger.Debug("[DisconnectionTimer] Agent is running a job, going to just ignore and let it finish it's work")
 			}
 		}()
 
-		logger.Debug("[DisconnectionTimer] Started for %d seconds...", a.AgentConfiguration.DisconnectAfterJobTimeout)
+		a.logger.Debug("[DisconnectionTimer] Started for %d seconds...", a.AgentConfiguration.DisconnectAfterJobTimeout)
 	}
 
 	// Continue this loop until the the ticker is stopped, and we received
@@ -157,28 +182,31 @@ func (a *AgentWorker) Stop(graceful bool) {
 
 	if graceful {
 		if a.stopping {
-			logger.Warn("Agent is already gracefully stopping...")
+			a.logger.Warn("Agent is already gracefully stopping...")
 		} else {
 			// If we have a job, tell the user that we'll wait for
 			// it to finish before disconnecting
 			if a.jobRunner != nil {
-				logger.Info("Gracefully stopping agent. Waiting for current job to finish before disconnecting...")
+				a.logger.Info("Gracefully stopping agent. Waiting for current job to finish before disconnecting...")
 			} else {
-				logger.Info("Gracefully stopping agent. Since there is no job running, the agent will disconnect immediately")
+				a.logger.Info("Gracefully stopping agent. Since there is no job running, the agent will disconnect immediately")
 			}
 		}
 	} else {
 		// If there's a job running, kill it, then disconnect
 		if a.jobRunner != nil {
-			logger.Info("Forcefully stopping agent. The current job will be canceled before disconnecting...")
+			a.logger.Info("Forcefully stopping agent. The current job will be canceled before disconnecting...")
 
 			// Kill the current job. Doesn't do anything if the job
 			// is already being killed, so it's safe to call
 			// multiple times.
 			a.jobRunner.Kill()
 		} else {
-			logger.Info("Forcefully stopping agent. Since there is no job running, the agent will disconnect immediately")
+			a.logger.Info("Forcefully stopping agent. Since there is no job running, the agent will disconnect immediately")
 		}
+
+		// Stop any retries that are waiting to try again
+		a.contextCancel()
 	}
 
 	// We don't need to do the below operations again since we've already
@@ -209,11 +237,11 @@ func (a *AgentWorker) Connect() error {
 	return retry.Do(func(s *retry.Stats) error {
 		_, err := a.APIClient.Agents.Connect()
 		if err != nil {
-			logger.Warn("%s (%s)", err, s)
+			a.logger.Warn("%s (%s)", err, s)
 		}
 
 		return err
-	}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+	}, retry.ExponentialBackoff(10))
 }
 
 // Performs a heatbeat
@@ -222,10 +250,10 @@ func (a *AgentWorker) Heartbeat() error {
 	var err error
 
 	// Retry the heartbeat a few times
-	err = retry.Do(func(s *retry.Stats) error {
+	err = retry.DoWithContext(a.context, func(s *retry.Stats) error {
 		beat, _, err = a.APIClient.Heartbeats.Beat()
 		if err != nil {
-			logger.Warn("%s (%s)", err, s)
+			a.logger.Warn("%s (%s)", err, s)
 		}
 		return err
 	}, &retry.Config{Maximum: 5, Interval: 5 * time.Second})
@@ -237,12 +265,20 @@ func (a *AgentWorker) Heartbeat() error {
 	// Track a timestamp for the successful heartbeat for better errors
 	atomic.StoreInt64(&a.lastHeartbeat, time.Now().Unix())
 
-	logger.Debug("Heartbeat sent at %s and received at %s", beat.SentAt, beat.ReceivedAt)
+	a.logger.Debug("Heartbeat sent at %s and received at %s", beat.SentAt, beat.ReceivedAt)
 	return nil
 }
 
 // Performs a ping, which returns what action the agent should take next.
 func (a *AgentWorker) Ping() {
+	// Only ask for work once there's room for another job on the host.
+	// The slots are held until the job finishes.
+	slots, ok := a.acquireConcurrencySlots()
+	if !ok {
+		return
+	}
+	defer a.releaseConcurrencySlots(slots)
+
 	// Update the proc title
 	a.UpdateProcTitle("pinging")
 
@@ -253,7 +289,7 @@ func (a *AgentWorker) Ping() {
 
 		// If a ping fails, we don't really care, because it'll
 		// ping again after the interval.
-		logger.Warn("Failed to ping: %s (Last successful was %v ago)", err, time.Now().Sub(lastPing))
+		a.logger.Warn("Failed to ping: %s (Last successful was %v ago)", err, time.Now().Sub(lastPing))
 
 		// When the ping fails, we wan't to reset our disconnection
 		// timer. It wouldnt' be very nice if we just killed the agent
@@ -262,7 +298,7 @@ func (a *AgentWorker) Ping() {
 			jobTimeoutSeconds := time.Second * time.Duration(a.AgentConfiguration.DisconnectAfterJobTimeout)
 			a.disconnectTimeoutTimer.Reset(jobTimeoutSeconds)
 
-			logger.Debug("[DisconnectionTimer] Reset back to %d seconds because of ping failure...", a.AgentConfiguration.DisconnectAfterJobTimeout)
+			a.logger.Debug("[DisconnectionTimer] Reset back to %d seconds because of ping failure...", a.AgentConfiguration.DisconnectAfterJobTimeout)
 		}
 
 		return
@@ -279,7 +315,7 @@ func (a *AgentWorker) Ping() {
 		newAPIClient := APIClient{Endpoint: ping.Endpoint, Token: a.Agent.AccessToken}.Create()
 		newPing, _, err := newAPIClient.Pings.Get()
 		if err != nil {
-			logger.Warn("Failed to ping the new endpoint %s - ignoring switch for now (%s)", ping.Endpoint, err)
+			a.logger.Warn("Failed to ping the new endpoint %s - ignoring switch for now (%s)", ping.Endpoint, err)
 		} else {
 			// Replace the APIClient and process the new ping
 			a.APIClient = newAPIClient
@@ -290,7 +326,7 @@ func (a *AgentWorker) Ping() {
 
 	// Is there a message that should be shown in the logs?
 	if ping.Message != "" {
-		logger.Info(ping.Message)
+		a.logger.Info("%s", ping.Message)
 	}
 
 	// Should the agent disconnect?
@@ -310,20 +346,30 @@ func (a *AgentWorker) Ping() {
 	// Update the proc title
 	a.UpdateProcTitle(fmt.Sprintf("job %s", strings.Split(ping.Job.ID, "-")[0]))
 
-	logger.Info("Assigned job %s. Accepting...", ping.Job.ID)
+	// The job can ask for agents with tags that match an expression, which
+	// is more than Buildkite can check for when it assigns jobs
+	if expression := ping.Job.Env[tagExpressionEnv]; expression != "" && !a.matchesTagExpression(ping.Job, expression) {
+		a.skipMismatchedJob(ping.Job, expression)
+		a.UpdateProcTitle("idle")
+		return
+	}
+
+	a.logger.Info("Assigned job %s. Accepting...", ping.Job.ID)
+
+	acceptStartedAt := time.Now()
 
 	// Accept the job. We'll retry on connection related issues, but if
 	// Buildkite returns a 422 or 500 for example, we'll just bail out,
 	// re-ping, and try the whole process again.
 	var accepted *api.Job
-	retry.Do(func(s *retry.Stats) error {
+	retry.DoWithContext(a.context, func(s *retry.Stats) error {
 		accepted, _, err = a.APIClient.Jobs.Accept(ping.Job)
 
 		if err != nil {
 			if api.IsRetryableError(err) {
-				logger.Warn("%s (%s)", err, s)
+				a.logger.Warn("%s (%s)", err, s)
 			} else {
-				logger.Warn("Buildkite rejected the call to accept the job (%s)", err)
+				a.logger.Warn("Buildkite rejected the call to accept the job (%s)", err)
 				s.Break()
 			}
 		}
@@ -331,42 +377,80 @@ func (a *AgentWorker) Ping() {
 		return err
 	}, &retry.Config{Maximum: 30, Interval: 5 * time.Second})
 
+	// The span covering the whole job, starting from when it was accepted.
+	// If the job was given a TRACEPARENT, the job joins that trace.
+	var traceParent tracing.SpanContext
+	if accepted != nil && accepted.Env["TRACEPARENT"] != "" {
+		var parseErr error
+		if traceParent, parseErr = tracing.ParseTraceparent(accepted.Env["TRACEPARENT"]); parseErr != nil {
+			a.logger.Warn("Ignoring the job's TRACEPARENT: %s", parseErr)
+		}
+	}
+
+	jobSpan := a.Tracer.StartSpanAt("job", traceParent, acceptStartedAt)
+	jobSpan.SetAttribute("buildkite.agent", a.Agent.Name)
+	jobSpan.SetAttribute("buildkite.job_id", ping.Job.ID)
+
+	acceptSpan := jobSpan.StartChildAt("accept", acceptStartedAt)
+	acceptSpan.RecordError(err)
+	acceptSpan.Finish()
+
 	// If `accepted` is nil, then the job was never accepted
 	if accepted == nil {
-		logger.Error("Failed to accept job")
+		a.logger.Error("Failed to accept job")
+
+		jobSpan.RecordError(fmt.Errorf("Failed to accept job"))
+		jobSpan.Finish()
+		a.Tracer.Flush()
 		return
 	}
 
+	a.Events.Emit(events.Event{
+		Type:  events.JobAccepted,
+		Agent: a.Agent.Name,
+		JobID: accepted.ID,
+	})
+
 	// Now that the job has been accepted, we can start it.
 	a.jobRunner, err = JobRunner{
 		Endpoint:           accepted.Endpoint,
 		Agent:              a.Agent,
 		AgentConfiguration: a.AgentConfiguration,
 		Job:                accepted,
+		Events:             a.Events,
+		Span:               jobSpan,
 	}.Create()
 
 	// Woo! We've got a job, and successfully accepted it, let's kill our auto-disconnect timer
 	if a.disconnectTimeoutTimer != nil {
-		logger.Debug("[DisconnectionTimer] A job was assigned and accepted, stopping timer...")
+		a.logger.Debug("[DisconnectionTimer] A job was assigned and accepted, stopping timer...")
 		a.disconnectTimeoutTimer.Stop()
 	}
 
 	// Was there an error creating the job runner?
 	if err != nil {
-		logger.Error("Failed to initialize job: %s", err)
+		a.logger.Error("Failed to initialize job: %s", err)
+
+		jobSpan.RecordError(err)
+		jobSpan.Finish()
+		a.Tracer.Flush()
 		return
 	}
 
 	// Start running the job
 	if err = a.jobRunner.Run(); err != nil {
-		logger.Error("Failed to run job: %s", err)
+		a.logger.Error("Failed to run job: %s", err)
 	}
 
+	jobSpan.RecordError(err)
+	jobSpan.Finish()
+	a.Tracer.Flush()
+
 	// No more job, no more runner.
 	a.jobRunner = nil
 
 	if a.AgentConfiguration.DisconnectAfterJob {
-		logger.Info("Job finished. Disconnecting...")
+		a.logger.Info("Job finished. Disconnecting...")
 
 		// We can just kill this timer now as well
 		if a.disconnectTimeoutTimer != nil {
@@ -378,6 +462,76 @@ func (a *AgentWorker) Ping() {
 	}
 }
 
+// acquireConcurrencySlots takes a slot of the host-wide semaphore for each
+// concurrency limit on one of the agent's tags. It returns false if any of
+// them are full, after giving back the slots it did get.
+func (a *AgentWorker) acquireConcurrencySlots() ([]*HostSemaphoreSlot, bool) {
+	var slots []*HostSemaphoreSlot
+
+	for _, limit := range a.AgentConfiguration.ConcurrencyLimits {
+		if !a.hasTag(limit.Tag) {
+			continue
+		}
+
+		slot, err := HostSemaphore{Dir: HostSemaphoreDir(a.AgentConfiguration.BuildPath), Name: limit.Tag, Max: limit.Max}.TryAcquire()
+		if err != nil {
+			a.logger.Warn("Failed to check the concurrency limit for %s: %s", limit.Tag, err)
+		}
+		if slot == nil {
+			a.logger.Debug("Already running %d jobs tagged %s on this host, waiting to ping", limit.Max, limit.Tag)
+			a.UpdateProcTitle(fmt.Sprintf("waiting for %s", limit.Tag))
+			a.releaseConcurrencySlots(slots)
+			return nil, false
+		}
+
+		slots = append(slots, slot)
+	}
+
+	return slots, true
+}
+
+func (a *AgentWorker) releaseConcurrencySlots(slots []*HostSemaphoreSlot) {
+	for _, slot := range slots {
+		if err := slot.Release(); err != nil {
+			a.logger.Warn("Failed to release concurrency limit: %s", err)
+		}
+	}
+}
+
+func (a *AgentWorker) hasTag(tag string) bool {
+	for _, t := range a.Agent.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesTagExpression returns whether the agent's tags match the job's tag
+// expression. Jobs with expressions that can't be parsed don't match.
+func (a *AgentWorker) matchesTagExpression(job *api.Job, expression string) bool {
+	expr, err := ParseTagExpression(expression)
+	if err != nil {
+		a.logger.Warn("Job %s has an invalid tag expression: %s", job.ID, err)
+		return false
+	}
+
+	return expr.Match(TagsToMap(a.Agent.Tags))
+}
+
+// skipMismatchedJob leaves a job this agent can't run because its tags don't
+// match the job's tag expression. The job isn't accepted, so the assignment
+// times out and Buildkite gives it to another agent. It's only logged the
+// first time, as it's assigned again on each ping until then.
+func (a *AgentWorker) skipMismatchedJob(job *api.Job, expression string) {
+	if a.skippedJobID == job.ID {
+		return
+	}
+	a.skippedJobID = job.ID
+
+	a.logger.Warn("Assigned job %s, but this agent's tags don't match %q. Leaving it for another agent...", job.ID, expression)
+}
+
 // Disconnects the agent from the Buildkite Agent API, doesn't bother retrying
 // because we want to disconnect as fast as possible.
 func (a *AgentWorker) Disconnect() error {
@@ -386,7 +540,7 @@ func (a *AgentWorker) Disconnect() error {
 
 	_, err := a.APIClient.Agents.Disconnect()
 	if err != nil {
-		logger.Warn("There was an error sending the disconnect API call to Buildkite. If this agent still appears online, you may have to manually stop it (%s)", err)
+		a.logger.Warn("There was an error sending the disconnect API call to Buildkite. If this agent still appears online, you may have to manually stop it (%s)", err)
 	}
 
 	return err
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/logger"
+)
+
+func TestJobsWithMismatchedTagExpressionsAreNotAccepted(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		if req.URL.Path == "/ping" {
+			w.Write([]byte(`{"job":{"id":"llamas","env":{"BUILDKITE_TAG_EXPRESSION":"queue=default && gpu"}}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	worker := &AgentWorker{
+		APIClient:          APIClient{Endpoint: server.URL, Token: "llamas"}.Create(),
+		Agent:              &api.Agent{Tags: []string{"queue=default"}},
+		AgentConfiguration: &AgentConfiguration{},
+		context:            context.Background(),
+		logger:             logger.WithFields(logger.Fields{}),
+	}
+
+	worker.Ping()
+	worker.Ping()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The job is left assigned until it times out, rather than accepted
+	expected := []string{"GET /ping", "GET /ping"}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Fatalf("Unexpected API calls:\nWanted: %v\nGot:    %v", expected, paths)
+	}
+
+	if worker.skippedJobID != "llamas" {
+		t.Fatalf("Expected the job to be skipped, got %q", worker.skippedJobID)
+	}
+}
+
+func TestMatchesTagExpression(t *testing.T) {
+	worker := &AgentWorker{
+		Agent:  &api.Agent{Tags: []string{"queue=default", "docker"}},
+		logger: logger.WithFields(logger.Fields{}),
+	}
+
+	job := &api.Job{ID: "llamas"}
+
+	if !worker.matchesTagExpression(job, "queue=default && docker && !gpu") {
+		t.Fatal("Expected the job's tag expression to match")
+	}
+	if worker.matchesTagExpression(job, "queue=default && gpu") {
+		t.Fatal("Expected the job's tag expression to not match")
+	}
+	if worker.matchesTagExpression(job, "queue=default &&") {
+		t.Fatal("Expected an invalid tag expression to not match")
+	}
+}
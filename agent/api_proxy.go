@@ -1,17 +1,20 @@
 package agent
 
 import (
-	"crypto/sha256"
-	"fmt"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/buildkite/agent/api"
 	"github.com/buildkite/agent/logger"
@@ -23,11 +26,17 @@ type APIProxy struct {
 	upstreamToken    string
 	upstreamEndpoint string
 	token            string
-	socket           *os.File
+	revoked          bool
+	scope            []string
+	socketDir        string
+	tcpAddress       string
 	listener         net.Listener
 	listenerWg       *sync.WaitGroup
+	mu               sync.Mutex
 }
 
+// NewAPIProxy creates a proxy that authenticates to endpoint with token, and
+// mints a new random token that clients of the proxy must use instead
 func NewAPIProxy(endpoint string, token string) *APIProxy {
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -35,21 +44,84 @@ func NewAPIProxy(endpoint string, token string) *APIProxy {
 	return &APIProxy{
 		upstreamToken:    token,
 		upstreamEndpoint: endpoint,
-		token:            fmt.Sprintf("%x", sha256.Sum256([]byte(string(time.Now().UnixNano())))),
+		token:            newProxyToken(),
 		listenerWg:       &wg,
 	}
 }
 
+func newProxyToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Scope restricts the proxy's token to requests for paths that start with
+// one of the given prefixes, e.g. "/jobs/<id>/annotations". Without a scope
+// every request is proxied.
+func (p *APIProxy) Scope(prefixes ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.scope = prefixes
+}
+
+// Revoke stops the proxy's token from being accepted, so it can't be used
+// once the job it was created for has finished
+func (p *APIProxy) Revoke() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.revoked = true
+}
+
+// authorize checks a request's token and path, returning the status code to
+// respond with if it isn't allowed
+func (p *APIProxy) authorize(r *http.Request) (int, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	given := []byte(r.Header.Get(`Authorization`))
+	if subtle.ConstantTimeCompare(given, []byte(`Token `+p.token)) != 1 {
+		return http.StatusBadRequest, "Invalid authorization token"
+	}
+
+	if p.revoked {
+		return http.StatusUnauthorized, "Authorization token has been revoked"
+	}
+
+	if len(p.scope) == 0 {
+		return 0, ""
+	}
+
+	requested := path.Clean("/" + r.URL.Path)
+	for _, prefix := range p.scope {
+		if requested == prefix || strings.HasPrefix(requested, strings.TrimSuffix(prefix, "/")+"/") {
+			return 0, ""
+		}
+	}
+
+	return http.StatusForbidden, "Authorization token isn't allowed to access " + requested
+}
+
+// ListenOnTCP makes the proxy listen on a tcp address, rather than a unix
+// socket, for jobs that can't reach the agent's filesystem. A port of 0 picks
+// any available one.
+func (p *APIProxy) ListenOnTCP(address string) {
+	p.tcpAddress = address
+}
+
 // Listen on either a tcp socket (for windows) or a unix socket
 func (p *APIProxy) Listen() error {
 	defer p.listenerWg.Done()
 	var err error
 
 	// windows doesn't support unix sockets, so we fall back to a tcp socket
-	if runtime.GOOS == `windows` {
+	if runtime.GOOS == `windows` || p.tcpAddress != "" {
 		p.listener, err = p.listenOnTCPSocket()
 	} else {
-		p.listener, p.socket, err = p.listenOnUnixSocket()
+		p.listener, p.socketDir, err = p.listenOnUnixSocket()
 	}
 
 	if err != nil {
@@ -76,8 +148,8 @@ func (p *APIProxy) Listen() error {
 
 		// serve traffic, proxy off to the reverse proxy
 		_ = http.Serve(p.listener, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-			if r.Header.Get(`Authorization`) != `Token `+p.token {
-				http.Error(rw, "Invalid authorization token", http.StatusBadRequest)
+			if status, message := p.authorize(r); status != 0 {
+				http.Error(rw, message, status)
 				return
 			}
 			proxy.ServeHTTP(rw, r)
@@ -87,35 +159,45 @@ func (p *APIProxy) Listen() error {
 	return nil
 }
 
-func (p *APIProxy) listenOnUnixSocket() (net.Listener, *os.File, error) {
-	socket, err := ioutil.TempFile("", "agent-socket")
+// listenOnUnixSocket listens on a socket in a new directory that only the
+// agent's user can use, so that the job's sandbox can be given the socket
+// without the rest of the temp directory
+func (p *APIProxy) listenOnUnixSocket() (net.Listener, string, error) {
+	dir, err := ioutil.TempDir("", "agent-socket")
 	if err != nil {
-		return nil, nil, err
+		return nil, "", err
 	}
 
-	// Servers should unlink the socket path name prior to binding it.
-	// https://troydhanson.github.io/network/Unix_domain_sockets.html
-	_ = os.Remove(socket.Name())
+	socket := filepath.Join(dir, "socket")
 
-	logger.Debug("[APIProxy] Listening on unix socket %s", socket.Name())
+	logger.Debug("[APIProxy] Listening on unix socket %s", socket)
 
 	// create a unix socket to do the listening
-	l, err := net.Listen("unix", socket.Name())
+	l, err := net.Listen("unix", socket)
 	if err != nil {
-		return nil, nil, err
+		os.RemoveAll(dir)
+		return nil, "", err
 	}
 
 	// Restrict to owner r+w permissions
-	if err = os.Chmod(socket.Name(), 0600); err != nil {
-		return nil, nil, err
+	if err = os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		os.RemoveAll(dir)
+		return nil, "", err
 	}
 
-	return l, socket, nil
+	return l, dir, nil
 }
 
 func (p *APIProxy) listenOnTCPSocket() (net.Listener, error) {
-	// Listen on the first available non-privileged port
-	l, err := net.Listen("tcp", "127.0.0.1:0")
+	// Listen on the first available non-privileged port, unless we've been
+	// given somewhere else
+	address := "127.0.0.1:0"
+	if p.tcpAddress != "" {
+		address = p.tcpAddress
+	}
+
+	l, err := net.Listen("tcp", address)
 	if err != nil {
 		return nil, err
 	}
@@ -124,10 +206,34 @@ func (p *APIProxy) listenOnTCPSocket() (net.Listener, error) {
 	return l, nil
 }
 
-// Close any listeners or internal files
+// Chown gives the unix socket, and the directory it's in, to another user, so
+// that a job running as that user can connect to it
+func (p *APIProxy) Chown(uid, gid int) error {
+	if p.socketDir == "" {
+		return nil
+	}
+
+	for _, path := range []string{p.socketDir, p.listener.Addr().String()} {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close any listeners or internal files, revoking the token
 func (p *APIProxy) Close() error {
+	p.Revoke()
+
 	defer p.listenerWg.Add(1)
-	return p.listener.Close()
+	err := p.listener.Close()
+
+	if p.socketDir != "" {
+		os.RemoveAll(p.socketDir)
+	}
+
+	return err
 }
 
 // Wait blocks until the listener is ready
@@ -136,7 +242,7 @@ func (p *APIProxy) Wait() {
 }
 
 func (p *APIProxy) Endpoint() string {
-	if p.socket != nil {
+	if p.socketDir != "" {
 		return `unix://` + p.listener.Addr().String()
 	}
 	return `http://` + p.listener.Addr().String()
@@ -4,7 +4,13 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+
+	"github.com/buildkite/agent/api"
 )
 
 func TestAPIProxy(t *testing.T) {
@@ -68,3 +74,106 @@ func TestAPIProxyFailsWithoutAccessToken(t *testing.T) {
 		t.Fatalf("Expected an error without an access token")
 	}
 }
+
+func TestAPIProxyRestrictsTokenToScope(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message": "ok"}`)
+	}))
+	defer ts.Close()
+
+	proxy := NewAPIProxy(ts.URL, `llamas`)
+	proxy.Scope("/jobs/my-job/annotations")
+	go proxy.Listen()
+	proxy.Wait()
+	defer proxy.Close()
+
+	client := APIClient{
+		Endpoint: proxy.Endpoint(),
+		Token:    proxy.AccessToken(),
+	}.Create()
+
+	if _, err := client.Annotations.Create("my-job", &api.Annotation{Body: "hi"}); err != nil {
+		t.Fatalf("Expected the job's own annotations to be allowed, got %v", err)
+	}
+
+	if _, err := client.Annotations.Create("another-job", &api.Annotation{Body: "hi"}); err == nil {
+		t.Fatalf("Expected another job's annotations to be forbidden")
+	}
+
+	if _, _, err := client.Pings.Get(); err == nil {
+		t.Fatalf("Expected pings to be forbidden")
+	}
+}
+
+func TestAPIProxyRejectsRevokedToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message": "ok"}`)
+	}))
+	defer ts.Close()
+
+	proxy := NewAPIProxy(ts.URL, `llamas`)
+	go proxy.Listen()
+	proxy.Wait()
+	defer proxy.Close()
+
+	client := APIClient{
+		Endpoint: proxy.Endpoint(),
+		Token:    proxy.AccessToken(),
+	}.Create()
+
+	if _, _, err := client.Pings.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy.Revoke()
+
+	if _, _, err := client.Pings.Get(); err == nil {
+		t.Fatalf("Expected an error with a revoked access token")
+	}
+}
+
+func TestAPIProxyTokensAreUnique(t *testing.T) {
+	a := NewAPIProxy(`http://localhost`, `llamas`)
+	b := NewAPIProxy(`http://localhost`, `llamas`)
+
+	if a.AccessToken() == b.AccessToken() {
+		t.Fatalf("Expected each proxy to mint its own token")
+	}
+}
+
+func TestAPIProxySocketIsInAPrivateDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The proxy listens on a tcp socket on Windows")
+	}
+
+	proxy := NewAPIProxy("http://localhost", `llamas`)
+	go proxy.Listen()
+	proxy.Wait()
+
+	dir := filepath.Dir(strings.TrimPrefix(proxy.Endpoint(), "unix://"))
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Fatalf("Expected the socket's directory to only be usable by the agent's user, got %o", perm)
+	}
+
+	proxy.Close()
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("Expected the socket's directory to be removed, got %v", err)
+	}
+}
+
+func TestAPIProxyListensOnTCP(t *testing.T) {
+	proxy := NewAPIProxy("http://localhost", `llamas`)
+	proxy.ListenOnTCP("127.0.0.1:0")
+	go proxy.Listen()
+	proxy.Wait()
+	defer proxy.Close()
+
+	if !strings.HasPrefix(proxy.Endpoint(), "http://127.0.0.1:") {
+		t.Fatalf("Expected a tcp endpoint, got %s", proxy.Endpoint())
+	}
+}
@@ -1,8 +1,6 @@
 package agent
 
 import (
-	"time"
-
 	"github.com/buildkite/agent/api"
 	"github.com/buildkite/agent/logger"
 	"github.com/buildkite/agent/retry"
@@ -60,7 +58,7 @@ func (a *ArtifactBatchCreator) Create() ([]*api.Artifact, error) {
 			}
 
 			return err
-		}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+		}, retry.ExponentialBackoff(10))
 
 		// Did the batch creation eventually fail?
 		if err != nil {
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildkite/agent/api"
+)
+
+// The content encoding of artifacts that were gzipped before they were
+// uploaded
+const ArtifactContentEncodingGzip = "gzip"
+
+// Files that are already compressed, which gzip would only make bigger
+var compressedArtifactExtensions = map[string]bool{
+	".7z":   true,
+	".br":   true,
+	".bz2":  true,
+	".gif":  true,
+	".gz":   true,
+	".jpeg": true,
+	".jpg":  true,
+	".mp4":  true,
+	".png":  true,
+	".tgz":  true,
+	".webp": true,
+	".xz":   true,
+	".zip":  true,
+	".zst":  true,
+}
+
+// shouldCompressArtifact returns whether compressing an artifact is likely to
+// make it smaller
+func shouldCompressArtifact(artifact *api.Artifact) bool {
+	return !compressedArtifactExtensions[strings.ToLower(filepath.Ext(artifact.Path))]
+}
+
+// compressArtifact gzips an artifact into a temporary file, and returns a copy
+// of the artifact that's uploaded from it instead. The function that's
+// returned removes the file.
+func compressArtifact(artifact *api.Artifact) (*api.Artifact, func(), error) {
+	f, err := os.Open(artifact.AbsolutePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	tmp, err := ioutil.TempFile("", "buildkite-artifact")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	gz := gzip.NewWriter(tmp)
+	if _, err := io.Copy(gz, f); err != nil {
+		gz.Close()
+		tmp.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("Failed to compress %s (%v)", artifact.Path, err)
+	}
+
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		cleanup()
+		return nil, nil, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	compressed := *artifact
+	compressed.AbsolutePath = tmp.Name()
+
+	return &compressed, cleanup, nil
+}
+
+// decodeArtifact undoes the compression of an artifact that's being
+// downloaded
+func decodeArtifact(r io.ReadCloser, contentEncoding string) (io.ReadCloser, error) {
+	switch contentEncoding {
+	case "":
+		return r, nil
+	case ArtifactContentEncodingGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decompress (%v)", err)
+		}
+		return gzipReadCloser{Reader: gz, body: r}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown artifact content encoding %q", contentEncoding)
+}
+
+// gzipReadCloser closes the body under a gzip reader along with it
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (r gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.body.Close()
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldCompressArtifact(t *testing.T) {
+	assert.True(t, shouldCompressArtifact(&api.Artifact{Path: "log/test.log"}))
+	assert.False(t, shouldCompressArtifact(&api.Artifact{Path: "pkg/release.TAR.GZ"}))
+	assert.False(t, shouldCompressArtifact(&api.Artifact{Path: "screenshots/failure.png"}))
+}
+
+func TestOnlyUploadersThatStoreContentEncodingCompress(t *testing.T) {
+	assert.True(t, storesContentEncoding(&S3Uploader{}))
+	assert.True(t, storesContentEncoding(&GSUploader{}))
+	assert.False(t, storesContentEncoding(&FormUploader{}))
+	assert.False(t, storesContentEncoding(&FileUploader{}))
+	assert.False(t, storesContentEncoding(&ArtifactoryUploader{}))
+}
+
+func TestCompressedArtifactsAreDecompressedWhenDownloaded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifact-compression")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "llamas.log")
+	content := bytes.Repeat([]byte("llamas and alpacas\n"), 100)
+	if err := ioutil.WriteFile(source, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	artifact := &api.Artifact{Path: "llamas.log", AbsolutePath: source, ContentEncoding: ArtifactContentEncodingGzip}
+
+	compressed, cleanup, err := compressArtifact(artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	assert.Equal(t, source, artifact.AbsolutePath)
+
+	gzipped, err := ioutil.ReadFile(compressed.AbsolutePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, len(gzipped) < len(content))
+
+	// Copied to a directory, it's decompressed because of its encoding
+	artifacts := filepath.Join(dir, "artifacts")
+	if err := os.Mkdir(artifacts, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &FileUploader{}
+	assert.NoError(t, u.Setup("file://"+filepath.ToSlash(artifacts), false))
+	assert.NoError(t, u.Upload(compressed))
+
+	assert.NoError(t, Download{
+		URL:             u.URL(artifact),
+		Path:            artifact.Path,
+		Destination:     filepath.Join(dir, "from-file"),
+		ContentEncoding: artifact.ContentEncoding,
+		FileDir:         u.Dir(),
+	}.Start())
+
+	downloaded, err := ioutil.ReadFile(filepath.Join(dir, "from-file", "llamas.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, content, downloaded)
+
+	// Over HTTP with a Content-Encoding, the client decompresses it, and it
+	// isn't decompressed twice
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped)
+	}))
+	defer server.Close()
+
+	assert.NoError(t, Download{
+		URL:             server.URL + "/llamas.log",
+		Path:            artifact.Path,
+		Destination:     filepath.Join(dir, "from-http"),
+		ContentEncoding: artifact.ContentEncoding,
+	}.Start())
+
+	downloaded, err = ioutil.ReadFile(filepath.Join(dir, "from-http", "llamas.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, content, downloaded)
+}
+
+func TestDecodeArtifactWithAnUnknownEncoding(t *testing.T) {
+	_, err := decodeArtifact(ioutil.NopCloser(&bytes.Buffer{}), "br")
+	assert.Error(t, err)
+}
+
+func TestDecodeArtifactWithGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("llamas"))
+	gz.Close()
+
+	r, err := decodeArtifact(ioutil.NopCloser(&buf), ArtifactContentEncodingGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "llamas", string(b))
+}
@@ -3,6 +3,7 @@ package agent
 import (
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/buildkite/agent/api"
@@ -25,6 +26,44 @@ type ArtifactDownloader struct {
 
 	// Where we'll be downloading artifacts to
 	Destination string
+
+	// The permissions of directories created for artifacts, zero leaves them
+	// up to the umask
+	DirMode os.FileMode
+
+	// Permissions to remove from downloaded files, like a umask, which is
+	// applied even if the process's own umask is different
+	Umask *os.FileMode
+
+	// Whether to give files the permissions they had when they were uploaded
+	RestoreModes bool
+
+	// How to reach S3, or a store that's compatible with it, for artifacts
+	// uploaded to s3:// destinations
+	S3Config S3Config
+}
+
+// fileMode returns the permissions a downloaded artifact should have, or
+// zero to leave them up to the process's umask
+func (a *ArtifactDownloader) fileMode(artifact *api.Artifact) os.FileMode {
+	if a.Umask == nil && !a.RestoreModes {
+		return 0
+	}
+
+	mode := os.FileMode(0666)
+	if a.RestoreModes && artifact.FileMode != "" {
+		if recorded, err := strconv.ParseUint(artifact.FileMode, 8, 32); err == nil {
+			mode = os.FileMode(recorded) & os.ModePerm
+		} else {
+			logger.Warn("Ignoring invalid file mode %q of %s", artifact.FileMode, artifact.Path)
+		}
+	}
+
+	if a.Umask != nil {
+		mode &^= *a.Umask
+	}
+
+	return mode
 }
 
 func (a *ArtifactDownloader) Download() error {
@@ -52,60 +91,70 @@ func (a *ArtifactDownloader) Download() error {
 	} else {
 		logger.Info("Found %d artifacts. Starting to download to: %s", artifactCount, downloadDestination)
 
-		p := pool.New(pool.MaxConcurrencyLimit)
-		errors := []error{}
+		p := pool.NewErrorPool(pool.MaxConcurrencyLimit)
 
 		for _, artifact := range artifacts {
 			// Create new instance of the artifact for the goroutine
 			// See: http://golang.org/doc/effective_go.html#channels
 			artifact := artifact
 
-			p.Spawn(func() {
+			p.Spawn(func() error {
 				var err error
+				fileMode := a.fileMode(artifact)
 
 				// Handle downloading from S3 and GS
 				if strings.HasPrefix(artifact.UploadDestination, "s3://") {
 					err = S3Downloader{
-						Path:        artifact.Path,
-						Bucket:      artifact.UploadDestination,
-						Destination: downloadDestination,
-						Retries:     5,
-						DebugHTTP:   a.APIClient.DebugHTTP,
+						Path:            artifact.Path,
+						Bucket:          artifact.UploadDestination,
+						Destination:     downloadDestination,
+						Retries:         5,
+						DebugHTTP:       a.APIClient.DebugHTTP,
+						DirMode:         a.DirMode,
+						FileMode:        fileMode,
+						ContentEncoding: artifact.ContentEncoding,
+						Config:          a.S3Config,
 					}.Start()
 				} else if strings.HasPrefix(artifact.UploadDestination, "gs://") {
 					err = GSDownloader{
-						Path:        artifact.Path,
-						Bucket:      artifact.UploadDestination,
-						Destination: downloadDestination,
-						Retries:     5,
-						DebugHTTP:   a.APIClient.DebugHTTP,
+						Path:            artifact.Path,
+						Bucket:          artifact.UploadDestination,
+						Destination:     downloadDestination,
+						Retries:         5,
+						DebugHTTP:       a.APIClient.DebugHTTP,
+						DirMode:         a.DirMode,
+						FileMode:        fileMode,
+						ContentEncoding: artifact.ContentEncoding,
 					}.Start()
 				} else {
+					var fileDir string
+					if strings.HasPrefix(artifact.UploadDestination, "file://") {
+						fileDir = fileURLPath(artifact.UploadDestination)
+					}
+
 					err = Download{
-						URL:         artifact.URL,
-						Path:        artifact.Path,
-						Destination: downloadDestination,
-						Retries:     5,
-						DebugHTTP:   a.APIClient.DebugHTTP,
+						URL:             artifact.URL,
+						Path:            artifact.Path,
+						Destination:     downloadDestination,
+						Retries:         5,
+						DebugHTTP:       a.APIClient.DebugHTTP,
+						DirMode:         a.DirMode,
+						FileMode:        fileMode,
+						ContentEncoding: artifact.ContentEncoding,
+						FileDir:         fileDir,
 					}.Start()
 				}
 
-				// If the downloaded encountered an error, lock
-				// the pool, collect it, then unlock the pool
-				// again.
 				if err != nil {
 					logger.Error("Failed to download artifact: %s", err)
-
-					p.Lock()
-					errors = append(errors, err)
-					p.Unlock()
+					throttlePool(p.Pool, err)
 				}
+
+				return err
 			})
 		}
 
-		p.Wait()
-
-		if len(errors) > 0 {
+		if err := p.Wait(); err != nil {
 			logger.Fatal("There were errors with downloading some of the artifacts")
 		}
 	}
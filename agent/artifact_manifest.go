@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/buildkite/agent/api"
+)
+
+// ArtifactManifest lists a batch of artifacts with their checksums, so that
+// whoever downloads them can check they have all of them, intact, without
+// looking each one up in the API
+type ArtifactManifest struct {
+	Artifacts []ArtifactManifestEntry `json:"artifacts"`
+}
+
+// ArtifactManifestEntry is a single artifact in a manifest
+type ArtifactManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// NewArtifactManifest creates the manifest for artifacts, sorted by path
+func NewArtifactManifest(artifacts []*api.Artifact) *ArtifactManifest {
+	manifest := &ArtifactManifest{Artifacts: make([]ArtifactManifestEntry, 0, len(artifacts))}
+
+	for _, artifact := range artifacts {
+		manifest.Artifacts = append(manifest.Artifacts, ArtifactManifestEntry{
+			Path:   filepath.ToSlash(artifact.Path),
+			Size:   artifact.FileSize,
+			Sha256: artifact.Sha256Sum,
+		})
+	}
+
+	sort.Slice(manifest.Artifacts, func(i, j int) bool {
+		return manifest.Artifacts[i].Path < manifest.Artifacts[j].Path
+	})
+
+	return manifest
+}
+
+// buildManifest writes the manifest for artifacts to a temporary file, and
+// returns it as an artifact to upload alongside them. The caller removes the
+// file once it's uploaded.
+func (a *ArtifactUploader) buildManifest(artifacts []*api.Artifact) (*api.Artifact, error) {
+	data, err := json.MarshalIndent(NewArtifactManifest(artifacts), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.TempFile("", "artifact-manifest")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	artifact, err := a.build(a.Manifest, file.Name(), a.Manifest)
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return artifact, nil
+}
@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewArtifactManifest(t *testing.T) {
+	manifest := NewArtifactManifest([]*api.Artifact{
+		{Path: filepath.Join("dist", "b.js"), FileSize: 2, Sha256Sum: "bbb"},
+		{Path: filepath.Join("dist", "a.js"), FileSize: 1, Sha256Sum: "aaa"},
+	})
+
+	assert.Equal(t, []ArtifactManifestEntry{
+		{Path: "dist/a.js", Size: 1, Sha256: "aaa"},
+		{Path: "dist/b.js", Size: 2, Sha256: "bbb"},
+	}, manifest.Artifacts)
+}
+
+func TestBuildManifest(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	uploader := ArtifactUploader{
+		Paths:    filepath.Join("test", "fixtures", "artifacts", "gifs", "*.gif"),
+		Manifest: "gifs.manifest.json",
+	}
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := uploader.buildManifest(artifacts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(manifest.AbsolutePath)
+
+	assert.Equal(t, "gifs.manifest.json", manifest.Path)
+
+	data, err := ioutil.ReadFile(manifest.AbsolutePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed ArtifactManifest
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []ArtifactManifestEntry{{
+		Path:   "test/fixtures/artifacts/gifs/Smile.gif",
+		Size:   artifacts[0].FileSize,
+		Sha256: artifacts[0].Sha256Sum,
+	}}, parsed.Artifacts)
+	assert.Len(t, artifacts[0].Sha256Sum, 64)
+}
@@ -2,17 +2,22 @@ package agent
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/events"
 	"github.com/buildkite/agent/logger"
 	"github.com/buildkite/agent/pool"
 	"github.com/buildkite/agent/retry"
@@ -35,6 +40,38 @@ type ArtifactUploader struct {
 
 	// Where we'll be uploading artifacts
 	Destination string
+
+	// A command that uploads the artifacts instead, see ExecUploader
+	UploaderCommand string
+
+	// The URL of the Artifactory instance that rt:// destinations are in
+	ArtifactoryURL string
+
+	// Where s3:// destinations are, if they aren't in AWS
+	S3Config S3Config
+
+	// Whether to gzip artifacts before they're uploaded. They're
+	// decompressed again when they're downloaded.
+	Compress bool
+
+	// Tags that are added to every artifact
+	Tags map[string]string
+
+	// Where artifact uploaded events are sent
+	Events *events.Bus
+
+	// The most files and total bytes the paths can match, so that a runaway
+	// glob fails before anything is uploaded. 0 means there's no limit.
+	MaxFiles int
+	MaxBytes int64
+
+	// The most bytes all the uploads in a job can add up to, and the file
+	// that keeps count of how many have been uploaded so far
+	MaxJobBytes  int64
+	JobBytesFile string
+
+	// The path to upload a manifest of the artifacts as, if any
+	Manifest string
 }
 
 func (a *ArtifactUploader) Upload() error {
@@ -49,25 +86,44 @@ func (a *ArtifactUploader) Upload() error {
 	} else {
 		logger.Info("Found %d files that match \"%s\"", len(artifacts), a.Paths)
 
+		if a.Manifest != "" {
+			manifest, err := a.buildManifest(artifacts)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(manifest.AbsolutePath)
+
+			artifacts = append(artifacts, manifest)
+		}
+
 		err := a.upload(artifacts)
 		if err != nil {
 			return err
 		}
+
+		if err := a.addJobBytes(artifacts); err != nil {
+			logger.Warn("Failed to record how many bytes the job has uploaded: %v", err)
+		}
 	}
 
 	return nil
 }
 
-func isDir(path string) bool {
-	fi, err := os.Stat(path)
+func (a *ArtifactUploader) Collect() (artifacts []*api.Artifact, err error) {
+	wd, err := os.Getwd()
 	if err != nil {
-		return false
+		return nil, err
 	}
-	return fi.IsDir()
-}
 
-func (a *ArtifactUploader) Collect() (artifacts []*api.Artifact, err error) {
-	wd, err := os.Getwd()
+	var fileCount int
+	var totalBytes int64
+	var matched []matchedArtifact
+
+	// Overlapping paths can match the same file more than once, but it only
+	// counts towards the limits once
+	counted := map[string]bool{}
+
+	jobBytes, err := a.jobBytes()
 	if err != nil {
 		return nil, err
 	}
@@ -80,14 +136,12 @@ func (a *ArtifactUploader) Collect() (artifacts []*api.Artifact, err error) {
 
 		logger.Debug("Searching for %s", globPath)
 
-		// Resolve the globs (with * and ** in them), if it's a non-globbed path and doesn't exists
-		// then we will get the ErrNotExist that is handled below
-		files, err := zglob.Glob(globPath)
-		if err == os.ErrNotExist {
+		files, err := globFiles(wd, globPath)
+		if err != nil {
+			return nil, err
+		} else if len(files) == 0 {
 			logger.Info("File not found: %s", globPath)
 			continue
-		} else if err != nil {
-			return nil, err
 		}
 
 		// Process each glob match into an api.Artifact
@@ -98,25 +152,50 @@ func (a *ArtifactUploader) Collect() (artifacts []*api.Artifact, err error) {
 			}
 
 			// Ignore directories, we only want files
-			if isDir(absolutePath) {
+			fileInfo, err := os.Stat(absolutePath)
+			if err == nil && fileInfo.IsDir() {
 				logger.Debug("Skipping directory %s", file)
 				continue
 			}
 
+			// Check the limits before checksumming, which reads every file
+			if err == nil && !counted[absolutePath] {
+				counted[absolutePath] = true
+				fileCount++
+				totalBytes += fileInfo.Size()
+				matched = append(matched, matchedArtifact{file, fileInfo.Size()})
+
+				if a.MaxFiles > 0 && fileCount > a.MaxFiles {
+					return nil, fmt.Errorf("The artifact paths matched more than %d files, the most that can be uploaded at once, when %q was searched. "+
+						"Make the paths more specific so they don't include directories like node_modules or .git, or raise the limit with --max-files.", a.MaxFiles, globPath)
+				}
+
+				if a.MaxBytes > 0 && totalBytes > a.MaxBytes {
+					return nil, fmt.Errorf("The artifact paths matched more than %d bytes, the most that can be uploaded at once, when %q was searched. "+
+						"Make the paths more specific so they don't include directories like node_modules or .git, or raise the limit with --max-bytes.%s", a.MaxBytes, globPath, largestArtifacts(matched))
+				}
+
+				if a.MaxJobBytes > 0 && jobBytes+totalBytes > a.MaxJobBytes {
+					return nil, fmt.Errorf("The artifact paths matched more than the %s this job can upload in total (it's already uploaded %s) when %q was searched. "+
+						"Make the paths more specific, or ask for the limit on the agent to be raised.%s", formatBytes(a.MaxJobBytes), formatBytes(jobBytes), globPath, largestArtifacts(matched))
+				}
+			}
+
 			// If a glob is absolute, we need to make it relative to the root so that
 			// it can be combined with the download destination to make a valid path.
 			// This is possibly weird and crazy, this logic dates back to
 			// https://github.com/buildkite/agent/commit/8ae46d975aa60d1ae0e2cc0bff7a43d3bf960935
 			// from 2014, so I'm replicating it here to avoid breaking things
+			relativeTo := wd
 			if filepath.IsAbs(globPath) {
 				if runtime.GOOS == "windows" {
-					wd = filepath.VolumeName(absolutePath) + "/"
+					relativeTo = filepath.VolumeName(absolutePath) + "/"
 				} else {
-					wd = "/"
+					relativeTo = "/"
 				}
 			}
 
-			path, err := filepath.Rel(wd, absolutePath)
+			path, err := filepath.Rel(relativeTo, absolutePath)
 			if err != nil {
 				return nil, err
 			}
@@ -134,6 +213,193 @@ func (a *ArtifactUploader) Collect() (artifacts []*api.Artifact, err error) {
 	return artifacts, nil
 }
 
+// matchedArtifact is a file that's going to be uploaded, for explaining why
+// a limit was hit
+type matchedArtifact struct {
+	path string
+	size int64
+}
+
+// How many of the largest artifacts are listed when a limit is hit
+const largestArtifactsShown = 5
+
+// largestArtifacts lists the largest of the files, which are usually why a
+// limit was hit
+func largestArtifacts(matched []matchedArtifact) string {
+	sorted := make([]matchedArtifact, len(matched))
+	copy(sorted, matched)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].size > sorted[j].size
+	})
+
+	if len(sorted) > largestArtifactsShown {
+		sorted = sorted[:largestArtifactsShown]
+	}
+
+	s := "\n\nThe largest files are:\n"
+	for _, m := range sorted {
+		s += fmt.Sprintf("  %10s  %s\n", formatBytes(m.size), m.path)
+	}
+
+	return s
+}
+
+// formatBytes returns a number of bytes in the largest unit that fits
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// jobBytes returns how many bytes have already been uploaded by the job
+func (a *ArtifactUploader) jobBytes() (int64, error) {
+	if a.JobBytesFile == "" {
+		return 0, nil
+	}
+
+	b, err := ioutil.ReadFile(a.JobBytesFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// addJobBytes adds the size of the artifacts to what the job has uploaded
+func (a *ArtifactUploader) addJobBytes(artifacts []*api.Artifact) error {
+	if a.JobBytesFile == "" {
+		return nil
+	}
+
+	total, err := a.jobBytes()
+	if err != nil {
+		return err
+	}
+
+	for _, artifact := range artifacts {
+		total += artifact.FileSize
+	}
+
+	return ioutil.WriteFile(a.JobBytesFile, []byte(strconv.FormatInt(total, 10)), 0600)
+}
+
+// globFiles returns the files that match a glob path, after expanding any
+// braces in it
+func globFiles(wd string, globPath string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+
+	for _, pattern := range expandBraces(globPath) {
+		// Resolve the globs (with * and ** in them), if it's a non-globbed path and doesn't exists
+		// then we will get the ErrNotExist that is skipped here
+		matches, err := zglob.Glob(globPattern(wd, pattern))
+		if err == os.ErrNotExist {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Alternatives can overlap, like *.{js,j*}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// expandBraces expands {a,b} in a glob path into a path for each of the
+// alternatives, the way a shell does. Braces can be nested, and ones without
+// a comma in them, like {a}, are left as they are.
+func expandBraces(pattern string) []string {
+	for start := 0; start < len(pattern); start++ {
+		if pattern[start] != '{' {
+			continue
+		}
+
+		alternatives, end := braceAlternatives(pattern, start)
+		if alternatives == nil {
+			continue
+		}
+
+		var expanded []string
+		for _, alternative := range alternatives {
+			expanded = append(expanded, expandBraces(pattern[:start]+alternative+pattern[end+1:])...)
+		}
+
+		return expanded
+	}
+
+	return []string{pattern}
+}
+
+// braceAlternatives returns the comma separated alternatives in the braces
+// that open at start, and the index of the closing brace. The alternatives
+// are nil if the braces aren't closed or don't have a comma in them.
+func braceAlternatives(pattern string, start int) ([]string, int) {
+	var alternatives []string
+	depth := 0
+	last := start + 1
+
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, pattern[last:i])
+				last = i + 1
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				if alternatives == nil {
+					return nil, i
+				}
+				return append(alternatives, pattern[last:i]), i
+			}
+		}
+	}
+
+	return nil, -1
+}
+
+// globPattern returns the pattern to glob for a path. Windows only allows
+// paths longer than 260 characters when they're absolute, which is when Go
+// gives them a \\?\ prefix, so on Windows relative globs are made absolute
+// to find files in deep trees like node_modules.
+func globPattern(wd string, globPath string) string {
+	if runtime.GOOS != "windows" || filepath.IsAbs(globPath) {
+		return globPath
+	}
+
+	// Globs from the home directory or an env var are expanded by zglob
+	if strings.HasPrefix(globPath, "~") || strings.HasPrefix(globPath, "$") {
+		return globPath
+	}
+
+	return filepath.Join(wd, globPath)
+}
+
 func (a *ArtifactUploader) build(path string, absolutePath string, globPath string) (*api.Artifact, error) {
 	// Temporarily open the file to get it's size
 	file, err := os.Open(absolutePath)
@@ -148,10 +414,10 @@ func (a *ArtifactUploader) build(path string, absolutePath string, globPath stri
 		return nil, err
 	}
 
-	// Generate a sha1 checksum for the file
-	hash := sha1.New()
-	io.Copy(hash, file)
-	checksum := fmt.Sprintf("%x", hash.Sum(nil))
+	// Generate sha1 and sha256 checksums for the file
+	sha1Hash := sha1.New()
+	sha256Hash := sha256.New()
+	io.Copy(io.MultiWriter(sha1Hash, sha256Hash), file)
 
 	// Create our new artifact data structure
 	artifact := &api.Artifact{
@@ -159,23 +425,44 @@ func (a *ArtifactUploader) build(path string, absolutePath string, globPath stri
 		AbsolutePath: absolutePath,
 		GlobPath:     globPath,
 		FileSize:     fileInfo.Size(),
-		Sha1Sum:      checksum,
+		Sha1Sum:      fmt.Sprintf("%x", sha1Hash.Sum(nil)),
+		Sha256Sum:    fmt.Sprintf("%x", sha256Hash.Sum(nil)),
+		FileMode:     fmt.Sprintf("%04o", fileInfo.Mode().Perm()),
+		Tags:         a.Tags,
 	}
 
 	return artifact, nil
 }
 
+// storesContentEncoding returns whether the uploader marks the objects it
+// stores with their Content-Encoding, so that anything else downloading a
+// compressed artifact knows to decompress it
+func storesContentEncoding(uploader Uploader) bool {
+	switch uploader.(type) {
+	case *S3Uploader, *GSUploader:
+		return true
+	default:
+		return false
+	}
+}
+
 func (a *ArtifactUploader) upload(artifacts []*api.Artifact) error {
 	var uploader Uploader
 
 	// Determine what uploader to use
-	if a.Destination != "" {
+	if a.UploaderCommand != "" {
+		uploader = &ExecUploader{Command: a.UploaderCommand}
+	} else if a.Destination != "" {
 		if strings.HasPrefix(a.Destination, "s3://") {
-			uploader = new(S3Uploader)
+			uploader = &S3Uploader{Config: a.S3Config}
 		} else if strings.HasPrefix(a.Destination, "gs://") {
 			uploader = new(GSUploader)
+		} else if strings.HasPrefix(a.Destination, "rt://") {
+			uploader = &ArtifactoryUploader{ServerURL: a.ArtifactoryURL}
+		} else if strings.HasPrefix(a.Destination, "file://") {
+			uploader = new(FileUploader)
 		} else {
-			return errors.New(fmt.Sprintf("Invalid upload destination: '%v'. Only s3:// and gs:// upload destinations are allowed. Did you forget to surround your artifact upload pattern in double quotes?", a.Destination))
+			return errors.New(fmt.Sprintf("Invalid upload destination: '%v'. Only s3://, gs://, rt:// and file:// upload destinations are allowed, unless there's an --uploader command for it. Did you forget to surround your artifact upload pattern in double quotes?", a.Destination))
 		}
 	} else {
 		uploader = new(FormUploader)
@@ -187,6 +474,22 @@ func (a *ArtifactUploader) upload(artifacts []*api.Artifact) error {
 		return err
 	}
 
+	// Compressed artifacts are recorded as such, so they're decompressed
+	// when they're downloaded
+	compress := a.Compress
+	if compress && !storesContentEncoding(uploader) {
+		logger.Warn("Artifacts can only be compressed when they're uploaded to S3 or Google Cloud Storage, so they'll be uploaded as they are")
+		compress = false
+	}
+
+	if compress {
+		for _, artifact := range artifacts {
+			if shouldCompressArtifact(artifact) {
+				artifact.ContentEncoding = ArtifactContentEncodingGzip
+			}
+		}
+	}
+
 	// Set the URL's of the artifacts based on the uploader
 	for _, artifact := range artifacts {
 		artifact.URL = uploader.URL(artifact)
@@ -205,9 +508,11 @@ func (a *ArtifactUploader) upload(artifacts []*api.Artifact) error {
 	}
 
 	// Prepare a concurrency pool to upload the artifacts
-	p := pool.New(pool.MaxConcurrencyLimit)
-	errors := []error{}
-	var errorsMutex sync.Mutex
+	p := pool.NewErrorPool(pool.MaxConcurrencyLimit)
+
+	// Only the state uploader goroutine touches this, and it's only read
+	// after that goroutine has finished
+	var stateUploadErrors []error
 
 	// Create a wait group so we can make sure the uploader waits for all
 	// the artifact states to upload before finishing
@@ -252,17 +557,11 @@ func (a *ArtifactUploader) upload(artifacts []*api.Artifact) error {
 					}
 
 					return err
-				}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+				}, retry.ExponentialBackoff(10))
 
 				if err != nil {
 					logger.Error("Error uploading artifact states: %s", err)
-
-					// Track the error that was raised. We need to
-					// aquire a lock since we mutate the errors
-					// slice in mutliple routines.
-					errorsMutex.Lock()
-					errors = append(errors, err)
-					errorsMutex.Unlock()
+					stateUploadErrors = append(stateUploadErrors, err)
 				}
 
 				logger.Debug("Uploaded %d artfact states (%d/%d)", len(statesToUpload), artifactStatesUploaded, len(artifacts))
@@ -280,21 +579,38 @@ func (a *ArtifactUploader) upload(artifacts []*api.Artifact) error {
 		// See: http://golang.org/doc/effective_go.html#channels
 		artifact := artifact
 
-		p.Spawn(func() {
+		p.Spawn(func() error {
 			// Show a nice message that we're starting to upload the file
 			logger.Info("Uploading artifact %s %s (%d bytes)", artifact.ID, artifact.Path, artifact.FileSize)
 
+			// Compressed artifacts are uploaded from a temporary
+			// file with the compressed contents
+			upload := artifact
+			if artifact.ContentEncoding == ArtifactContentEncodingGzip {
+				compressed, cleanup, err := compressArtifact(artifact)
+				if err != nil {
+					logger.Error("Error compressing artifact \"%s\": %s", artifact.Path, err)
+					artifactStatesMutex.Lock()
+					artifactStates[artifact.ID] = "error"
+					artifactStatesMutex.Unlock()
+					return err
+				}
+				defer cleanup()
+				upload = compressed
+			}
+
 			// Upload the artifact and then set the state depending
 			// on whether or not it passed. We'll retry the upload
 			// a couple of times before giving up.
-			err = retry.Do(func(s *retry.Stats) error {
-				err := uploader.Upload(artifact)
+			err := retry.Do(func(s *retry.Stats) error {
+				err := uploader.Upload(upload)
 				if err != nil {
 					logger.Warn("%s (%s)", err, s)
+					throttlePool(p.Pool, err)
 				}
 
 				return err
-			}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+			}, retry.ExponentialBackoff(10))
 
 			var state string
 
@@ -302,16 +618,20 @@ func (a *ArtifactUploader) upload(artifacts []*api.Artifact) error {
 			if err != nil {
 				logger.Error("Error uploading artifact \"%s\": %s", artifact.Path, err)
 
-				// Track the error that was raised. We need to
-				// aquire a lock since we mutate the errors
-				// slice in mutliple routines.
-				errorsMutex.Lock()
-				errors = append(errors, err)
-				errorsMutex.Unlock()
-
 				state = "error"
 			} else {
 				state = "finished"
+
+				a.Events.Emit(events.Event{
+					Type:  events.ArtifactUploaded,
+					JobID: a.JobID,
+					Data: map[string]string{
+						"id":   artifact.ID,
+						"path": artifact.Path,
+						"url":  artifact.URL,
+						"size": fmt.Sprintf("%d", artifact.FileSize),
+					},
+				})
 			}
 
 			// Since we mutate the artifactStates variable in
@@ -320,16 +640,18 @@ func (a *ArtifactUploader) upload(artifacts []*api.Artifact) error {
 			artifactStatesMutex.Lock()
 			artifactStates[artifact.ID] = state
 			artifactStatesMutex.Unlock()
+
+			return err
 		})
 	}
 
 	// Wait for the pool to finish
-	p.Wait()
+	uploadErr := p.Wait()
 
 	// Wait for the statuses to finish uploading
 	stateUploaderWaitGroup.Wait()
 
-	if len(errors) > 0 {
+	if uploadErr != nil || len(stateUploadErrors) > 0 {
 		logger.Fatal("There were errors with uploading some of the artifacts")
 	}
 
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -146,3 +147,159 @@ func TestCollectWithSomeGlobsThatDontMatchAnything(t *testing.T) {
 		t.Fatalf("Expected to match 3 artifacts, found %d", len(artifacts))
 	}
 }
+
+func TestCollectWithLongPaths(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	dir, err := ioutil.TempDir("", "artifact-long-paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Deeper than the 260 characters Windows allows for relative paths
+	nested := filepath.Join(dir, "node_modules")
+	for i := 0; i < 12; i++ {
+		nested = filepath.Join(nested, fmt.Sprintf("some-dependency-%02d", i), "node_modules")
+	}
+	if err := os.MkdirAll(nested, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(nested, "llamas.log"), []byte("llamas"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	uploader := ArtifactUploader{Paths: filepath.Join("node_modules", "**", "*.log")}
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(artifacts) != 1 {
+		t.Fatalf("Expected 1 artifact, got %d", len(artifacts))
+	}
+
+	rel, _ := filepath.Rel(dir, filepath.Join(nested, "llamas.log"))
+	assert.Equal(t, rel, artifacts[0].Path)
+	assert.Equal(t, int64(6), artifacts[0].FileSize)
+}
+
+func TestCollectWithBraces(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	globPath := filepath.Join("test", "fixtures", "artifacts", "**", "*.{jpg,gif,j*}")
+	uploader := ArtifactUploader{Paths: globPath}
+
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 4, len(artifacts))
+	for _, name := range []string{"Mr Freeze.jpg", "Commando.jpg", "The Terminator.jpg", "Smile.gif"} {
+		a := findArtifact(artifacts, name)
+		if a == nil {
+			t.Fatalf("Missing %s", name)
+		}
+		assert.Equal(t, globPath, a.GlobPath)
+	}
+}
+
+func TestExpandBraces(t *testing.T) {
+	for _, tc := range []struct {
+		pattern  string
+		expected []string
+	}{
+		{"dist/**/*.js", []string{"dist/**/*.js"}},
+		{"dist/**/*.{js,map,css}", []string{"dist/**/*.js", "dist/**/*.map", "dist/**/*.css"}},
+		{"{app,lib}/*.{js,css}", []string{"app/*.js", "app/*.css", "lib/*.js", "lib/*.css"}},
+		{"log/{a,b{1,2}}.log", []string{"log/a.log", "log/b1.log", "log/b2.log"}},
+		{"log/{,old/}*.log", []string{"log/*.log", "log/old/*.log"}},
+		{"log/{a}/{b,c}", []string{"log/{a}/b", "log/{a}/c"}},
+		{"log/{a,b", []string{"log/{a,b"}},
+		{"log/a}", []string{"log/a}"}},
+	} {
+		assert.Equal(t, tc.expected, expandBraces(tc.pattern), tc.pattern)
+	}
+}
+
+func TestCollectWithLimits(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	paths := filepath.Join("test", "fixtures", "artifacts", "**", "*.jpg")
+
+	uploader := ArtifactUploader{Paths: paths, MaxFiles: 2}
+	if _, err := uploader.Collect(); err == nil || !strings.Contains(err.Error(), "more than 2 files") {
+		t.Fatalf("Expected the file limit to be hit, got %v", err)
+	}
+
+	uploader = ArtifactUploader{Paths: paths, MaxBytes: 500000}
+	if _, err := uploader.Collect(); err == nil || !strings.Contains(err.Error(), "more than 500000 bytes") {
+		t.Fatalf("Expected the byte limit to be hit, got %v", err)
+	}
+
+	uploader = ArtifactUploader{Paths: paths, MaxFiles: 3, MaxBytes: 1000000}
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(artifacts))
+
+	// Matching the same files twice doesn't count them twice
+	uploader = ArtifactUploader{Paths: paths + ArtifactPathDelimiter + paths, MaxFiles: 3, MaxBytes: 1000000}
+	if _, err := uploader.Collect(); err != nil {
+		t.Fatalf("Expected files matched twice to be counted once, got %v", err)
+	}
+}
+
+func TestCollectWithJobLimit(t *testing.T) {
+	wd, _ := os.Getwd()
+	root := filepath.Join(wd, "..")
+	os.Chdir(root)
+	defer os.Chdir(wd)
+
+	bytesFile, err := ioutil.TempFile("", "artifact-bytes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytesFile.Close()
+	defer os.Remove(bytesFile.Name())
+
+	paths := filepath.Join("test", "fixtures", "artifacts", "**", "*.jpg")
+
+	uploader := ArtifactUploader{Paths: paths, MaxJobBytes: 1000000, JobBytesFile: bytesFile.Name()}
+	artifacts, err := uploader.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same files again would take the job over its limit
+	if err := uploader.addJobBytes(artifacts); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = uploader.Collect()
+	if err == nil || !strings.Contains(err.Error(), "this job can upload in total") {
+		t.Fatalf("Expected the job limit to be hit, got %v", err)
+	}
+
+	// The largest files are listed, biggest first
+	assert.Contains(t, err.Error(), "The largest files are:")
+	assert.Contains(t, err.Error(), "KB  "+filepath.Join("test", "fixtures", "artifacts"))
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", formatBytes(512))
+	assert.Equal(t, "1.5 KB", formatBytes(1536))
+	assert.Equal(t, "2.0 GB", formatBytes(2*1024*1024*1024))
+}
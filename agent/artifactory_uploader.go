@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/mime"
+)
+
+// ArtifactoryUploader uploads artifacts to a repository in JFrog Artifactory,
+// given as rt://repository/path. The artifacts are tagged with the build and
+// job they came from as Artifactory properties.
+//
+// It authenticates with $BUILDKITE_ARTIFACTORY_ACCESS_TOKEN, or
+// $BUILDKITE_ARTIFACTORY_API_KEY, or $BUILDKITE_ARTIFACTORY_USER and
+// $BUILDKITE_ARTIFACTORY_PASSWORD.
+type ArtifactoryUploader struct {
+	// The URL of the Artifactory instance, like
+	// https://example.jfrog.io/artifactory
+	ServerURL string
+
+	// The destination which includes the repository and the path.
+	// rt://my-repository/foo/bar
+	Destination string
+
+	// Whether or not HTTP calls shoud be debugged
+	DebugHTTP bool
+
+	client *http.Client
+}
+
+func (u *ArtifactoryUploader) Setup(destination string, debugHTTP bool) error {
+	u.Destination = destination
+	u.DebugHTTP = debugHTTP
+	u.client = &http.Client{}
+
+	if u.ServerURL == "" {
+		u.ServerURL = os.Getenv("BUILDKITE_ARTIFACTORY_URL")
+	}
+	if u.ServerURL == "" {
+		return errors.New("Uploading to Artifactory needs its URL, from --artifactory-url or $BUILDKITE_ARTIFACTORY_URL")
+	}
+
+	if u.RepositoryName() == "" {
+		return fmt.Errorf("Invalid Artifactory destination: '%v', expected rt://repository/path", destination)
+	}
+
+	return nil
+}
+
+func (u *ArtifactoryUploader) URL(artifact *api.Artifact) string {
+	segments := strings.Split(u.RepositoryName()+"/"+u.artifactPath(artifact), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.TrimSuffix(u.ServerURL, "/") + "/" + strings.Join(segments, "/")
+}
+
+func (u *ArtifactoryUploader) Upload(artifact *api.Artifact) error {
+	file, err := os.Open(artifact.AbsolutePath)
+	if err != nil {
+		return fmt.Errorf("Failed to open file %q (%v)", artifact.AbsolutePath, err)
+	}
+	defer file.Close()
+
+	// Properties are set with matrix parameters on the path
+	request, err := http.NewRequest("PUT", u.URL(artifact)+u.properties(), file)
+	if err != nil {
+		return err
+	}
+
+	// The checksums are of the uncompressed file, so Artifactory can only
+	// check them if it wasn't compressed
+	if artifact.ContentEncoding == "" {
+		request.ContentLength = artifact.FileSize
+		request.Header.Set("X-Checksum-Sha1", artifact.Sha1Sum)
+		if artifact.Sha256Sum != "" {
+			request.Header.Set("X-Checksum-Sha256", artifact.Sha256Sum)
+		}
+	} else if info, err := file.Stat(); err == nil {
+		request.ContentLength = info.Size()
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(artifact.Path)); contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	u.authenticate(request)
+
+	logger.Debug("Uploading \"%s\" to Artifactory repository \"%s\"", u.artifactPath(artifact), u.RepositoryName())
+
+	response, err := u.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if u.DebugHTTP {
+		responseDump, err := httputil.DumpResponse(response, true)
+		logger.Debug("\nERR: %s\n%s", err, string(responseDump))
+	}
+
+	if response.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Failed to PUT file \"%s\" (%s: %s)", u.artifactPath(artifact), response.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// properties returns the matrix parameters that tag an artifact with the
+// build and job it came from
+func (u *ArtifactoryUploader) properties() string {
+	var properties string
+
+	for _, property := range []struct{ name, env string }{
+		{"buildkite.build_id", "BUILDKITE_BUILD_ID"},
+		{"buildkite.job_id", "BUILDKITE_JOB_ID"},
+	} {
+		if value := os.Getenv(property.env); value != "" {
+			properties += ";" + property.name + "=" + url.PathEscape(value)
+		}
+	}
+
+	return properties
+}
+
+func (u *ArtifactoryUploader) authenticate(request *http.Request) {
+	if token := os.Getenv("BUILDKITE_ARTIFACTORY_ACCESS_TOKEN"); token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	} else if apiKey := os.Getenv("BUILDKITE_ARTIFACTORY_API_KEY"); apiKey != "" {
+		request.Header.Set("X-JFrog-Art-Api", apiKey)
+	} else if user := os.Getenv("BUILDKITE_ARTIFACTORY_USER"); user != "" {
+		request.SetBasicAuth(user, os.Getenv("BUILDKITE_ARTIFACTORY_PASSWORD"))
+	}
+}
+
+func (u *ArtifactoryUploader) artifactPath(artifact *api.Artifact) string {
+	if path := u.RepositoryPath(); path != "" {
+		return path + "/" + artifact.Path
+	}
+
+	return artifact.Path
+}
+
+func (u *ArtifactoryUploader) RepositoryPath() string {
+	return strings.Trim(strings.Join(u.destinationParts()[1:], "/"), "/")
+}
+
+func (u *ArtifactoryUploader) RepositoryName() string {
+	return u.destinationParts()[0]
+}
+
+func (u *ArtifactoryUploader) destinationParts() []string {
+	trimmed := strings.TrimPrefix(u.Destination, "rt://")
+
+	return strings.Split(trimmed, "/")
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifactoryUploaderRepository(t *testing.T) {
+	u := ArtifactoryUploader{Destination: "rt://my-repository/foo/bar"}
+	assert.Equal(t, "my-repository", u.RepositoryName())
+	assert.Equal(t, "foo/bar", u.RepositoryPath())
+
+	u.Destination = "rt://my-repository"
+	assert.Equal(t, "my-repository", u.RepositoryName())
+	assert.Equal(t, "", u.RepositoryPath())
+}
+
+func TestArtifactoryUploaderURL(t *testing.T) {
+	u := ArtifactoryUploader{
+		ServerURL:   "https://example.jfrog.io/artifactory/",
+		Destination: "rt://my-repository/builds",
+	}
+
+	artifact := &api.Artifact{Path: "logs/llamas and alpacas.log"}
+	assert.Equal(t, "https://example.jfrog.io/artifactory/my-repository/builds/logs/llamas%20and%20alpacas.log", u.URL(artifact))
+}
+
+func TestArtifactoryUploaderUpload(t *testing.T) {
+	os.Setenv("BUILDKITE_ARTIFACTORY_ACCESS_TOKEN", "llamas")
+	os.Setenv("BUILDKITE_BUILD_ID", "build-1")
+	os.Setenv("BUILDKITE_JOB_ID", "job-2")
+	defer os.Unsetenv("BUILDKITE_ARTIFACTORY_ACCESS_TOKEN")
+	defer os.Unsetenv("BUILDKITE_BUILD_ID")
+	defer os.Unsetenv("BUILDKITE_JOB_ID")
+
+	var path, authorization, checksum, body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		path, authorization, checksum, body = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("X-Checksum-Sha1"), string(b)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "artifactory-uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "llamas.txt")
+	if err := ioutil.WriteFile(file, []byte("llamas"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &ArtifactoryUploader{ServerURL: server.URL}
+	assert.NoError(t, u.Setup("rt://my-repository/builds", false))
+
+	artifact := &api.Artifact{Path: "llamas.txt", AbsolutePath: file, FileSize: 6, Sha1Sum: "abc"}
+	assert.NoError(t, u.Upload(artifact))
+
+	assert.Equal(t, "/my-repository/builds/llamas.txt;buildkite.build_id=build-1;buildkite.job_id=job-2", path)
+	assert.Equal(t, "Bearer llamas", authorization)
+	assert.Equal(t, "abc", checksum)
+	assert.Equal(t, "llamas", body)
+}
+
+func TestArtifactoryUploaderNeedsAURL(t *testing.T) {
+	os.Unsetenv("BUILDKITE_ARTIFACTORY_URL")
+
+	u := &ArtifactoryUploader{}
+	assert.Error(t, u.Setup("rt://my-repository", false))
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/buildkite/agent/api"
+)
+
+// How long to wait for anything still holding the audit log pipe open once
+// the job has finished, like a bootstrap-script that let something it ran
+// inherit it
+var auditLogDrainTimeout = 10 * time.Second
+
+// The path of the job's audit log on the agent's host
+func (r *JobRunner) auditLogPath() string {
+	return filepath.Join(r.AgentConfiguration.AuditLogPath, r.Job.ID+".jsonl")
+}
+
+// startAuditLog opens the job's audit log, and a pipe that the bootstrap
+// writes its entries to. Only the agent has the file open, so the job can add
+// entries but can't change or remove the ones that are already there.
+func (r *JobRunner) startAuditLog() error {
+	file, err := os.OpenFile(r.auditLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to open the job's audit log: %v", err)
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(file, reader)
+		reader.Close()
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		done <- err
+	}()
+
+	r.auditLogReader = reader
+	r.auditLogWriter = writer
+	r.auditLogDone = done
+
+	return nil
+}
+
+// finishAuditLog waits for the rest of the bootstrap's entries to be written
+// to the job's audit log, then uploads it if the agent's been asked to
+func (r *JobRunner) finishAuditLog() error {
+	r.auditLogWriter.Close()
+
+	var err error
+	select {
+	case err = <-r.auditLogDone:
+	case <-time.After(auditLogDrainTimeout):
+		r.logger.Warn("[JobRunner] Gave up waiting for the rest of the job's audit log")
+		r.auditLogReader.Close()
+		err = <-r.auditLogDone
+	}
+
+	if err != nil || !r.AgentConfiguration.AuditLogUpload {
+		return err
+	}
+
+	uploader := &ArtifactUploader{APIClient: r.APIClient, JobID: r.Job.ID}
+
+	name := fmt.Sprintf("audit-%s.jsonl", r.Job.ID)
+	artifact, err := uploader.build(name, r.auditLogPath(), name)
+	if err != nil {
+		return err
+	}
+
+	return uploader.upload([]*api.Artifact{artifact})
+}
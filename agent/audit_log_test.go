@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLogIsWrittenByTheAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &JobRunner{
+		Agent:              &api.Agent{},
+		Job:                &api.Job{ID: "my-job"},
+		AgentConfiguration: &AgentConfiguration{AuditLogPath: dir},
+	}
+
+	if err := r.startAuditLog(); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := r.createEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, env, "BUILDKITE_AUDIT_LOG_FD=3")
+	for _, e := range env {
+		assert.False(t, strings.HasPrefix(e, "BUILDKITE_AUDIT_LOG="), e)
+	}
+
+	// The bootstrap only gets the pipe, and the agent appends to the file
+	if _, err := r.auditLogWriter.WriteString("{\"name\":\"pre-command\"}\n"); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, r.finishAuditLog())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "my-job.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "{\"name\":\"pre-command\"}\n", string(data))
+}
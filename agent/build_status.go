@@ -0,0 +1,44 @@
+package agent
+
+import "github.com/buildkite/agent/api"
+
+// BuildStatus is a summary of a build that later steps can use to decide
+// what to do, e.g. skipping a deploy if anything has failed
+type BuildStatus struct {
+	ID           string          `json:"id"`
+	Number       int             `json:"number"`
+	State        string          `json:"state"`
+	BlockedSteps []*api.BuildJob `json:"blocked_steps"`
+	FailedJobs   []*api.BuildJob `json:"failed_jobs"`
+}
+
+// NewBuildStatus summarises the build's jobs into the steps that are blocked
+// waiting on someone to unblock them, and the jobs that have failed
+func NewBuildStatus(build *api.Build) *BuildStatus {
+	status := &BuildStatus{
+		ID:           build.ID,
+		Number:       build.Number,
+		State:        build.State,
+		BlockedSteps: []*api.BuildJob{},
+		FailedJobs:   []*api.BuildJob{},
+	}
+
+	for _, job := range build.Jobs {
+		switch {
+		case job.Type == "manual" && job.State == "blocked":
+			status.BlockedSteps = append(status.BlockedSteps, job)
+		case job.Type == "script" && jobFailed(job):
+			status.FailedJobs = append(status.FailedJobs, job)
+		}
+	}
+
+	return status
+}
+
+func jobFailed(job *api.BuildJob) bool {
+	if job.State == "timed_out" {
+		return true
+	}
+
+	return job.State == "finished" && job.ExitStatus != "" && job.ExitStatus != "0"
+}
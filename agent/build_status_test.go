@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent/api"
+)
+
+func TestNewBuildStatus(t *testing.T) {
+	status := NewBuildStatus(&api.Build{
+		ID:     "build-1",
+		Number: 42,
+		State:  "running",
+		Jobs: []*api.BuildJob{
+			{ID: "passed", Type: "script", State: "finished", ExitStatus: "0"},
+			{ID: "failed", Type: "script", State: "finished", ExitStatus: "1"},
+			{ID: "timed-out", Type: "script", State: "timed_out"},
+			{ID: "running", Type: "script", State: "running"},
+			{ID: "wait", Type: "waiter", State: "waiting"},
+			{ID: "blocked", Type: "manual", State: "blocked", Label: "Deploy?"},
+			{ID: "unblocked", Type: "manual", State: "unblocked"},
+		},
+	})
+
+	if status.ID != "build-1" || status.Number != 42 || status.State != "running" {
+		t.Fatalf("Unexpected build details %#v", status)
+	}
+
+	if len(status.BlockedSteps) != 1 || status.BlockedSteps[0].ID != "blocked" {
+		t.Fatalf("Unexpected blocked steps %#v", status.BlockedSteps)
+	}
+
+	if len(status.FailedJobs) != 2 || status.FailedJobs[0].ID != "failed" || status.FailedJobs[1].ID != "timed-out" {
+		t.Fatalf("Unexpected failed jobs %#v", status.FailedJobs)
+	}
+}
@@ -0,0 +1,392 @@
+package agent
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/buildkite/agent/logger"
+	zglob "github.com/mattn/go-zglob"
+)
+
+// The extension of the archives that caches are stored as
+const cacheArchiveExtension = ".tar.gz"
+
+var (
+	cacheKeyTemplateRegex = regexp.MustCompile(`{{\s*(checksum|env)\s+"([^"]*)"\s*}}`)
+	cacheKeyInvalidRegex  = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+// ExpandCacheKey fills in the templates in a cache key, so that it changes
+// whenever the things the cache depends on do. {{ checksum "path" }} is the
+// SHA256 of the files matching the path (which can be a glob), and
+// {{ env "NAME" }} is the value of an environment variable. Any characters
+// that can't be used in a key are replaced with a "-".
+func ExpandCacheKey(key string) (string, error) {
+	var expandErr error
+
+	expanded := cacheKeyTemplateRegex.ReplaceAllStringFunc(key, func(match string) string {
+		parts := cacheKeyTemplateRegex.FindStringSubmatch(match)
+
+		switch parts[1] {
+		case "checksum":
+			sum, err := checksumFiles(parts[2])
+			if err != nil {
+				expandErr = err
+			}
+			return sum
+		default:
+			return os.Getenv(parts[2])
+		}
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	expanded = cacheKeyInvalidRegex.ReplaceAllString(expanded, "-")
+	if expanded == "" || strings.Trim(expanded, ".") == "" {
+		return "", fmt.Errorf("Invalid cache key %q", key)
+	}
+
+	return expanded, nil
+}
+
+// checksumFiles hashes the contents of every file matching the glob, in a
+// stable order
+func checksumFiles(glob string) (string, error) {
+	files, err := zglob.Glob(glob)
+	if err != nil || len(files) == 0 {
+		return "", fmt.Errorf("No files found to checksum for %q", glob)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(file))
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CacheSaver archives paths and saves them under a key
+type CacheSaver struct {
+	// Where caches are stored
+	Store CacheStore
+
+	// The (expanded) key to save the cache under
+	Key string
+
+	// The files and directories to cache, relative to the working directory
+	Paths []string
+}
+
+// Save stores the cache, unless there's already one with the same key.
+// Returns whether a new cache was saved.
+func (c CacheSaver) Save() (bool, error) {
+	exists, err := c.Store.Exists(c.Key)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		logger.Info("Cache %q already exists, not saving it again", c.Key)
+		return false, nil
+	}
+
+	for _, path := range c.Paths {
+		if filepath.IsAbs(path) || strings.HasPrefix(filepath.ToSlash(filepath.Clean(path)), "../") {
+			return false, fmt.Errorf("Cached paths must be within the working directory, %q isn't", path)
+		}
+	}
+
+	archive, err := ioutil.TempFile("", "cache-save")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(archive.Name())
+
+	if err := createCacheArchive(archive, c.Paths); err != nil {
+		archive.Close()
+		return false, err
+	}
+	if err := archive.Close(); err != nil {
+		return false, err
+	}
+
+	logger.Info("Saving cache %q to %s", c.Key, c.Store)
+	return true, c.Store.Put(c.Key, archive.Name())
+}
+
+// CacheRestorer finds the best matching cache and extracts it
+type CacheRestorer struct {
+	// Where caches are stored
+	Store CacheStore
+
+	// The keys to try, in order. Each key is first looked for exactly, and
+	// then as the prefix of the most recently saved cache.
+	Keys []string
+
+	// Where to extract the cache to
+	Destination string
+}
+
+// Restore extracts the first cache that matches, and returns its key. An
+// empty key means there were no matching caches.
+func (c CacheRestorer) Restore() (string, error) {
+	key, err := c.find()
+	if err != nil || key == "" {
+		return "", err
+	}
+
+	archive, err := ioutil.TempFile("", "cache-restore")
+	if err != nil {
+		return "", err
+	}
+	archive.Close()
+	defer os.Remove(archive.Name())
+
+	logger.Info("Restoring cache %q from %s", key, c.Store)
+	if err := c.Store.Get(key, archive.Name()); err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(archive.Name())
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return key, extractCacheArchive(f, c.Destination)
+}
+
+func (c CacheRestorer) find() (string, error) {
+	for _, key := range c.Keys {
+		exists, err := c.Store.Exists(key)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return key, nil
+		}
+
+		entries, err := c.Store.List(key)
+		if err != nil {
+			return "", err
+		}
+		if len(entries) > 0 {
+			sort.SliceStable(entries, func(i, j int) bool {
+				return entries[i].UpdatedAt.After(entries[j].UpdatedAt)
+			})
+			return entries[0].Key, nil
+		}
+
+		logger.Debug("No cache matches %q", key)
+	}
+
+	return "", nil
+}
+
+// createCacheArchive writes the paths to w as a gzipped tarball
+func createCacheArchive(w io.Writer, paths []string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			var link string
+			if info.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(file); err != nil {
+					return err
+				}
+			}
+
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(file)
+
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to archive %q: %v", path, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// extractCacheArchive extracts a gzipped tarball into dir, refusing to write
+// anything outside of it, including through symlinks the archive contains
+func extractCacheArchive(r io.Reader, dir string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	// Symlinks are compared after resolving, so the root has to be resolved
+	// too (e.g. /tmp is a symlink on macOS)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		target := filepath.Join(root, filepath.FromSlash(header.Name))
+		if !isInsideDir(root, target) {
+			return fmt.Errorf("Cache contains a path outside of the destination: %q", header.Name)
+		}
+
+		// An earlier entry may have been a symlink that this one is written
+		// through, so make sure the real parent is still inside the root
+		// before creating anything
+		parent, err := resolveExisting(filepath.Dir(target))
+		if err != nil {
+			return err
+		}
+		if !isInsideDir(realRoot, parent) {
+			return fmt.Errorf("Cache contains a path that is written through a symlink outside of the destination: %q", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(header.Mode)|0700); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := checkCacheSymlink(realRoot, parent, header); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			// Don't follow a symlink left at the target by an earlier entry
+			if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkCacheSymlink rejects symlinks in a cache that point outside of the
+// root. Absolute targets are never allowed, and relative ones may only use
+// ".." to move around inside the root (e.g. node_modules/.bin links)
+func checkCacheSymlink(realRoot, parent string, header *tar.Header) error {
+	link := filepath.FromSlash(header.Linkname)
+	if filepath.IsAbs(link) || filepath.VolumeName(link) != "" {
+		return fmt.Errorf("Cache contains a symlink with an absolute target: %q -> %q", header.Name, header.Linkname)
+	}
+
+	if !isInsideDir(realRoot, filepath.Join(parent, link)) {
+		return fmt.Errorf("Cache contains a symlink that points outside of the destination: %q -> %q", header.Name, header.Linkname)
+	}
+	return nil
+}
+
+// resolveExisting resolves the symlinks in the longest part of path that
+// exists. The rest doesn't exist yet, so can't contain any symlinks.
+func resolveExisting(path string) (string, error) {
+	rest := ""
+	for {
+		if _, err := os.Lstat(path); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, rest), nil
+}
+
+// isInsideDir returns whether path is dir or somewhere beneath it
+func isInsideDir(dir, path string) bool {
+	path = filepath.Clean(path)
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
@@ -0,0 +1,337 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// CacheStore is somewhere cache archives are kept
+type CacheStore interface {
+	// Exists returns whether there's a cache with exactly this key
+	Exists(key string) (bool, error)
+
+	// Put stores the archive at path under the key
+	Put(key string, path string) error
+
+	// Get downloads the archive for the key to path
+	Get(key string, path string) error
+
+	// List returns the caches with keys that start with prefix
+	List(prefix string) ([]CacheEntry, error)
+
+	String() string
+}
+
+// CacheEntry is a cache that's been saved
+type CacheEntry struct {
+	Key       string
+	UpdatedAt time.Time
+}
+
+// NewCacheStore creates a store for caches at an s3:// or gs:// location, or
+// a directory on the local filesystem. The S3 config is only used for s3://
+// locations.
+func NewCacheStore(location string, s3Config S3Config) (CacheStore, error) {
+	switch {
+	case location == "":
+		return nil, errors.New("No cache store has been set")
+
+	case strings.HasPrefix(location, "s3://"):
+		bucket, prefix := splitBucketLocation(strings.TrimPrefix(location, "s3://"))
+		client, err := newS3Client(bucket, s3Config)
+		if err != nil {
+			return nil, err
+		}
+		return &s3CacheStore{client: client, bucket: bucket, prefix: prefix}, nil
+
+	case strings.HasPrefix(location, "gs://"):
+		bucket, prefix := splitBucketLocation(strings.TrimPrefix(location, "gs://"))
+		client, err := (&GSUploader{}).getClient(storage.DevstorageReadWriteScope)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating Google Cloud Storage client: %v", err)
+		}
+		service, err := storage.New(client)
+		if err != nil {
+			return nil, err
+		}
+		return &gsCacheStore{service: service, bucket: bucket, prefix: prefix}, nil
+
+	default:
+		dir := strings.TrimPrefix(location, "file://")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return &localCacheStore{dir: dir}, nil
+	}
+}
+
+func splitBucketLocation(location string) (string, string) {
+	parts := strings.SplitN(location, "/", 2)
+	if len(parts) == 1 || strings.Trim(parts[1], "/") == "" {
+		return parts[0], ""
+	}
+	return parts[0], strings.Trim(parts[1], "/") + "/"
+}
+
+// Caches in a directory on the local filesystem, which could be shared
+// between agents on the same host or a network filesystem
+type localCacheStore struct {
+	dir string
+}
+
+func (s *localCacheStore) String() string {
+	return s.dir
+}
+
+func (s *localCacheStore) path(key string) string {
+	return filepath.Join(s.dir, key+cacheArchiveExtension)
+}
+
+func (s *localCacheStore) Exists(key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *localCacheStore) Put(key string, path string) error {
+	// Copy to a temporary file first, so the cache appears all at once
+	tmp, err := ioutil.TempFile(s.dir, "."+key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := copyFileTo(path, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *localCacheStore) Get(key string, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := copyFileTo(s.path(key), f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *localCacheStore) List(prefix string) ([]CacheEntry, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CacheEntry
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, cacheArchiveExtension) {
+			continue
+		}
+		entries = append(entries, CacheEntry{
+			Key:       strings.TrimSuffix(name, cacheArchiveExtension),
+			UpdatedAt: f.ModTime(),
+		})
+	}
+
+	return entries, nil
+}
+
+func copyFileTo(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Caches in an S3 bucket
+type s3CacheStore struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+func (s *s3CacheStore) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+func (s *s3CacheStore) objectKey(key string) string {
+	return s.prefix + key + cacheArchiveExtension
+}
+
+func (s *s3CacheStore) Exists(key string) (bool, error) {
+	_, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *s3CacheStore) Put(key string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = s3manager.NewUploaderWithClient(s.client).Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.objectKey(key)),
+		ContentType: aws.String("application/gzip"),
+		ACL:         aws.String("private"),
+		Body:        f,
+	})
+	return err
+}
+
+func (s *s3CacheStore) Get(key string, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = s3manager.NewDownloaderWithClient(s.client).Download(f, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	return err
+}
+
+func (s *s3CacheStore) List(prefix string) ([]CacheEntry, error) {
+	var entries []CacheEntry
+
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(object.Key), s.prefix)
+			if strings.Contains(name, "/") || !strings.HasSuffix(name, cacheArchiveExtension) {
+				continue
+			}
+			entries = append(entries, CacheEntry{
+				Key:       strings.TrimSuffix(name, cacheArchiveExtension),
+				UpdatedAt: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+
+	return entries, err
+}
+
+// Caches in a Google Cloud Storage bucket
+type gsCacheStore struct {
+	service *storage.Service
+	bucket  string
+	prefix  string
+}
+
+func (s *gsCacheStore) String() string {
+	return "gs://" + s.bucket + "/" + s.prefix
+}
+
+func (s *gsCacheStore) objectName(key string) string {
+	return s.prefix + key + cacheArchiveExtension
+}
+
+func (s *gsCacheStore) Exists(key string) (bool, error) {
+	_, err := s.service.Objects.Get(s.bucket, s.objectName(key)).Do()
+	if err != nil {
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *gsCacheStore) Put(key string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	object := &storage.Object{
+		Name:        s.objectName(key),
+		ContentType: "application/gzip",
+	}
+	_, err = s.service.Objects.Insert(s.bucket, object).Media(f, googleapi.ContentType("")).Do()
+	return err
+}
+
+func (s *gsCacheStore) Get(key string, path string) error {
+	resp, err := s.service.Objects.Get(s.bucket, s.objectName(key)).Download()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *gsCacheStore) List(prefix string) ([]CacheEntry, error) {
+	var entries []CacheEntry
+
+	err := s.service.Objects.List(s.bucket).Prefix(s.prefix+prefix).Pages(context.Background(), func(objects *storage.Objects) error {
+		for _, object := range objects.Items {
+			name := strings.TrimPrefix(object.Name, s.prefix)
+			if strings.Contains(name, "/") || !strings.HasSuffix(name, cacheArchiveExtension) {
+				continue
+			}
+			updatedAt, _ := time.Parse(time.RFC3339, object.Updated)
+			entries = append(entries, CacheEntry{
+				Key:       strings.TrimSuffix(name, cacheArchiveExtension),
+				UpdatedAt: updatedAt,
+			})
+		}
+		return nil
+	})
+
+	return entries, err
+}
@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandCacheKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lockfile := filepath.Join(dir, "yarn.lock")
+	if err := ioutil.WriteFile(lockfile, []byte("llamas"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("CACHE_TEST_BRANCH", "feature/llamas")
+	defer os.Unsetenv("CACHE_TEST_BRANCH")
+
+	first, err := ExpandCacheKey(`node-{{ env "CACHE_TEST_BRANCH" }}-{{ checksum "` + lockfile + `" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "node-feature-llamas-"; first[:len(expected)] != expected {
+		t.Fatalf("Expected key to start with %q, got %q", expected, first)
+	}
+
+	if err := ioutil.WriteFile(lockfile, []byte("alpacas"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := ExpandCacheKey(`node-{{ env "CACHE_TEST_BRANCH" }}-{{ checksum "` + lockfile + `" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second {
+		t.Fatalf("Expected the key to change with the file's contents")
+	}
+
+	if _, err := ExpandCacheKey(`node-{{ checksum "does-not-exist.lock" }}`); err == nil {
+		t.Fatalf("Expected an error when there's nothing to checksum")
+	}
+}
+
+func TestCacheSaveAndRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewCacheStore(filepath.Join(dir, "store"), S3Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	build := filepath.Join(dir, "build")
+	if err := os.MkdirAll(filepath.Join(build, "vendor", "gems"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(build); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"gems-old", "gems-new"} {
+		if err := ioutil.WriteFile(filepath.Join("vendor", "gems", "version"), []byte(key), 0644); err != nil {
+			t.Fatal(err)
+		}
+		saved, err := CacheSaver{Store: store, Key: key, Paths: []string{"vendor"}}.Save()
+		if err != nil || !saved {
+			t.Fatalf("Expected %q to be saved, got %v", key, err)
+		}
+
+		// Make sure the caches have different modification times
+		old := time.Now().Add(-time.Hour)
+		if key == "gems-old" {
+			os.Chtimes(filepath.Join(dir, "store", key+cacheArchiveExtension), old, old)
+		}
+	}
+
+	// Saving the same key again is skipped
+	if saved, err := (CacheSaver{Store: store, Key: "gems-new", Paths: []string{"vendor"}}).Save(); err != nil || saved {
+		t.Fatalf("Expected an existing cache not to be saved again, got %v, %v", saved, err)
+	}
+
+	if _, err := (CacheSaver{Store: store, Key: "gems-bad", Paths: []string{"../elsewhere"}}).Save(); err == nil {
+		t.Fatalf("Expected paths outside the working directory to be rejected")
+	}
+
+	for _, tc := range []struct {
+		keys     []string
+		expected string
+	}{
+		{[]string{"gems-old"}, "gems-old"},
+		{[]string{"gems-missing", "gems-"}, "gems-new"},
+		{[]string{"nope"}, ""},
+	} {
+		restoreDir := filepath.Join(dir, "restore-"+tc.expected)
+
+		restored, err := CacheRestorer{Store: store, Keys: tc.keys, Destination: restoreDir}.Restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored != tc.expected {
+			t.Fatalf("Expected %v to restore %q, got %q", tc.keys, tc.expected, restored)
+		}
+		if restored == "" {
+			continue
+		}
+
+		version, err := ioutil.ReadFile(filepath.Join(restoreDir, "vendor", "gems", "version"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(version) != tc.expected {
+			t.Fatalf("Expected to restore the %q cache, got %q", tc.expected, version)
+		}
+	}
+}
+
+func TestExtractCacheArchiveRefusesToEscapeThroughSymlinks(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []tar.Header
+	}{
+		{"absolute symlink", []tar.Header{
+			{Name: "x", Typeflag: tar.TypeSymlink, Linkname: "/tmp"},
+		}},
+		{"relative symlink out of the root", []tar.Header{
+			{Name: "x", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+		}},
+		{"chained symlinks", []tar.Header{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "a/b/evil", Typeflag: tar.TypeReg, Mode: 0644},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "cache-extract")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			dest := filepath.Join(dir, "dest")
+			if err := extractCacheArchive(cacheArchive(t, tc.entries), dest); err == nil {
+				t.Fatalf("Expected the archive to be refused")
+			}
+			if _, err := os.Stat(filepath.Join(dir, "evil")); err == nil {
+				t.Fatalf("Expected nothing to be written outside of the destination")
+			}
+		})
+	}
+}
+
+func TestExtractCacheArchiveAllowsSymlinksInsideTheRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := cacheArchive(t, []tar.Header{
+		{Name: "node_modules/foo/bin/foo", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "node_modules/.bin/foo", Typeflag: tar.TypeSymlink, Linkname: "../foo/bin/foo"},
+	})
+	if err := extractCacheArchive(archive, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "node_modules", ".bin", "foo")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cacheArchive(t *testing.T, entries []tar.Header) io.Reader {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, header := range entries {
+		header := header
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// CloudWatch only takes so many metrics in each request
+const cloudWatchMaxMetrics = 20
+
+// CloudWatchMetricsPublisher puts metrics into CloudWatch, using the same
+// credentials and region detection as the S3 artifact uploader
+type CloudWatchMetricsPublisher struct {
+	// The namespace the metrics are put in
+	Namespace string
+}
+
+func (p *CloudWatchMetricsPublisher) String() string {
+	return fmt.Sprintf("CloudWatch namespace %s", p.Namespace)
+}
+
+func (p *CloudWatchMetricsPublisher) Publish(metrics []Metric) error {
+	sess, err := awsSession()
+	if err != nil {
+		return err
+	}
+
+	region := *sess.Config.Region
+	signer := v4.NewSigner(sess.Config.Credentials)
+	endpoint := fmt.Sprintf("https://monitoring.%s.amazonaws.com/", region)
+
+	for start := 0; start < len(metrics); start += cloudWatchMaxMetrics {
+		end := start + cloudWatchMaxMetrics
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+
+		body := cloudWatchPutMetricData(p.Namespace, metrics[start:end], time.Now())
+
+		req, err := http.NewRequest("POST", endpoint, strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+		if _, err := signer.Sign(req, strings.NewReader(body), "monitoring", region, time.Now()); err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("CloudWatch responded with %s: %s", resp.Status, respBody)
+		}
+	}
+
+	return nil
+}
+
+// cloudWatchPutMetricData encodes a PutMetricData request for the query API,
+// see https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_PutMetricData.html
+func cloudWatchPutMetricData(namespace string, metrics []Metric, timestamp time.Time) string {
+	values := url.Values{}
+	values.Set("Action", "PutMetricData")
+	values.Set("Version", "2010-08-01")
+	values.Set("Namespace", namespace)
+
+	for i, m := range metrics {
+		prefix := fmt.Sprintf("MetricData.member.%d.", i+1)
+		values.Set(prefix+"MetricName", m.Name)
+		values.Set(prefix+"Value", strconv.FormatFloat(m.Value, 'f', -1, 64))
+		values.Set(prefix+"Unit", m.Unit)
+		values.Set(prefix+"Timestamp", timestamp.UTC().Format(time.RFC3339))
+
+		if m.Queue != "" {
+			values.Set(prefix+"Dimensions.member.1.Name", "Queue")
+			values.Set(prefix+"Dimensions.member.1.Value", m.Queue)
+		}
+	}
+
+	return values.Encode()
+}
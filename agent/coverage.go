@@ -0,0 +1,284 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The most files to list in a coverage annotation
+const maxAnnotatedCoverageFiles = 10
+
+// CoverageReport is how much of each file was covered by the tests
+type CoverageReport struct {
+	Format string
+	Files  []CoverageFile
+}
+
+// CoverageFile is the coverage of a single file. For Go coverprofiles the
+// lines are really statements, which is what `go tool cover` counts too.
+type CoverageFile struct {
+	Path    string
+	Lines   int
+	Covered int
+}
+
+// Lines is the total number of lines that could have been covered
+func (r *CoverageReport) Lines() (lines int) {
+	for _, f := range r.Files {
+		lines += f.Lines
+	}
+	return
+}
+
+// Covered is the total number of lines that were covered
+func (r *CoverageReport) Covered() (covered int) {
+	for _, f := range r.Files {
+		covered += f.Covered
+	}
+	return
+}
+
+// Percent is the percentage of lines covered, which is 100 for a report
+// without any lines in it
+func (r *CoverageReport) Percent() float64 {
+	return coveragePercent(r.Covered(), r.Lines())
+}
+
+func coveragePercent(covered, lines int) float64 {
+	if lines == 0 {
+		return 100
+	}
+	return float64(covered) / float64(lines) * 100
+}
+
+// ParseCoverage reads an lcov tracefile, a Cobertura XML report or a Go
+// coverprofile, working out which one it is from the contents
+func ParseCoverage(data []byte) (*CoverageReport, error) {
+	trimmed := bytes.TrimSpace(data)
+
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("mode:")):
+		return parseGoCoverprofile(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		return parseCobertura(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("TN:")) || bytes.HasPrefix(trimmed, []byte("SF:")):
+		return parseLcov(trimmed)
+	default:
+		return nil, fmt.Errorf("Unrecognised coverage format, expected lcov, Cobertura or a Go coverprofile")
+	}
+}
+
+func parseLcov(data []byte) (*CoverageReport, error) {
+	report := &CoverageReport{Format: "lcov"}
+
+	var current *CoverageFile
+	var found, hit int
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			current = &CoverageFile{Path: strings.TrimPrefix(line, "SF:")}
+			found, hit = 0, 0
+		case current == nil:
+			continue
+		case strings.HasPrefix(line, "DA:"):
+			// DA:<line number>,<execution count>[,<checksum>]
+			parts := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("Invalid lcov line %q", line)
+			}
+			current.Lines++
+			if parts[1] != "0" {
+				current.Covered++
+			}
+		case strings.HasPrefix(line, "LF:"):
+			found, _ = strconv.Atoi(strings.TrimPrefix(line, "LF:"))
+		case strings.HasPrefix(line, "LH:"):
+			hit, _ = strconv.Atoi(strings.TrimPrefix(line, "LH:"))
+		case line == "end_of_record":
+			// Prefer the summary lines when there are some, since
+			// not every tool writes out the DA lines
+			if found > 0 {
+				current.Lines, current.Covered = found, hit
+			}
+			report.Files = append(report.Files, *current)
+			current = nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+type coberturaCoverage struct {
+	Packages []struct {
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Lines    []struct {
+				Number string `xml:"number,attr"`
+				Hits   string `xml:"hits,attr"`
+			} `xml:"lines>line"`
+		} `xml:"classes>class"`
+	} `xml:"packages>package"`
+}
+
+func parseCobertura(data []byte) (*CoverageReport, error) {
+	var parsed coberturaCoverage
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+
+	// A file can have more than one class in it, so the lines are
+	// collected per file first
+	hits := map[string]map[string]bool{}
+	var order []string
+
+	for _, pkg := range parsed.Packages {
+		for _, class := range pkg.Classes {
+			lines, ok := hits[class.Filename]
+			if !ok {
+				lines = map[string]bool{}
+				hits[class.Filename] = lines
+				order = append(order, class.Filename)
+			}
+			for _, line := range class.Lines {
+				lines[line.Number] = lines[line.Number] || (line.Hits != "" && line.Hits != "0")
+			}
+		}
+	}
+
+	report := &CoverageReport{Format: "cobertura"}
+	for _, path := range order {
+		file := CoverageFile{Path: path, Lines: len(hits[path])}
+		for _, covered := range hits[path] {
+			if covered {
+				file.Covered++
+			}
+		}
+		report.Files = append(report.Files, file)
+	}
+
+	return report, nil
+}
+
+func parseGoCoverprofile(data []byte) (*CoverageReport, error) {
+	type block struct {
+		statements int
+		covered    bool
+	}
+
+	// Profiles merged from several packages can have the same block more
+	// than once, so they're keyed by their position
+	blocks := map[string]map[string]*block{}
+	var order []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+
+		// <file>:<start line>.<col>,<end line>.<col> <statements> <count>
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Invalid coverprofile line %q", line)
+		}
+
+		colon := strings.LastIndex(fields[0], ":")
+		statements, err := strconv.Atoi(fields[1])
+		if colon == -1 || err != nil {
+			return nil, fmt.Errorf("Invalid coverprofile line %q", line)
+		}
+
+		path, position := fields[0][:colon], fields[0][colon+1:]
+		if _, ok := blocks[path]; !ok {
+			blocks[path] = map[string]*block{}
+			order = append(order, path)
+		}
+
+		b, ok := blocks[path][position]
+		if !ok {
+			b = &block{statements: statements}
+			blocks[path][position] = b
+		}
+		b.covered = b.covered || fields[2] != "0"
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &CoverageReport{Format: "go"}
+	for _, path := range order {
+		file := CoverageFile{Path: path}
+		for _, b := range blocks[path] {
+			file.Lines += b.statements
+			if b.covered {
+				file.Covered += b.statements
+			}
+		}
+		report.Files = append(report.Files, file)
+	}
+
+	return report, nil
+}
+
+// CoverageAnnotation is a Markdown summary of the coverage, including how
+// it's changed since the baseline if there is one
+func CoverageAnnotation(report *CoverageReport, baseline *float64) string {
+	var b strings.Builder
+
+	percent := report.Percent()
+	fmt.Fprintf(&b, "Coverage is **%.2f%%** (%d of %d lines)", percent, report.Covered(), report.Lines())
+
+	if baseline != nil {
+		delta := percent - *baseline
+		switch {
+		case delta > 0.005:
+			fmt.Fprintf(&b, ", up **%.2f%%** from %.2f%%", delta, *baseline)
+		case delta < -0.005:
+			fmt.Fprintf(&b, ", down **%.2f%%** from %.2f%%", -delta, *baseline)
+		default:
+			fmt.Fprintf(&b, ", unchanged from %.2f%%", *baseline)
+		}
+	}
+	b.WriteString("\n")
+
+	// List the files with the most lines missing coverage, since that's
+	// where there's the most to gain
+	files := make([]CoverageFile, 0, len(report.Files))
+	for _, f := range report.Files {
+		if f.Covered < f.Lines {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return b.String()
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Lines-files[i].Covered > files[j].Lines-files[j].Covered
+	})
+	if len(files) > maxAnnotatedCoverageFiles {
+		files = files[:maxAnnotatedCoverageFiles]
+	}
+
+	b.WriteString("\n| File | Coverage | Uncovered lines |\n| --- | ---: | ---: |\n")
+	for _, f := range files {
+		fmt.Fprintf(&b, "| `%s` | %.2f%% | %d |\n", f.Path, coveragePercent(f.Covered, f.Lines), f.Lines-f.Covered)
+	}
+
+	return b.String()
+}
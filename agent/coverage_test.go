@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCoverageLcov(t *testing.T) {
+	report, err := ParseCoverage([]byte(`TN:
+SF:src/llamas.js
+DA:1,1
+DA:2,0
+DA:3,4
+end_of_record
+SF:src/alpacas.js
+DA:1,0
+LF:10
+LH:5
+end_of_record
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Format != "lcov" || len(report.Files) != 2 {
+		t.Fatalf("Unexpected report %#v", report)
+	}
+	if report.Files[0].Lines != 3 || report.Files[0].Covered != 2 {
+		t.Fatalf("Unexpected coverage from DA lines %#v", report.Files[0])
+	}
+	if report.Files[1].Lines != 10 || report.Files[1].Covered != 5 {
+		t.Fatalf("Unexpected coverage from LF and LH lines %#v", report.Files[1])
+	}
+	if report.Lines() != 13 || report.Covered() != 7 {
+		t.Fatalf("Unexpected totals %d of %d", report.Covered(), report.Lines())
+	}
+}
+
+func TestParseCoverageCobertura(t *testing.T) {
+	report, err := ParseCoverage([]byte(`<?xml version="1.0" ?>
+<coverage line-rate="0.5">
+  <packages>
+    <package name="llamas">
+      <classes>
+        <class name="Llama" filename="llamas/llama.py">
+          <lines><line number="1" hits="1"/><line number="2" hits="0"/></lines>
+        </class>
+        <class name="Baby" filename="llamas/llama.py">
+          <lines><line number="2" hits="3"/><line number="3" hits="0"/></lines>
+        </class>
+      </classes>
+    </package>
+  </packages>
+</coverage>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Format != "cobertura" || len(report.Files) != 1 {
+		t.Fatalf("Unexpected report %#v", report)
+	}
+	if report.Files[0].Lines != 3 || report.Files[0].Covered != 2 {
+		t.Fatalf("Unexpected coverage %#v", report.Files[0])
+	}
+}
+
+func TestParseCoverageGoCoverprofile(t *testing.T) {
+	report, err := ParseCoverage([]byte(`mode: set
+github.com/buildkite/llamas/llama.go:10.2,12.3 2 1
+github.com/buildkite/llamas/llama.go:14.2,16.3 3 0
+github.com/buildkite/llamas/llama.go:14.2,16.3 3 1
+github.com/buildkite/llamas/alpaca.go:1.2,2.3 5 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Format != "go" || len(report.Files) != 2 {
+		t.Fatalf("Unexpected report %#v", report)
+	}
+	if report.Files[0].Lines != 5 || report.Files[0].Covered != 5 {
+		t.Fatalf("Unexpected coverage %#v", report.Files[0])
+	}
+	if report.Percent() != 50 {
+		t.Fatalf("Expected 50%% coverage, got %.2f", report.Percent())
+	}
+
+	baseline := 60.0
+	annotation := CoverageAnnotation(report, &baseline)
+	if !strings.Contains(annotation, "**50.00%**") || !strings.Contains(annotation, "down **10.00%** from 60.00%") {
+		t.Fatalf("Unexpected annotation %q", annotation)
+	}
+	if !strings.Contains(annotation, "`github.com/buildkite/llamas/alpaca.go`") || strings.Contains(annotation, "llama.go`") {
+		t.Fatalf("Unexpected files in annotation %q", annotation)
+	}
+}
+
+func TestParseCoverageUnknownFormat(t *testing.T) {
+	if _, err := ParseCoverage([]byte("llamas")); err == nil {
+		t.Fatal("Expected an error for an unknown format")
+	}
+}
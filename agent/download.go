@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -32,6 +33,18 @@ type Download struct {
 
 	// If failed responses should be dumped to the log
 	DebugHTTP bool
+
+	// The permissions of directories the download creates, and of the
+	// downloaded file. Zero leaves them up to the process's umask.
+	DirMode  os.FileMode
+	FileMode os.FileMode
+
+	// How the file was compressed when it was uploaded, if it was
+	ContentEncoding string
+
+	// The directory artifacts uploaded to a file:// destination were copied
+	// to. file:// URLs are only read when they're within it.
+	FileDir string
 }
 
 func (d Download) Start() error {
@@ -77,24 +90,14 @@ func (d Download) try() error {
 	logger.Debug("Downloading %s to %s", d.URL, targetFile)
 
 	// Start by downloading the file
-	response, err := d.Client.Get(d.URL)
+	body, err := d.open()
 	if err != nil {
-		return fmt.Errorf("Error while downloading %s (%T: %v)", d.URL, err, err)
-	}
-	defer response.Body.Close()
-
-	// Double check the status
-	if response.StatusCode/100 != 2 && response.StatusCode/100 != 3 {
-		if d.DebugHTTP {
-			responseDump, err := httputil.DumpResponse(response, true)
-			logger.Debug("\nERR: %s\n%s", err, string(responseDump))
-		}
-
-		return &downloadError{response.Status}
+		return err
 	}
+	defer body.Close()
 
 	// Now make the folder for our file
-	err = os.MkdirAll(targetDirectory, 0777)
+	err = mkdirAllWithMode(targetDirectory, d.DirMode)
 	if err != nil {
 		return fmt.Errorf("Failed to create folder for %s (%T: %v)", targetFile, err, err)
 	}
@@ -107,16 +110,112 @@ func (d Download) try() error {
 	defer fileBuffer.Close()
 
 	// Copy the data to the file
-	bytes, err := io.Copy(fileBuffer, response.Body)
+	bytes, err := io.Copy(fileBuffer, body)
 	if err != nil {
 		return fmt.Errorf("Error when copying data %s (%T: %v)", d.URL, err, err)
 	}
 
+	// Set the mode explicitly, so the process's umask doesn't change it
+	if d.FileMode != 0 {
+		if err := fileBuffer.Chmod(d.FileMode); err != nil {
+			return fmt.Errorf("Failed to set the permissions of %s (%T: %v)", targetFile, err, err)
+		}
+	}
+
 	logger.Info("Successfully downloaded \"%s\" %d bytes", d.Path, bytes)
 
 	return nil
 }
 
+// open starts downloading the file, and decompresses it if it needs to be.
+// Artifacts copied to a directory by the FileUploader are read straight from
+// it.
+func (d Download) open() (io.ReadCloser, error) {
+	body, decoded, err := d.openEncoded()
+	if err != nil || decoded {
+		return body, err
+	}
+
+	decodedBody, err := decodeArtifact(body, d.ContentEncoding)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("Error while downloading %s (%v)", d.URL, err)
+	}
+
+	return decodedBody, nil
+}
+
+// openEncoded starts downloading the file as it was stored. The HTTP client
+// decompresses gzipped responses itself, which it says it's done.
+func (d Download) openEncoded() (io.ReadCloser, bool, error) {
+	if strings.HasPrefix(d.URL, "file://") {
+		fileURL, err := url.Parse(d.URL)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// Whoever uploaded the artifact chose its URL, so it mustn't be
+		// able to point at any other file on this host
+		path := fileURLPath("file://" + fileURL.Path)
+		if d.FileDir == "" || !withinDir(d.FileDir, path) {
+			return nil, false, retry.Permanent(fmt.Errorf("Refusing to download %s, as it isn't in the artifact's upload destination", d.URL))
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, false, fmt.Errorf("Error while downloading %s (%T: %v)", d.URL, err, err)
+		}
+		return f, false, nil
+	}
+
+	response, err := d.Client.Get(d.URL)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error while downloading %s (%T: %v)", d.URL, err, err)
+	}
+
+	// Double check the status
+	if response.StatusCode/100 != 2 && response.StatusCode/100 != 3 {
+		if d.DebugHTTP {
+			responseDump, err := httputil.DumpResponse(response, true)
+			logger.Debug("\nERR: %s\n%s", err, string(responseDump))
+		}
+
+		response.Body.Close()
+		return nil, false, &downloadError{response.Status}
+	}
+
+	return response.Body, response.Uncompressed, nil
+}
+
+// mkdirAllWithMode is like os.MkdirAll, but sets the permissions of the
+// directories it creates to mode regardless of the umask. Directories that
+// already exist are left alone.
+func mkdirAllWithMode(dir string, mode os.FileMode) error {
+	if mode == 0 {
+		return os.MkdirAll(dir, 0777)
+	}
+
+	dir = filepath.Clean(dir)
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := mkdirAllWithMode(parent, mode); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Mkdir(dir, mode); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	return os.Chmod(dir, mode)
+}
+
 type downloadError struct {
 	s string
 }
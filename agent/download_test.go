@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/buildkite/agent/api"
+)
+
+func TestDownloadSetsPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't have unix permissions")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "llamas")
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = Download{
+		URL:         server.URL,
+		Path:        filepath.Join("a", "b", "llamas.txt"),
+		Destination: dir,
+		Retries:     1,
+		DirMode:     0750,
+		FileMode:    0640,
+	}.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]os.FileMode{
+		filepath.Join(dir, "a"):                    0750,
+		filepath.Join(dir, "a", "b"):               0750,
+		filepath.Join(dir, "a", "b", "llamas.txt"): 0640,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != expected {
+			t.Errorf("Expected %s to have mode %v, got %v", path, expected, info.Mode().Perm())
+		}
+	}
+
+	// Directories that already existed keep their permissions
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("Expected the destination to keep mode 0700, got %v", info.Mode().Perm())
+	}
+}
+
+func TestArtifactDownloaderFileMode(t *testing.T) {
+	umask := os.FileMode(0027)
+	artifact := &api.Artifact{Path: "bin/llamas", FileMode: "0755"}
+
+	for _, tc := range []struct {
+		downloader ArtifactDownloader
+		expected   os.FileMode
+	}{
+		{ArtifactDownloader{}, 0},
+		{ArtifactDownloader{Umask: &umask}, 0640},
+		{ArtifactDownloader{RestoreModes: true}, 0755},
+		{ArtifactDownloader{RestoreModes: true, Umask: &umask}, 0750},
+	} {
+		if mode := tc.downloader.fileMode(artifact); mode != tc.expected {
+			t.Errorf("Expected %v for %+v, got %v", tc.expected, tc.downloader, mode)
+		}
+	}
+
+	// Artifacts uploaded without a recorded mode fall back to the default
+	d := ArtifactDownloader{RestoreModes: true}
+	if mode := d.fileMode(&api.Artifact{Path: "old"}); mode != 0666 {
+		t.Errorf("Expected 0666 for an artifact without a mode, got %v", mode)
+	}
+}
@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"sort"
+	"strings"
+)
+
+// EnvTags finds agent tags in environment variables that start with Prefix,
+// so BUILDKITE_AGENT_TAG_QUEUE=deploy becomes the tag queue=deploy
+type EnvTags struct {
+	Prefix string
+}
+
+func (e EnvTags) Get(environ []string) []string {
+	var tags []string
+
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, e.Prefix) {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(kv, e.Prefix), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+
+		tags = append(tags, strings.ToLower(parts[0])+"="+parts[1])
+	}
+
+	// The environment isn't in any particular order
+	sort.Strings(tags)
+
+	return tags
+}
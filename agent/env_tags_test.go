@@ -0,0 +1,22 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvTags(t *testing.T) {
+	tags := EnvTags{Prefix: "BUILDKITE_AGENT_TAG_"}.Get([]string{
+		"BUILDKITE_AGENT_TAG_QUEUE=deploy",
+		"PATH=/usr/bin",
+		"BUILDKITE_AGENT_TAGS=ignored=true",
+		"BUILDKITE_AGENT_TAG_=empty",
+		"BUILDKITE_AGENT_TAG_Instance_Type=m5.large",
+		"BUILDKITE_AGENT_TAG_EXPR=a=b",
+	})
+
+	expected := []string{"expr=a=b", "instance_type=m5.large", "queue=deploy"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("Expected %v, got %v", expected, tags)
+	}
+}
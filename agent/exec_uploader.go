@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/mime"
+	"github.com/buildkite/shellwords"
+)
+
+// ExecUploader uploads artifacts by running a command, so that storage that
+// the agent doesn't support can be added without changing it.
+//
+// The command is run twice for each artifact, first to get the URL it will
+// have and then to upload it, with $BUILDKITE_ARTIFACT_UPLOAD_ACTION set to
+// "url" or "upload". The artifact is described as JSON on STDIN, and the
+// command writes a JSON response to STDOUT:
+//
+//	{"url": "https://...", "error": "..."}
+//
+// Either field can be left out. A non-zero exit status or an error fails
+// the action, and failed uploads are retried.
+type ExecUploader struct {
+	// The command to run
+	Command string
+
+	// Where the artifacts are being uploaded to, which is passed on to the
+	// command as is
+	Destination string
+
+	// Whether or not HTTP calls shoud be debugged
+	DebugHTTP bool
+}
+
+const (
+	ExecUploaderActionURL    = "url"
+	ExecUploaderActionUpload = "upload"
+)
+
+// What the command is given on STDIN
+type execUploaderRequest struct {
+	Action          string `json:"action"`
+	Destination     string `json:"destination"`
+	Path            string `json:"path"`
+	AbsolutePath    string `json:"absolute_path"`
+	FileSize        int64  `json:"file_size"`
+	Sha1Sum         string `json:"sha1sum"`
+	Sha256Sum       string `json:"sha256sum,omitempty"`
+	ContentType     string `json:"content_type,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	URL             string `json:"url,omitempty"`
+}
+
+// What the command writes to STDOUT
+type execUploaderResponse struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+func (u *ExecUploader) Setup(destination string, debugHTTP bool) error {
+	u.Destination = destination
+	u.DebugHTTP = debugHTTP
+
+	args, err := shellwords.Split(u.Command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("No artifact uploader command to run")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return fmt.Errorf("Artifact uploader command %q can't be run: %v", args[0], err)
+	}
+
+	return nil
+}
+
+// URL asks the command where the artifact will be. The interface doesn't
+// allow for errors here, so a command that fails just gives no URL.
+func (u *ExecUploader) URL(artifact *api.Artifact) string {
+	resp, err := u.run(ExecUploaderActionURL, artifact)
+	if err != nil {
+		logger.Warn("Failed to get the URL of artifact %s from %s: %v", artifact.Path, u.Command, err)
+		return ""
+	}
+
+	return resp.URL
+}
+
+func (u *ExecUploader) Upload(artifact *api.Artifact) error {
+	logger.Debug("Uploading \"%s\" with %s", artifact.Path, u.Command)
+
+	_, err := u.run(ExecUploaderActionUpload, artifact)
+	return err
+}
+
+func (u *ExecUploader) run(action string, artifact *api.Artifact) (*execUploaderResponse, error) {
+	args, err := shellwords.Split(u.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(execUploaderRequest{
+		Action:          action,
+		Destination:     u.Destination,
+		Path:            artifact.Path,
+		AbsolutePath:    artifact.AbsolutePath,
+		FileSize:        artifact.FileSize,
+		Sha1Sum:         artifact.Sha1Sum,
+		Sha256Sum:       artifact.Sha256Sum,
+		ContentType:     mime.TypeByExtension(filepath.Ext(artifact.Path)),
+		ContentEncoding: artifact.ContentEncoding,
+		URL:             artifact.URL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "BUILDKITE_ARTIFACT_UPLOAD_ACTION="+action)
+
+	if err := cmd.Run(); err != nil {
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			return nil, fmt.Errorf("%v: %s", err, output)
+		}
+		return nil, err
+	}
+
+	if output := strings.TrimSpace(stderr.String()); output != "" {
+		logger.Debug("%s: %s", u.Command, output)
+	}
+
+	resp := &execUploaderResponse{}
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		if err := json.Unmarshal(out, resp); err != nil {
+			return nil, fmt.Errorf("Artifact uploader %s didn't write JSON to STDOUT: %v", u.Command, err)
+		}
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/buildkite/agent/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeUploaderScript(t *testing.T, dir string, script string) string {
+	path := filepath.Join(dir, "uploader")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecUploader(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Not supported on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "exec-uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	received := filepath.Join(dir, "received")
+	script := writeUploaderScript(t, dir, `
+cat > "`+received+`.$BUILDKITE_ARTIFACT_UPLOAD_ACTION"
+if [ "$BUILDKITE_ARTIFACT_UPLOAD_ACTION" = "url" ]; then
+  echo '{"url": "https://example.com/llamas.txt"}'
+fi
+`)
+
+	u := &ExecUploader{Command: script}
+	assert.NoError(t, u.Setup("minio://bucket", false))
+
+	artifact := &api.Artifact{Path: "llamas.txt", AbsolutePath: "/tmp/llamas.txt", FileSize: 3}
+
+	artifact.URL = u.URL(artifact)
+	assert.Equal(t, "https://example.com/llamas.txt", artifact.URL)
+	assert.NoError(t, u.Upload(artifact))
+
+	body, err := ioutil.ReadFile(received + ".upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, `{
+		"action": "upload",
+		"destination": "minio://bucket",
+		"path": "llamas.txt",
+		"absolute_path": "/tmp/llamas.txt",
+		"file_size": 3,
+		"sha1sum": "",
+		"content_type": "text/plain; charset=utf-8",
+		"url": "https://example.com/llamas.txt"
+	}`, string(body))
+}
+
+func TestExecUploaderFailures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Not supported on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "exec-uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	artifact := &api.Artifact{Path: "llamas.txt"}
+
+	u := &ExecUploader{Command: writeUploaderScript(t, dir, `echo "bucket is full" >&2; exit 1`)}
+	err = u.Upload(artifact)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "bucket is full")
+	}
+
+	u = &ExecUploader{Command: writeUploaderScript(t, dir, `echo '{"error": "access denied"}'`)}
+	err = u.Upload(artifact)
+	if assert.Error(t, err) {
+		assert.Equal(t, "access denied", err.Error())
+	}
+
+	u = &ExecUploader{Command: filepath.Join(dir, "nope")}
+	assert.Error(t, u.Setup("", false))
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// The ways the agent can run jobs
+const (
+	ExecutorLocal      = "local"
+	ExecutorKubernetes = "kubernetes"
+)
+
+// ValidateExecutor returns an error if the executor isn't one we know about
+func ValidateExecutor(executor string) error {
+	switch executor {
+	case ExecutorLocal, ExecutorKubernetes:
+		return nil
+	default:
+		return fmt.Errorf("Invalid executor %q, must be one of local or kubernetes", executor)
+	}
+}
+
+// kubernetesCommand returns the command that runs the job in its own pod,
+// rather than running the bootstrap on this machine. The job's env goes to
+// the command in a file, so that it can tell it apart from the agent's.
+func (r *JobRunner) kubernetesCommand(env []string) ([]string, []string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envFile, err := r.writeJobEnvFile(env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env = append(env,
+		"BUILDKITE_KUBERNETES_ENV_FILE="+envFile,
+		"BUILDKITE_KUBERNETES_NAMESPACE="+r.AgentConfiguration.KubernetesNamespace,
+		"BUILDKITE_KUBERNETES_DEFAULT_IMAGE="+r.AgentConfiguration.KubernetesImage,
+		"BUILDKITE_KUBERNETES_AGENT_IMAGE="+r.AgentConfiguration.KubernetesAgentImage,
+	)
+
+	return []string{self, "kubernetes-bootstrap"}, env, nil
+}
+
+// isolatedCommand returns the command that runs the job with the agent's
+// isolation backend, like kubernetesCommand does for pods
+func (r *JobRunner) isolatedCommand(env []string) ([]string, []string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envFile, err := r.writeJobEnvFile(env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env = append(env,
+		"BUILDKITE_ISOLATION_ENV_FILE="+envFile,
+		"BUILDKITE_ISOLATION="+r.AgentConfiguration.Isolation,
+		"BUILDKITE_ISOLATION_OPTIONS="+strings.Join(r.AgentConfiguration.IsolationOptions, ","),
+	)
+
+	return []string{self, "isolated-bootstrap"}, env, nil
+}
+
+// writeJobEnvFile writes the job's env to a JSON file, which is removed
+// when the job finishes
+func (r *JobRunner) writeJobEnvFile(env []string) (string, error) {
+	jobEnv := map[string]string{}
+	for _, e := range env {
+		if parts := strings.SplitN(e, "=", 2); len(parts) == 2 {
+			jobEnv[parts[0]] = parts[1]
+		}
+	}
+
+	data, err := json.Marshal(jobEnv)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile("", fmt.Sprintf("job-isolated-env-%s", r.Job.ID))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	r.jobEnvFile = file.Name()
+
+	if _, err := file.Write(data); err != nil {
+		return "", err
+	}
+
+	return file.Name(), nil
+}
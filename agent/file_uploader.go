@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/logger"
+)
+
+// Windows paths in file:// URLs start with a slash before the drive
+var fileURLDrivePathRegex = regexp.MustCompile(`^/[a-zA-Z]:/`)
+
+// FileUploader copies artifacts into a directory, given as
+// file:///path/to/dir, which is usually a network filesystem like NFS or SMB
+// that's mounted on every agent. The artifacts keep their relative paths.
+type FileUploader struct {
+	// The destination directory, e.g. file:///mnt/artifacts/foo
+	Destination string
+
+	// Whether or not HTTP calls shoud be debugged
+	DebugHTTP bool
+}
+
+func (u *FileUploader) Setup(destination string, debugHTTP bool) error {
+	u.Destination = destination
+	u.DebugHTTP = debugHTTP
+
+	dir := u.Dir()
+	if dir == "" {
+		return fmt.Errorf("Invalid upload destination: '%v', expected file:///path/to/dir", destination)
+	}
+
+	if info, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("Artifact destination %s isn't available (%v)", dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("Artifact destination %s isn't a directory", dir)
+	}
+
+	return nil
+}
+
+func (u *FileUploader) URL(artifact *api.Artifact) string {
+	path := filepath.ToSlash(u.artifactPath(artifact))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+func (u *FileUploader) Upload(artifact *api.Artifact) error {
+	target := u.artifactPath(artifact)
+	if !withinDir(u.Dir(), target) {
+		return fmt.Errorf("Refusing to copy %q outside of %s", artifact.Path, u.Dir())
+	}
+
+	logger.Debug("Copying \"%s\" to %s", artifact.Path, target)
+
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+
+	// Copy to a temporary file first, so the artifact appears all at once
+	tmp, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := copyFileTo(artifact.AbsolutePath, tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to copy file %q (%v)", artifact.AbsolutePath, err)
+	}
+
+	// Temporary files can only be read by their owner, but the agents
+	// downloading them might not be running as the same user
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), target)
+}
+
+// Dir returns the directory artifacts are copied to
+func (u *FileUploader) Dir() string {
+	return fileURLPath(u.Destination)
+}
+
+func (u *FileUploader) artifactPath(artifact *api.Artifact) string {
+	return filepath.Join(u.Dir(), filepath.FromSlash(artifact.Path))
+}
+
+// withinDir returns whether path is dir, or is somewhere inside it, once both
+// have been cleaned
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// fileURLPath returns the path on the filesystem of a file:// URL
+func fileURLPath(fileURL string) string {
+	path := strings.TrimPrefix(fileURL, "file://")
+	if fileURLDrivePathRegex.MatchString(path) {
+		path = path[1:]
+	}
+
+	return filepath.FromSlash(path)
+}
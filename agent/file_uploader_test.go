@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/buildkite/agent/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileUploaderCopiesArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source.txt")
+	if err := ioutil.WriteFile(source, []byte("llamas"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	destination := filepath.Join(dir, "artifacts")
+	if err := os.Mkdir(destination, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &FileUploader{}
+	assert.NoError(t, u.Setup("file://"+filepath.ToSlash(destination), false))
+
+	artifact := &api.Artifact{Path: "logs/llamas and alpacas.txt", AbsolutePath: source}
+	assert.NoError(t, u.Upload(artifact))
+
+	target := filepath.Join(destination, "logs", "llamas and alpacas.txt")
+	b, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "llamas", string(b))
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	}
+
+	// It can be downloaded again from its URL
+	downloads := filepath.Join(dir, "downloads")
+	assert.NoError(t, Download{
+		URL:         u.URL(artifact),
+		Path:        artifact.Path,
+		Destination: downloads,
+		FileDir:     u.Dir(),
+	}.Start())
+
+	b, err = ioutil.ReadFile(filepath.Join(downloads, "logs", "llamas and alpacas.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "llamas", string(b))
+}
+
+func TestFileUploaderRefusesPathsOutsideTheDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := &FileUploader{}
+	assert.NoError(t, u.Setup("file://"+filepath.ToSlash(dir), false))
+
+	artifact := &api.Artifact{Path: "../llamas.txt", AbsolutePath: filepath.Join(dir, "llamas.txt")}
+	assert.Error(t, u.Upload(artifact))
+}
+
+func TestDownloadRefusesFilesOutsideTheUploadDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret := filepath.Join(dir, "secret.txt")
+	if err := ioutil.WriteFile(secret, []byte("llamas"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts := filepath.Join(dir, "artifacts")
+	if err := os.Mkdir(artifacts, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &FileUploader{}
+	assert.NoError(t, u.Setup("file://"+filepath.ToSlash(artifacts), false))
+
+	for _, fileDir := range []string{"", u.Dir()} {
+		err := Download{
+			URL:         u.URL(&api.Artifact{Path: "../secret.txt"}),
+			Path:        "secret.txt",
+			Destination: filepath.Join(dir, "downloads"),
+			FileDir:     fileDir,
+		}.Start()
+		assert.Error(t, err, fileDir)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "downloads", "secret.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileUploaderNeedsADirectory(t *testing.T) {
+	u := &FileUploader{}
+	assert.Error(t, u.Setup("file:///this/does/not/exist", false))
+}
+
+func TestFileURLPath(t *testing.T) {
+	assert.Equal(t, filepath.FromSlash("/mnt/artifacts"), fileURLPath("file:///mnt/artifacts"))
+	assert.Equal(t, filepath.FromSlash("C:/artifacts"), fileURLPath("file:///C:/artifacts"))
+}
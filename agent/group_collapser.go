@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches the line that expands the previous group, which always needs to
+// make it into the log
+var headerExpansionRegex = regexp.MustCompile(`^\^\^\^\s+\+\+\+\s*$`)
+
+// GroupCollapser hides the lines of each log group past a limit, so that the
+// build page stays usable for extremely chatty steps. In place of the hidden
+// lines it writes a marker saying how many there were and where to find them.
+type GroupCollapser struct {
+	// The most lines to show from each group
+	MaxLines int
+
+	// The name of the artifact that has the full output
+	Artifact string
+
+	// The end of the output that isn't a whole line yet
+	partial string
+
+	// How many lines of the current group have been shown and hidden
+	shown  int
+	hidden int
+
+	// How many lines have been hidden across all groups
+	totalHidden int
+}
+
+// Collapse takes the next part of the output and returns what should be shown
+// of it. Lines are only returned once they're complete, so that headers can
+// always be found.
+func (c *GroupCollapser) Collapse(output string) string {
+	var b strings.Builder
+
+	c.partial += output
+	for {
+		i := strings.IndexByte(c.partial, '\n')
+		if i == -1 {
+			break
+		}
+
+		c.line(&b, c.partial[:i+1])
+		c.partial = c.partial[i+1:]
+	}
+
+	return b.String()
+}
+
+// Flush returns the rest of the output once there isn't going to be any more
+func (c *GroupCollapser) Flush() string {
+	var b strings.Builder
+
+	if c.partial != "" {
+		c.line(&b, c.partial)
+		c.partial = ""
+	}
+	c.finishGroup(&b)
+
+	return b.String()
+}
+
+// Hidden is how many lines have been hidden altogether
+func (c *GroupCollapser) Hidden() int {
+	return c.totalHidden
+}
+
+func (c *GroupCollapser) line(b *strings.Builder, line string) {
+	stripped := strings.TrimRight(ANSIColorRegex.ReplaceAllString(line, ""), "\r\n")
+
+	switch {
+	case len(stripped) < 500 && HeaderRegex.MatchString(stripped):
+		c.finishGroup(b)
+		b.WriteString(line)
+	case headerExpansionRegex.MatchString(stripped):
+		b.WriteString(line)
+	case c.shown < c.MaxLines:
+		c.shown++
+		b.WriteString(line)
+	default:
+		c.hidden++
+		c.totalHidden++
+	}
+}
+
+func (c *GroupCollapser) finishGroup(b *strings.Builder) {
+	if c.hidden > 0 {
+		fmt.Fprintf(b, "… %s more lines, see the %s artifact\n", formatCount(c.hidden), c.Artifact)
+	}
+
+	c.shown, c.hidden = 0, 0
+}
+
+// formatCount formats a number with commas between the thousands
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupCollapser(t *testing.T) {
+	c := &GroupCollapser{MaxLines: 2, Artifact: "job-1.log"}
+
+	// Split the output up mid-line, like the log streamer does
+	var collapsed string
+	for _, part := range []string{
+		"--- Installing\n1\n2\n3",
+		"\n4\n\x1b[90m+++ Testing\x1b[0m\r\nok\n",
+		"^^^ +++\nfailed",
+	} {
+		collapsed += c.Collapse(part)
+	}
+	collapsed += c.Flush()
+
+	expected := strings.Join([]string{
+		"--- Installing",
+		"1",
+		"2",
+		"… 2 more lines, see the job-1.log artifact",
+		"\x1b[90m+++ Testing\x1b[0m\r",
+		"ok",
+		"^^^ +++",
+		"failed",
+	}, "\n")
+
+	if collapsed != expected {
+		t.Fatalf("Expected %q, got %q", expected, collapsed)
+	}
+
+	if c.Hidden() != 2 {
+		t.Fatalf("Expected 2 hidden lines, got %d", c.Hidden())
+	}
+}
+
+func TestGroupCollapserMarksTheEndOfTheOutput(t *testing.T) {
+	c := &GroupCollapser{MaxLines: 1, Artifact: "job-1.log"}
+
+	collapsed := c.Collapse("~~~ Chatty\n" + strings.Repeat("line\n", 12346))
+	collapsed += c.Flush()
+
+	if !strings.HasSuffix(collapsed, "line\n… 12,345 more lines, see the job-1.log artifact\n") {
+		t.Fatalf("Unexpected collapsed output %q", collapsed)
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	for n, expected := range map[int]string{
+		0:       "0",
+		999:     "999",
+		1000:    "1,000",
+		12345:   "12,345",
+		1234567: "1,234,567",
+	} {
+		if actual := formatCount(n); actual != expected {
+			t.Errorf("formatCount(%d) = %q, expected %q", n, actual, expected)
+		}
+	}
+}
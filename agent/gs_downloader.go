@@ -1,15 +1,19 @@
 package agent
 
 import (
-	"context"
 	"errors"
 	"fmt"
+	"os"
 	"strings"
 
-	"golang.org/x/oauth2/google"
 	storage "google.golang.org/api/storage/v1"
 )
 
+// GSDownloader downloads artifacts from Google Cloud Storage. It uses the
+// service account key in $BUILDKITE_GS_APPLICATION_CREDENTIALS if there is
+// one, and otherwise the application default credentials, which include
+// $GOOGLE_APPLICATION_CREDENTIALS and the service account of the instance or
+// GKE workload identity.
 type GSDownloader struct {
 	// The name of the bucket
 	Bucket string
@@ -26,10 +30,18 @@ type GSDownloader struct {
 
 	// If failed responses should be dumped to the log
 	DebugHTTP bool
+
+	// The permissions of created directories and the downloaded file
+	DirMode  os.FileMode
+	FileMode os.FileMode
+
+	// How the file was compressed when it was uploaded, if it was
+	ContentEncoding string
 }
 
 func (d GSDownloader) Start() error {
-	client, err := google.DefaultClient(context.Background(), storage.DevstorageReadOnlyScope)
+	// Authenticate the same way as the uploader, so private buckets work
+	client, err := (&GSUploader{}).getClient(storage.DevstorageReadOnlyScope)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Error creating Google Cloud Storage client: %v", err))
 	}
@@ -38,12 +50,15 @@ func (d GSDownloader) Start() error {
 
 	// We can now cheat and pass the URL onto our regular downloader
 	return Download{
-		Client:      *client,
-		URL:         url,
-		Path:        d.Path,
-		Destination: d.Destination,
-		Retries:     d.Retries,
-		DebugHTTP:   d.DebugHTTP,
+		Client:          *client,
+		URL:             url,
+		Path:            d.Path,
+		Destination:     d.Destination,
+		Retries:         d.Retries,
+		DebugHTTP:       d.DebugHTTP,
+		DirMode:         d.DirMode,
+		FileMode:        d.FileMode,
+		ContentEncoding: d.ContentEncoding,
 	}.Start()
 }
 
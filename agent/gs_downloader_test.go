@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGSDownloaderBucketFileLocation(t *testing.T) {
+	d := GSDownloader{Bucket: "gs://my-bucket-name/foo/bar", Path: "llamas.txt"}
+	assert.Equal(t, "my-bucket-name", d.BucketName())
+	assert.Equal(t, "foo/bar/llamas.txt", d.BucketFileLocation())
+
+	d.Bucket = "gs://my-bucket-name"
+	assert.Equal(t, "llamas.txt", d.BucketFileLocation())
+}
+
+func TestGSDownloaderUsesTheUploaderCredentials(t *testing.T) {
+	os.Setenv("BUILDKITE_GS_APPLICATION_CREDENTIALS", filepath.Join(os.TempDir(), "this-does-not-exist.json"))
+	defer os.Unsetenv("BUILDKITE_GS_APPLICATION_CREDENTIALS")
+
+	err := GSDownloader{Bucket: "gs://my-bucket-name", Path: "llamas.txt"}.Start()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "this-does-not-exist.json")
+	}
+}
@@ -87,6 +87,7 @@ func (u *GSUploader) Upload(artifact *api.Artifact) error {
 		Name:               u.artifactPath(artifact),
 		ContentType:        u.mimeType(artifact),
 		ContentDisposition: u.contentDisposition(artifact),
+		ContentEncoding:    artifact.ContentEncoding,
 	}
 	file, err := os.Open(artifact.AbsolutePath)
 	if err != nil {
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// How often a running agent checks whether another is replacing it, and how
+// often the replacement checks whether it has finished
+const handoverPollInterval = 1 * time.Second
+
+// How often the replacement says it's still waiting
+const handoverProgressInterval = 1 * time.Minute
+
+// AgentHandover lets a new agent replace one that's already running on the
+// host without interrupting its job, like when the agent is being upgraded.
+//
+// The running agent keeps a file with its PID and name. A new agent asks it
+// to stop by creating a ".replace" file next to it, and the running agent
+// then finishes its job, disconnects and removes its file. The new agent
+// waits for that before registering with the same name.
+type AgentHandover struct {
+	Path string
+}
+
+type agentHandoverState struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"`
+}
+
+func (h AgentHandover) requestPath() string {
+	return h.Path + ".replace"
+}
+
+// Claim records that this process is the agent called name, for the next
+// agent to find
+func (h AgentHandover) Claim(name string) error {
+	b, err := json.Marshal(agentHandoverState{PID: os.Getpid(), Name: name})
+	if err != nil {
+		return err
+	}
+
+	// Any request was for the agent we've just replaced
+	os.Remove(h.requestPath())
+
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0777); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(h.Path, b, 0644)
+}
+
+// Release removes the file, if it's still this process's, to tell the agent
+// replacing us that we've finished
+func (h AgentHandover) Release() error {
+	state, err := h.read()
+	if err != nil || state.PID != os.Getpid() {
+		return nil
+	}
+
+	os.Remove(h.requestPath())
+	return os.Remove(h.Path)
+}
+
+// ReplaceRequested returns whether another agent has asked to replace this
+// one
+func (h AgentHandover) ReplaceRequested() bool {
+	_, err := os.Stat(h.requestPath())
+	return err == nil
+}
+
+// WatchForReplacement calls f once another agent asks to replace this one,
+// unless stop is closed first
+func (h AgentHandover) WatchForReplacement(stop <-chan struct{}, f func()) {
+	ticker := time.NewTicker(handoverPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if h.ReplaceRequested() {
+				f()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Replace asks the agent that's running to stop once it's finished its job,
+// waits for it, and returns its name so it can be registered again. If no
+// agent is running, the name is empty.
+func (h AgentHandover) Replace() (string, error) {
+	state, err := h.read()
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if !processRunning(state.PID) {
+		logger.Info("Agent \"%s\" (PID %d) isn't running any more, so there's nothing to replace", state.Name, state.PID)
+		os.Remove(h.Path)
+		return state.Name, nil
+	}
+
+	logger.Info("Asking agent \"%s\" (PID %d) to stop once it has finished its current job", state.Name, state.PID)
+
+	if err := ioutil.WriteFile(h.requestPath(), []byte(fmt.Sprintf("%d", os.Getpid())), 0644); err != nil {
+		return "", err
+	}
+
+	started := time.Now()
+	lastProgress := started
+
+	for {
+		if _, err := os.Stat(h.Path); os.IsNotExist(err) || !processRunning(state.PID) {
+			break
+		}
+
+		if time.Since(lastProgress) >= handoverProgressInterval {
+			logger.Info("Still waiting for agent \"%s\" (PID %d) to finish (%s so far)", state.Name, state.PID,
+				time.Since(started).Truncate(time.Second))
+			lastProgress = time.Now()
+		}
+
+		time.Sleep(handoverPollInterval)
+	}
+
+	logger.Info("Agent \"%s\" has stopped after %s", state.Name, time.Since(started).Truncate(time.Second))
+
+	// It's gone, so the files are ours to tidy up if it didn't
+	os.Remove(h.requestPath())
+	os.Remove(h.Path)
+
+	return state.Name, nil
+}
+
+func (h AgentHandover) read() (agentHandoverState, error) {
+	var state agentHandoverState
+
+	b, err := ioutil.ReadFile(h.Path)
+	if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(b, &state); err != nil {
+		return state, fmt.Errorf("Failed to read agent handover file %s: %v", h.Path, err)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplacingWithoutARunningAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := AgentHandover{Path: filepath.Join(dir, "agent.json")}
+
+	name, err := h.Replace()
+	assert.NoError(t, err)
+	assert.Equal(t, "", name)
+}
+
+func TestReplacingAnAgentThatHasGone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := AgentHandover{Path: filepath.Join(dir, "agent.json")}
+
+	// The highest PID on most systems, which won't be in use
+	if err := ioutil.WriteFile(h.Path, []byte(`{"pid": 4194304, "name": "llamas-1"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := h.Replace()
+	assert.NoError(t, err)
+	assert.Equal(t, "llamas-1", name)
+
+	_, err = os.Stat(h.Path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReplacingARunningAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := AgentHandover{Path: filepath.Join(dir, "agent.json")}
+
+	if err := h.Claim("llamas-1"); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, h.ReplaceRequested())
+
+	// Play the part of the running agent
+	stop := make(chan struct{})
+	defer close(stop)
+
+	replaced := make(chan struct{})
+	go h.WatchForReplacement(stop, func() {
+		close(replaced)
+		time.Sleep(100 * time.Millisecond)
+		h.Release()
+	})
+
+	name, err := h.Replace()
+	assert.NoError(t, err)
+	assert.Equal(t, "llamas-1", name)
+
+	select {
+	case <-replaced:
+	default:
+		t.Fatal("Expected the running agent to be asked to stop")
+	}
+
+	assert.False(t, h.ReplaceRequested())
+}
@@ -0,0 +1,25 @@
+package agent
+
+import (
+	"syscall"
+)
+
+// The exit code of a process that's still running
+const stillActive = 259
+
+// processRunning returns whether there's a process with the PID that hasn't
+// exited yet
+func processRunning(pid int) bool {
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+
+	return code == stillActive
+}
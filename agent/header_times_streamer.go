@@ -2,10 +2,13 @@ package agent
 
 import (
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/buildkite/agent/api"
 	"github.com/buildkite/agent/logger"
 )
 
@@ -13,6 +16,13 @@ import (
 // buildkite.com frontend logic, too
 
 var HeaderRegex = regexp.MustCompile("^(?:---|\\+\\+\\+|~~~)\\s(.+)?$")
+
+// Nested headers are indented further than the header they belong to
+var NestedHeaderRegex = regexp.MustCompile("^([ \\t]*)(?:---|\\+\\+\\+|~~~)\\s(.+)?$")
+
+// The results go test prints for each test look like headers, but aren't
+var GoTestResultRegex = regexp.MustCompile(`^[ \t]*--- (?:PASS|FAIL|SKIP): `)
+
 var ANSIColorRegex = regexp.MustCompile(`\x1b\[([;\d]+)?[mK]`)
 
 type HeaderTimesStreamer struct {
@@ -40,6 +50,17 @@ type HeaderTimesStreamer struct {
 	// We store the last index we uploaded to, so we don't have to keep
 	// uploading the same times
 	cursor int
+
+	// Every header, including nested ones, for working out how long each
+	// group took
+	headers      []header
+	headersMutex sync.Mutex
+}
+
+type header struct {
+	name   string
+	indent int
+	time   time.Time
 }
 
 func (h *HeaderTimesStreamer) Start() error {
@@ -76,18 +97,104 @@ func (h *HeaderTimesStreamer) Scan(line string) {
 	h.scanWaitGroup.Add(1)
 	defer h.scanWaitGroup.Done()
 
-	if h.LineIsHeader(line) {
-		logger.Debug("[HeaderTimesStreamer] Found header %q", line)
+	if !h.LineIsHeader(line) {
+		return
+	}
+
+	now := time.Now().UTC()
+	name, indent := parseHeader(line)
+
+	h.headersMutex.Lock()
+	h.headers = append(h.headers, header{name: name, indent: indent, time: now})
+	h.headersMutex.Unlock()
+
+	// Only headers that aren't indented are shown as groups in the log, so
+	// they're the only ones that get a header time
+	if indent > 0 {
+		logger.Debug("[HeaderTimesStreamer] Found nested header %q", line)
+		return
+	}
+
+	logger.Debug("[HeaderTimesStreamer] Found header %q", line)
+
+	// Aquire a lock on the times and then add the current time to
+	// our times slice.
+	h.timesMutex.Lock()
+	h.times = append(h.times, now.Format(time.RFC3339Nano))
+	h.timesMutex.Unlock()
+
+	// Add the time to the wait group
+	h.uploadWaitGroup.Add(1)
+}
+
+// Timings returns how long each group took, with nested groups beneath the
+// one they're in. Groups that are still open are treated as finishing at
+// finishedAt. It should be called after the streamer has been stopped.
+func (h *HeaderTimesStreamer) Timings(finishedAt time.Time) []*api.HeaderTiming {
+	h.headersMutex.Lock()
+	headers := make([]header, len(h.headers))
+	copy(headers, h.headers)
+	h.headersMutex.Unlock()
+
+	// Lines are scanned concurrently, so the headers are put back in the
+	// order they were found
+	sort.SliceStable(headers, func(i, j int) bool {
+		return headers[i].time.Before(headers[j].time)
+	})
+
+	var roots []*api.HeaderTiming
+	var open []*api.HeaderTiming
+	var started []time.Time
+	var indents []int
+
+	// Finishes the open groups that are indented at least as far as indent
+	finish := func(indent int, at time.Time) {
+		for len(open) > 0 && indents[len(indents)-1] >= indent {
+			last := len(open) - 1
+			open[last].FinishedAt = at.UTC().Format(time.RFC3339Nano)
+			open[last].DurationMS = float64(at.Sub(started[last])) / float64(time.Millisecond)
+			open, started, indents = open[:last], started[:last], indents[:last]
+		}
+	}
+
+	// A header is nested in every open group that's indented less than it,
+	// however far that is
+	for _, hdr := range headers {
+		finish(hdr.indent, hdr.time)
 
-		// Aquire a lock on the times and then add the current time to
-		// our times slice.
-		h.timesMutex.Lock()
-		h.times = append(h.times, time.Now().UTC().Format(time.RFC3339Nano))
-		h.timesMutex.Unlock()
+		timing := &api.HeaderTiming{
+			Name:      hdr.name,
+			Depth:     len(open),
+			StartedAt: hdr.time.Format(time.RFC3339Nano),
+		}
 
-		// Add the time to the wait group
-		h.uploadWaitGroup.Add(1)
+		if len(open) > 0 {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, timing)
+		} else {
+			roots = append(roots, timing)
+		}
+
+		open = append(open, timing)
+		started = append(started, hdr.time)
+		indents = append(indents, hdr.indent)
 	}
+
+	finish(0, finishedAt)
+
+	return roots
+}
+
+// parseHeader returns the name of the header and how far it's indented.
+// Indentation only says which headers are nested in which, as jobs indent by
+// any amount.
+func parseHeader(line string) (string, int) {
+	matches := NestedHeaderRegex.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if matches == nil {
+		return "", 0
+	}
+
+	return strings.TrimSpace(matches[2]), len(matches[1])
 }
 
 func (h *HeaderTimesStreamer) Upload() {
@@ -150,5 +257,5 @@ func (h *HeaderTimesStreamer) LinePreProcessor(line string) string {
 func (h *HeaderTimesStreamer) LineIsHeader(line string) bool {
 	// To avoid running the regex over every single line, we'll first do a
 	// length check. Hopefully there are no heeaders over 500 characters!
-	return len(line) < 500 && HeaderRegex.MatchString(line)
+	return len(line) < 500 && NestedHeaderRegex.MatchString(line) && !GoTestResultRegex.MatchString(line)
 }
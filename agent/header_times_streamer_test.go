@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeaderTimesStreamerNestedTimings(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	h := &HeaderTimesStreamer{}
+
+	// Scanned out of order, like the concurrent line callbacks can be
+	h.headers = []header{
+		{name: "Compiling", indent: 2, time: at(100)},
+		{name: "Building", indent: 0, time: at(0)},
+		{name: "Linking", indent: 2, time: at(1500)},
+		{name: "Testing", indent: 0, time: at(2000)},
+		{name: "Unit", indent: 4, time: at(2250)},
+	}
+
+	roots := h.Timings(at(3000))
+	if len(roots) != 2 {
+		t.Fatalf("Expected 2 top level groups, got %d", len(roots))
+	}
+
+	building, tests := roots[0], roots[1]
+	if building.Name != "Building" || building.DurationMS != 2000 || len(building.Children) != 2 {
+		t.Fatalf("Unexpected building group %#v", building)
+	}
+	if c := building.Children[0]; c.Name != "Compiling" || c.DurationMS != 1400 || c.FinishedAt != at(1500).Format(time.RFC3339Nano) {
+		t.Fatalf("Unexpected compiling group %#v", c)
+	}
+	if c := building.Children[1]; c.Name != "Linking" || c.DurationMS != 500 {
+		t.Fatalf("Unexpected linking group %#v", c)
+	}
+	if tests.DurationMS != 1000 || len(tests.Children) != 1 || tests.Children[0].DurationMS != 750 {
+		t.Fatalf("Unexpected testing group %#v", tests)
+	}
+}
+
+func TestHeaderTimesStreamerDepthFollowsNesting(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	h := &HeaderTimesStreamer{}
+
+	// Indented by four spaces, then by a tab, then back out to four
+	for i, line := range []string{
+		"--- Building",
+		"    --- Compiling",
+		"    \t--- Optimising",
+		"    --- Linking",
+	} {
+		name, indent := parseHeader(line)
+		h.headers = append(h.headers, header{name: name, indent: indent, time: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	roots := h.Timings(start.Add(time.Minute))
+	if len(roots) != 1 || len(roots[0].Children) != 2 {
+		t.Fatalf("Expected 1 top level group with 2 children, got %#v", roots)
+	}
+
+	compiling, linking := roots[0].Children[0], roots[0].Children[1]
+	if compiling.Name != "Compiling" || compiling.Depth != 1 || len(compiling.Children) != 1 {
+		t.Fatalf("Unexpected compiling group %#v", compiling)
+	}
+	if c := compiling.Children[0]; c.Name != "Optimising" || c.Depth != 2 {
+		t.Fatalf("Unexpected optimising group %#v", c)
+	}
+	if linking.Name != "Linking" || linking.Depth != 1 {
+		t.Fatalf("Unexpected linking group %#v", linking)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	for line, expected := range map[string]struct {
+		name   string
+		indent int
+	}{
+		"--- Building":          {"Building", 0},
+		"+++ :rspec: Testing\r": {":rspec: Testing", 0},
+		"  ~~~ Compiling":       {"Compiling", 2},
+		"\t\t--- Linking":       {"Linking", 2},
+	} {
+		name, indent := parseHeader(line)
+		if name != expected.name || indent != expected.indent {
+			t.Errorf("parseHeader(%q) = %q, %d, expected %q, %d", line, name, indent, expected.name, expected.indent)
+		}
+	}
+}
+
+func TestHeaderTimesStreamerOnlyTimesTopLevelHeaders(t *testing.T) {
+	h := &HeaderTimesStreamer{}
+	h.Scan("--- Building")
+	h.Scan("  --- Compiling")
+	h.Scan("not a header")
+
+	if len(h.times) != 1 {
+		t.Fatalf("Expected 1 header time, got %d", len(h.times))
+	}
+	if len(h.headers) != 2 {
+		t.Fatalf("Expected 2 headers, got %d", len(h.headers))
+	}
+}
+
+func TestHeaderTimesStreamerIgnoresGoTestResults(t *testing.T) {
+	h := &HeaderTimesStreamer{}
+
+	for _, line := range []string{
+		"--- PASS: TestParseHeader (0.00s)",
+		"--- FAIL: TestHeaderTimes (0.01s)",
+		"    --- SKIP: TestHeaderTimes/nested (0.00s)",
+	} {
+		if h.LineIsHeader(line) {
+			t.Errorf("Expected %q not to be a header", line)
+		}
+	}
+
+	if !h.LineIsHeader("--- PASSing the tests") {
+		t.Error("Expected headers that only start like go test results to still be headers")
+	}
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConcurrencyLimit is how many jobs agents with a tag can run at once across
+// the whole host, like only one at a time for agents tagged resource=gpu
+type ConcurrencyLimit struct {
+	Tag string
+	Max int
+}
+
+// ParseConcurrencyLimit parses a limit in the form "tag:max", e.g.
+// "resource=gpu:1"
+func ParseConcurrencyLimit(s string) (ConcurrencyLimit, error) {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 {
+		return ConcurrencyLimit{}, fmt.Errorf("Invalid concurrency limit %q, expected it to be in the form \"tag:max\"", s)
+	}
+
+	max, err := strconv.Atoi(s[i+1:])
+	if err != nil || max < 1 {
+		return ConcurrencyLimit{}, fmt.Errorf("Invalid concurrency limit %q, the maximum must be a number above 0", s)
+	}
+
+	return ConcurrencyLimit{Tag: s[:i], Max: max}, nil
+}
+
+// HostSemaphoreDir is where the lock files for host-wide concurrency limits
+// go. It's in the build path rather than somewhere like /tmp, so that only
+// the agent's user can create or hold the locks, and agents on the host
+// coordinate as long as they share a build path.
+func HostSemaphoreDir(buildPath string) string {
+	return filepath.Join(buildPath, ".semaphores")
+}
+
+// HostSemaphore limits how many holders there can be across every agent
+// process on the host, using a locked file for each of its slots. The locks
+// belong to the open file rather than the process, so they're released if
+// the agent dies, and agent workers in the same process can't take the
+// same slot.
+type HostSemaphore struct {
+	Dir  string
+	Name string
+	Max  int
+}
+
+// HostSemaphoreSlot is a slot of a HostSemaphore that's been acquired
+type HostSemaphoreSlot struct {
+	file *os.File
+}
+
+var semaphoreNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// TryAcquire takes a free slot of the semaphore without waiting, returning
+// nil if they're all taken
+func (s HostSemaphore) TryAcquire() (*HostSemaphoreSlot, error) {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	name := semaphoreNameRegex.ReplaceAllString(s.Name, "-")
+
+	for i := 0; i < s.Max; i++ {
+		path := filepath.Join(s.Dir, fmt.Sprintf("%s.%d.lock", name, i))
+
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if !locked {
+			file.Close()
+			continue
+		}
+
+		return &HostSemaphoreSlot{file: file}, nil
+	}
+
+	return nil, nil
+}
+
+// Release gives the slot back so something else can acquire it
+func (s *HostSemaphoreSlot) Release() error {
+	if err := unlockFile(s.file); err != nil {
+		s.file.Close()
+		return err
+	}
+
+	return s.file.Close()
+}
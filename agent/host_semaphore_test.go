@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseConcurrencyLimit(t *testing.T) {
+	limit, err := ParseConcurrencyLimit("resource=gpu:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit.Tag != "resource=gpu" || limit.Max != 2 {
+		t.Fatalf("Unexpected limit %#v", limit)
+	}
+
+	for _, s := range []string{"resource=gpu", ":1", "gpu:0", "gpu:lots"} {
+		if _, err := ParseConcurrencyLimit(s); err == nil {
+			t.Errorf("Expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestHostSemaphoreLimitsHolders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "semaphores")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sem := HostSemaphore{Dir: dir, Name: "resource=gpu", Max: 2}
+
+	first, err := sem.TryAcquire()
+	if err != nil || first == nil {
+		t.Fatalf("Expected to acquire the first slot, got %v, %v", first, err)
+	}
+
+	second, err := sem.TryAcquire()
+	if err != nil || second == nil {
+		t.Fatalf("Expected to acquire the second slot, got %v, %v", second, err)
+	}
+
+	if third, err := sem.TryAcquire(); err != nil || third != nil {
+		t.Fatalf("Expected the semaphore to be full, got %v, %v", third, err)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := sem.TryAcquire()
+	if err != nil || again == nil {
+		t.Fatalf("Expected to acquire the released slot, got %v, %v", again, err)
+	}
+
+	again.Release()
+	second.Release()
+}
+
+func TestHostSemaphoreDirIsPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't have unix permissions")
+	}
+
+	buildPath, err := ioutil.TempDir("", "builds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildPath)
+
+	dir := HostSemaphoreDir(buildPath)
+	if filepath.Dir(dir) != buildPath {
+		t.Fatalf("Expected the semaphores to be in the build path, got %s", dir)
+	}
+
+	slot, err := HostSemaphore{Dir: dir, Name: "resource=gpu", Max: 1}.TryAcquire()
+	if err != nil || slot == nil {
+		t.Fatalf("Expected to acquire a slot, got %v, %v", slot, err)
+	}
+	defer slot.Release()
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Fatalf("Expected only the agent's user to be able to use the semaphores, got %o", perm)
+	}
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Output that means the job couldn't talk to the Docker daemon, which is a
+// problem with the agent's host rather than the job
+var dockerUnreachableMessages = []string{
+	"Cannot connect to the Docker daemon",
+	"error during connect: This error may indicate that the docker daemon is not running",
+}
+
+// dockerDaemonReachable checks whether the agent can reach the Docker daemon
+// itself, as anything can print that it can't. It's a variable so that tests
+// can replace it.
+var dockerDaemonReachable = func() bool {
+	_, err := preflightCommand("docker", "version", "--format", "{{.Server.Version}}")
+	return err == nil
+}
+
+// The exit status that a job is finished with when its process couldn't be
+// started at all. Buildkite shows -1 for jobs that failed because of the
+// agent rather than the build, which automatic retry rules can match on.
+const startFailedExitStatus = "-1"
+
+// ParseExitCodes turns a list of exit codes from the config into ints
+func ParseExitCodes(codes []string) ([]int, error) {
+	var parsed []int
+
+	for _, code := range codes {
+		i, err := strconv.Atoi(strings.TrimSpace(code))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid exit code %q", code)
+		}
+		parsed = append(parsed, i)
+	}
+
+	return parsed, nil
+}
+
+// infrastructureFailure works out whether a job failed because of the agent's
+// infrastructure rather than the job itself, and returns why. An empty
+// reason means it was a normal failure (or it didn't fail at all).
+func infrastructureFailure(exitStatus string, output string, bootstrapReason string, exitCodes []int) string {
+	if exitStatus == "0" {
+		return ""
+	}
+
+	// The bootstrap records failures it knows are the infrastructure's fault,
+	// like not being able to check out the repository
+	if bootstrapReason != "" {
+		return bootstrapReason
+	}
+
+	if status, err := strconv.Atoi(exitStatus); err == nil {
+		for _, code := range exitCodes {
+			if status == code {
+				return fmt.Sprintf("exited with status %d", status)
+			}
+		}
+	}
+
+	for _, message := range dockerUnreachableMessages {
+		if strings.Contains(output, message) && !dockerDaemonReachable() {
+			return "the Docker daemon is unreachable"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"os"
+	"testing"
+)
+
+func TestInfrastructureFailure(t *testing.T) {
+	defer func(original func() bool) { dockerDaemonReachable = original }(dockerDaemonReachable)
+	dockerDaemonReachable = func() bool { return false }
+
+	for _, tc := range []struct {
+		name       string
+		exitStatus string
+		output     string
+		reason     string
+		expected   string
+	}{
+		{"success", "0", "Cannot connect to the Docker daemon", "the checkout failed", ""},
+		{"user failure", "1", "FAIL: TestThings", "", ""},
+		{"configured exit code", "94", "", "", "exited with status 94"},
+		{"docker unreachable", "1", "docker: Cannot connect to the Docker daemon at unix:///var/run/docker.sock.", "", "the Docker daemon is unreachable"},
+		{"bootstrap reason", "1", "", "the checkout failed", "the checkout failed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if reason := infrastructureFailure(tc.exitStatus, tc.output, tc.reason, []int{94, 255}); reason != tc.expected {
+				t.Fatalf("Expected %q, got %q", tc.expected, reason)
+			}
+		})
+	}
+}
+
+func TestParseExitCodes(t *testing.T) {
+	codes, err := ParseExitCodes([]string{"94", " 255"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || codes[0] != 94 || codes[1] != 255 {
+		t.Fatalf("Unexpected exit codes %v", codes)
+	}
+
+	if _, err := ParseExitCodes([]string{"nope"}); err == nil {
+		t.Fatalf("Expected an error for an invalid exit code")
+	}
+}
+
+func TestDockerMessagesAreCheckedByTheAgent(t *testing.T) {
+	defer func(original func() bool) { dockerDaemonReachable = original }(dockerDaemonReachable)
+	dockerDaemonReachable = func() bool { return true }
+
+	// A job can print whatever it likes, so it's only believed if the
+	// agent can't reach the daemon either
+	if reason := infrastructureFailure("1", "Cannot connect to the Docker daemon", "", nil); reason != "" {
+		t.Fatalf("Expected no infrastructure failure, got %q", reason)
+	}
+}
+
+func TestReadInfraFailureDrainsThePipe(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+
+	r := &JobRunner{infraFailureReader: reader, infraFailureWriter: writer}
+
+	if reason := r.readInfraFailure(); reason != "" {
+		t.Fatalf("Expected no reason before anything was written, got %q", reason)
+	}
+
+	writer.WriteString("the checkout failed\n")
+	if reason := r.readInfraFailure(); reason != "the checkout failed" {
+		t.Fatalf("Expected the bootstrap's reason, got %q", reason)
+	}
+
+	// The next attempt starts afresh
+	if reason := r.readInfraFailure(); reason != "" {
+		t.Fatalf("Expected the pipe to be drained, got %q", reason)
+	}
+}
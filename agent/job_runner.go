@@ -2,22 +2,38 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/events"
 	"github.com/buildkite/agent/experiments"
 	"github.com/buildkite/agent/logger"
 	"github.com/buildkite/agent/process"
 	"github.com/buildkite/agent/retry"
+	"github.com/buildkite/agent/sandbox"
+	"github.com/buildkite/agent/tracing"
 	"github.com/buildkite/shellwords"
 )
 
+// Jobs that write more output than this get a warning in the agent's log
+const largeJobOutputBytes = 1024 * 1024 * 1024
+
+// How long a line of timestamped output can be left unfinished before what
+// there is of it is put in the log
+const timestampedPartialLineFlushInterval = 5 * time.Second
+
+// How long a chunk upload can take before it's abandoned and tried again
+var chunkUploadTimeout = 60 * time.Second
+
 type JobRunner struct {
 	// The job being run
 	Job *api.Job
@@ -37,6 +53,13 @@ type JobRunner struct {
 	// The configuration of the agent from the CLI
 	AgentConfiguration *AgentConfiguration
 
+	// Where job lifecycle events are sent
+	Events *events.Bus
+
+	// The span covering the whole job, which each phase gets a child span of
+	Span      *tracing.Span
+	phaseSpan *tracing.Span
+
 	// Go context for goroutine supervision
 	context       context.Context
 	contextCancel context.CancelFunc
@@ -53,6 +76,9 @@ type JobRunner struct {
 	// If the job is being cancelled
 	cancelled bool
 
+	// The IDs of the signals that have already been sent to the job
+	deliveredSignals map[string]bool
+
 	// Used to wait on various routines that we spin up
 	routineWaitGroup sync.WaitGroup
 
@@ -61,6 +87,49 @@ type JobRunner struct {
 
 	// File containing a copy of the job env
 	envFile *os.File
+
+	// File with all of the job's output, for when not all of it is sent
+	// to the log
+	fullLogFile *os.File
+
+	// File with the env for a job that runs in a Kubernetes pod or with an
+	// isolation backend
+	jobEnvFile string
+
+	// File the bootstrap writes the duration of each phase to
+	phaseTimingsFile string
+
+	// Pipe the bootstrap writes to when it fails because of the agent's
+	// infrastructure rather than the job. It's a pipe rather than a file so
+	// that the job can't get at it.
+	infraFailureReader *os.File
+	infraFailureWriter *os.File
+
+	// Pipe the bootstrap writes its audit log entries to, which the agent
+	// appends to the job's audit log
+	auditLogReader *os.File
+	auditLogWriter *os.File
+	auditLogDone   chan error
+
+	// File the meta-data commands cache values in for the job
+	metaDataCacheFile string
+
+	// File artifact uploads keep count of the job's artifact bytes in
+	artifactBytesFile string
+
+	// The output of earlier attempts at running the job, which were retried
+	// after infrastructure failures
+	previousOutput string
+
+	// Whether the job's process couldn't be started at all
+	startFailed bool
+
+	// Logs lines with the agent, job and current phase attached
+	logger *logger.Logger
+
+	// The phase the job is currently in, and when it started
+	phase          string
+	phaseStartedAt time.Time
 }
 
 // Initializes the job runner
@@ -69,6 +138,14 @@ func (r JobRunner) Create() (runner *JobRunner, err error) {
 
 	runner.context, runner.contextCancel = context.WithCancel(context.Background())
 
+	runner.logger = logger.WithFields(logger.Fields{
+		"agent": r.Agent.Name,
+		"job":   r.Job.ID,
+	})
+	runner.setPhase("preparing")
+
+	runner.deliveredSignals = map[string]bool{}
+
 	// Our own APIClient using the endpoint and the agents access token
 	runner.APIClient = APIClient{Endpoint: r.Endpoint, Token: r.Agent.AccessToken}.Create()
 
@@ -82,8 +159,47 @@ func (r JobRunner) Create() (runner *JobRunner, err error) {
 	// the Buildkite Agent API
 	runner.logStreamer = LogStreamer{MaxChunkSizeBytes: r.Job.ChunksMaxSizeBytes, Callback: r.onUploadChunk}.New()
 
-	// Start a proxy to give to the job for api operations
-	if experiments.IsEnabled("agent-socket") {
+	// Look for secrets in the output before it's sent anywhere
+	if mode := r.AgentConfiguration.SecretScanning; mode != "" && mode != SecretScanningOff {
+		scanner := &OutputScanner{Mode: mode, OnDetect: runner.onSecretDetected}
+		runner.logStreamer.Filter = scanner.Scan
+	}
+
+	// Hide the lines of groups that go on for too long
+	if r.AgentConfiguration.CollapseGroupsAfter > 0 {
+		runner.logStreamer.Collapser = &GroupCollapser{
+			MaxLines: r.AgentConfiguration.CollapseGroupsAfter,
+			Artifact: runner.fullLogArtifact(),
+		}
+	}
+
+	// Stop sending the log once it gets too big
+	if max := r.AgentConfiguration.MaxLogSizeBytes; max > 0 {
+		runner.logStreamer.MaxSizeBytes = max
+		runner.logStreamer.TruncationNotice = fmt.Sprintf(
+			"\n\n⚠️ The log has reached the %.1f MB limit, so the rest of it isn't shown here. The full log will be uploaded as the %s artifact once the job finishes.\n",
+			float64(max)/1024/1024, runner.fullLogArtifact())
+	}
+
+	// Keep the full log on disk when not all of it will be sent, so it can
+	// be uploaded as an artifact instead
+	if runner.logStreamer.Collapser != nil || runner.logStreamer.MaxSizeBytes > 0 {
+		if file, err := ioutil.TempFile("", fmt.Sprintf("job-log-%s", runner.Job.ID)); err != nil {
+			return runner, err
+		} else {
+			r.logger.Debug("[JobRunner] Created full log file: %s", file.Name())
+			runner.fullLogFile = file
+			runner.logStreamer.Tee = file
+		}
+	}
+
+	// Start a proxy to give to the job for api operations, with a token
+	// that only works for this job's own resources
+	if runner.usesAPIProxy() {
+		runner.APIProxy.Scope(jobTokenScope(runner.Job)...)
+		if r.AgentConfiguration.Isolation != "" {
+			runner.APIProxy.ListenOnTCP(r.AgentConfiguration.IsolationAPIProxyAddress)
+		}
 		if err := r.APIProxy.Listen(); err != nil {
 			return nil, err
 		}
@@ -93,10 +209,57 @@ func (r JobRunner) Create() (runner *JobRunner, err error) {
 	if file, err := ioutil.TempFile("", fmt.Sprintf("job-env-%s", runner.Job.ID)); err != nil {
 		return runner, err
 	} else {
-		logger.Debug("[JobRunner] Created env file: %s", file.Name())
+		r.logger.Debug("[JobRunner] Created env file: %s", file.Name())
 		runner.envFile = file
 	}
 
+	// Prepare a file for the bootstrap to write its phase timings to
+	if file, err := ioutil.TempFile("", fmt.Sprintf("job-timings-%s", runner.Job.ID)); err != nil {
+		return runner, err
+	} else {
+		file.Close()
+		r.logger.Debug("[JobRunner] Created phase timings file: %s", file.Name())
+		runner.phaseTimingsFile = file.Name()
+	}
+
+	// Prepare a file for meta-data commands to cache values in
+	if file, err := ioutil.TempFile("", fmt.Sprintf("job-meta-data-%s", runner.Job.ID)); err != nil {
+		return runner, err
+	} else {
+		file.Close()
+		runner.metaDataCacheFile = file.Name()
+	}
+
+	// Prepare a file for artifact uploads to count the job's bytes in
+	if r.AgentConfiguration.MaxArtifactBytesPerJob > 0 {
+		if file, err := ioutil.TempFile("", fmt.Sprintf("job-artifact-bytes-%s", runner.Job.ID)); err != nil {
+			return runner, err
+		} else {
+			file.Close()
+			runner.artifactBytesFile = file.Name()
+		}
+	}
+
+	// Prepare a pipe for the bootstrap to explain infrastructure failures
+	// on. Pods, isolated jobs and Windows can't inherit it, so only exit
+	// codes and output are used to spot them there.
+	if r.AgentConfiguration.InfraFailureRetries > 0 && runner.canInheritFiles() {
+		if reader, writer, err := os.Pipe(); err != nil {
+			return runner, err
+		} else {
+			runner.infraFailureReader = reader
+			runner.infraFailureWriter = writer
+		}
+	}
+
+	// Prepare a pipe for the bootstrap to record what it runs on. Where it
+	// can't be inherited, the bootstrap appends to the audit log itself.
+	if r.AgentConfiguration.AuditLogPath != "" && runner.canInheritFiles() {
+		if err := runner.startAuditLog(); err != nil {
+			return runner, err
+		}
+	}
+
 	env, err := r.createEnvironment()
 	if err != nil {
 		return nil, err
@@ -109,24 +272,178 @@ func (r JobRunner) Create() (runner *JobRunner, err error) {
 			r.AgentConfiguration.BootstrapScript, err)
 	}
 
+	// Confine the bootstrap, and everything it runs, with any security
+	// modules that have been configured
+	cmd = sandbox.Wrap(cmd, r.AgentConfiguration.AppArmorProfile, r.AgentConfiguration.SELinuxContext)
+
+	// Run the bootstrap in its own pod instead
+	if r.AgentConfiguration.Executor == ExecutorKubernetes {
+		if cmd, env, err = runner.kubernetesCommand(env); err != nil {
+			return nil, err
+		}
+	}
+
+	// Or with the isolation backend, like in a microVM
+	if r.AgentConfiguration.Isolation != "" {
+		if cmd, env, err = runner.isolatedCommand(env); err != nil {
+			return nil, err
+		}
+	}
+
+	// The bootstrap runs as the job's user, so give it what it writes to
+	if r.AgentConfiguration.JobUser != "" {
+		if err := runner.chownForJobUser(); err != nil {
+			return nil, err
+		}
+	}
+
 	// The process that will run the bootstrap script
-	runner.process = &process.Process{
+	runner.process = runner.newProcess(cmd, env)
+
+	return
+}
+
+// Gives the job's user the files the agent created for the bootstrap, and
+// the build path it checks out into. They're created by the agent, which is
+// usually root when it's running jobs as another user.
+func (r *JobRunner) chownForJobUser() error {
+	uid, gid, err := process.LookupUserIDs(r.AgentConfiguration.JobUser)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(r.AgentConfiguration.BuildPath, 0777); err != nil {
+		return err
+	}
+
+	paths := []string{
+		r.AgentConfiguration.BuildPath,
+		r.envFile.Name(),
+		r.phaseTimingsFile,
+		r.metaDataCacheFile,
+		r.artifactBytesFile,
+		r.jobEnvFile,
+	}
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("Failed to give %s to the job's user: %v", path, err)
+		}
+	}
+
+	// The proxy's socket is only usable by its owner
+	if r.usesAPIProxy() {
+		if err := r.APIProxy.Chown(uid, gid); err != nil {
+			return fmt.Errorf("Failed to give the API proxy's socket to the job's user: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Creates the process that runs the bootstrap script
+func (r *JobRunner) newProcess(cmd []string, env []string) *process.Process {
+	p := &process.Process{
 		Script:             cmd,
 		Env:                env,
 		PTY:                r.AgentConfiguration.RunInPty,
 		Timestamp:          r.AgentConfiguration.TimestampLines,
+		TimestampFormat:    r.AgentConfiguration.TimestampLinesFormat,
+		TimestampLocation:  r.AgentConfiguration.TimestampLinesLocation,
+		CPUWeight:          r.AgentConfiguration.JobCPUWeight,
+		MemoryMax:          r.AgentConfiguration.JobMemoryMax,
+		CgroupParent:       r.AgentConfiguration.JobCgroupParent,
+		KillSequence:       r.AgentConfiguration.KillSequence,
+		EnvAllowlist:       r.AgentConfiguration.JobEnvAllowlist,
+		EnvDenylist:        r.AgentConfiguration.JobEnvDenylist,
+		User:               r.AgentConfiguration.JobUser,
+		IdleTimeout:        r.AgentConfiguration.JobIdleTimeout,
+		IdleCallback:       r.onProcessIdle,
 		StartCallback:      r.onProcessStartCallback,
-		LineCallback:       runner.headerTimesStreamer.Scan,
-		LinePreProcessor:   runner.headerTimesStreamer.LinePreProcessor,
-		LineCallbackFilter: runner.headerTimesStreamer.LineIsHeader,
+		LineCallback:       r.headerTimesStreamer.Scan,
+		LineProcessors:     []process.LineProcessor{r.headerTimesStreamer.LinePreProcessor},
+		LineCallbackFilter: r.headerTimesStreamer.LineIsHeader,
 	}
 
-	return
+	// Timestamped output only has whole lines, so things like progress
+	// bars wouldn't show up in the log until they'd finished
+	if r.AgentConfiguration.TimestampLines {
+		p.PartialLineFlushInterval = timestampedPartialLineFlushInterval
+	}
+
+	p.ExtraFiles = r.inheritedFiles()
+
+	return p
+}
+
+// The pipes the bootstrap inherits, which it gets as file descriptors 3
+// onwards in this order
+func (r *JobRunner) inheritedFiles() []*os.File {
+	var files []*os.File
+	for _, f := range []*os.File{r.infraFailureWriter, r.auditLogWriter} {
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// The file descriptor the bootstrap inherits f as
+func (r *JobRunner) inheritedFD(f *os.File) string {
+	for i, inherited := range r.inheritedFiles() {
+		if inherited == f {
+			return strconv.Itoa(3 + i)
+		}
+	}
+	return ""
+}
+
+// All of the job's output, including from any attempts that were retried
+func (r *JobRunner) output() string {
+	return r.previousOutput + r.process.Output()
+}
+
+// The name of the artifact the full log is uploaded as
+func (r *JobRunner) fullLogArtifact() string {
+	return fmt.Sprintf("job-%s.log", r.Job.ID)
+}
+
+// Uploads the full log as an artifact, if some of it was left out of the log
+// that was streamed, then removes it
+func (r *JobRunner) uploadFullLog() error {
+	defer func() {
+		if err := os.Remove(r.fullLogFile.Name()); err != nil {
+			r.logger.Warn("[JobRunner] Error cleaning up full log file: %s", err)
+		}
+	}()
+
+	if err := r.fullLogFile.Close(); err != nil {
+		return err
+	}
+
+	collapsed := r.logStreamer.Collapser != nil && r.logStreamer.Collapser.Hidden() > 0
+	if !collapsed && !r.logStreamer.Truncated() {
+		return nil
+	}
+
+	uploader := &ArtifactUploader{APIClient: r.APIClient, JobID: r.Job.ID}
+
+	name := r.fullLogArtifact()
+	artifact, err := uploader.build(name, r.fullLogFile.Name(), name)
+	if err != nil {
+		return err
+	}
+
+	return uploader.upload([]*api.Artifact{artifact})
 }
 
 // Runs the job
 func (r *JobRunner) Run() error {
-	logger.Info("Starting job %s", r.Job.ID)
+	r.logger.Info("Starting job %s", r.Job.ID)
+	r.setPhase("starting")
 
 	// Start the build in the Buildkite Agent API. This is the first thing
 	// we do so if it fails, we don't have to worry about cleaning things
@@ -135,6 +452,8 @@ func (r *JobRunner) Run() error {
 		return err
 	}
 
+	r.emit(events.JobStarted, nil)
+
 	// Start the header time streamer
 	if err := r.headerTimesStreamer.Start(); err != nil {
 		return err
@@ -146,47 +465,120 @@ func (r *JobRunner) Run() error {
 	}
 
 	// Start the process. This will block until it finishes.
-	if err := r.process.Start(); err != nil {
-		// Send the error as output
-		r.logStreamer.Process(fmt.Sprintf("%s", err))
-	} else {
+	r.setPhase("running")
+	for attempt := 1; ; attempt++ {
+		if err := r.process.Start(); err != nil {
+			// Send the error as output
+			r.logStreamer.Process(r.previousOutput + fmt.Sprintf("%s", err))
+
+			// The build never ran, so this is the agent's problem rather
+			// than the build's, which the exit status tells Buildkite
+			if process.IsStartError(err) {
+				r.startFailed = true
+				r.logger.Error("[JobRunner] Job %s couldn't be started: %s", r.Job.ID, err)
+			}
+			break
+		}
+
 		// Add the final output to the streamer
-		r.logStreamer.Process(r.process.Output())
+		r.logStreamer.Process(r.output())
+
+		if !r.retryAfterInfrastructureFailure(attempt) {
+			break
+		}
 	}
 
 	// Store the finished at time
 	finishedAt := time.Now()
+	r.setPhase("finishing")
 
 	// Stop the header time streamer. This will block until all the chunks
 	// have been uploaded
 	r.headerTimesStreamer.Stop()
 
+	// Send how long each group took, including nested ones
+	if timings := r.headerTimesStreamer.Timings(finishedAt); len(timings) > 0 {
+		r.onUploadHeaderTimings(timings)
+	}
+
 	// Stop the log streamer. This will block until all the chunks have
 	// been uploaded
 	r.logStreamer.Stop()
 
 	// Warn about failed chunks
 	if r.logStreamer.ChunksFailedCount > 0 {
-		logger.Warn("%d chunks failed to upload for this job", r.logStreamer.ChunksFailedCount)
+		r.logger.Warn("%d chunks failed to upload for this job", r.logStreamer.ChunksFailedCount)
+	}
+
+	// Point out jobs with so much output that it's likely a mistake
+	stats := r.process.Stats()
+	r.logger.Debug("[JobRunner] Job %s wrote %s of output (%d lines) in %v", r.Job.ID, formatBytes(stats.Bytes), stats.Lines, stats.Duration)
+	if stats.Bytes >= largeJobOutputBytes {
+		r.logger.Warn("Job %s wrote %s of output (%d lines, %s per second)", r.Job.ID,
+			formatBytes(stats.Bytes), stats.Lines, formatBytes(int64(stats.BytesPerSecond)))
+	}
+
+	// The log only has some of the output if lines were collapsed or it
+	// was too big, so the full log goes in an artifact
+	if r.fullLogFile != nil {
+		if err := r.uploadFullLog(); err != nil {
+			r.logger.Warn("[JobRunner] Failed to upload the job's full log: %v", err)
+		}
+	}
+
+	// Only the agent can write the audit log, so it uploads it too
+	if r.auditLogWriter != nil {
+		if err := r.finishAuditLog(); err != nil {
+			r.logger.Warn("[JobRunner] Failed to write or upload the job's audit log: %v", err)
+		}
 	}
 
 	// Wait for the routines that we spun up to finish
-	logger.Debug("[JobRunner] Waiting for all other routines to finish")
+	r.logger.Debug("[JobRunner] Waiting for all other routines to finish")
 	r.contextCancel()
 	r.routineWaitGroup.Wait()
 
+	// Collect how long each phase of the bootstrap took
+	r.Job.PhaseTimings = r.readPhaseTimings()
+
+	// And what its process used, for the build page
+	r.Job.ResourceUsage = r.resourceUsage()
+
+	if r.jobEnvFile != "" {
+		if err := os.Remove(r.jobEnvFile); err != nil {
+			r.logger.Warn("[JobRunner] Error cleaning up job env file: %s", err)
+		}
+	}
+
+	if r.infraFailureReader != nil {
+		r.infraFailureReader.Close()
+		r.infraFailureWriter.Close()
+	}
+
+	if r.metaDataCacheFile != "" {
+		if err := os.Remove(r.metaDataCacheFile); err != nil {
+			r.logger.Warn("[JobRunner] Error cleaning up meta-data cache file: %s", err)
+		}
+	}
+
+	if r.artifactBytesFile != "" {
+		if err := os.Remove(r.artifactBytesFile); err != nil {
+			r.logger.Warn("[JobRunner] Error cleaning up artifact bytes file: %s", err)
+		}
+	}
+
 	// Remove the env file, if any
 	if r.envFile != nil {
 		if err := os.Remove(r.envFile.Name()); err != nil {
-			logger.Warn("[JobRunner] Error cleaning up env file: %s", err)
+			r.logger.Warn("[JobRunner] Error cleaning up env file: %s", err)
 		}
-		logger.Debug("[JobRunner] Deleted env file: %s", r.envFile.Name())
+		r.logger.Debug("[JobRunner] Deleted env file: %s", r.envFile.Name())
 	}
 
-	// Destroy the proxy
-	if experiments.IsEnabled("agent-socket") {
+	// Destroy the proxy, which revokes the job's token
+	if r.usesAPIProxy() {
 		if err := r.APIProxy.Close(); err != nil {
-			logger.Warn("[JobRunner] Failed to close API proxy: %v", err)
+			r.logger.Warn("[JobRunner] Failed to close API proxy: %v", err)
 		}
 	}
 
@@ -194,31 +586,150 @@ func (r *JobRunner) Run() error {
 	//
 	// Once we tell the API we're finished it might assign us new work, so make
 	// sure everything else is done first.
-	r.finishJob(finishedAt, r.process.ExitStatus, int(r.logStreamer.ChunksFailedCount))
+	exitStatus := r.exitStatus()
+	r.finishJob(finishedAt, exitStatus, int(r.logStreamer.ChunksFailedCount))
+
+	r.setPhase("")
+	finished := map[string]string{"exit_status": exitStatus}
+	if r.startFailed {
+		finished["start_failed"] = "true"
+	}
+	r.emit(events.JobFinished, finished)
 
-	logger.Info("Finished job %s", r.Job.ID)
+	r.Span.SetAttribute("buildkite.exit_status", exitStatus)
+
+	r.logger.Info("Finished job %s", r.Job.ID)
 
 	return nil
 }
 
+// Whether the job is given a job-scoped token for a local API proxy, rather
+// than the agent's own access token
+func (r *JobRunner) usesAPIProxy() bool {
+	// Jobs in Kubernetes pods can't reach the proxy's socket
+	if r.AgentConfiguration.Executor == ExecutorKubernetes {
+		return false
+	}
+
+	// Isolated jobs reach the proxy over the network instead, so they're
+	// never given the agent's own token
+	if r.AgentConfiguration.Isolation != "" {
+		return true
+	}
+
+	// The command sandbox has no network, so its only way to the Agent API
+	// is the proxy's socket
+	if r.AgentConfiguration.CommandSandbox {
+		return true
+	}
+
+	return r.AgentConfiguration.JobScopedTokens || experiments.IsEnabled("agent-socket")
+}
+
+// The API paths that a job's scoped token is allowed to access, which are
+// the ones the agent's job subcommands use
+func jobTokenScope(job *api.Job) []string {
+	scope := []string{
+		"/jobs/" + job.ID + "/annotations",
+		"/jobs/" + job.ID + "/artifacts",
+		"/jobs/" + job.ID + "/data",
+		"/jobs/" + job.ID + "/pipelines",
+		"/jobs/" + job.ID + "/step_update",
+		"/jobs/" + job.ID + "/test_results",
+	}
+
+	if buildID := job.Env["BUILDKITE_BUILD_ID"]; buildID != "" {
+		scope = append(scope, "/builds/"+buildID)
+	}
+
+	return scope
+}
+
+// Moves the job into a new phase, emitting an event for the end of the
+// previous one. An empty phase just finishes the current one.
+func (r *JobRunner) setPhase(phase string) {
+	if r.phase != "" {
+		r.emit(events.PhaseFinished, map[string]string{
+			"phase":    r.phase,
+			"duration": time.Now().Sub(r.phaseStartedAt).String(),
+		})
+	}
+
+	r.phaseSpan.Finish()
+
+	r.phase = phase
+	r.phaseStartedAt = time.Now()
+
+	if phase != "" {
+		r.logger.SetField("phase", phase)
+		r.phaseSpan = r.Span.StartChild(phase)
+	}
+}
+
+func (r *JobRunner) emit(eventType string, data map[string]string) {
+	r.Events.Emit(events.Event{
+		Type:  eventType,
+		Agent: r.Agent.Name,
+		JobID: r.Job.ID,
+		Data:  data,
+	})
+}
+
 func (r *JobRunner) Kill() error {
 	r.killLock.Lock()
 	defer r.killLock.Unlock()
 
 	if !r.cancelled {
-		logger.Info("Canceling job %s", r.Job.ID)
+		r.logger.Info("Canceling job %s", r.Job.ID)
 		r.cancelled = true
 
 		if r.process != nil {
 			r.process.Kill()
 		} else {
-			logger.Error("No process to kill")
+			r.logger.Error("No process to kill")
 		}
 	}
 
 	return nil
 }
 
+// Signal sends a signal to the job's process, unless it's being killed
+func (r *JobRunner) Signal(sig os.Signal) error {
+	r.killLock.Lock()
+	defer r.killLock.Unlock()
+
+	if r.cancelled {
+		return fmt.Errorf("Job %s is being canceled", r.Job.ID)
+	}
+
+	if r.process == nil {
+		return fmt.Errorf("No process to signal")
+	}
+
+	return r.process.Signal(sig)
+}
+
+// Sends each of the signals that have been requested for the job, once
+func (r *JobRunner) deliverSignals(signals []api.JobSignal) {
+	for _, s := range signals {
+		if r.deliveredSignals[s.ID] {
+			continue
+		}
+		r.deliveredSignals[s.ID] = true
+
+		sig, err := process.ParseSignal(s.Signal)
+		if err != nil {
+			r.logger.Warn("Not sending signal to job %s: %v", r.Job.ID, err)
+			continue
+		}
+
+		r.logger.Info("Sending %s to job %s", s.Signal, r.Job.ID)
+		if err := r.Signal(sig); err != nil {
+			r.logger.Warn("Failed to send %s to job %s: %v", s.Signal, r.Job.ID, err)
+		}
+	}
+}
+
 // Creates the environment variables that will be used in the process and writes a flat environment file
 func (r *JobRunner) createEnvironment() ([]string, error) {
 	// Create a clone of our jobs environment. We'll then set the
@@ -256,6 +767,7 @@ func (r *JobRunner) createEnvironment() ([]string, error) {
 		`BUILDKITE_BIN_PATH`,
 		`BUILDKITE_CONFIG_PATH`,
 		`BUILDKITE_BUILD_PATH`,
+		`BUILDKITE_BUILD_PATH_TEMPLATE`,
 		`BUILDKITE_HOOKS_PATH`,
 		`BUILDKITE_PLUGINS_PATH`,
 		`BUILDKITE_SSH_KEYSCAN`,
@@ -263,9 +775,31 @@ func (r *JobRunner) createEnvironment() ([]string, error) {
 		`BUILDKITE_COMMAND_EVAL`,
 		`BUILDKITE_PLUGINS_ENABLED`,
 		`BUILDKITE_LOCAL_HOOKS_ENABLED`,
+		`BUILDKITE_SKIP_REPOSITORY_HOOKS`,
+		`BUILDKITE_WINDOWS_PTY`,
 		`BUILDKITE_GIT_CLONE_FLAGS`,
 		`BUILDKITE_GIT_CLEAN_FLAGS`,
+		`BUILDKITE_GIT_URL_REWRITES`,
+		`BUILDKITE_DOTENV_ALLOWED_VARS`,
 		`BUILDKITE_SHELL`,
+		`BUILDKITE_AGENT_EVENT_SINKS`,
+		`BUILDKITE_TRACING_OTLP_ENDPOINT`,
+		`BUILDKITE_TRACING_PARENT`,
+		`BUILDKITE_PHASE_TIMINGS_FILE`,
+		`BUILDKITE_SECCOMP_PROFILE`,
+		`BUILDKITE_APPARMOR_PROFILE`,
+		`BUILDKITE_SELINUX_CONTEXT`,
+		`BUILDKITE_COMMAND_SANDBOX`,
+		`BUILDKITE_AUDIT_LOG`,
+		`BUILDKITE_AUDIT_LOG_UPLOAD`,
+		`BUILDKITE_AUDIT_LOG_FD`,
+		`BUILDKITE_INFRA_FAILURE_FD`,
+		`BUILDKITE_META_DATA_CACHE_FILE`,
+		`BUILDKITE_MAX_ARTIFACT_BYTES_PER_JOB`,
+		`BUILDKITE_ARTIFACT_BYTES_FILE`,
+		`BUILDKITE_CRASH_ARTIFACT_PATHS`,
+		`BUILDKITE_DEBUG_SESSION_TIMEOUT`,
+		`BUILDKITE_DEBUG_SESSION_AUTHORIZED_KEYS`,
 	}
 
 	var ignoredEnv []string
@@ -282,7 +816,7 @@ func (r *JobRunner) createEnvironment() ([]string, error) {
 		env["BUILDKITE_IGNORED_ENV"] = strings.Join(ignoredEnv, ",")
 	}
 
-	if experiments.IsEnabled("agent-socket") {
+	if r.usesAPIProxy() {
 		env["BUILDKITE_AGENT_ENDPOINT"] = r.APIProxy.Endpoint()
 		env["BUILDKITE_AGENT_ACCESS_TOKEN"] = r.APIProxy.AccessToken()
 	} else {
@@ -302,6 +836,7 @@ func (r *JobRunner) createEnvironment() ([]string, error) {
 	// Add options from the agent configuration
 	env["BUILDKITE_CONFIG_PATH"] = r.AgentConfiguration.ConfigPath
 	env["BUILDKITE_BUILD_PATH"] = r.AgentConfiguration.BuildPath
+	env["BUILDKITE_BUILD_PATH_TEMPLATE"] = r.AgentConfiguration.BuildPathTemplate
 	env["BUILDKITE_HOOKS_PATH"] = r.AgentConfiguration.HooksPath
 	env["BUILDKITE_PLUGINS_PATH"] = r.AgentConfiguration.PluginsPath
 	env["BUILDKITE_SSH_KEYSCAN"] = fmt.Sprintf("%t", r.AgentConfiguration.SSHKeyscan)
@@ -309,9 +844,82 @@ func (r *JobRunner) createEnvironment() ([]string, error) {
 	env["BUILDKITE_COMMAND_EVAL"] = fmt.Sprintf("%t", r.AgentConfiguration.CommandEval)
 	env["BUILDKITE_PLUGINS_ENABLED"] = fmt.Sprintf("%t", r.AgentConfiguration.PluginsEnabled)
 	env["BUILDKITE_LOCAL_HOOKS_ENABLED"] = fmt.Sprintf("%t", r.AgentConfiguration.LocalHooksEnabled)
+	env["BUILDKITE_SKIP_REPOSITORY_HOOKS"] = fmt.Sprintf("%t", r.AgentConfiguration.SkipRepositoryHooks)
+	env["BUILDKITE_WINDOWS_PTY"] = fmt.Sprintf("%t", r.AgentConfiguration.WindowsPTY)
 	env["BUILDKITE_GIT_CLONE_FLAGS"] = r.AgentConfiguration.GitCloneFlags
 	env["BUILDKITE_GIT_CLEAN_FLAGS"] = r.AgentConfiguration.GitCleanFlags
+	env["BUILDKITE_GIT_URL_REWRITES"] = strings.Join(r.AgentConfiguration.GitURLRewrites, ",")
+	env["BUILDKITE_DOTENV_ALLOWED_VARS"] = strings.Join(r.AgentConfiguration.DotenvAllowedVars, ",")
 	env["BUILDKITE_SHELL"] = r.AgentConfiguration.Shell
+	env["BUILDKITE_COMMAND_SANDBOX"] = fmt.Sprintf("%t", r.AgentConfiguration.CommandSandbox)
+	env["BUILDKITE_CRASH_ARTIFACT_PATHS"] = r.AgentConfiguration.CrashArtifactPaths
+	env["BUILDKITE_DEBUG_SESSION_TIMEOUT"] = fmt.Sprintf("%d", r.AgentConfiguration.DebugSessionTimeout)
+	env["BUILDKITE_DEBUG_SESSION_AUTHORIZED_KEYS"] = r.AgentConfiguration.DebugSessionKeys
+
+	// Where the bootstrap should explain infrastructure failures
+	if r.infraFailureWriter != nil {
+		env["BUILDKITE_INFRA_FAILURE_FD"] = r.inheritedFD(r.infraFailureWriter)
+	}
+
+	// Where meta-data commands cache the values they've read
+	if r.metaDataCacheFile != "" {
+		env["BUILDKITE_META_DATA_CACHE_FILE"] = r.metaDataCacheFile
+	}
+
+	// The limit on the job's artifacts, and where uploads count them
+	if r.artifactBytesFile != "" {
+		env["BUILDKITE_MAX_ARTIFACT_BYTES_PER_JOB"] = fmt.Sprintf("%d", r.AgentConfiguration.MaxArtifactBytesPerJob)
+		env["BUILDKITE_ARTIFACT_BYTES_FILE"] = r.artifactBytesFile
+	}
+
+	// Where the bootstrap should write how long each phase took
+	if r.phaseTimingsFile != "" {
+		env["BUILDKITE_PHASE_TIMINGS_FILE"] = r.phaseTimingsFile
+	}
+
+	// The bootstrap installs the seccomp filter on itself before it starts
+	if r.AgentConfiguration.SeccompProfile != "" {
+		env["BUILDKITE_SECCOMP_PROFILE"] = r.AgentConfiguration.SeccompProfile
+	}
+
+	// The bootstrap runs under these, and applies them to any container it
+	// runs the command in
+	if r.AgentConfiguration.AppArmorProfile != "" {
+		env["BUILDKITE_APPARMOR_PROFILE"] = r.AgentConfiguration.AppArmorProfile
+	}
+	if r.AgentConfiguration.SELinuxContext != "" {
+		env["BUILDKITE_SELINUX_CONTEXT"] = r.AgentConfiguration.SELinuxContext
+	}
+
+	// Each job has its own audit log, which the agent writes to and uploads
+	// when it can give the bootstrap a pipe to it
+	if r.auditLogWriter != nil {
+		env["BUILDKITE_AUDIT_LOG_FD"] = r.inheritedFD(r.auditLogWriter)
+	} else if r.AgentConfiguration.AuditLogPath != "" {
+		env["BUILDKITE_AUDIT_LOG"] = r.auditLogPath()
+		env["BUILDKITE_AUDIT_LOG_UPLOAD"] = fmt.Sprintf("%t", r.AgentConfiguration.AuditLogUpload)
+	}
+
+	// Pass event sinks along so commands like artifact upload can use them
+	if len(r.AgentConfiguration.EventSinks) > 0 {
+		env["BUILDKITE_AGENT_EVENT_SINKS"] = strings.Join(r.AgentConfiguration.EventSinks, ",")
+	}
+
+	// Every job gets a trace context, even when the agent isn't tracing, so
+	// that anything the job runs can attach its own spans to the build's
+	// trace. A TRACEPARENT given to the job is continued rather than replaced.
+	traceContext := r.Span.SpanContext()
+	if !traceContext.IsValid() {
+		parent, _ := tracing.ParseTraceparent(r.Job.Env["TRACEPARENT"])
+		traceContext = tracing.NewSpanContext(parent)
+	}
+	env["TRACEPARENT"] = traceContext.Traceparent()
+
+	// Let the bootstrap add its spans to the job's trace
+	if r.AgentConfiguration.TracingOTLPEndpoint != "" && r.Span != nil {
+		env["BUILDKITE_TRACING_OTLP_ENDPOINT"] = r.AgentConfiguration.TracingOTLPEndpoint
+		env["BUILDKITE_TRACING_PARENT"] = traceContext.Traceparent()
+	}
 
 	enablePluginValidation := r.AgentConfiguration.PluginValidation
 
@@ -336,6 +944,148 @@ func (r *JobRunner) createEnvironment() ([]string, error) {
 	return envSlice, nil
 }
 
+// Reads the phase timings written by the bootstrap and removes the file.
+// Timings are nice to have, so any problems are just logged.
+func (r *JobRunner) readPhaseTimings() []api.PhaseTiming {
+	if r.phaseTimingsFile == "" {
+		return nil
+	}
+
+	defer func() {
+		if err := os.Remove(r.phaseTimingsFile); err != nil {
+			r.logger.Warn("[JobRunner] Error cleaning up phase timings file: %s", err)
+		}
+	}()
+
+	data, err := ioutil.ReadFile(r.phaseTimingsFile)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	var timings []api.PhaseTiming
+	if err := json.Unmarshal(data, &timings); err != nil {
+		r.logger.Warn("[JobRunner] Failed to read phase timings: %s", err)
+		return nil
+	}
+
+	for _, timing := range timings {
+		r.logger.Debug("[JobRunner] Phase %s took %dms", timing.Name, timing.DurationMS)
+	}
+
+	return timings
+}
+
+// Called when the job hasn't written any output for the idle timeout, which
+// usually means it's hung
+func (r *JobRunner) onProcessIdle(idle time.Duration) {
+	r.logger.Warn("Job %s hasn't written any output for %s and appears to be hung", r.Job.ID, idle.Truncate(time.Second))
+	r.emit(events.JobIdle, map[string]string{"idle_seconds": strconv.Itoa(int(idle.Seconds()))})
+}
+
+// Works out whether the job failed because of the agent's infrastructure, and
+// if so, and there are retries left, gets a new process ready to try again.
+// The output of each attempt is kept in the job's log.
+func (r *JobRunner) retryAfterInfrastructureFailure(attempt int) bool {
+	retries := r.AgentConfiguration.InfraFailureRetries
+	if attempt > retries {
+		return false
+	}
+
+	reason := infrastructureFailure(r.exitStatus(), r.process.Output(), r.readInfraFailure(), r.AgentConfiguration.InfraFailureExitCodes)
+	if reason == "" {
+		return false
+	}
+
+	// Let the routines watching the last process finish before starting
+	// new ones for the next. They can cancel the job, so this has to happen
+	// before taking the kill lock.
+	r.routineWaitGroup.Wait()
+
+	r.killLock.Lock()
+	defer r.killLock.Unlock()
+
+	if r.cancelled {
+		return false
+	}
+
+	r.logger.Warn("Job %s failed because %s, retrying (attempt %d of %d)", r.Job.ID, reason, attempt+1, retries+1)
+	r.emit(events.JobRetrying, map[string]string{"reason": reason, "attempt": strconv.Itoa(attempt + 1)})
+
+	// Starting the process again clears its output, so what it had so far
+	// is kept here
+	r.previousOutput = r.output() + fmt.Sprintf("\nRetrying the job because %s (attempt %d of %d)\n", reason, attempt+1, retries+1)
+
+	return true
+}
+
+// readInfraFailure reads what the bootstrap wrote to the infrastructure
+// failure pipe in the last attempt, if anything. Reading drains the pipe, so
+// the next attempt starts with it empty.
+func (r *JobRunner) readInfraFailure() string {
+	if r.infraFailureReader == nil {
+		return ""
+	}
+
+	// The agent holds the pipe open too, so there's never an EOF, just
+	// whatever's buffered
+	if err := r.infraFailureReader.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		r.logger.Warn("[JobRunner] Error reading infrastructure failure pipe: %s", err)
+		return ""
+	}
+
+	buf := make([]byte, 4096)
+	n, _ := r.infraFailureReader.Read(buf)
+	return strings.TrimSpace(string(buf[:n]))
+}
+
+// canInheritFiles returns whether the bootstrap runs locally, on a platform
+// where it can inherit open files
+func (r *JobRunner) canInheritFiles() bool {
+	return runtime.GOOS != "windows" && r.AgentConfiguration.Executor != ExecutorKubernetes && r.AgentConfiguration.Isolation == ""
+}
+
+// exitStatus returns the exit status of the job's process, once it's finished
+func (r *JobRunner) exitStatus() string {
+	// A process that never started didn't fail the build, the agent did
+	if r.startFailed {
+		return startFailedExitStatus
+	}
+
+	result, _ := r.process.Wait()
+	return strconv.Itoa(result.ExitStatus)
+}
+
+// resourceUsage returns the CPU time and memory the job's process used, or
+// nil if it never ran
+func (r *JobRunner) resourceUsage() *api.ResourceUsage {
+	result, _ := r.process.Wait()
+	if result.Usage == (process.ResourceUsage{}) {
+		return nil
+	}
+
+	return &api.ResourceUsage{
+		UserCPUMS:   int64(result.Usage.UserTime / time.Millisecond),
+		SystemCPUMS: int64(result.Usage.SystemTime / time.Millisecond),
+		MaxRSSBytes: result.Usage.MaxRSS,
+	}
+}
+
+// Called when a secret turns up in the job output
+func (r *JobRunner) onSecretDetected(name string) {
+	switch r.AgentConfiguration.SecretScanning {
+	case SecretScanningFail:
+		r.logger.Error("Found what looks like a %s in the output of job %s, failing the job", name, r.Job.ID)
+
+		// This is called while the log streamer holds its lock, which
+		// mustn't be held for the whole kill sequence
+		go r.Kill()
+	case SecretScanningMask:
+		r.logger.Warn("Found what looks like a %s in the output of job %s, it has been masked", name, r.Job.ID)
+	default:
+		r.logger.Warn("Found what looks like a %s in the output of job %s", name, r.Job.ID)
+	}
+}
+
 // Starts the job in the Buildkite Agent API. We'll retry on connection-related
 // issues, but if a connection succeeds and we get an error response back from
 // Buildkite, we won't bother retrying. For example, a "no such host" will
@@ -348,9 +1098,9 @@ func (r *JobRunner) startJob(startedAt time.Time) error {
 
 		if err != nil {
 			if api.IsRetryableError(err) {
-				logger.Warn("%s (%s)", err, s)
+				r.logger.Warn("%s (%s)", err, s)
 			} else {
-				logger.Warn("Buildkite rejected the call to start the job (%s)", err)
+				r.logger.Warn("Buildkite rejected the call to start the job (%s)", err)
 				s.Break()
 			}
 		}
@@ -379,10 +1129,10 @@ func (r *JobRunner) finishJob(finishedAt time.Time, exitStatus string, failedChu
 			// to finish the job forever so we'll just bail out and
 			// go find some more work to do.
 			if response != nil && response.StatusCode == 422 {
-				logger.Warn("Buildkite rejected the call to finish the job (%s)", err)
+				r.logger.Warn("Buildkite rejected the call to finish the job (%s)", err)
 				s.Break()
 			} else {
-				logger.Warn("%s (%s)", err, s)
+				r.logger.Warn("%s (%s)", err, s)
 			}
 		}
 
@@ -401,7 +1151,7 @@ func (r *JobRunner) onProcessStartCallback() {
 		for r.process.IsRunning() {
 			// Send the output of the process to the log streamer
 			// for processing
-			r.logStreamer.Process(r.process.Output())
+			r.logStreamer.Process(r.output())
 
 			// Sleep for a bit, or until the job is finished
 			select {
@@ -415,7 +1165,7 @@ func (r *JobRunner) onProcessStartCallback() {
 		// Mark this routine as done in the wait group
 		r.routineWaitGroup.Done()
 
-		logger.Debug("[JobRunner] Routine that processes the log has finished")
+		r.logger.Debug("[JobRunner] Routine that processes the log has finished")
 	}()
 
 	// Start a routine that will constantly ping Buildkite to see if the
@@ -428,9 +1178,11 @@ func (r *JobRunner) onProcessStartCallback() {
 			if err != nil {
 				// We don't really care if it fails, we'll just
 				// try again soon anyway
-				logger.Warn("Problem with getting job state %s (%s)", r.Job.ID, err)
+				r.logger.Warn("Problem with getting job state %s (%s)", r.Job.ID, err)
 			} else if jobState.State == "canceling" || jobState.State == "canceled" {
 				r.Kill()
+			} else {
+				r.deliverSignals(jobState.Signals)
 			}
 
 			// Sleep for a bit, or until the job is finished
@@ -443,7 +1195,7 @@ func (r *JobRunner) onProcessStartCallback() {
 		// Mark this routine as done in the wait group
 		r.routineWaitGroup.Done()
 
-		logger.Debug("[JobRunner] Routine that refreshes the job has finished")
+		r.logger.Debug("[JobRunner] Routine that refreshes the job has finished")
 	}()
 }
 
@@ -451,27 +1203,45 @@ func (r *JobRunner) onUploadHeaderTime(cursor int, total int, times map[string]s
 	retry.Do(func(s *retry.Stats) error {
 		_, err := r.APIClient.HeaderTimes.Save(r.Job.ID, &api.HeaderTimes{Times: times})
 		if err != nil {
-			logger.Warn("%s (%s)", err, s)
+			r.logger.Warn("%s (%s)", err, s)
 		}
 
 		return err
-	}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+	}, retry.ExponentialBackoff(10))
+}
+
+// Uploads the nested timings of the log groups, retrying a few times
+func (r *JobRunner) onUploadHeaderTimings(timings []*api.HeaderTiming) {
+	config := retry.ExponentialBackoff(5)
+	config.IsPermanent = api.IsPermanentError
+
+	retry.Do(func(s *retry.Stats) error {
+		_, err := r.APIClient.HeaderTimes.SaveTimings(r.Job.ID, timings)
+		if err != nil {
+			r.logger.Warn("%s (%s)", err, s)
+		}
+
+		return err
+	}, config)
 }
 
 // Call when a chunk is ready for upload. It retry the chunk upload with an
 // interval before giving up.
 func (r *JobRunner) onUploadChunk(chunk *LogStreamerChunk) error {
+	config := retry.ExponentialBackoff(10)
+	config.AttemptTimeout = chunkUploadTimeout
+
 	return retry.Do(func(s *retry.Stats) error {
-		_, err := r.APIClient.Chunks.Upload(r.Job.ID, &api.Chunk{
+		_, err := r.APIClient.Chunks.UploadWithContext(s.Context, r.Job.ID, &api.Chunk{
 			Data:     chunk.Data,
 			Sequence: chunk.Order,
 			Offset:   chunk.Offset,
 			Size:     chunk.Size,
 		})
 		if err != nil {
-			logger.Warn("%s (%s)", err, s)
+			r.logger.Warn("%s (%s)", err, s)
 		}
 
 		return err
-	}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+	}, config)
 }
@@ -0,0 +1,170 @@
+//go:build !windows
+// +build !windows
+
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/logger"
+)
+
+// The bootstrap for TestRunningJobAsAnotherUser, which fails unless it's
+// running as the job's user and can use everything the agent gave it
+const jobUserBootstrap = `#!/bin/sh
+set -eu
+test "$(id -u)" = 65534
+cat "$BUILDKITE_ENV_FILE" > /dev/null
+echo '[]' > "$BUILDKITE_PHASE_TIMINGS_FILE"
+echo '{}' > "$BUILDKITE_META_DATA_CACHE_FILE"
+echo 0 > "$BUILDKITE_ARTIFACT_BYTES_FILE"
+mkdir -p "$BUILDKITE_BUILD_PATH/checkout"
+test -w "${BUILDKITE_AGENT_ENDPOINT#unix://}"
+`
+
+func TestRunningJobAsAnotherUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Running jobs as another user needs root")
+	}
+
+	dir, err := ioutil.TempDir("", "job-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The job's user needs to be able to get to the bootstrap
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	bootstrap := filepath.Join(dir, "bootstrap.sh")
+	if err := ioutil.WriteFile(bootstrap, []byte(jobUserBootstrap), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var exitStatus string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/finish") {
+			var finish struct {
+				ExitStatus string `json:"exit_status"`
+			}
+			json.NewDecoder(req.Body).Decode(&finish)
+
+			mu.Lock()
+			exitStatus = finish.ExitStatus
+			mu.Unlock()
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	runner, err := JobRunner{
+		Endpoint: server.URL,
+		Agent:    &api.Agent{Name: "llamas", AccessToken: "llamas"},
+		Job:      &api.Job{ID: "job-user-test", Env: map[string]string{}, ChunksMaxSizeBytes: 1024},
+		AgentConfiguration: &AgentConfiguration{
+			BootstrapScript:        bootstrap,
+			BuildPath:              filepath.Join(dir, "builds"),
+			JobUser:                "65534:65534",
+			MaxArtifactBytesPerJob: 1024,
+			JobScopedTokens:        true,
+		},
+	}.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runner.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if exitStatus != "0" {
+		t.Fatalf("Expected the bootstrap to succeed as the job's user, got exit status %q:\n%s", exitStatus, runner.output())
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "builds", "checkout")); err != nil {
+		t.Fatalf("Expected the bootstrap to be able to write to the build path: %v", err)
+	}
+}
+
+func TestCommandSandboxUsesTheAPIProxy(t *testing.T) {
+	runner := &JobRunner{AgentConfiguration: &AgentConfiguration{CommandSandbox: true}}
+	if !runner.usesAPIProxy() {
+		t.Fatal("Expected jobs in the command sandbox to reach the API through the proxy")
+	}
+
+	runner.AgentConfiguration.Executor = ExecutorKubernetes
+	if runner.usesAPIProxy() {
+		t.Fatal("Expected jobs in pods to not use the proxy")
+	}
+}
+
+func TestIsolatedJobsUseTheAPIProxy(t *testing.T) {
+	runner := &JobRunner{AgentConfiguration: &AgentConfiguration{Isolation: "firecracker"}}
+	if !runner.usesAPIProxy() {
+		t.Fatal("Expected isolated jobs to reach the API through the proxy, rather than with the agent's token")
+	}
+}
+
+func TestUploadingChunkGivesUpOnHungRequests(t *testing.T) {
+	defer func(timeout time.Duration) { chunkUploadTimeout = timeout }(chunkUploadTimeout)
+	chunkUploadTimeout = 50 * time.Millisecond
+
+	var uploads int32
+	cancelled := make(chan struct{})
+	done := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// The first upload hangs until the agent gives up on it. The server
+		// only notices the connection closing once the body's been read.
+		if atomic.AddInt32(&uploads, 1) == 1 {
+			ioutil.ReadAll(req.Body)
+			select {
+			case <-req.Context().Done():
+				close(cancelled)
+			case <-done:
+			}
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	defer close(done)
+
+	runner := &JobRunner{
+		APIClient: APIClient{Endpoint: server.URL, Token: "llamas"}.Create(),
+		Job:       &api.Job{ID: "chunk-test"},
+		logger:    logger.WithFields(logger.Fields{}),
+	}
+
+	if err := runner.onUploadChunk(&LogStreamerChunk{Data: "llamas", Order: 1, Size: 6}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&uploads); n != 2 {
+		t.Fatalf("Expected the hung upload to be tried again, got %d uploads", n)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the hung upload's request to be cancelled")
+	}
+}
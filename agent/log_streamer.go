@@ -2,13 +2,20 @@ package agent
 
 import (
 	"errors"
+	"io"
 	"math"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/buildkite/agent/logger"
 )
 
+// The longest unfinished line that's held back from the filter while waiting
+// for the rest of it, after which it's filtered as it is
+const maxPendingLineBytes = 64 * 1024
+
 type LogStreamer struct {
 	// How many log streamer workers are running at any one time
 	Concurrency int
@@ -22,12 +29,40 @@ type LogStreamer struct {
 	// The callback called when a chunk is ready for upload
 	Callback func(chunk *LogStreamerChunk) error
 
+	// Optionally changes new output before it's chunked. It must return a
+	// string of the same length, so that offsets stay correct. It's given
+	// whole lines, in order.
+	Filter func(output string) string
+
+	// Optionally hides lines of groups that are too long
+	Collapser *GroupCollapser
+
+	// The most bytes of log to send, after which the truncation notice is
+	// sent and nothing else. 0 means there's no limit.
+	MaxSizeBytes     int
+	TruncationNotice string
+
+	// Optionally where all of the output is written, even the parts that
+	// are collapsed or past the size limit
+	Tee io.Writer
+
+	// Whether the log was cut off at the size limit
+	truncated bool
+
+	// The end of the output that hasn't been filtered yet, as its line
+	// hasn't finished
+	pending string
+
 	// The queue of chunks that are needing to be uploaded
 	queue chan *LogStreamerChunk
 
 	// Total size in bytes of the log
 	bytes int
 
+	// Total size in bytes of what's been sent, which is smaller than the
+	// log when lines have been collapsed
+	offset int
+
 	// Each chunk is assigned an order
 	order int
 
@@ -86,49 +121,137 @@ func (ls *LogStreamer) Process(output string) error {
 	if ls.bytes != bytes {
 		// Grab the part of the log that we haven't seen yet
 		blob := output[ls.bytes:bytes]
+		if ls.Filter != nil {
+			blob = ls.filterLines(blob)
+		}
+		ls.send(blob)
 
-		// How many chunks do we have that fit within the MaxChunkSizeBytes?
-		numberOfChunks := int(math.Ceil(float64(len(blob)) / float64(ls.MaxChunkSizeBytes)))
+		// Save the new amount of bytes
+		ls.bytes = bytes
+	}
+
+	ls.processMutex.Unlock()
 
-		// Increase the wait group by the amount of chunks we're going
-		// to add
-		ls.chunkWaitGroup.Add(numberOfChunks)
+	return nil
+}
 
-		for i := 0; i < numberOfChunks; i++ {
-			// Find the upper limit of the blob
-			upperLimit := (i + 1) * ls.MaxChunkSizeBytes
-			if upperLimit > len(blob) {
-				upperLimit = len(blob)
-			}
+// Filters the whole lines in the blob, and holds on to the end of it if its
+// line hasn't finished, so that the filter never sees half of a secret
+func (ls *LogStreamer) filterLines(blob string) string {
+	blob = ls.pending + blob
+	ls.pending = ""
 
-			// Grab the 100kb section of the blob
-			partialChunk := blob[i*ls.MaxChunkSizeBytes : upperLimit]
+	if end := strings.LastIndexByte(blob, '\n') + 1; len(blob)-end < maxPendingLineBytes {
+		ls.pending = blob[end:]
+		blob = blob[:end]
+	}
 
-			// Increment the order
-			ls.order += 1
+	if blob == "" {
+		return ""
+	}
 
-			// Create the chunk and append it to our list
-			chunk := LogStreamerChunk{
-				Data:   partialChunk,
-				Order:  ls.order,
-				Offset: ls.bytes,
-				Size:   bytes - ls.bytes,
-			}
+	return ls.Filter(blob)
+}
 
-			ls.queue <- &chunk
+// Writes the blob to the full log, and queues it up to be uploaded
+func (ls *LogStreamer) send(blob string) {
+	if blob == "" {
+		return
+	}
+	if ls.Tee != nil {
+		if _, err := io.WriteString(ls.Tee, blob); err != nil {
+			logger.Warn("[LogStreamer] Failed to write to the full log: %v", err)
 		}
+	}
+	if ls.Collapser != nil {
+		blob = ls.Collapser.Collapse(blob)
+	}
 
-		// Save the new amount of bytes
-		ls.bytes = bytes
+	ls.queueChunks(ls.limit(blob))
+}
+
+// Cuts the blob off at the size limit and adds the truncation notice. Once
+// the limit has been reached nothing else gets through.
+func (ls *LogStreamer) limit(blob string) string {
+	if ls.truncated {
+		return ""
 	}
 
-	ls.processMutex.Unlock()
+	if ls.MaxSizeBytes <= 0 || ls.offset+len(blob) <= ls.MaxSizeBytes {
+		return blob
+	}
+	ls.truncated = true
 
-	return nil
+	// Don't cut a multi-byte character in half
+	end := ls.MaxSizeBytes - ls.offset
+	for end > 0 && !utf8.RuneStart(blob[end]) {
+		end--
+	}
+
+	return blob[:end] + ls.TruncationNotice
+}
+
+// Truncated returns whether the log was cut off at the size limit
+func (ls *LogStreamer) Truncated() bool {
+	ls.processMutex.Lock()
+	defer ls.processMutex.Unlock()
+
+	return ls.truncated
+}
+
+// Splits the blob into chunks and adds them to the queue
+func (ls *LogStreamer) queueChunks(blob string) {
+	// How many chunks do we have that fit within the MaxChunkSizeBytes?
+	numberOfChunks := int(math.Ceil(float64(len(blob)) / float64(ls.MaxChunkSizeBytes)))
+
+	// Increase the wait group by the amount of chunks we're going
+	// to add
+	ls.chunkWaitGroup.Add(numberOfChunks)
+
+	for i := 0; i < numberOfChunks; i++ {
+		// Find the upper limit of the blob
+		upperLimit := (i + 1) * ls.MaxChunkSizeBytes
+		if upperLimit > len(blob) {
+			upperLimit = len(blob)
+		}
+
+		// Grab the 100kb section of the blob
+		partialChunk := blob[i*ls.MaxChunkSizeBytes : upperLimit]
+
+		// Increment the order
+		ls.order += 1
+
+		// Create the chunk and append it to our list
+		chunk := LogStreamerChunk{
+			Data:   partialChunk,
+			Order:  ls.order,
+			Offset: ls.offset,
+			Size:   len(blob),
+		}
+
+		ls.queue <- &chunk
+	}
+
+	ls.offset += len(blob)
 }
 
 // Waits for all the chunks to be uploaded, then shuts down all the workers
 func (ls *LogStreamer) Stop() error {
+	ls.processMutex.Lock()
+
+	// Send the last line if it never finished
+	if ls.pending != "" {
+		ls.send(ls.Filter(ls.pending))
+		ls.pending = ""
+	}
+
+	// Send whatever the collapser was still holding on to
+	if ls.Collapser != nil {
+		ls.queueChunks(ls.limit(ls.Collapser.Flush()))
+	}
+
+	ls.processMutex.Unlock()
+
 	logger.Debug("[LogStreamer] Waiting for all the chunks to be uploaded")
 
 	ls.chunkWaitGroup.Wait()
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLogStreamerTruncatesAtTheSizeLimit(t *testing.T) {
+	var mu sync.Mutex
+	var chunks []*LogStreamerChunk
+
+	var full bytes.Buffer
+	ls := LogStreamer{
+		MaxChunkSizeBytes: 4,
+		MaxSizeBytes:      10,
+		TruncationNotice:  "[truncated]",
+		Tee:               &full,
+		Callback: func(chunk *LogStreamerChunk) error {
+			mu.Lock()
+			chunks = append(chunks, chunk)
+			mu.Unlock()
+			return nil
+		},
+	}.New()
+
+	if err := ls.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	output := "llamas\n"
+	ls.Process(output)
+	output += "alpacas\n"
+	ls.Process(output)
+	output += "vicuñas\n"
+	ls.Process(output)
+	ls.Stop()
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Order < chunks[j].Order })
+
+	var sent strings.Builder
+	for _, chunk := range chunks {
+		sent.WriteString(chunk.Data)
+	}
+
+	if sent.String() != "llamas\nalp[truncated]" {
+		t.Fatalf("Unexpected log %q", sent.String())
+	}
+
+	if !ls.Truncated() {
+		t.Fatal("Expected the log to be truncated")
+	}
+
+	if full.String() != output {
+		t.Fatalf("Expected the full log to have everything, got %q", full.String())
+	}
+}
+
+func TestLogStreamerDoesntCutCharactersInHalf(t *testing.T) {
+	ls := &LogStreamer{MaxSizeBytes: 2, TruncationNotice: "!"}
+
+	if limited := ls.limit("añb"); limited != "a!" {
+		t.Fatalf("Unexpected limited output %q", limited)
+	}
+	if limited := ls.limit("more"); limited != "" {
+		t.Fatalf("Expected nothing after the limit, got %q", limited)
+	}
+}
+
+func TestLogStreamerFiltersWholeLines(t *testing.T) {
+	var mu sync.Mutex
+	var chunks []*LogStreamerChunk
+
+	scanner := &OutputScanner{Mode: SecretScanningMask}
+	ls := LogStreamer{
+		MaxChunkSizeBytes: 1024,
+		Filter:            scanner.Scan,
+		Callback: func(chunk *LogStreamerChunk) error {
+			mu.Lock()
+			chunks = append(chunks, chunk)
+			mu.Unlock()
+			return nil
+		},
+	}.New()
+
+	if err := ls.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key is split across two reads of the output
+	output := "key=AKIAIOSFOD"
+	ls.Process(output)
+	output += "NN7EXAMPLE\nno newline"
+	ls.Process(output)
+	ls.Stop()
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Order < chunks[j].Order })
+
+	var sent strings.Builder
+	for _, chunk := range chunks {
+		sent.WriteString(chunk.Data)
+	}
+
+	if sent.String() != "key=********************\nno newline" {
+		t.Fatalf("Unexpected log %q", sent.String())
+	}
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// MetaDataCache remembers the meta-data values a job has read or set, so
+// that reading the same key again doesn't need another API call. It's kept
+// in a file that the job runner creates and removes with the job.
+//
+// Only values that exist are cached, so a job can still wait for another
+// job to set a key.
+type MetaDataCache struct {
+	Path string
+}
+
+// The cached values, by job and then by key. The job is part of it because
+// meta-data commands can be given a different job, which can be in a
+// different build. Keys that are known to exist but haven't been read have a
+// nil value.
+type metaDataCacheEntries map[string]map[string]*string
+
+// Get returns a cached value, and whether there was one
+func (c MetaDataCache) Get(job string, key string) (string, bool) {
+	if c.Path == "" {
+		return "", false
+	}
+
+	entries, err := c.read()
+	if err != nil {
+		return "", false
+	}
+
+	value := entries[job][key]
+	if value == nil {
+		return "", false
+	}
+
+	return *value, true
+}
+
+// Exists returns whether a key is known to exist
+func (c MetaDataCache) Exists(job string, key string) bool {
+	if c.Path == "" {
+		return false
+	}
+
+	entries, err := c.read()
+	if err != nil {
+		return false
+	}
+
+	_, ok := entries[job][key]
+	return ok
+}
+
+// Set caches a value
+func (c MetaDataCache) Set(job string, key string, value string) error {
+	return c.write(job, key, &value)
+}
+
+// SetExists caches that a key exists, without its value
+func (c MetaDataCache) SetExists(job string, key string) error {
+	if c.Exists(job, key) {
+		return nil
+	}
+
+	return c.write(job, key, nil)
+}
+
+func (c MetaDataCache) write(job string, key string, value *string) error {
+	if c.Path == "" {
+		return nil
+	}
+
+	entries, err := c.read()
+	if err != nil {
+		entries = metaDataCacheEntries{}
+	}
+
+	if entries[job] == nil {
+		entries[job] = map[string]*string{}
+	}
+	entries[job][key] = value
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Commands can run at the same time, so the file is replaced rather
+	// than rewritten to make sure it's never seen half written. Losing a
+	// value to a race just means an extra API call.
+	tmp, err := ioutil.TempFile(filepath.Dir(c.Path), filepath.Base(c.Path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.Path)
+}
+
+func (c MetaDataCache) read() (metaDataCacheEntries, error) {
+	b, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := metaDataCacheEntries{}
+	if len(b) == 0 {
+		return entries, nil
+	}
+
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetaDataCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta-data-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The job runner creates the file empty
+	path := filepath.Join(dir, "cache")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := MetaDataCache{Path: path}
+
+	_, ok := cache.Get("job-1", "foo")
+	assert.False(t, ok)
+	assert.False(t, cache.Exists("job-1", "foo"))
+
+	assert.NoError(t, cache.SetExists("job-1", "foo"))
+	assert.True(t, cache.Exists("job-1", "foo"))
+	_, ok = cache.Get("job-1", "foo")
+	assert.False(t, ok)
+
+	assert.NoError(t, cache.Set("job-1", "foo", "bar"))
+	assert.NoError(t, cache.SetExists("job-1", "foo"))
+
+	value, ok := cache.Get("job-1", "foo")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", value)
+
+	_, ok = cache.Get("job-2", "foo")
+	assert.False(t, ok)
+}
+
+func TestMetaDataCacheWithoutAFile(t *testing.T) {
+	cache := MetaDataCache{}
+
+	assert.NoError(t, cache.Set("job-1", "foo", "bar"))
+	_, ok := cache.Get("job-1", "foo")
+	assert.False(t, ok)
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/buildkite/agent/api"
+)
+
+// The places autoscaling metrics can be published to
+const (
+	MetricsBackendCloudWatch  = "cloudwatch"
+	MetricsBackendStackdriver = "stackdriver"
+)
+
+// Metric is a single measurement for autoscaling, either for the whole
+// organization or for one queue
+type Metric struct {
+	Name  string
+	Queue string
+	Value float64
+	Unit  string
+}
+
+// The units metrics are measured in, named the way CloudWatch names them
+const (
+	MetricUnitCount   = "Count"
+	MetricUnitSeconds = "Seconds"
+)
+
+// MetricsPublisher sends metrics somewhere an autoscaling group can use them
+type MetricsPublisher interface {
+	Publish(metrics []Metric) error
+	String() string
+}
+
+// NewMetricsPublisher creates the publisher for a backend
+func NewMetricsPublisher(backend string, namespace string, project string) (MetricsPublisher, error) {
+	switch backend {
+	case MetricsBackendCloudWatch:
+		return &CloudWatchMetricsPublisher{Namespace: namespace}, nil
+	case MetricsBackendStackdriver:
+		if project == "" {
+			return nil, fmt.Errorf("Publishing metrics to Stackdriver needs a project")
+		}
+		return &StackdriverMetricsPublisher{ProjectID: project}, nil
+	default:
+		return nil, fmt.Errorf("Invalid metrics backend %q, must be one of cloudwatch or stackdriver", backend)
+	}
+}
+
+// AutoscalingMetrics turns the API's metrics into the ones an autoscaling
+// group scales on: how many jobs are waiting for an agent, how long they've
+// been waiting and how many agents are idle. There's a set for the whole
+// organization, and one for each queue, limited to queues if it isn't empty.
+func AutoscalingMetrics(m *api.Metrics, queues []string) []Metric {
+	metrics := queueMetrics("", m.Jobs.JobCounts, m.Agents.AgentCounts)
+
+	names := queues
+	if len(names) == 0 {
+		seen := map[string]bool{}
+		for queue := range m.Jobs.Queues {
+			seen[queue] = true
+		}
+		for queue := range m.Agents.Queues {
+			seen[queue] = true
+		}
+		for queue := range seen {
+			names = append(names, queue)
+		}
+		sort.Strings(names)
+	}
+
+	for _, queue := range names {
+		metrics = append(metrics, queueMetrics(queue, m.Jobs.Queues[queue], m.Agents.Queues[queue])...)
+	}
+
+	return metrics
+}
+
+func queueMetrics(queue string, jobs api.JobCounts, agents api.AgentCounts) []Metric {
+	return []Metric{
+		{Name: "ScheduledJobsCount", Queue: queue, Value: float64(jobs.Scheduled), Unit: MetricUnitCount},
+		{Name: "RunningJobsCount", Queue: queue, Value: float64(jobs.Running), Unit: MetricUnitCount},
+		{Name: "WaitingJobsCount", Queue: queue, Value: float64(jobs.Waiting), Unit: MetricUnitCount},
+		{Name: "ScheduledJobsWaitTime", Queue: queue, Value: jobs.ScheduledWaitSeconds, Unit: MetricUnitSeconds},
+		{Name: "IdleAgentCount", Queue: queue, Value: float64(agents.Idle), Unit: MetricUnitCount},
+		{Name: "BusyAgentCount", Queue: queue, Value: float64(agents.Busy), Unit: MetricUnitCount},
+		{Name: "TotalAgentCount", Queue: queue, Value: float64(agents.Total), Unit: MetricUnitCount},
+	}
+}
@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/api"
+)
+
+func testMetrics() *api.Metrics {
+	return &api.Metrics{
+		Jobs: api.JobMetrics{
+			JobCounts: api.JobCounts{Scheduled: 5, Running: 2, ScheduledWaitSeconds: 90},
+			Queues: map[string]api.JobCounts{
+				"default": {Scheduled: 3, Running: 2, ScheduledWaitSeconds: 90},
+				"deploy":  {Scheduled: 2},
+			},
+		},
+		Agents: api.AgentMetrics{
+			AgentCounts: api.AgentCounts{Idle: 1, Busy: 2, Total: 3},
+			Queues: map[string]api.AgentCounts{
+				"default": {Idle: 1, Busy: 2, Total: 3},
+				"windows": {},
+			},
+		},
+	}
+}
+
+func findMetric(metrics []Metric, name string, queue string) (Metric, bool) {
+	for _, m := range metrics {
+		if m.Name == name && m.Queue == queue {
+			return m, true
+		}
+	}
+
+	return Metric{}, false
+}
+
+func TestAutoscalingMetrics(t *testing.T) {
+	metrics := AutoscalingMetrics(testMetrics(), nil)
+
+	// The organization, and the three queues seen in jobs or agents
+	if len(metrics) != 4*7 {
+		t.Fatalf("Expected 28 metrics, got %d", len(metrics))
+	}
+
+	for _, tc := range []struct {
+		name  string
+		queue string
+		value float64
+	}{
+		{"ScheduledJobsCount", "", 5},
+		{"ScheduledJobsCount", "deploy", 2},
+		{"ScheduledJobsWaitTime", "default", 90},
+		{"IdleAgentCount", "default", 1},
+		{"IdleAgentCount", "deploy", 0},
+		{"TotalAgentCount", "windows", 0},
+	} {
+		m, ok := findMetric(metrics, tc.name, tc.queue)
+		if !ok {
+			t.Errorf("Missing %s for queue %q", tc.name, tc.queue)
+		} else if m.Value != tc.value {
+			t.Errorf("Expected %s for queue %q to be %v, got %v", tc.name, tc.queue, tc.value, m.Value)
+		}
+	}
+}
+
+func TestAutoscalingMetricsForQueues(t *testing.T) {
+	metrics := AutoscalingMetrics(testMetrics(), []string{"deploy"})
+
+	if len(metrics) != 2*7 {
+		t.Fatalf("Expected 14 metrics, got %d", len(metrics))
+	}
+	if _, ok := findMetric(metrics, "ScheduledJobsCount", "default"); ok {
+		t.Fatal("Didn't expect metrics for the default queue")
+	}
+}
+
+func TestCloudWatchPutMetricData(t *testing.T) {
+	timestamp := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	body := cloudWatchPutMetricData("Buildkite", []Metric{
+		{Name: "ScheduledJobsCount", Value: 5, Unit: MetricUnitCount},
+		{Name: "ScheduledJobsWaitTime", Queue: "default", Value: 1.5, Unit: MetricUnitSeconds},
+	}, timestamp)
+
+	values, err := url.ParseQuery(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]string{
+		"Action":                         "PutMetricData",
+		"Namespace":                      "Buildkite",
+		"MetricData.member.1.MetricName": "ScheduledJobsCount",
+		"MetricData.member.1.Value":      "5",
+		"MetricData.member.1.Dimensions.member.1.Name":  "",
+		"MetricData.member.2.Value":                     "1.5",
+		"MetricData.member.2.Unit":                      "Seconds",
+		"MetricData.member.2.Timestamp":                 "2018-10-01T12:00:00Z",
+		"MetricData.member.2.Dimensions.member.1.Name":  "Queue",
+		"MetricData.member.2.Dimensions.member.1.Value": "default",
+	} {
+		if actual := values.Get(key); actual != expected {
+			t.Errorf("Expected %s to be %q, got %q", key, expected, actual)
+		}
+	}
+}
+
+func TestStackdriverTimeSeriesRequest(t *testing.T) {
+	req := stackdriverTimeSeriesRequest("my-project", []Metric{
+		{Name: "IdleAgentCount", Queue: "default", Value: 2},
+	}, time.Now())
+
+	if len(req.TimeSeries) != 1 {
+		t.Fatalf("Expected 1 time series, got %d", len(req.TimeSeries))
+	}
+
+	ts := req.TimeSeries[0]
+	if ts.Metric.Type != "custom.googleapis.com/buildkite/IdleAgentCount" || ts.Metric.Labels["queue"] != "default" {
+		t.Errorf("Unexpected metric %#v", ts.Metric)
+	}
+	if ts.Resource.Labels["project_id"] != "my-project" {
+		t.Errorf("Unexpected resource %#v", ts.Resource)
+	}
+	if ts.Points[0].Value.DoubleValue != 2 {
+		t.Errorf("Unexpected value %v", ts.Points[0].Value.DoubleValue)
+	}
+}
+
+func TestNewMetricsPublisher(t *testing.T) {
+	if _, err := NewMetricsPublisher("stackdriver", "", ""); err == nil {
+		t.Error("Expected an error without a Stackdriver project")
+	}
+	if _, err := NewMetricsPublisher("datadog", "", ""); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+	if _, err := NewMetricsPublisher("cloudwatch", "Buildkite", ""); err != nil {
+		t.Error(err)
+	}
+}
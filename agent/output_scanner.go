@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// What to do when a secret is found in a job's output
+const (
+	SecretScanningOff  = "off"
+	SecretScanningWarn = "warn"
+	SecretScanningMask = "mask"
+	SecretScanningFail = "fail"
+)
+
+// A well-known kind of secret that shouldn't end up in a job log
+type secretPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+var secretPatterns = []secretPattern{
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+}
+
+// Private keys are masked from the start of the block through to its end,
+// which can be in later output
+var (
+	privateKeyBegin = regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`)
+	privateKeyEnd   = regexp.MustCompile(`-----END (?:[A-Z0-9]+ )*PRIVATE KEY-----`)
+)
+
+// ValidateSecretScanning returns an error if the mode isn't one we know about
+func ValidateSecretScanning(mode string) error {
+	switch mode {
+	case SecretScanningOff, SecretScanningWarn, SecretScanningMask, SecretScanningFail:
+		return nil
+	default:
+		return fmt.Errorf("Invalid secret scanning mode %q, must be one of off, warn, mask or fail", mode)
+	}
+}
+
+// OutputScanner looks for secrets in job output before it's uploaded
+type OutputScanner struct {
+	// One of the SecretScanning modes
+	Mode string
+
+	// Called the first time each kind of secret is found
+	OnDetect func(name string)
+
+	detected map[string]bool
+	mu       sync.Mutex
+
+	// Whether the output so far ended inside a private key
+	inPrivateKey bool
+}
+
+// Scan looks for secrets in the output, and returns it with them masked if
+// the mode calls for it. The returned output is always the same length, so
+// that log offsets don't change. It must be given the output in order, and
+// secrets are only found if they aren't split between calls.
+func (s *OutputScanner) Scan(output string) string {
+	if s == nil || s.Mode == "" || s.Mode == SecretScanningOff {
+		return output
+	}
+
+	for _, pattern := range secretPatterns {
+		if !pattern.Regexp.MatchString(output) {
+			continue
+		}
+
+		s.detect(pattern.Name)
+
+		// Failing the job still masks the secret, since the output
+		// up to the point the job is killed is still uploaded
+		if s.Mode == SecretScanningMask || s.Mode == SecretScanningFail {
+			output = pattern.Regexp.ReplaceAllStringFunc(output, func(secret string) string {
+				return strings.Repeat("*", len(secret))
+			})
+		}
+	}
+
+	return s.scanPrivateKeys(output)
+}
+
+// Finds private key blocks, and masks all of them apart from the line breaks
+func (s *OutputScanner) scanPrivateKeys(output string) string {
+	mask := s.Mode == SecretScanningMask || s.Mode == SecretScanningFail
+	masked := []byte(output)
+
+	for start := 0; start < len(output); {
+		if !s.inPrivateKey {
+			loc := privateKeyBegin.FindStringIndex(output[start:])
+			if loc == nil {
+				break
+			}
+			s.detect("private key")
+			s.inPrivateKey = true
+			start += loc[0]
+		}
+
+		end := len(output)
+		if loc := privateKeyEnd.FindStringIndex(output[start:]); loc != nil {
+			end = start + loc[1]
+			s.inPrivateKey = false
+		}
+
+		if mask {
+			for i := start; i < end; i++ {
+				if masked[i] != '\n' && masked[i] != '\r' {
+					masked[i] = '*'
+				}
+			}
+		}
+
+		start = end
+	}
+
+	return string(masked)
+}
+
+func (s *OutputScanner) detect(name string) {
+	s.mu.Lock()
+	if s.detected == nil {
+		s.detected = map[string]bool{}
+	}
+	first := !s.detected[name]
+	s.detected[name] = true
+	s.mu.Unlock()
+
+	if first && s.OnDetect != nil {
+		s.OnDetect(name)
+	}
+}
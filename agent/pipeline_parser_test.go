@@ -287,3 +287,58 @@ steps:
 	expected := `{"steps":[{"name":":s3: xxx","command":"script/buildkite/xxx.sh","plugins":{"xxx/aws-assume-role#v0.1.0":{"role":"arn:aws:iam::xxx:role/xxx"},"ecr#v1.1.4":{"login":true,"account_ids":"xxx","registry_region":"us-east-1"},"docker-compose#v2.5.1":{"run":"xxx","config":".buildkite/docker/docker-compose.yml","env":["AWS_ACCESS_KEY_ID","AWS_SECRET_ACCESS_KEY","AWS_SESSION_TOKEN"]}},"agents":{"queue":"xxx"}}]}`
 	assert.Equal(t, expected, strings.TrimSpace(buf.String()))
 }
+
+func TestPipelineParserPreservesOrderOfEnvBlocks(t *testing.T) {
+	var pipeline = `---
+env:
+  ZULU: "${FROM_ENV}"
+  ALPHA: global
+steps:
+  - command: "echo hello"
+    env:
+      ZULU: first
+      "${FROM_ENV}_KEY": second
+      MIKE: "${ALPHA}"
+      ALPHA: fourth
+    plugins:
+      - docker#v1.0.0:
+          zebra: 1
+          apple: 2`
+
+	environ := env.FromSlice([]string{`FROM_ENV=llamas`})
+
+	result, err := PipelineParser{Pipeline: []byte(pipeline), Env: environ}.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"env":{"ZULU":"llamas","ALPHA":"global"},"steps":[{"command":"echo hello","env":{"ZULU":"first","llamas_KEY":"second","MIKE":"global","ALPHA":"fourth"},"plugins":[{"docker#v1.0.0":{"zebra":1,"apple":2}}]}]}`
+	assert.Equal(t, expected, string(j))
+}
+
+func TestPipelineParserPreservesOrderOfTopLevelStepsEnv(t *testing.T) {
+	var pipeline = `[
+		{"command": "echo hello", "env": {"ZULU": "1", "ALPHA": "2", "MIKE": "3"}},
+		"wait"
+	]`
+
+	for _, noInterpolation := range []bool{false, true} {
+		result, err := PipelineParser{Pipeline: []byte(pipeline), NoInterpolation: noInterpolation}.Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		j, err := json.Marshal(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := `{"steps":[{"command":"echo hello","env":{"ZULU":"1","ALPHA":"2","MIKE":"3"}},"wait"]}`
+		assert.Equal(t, expected, string(j))
+	}
+}
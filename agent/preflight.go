@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// How far the clock can be from Buildkite's before jobs start failing, like
+// uploads to S3 that are signed with the time
+const PreflightMaxClockSkew = 5 * time.Minute
+
+// How long each command a check runs can take
+const preflightCommandTimeout = 30 * time.Second
+
+// Preflight checks that the host has what the agent needs to run jobs, so
+// that a misconfigured host fails before it registers rather than in the
+// middle of someone's build
+type Preflight struct {
+	// The Agent API endpoint, which should resolve and have the same time
+	// as us
+	Endpoint string
+
+	// Where builds are checked out, which has to be writable
+	BuildPath string
+}
+
+// PreflightResult is how one of the checks went. Warnings are problems that
+// only some jobs will run into, so they don't stop the agent.
+type PreflightResult struct {
+	Name    string
+	Message string
+	Err     error
+	Warning bool
+}
+
+// Run does each of the checks, and returns how they went
+func (p Preflight) Run() []PreflightResult {
+	checks := []struct {
+		name    string
+		warning bool
+		check   func() (string, error)
+	}{
+		{"build path", false, p.checkBuildPath},
+		{"git", false, p.checkGit},
+		{"ssh", true, p.checkSSH},
+		{"docker", true, p.checkDocker},
+		{"dns", false, p.checkDNS},
+		{"clock", false, p.checkClock},
+	}
+
+	var results []PreflightResult
+	for _, c := range checks {
+		message, err := c.check()
+		results = append(results, PreflightResult{
+			Name:    c.name,
+			Message: message,
+			Err:     err,
+			Warning: c.warning,
+		})
+	}
+
+	return results
+}
+
+// PreflightFailed returns whether any of the checks that stop the agent
+// failed
+func PreflightFailed(results []PreflightResult) bool {
+	for _, r := range results {
+		if r.Err != nil && !r.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Preflight) checkBuildPath() (string, error) {
+	if p.BuildPath == "" {
+		return "", fmt.Errorf("No build path is set, use --build-path to set one")
+	}
+
+	if err := os.MkdirAll(p.BuildPath, 0777); err != nil {
+		return "", fmt.Errorf("Couldn't create %s, make sure the agent's user can write to it: %v", p.BuildPath, err)
+	}
+
+	if err := checkWritable(p.BuildPath); err != nil {
+		return "", fmt.Errorf("Couldn't write to %s, make sure the agent's user can write to it: %v", p.BuildPath, err)
+	}
+
+	return fmt.Sprintf("%s is writable", p.BuildPath), nil
+}
+
+func (p Preflight) checkGit() (string, error) {
+	output, err := preflightCommand("git", "--version")
+	if err != nil {
+		return "", fmt.Errorf("git is needed to check out builds, install it and make sure it's in the agent's PATH: %v", err)
+	}
+
+	return output, nil
+}
+
+func (p Preflight) checkSSH() (string, error) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return "", fmt.Errorf("ssh isn't installed, so only repositories with https URLs can be checked out")
+	}
+
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		if output, err := preflightCommand("ssh-add", "-l"); err == nil {
+			return fmt.Sprintf("%d keys in the ssh agent", len(strings.Split(output, "\n"))), nil
+		}
+	}
+
+	home, _ := homedir.Dir()
+	for _, key := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		path := filepath.Join(home, ".ssh", key)
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Sprintf("found %s", path), nil
+		}
+	}
+
+	return "", fmt.Errorf("No ssh keys were found in an ssh agent or in %s, so private repositories with ssh URLs can't be checked out",
+		filepath.Join(home, ".ssh"))
+}
+
+func (p Preflight) checkDocker() (string, error) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "not installed", nil
+	}
+
+	output, err := preflightCommand("docker", "version", "--format", "{{.Server.Version}}")
+	if err != nil {
+		return "", fmt.Errorf("docker is installed, but the daemon can't be reached. Make sure it's running and the agent's user can use it: %v", err)
+	}
+
+	return fmt.Sprintf("daemon version %s", output), nil
+}
+
+func (p Preflight) endpointHost() (string, error) {
+	u, err := url.Parse(p.Endpoint)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("%q isn't a valid endpoint", p.Endpoint)
+	}
+	return u.Hostname(), nil
+}
+
+func (p Preflight) checkDNS() (string, error) {
+	host, err := p.endpointHost()
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return "", fmt.Errorf("%s doesn't resolve, check the host's DNS settings: %v", host, err)
+	}
+
+	return fmt.Sprintf("%s resolves to %s", host, strings.Join(addrs, ", ")), nil
+}
+
+// checkClock compares our time with the Date header from the Agent API
+func (p Preflight) checkClock() (string, error) {
+	if _, err := p.endpointHost(); err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	before := time.Now()
+	resp, err := client.Get(p.Endpoint)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't reach %s, check the host's network and any proxy settings: %v", p.Endpoint, err)
+	}
+	resp.Body.Close()
+	after := time.Now()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return "reachable, but it didn't say what time it is", nil
+	}
+
+	// The server's time is from somewhere during the request
+	ours := before.Add(after.Sub(before) / 2)
+	skew := ours.Sub(date)
+	if skew < 0 {
+		skew = -skew
+	}
+
+	// Date headers only have whole seconds
+	skew = skew.Truncate(time.Second)
+
+	if skew > PreflightMaxClockSkew {
+		return "", fmt.Errorf("The clock is %s out, which breaks things like artifact uploads. Make sure the host syncs its time with NTP", skew)
+	}
+
+	return fmt.Sprintf("%s out", skew), nil
+}
+
+func preflightCommand(command string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), preflightCommandTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, command, args...).CombinedOutput()
+	if err != nil {
+		if len(output) > 0 {
+			return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
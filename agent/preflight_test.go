@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreflightCreatesBuildPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := Preflight{BuildPath: filepath.Join(dir, "builds")}
+
+	_, err = p.checkBuildPath()
+	assert.NoError(t, err)
+
+	info, err := os.Stat(p.BuildPath)
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestPreflightFailsWithoutBuildPath(t *testing.T) {
+	_, err := Preflight{}.checkBuildPath()
+	assert.Error(t, err)
+}
+
+func TestPreflightChecksClockSkew(t *testing.T) {
+	var offset time.Duration
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	p := Preflight{Endpoint: server.URL}
+
+	_, err := p.checkClock()
+	assert.NoError(t, err)
+
+	offset = -10 * time.Minute
+	_, err = p.checkClock()
+	assert.Error(t, err)
+
+	offset = 10 * time.Minute
+	_, err = p.checkClock()
+	assert.Error(t, err)
+}
+
+func TestPreflightFailedIgnoresWarnings(t *testing.T) {
+	assert.False(t, PreflightFailed([]PreflightResult{
+		{Name: "git"},
+		{Name: "ssh", Err: errors.New("no keys"), Warning: true},
+	}))
+
+	assert.True(t, PreflightFailed([]PreflightResult{
+		{Name: "git", Err: errors.New("not installed")},
+		{Name: "ssh"},
+	}))
+}
@@ -3,7 +3,10 @@ package agent
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -31,6 +34,9 @@ func (e *credentialsProvider) Retrieve() (creds credentials.Value, err error) {
 		creds.SecretAccessKey = os.Getenv("BUILDKITE_S3_SECRET_KEY")
 	}
 
+	// Keep the secret out of any debug output
+	logger.RedactValue(creds.SecretAccessKey)
+
 	if creds.AccessKeyID == "" {
 		err = errors.New("BUILDKITE_S3_ACCESS_KEY_ID or BUILDKITE_S3_ACCESS_KEY not found in environment")
 	}
@@ -46,10 +52,70 @@ func (e *credentialsProvider) IsExpired() bool {
 	return !e.retrieved
 }
 
-func awsS3RegionFromEnv() (region string, err error) {
+// S3Config is how to reach S3, or a store that's compatible with it like
+// MinIO or Ceph RGW. Anything that isn't set comes from the environment.
+type S3Config struct {
+	// The URL of the store, like http://minio.internal:9000, instead of AWS.
+	// From $BUILDKITE_S3_ENDPOINT.
+	Endpoint string
+
+	// Whether buckets are addressed in the path of the URL rather than the
+	// host, which most S3-compatible stores need. From
+	// $BUILDKITE_S3_FORCE_PATH_STYLE.
+	ForcePathStyle bool
+
+	// The region, which doesn't have to be one of AWS's with a custom
+	// endpoint. From $BUILDKITE_S3_DEFAULT_REGION.
+	Region string
+}
+
+func (c S3Config) withEnv() S3Config {
+	if c.Endpoint == "" {
+		c.Endpoint = os.Getenv("BUILDKITE_S3_ENDPOINT")
+	}
+	if !c.ForcePathStyle {
+		c.ForcePathStyle, _ = strconv.ParseBool(os.Getenv("BUILDKITE_S3_FORCE_PATH_STYLE"))
+	}
+	if c.Region == "" {
+		c.Region = os.Getenv("BUILDKITE_S3_DEFAULT_REGION")
+	}
+
+	return c
+}
+
+// bucketURL returns the URL a bucket is at, or an empty string if it's in
+// AWS
+func (c S3Config) bucketURL(bucket string) string {
+	if c.Endpoint == "" {
+		return ""
+	}
+
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return ""
+	}
+
+	if c.ForcePathStyle {
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + bucket
+	} else {
+		endpoint.Host = bucket + "." + endpoint.Host
+	}
+
+	return endpoint.String()
+}
+
+func awsS3Region(config S3Config) (region string, err error) {
+	// Stores other than AWS can have regions of their own
+	if config.Endpoint != "" {
+		if config.Region == "" {
+			return "us-east-1", nil
+		}
+		return config.Region, nil
+	}
+
 	regionName := "us-east-1"
-	if os.Getenv("BUILDKITE_S3_DEFAULT_REGION") != "" {
-		regionName = os.Getenv("BUILDKITE_S3_DEFAULT_REGION")
+	if config.Region != "" {
+		regionName = config.Region
 	} else {
 		var err error
 		regionName, err = awsRegion()
@@ -93,8 +159,10 @@ func awsS3Session(region string) (*session.Session, error) {
 	return sess, nil
 }
 
-func newS3Client(bucket string) (*s3.S3, error) {
-	region, err := awsS3RegionFromEnv()
+func newS3Client(bucket string, config S3Config) (*s3.S3, error) {
+	config = config.withEnv()
+
+	region, err := awsS3Region(config)
 	if err != nil {
 		return nil, err
 	}
@@ -104,6 +172,11 @@ func newS3Client(bucket string) (*s3.S3, error) {
 		return nil, err
 	}
 
+	if config.Endpoint != "" {
+		sess.Config.Endpoint = aws.String(config.Endpoint)
+	}
+	sess.Config.S3ForcePathStyle = aws.Bool(config.ForcePathStyle)
+
 	logger.Debug("Authorizing S3 credentials and finding bucket `%s` in region `%s`...", bucket, region)
 
 	s3client := s3.New(sess)
@@ -3,6 +3,7 @@ package agent
 import (
 	"fmt"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -26,11 +27,21 @@ type S3Downloader struct {
 
 	// If failed responses should be dumped to the log
 	DebugHTTP bool
+
+	// The permissions of created directories and the downloaded file
+	DirMode  os.FileMode
+	FileMode os.FileMode
+
+	// How the file was compressed when it was uploaded, if it was
+	ContentEncoding string
+
+	// How to reach S3, or a store that's compatible with it
+	Config S3Config
 }
 
 func (d S3Downloader) Start() error {
 	// Initialize the s3 client, and authenticate it
-	s3Client, err := newS3Client(d.BucketName())
+	s3Client, err := newS3Client(d.BucketName(), d.Config)
 	if err != nil {
 		return err
 	}
@@ -47,12 +58,15 @@ func (d S3Downloader) Start() error {
 
 	// We can now cheat and pass the URL onto our regular downloader
 	return Download{
-		Client:      *http.DefaultClient,
-		URL:         signedURL,
-		Path:        d.Path,
-		Destination: d.Destination,
-		Retries:     d.Retries,
-		DebugHTTP:   d.DebugHTTP,
+		Client:          *http.DefaultClient,
+		URL:             signedURL,
+		Path:            d.Path,
+		Destination:     d.Destination,
+		Retries:         d.Retries,
+		DebugHTTP:       d.DebugHTTP,
+		DirMode:         d.DirMode,
+		FileMode:        d.FileMode,
+		ContentEncoding: d.ContentEncoding,
 	}.Start()
 }
 
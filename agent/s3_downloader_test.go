@@ -1,6 +1,11 @@
 package agent
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,3 +40,44 @@ func TestS3DowloaderBucketFileLocation(t *testing.T) {
 	s3Uploader.Path = ""
 	assert.Equal(t, s3Uploader.BucketFileLocation(), "s3/folder/")
 }
+
+func TestS3DownloaderUsesACustomEndpoint(t *testing.T) {
+	for key, value := range map[string]string{"AWS_ACCESS_KEY_ID": "llamas", "AWS_SECRET_ACCESS_KEY": "alpacas"} {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Setenv(key, value)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/my-bucket":
+			w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+		case "/my-bucket/artifacts/llamas.txt":
+			w.Write([]byte("llamas"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "s3-downloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = S3Downloader{
+		Bucket:      "s3://my-bucket/artifacts",
+		Path:        "llamas.txt",
+		Destination: dir,
+		Config:      S3Config{Endpoint: server.URL, ForcePathStyle: true},
+	}.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "llamas.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "llamas", string(b))
+}
@@ -23,6 +23,9 @@ type S3Uploader struct {
 	// Whether or not HTTP calls should be debugged
 	DebugHTTP bool
 
+	// Where the bucket is, if it isn't in AWS
+	Config S3Config
+
 	// The aws s3 client
 	s3Client *s3.S3
 }
@@ -32,7 +35,7 @@ func (u *S3Uploader) Setup(destination string, debugHTTP bool) error {
 	u.DebugHTTP = debugHTTP
 
 	// Initialize the s3 client, and authenticate it
-	s3Client, err := newS3Client(u.BucketName())
+	s3Client, err := newS3Client(u.BucketName(), u.Config)
 	if err != nil {
 		return err
 	}
@@ -46,11 +49,13 @@ func (u *S3Uploader) URL(artifact *api.Artifact) string {
 
 	if os.Getenv("BUILDKITE_S3_ACCESS_URL") != "" {
 		baseUrl = os.Getenv("BUILDKITE_S3_ACCESS_URL")
+	} else if bucketURL := u.Config.withEnv().bucketURL(u.BucketName()); bucketURL != "" {
+		baseUrl = bucketURL
 	}
 
 	url, _ := url.Parse(baseUrl)
 
-	url.Path += u.artifactPath(artifact)
+	url.Path = strings.TrimSuffix(url.Path, "/") + "/" + strings.TrimPrefix(u.artifactPath(artifact), "/")
 
 	return url.String()
 }
@@ -74,7 +79,7 @@ func (u *S3Uploader) Upload(artifact *api.Artifact) error {
 	}
 
 	// Initialize the s3 client, and authenticate it
-	s3Client, err := newS3Client(u.BucketName())
+	s3Client, err := newS3Client(u.BucketName(), u.Config)
 	if err != nil {
 		return err
 	}
@@ -91,13 +96,17 @@ func (u *S3Uploader) Upload(artifact *api.Artifact) error {
 
 	// Upload the file to S3.
 	logger.Debug("Uploading \"%s\" to bucket with permission `%s`", u.artifactPath(artifact), permission)
-	_, err = uploader.Upload(&s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket:      aws.String(u.BucketName()),
 		Key:         aws.String(u.artifactPath(artifact)),
 		ContentType: aws.String(u.mimeType(artifact)),
 		ACL:         aws.String(permission),
 		Body:        f,
-	})
+	}
+	if artifact.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(artifact.ContentEncoding)
+	}
+	_, err = uploader.Upload(input)
 
 	return err
 }
@@ -1,8 +1,10 @@
 package agent
 
 import (
+	"os"
 	"testing"
 
+	"github.com/buildkite/agent/api"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,3 +23,29 @@ func TestS3UploaderBucketName(t *testing.T) {
 	s3Uploader.Destination = "s3://starts-with-an-s"
 	assert.Equal(t, s3Uploader.BucketName(), "starts-with-an-s")
 }
+
+func TestS3UploaderURLWithACustomEndpoint(t *testing.T) {
+	os.Unsetenv("BUILDKITE_S3_ACCESS_URL")
+
+	s3Uploader := S3Uploader{
+		Destination: "s3://my-bucket-name/foo",
+		Config:      S3Config{Endpoint: "http://minio.internal:9000", ForcePathStyle: true},
+	}
+	artifact := &api.Artifact{Path: "llamas.txt"}
+	assert.Equal(t, "http://minio.internal:9000/my-bucket-name/foo/llamas.txt", s3Uploader.URL(artifact))
+
+	s3Uploader.Config.ForcePathStyle = false
+	assert.Equal(t, "http://my-bucket-name.minio.internal:9000/foo/llamas.txt", s3Uploader.URL(artifact))
+
+	s3Uploader.Config = S3Config{}
+	assert.Equal(t, "https://my-bucket-name.s3.amazonaws.com/foo/llamas.txt", s3Uploader.URL(artifact))
+}
+
+func TestS3RegionWithACustomEndpoint(t *testing.T) {
+	region, err := awsS3Region(S3Config{Endpoint: "http://ceph.internal", Region: "ceph-datacenter-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ceph-datacenter-1", region)
+
+	_, err = awsS3Region(S3Config{Region: "ceph-datacenter-1"})
+	assert.Error(t, err)
+}
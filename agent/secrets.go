@@ -0,0 +1,247 @@
+package agent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/retry"
+	"golang.org/x/oauth2/google"
+)
+
+// The secret managers that config values can be fetched from, by the prefix
+// used to refer to them, e.g. aws-ssm:/buildkite/agent-token
+var secretFetchers = map[string]func(path string) (string, error){
+	"aws-ssm":             fetchAWSSSMParameter,
+	"aws-secrets-manager": fetchAWSSecretsManagerSecret,
+	"gcp-secret-manager":  fetchGCPSecret,
+	"vault":               fetchVaultSecret,
+}
+
+// The field of a Vault secret that's used if one isn't given
+const defaultVaultSecretField = "token"
+
+// How long a request to a secret manager can take before it's given up on,
+// so one that never responds doesn't block the agent from starting
+var secretRequestTimeout = 30 * time.Second
+
+// parseSecretReference splits a reference to a secret into the secret manager
+// and the path of the secret in it. Values that aren't references return an
+// empty secret manager.
+func parseSecretReference(value string) (string, string) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+
+	if _, ok := secretFetchers[parts[0]]; !ok {
+		return "", ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// FetchSecret returns the secret that a value refers to, if it refers to one
+// in a secret manager, so that things like the agent token never need to be
+// written to disk or instance user-data. Any other value is returned as is.
+func FetchSecret(value string) (string, error) {
+	manager, path := parseSecretReference(value)
+	if manager == "" {
+		return value, nil
+	}
+
+	if path == "" {
+		return "", fmt.Errorf("%q doesn't say which secret to fetch", value)
+	}
+
+	logger.Info("Fetching secret from %s", value)
+
+	var secret string
+
+	// Secret managers often aren't reachable straight away when a host
+	// boots, like before its instance role is available
+	err := retry.Do(func(s *retry.Stats) error {
+		var err error
+		secret, err = secretFetchers[manager](path)
+		if err != nil {
+			logger.Warn("Failed to fetch secret from %s: %v (%s)", value, err, s)
+		}
+		return err
+	}, &retry.Config{Maximum: 5, Interval: 2 * time.Second, Backoff: 2})
+	if err != nil {
+		return "", fmt.Errorf("Failed to fetch secret from %s: %v", value, err)
+	}
+
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return "", fmt.Errorf("The secret at %s is empty", value)
+	}
+
+	return secret, nil
+}
+
+// fetchAWSSSMParameter fetches a parameter from AWS Systems Manager Parameter
+// Store, decrypting it if it's a SecureString
+func fetchAWSSSMParameter(name string) (string, error) {
+	sess, err := awsSession()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ssm.New(sess).GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(output.Parameter.Value), nil
+}
+
+// fetchAWSSecretsManagerSecret fetches the current version of a secret from
+// AWS Secrets Manager, by its name or ARN
+func fetchAWSSecretsManagerSecret(id string) (string, error) {
+	sess, err := awsSession()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := secretsmanager.New(sess).GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if output.SecretString != nil {
+		return aws.StringValue(output.SecretString), nil
+	}
+
+	return string(output.SecretBinary), nil
+}
+
+// fetchGCPSecret fetches a secret from Google Cloud Secret Manager, given as
+// projects/<project>/secrets/<secret>, with an optional /versions/<version>
+// that defaults to the latest
+func fetchGCPSecret(name string) (string, error) {
+	name = strings.Trim(name, "/")
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/secrets/") {
+		return "", retry.Permanent(fmt.Errorf("%q should look like projects/<project>/secrets/<secret>", name))
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+
+	client, err := google.DefaultClient(context.Background(), "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return "", fmt.Errorf("Error creating Google Cloud client: %v", err)
+	}
+
+	var response struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+
+	if err := getSecretJSON(client, "https://secretmanager.googleapis.com/v1/"+name+":access", nil, &response); err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(response.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// fetchVaultSecret fetches a field of a secret from HashiCorp Vault, given as
+// <path>#<field>, using the usual VAULT_ADDR and VAULT_TOKEN. Both versions of
+// the key/value secrets engine work.
+func fetchVaultSecret(path string) (string, error) {
+	field := defaultVaultSecretField
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		path, field = path[:i], path[i+1:]
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", retry.Permanent(fmt.Errorf("VAULT_ADDR needs to be set to fetch secrets from Vault"))
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		return "", retry.Permanent(fmt.Errorf("VAULT_TOKEN needs to be set to fetch secrets from Vault"))
+	}
+
+	headers := map[string]string{"X-Vault-Token": token}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		headers["X-Vault-Namespace"] = namespace
+	}
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+
+	url := strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	if err := getSecretJSON(http.DefaultClient, url, headers, &response); err != nil {
+		return "", err
+	}
+
+	// Version 2 of the key/value engine nests the secret in another data
+	data := response.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	value, ok := data[field].(string)
+	if !ok {
+		return "", retry.Permanent(fmt.Errorf("The secret at %s has no %q field", path, field))
+	}
+
+	return value, nil
+}
+
+func getSecretJSON(client *http.Client, url string, headers map[string]string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretRequestTimeout)
+	defer cancel()
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecretReference(t *testing.T) {
+	for _, tc := range []struct {
+		value, manager, path string
+	}{
+		{"aws-ssm:/buildkite/agent-token", "aws-ssm", "/buildkite/agent-token"},
+		{"aws-secrets-manager:buildkite/agent-token", "aws-secrets-manager", "buildkite/agent-token"},
+		{"gcp-secret-manager:projects/llamas/secrets/token", "gcp-secret-manager", "projects/llamas/secrets/token"},
+		{"vault:secret/data/buildkite#token", "vault", "secret/data/buildkite#token"},
+		{"abc123", "", ""},
+		{"something:else", "", ""},
+	} {
+		manager, path := parseSecretReference(tc.value)
+		assert.Equal(t, tc.manager, manager, tc.value)
+		assert.Equal(t, tc.path, path, tc.value)
+	}
+}
+
+func TestFetchSecretReturnsPlainValues(t *testing.T) {
+	secret, err := FetchSecret("abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", secret)
+}
+
+func TestFetchSecretFromVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "llamas" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/buildkite":
+			w.Write([]byte(`{"data":{"token":"kv1-token\n"}}`))
+		case "/v1/secret/data/buildkite":
+			w.Write([]byte(`{"data":{"data":{"agent-token":"kv2-token"},"metadata":{"version":1}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer os.Setenv("VAULT_ADDR", os.Getenv("VAULT_ADDR"))
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Setenv("VAULT_ADDR", server.URL)
+	os.Setenv("VAULT_TOKEN", "llamas")
+
+	secret, err := FetchSecret("vault:secret/buildkite")
+	assert.NoError(t, err)
+	assert.Equal(t, "kv1-token", secret)
+
+	secret, err = FetchSecret("vault:secret/data/buildkite#agent-token")
+	assert.NoError(t, err)
+	assert.Equal(t, "kv2-token", secret)
+
+	_, err = FetchSecret("vault:secret/data/buildkite#nope")
+	assert.Error(t, err)
+}
+
+func TestGettingSecretsGivesUpOnHungRequests(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+
+	defer func(timeout time.Duration) { secretRequestTimeout = timeout }(secretRequestTimeout)
+	secretRequestTimeout = 10 * time.Millisecond
+
+	err := getSecretJSON(http.DefaultClient, server.URL+"/v1/secret/buildkite", nil, &struct{}{})
+	assert.Error(t, err)
+}
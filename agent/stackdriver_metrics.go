@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// Stackdriver only takes so many time series in each request
+const stackdriverMaxTimeSeries = 200
+
+const stackdriverMetricPrefix = "custom.googleapis.com/buildkite/"
+
+// StackdriverMetricsPublisher writes metrics to Stackdriver Monitoring as
+// custom metrics, using the application default credentials
+type StackdriverMetricsPublisher struct {
+	// The Google Cloud project the metrics are written to
+	ProjectID string
+}
+
+func (p *StackdriverMetricsPublisher) String() string {
+	return fmt.Sprintf("Stackdriver project %s", p.ProjectID)
+}
+
+func (p *StackdriverMetricsPublisher) Publish(metrics []Metric) error {
+	client, err := google.DefaultClient(context.Background(), "https://www.googleapis.com/auth/monitoring.write")
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("https://monitoring.googleapis.com/v3/projects/%s/timeSeries", p.ProjectID)
+
+	for start := 0; start < len(metrics); start += stackdriverMaxTimeSeries {
+		end := start + stackdriverMaxTimeSeries
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+
+		body, err := json.Marshal(stackdriverTimeSeriesRequest(p.ProjectID, metrics[start:end], time.Now()))
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("Stackdriver responded with %s: %s", resp.Status, respBody)
+		}
+	}
+
+	return nil
+}
+
+// The parts of the timeSeries.create request that we use, see
+// https://cloud.google.com/monitoring/api/ref_v3/rest/v3/projects.timeSeries/create
+
+type stackdriverRequest struct {
+	TimeSeries []stackdriverTimeSeries `json:"timeSeries"`
+}
+
+type stackdriverTimeSeries struct {
+	Metric   stackdriverMetric   `json:"metric"`
+	Resource stackdriverResource `json:"resource"`
+	Points   []stackdriverPoint  `json:"points"`
+}
+
+type stackdriverMetric struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type stackdriverResource struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+}
+
+type stackdriverPoint struct {
+	Interval stackdriverInterval `json:"interval"`
+	Value    stackdriverValue    `json:"value"`
+}
+
+type stackdriverInterval struct {
+	EndTime string `json:"endTime"`
+}
+
+type stackdriverValue struct {
+	DoubleValue float64 `json:"doubleValue"`
+}
+
+func stackdriverTimeSeriesRequest(project string, metrics []Metric, timestamp time.Time) stackdriverRequest {
+	req := stackdriverRequest{TimeSeries: make([]stackdriverTimeSeries, 0, len(metrics))}
+
+	for _, m := range metrics {
+		metric := stackdriverMetric{Type: stackdriverMetricPrefix + m.Name}
+		if m.Queue != "" {
+			metric.Labels = map[string]string{"queue": m.Queue}
+		}
+
+		req.TimeSeries = append(req.TimeSeries, stackdriverTimeSeries{
+			Metric: metric,
+			Resource: stackdriverResource{
+				Type:   "global",
+				Labels: map[string]string{"project_id": project},
+			},
+			Points: []stackdriverPoint{{
+				Interval: stackdriverInterval{EndTime: timestamp.UTC().Format(time.RFC3339Nano)},
+				Value:    stackdriverValue{DoubleValue: m.Value},
+			}},
+		})
+	}
+
+	return req
+}
@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// SupportBundle is a gzipped tarball of the files that help work out what's
+// wrong with an agent, for attaching to support requests and bug reports.
+// Everything added to it goes through the log redaction first.
+type SupportBundle struct {
+	dir string
+	gz  *gzip.Writer
+	tw  *tar.Writer
+}
+
+// NewSupportBundle starts writing a bundle to w, with all of its files inside
+// a directory so it extracts cleanly
+func NewSupportBundle(w io.Writer, dir string) *SupportBundle {
+	gz := gzip.NewWriter(w)
+
+	return &SupportBundle{
+		dir: dir,
+		gz:  gz,
+		tw:  tar.NewWriter(gz),
+	}
+}
+
+// Add writes a file to the bundle
+func (b *SupportBundle) Add(name string, body string) error {
+	body = logger.Redact(body)
+
+	hdr := &tar.Header{
+		Name:    path.Join(b.dir, name),
+		Mode:    0644,
+		Size:    int64(len(body)),
+		ModTime: time.Now(),
+	}
+
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(b.tw, body)
+	return err
+}
+
+// AddFileTail writes the last max bytes of a file to the bundle, which is
+// what's useful from a long log file
+func (b *SupportBundle) AddFileTail(name string, filename string, max int64) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() > max {
+		if _, err := f.Seek(-max, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+
+	body, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	// Don't start half way through a line
+	if info.Size() > max {
+		if i := bytes.IndexByte(body, '\n'); i >= 0 {
+			body = body[i+1:]
+		}
+	}
+
+	return b.Add(name, string(body))
+}
+
+// AddCommand writes the output of a command to the bundle. Commands that
+// fail or don't exist are noted in the file rather than failing the bundle.
+func (b *SupportBundle) AddCommand(name string, command string, args ...string) error {
+	cmd := exec.Command(command, args...)
+	output, err := cmd.CombinedOutput()
+
+	body := fmt.Sprintf("$ %s\n%s", strings.Join(append([]string{command}, args...), " "), output)
+	if err != nil {
+		body += fmt.Sprintf("\n%v\n", err)
+	}
+
+	return b.Add(name, body)
+}
+
+// Close finishes writing the bundle
+func (b *SupportBundle) Close() error {
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+
+	return b.gz.Close()
+}
+
+var supportSecretNameRegex = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSWORD|PASSPHRASE|PRIVATE|ACCESS_KEY|CREDENTIALS)`)
+
+// SupportEnvironment lists the environment for a support bundle. We can't
+// know which variables hold secrets, so only the values of our own
+// variables are shown, and not even those if they look like secrets.
+func SupportEnvironment(environ []string) string {
+	lines := []string{}
+
+	for _, pair := range environ {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+
+		name, value := parts[0], parts[1]
+		switch {
+		case supportSecretNameRegex.MatchString(name) && value != "":
+			value = logger.RedactedReplacement
+		case !strings.HasPrefix(name, "BUILDKITE"):
+			value = "(hidden)"
+		default:
+			value = logger.RedactURLs(value)
+		}
+
+		lines = append(lines, fmt.Sprintf("%s=%s", name, value))
+	}
+
+	sort.Strings(lines)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// SupportVersion describes the agent and the machine it's running on
+func SupportVersion() string {
+	hostname, _ := os.Hostname()
+
+	return fmt.Sprintf("buildkite-agent %s, build %s\ngo %s\nos %s\narch %s\nhostname %s\n",
+		Version(), BuildVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH, hostname)
+}
+
+// SupportChecks checks the things an agent needs to work, which are being
+// able to reach the Agent API and write to its directories, and describes
+// how each one went
+func SupportChecks(endpoint string, dirs []string) string {
+	var buf bytes.Buffer
+
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if err := checkWritable(dir); err != nil {
+			fmt.Fprintf(&buf, "FAIL %s is writable: %v\n", dir, err)
+		} else {
+			fmt.Fprintf(&buf, "OK   %s is writable\n", dir)
+		}
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		fmt.Fprintf(&buf, "FAIL %s is a valid endpoint: %v\n", endpoint, err)
+		return buf.String()
+	}
+
+	start := time.Now()
+	addrs, err := net.LookupHost(u.Hostname())
+	if err != nil {
+		fmt.Fprintf(&buf, "FAIL %s resolves: %v\n", u.Hostname(), err)
+		return buf.String()
+	}
+	fmt.Fprintf(&buf, "OK   %s resolves to %s (%v)\n", u.Hostname(), strings.Join(addrs, ", "), time.Since(start))
+
+	// Any response at all means the network is fine, the API will just
+	// turn away a request without a token
+	client := &http.Client{Timeout: 30 * time.Second}
+	start = time.Now()
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		fmt.Fprintf(&buf, "FAIL %s is reachable: %v\n", endpoint, err)
+		return buf.String()
+	}
+	resp.Body.Close()
+	fmt.Fprintf(&buf, "OK   %s is reachable: %s (%v)\n", endpoint, resp.Status, time.Since(start))
+
+	return buf.String()
+}
+
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".buildkite-agent-support")
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func readSupportBundle(t *testing.T, r io.Reader) map[string]string {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(body)
+	}
+
+	return files
+}
+
+func TestSupportBundleRedactsFiles(t *testing.T) {
+	logger.RedactValue("llamas-are-secret")
+
+	var buf bytes.Buffer
+	bundle := NewSupportBundle(&buf, "support")
+	assert.NoError(t, bundle.Add("config.txt", "token llamas-are-secret\n"))
+	assert.NoError(t, bundle.Close())
+
+	assert.Equal(t, map[string]string{
+		"support/config.txt": "token [REDACTED]\n",
+	}, readSupportBundle(t, &buf))
+}
+
+func TestSupportBundleOnlyIncludesTheEndOfFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "support-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "agent.log")
+	body := strings.Repeat("an old line\n", 10) + "the last line\n"
+	if err := ioutil.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	bundle := NewSupportBundle(&buf, "support")
+	assert.NoError(t, bundle.AddFileTail("logs/agent.log", path, 20))
+	assert.NoError(t, bundle.Close())
+
+	assert.Equal(t, map[string]string{
+		"support/logs/agent.log": "the last line\n",
+	}, readSupportBundle(t, &buf))
+}
+
+func TestSupportEnvironmentHidesValues(t *testing.T) {
+	assert.Equal(t, strings.Join([]string{
+		"BUILDKITE_AGENT_NAME=llama",
+		"BUILDKITE_AGENT_TOKEN=[REDACTED]",
+		"BUILDKITE_GIT_ARCHIVE_URL=https://example.com/repo.tgz?[REDACTED]",
+		"HOME=(hidden)",
+		"THIRD_PARTY_API_KEY=(hidden)",
+	}, "\n")+"\n", SupportEnvironment([]string{
+		"THIRD_PARTY_API_KEY=abc123",
+		"BUILDKITE_AGENT_TOKEN=xxx",
+		"BUILDKITE_GIT_ARCHIVE_URL=https://example.com/repo.tgz?signature=secret",
+		"HOME=/home/llama",
+		"BUILDKITE_AGENT_NAME=llama",
+	}))
+}
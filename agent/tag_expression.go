@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TagExpression is a boolean expression over an agent's tags, like
+// `queue=default && docker && !gpu`, that a job can use to say which agents
+// should run it. It supports:
+//
+// key=value, key!=value  The tag has (or doesn't have) the value
+// key                    The tag is set, and isn't "false"
+// !, &&, ||, ( )         Not, and, or, and grouping
+//
+// Values with spaces or operators in them can be double quoted.
+type TagExpression interface {
+	Match(tags map[string]string) bool
+	String() string
+}
+
+// The env a job's tag expression is set in, from its step
+const tagExpressionEnv = "BUILDKITE_TAG_EXPRESSION"
+
+// ParseTagExpression parses a tag expression
+func ParseTagExpression(expression string) (TagExpression, error) {
+	tokens, err := tokenizeTagExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &tagExpressionParser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid tag expression %q: %v", expression, err)
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("Invalid tag expression %q: unexpected %q", expression, p.tokens[p.pos].text)
+	}
+
+	return expr, nil
+}
+
+// TagsToMap turns an agent's tags into a map. A tag without a value, like
+// "docker", is treated as "docker=true".
+func TagsToMap(tags []string) map[string]string {
+	m := map[string]string{}
+
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) == 2 {
+			m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		} else {
+			m[strings.TrimSpace(parts[0])] = "true"
+		}
+	}
+
+	return m
+}
+
+type tagTerm struct {
+	key      string
+	value    string
+	hasValue bool
+	negated  bool
+}
+
+func (t tagTerm) Match(tags map[string]string) bool {
+	value, ok := tags[t.key]
+
+	if !t.hasValue {
+		return ok && value != "false"
+	}
+
+	return (ok && value == t.value) != t.negated
+}
+
+func (t tagTerm) String() string {
+	switch {
+	case !t.hasValue:
+		return t.key
+	case t.negated:
+		return fmt.Sprintf("%s!=%q", t.key, t.value)
+	default:
+		return fmt.Sprintf("%s=%q", t.key, t.value)
+	}
+}
+
+type tagNot struct {
+	expr TagExpression
+}
+
+func (n tagNot) Match(tags map[string]string) bool {
+	return !n.expr.Match(tags)
+}
+
+func (n tagNot) String() string {
+	return "!" + n.expr.String()
+}
+
+type tagAnd struct {
+	left, right TagExpression
+}
+
+func (a tagAnd) Match(tags map[string]string) bool {
+	return a.left.Match(tags) && a.right.Match(tags)
+}
+
+func (a tagAnd) String() string {
+	return fmt.Sprintf("(%s && %s)", a.left, a.right)
+}
+
+type tagOr struct {
+	left, right TagExpression
+}
+
+func (o tagOr) Match(tags map[string]string) bool {
+	return o.left.Match(tags) || o.right.Match(tags)
+}
+
+func (o tagOr) String() string {
+	return fmt.Sprintf("(%s || %s)", o.left, o.right)
+}
+
+type tagToken struct {
+	text string
+	word bool
+}
+
+func tokenizeTagExpression(expression string) ([]tagToken, error) {
+	var tokens []tagToken
+
+	for i := 0; i < len(expression); {
+		c := expression[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.HasPrefix(expression[i:], "&&"), strings.HasPrefix(expression[i:], "||"), strings.HasPrefix(expression[i:], "!="):
+			tokens = append(tokens, tagToken{text: expression[i : i+2]})
+			i += 2
+		case c == '!' || c == '(' || c == ')' || c == '=':
+			tokens = append(tokens, tagToken{text: string(c)})
+			i++
+		case c == '"':
+			end := strings.IndexByte(expression[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("Invalid tag expression %q: unterminated quote", expression)
+			}
+			tokens = append(tokens, tagToken{text: expression[i+1 : i+1+end], word: true})
+			i += end + 2
+		default:
+			start := i
+			for i < len(expression) && isTagWordChar(rune(expression[i])) {
+				i++
+			}
+			if i == start {
+				return nil, fmt.Errorf("Invalid tag expression %q: unexpected %q", expression, c)
+			}
+			tokens = append(tokens, tagToken{text: expression[start:i], word: true})
+		}
+	}
+
+	return tokens, nil
+}
+
+func isTagWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.:/@+", r)
+}
+
+type tagExpressionParser struct {
+	tokens []tagToken
+	pos    int
+}
+
+func (p *tagExpressionParser) peek() (tagToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return tagToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *tagExpressionParser) accept(op string) bool {
+	if t, ok := p.peek(); ok && !t.word && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tagExpressionParser) parseOr() (TagExpression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = tagOr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *tagExpressionParser) parseAnd() (TagExpression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = tagAnd{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *tagExpressionParser) parseUnary() (TagExpression, error) {
+	if p.accept("!") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{expr}, nil
+	}
+
+	if p.accept("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return expr, nil
+	}
+
+	return p.parseTerm()
+}
+
+func (p *tagExpressionParser) parseTerm() (TagExpression, error) {
+	key, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if !key.word {
+		return nil, fmt.Errorf("unexpected %q", key.text)
+	}
+	p.pos++
+
+	term := tagTerm{key: key.text}
+
+	switch {
+	case p.accept("="):
+	case p.accept("!="):
+		term.negated = true
+	default:
+		return term, nil
+	}
+
+	value, ok := p.peek()
+	if !ok || !value.word {
+		return nil, fmt.Errorf("missing a value for %q", key.text)
+	}
+	p.pos++
+
+	term.value = value.text
+	term.hasValue = true
+
+	return term, nil
+}
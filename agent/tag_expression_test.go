@@ -0,0 +1,57 @@
+package agent
+
+import "testing"
+
+func TestTagExpressions(t *testing.T) {
+	tags := TagsToMap([]string{"queue=default", "docker", "os=linux", "gpu=false", "team=front end"})
+
+	for _, tc := range []struct {
+		expression string
+		matches    bool
+	}{
+		{`queue=default`, true},
+		{`queue=deploy`, false},
+		{`queue!=deploy`, true},
+		{`docker`, true},
+		{`gpu`, false},
+		{`!gpu`, true},
+		{`llamas`, false},
+		{`llamas!=true`, true},
+		{`queue=default && docker && !gpu`, true},
+		{`queue=default && gpu`, false},
+		{`gpu || os=linux`, true},
+		{`queue=deploy || (os=linux && !gpu)`, true},
+		{`!(queue=default || docker)`, false},
+		{`queue=deploy || os=windows && docker`, false},
+		{`team="front end"`, true},
+		{`docker&&os=linux`, true},
+	} {
+		expr, err := ParseTagExpression(tc.expression)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tc.expression, err)
+			continue
+		}
+
+		if matches := expr.Match(tags); matches != tc.matches {
+			t.Errorf("Expected %q (parsed as %s) to match: %v, got %v", tc.expression, expr, tc.matches, matches)
+		}
+	}
+}
+
+func TestInvalidTagExpressions(t *testing.T) {
+	for _, expression := range []string{
+		``,
+		`queue=`,
+		`&& docker`,
+		`docker &&`,
+		`(docker`,
+		`docker)`,
+		`docker os=linux`,
+		`team="front end`,
+		`queue=default & docker`,
+	} {
+		if _, err := ParseTagExpression(expression); err == nil {
+			t.Errorf("Expected %q to be invalid", expression)
+		}
+	}
+}
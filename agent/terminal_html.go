@@ -0,0 +1,258 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// TerminalToHTML converts output that contains ANSI escape sequences into
+// HTML that can be used in an annotation body. Colors and text styles become
+// spans with the same term-* classes that Buildkite uses to render job logs,
+// and any other escape sequences are removed.
+func TerminalToHTML(output string) string {
+	var buf bytes.Buffer
+	var current terminalStyle
+
+	buf.WriteString(`<pre class="term"><code>`)
+
+	lines := strings.Split(strings.TrimRight(output, "\r\n"), "\n")
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+
+		// Progress bars and spinners redraw the line after a carriage
+		// return, so only the last version of the line is kept
+		line = strings.TrimSuffix(line, "\r")
+		if idx := strings.LastIndex(line, "\r"); idx >= 0 {
+			line = line[idx+1:]
+		}
+
+		current = writeTerminalLine(&buf, line, current)
+	}
+
+	buf.WriteString(`</code></pre>`)
+
+	return buf.String()
+}
+
+// writeTerminalLine writes a single line as HTML, starting with the style
+// that the previous line finished with, and returns the style the line ends
+// with. Spans are closed at the end of each line so they never straddle one.
+func writeTerminalLine(buf *bytes.Buffer, line string, style terminalStyle) terminalStyle {
+	open := false
+	text := ""
+
+	flush := func() {
+		if text == "" {
+			return
+		}
+		if !open && !style.isPlain() {
+			buf.WriteString(style.openTag())
+			open = true
+		}
+		buf.WriteString(html.EscapeString(text))
+		text = ""
+	}
+
+	for len(line) > 0 {
+		idx := strings.IndexByte(line, '\x1b')
+		if idx < 0 {
+			text += line
+			break
+		}
+
+		text += line[:idx]
+		line = line[idx:]
+
+		params, final, length := parseEscapeSequence(line)
+		line = line[length:]
+
+		// Only SGR sequences change how text looks, everything else
+		// (cursor movement, erasing, etc) doesn't make sense in HTML
+		if final != 'm' {
+			continue
+		}
+
+		next := style.apply(params)
+		if next == style {
+			continue
+		}
+
+		flush()
+		if open {
+			buf.WriteString("</span>")
+			open = false
+		}
+		style = next
+	}
+
+	flush()
+	if open {
+		buf.WriteString("</span>")
+	}
+
+	return style
+}
+
+// parseEscapeSequence reads the escape sequence at the start of s, returning
+// its parameters, the final byte of a CSI sequence (or 0 for other types of
+// sequence), and how many bytes it took up
+func parseEscapeSequence(s string) ([]string, byte, int) {
+	if len(s) < 2 || s[1] != '[' {
+		// A two byte escape like ESC 7, or a lone escape at the end
+		if len(s) < 2 {
+			return nil, 0, len(s)
+		}
+		return nil, 0, 2
+	}
+
+	for i := 2; i < len(s); i++ {
+		if s[i] >= 0x40 && s[i] <= 0x7e {
+			return strings.Split(s[2:i], ";"), s[i], i + 1
+		}
+	}
+
+	// An unterminated sequence swallows the rest of the line
+	return nil, 0, len(s)
+}
+
+type terminalStyle struct {
+	bold, faint, italic, underline bool
+	fg, bg                         string
+}
+
+func (s terminalStyle) isPlain() bool {
+	return s == terminalStyle{}
+}
+
+// apply returns the style after the parameters of an SGR sequence have been
+// applied to it
+func (s terminalStyle) apply(params []string) terminalStyle {
+	if len(params) == 0 {
+		return terminalStyle{}
+	}
+
+	for i := 0; i < len(params); i++ {
+		code, err := strconv.Atoi(params[i])
+		if params[i] == "" {
+			code, err = 0, nil
+		}
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case code == 0:
+			s = terminalStyle{}
+		case code == 1:
+			s.bold = true
+		case code == 2:
+			s.faint = true
+		case code == 3:
+			s.italic = true
+		case code == 4:
+			s.underline = true
+		case code == 22:
+			s.bold, s.faint = false, false
+		case code == 23:
+			s.italic = false
+		case code == 24:
+			s.underline = false
+		case code >= 30 && code <= 37, code >= 90 && code <= 97:
+			s.fg = fmt.Sprintf("term-fg%d", code)
+		case code >= 40 && code <= 47, code >= 100 && code <= 107:
+			s.bg = fmt.Sprintf("term-bg%d", code)
+		case code == 39:
+			s.fg = ""
+		case code == 49:
+			s.bg = ""
+		case code == 38 || code == 48:
+			color, n := extendedColor(params[i+1:], code == 38)
+			if color != "" {
+				if code == 38 {
+					s.fg = color
+				} else {
+					s.bg = color
+				}
+			}
+			i += n
+		}
+	}
+
+	return s
+}
+
+// extendedColor reads a 256 color (5;n) or true color (2;r;g;b) from the
+// parameters following a 38 or 48, returning it and how many parameters it
+// used
+func extendedColor(params []string, foreground bool) (string, int) {
+	prefix := "term-bgx"
+	if foreground {
+		prefix = "term-fgx"
+	}
+
+	if len(params) >= 2 && params[0] == "5" {
+		n, err := strconv.Atoi(params[1])
+		if err != nil || n < 0 || n > 255 {
+			return "", 2
+		}
+		return fmt.Sprintf("%s%d", prefix, n), 2
+	}
+
+	if len(params) >= 4 && params[0] == "2" {
+		var rgb [3]int
+		for i := range rgb {
+			n, err := strconv.Atoi(params[i+1])
+			if err != nil || n < 0 || n > 255 {
+				return "", 4
+			}
+			rgb[i] = n
+		}
+		property := "background-color"
+		if foreground {
+			property = "color"
+		}
+		return fmt.Sprintf("style:%s: rgb(%d, %d, %d)", property, rgb[0], rgb[1], rgb[2]), 4
+	}
+
+	return "", len(params)
+}
+
+func (s terminalStyle) openTag() string {
+	var classes []string
+	var styles []string
+
+	for _, color := range []string{s.fg, s.bg} {
+		if strings.HasPrefix(color, "style:") {
+			styles = append(styles, strings.TrimPrefix(color, "style:"))
+		} else if color != "" {
+			classes = append(classes, color)
+		}
+	}
+	if s.bold {
+		classes = append(classes, "term-fg1")
+	}
+	if s.faint {
+		classes = append(classes, "term-fg2")
+	}
+	if s.italic {
+		classes = append(classes, "term-fg3")
+	}
+	if s.underline {
+		classes = append(classes, "term-fg4")
+	}
+
+	tag := "<span"
+	if len(classes) > 0 {
+		tag += fmt.Sprintf(` class="%s"`, strings.Join(classes, " "))
+	}
+	if len(styles) > 0 {
+		tag += fmt.Sprintf(` style="%s"`, strings.Join(styles, "; "))
+	}
+
+	return tag + ">"
+}
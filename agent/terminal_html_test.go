@@ -0,0 +1,48 @@
+package agent
+
+import "testing"
+
+func TestTerminalToHTML(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"plain text is escaped",
+			"a < b & \"c\"\n",
+			`<pre class="term"><code>a &lt; b &amp; &#34;c&#34;</code></pre>`,
+		},
+		{
+			"colors and resets",
+			"\x1b[31mred\x1b[0m plain \x1b[1;92mbold green\x1b[m",
+			`<pre class="term"><code><span class="term-fg31">red</span> plain <span class="term-fg92 term-fg1">bold green</span></code></pre>`,
+		},
+		{
+			"styles carry over lines",
+			"\x1b[4;44mone\ntwo\x1b[24m three\x1b[49m",
+			`<pre class="term"><code><span class="term-bg44 term-fg4">one</span>` + "\n" + `<span class="term-bg44 term-fg4">two</span><span class="term-bg44"> three</span></code></pre>`,
+		},
+		{
+			"256 and true colors",
+			"\x1b[38;5;196mx\x1b[48;2;1;2;3my",
+			`<pre class="term"><code><span class="term-fgx196">x</span><span class="term-fgx196" style="background-color: rgb(1, 2, 3)">y</span></code></pre>`,
+		},
+		{
+			"other sequences are removed",
+			"\x1b[2K\x1b[1Gdone\x1b[?25h",
+			`<pre class="term"><code>done</code></pre>`,
+		},
+		{
+			"carriage returns keep the last redraw",
+			"10%\r50%\r100%\r\nfinished\r\n",
+			`<pre class="term"><code>100%` + "\n" + `finished</code></pre>`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := TerminalToHTML(tc.input); actual != tc.expected {
+				t.Fatalf("Expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
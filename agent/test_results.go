@@ -0,0 +1,278 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/agent/api"
+)
+
+// The results a test can have
+const (
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+	TestErrored = "error"
+	TestSkipped = "skipped"
+)
+
+// The most failures to include in a test results annotation
+const maxAnnotatedFailures = 20
+
+// ParseTestResults reads the test cases from a JUnit XML file, or an xUnit.net
+// v2 XML file, returning them along with the format they were in
+func ParseTestResults(data []byte) ([]api.TestResult, string, error) {
+	root, err := xmlRootElement(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch root {
+	case "testsuites", "testsuite":
+		cases, err := parseJUnit(data, root)
+		return cases, "junit", err
+	case "assemblies", "assembly":
+		cases, err := parseXUnit(data, root)
+		return cases, "xunit", err
+	default:
+		return nil, "", fmt.Errorf("Unrecognised test results format with a <%s> root element", root)
+	}
+}
+
+func xmlRootElement(data []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return "", fmt.Errorf("No XML elements found")
+		} else if err != nil {
+			return "", err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+type junitTestSuite struct {
+	Name   string           `xml:"name,attr"`
+	File   string           `xml:"file,attr"`
+	Cases  []junitTestCase  `xml:"testcase"`
+	Suites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure"`
+	Error     *junitProblem `xml:"error"`
+	Skipped   *junitProblem `xml:"skipped"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func parseJUnit(data []byte, root string) ([]api.TestResult, error) {
+	var suites []junitTestSuite
+
+	if root == "testsuites" {
+		var parsed struct {
+			Suites []junitTestSuite `xml:"testsuite"`
+		}
+		if err := xml.Unmarshal(data, &parsed); err != nil {
+			return nil, err
+		}
+		suites = parsed.Suites
+	} else {
+		var suite junitTestSuite
+		if err := xml.Unmarshal(data, &suite); err != nil {
+			return nil, err
+		}
+		suites = []junitTestSuite{suite}
+	}
+
+	var results []api.TestResult
+	var walk func(suites []junitTestSuite)
+	walk = func(suites []junitTestSuite) {
+		for _, suite := range suites {
+			for _, c := range suite.Cases {
+				result := api.TestResult{
+					Suite:  suite.Name,
+					Class:  c.Classname,
+					Name:   c.Name,
+					File:   c.File,
+					Result: TestPassed,
+				}
+				if result.File == "" {
+					result.File = suite.File
+				}
+				if seconds, err := strconv.ParseFloat(c.Time, 64); err == nil {
+					result.DurationMS = seconds * 1000
+				}
+
+				switch {
+				case c.Failure != nil:
+					result.Result = TestFailed
+					result.Message, result.Details = c.Failure.Message, strings.TrimSpace(c.Failure.Body)
+				case c.Error != nil:
+					result.Result = TestErrored
+					result.Message, result.Details = c.Error.Message, strings.TrimSpace(c.Error.Body)
+				case c.Skipped != nil:
+					result.Result = TestSkipped
+					result.Message = c.Skipped.Message
+				}
+
+				results = append(results, result)
+			}
+
+			walk(suite.Suites)
+		}
+	}
+	walk(suites)
+
+	return results, nil
+}
+
+type xunitAssembly struct {
+	Name        string            `xml:"name,attr"`
+	Collections []xunitCollection `xml:"collection"`
+}
+
+type xunitCollection struct {
+	Name  string      `xml:"name,attr"`
+	Tests []xunitTest `xml:"test"`
+}
+
+type xunitTest struct {
+	Name    string `xml:"name,attr"`
+	Type    string `xml:"type,attr"`
+	Time    string `xml:"time,attr"`
+	Result  string `xml:"result,attr"`
+	Reason  string `xml:"reason"`
+	Failure *struct {
+		Message    string `xml:"message"`
+		StackTrace string `xml:"stack-trace"`
+	} `xml:"failure"`
+}
+
+func parseXUnit(data []byte, root string) ([]api.TestResult, error) {
+	var assemblies []xunitAssembly
+
+	if root == "assemblies" {
+		var parsed struct {
+			Assemblies []xunitAssembly `xml:"assembly"`
+		}
+		if err := xml.Unmarshal(data, &parsed); err != nil {
+			return nil, err
+		}
+		assemblies = parsed.Assemblies
+	} else {
+		var assembly xunitAssembly
+		if err := xml.Unmarshal(data, &assembly); err != nil {
+			return nil, err
+		}
+		assemblies = []xunitAssembly{assembly}
+	}
+
+	var results []api.TestResult
+	for _, assembly := range assemblies {
+		for _, collection := range assembly.Collections {
+			for _, test := range collection.Tests {
+				result := api.TestResult{
+					Suite: assembly.Name,
+					Class: test.Type,
+					Name:  test.Name,
+				}
+				if seconds, err := strconv.ParseFloat(test.Time, 64); err == nil {
+					result.DurationMS = seconds * 1000
+				}
+
+				switch strings.ToLower(test.Result) {
+				case "fail":
+					result.Result = TestFailed
+					if test.Failure != nil {
+						result.Message = strings.TrimSpace(test.Failure.Message)
+						result.Details = strings.TrimSpace(test.Failure.StackTrace)
+					}
+				case "skip":
+					result.Result = TestSkipped
+					result.Message = strings.TrimSpace(test.Reason)
+				default:
+					result.Result = TestPassed
+				}
+
+				results = append(results, result)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// SummarizeTestResults counts up the results of each test
+func SummarizeTestResults(format string, cases []api.TestResult) *api.TestResults {
+	results := &api.TestResults{Format: format, Tests: len(cases), Cases: cases}
+
+	for _, c := range cases {
+		switch c.Result {
+		case TestFailed:
+			results.Failed++
+		case TestErrored:
+			results.Errors++
+		case TestSkipped:
+			results.Skips++
+		default:
+			results.Passed++
+		}
+	}
+
+	return results
+}
+
+// TestResultsAnnotation is a Markdown summary of the tests that failed
+func TestResultsAnnotation(results *api.TestResults) string {
+	var b strings.Builder
+
+	failures := results.Failed + results.Errors
+	fmt.Fprintf(&b, "**%d of %d tests failed** (%d passed, %d skipped)\n\n", failures, results.Tests, results.Passed, results.Skips)
+
+	shown := 0
+	for _, c := range results.Cases {
+		if c.Result != TestFailed && c.Result != TestErrored {
+			continue
+		}
+		if shown == maxAnnotatedFailures {
+			fmt.Fprintf(&b, "…and %d more\n", failures-shown)
+			break
+		}
+		shown++
+
+		name := c.Name
+		if c.Class != "" {
+			name = c.Class + " " + c.Name
+		}
+
+		fmt.Fprintf(&b, "<details>\n<summary><code>%s</code></summary>\n\n", escapeHTML(name))
+		if details := strings.TrimSpace(c.Message + "\n\n" + c.Details); details != "" {
+			fmt.Fprintf(&b, "<pre><code>%s</code></pre>\n\n", escapeHTML(details))
+		}
+		b.WriteString("</details>\n")
+	}
+
+	return b.String()
+}
+
+func escapeHTML(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTestResultsJUnit(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="models" file="spec/models/user_spec.rb">
+    <testcase classname="User" name="is valid" time="0.25"/>
+    <testcase classname="User" name="has a name" time="0.5">
+      <failure message="expected a name" type="RSpec::Expectations::ExpectationNotMetError">
+        spec/models/user_spec.rb:12
+      </failure>
+    </testcase>
+    <testsuite name="nested">
+      <testcase classname="User" name="blows up"><error message="boom"/></testcase>
+      <testcase classname="User" name="is pending"><skipped/></testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`)
+
+	cases, format, err := ParseTestResults(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "junit" {
+		t.Fatalf("Expected junit, got %q", format)
+	}
+	if len(cases) != 4 {
+		t.Fatalf("Expected 4 tests, got %d", len(cases))
+	}
+
+	if cases[0].Result != TestPassed || cases[0].DurationMS != 250 || cases[0].File != "spec/models/user_spec.rb" {
+		t.Fatalf("Unexpected first test %#v", cases[0])
+	}
+	if cases[1].Result != TestFailed || cases[1].Message != "expected a name" || cases[1].Details != "spec/models/user_spec.rb:12" {
+		t.Fatalf("Unexpected failed test %#v", cases[1])
+	}
+	if cases[2].Result != TestErrored || cases[2].Suite != "nested" {
+		t.Fatalf("Unexpected errored test %#v", cases[2])
+	}
+	if cases[3].Result != TestSkipped {
+		t.Fatalf("Unexpected skipped test %#v", cases[3])
+	}
+
+	results := SummarizeTestResults(format, cases)
+	if results.Tests != 4 || results.Passed != 1 || results.Failed != 1 || results.Errors != 1 || results.Skips != 1 {
+		t.Fatalf("Unexpected summary %#v", results)
+	}
+
+	annotation := TestResultsAnnotation(results)
+	if !strings.Contains(annotation, "**2 of 4 tests failed**") || !strings.Contains(annotation, "User has a name") {
+		t.Fatalf("Unexpected annotation %q", annotation)
+	}
+	if strings.Contains(annotation, "is valid") {
+		t.Fatalf("Annotation shouldn't include passing tests %q", annotation)
+	}
+}
+
+func TestParseTestResultsXUnit(t *testing.T) {
+	data := []byte(`<assemblies>
+  <assembly name="Tests.dll">
+    <collection name="Math">
+      <test name="Math.Adds" type="Math" method="Adds" time="0.1" result="Pass"/>
+      <test name="Math.Divides" type="Math" method="Divides" time="0.2" result="Fail">
+        <failure><message>Expected 2 &lt; 1</message><stack-trace>at Math.Divides()</stack-trace></failure>
+      </test>
+      <test name="Math.Later" type="Math" method="Later" time="0" result="Skip"><reason>Not yet</reason></test>
+    </collection>
+  </assembly>
+</assemblies>`)
+
+	cases, format, err := ParseTestResults(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "xunit" || len(cases) != 3 {
+		t.Fatalf("Unexpected %q results %#v", format, cases)
+	}
+	if cases[1].Result != TestFailed || cases[1].Message != "Expected 2 < 1" || cases[1].Details != "at Math.Divides()" {
+		t.Fatalf("Unexpected failed test %#v", cases[1])
+	}
+	if cases[2].Result != TestSkipped || cases[2].Message != "Not yet" {
+		t.Fatalf("Unexpected skipped test %#v", cases[2])
+	}
+}
+
+func TestParseTestResultsUnknownFormat(t *testing.T) {
+	if _, _, err := ParseTestResults([]byte(`<html></html>`)); err == nil {
+		t.Fatal("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"strings"
+
+	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/pool"
+)
+
+// Bits of error messages that mean the artifact storage wants us to slow down
+var throttlingErrorMessages = []string{
+	"429",
+	"503",
+	"Too Many Requests",
+	"SlowDown",
+	"Throttling",
+	"RequestLimitExceeded",
+	"rateLimitExceeded",
+}
+
+// Looks at an upload or download error and returns true if it looks like we
+// are being rate limited
+func isThrottlingError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	s := err.Error()
+	for _, message := range throttlingErrorMessages {
+		if strings.Contains(s, message) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// If the error means we're being rate limited, halve the number of
+// transfers that can happen at once so that we back off
+func throttlePool(p *pool.Pool, err error) {
+	if !isThrottlingError(err) {
+		return
+	}
+
+	if size := p.Size(); size > 1 {
+		logger.Warn("Being rate limited, reducing concurrency from %d to %d", size, size/2)
+		p.Resize(size / 2)
+	}
+}
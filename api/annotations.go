@@ -10,9 +10,11 @@ type AnnotationsService struct {
 
 // Annotation represents a Buildkite Agent API Annotation
 type Annotation struct {
+	UUID    string `json:"uuid,omitempty"`
 	Body    string `json:"body,omitempty"`
 	Context string `json:"context,omitempty"`
 	Style   string `json:"style,omitempty"`
+	Class   string `json:"class,omitempty"`
 	Append  bool   `json:"append,omitempty"`
 }
 
@@ -31,6 +31,17 @@ type Artifact struct {
 	// A Sha1Sum calculation of the file
 	Sha1Sum string `json:"sha1sum"`
 
+	// A Sha256Sum calculation of the file
+	Sha256Sum string `json:"sha256sum,omitempty"`
+
+	// The file's permissions in octal, so they can be restored when it's
+	// downloaded
+	FileMode string `json:"file_mode,omitempty"`
+
+	// How the file was compressed before it was uploaded, if it was, so it
+	// can be decompressed when it's downloaded
+	ContentEncoding string `json:"content_encoding,omitempty"`
+
 	// The HTTP url to this artifact once it's been uploaded
 	URL string `json:"url,omitempty"`
 
@@ -38,6 +49,10 @@ type Artifact struct {
 	// uploaded
 	UploadDestination string `json:"upload_destination,omitempty"`
 
+	// Extra details about the artifact, like the signal that crashed the
+	// process that left it behind
+	Tags map[string]string `json:"tags,omitempty"`
+
 	// Information on how to upload this artifact.
 	UploadInstructions *ArtifactUploadInstructions `json:"-"`
 }
@@ -51,6 +51,9 @@ type Client struct {
 	Pipelines   *PipelinesService
 	Heartbeats  *HeartbeatsService
 	Annotations *AnnotationsService
+	TestResults *TestResultsService
+	Builds      *BuildsService
+	Metrics     *MetricsService
 }
 
 // NewClient returns a new Buildkite Agent API Client.
@@ -73,6 +76,9 @@ func NewClient(httpClient *http.Client) *Client {
 	c.Pipelines = &PipelinesService{c}
 	c.Heartbeats = &HeartbeatsService{c}
 	c.Annotations = &AnnotationsService{c}
+	c.TestResults = &TestResultsService{c}
+	c.Builds = &BuildsService{c}
+	c.Metrics = &MetricsService{c}
 
 	return c
 }
@@ -185,19 +191,19 @@ func (c *Client) Do(req *http.Request, v interface{}) (*Response, error) {
 			requestDump, err = httputil.DumpRequestOut(req, true)
 		}
 
-		logger.Debug("ERR: %s\n%s", err, string(requestDump))
+		logger.Debug("[API] ERR: %s\n%s", err, string(requestDump))
 	}
 
 	ts := time.Now()
 
-	logger.Debug("%s %s", req.Method, req.URL)
+	logger.Debug("[API] %s %s", req.Method, req.URL)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
-	logger.Debug("↳ %s %s (%s %s %s)", req.Method, req.URL, resp.Proto, resp.Status, time.Now().Sub(ts))
+	logger.Debug("[API] ↳ %s %s (%s %s %s)", req.Method, req.URL, resp.Proto, resp.Status, time.Now().Sub(ts))
 
 	defer resp.Body.Close()
 	defer io.Copy(ioutil.Discard, resp.Body)
@@ -206,7 +212,7 @@ func (c *Client) Do(req *http.Request, v interface{}) (*Response, error) {
 
 	if c.DebugHTTP {
 		responseDump, err := httputil.DumpResponse(resp, true)
-		logger.Debug("\nERR: %s\n%s", err, string(responseDump))
+		logger.Debug("[API] ERR: %s\n%s", err, string(responseDump))
 	}
 
 	err = checkResponse(resp)
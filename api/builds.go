@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+)
+
+// BuildsService handles communication with the build related methods of the
+// Buildkite Agent API.
+type BuildsService struct {
+	client *Client
+}
+
+// Build represents a Buildkite Agent API Build
+type Build struct {
+	ID     string      `json:"id"`
+	Number int         `json:"number"`
+	State  string      `json:"state"`
+	Jobs   []*BuildJob `json:"jobs"`
+}
+
+// BuildJob represents one of the jobs in a build, which might be a block step
+// or a wait step rather than a command
+type BuildJob struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Label      string `json:"label,omitempty"`
+	StepKey    string `json:"step_key,omitempty"`
+	State      string `json:"state"`
+	ExitStatus string `json:"exit_status,omitempty"`
+}
+
+// Gets a build and the state of its jobs
+func (bs *BuildsService) Get(id string) (*Build, *Response, error) {
+	u := fmt.Sprintf("builds/%s", id)
+
+	req, err := bs.client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := new(Build)
+	resp, err := bs.client.Do(req, b)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return b, resp, err
+}
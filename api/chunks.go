@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 )
 
@@ -23,6 +24,12 @@ type Chunk struct {
 // Uploads the chunk to the Buildkite Agent API. This request sends the
 // compressed log directly as a request body.
 func (cs *ChunksService) Upload(jobId string, chunk *Chunk) (*Response, error) {
+	return cs.UploadWithContext(context.Background(), jobId, chunk)
+}
+
+// UploadWithContext is like Upload, but gives up on the request once the
+// context is done, so a hung connection can't hold up the next chunk
+func (cs *ChunksService) UploadWithContext(ctx context.Context, jobId string, chunk *Chunk) (*Response, error) {
 	// Create a compressed buffer of the log content
 	body := &bytes.Buffer{}
 	gzipper := gzip.NewWriter(body)
@@ -42,5 +49,5 @@ func (cs *ChunksService) Upload(jobId string, chunk *Chunk) (*Response, error) {
 	req.Header.Add("Content-Type", "text/plain")
 	req.Header.Add("Content-Encoding", "gzip")
 
-	return cs.client.Do(req, nil)
+	return cs.client.Do(req.WithContext(ctx), nil)
 }
@@ -16,6 +16,21 @@ type HeaderTimes struct {
 	Times map[string]string `json:"header_times"`
 }
 
+// HeaderTiming is how long a log group took, along with the groups nested
+// within it
+type HeaderTiming struct {
+	Name       string          `json:"name"`
+	Depth      int             `json:"depth"`
+	StartedAt  string          `json:"started_at"`
+	FinishedAt string          `json:"finished_at"`
+	DurationMS float64         `json:"duration_ms"`
+	Children   []*HeaderTiming `json:"children,omitempty"`
+}
+
+type headerTimingsRequest struct {
+	Timings []*HeaderTiming `json:"header_timings"`
+}
+
 // Saves the header times to the job
 func (hs *HeaderTimesService) Save(jobId string, headerTimes *HeaderTimes) (*Response, error) {
 	u := fmt.Sprintf("jobs/%s/header_times", jobId)
@@ -32,3 +47,15 @@ func (hs *HeaderTimesService) Save(jobId string, headerTimes *HeaderTimes) (*Res
 
 	return resp, err
 }
+
+// Saves the nested timings of the job's log groups
+func (hs *HeaderTimesService) SaveTimings(jobId string, timings []*HeaderTiming) (*Response, error) {
+	u := fmt.Sprintf("jobs/%s/header_timings", jobId)
+
+	req, err := hs.client.NewRequest("POST", u, &headerTimingsRequest{Timings: timings})
+	if err != nil {
+		return nil, err
+	}
+
+	return hs.client.Do(req, nil)
+}
@@ -21,10 +21,34 @@ type Job struct {
 	StartedAt          string            `json:"started_at,omitempty"`
 	FinishedAt         string            `json:"finished_at,omitempty"`
 	ChunksFailedCount  int               `json:"chunks_failed_count,omitempty"`
+	PhaseTimings       []PhaseTiming     `json:"phase_timings,omitempty"`
+	ResourceUsage      *ResourceUsage    `json:"resource_usage,omitempty"`
+}
+
+// PhaseTiming is how long one phase of running a job took
+type PhaseTiming struct {
+	Name       string `json:"name"`
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// ResourceUsage is the CPU time and memory a job's process used
+type ResourceUsage struct {
+	UserCPUMS   int64 `json:"user_cpu_ms"`
+	SystemCPUMS int64 `json:"system_cpu_ms"`
+	MaxRSSBytes int64 `json:"max_rss_bytes,omitempty"`
 }
 
 type JobState struct {
-	State string `json:"state,omitempty"`
+	State   string      `json:"state,omitempty"`
+	Signals []JobSignal `json:"signals,omitempty"`
+}
+
+// JobSignal is a request to send a signal, like SIGUSR1, to a running job
+type JobSignal struct {
+	ID     string `json:"id"`
+	Signal string `json:"signal"`
 }
 
 type jobStartRequest struct {
@@ -32,9 +56,10 @@ type jobStartRequest struct {
 }
 
 type jobFinishRequest struct {
-	ExitStatus        string `json:"exit_status,omitempty"`
-	FinishedAt        string `json:"finished_at,omitempty"`
-	ChunksFailedCount int    `json:"chunks_failed_count"`
+	ExitStatus        string        `json:"exit_status,omitempty"`
+	FinishedAt        string        `json:"finished_at,omitempty"`
+	ChunksFailedCount int           `json:"chunks_failed_count"`
+	PhaseTimings      []PhaseTiming `json:"phase_timings,omitempty"`
 }
 
 // Fetches a job
@@ -97,6 +122,7 @@ func (js *JobsService) Finish(job *Job) (*Response, error) {
 		FinishedAt:        job.FinishedAt,
 		ExitStatus:        job.ExitStatus,
 		ChunksFailedCount: job.ChunksFailedCount,
+		PhaseTimings:      job.PhaseTimings,
 	})
 	if err != nil {
 		return nil, err
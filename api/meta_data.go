@@ -12,6 +12,7 @@ type MetaDataService struct {
 
 // MetaData represents a Buildkite Agent API MetaData
 type MetaData struct {
+	UUID  string `json:"uuid,omitempty"`
 	Key   string `json:"key,omitempty"`
 	Value string `json:"value,omitempty"`
 }
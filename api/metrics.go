@@ -0,0 +1,65 @@
+package api
+
+// MetricsService handles communication with the metrics related methods of
+// the Buildkite Agent API. It's authenticated with an agent registration
+// token, rather than an agent's access token.
+type MetricsService struct {
+	client *Client
+}
+
+// Metrics represents the state of an organization's jobs and agents
+type Metrics struct {
+	Organization MetricsOrganization `json:"organization"`
+	Jobs         JobMetrics          `json:"jobs"`
+	Agents       AgentMetrics        `json:"agents"`
+}
+
+// MetricsOrganization is the organization the metrics are for
+type MetricsOrganization struct {
+	Slug string `json:"slug"`
+}
+
+// JobMetrics counts jobs by state, overall and for each queue
+type JobMetrics struct {
+	JobCounts
+	Queues map[string]JobCounts `json:"queues"`
+}
+
+// JobCounts counts jobs by state. ScheduledWaitSeconds is how long the
+// oldest scheduled job has been waiting for an agent.
+type JobCounts struct {
+	Scheduled            int     `json:"scheduled"`
+	Running              int     `json:"running"`
+	Waiting              int     `json:"waiting"`
+	Total                int     `json:"total"`
+	ScheduledWaitSeconds float64 `json:"scheduled_wait_seconds"`
+}
+
+// AgentMetrics counts connected agents, overall and for each queue
+type AgentMetrics struct {
+	AgentCounts
+	Queues map[string]AgentCounts `json:"queues"`
+}
+
+// AgentCounts counts connected agents by whether they're running a job
+type AgentCounts struct {
+	Idle  int `json:"idle"`
+	Busy  int `json:"busy"`
+	Total int `json:"total"`
+}
+
+// Gets the current metrics for the organization the token belongs to
+func (ms *MetricsService) Get() (*Metrics, *Response, error) {
+	req, err := ms.client.NewRequest("GET", "metrics", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := new(Metrics)
+	resp, err := ms.client.Do(req, m)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return m, resp, err
+}
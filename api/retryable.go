@@ -18,6 +18,18 @@ var retrableErrorSuffixes = []string{
 	io.EOF.Error(),
 }
 
+// IsPermanentError returns true if the error is a response from Buildkite
+// that will be the same if the request is retried, which is any 4xx apart
+// from timeouts and rate limiting. It can be used as retry.Config.IsPermanent
+func IsPermanentError(err error) bool {
+	if apierr, ok := err.(*ErrorResponse); ok && apierr.Response != nil {
+		code := apierr.Response.StatusCode
+		return code >= 400 && code <= 499 && code != 408 && code != 429
+	}
+
+	return false
+}
+
 // Looks at a bunch of connection related errors, and returns true if the error
 // matches one of them.
 func IsRetryableError(err error) bool {
@@ -0,0 +1,44 @@
+package api
+
+import "fmt"
+
+// TestResultsService handles communication with the test result related
+// methods of the Buildkite Agent API.
+type TestResultsService struct {
+	client *Client
+}
+
+// TestResults represents the results of the tests run by a job
+type TestResults struct {
+	Format string       `json:"format"`
+	Tests  int          `json:"tests"`
+	Passed int          `json:"passed"`
+	Failed int          `json:"failed"`
+	Errors int          `json:"errors"`
+	Skips  int          `json:"skipped"`
+	Cases  []TestResult `json:"cases"`
+}
+
+// TestResult represents the result of a single test
+type TestResult struct {
+	Suite      string  `json:"suite,omitempty"`
+	Class      string  `json:"class,omitempty"`
+	Name       string  `json:"name"`
+	File       string  `json:"file,omitempty"`
+	Result     string  `json:"result"`
+	DurationMS float64 `json:"duration_ms"`
+	Message    string  `json:"message,omitempty"`
+	Details    string  `json:"details,omitempty"`
+}
+
+// Uploads the results of the tests a job ran
+func (ts *TestResultsService) Upload(jobId string, results *TestResults) (*Response, error) {
+	u := fmt.Sprintf("jobs/%s/test_results", jobId)
+
+	req, err := ts.client.NewRequest("POST", u, results)
+	if err != nil {
+		return nil, err
+	}
+
+	return ts.client.Do(req, nil)
+}
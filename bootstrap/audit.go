@@ -0,0 +1,118 @@
+package bootstrap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/bootstrap/shell"
+)
+
+// auditEntry records a hook or command that the bootstrap ran for the job.
+// Arguments are hashed rather than stored, as they often contain secrets.
+type auditEntry struct {
+	Time       string `json:"time"`
+	JobID      string `json:"job_id"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	ArgsSHA256 string `json:"args_sha256"`
+	ExitStatus int    `json:"exit_status"`
+	DurationMS int64  `json:"duration_ms"`
+	User       string `json:"user"`
+}
+
+// audited runs fn and, if there's an audit log, appends an entry to it for
+// whatever fn ran
+func (b *Bootstrap) audited(kind string, name string, path string, args []string, fn func() error) error {
+	if b.Config.AuditLog == "" && b.auditLog == nil {
+		return fn()
+	}
+
+	startedAt := time.Now()
+	err := fn()
+
+	entry := auditEntry{
+		Time:       startedAt.UTC().Format(time.RFC3339Nano),
+		JobID:      b.Config.JobID,
+		Kind:       kind,
+		Name:       name,
+		Path:       path,
+		ArgsSHA256: hashArgs(args),
+		ExitStatus: shell.GetExitCode(err),
+		DurationMS: int64(time.Since(startedAt) / time.Millisecond),
+		User:       currentUser(),
+	}
+
+	var auditErr error
+	if b.auditLog != nil {
+		auditErr = writeAuditEntry(b.auditLog, entry)
+	} else {
+		auditErr = appendAuditEntry(b.Config.AuditLog, entry)
+	}
+
+	if auditErr != nil && b.shell != nil {
+		b.shell.Warningf("Failed to write to audit log: %v", auditErr)
+	}
+
+	return err
+}
+
+// uploadAuditLog uploads the audit log as an artifact of the job
+func (b *Bootstrap) uploadAuditLog() {
+	if _, err := os.Stat(b.Config.AuditLog); err != nil {
+		return
+	}
+
+	b.shell.Headerf("Uploading audit log")
+	if err := b.shell.Run("buildkite-agent", "artifact", "upload", b.Config.AuditLog); err != nil {
+		b.shell.Warningf("Failed to upload audit log: %v", err)
+	}
+}
+
+// appendAuditEntry appends the entry to the audit log at path. Unlike the
+// pipe the agent gives us, anything the job runs as the same user could
+// change the file afterwards.
+func appendAuditEntry(path string, entry auditEntry) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := writeAuditEntry(f, entry); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// writeAuditEntry writes the entry as a line of JSON, all at once so that it
+// isn't interleaved with anything else written to a pipe
+func writeAuditEntry(w io.Writer, entry auditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func hashArgs(args []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(args, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return strconv.Itoa(os.Getuid())
+}
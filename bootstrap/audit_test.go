@@ -0,0 +1,83 @@
+package bootstrap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/bootstrap/shell"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLogRecordsEachInvocation(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "audit-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := &Bootstrap{Config: Config{JobID: "my-job", AuditLog: filepath.Join(dir, "my-job.jsonl")}}
+
+	assert.NoError(t, b.audited("hook", "pre-command", "/hooks/pre-command", nil, func() error { return nil }))
+	assert.Error(t, b.audited("command", "command", "/bin/bash", []string{"-c", "secret"}, func() error {
+		return &shell.ExitError{Code: 3, Message: "Nope"}
+	}))
+
+	f, err := os.Open(b.Config.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry auditEntry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "hook", entries[0].Kind)
+		assert.Equal(t, "pre-command", entries[0].Name)
+		assert.Equal(t, "/hooks/pre-command", entries[0].Path)
+		assert.Equal(t, 0, entries[0].ExitStatus)
+		assert.Equal(t, "my-job", entries[0].JobID)
+		assert.NotEmpty(t, entries[0].User)
+
+		assert.Equal(t, "command", entries[1].Kind)
+		assert.Equal(t, 3, entries[1].ExitStatus)
+		assert.Equal(t, hashArgs([]string{"-c", "secret"}), entries[1].ArgsSHA256)
+		assert.NotContains(t, entries[1].ArgsSHA256, "secret")
+	}
+}
+
+func TestAuditLogEntriesGoToTheAgentsPipe(t *testing.T) {
+	t.Parallel()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	b := &Bootstrap{Config: Config{JobID: "my-job"}, auditLog: writer}
+
+	assert.NoError(t, b.audited("hook", "pre-command", "/hooks/pre-command", nil, func() error { return nil }))
+	writer.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry auditEntry
+	assert.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "pre-command", entry.Name)
+	assert.Equal(t, "my-job", entry.JobID)
+}
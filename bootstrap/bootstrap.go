@@ -14,11 +14,15 @@ import (
 	"time"
 
 	"github.com/buildkite/agent/agent/plugin"
+	"github.com/buildkite/agent/api"
 	"github.com/buildkite/agent/bootstrap/shell"
 	"github.com/buildkite/agent/env"
 	"github.com/buildkite/agent/process"
 	"github.com/buildkite/agent/retry"
+	"github.com/buildkite/agent/sandbox"
+	"github.com/buildkite/agent/tracing"
 	"github.com/buildkite/shellwords"
+	"github.com/nightlyone/lockfile"
 	"github.com/pkg/errors"
 )
 
@@ -41,6 +45,21 @@ type Bootstrap struct {
 
 	// Whether the checkout dir was created as part of checkout
 	createdCheckoutDir bool
+
+	// The span that any new spans are started beneath
+	span *tracing.Span
+
+	// How long each phase took
+	timings []api.PhaseTiming
+
+	// Where infrastructure failures are explained to the agent
+	infraFailure *os.File
+
+	// Where audit log entries are sent to the agent
+	auditLog *os.File
+
+	// The lock on a checkout that's shared with other agents
+	checkoutLock *lockfile.Lockfile
 }
 
 // Start runs the bootstrap and returns the exit code
@@ -58,9 +77,72 @@ func (b *Bootstrap) Start() (exitCode int) {
 		b.shell.Debug = b.Config.Debug
 	}
 
+	// Only the bootstrap gets to explain infrastructure failures, so nothing
+	// it runs inherits the pipe to the agent, or finds out about it
+	b.shell.Env.Remove(`BUILDKITE_INFRA_FAILURE_FD`)
+	if b.Config.InfraFailureFD > 0 {
+		f, err := openInheritedFD(b.Config.InfraFailureFD, "infra-failure")
+		if err != nil {
+			b.shell.Warningf("Failed to open the infrastructure failure pipe: %v", err)
+		} else {
+			b.infraFailure = f
+			defer f.Close()
+		}
+	}
+
+	// The same goes for the audit log
+	b.shell.Env.Remove(`BUILDKITE_AUDIT_LOG_FD`)
+	if b.Config.AuditLogFD > 0 {
+		f, err := openInheritedFD(b.Config.AuditLogFD, "audit-log")
+		if err != nil {
+			b.shell.Warningf("Failed to open the audit log pipe: %v", err)
+		} else {
+			b.auditLog = f
+			defer f.Close()
+		}
+	}
+
+	// Write out how long each phase took once everything (including the
+	// tear down) has finished
+	if b.Config.PhaseTimingsFile != "" {
+		defer func() {
+			if err := b.writePhaseTimings(); err != nil {
+				b.shell.Warningf("Failed to write phase timings: %v", err)
+			}
+		}()
+	}
+
+	// Trace the bootstrap if we've been asked to. The spans are sent after
+	// everything else has finished.
+	if b.Config.TracingOTLPEndpoint != "" {
+		tracer := tracing.NewTracer(tracing.NewOTLPExporter(b.Config.TracingOTLPEndpoint, "buildkite-agent"))
+
+		parent, err := tracing.ParseTraceparent(b.Config.TracingParent)
+		if err != nil && b.Config.TracingParent != "" {
+			b.shell.Warningf("Not continuing the job's trace: %v", err)
+		}
+
+		b.span = tracer.StartSpan("bootstrap", parent)
+		b.span.SetAttribute("buildkite.job_id", b.Config.JobID)
+
+		defer func() {
+			b.span.SetAttribute("buildkite.exit_status", strconv.Itoa(exitCode))
+			b.span.Finish()
+			tracer.Flush()
+		}()
+	}
+
+	// Upload the audit log once the tear down has added its hooks to it
+	if b.Config.AuditLog != "" && b.Config.AuditLogUpload {
+		defer b.uploadAuditLog()
+	}
+
+	// A shared checkout is only unlocked once everything's done with it
+	defer b.unlockCheckout()
+
 	// Tear down the environment (and fire pre-exit hook) before we exit
 	defer func() {
-		if err := b.tearDown(); err != nil {
+		if err := b.timedPhase("teardown", b.tearDown); err != nil {
 			b.shell.Errorf("Error tearing down bootstrap: %v", err)
 
 			// this gets passed back via the named return
@@ -69,7 +151,7 @@ func (b *Bootstrap) Start() (exitCode int) {
 	}()
 
 	// Initialize the environment, a failure here will still call the tearDown
-	if err := b.setUp(); err != nil {
+	if err := b.timedPhase("setup", b.setUp); err != nil {
 		b.shell.Errorf("Error setting up bootstrap: %v", err)
 		return shell.GetExitCode(err)
 	}
@@ -90,11 +172,11 @@ func (b *Bootstrap) Start() (exitCode int) {
 	var phaseErr error
 
 	if includePhase(`plugin`) {
-		phaseErr = b.PluginPhase()
+		phaseErr = b.timedPhase("plugin", b.PluginPhase)
 	}
 
 	if phaseErr == nil && includePhase(`checkout`) {
-		phaseErr = b.CheckoutPhase()
+		phaseErr = b.timedPhase("checkout", b.CheckoutPhase)
 	} else {
 		checkoutDir, exists := b.shell.Env.Get(`BUILDKITE_BUILD_CHECKOUT_PATH`)
 		if exists {
@@ -103,10 +185,10 @@ func (b *Bootstrap) Start() (exitCode int) {
 	}
 
 	if phaseErr == nil && includePhase(`command`) {
-		phaseErr = b.CommandPhase()
+		phaseErr = b.timedPhase("command", b.CommandPhase)
 
 		// Only upload artifacts as part of the command phase
-		if err := b.uploadArtifacts(); err != nil {
+		if err := b.timedPhase("artifacts", b.uploadArtifacts); err != nil {
 			b.shell.Errorf("%v", err)
 			return shell.GetExitCode(err)
 		}
@@ -126,6 +208,71 @@ func (b *Bootstrap) Start() (exitCode int) {
 	return exitStatusCode
 }
 
+// traced runs fn in a span beneath the current one. Any spans started while
+// fn is running (like for hooks) are nested beneath the new span, and so are
+// any that the scripts it runs create using TRACEPARENT.
+func (b *Bootstrap) traced(name string, fn func() error) error {
+	parent := b.span
+	b.span = parent.StartChild(name)
+
+	if b.span != nil && b.shell != nil {
+		b.shell.Env.Set("TRACEPARENT", b.span.SpanContext().Traceparent())
+	}
+
+	err := fn()
+
+	b.span.RecordError(err)
+	b.span.Finish()
+	b.span = parent
+
+	if b.span != nil && b.shell != nil {
+		b.shell.Env.Set("TRACEPARENT", b.span.SpanContext().Traceparent())
+	}
+
+	return err
+}
+
+// recordInfraFailure tells the agent that the job failed because of its
+// infrastructure rather than anything the job did. Only failures in the
+// agent's own steps count, never hooks or the command.
+func (b *Bootstrap) recordInfraFailure(reason string) {
+	if b.infraFailure == nil {
+		return
+	}
+
+	if _, err := b.infraFailure.WriteString(reason + "\n"); err != nil {
+		b.shell.Warningf("Failed to record infrastructure failure: %v", err)
+	}
+}
+
+// timedPhase runs one of the bootstrap's phases in its own span, and records
+// how long it took so it can be reported back to Buildkite
+func (b *Bootstrap) timedPhase(name string, fn func() error) error {
+	startedAt := time.Now()
+	err := b.traced(name, fn)
+	finishedAt := time.Now()
+
+	b.timings = append(b.timings, api.PhaseTiming{
+		Name:       name,
+		StartedAt:  startedAt.UTC().Format(time.RFC3339Nano),
+		FinishedAt: finishedAt.UTC().Format(time.RFC3339Nano),
+		DurationMS: int64(finishedAt.Sub(startedAt) / time.Millisecond),
+	})
+
+	return err
+}
+
+// writePhaseTimings writes the timings of each phase to the file the agent
+// gave us as JSON
+func (b *Bootstrap) writePhaseTimings() error {
+	data, err := json.Marshal(b.timings)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(b.Config.PhaseTimingsFile, data, 0600)
+}
+
 // executeHook runs a hook script with the hookRunner
 func (b *Bootstrap) executeHook(name string, hookPath string, extraEnviron *env.Environment) error {
 	if !fileExists(hookPath) {
@@ -135,6 +282,14 @@ func (b *Bootstrap) executeHook(name string, hookPath string, extraEnviron *env.
 		return nil
 	}
 
+	return b.traced(name+" hook", func() error {
+		return b.audited("hook", name, hookPath, nil, func() error {
+			return b.runHook(name, hookPath, extraEnviron)
+		})
+	})
+}
+
+func (b *Bootstrap) runHook(name string, hookPath string, extraEnviron *env.Environment) error {
 	b.shell.Headerf("Running %s hook", name)
 
 	// We need a script to wrap the hook script so that we can snaffle the changed
@@ -272,38 +427,48 @@ func (b *Bootstrap) localHookPath(name string) (string, error) {
 	return b.findHookFile(filepath.Join(b.shell.Getwd(), ".buildkite", "hooks"), name)
 }
 
+// Returns whether there's a local hook to run. Skipped hooks don't count, so
+// the global or default command runs in place of a skipped command hook.
 func (b *Bootstrap) hasLocalHook(name string) bool {
+	if b.SkipRepositoryHooks && b.localHooksEnabled() {
+		return false
+	}
 	_, err := b.localHookPath(name)
 	return err == nil
 }
 
 // Executes a local hook
 func (b *Bootstrap) executeLocalHook(name string) error {
-	if !b.hasLocalHook(name) {
-		return nil
-	}
-
 	localHookPath, err := b.localHookPath(name)
 	if err != nil {
 		return nil
 	}
 
-	// For high-security configs, we allow the disabling of local hooks.
-	localHooksEnabled := b.Config.LocalHooksEnabled
-
-	// Allow hooks to disable local hooks by setting BUILDKITE_NO_LOCAL_HOOKS=true
-	noLocalHooks, _ := b.shell.Env.Get(`BUILDKITE_NO_LOCAL_HOOKS`)
-	if noLocalHooks == "true" || noLocalHooks == "1" {
-		localHooksEnabled = false
+	if !b.localHooksEnabled() {
+		return fmt.Errorf("Refusing to run %s, local hooks are disabled", localHookPath)
 	}
 
-	if !localHooksEnabled {
-		return fmt.Errorf("Refusing to run %s, local hooks are disabled", localHookPath)
+	if b.SkipRepositoryHooks {
+		b.shell.Warningf("Skipping %s, as this agent skips hooks from the repository", localHookPath)
+		return nil
 	}
 
 	return b.executeHook("local "+name, localHookPath, nil)
 }
 
+// Returns whether local hooks are allowed to run at all. Jobs with local hooks
+// fail when they aren't.
+func (b *Bootstrap) localHooksEnabled() bool {
+	// For high-security configs, we allow the disabling of local hooks.
+	if !b.Config.LocalHooksEnabled {
+		return false
+	}
+
+	// Allow hooks to disable local hooks by setting BUILDKITE_NO_LOCAL_HOOKS=true
+	noLocalHooks, _ := b.shell.Env.Get(`BUILDKITE_NO_LOCAL_HOOKS`)
+	return noLocalHooks != "true" && noLocalHooks != "1"
+}
+
 // Returns whether or not a file exists on the filesystem. We consider any
 // error returned by os.Stat to indicate that the file doesn't exist. We could
 // be specific and use os.IsNotExist(err), but most other errors also indicate
@@ -371,8 +536,18 @@ func (b *Bootstrap) setUp() error {
 		if b.BuildPath == "" {
 			return fmt.Errorf("Must set either a BUILDKITE_BUILD_PATH or a BUILDKITE_BUILD_CHECKOUT_PATH")
 		}
-		b.shell.Env.Set("BUILDKITE_BUILD_CHECKOUT_PATH",
-			filepath.Join(b.BuildPath, dirForAgentName(b.AgentName), b.OrganizationSlug, b.PipelineSlug))
+		template := b.BuildPathTemplate
+		if template == "" {
+			template = DefaultBuildPathTemplate
+		}
+		checkoutPath := filepath.Join(b.BuildPath, b.buildPathFromTemplate(template))
+		b.shell.Env.Set("BUILDKITE_BUILD_CHECKOUT_PATH", checkoutPath)
+
+		if sharesCheckouts(template) {
+			if err := b.lockCheckout(checkoutPath); err != nil {
+				return err
+			}
+		}
 	}
 
 	// The job runner sets BUILDKITE_IGNORED_ENV with any keys that were ignored
@@ -721,6 +896,10 @@ func (b *Bootstrap) CheckoutPhase() error {
 			return err
 		}, &retry.Config{Maximum: 3, Interval: 2 * time.Second})
 		if err != nil {
+			// A git checkout that fails even after retrying is usually
+			// down to the network or the git host, so the agent may want
+			// to try again
+			b.recordInfraFailure("the checkout failed")
 			return err
 		}
 	}
@@ -769,21 +948,48 @@ func (b *Bootstrap) defaultCheckoutPhase() error {
 		return err
 	}
 
+	rewrites, err := ParseGitURLRewrites(b.GitURLRewrites)
+	if err != nil {
+		return err
+	}
+
+	repository := rewriteGitURL(b.Repository, rewrites)
+	if repository != b.Repository {
+		b.shell.Commentf("Using %s instead of %s", repository, b.Repository)
+	}
+
 	if b.SSHKeyscan {
-		addRepositoryHostToSSHKnownHosts(b.shell, b.Repository)
+		addRepositoryHostToSSHKnownHosts(b.shell, repository)
 	}
 
+	// Whether the checkout came from the git archive rather than a clone
+	var fromArchive bool
+
 	// Does the git directory exist?
 	existingGitDir := filepath.Join(b.shell.Getwd(), ".git")
 	if fileExists(existingGitDir) {
 		// Update the the origin of the repository so we can gracefully handle repository renames
-		if err := b.shell.Run("git", "remote", "set-url", "origin", b.Repository); err != nil {
+		if err := b.shell.Run("git", "remote", "set-url", "origin", repository); err != nil {
 			return err
 		}
-	} else {
-		if err := gitClone(b.shell, b.GitCloneFlags, b.Repository, "."); err != nil {
+
+		// Checkouts from before clones enabled long paths need it set too
+		if runtime.GOOS == "windows" {
+			if err := gitEnableLongPaths(b.shell); err != nil {
+				return err
+			}
+		}
+	} else if err := gitClone(b.shell, b.GitCloneFlags, repository, "."); err != nil {
+		if b.GitArchiveURL == "" {
 			return err
 		}
+
+		b.shell.Warningf("Failed to clone %s (%v), falling back to the git archive", repository, err)
+
+		if err := b.replaceCheckoutWithGitArchive(repository); err != nil {
+			return err
+		}
+		fromArchive = true
 	}
 
 	// Git clean prior to checkout
@@ -843,17 +1049,42 @@ func (b *Bootstrap) defaultCheckoutPhase() error {
 		// Otherwise fetch and checkout the commit directly. Some repositories don't
 		// support fetching a specific commit so we fall back to fetching all heads
 		// and tags, hoping that the commit is included.
+	} else if fromArchive && gitHasCommit(b.shell, b.Commit) {
+		// The repository might be unreachable, so don't fetch what the
+		// archive already has
+		b.shell.Commentf("Commit %s is in the git archive, skipping the fetch", b.Commit)
+		if err := b.shell.Run("git", "checkout", "-f", b.Commit); err != nil {
+			return err
+		}
 	} else {
-		if err := gitFetch(b.shell, "-v", "origin", b.Commit); err != nil {
+		err := gitFetch(b.shell, "-v", "origin", b.Commit)
+		if err != nil {
 			// By default `git fetch origin` will only fetch tags which are
 			// reachable from a fetches branch. git 1.9.0+ changed `--tags` to
 			// fetch all tags in addition to the default refspec, but pre 1.9.0 it
 			// excludes the default refspec.
 			gitFetchRefspec, _ := b.shell.RunAndCapture("git", "config", "remote.origin.fetch")
-			if err := gitFetch(b.shell, "-v --prune", "origin", gitFetchRefspec, "+refs/tags/*:refs/tags/*"); err != nil {
+			err = gitFetch(b.shell, "-v --prune", "origin", gitFetchRefspec, "+refs/tags/*:refs/tags/*")
+		}
+
+		// An existing checkout can't be updated when the repository is
+		// unreachable either, so it's replaced by the git archive if that
+		// has the commit
+		if err != nil {
+			if b.GitArchiveURL == "" || fromArchive {
 				return err
 			}
+
+			b.shell.Warningf("Failed to fetch %s (%v), falling back to the git archive", b.Commit, err)
+
+			if err := b.replaceCheckoutWithGitArchive(repository); err != nil {
+				return err
+			}
+			if !gitHasCommit(b.shell, b.Commit) {
+				return fmt.Errorf("Commit %s isn't in the git archive", b.Commit)
+			}
 		}
+
 		if err := b.shell.Run("git", "checkout", "-f", b.Commit); err != nil {
 			return err
 		}
@@ -876,7 +1107,7 @@ func (b *Bootstrap) defaultCheckoutPhase() error {
 				b.shell.Warningf("Failed to enumerate git submodules: %v", err)
 			} else {
 				for _, repository := range submoduleRepos {
-					addRepositoryHostToSSHKnownHosts(b.shell, repository)
+					addRepositoryHostToSSHKnownHosts(b.shell, rewriteGitURL(repository, rewrites))
 				}
 			}
 		}
@@ -891,7 +1122,8 @@ func (b *Bootstrap) defaultCheckoutPhase() error {
 			b.shell.Warningf("Failed to recursively sync git submodules. This is most likely because you have an older version of git installed (" + gitVersionOutput + ") and you need version 1.8.1 and above. If you're using submodules, it's highly recommended you upgrade if you can.")
 		}
 
-		if err := b.shell.Run("git", "submodule", "update", "--init", "--recursive", "--force"); err != nil {
+		args := append(gitURLRewriteArgs(rewrites), "submodule", "update", "--init", "--recursive", "--force")
+		if err := b.shell.Run("git", args...); err != nil {
 			return err
 		}
 		if err := b.shell.Run("git", "submodule", "foreach", "--recursive", "git", "reset", "--hard"); err != nil {
@@ -946,6 +1178,15 @@ func (b *Bootstrap) CommandPhase() error {
 		return err
 	}
 
+	// Load .env files after the agent's own hook, so that nothing in the
+	// repository can change what it sees or sets, but before the repository
+	// and plugin hooks so they can see them too
+	if b.DotenvFiles != "" {
+		if err := b.loadDotenvFiles(); err != nil {
+			return err
+		}
+	}
+
 	if err := b.executeLocalHook("pre-command"); err != nil {
 		return err
 	}
@@ -954,18 +1195,28 @@ func (b *Bootstrap) CommandPhase() error {
 		return err
 	}
 
+	retryExitCodes, err := parseExitCodes(b.CommandRetryExitCodes)
+	if err != nil {
+		return err
+	}
+
 	var commandExitError error
 
-	// There can only be one command hook, so we check them in order of plugin, local
-	switch {
-	case b.hasPluginHook("command"):
-		commandExitError = b.executePluginHook("command")
-	case b.hasLocalHook("command"):
-		commandExitError = b.executeLocalHook("command")
-	case b.hasGlobalHook("command"):
-		commandExitError = b.executeGlobalHook("command")
-	default:
-		commandExitError = b.defaultCommandPhase()
+	// Re-run the command for exit codes that are known to be flaky, rather
+	// than failing the whole job
+	for attempt := 1; ; attempt++ {
+		b.shell.Env.Set("BUILDKITE_COMMAND_ATTEMPT", fmt.Sprintf("%d", attempt))
+
+		commandExitError = b.runCommand()
+
+		if attempt > b.CommandRetryCount || !shouldRetryCommand(commandExitError, retryExitCodes) {
+			break
+		}
+
+		b.shell.Warningf("The command exited with status %d, retrying it (%d of %d)",
+			shell.GetExitCode(commandExitError), attempt, b.CommandRetryCount)
+		b.shell.Printf("^^^ +++")
+		b.shell.Headerf("Retrying the command")
 	}
 
 	// If the command returned an exit that wasn't a `exec.ExitError`
@@ -982,6 +1233,20 @@ func (b *Bootstrap) CommandPhase() error {
 		b.shell.Printf("^^^ +++")
 	}
 
+	// Keep whatever a crash left behind, before the post-command hooks get a
+	// chance to clean it up
+	if sig, crashed := crashSignal(commandExitError); crashed {
+		b.uploadCrashArtifacts(sig)
+	}
+
+	// Give someone a chance to debug the failure, before the post-command
+	// hooks clean anything up
+	if commandExitError != nil && b.debugSessionRequested() {
+		if err := b.runDebugSession(); err != nil {
+			b.shell.Warningf("Failed to start a debug session: %v", err)
+		}
+	}
+
 	// Save the command exit status to the env so hooks + plugins can access it. If there is no error
 	// this will be zero. It's used to set the exit code later, so it's important
 	b.shell.Env.Set("BUILDKITE_COMMAND_EXIT_STATUS", fmt.Sprintf("%d", shell.GetExitCode(commandExitError)))
@@ -1002,6 +1267,57 @@ func (b *Bootstrap) CommandPhase() error {
 	return nil
 }
 
+// runCommand runs the command hook, or the command itself if there isn't one
+func (b *Bootstrap) runCommand() error {
+	// There can only be one command hook, so we check them in order of plugin, local
+	switch {
+	case b.hasPluginHook("command"):
+		return b.executePluginHook("command")
+	case b.hasLocalHook("command"):
+		return b.executeLocalHook("command")
+	case b.hasGlobalHook("command"):
+		return b.executeGlobalHook("command")
+	default:
+		return b.defaultCommandPhase()
+	}
+}
+
+// shouldRetryCommand returns whether the command exited with one of the exit
+// codes it's re-run for
+func shouldRetryCommand(err error, exitCodes []int) bool {
+	if !shell.IsExitError(err) {
+		return false
+	}
+
+	code := shell.GetExitCode(err)
+	for _, exitCode := range exitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseExitCodes parses a comma separated list of exit codes
+func parseExitCodes(codes string) ([]int, error) {
+	var parsed []int
+
+	for _, code := range strings.Split(codes, ",") {
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+
+		i, err := strconv.Atoi(strings.TrimSpace(code))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid exit code %q in BUILDKITE_COMMAND_RETRY_EXIT_CODES", code)
+		}
+		parsed = append(parsed, i)
+	}
+
+	return parsed, nil
+}
+
 // defaultCommandPhase is executed if there is no global or plugin command hook
 func (b *Bootstrap) defaultCommandPhase() error {
 	// Make sure we actually have a command to run
@@ -1087,17 +1403,65 @@ func (b *Bootstrap) defaultCommandPhase() error {
 		return runDeprecatedDockerIntegration(b.shell, []string{cmdToExec})
 	}
 
+	if b.DockerImage != "" {
+		// The sandbox can't wrap a container, so refuse rather than run
+		// the command with less confinement than the agent asked for
+		if b.CommandSandbox {
+			return fmt.Errorf("The command can't run in %s as the agent runs commands in the command sandbox", b.DockerImage)
+		}
+		return b.runInDockerImage(cmdToExec)
+	}
+
 	var cmd []string
 	cmd = append(cmd, shell...)
 	cmd = append(cmd, cmdToExec)
 
+	if b.CommandSandbox {
+		if cmd, err = b.sandboxCommand(cmd); err != nil {
+			return err
+		}
+	}
+
 	if b.Debug {
 		b.shell.Promptf("%s", process.FormatCommand(cmd[0], cmd[1:]))
 	} else {
 		b.shell.Promptf("%s", cmdToExec)
 	}
 
-	return b.shell.RunWithoutPrompt(cmd[0], cmd[1:]...)
+	return b.audited("command", "command", cmd[0], cmd[1:], func() error {
+		return b.shell.RunWithoutPrompt(cmd[0], cmd[1:]...)
+	})
+}
+
+// sandboxCommand wraps the command so that it runs in its own namespaces,
+// where it can still see the checkout and talk to the agent over a unix
+// socket. It gets its own empty /tmp, /var/tmp and /dev/shm, so it can't see
+// other jobs' files in them, and anything it writes there is thrown away.
+func (b *Bootstrap) sandboxCommand(cmd []string) ([]string, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("The command sandbox is only supported on Linux")
+	}
+
+	if _, err := b.shell.AbsolutePath("unshare"); err != nil {
+		return nil, fmt.Errorf("The command sandbox requires unshare from util-linux: %v", err)
+	}
+
+	keep := []string{b.shell.Getwd(), b.BinPath}
+	if endpoint, _ := b.shell.Env.Get("BUILDKITE_AGENT_ENDPOINT"); strings.HasPrefix(endpoint, "unix://") {
+		// The socket is in a directory of its own, which is all of the
+		// agent's temp files that the command gets to see
+		keep = append(keep, filepath.Dir(strings.TrimPrefix(endpoint, "unix://")))
+	}
+
+	if b.Debug {
+		b.shell.Commentf("Running the command in new mount, PID and network namespaces")
+	}
+
+	return sandbox.NamespaceWrap(cmd, sandbox.NamespaceOptions{
+		Tmpfs:       sandbox.DefaultNamespaceTmpfs,
+		Keep:        keep,
+		MapRootUser: os.Getuid() != 0,
+	}), nil
 }
 
 func (b *Bootstrap) writeBatchScript(cmd string) (string, error) {
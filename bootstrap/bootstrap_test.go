@@ -1,8 +1,13 @@
 package bootstrap
 
 import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
 	"testing"
 
+	"github.com/buildkite/agent/api"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,3 +27,33 @@ func TestDirForAgentName(t *testing.T) {
 		assert.Equal(t, test.expected, dirForAgentName(test.agentName))
 	}
 }
+
+func TestPhaseTimingsAreWritten(t *testing.T) {
+	t.Parallel()
+
+	file, err := ioutil.TempFile("", "phase-timings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	b := &Bootstrap{Config: Config{PhaseTimingsFile: file.Name()}}
+
+	assert.NoError(t, b.timedPhase("checkout", func() error { return nil }))
+	assert.EqualError(t, b.timedPhase("command", func() error { return errors.New("Nope") }), "Nope")
+	assert.NoError(t, b.writePhaseTimings())
+
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var timings []api.PhaseTiming
+	assert.NoError(t, json.Unmarshal(data, &timings))
+
+	if assert.Len(t, timings, 2) {
+		assert.Equal(t, "checkout", timings[0].Name)
+		assert.Equal(t, "command", timings[1].Name)
+	}
+}
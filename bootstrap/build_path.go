@@ -0,0 +1,170 @@
+package bootstrap
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+)
+
+// DefaultBuildPathTemplate is where checkouts go within the build path,
+// which gives each agent its own checkout of each pipeline
+const DefaultBuildPathTemplate = "{agent}/{organization}/{pipeline}"
+
+// The placeholders a build path template can use:
+//
+// {agent}         The agent's name
+// {organization}  The organization's slug
+// {pipeline}      The pipeline's slug
+// {queue}         The agent's queue tag, or "default"
+// {hash}          A short hash of the organization and pipeline slugs
+var buildPathPlaceholders = map[string]bool{
+	"agent":        true,
+	"organization": true,
+	"pipeline":     true,
+	"queue":        true,
+	"hash":         true,
+}
+
+var buildPathPlaceholderRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// How long a job waits for another job to finish with a shared checkout
+// before giving up, and how often it checks. They're variables so that tests
+// can shorten them.
+var (
+	checkoutLockTimeout      = time.Hour
+	checkoutLockPollInterval = time.Second
+)
+
+// ValidateBuildPathTemplate returns an error if a build path template uses
+// placeholders that don't exist, or would put checkouts outside of the build
+// path
+func ValidateBuildPathTemplate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("The build path template can't be empty")
+	}
+
+	for _, match := range buildPathPlaceholderRegex.FindAllStringSubmatch(template, -1) {
+		if !buildPathPlaceholders[match[1]] {
+			return fmt.Errorf("Unknown placeholder %s in build path template %q", match[0], template)
+		}
+	}
+
+	slashed := filepath.ToSlash(template)
+	if path.IsAbs(slashed) || filepath.IsAbs(template) {
+		return fmt.Errorf("The build path template %q must be relative to the build path", template)
+	}
+
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return fmt.Errorf("The build path template %q can't leave the build path", template)
+		}
+	}
+
+	return nil
+}
+
+// buildPathFromTemplate returns the checkout path within the build path for
+// a template
+func (b *Bootstrap) buildPathFromTemplate(template string) string {
+	queue, _ := b.shell.Env.Get("BUILDKITE_AGENT_META_DATA_QUEUE")
+	if queue == "" {
+		queue = "default"
+	}
+
+	hash := sha1.Sum([]byte(b.OrganizationSlug + "/" + b.PipelineSlug))
+
+	vars := map[string]string{
+		"agent":        b.AgentName,
+		"organization": b.OrganizationSlug,
+		"pipeline":     b.PipelineSlug,
+		"queue":        queue,
+		"hash":         fmt.Sprintf("%x", hash[:4]),
+	}
+
+	expanded := buildPathPlaceholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		// Values can't add path separators of their own
+		return dirForAgentName(vars[strings.Trim(placeholder, "{}")])
+	})
+
+	return filepath.FromSlash(expanded)
+}
+
+// sharesCheckouts returns whether a build path template puts the checkouts of
+// different agents in the same place
+func sharesCheckouts(template string) bool {
+	return !strings.Contains(template, "{agent}")
+}
+
+// lockCheckout locks a checkout that's shared with other agents until the job
+// has finished, waiting for any job that's using it already, so that another
+// job can't check out over it or clean it in the meantime. The lock file sits
+// next to the checkout so that cleaning the checkout doesn't remove it. It
+// stops waiting if the job is cancelled, or after checkoutLockTimeout.
+func (b *Bootstrap) lockCheckout(checkoutPath string) error {
+	if err := os.MkdirAll(filepath.Dir(checkoutPath), 0777); err != nil {
+		return err
+	}
+
+	lockPath, err := filepath.Abs(checkoutPath + ".lock")
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockfile.New(lockPath)
+	if err != nil {
+		return fmt.Errorf("Failed to create lock %q (%v)", lockPath, err)
+	}
+
+	// Cancelling the job shouldn't leave it waiting
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	deadline := time.After(checkoutLockTimeout)
+
+	for waiting := false; ; {
+		err := lock.TryLock()
+		if err == nil {
+			break
+		}
+		if te, ok := err.(interface{ Temporary() bool }); !ok || !te.Temporary() {
+			return fmt.Errorf("Failed to lock the shared checkout %q (%v)", checkoutPath, err)
+		}
+
+		if !waiting {
+			b.shell.Commentf("Waiting for the job that's using the shared checkout at %s to finish", checkoutPath)
+			waiting = true
+		}
+
+		select {
+		case <-time.After(checkoutLockPollInterval):
+		case <-deadline:
+			return fmt.Errorf("Timed out after %s waiting for the job that's using the shared checkout at %s to finish", checkoutLockTimeout, checkoutPath)
+		case sig := <-signals:
+			return fmt.Errorf("Stopped waiting for the shared checkout at %s after receiving %v", checkoutPath, sig)
+		}
+	}
+
+	b.checkoutLock = &lock
+	return nil
+}
+
+// unlockCheckout releases the lock from lockCheckout, if there is one
+func (b *Bootstrap) unlockCheckout() {
+	if b.checkoutLock == nil {
+		return
+	}
+	if err := b.checkoutLock.Unlock(); err != nil {
+		b.shell.Warningf("Failed to unlock the shared checkout: %v", err)
+	}
+	b.checkoutLock = nil
+}
@@ -0,0 +1,173 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/bootstrap/shell"
+	"github.com/buildkite/agent/env"
+)
+
+func TestValidateBuildPathTemplate(t *testing.T) {
+	for _, template := range []string{
+		DefaultBuildPathTemplate,
+		"{organization}/{pipeline}",
+		"{queue}/{hash}",
+		"checkouts/{pipeline}-{hash}",
+	} {
+		if err := ValidateBuildPathTemplate(template); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", template, err)
+		}
+	}
+
+	for _, template := range []string{
+		"",
+		"{organization}/{llamas}",
+		"/{pipeline}",
+		"../{pipeline}",
+		"{organization}/../../{pipeline}",
+	} {
+		if err := ValidateBuildPathTemplate(template); err == nil {
+			t.Errorf("Expected %q to be invalid", template)
+		}
+	}
+}
+
+func TestBuildPathFromTemplate(t *testing.T) {
+	sh, err := shell.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.Env = env.FromSlice([]string{"BUILDKITE_AGENT_META_DATA_QUEUE=linux/arm64"})
+
+	b := &Bootstrap{
+		Config: Config{AgentName: "my-agent 1", OrganizationSlug: "my-org", PipelineSlug: "my-pipeline"},
+		shell:  sh,
+	}
+
+	for template, expected := range map[string]string{
+		DefaultBuildPathTemplate:    filepath.Join("my-agent-1", "my-org", "my-pipeline"),
+		"{organization}/{pipeline}": filepath.Join("my-org", "my-pipeline"),
+		"{queue}/{hash}":            filepath.Join("linux-arm64", "fc3f4e7c"),
+	} {
+		if actual := b.buildPathFromTemplate(template); actual != expected {
+			t.Errorf("Expected %q to expand to %q, got %q", template, expected, actual)
+		}
+	}
+
+	sh.Env = env.FromSlice(nil)
+	if actual := b.buildPathFromTemplate("{queue}"); actual != "default" {
+		t.Errorf("Expected the default queue, got %q", actual)
+	}
+}
+
+func TestLockCheckoutWaitsForTheOtherJob(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Lock files need a process to own them")
+	}
+
+	dir, err := ioutil.TempDir("", "shared-checkout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Another job's bootstrap has the lock until it exits
+	other := exec.Command("sleep", "1")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go other.Wait()
+
+	checkoutPath := filepath.Join(dir, "my-org", "my-pipeline")
+	if err := os.MkdirAll(filepath.Dir(checkoutPath), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(checkoutPath+".lock", []byte(fmt.Sprintf("%d\n", other.Process.Pid)), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	sh, err := shell.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.Logger = shell.DiscardLogger
+
+	b := &Bootstrap{shell: sh}
+
+	start := time.Now()
+	if err := b.lockCheckout(checkoutPath); err != nil {
+		t.Fatal(err)
+	}
+	defer b.unlockCheckout()
+
+	if waited := time.Since(start); waited < 500*time.Millisecond {
+		t.Fatalf("Expected to wait for the other job, only waited %v", waited)
+	}
+
+	owner, err := ioutil.ReadFile(checkoutPath + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := strings.TrimSpace(string(owner)); pid != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("Expected the lock to be ours, it's owned by %s", pid)
+	}
+}
+
+func TestLockCheckoutGivesUpAfterTheTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Lock files need a process to own them")
+	}
+
+	defer func(timeout, interval time.Duration) {
+		checkoutLockTimeout, checkoutLockPollInterval = timeout, interval
+	}(checkoutLockTimeout, checkoutLockPollInterval)
+	checkoutLockTimeout, checkoutLockPollInterval = 200*time.Millisecond, 10*time.Millisecond
+
+	dir, err := ioutil.TempDir("", "shared-checkout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Another job's bootstrap has the lock for longer than the timeout
+	other := exec.Command("sleep", "5")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Process.Kill()
+
+	checkoutPath := filepath.Join(dir, "my-pipeline")
+	if err := ioutil.WriteFile(checkoutPath+".lock", []byte(fmt.Sprintf("%d\n", other.Process.Pid)), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	sh, err := shell.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.Logger = shell.DiscardLogger
+
+	b := &Bootstrap{shell: sh}
+
+	if err := b.lockCheckout(checkoutPath); err == nil || !strings.Contains(err.Error(), "Timed out") {
+		t.Fatalf("Expected the lock to time out, got %v", err)
+	}
+}
+
+func TestSharesCheckouts(t *testing.T) {
+	if sharesCheckouts(DefaultBuildPathTemplate) {
+		t.Error("Expected the default template to give each agent its own checkout")
+	}
+	if !sharesCheckouts("{organization}/{pipeline}") {
+		t.Error("Expected a template without {agent} to share checkouts")
+	}
+}
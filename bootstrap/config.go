@@ -2,6 +2,7 @@ package bootstrap
 
 import (
 	"reflect"
+	"strconv"
 
 	"github.com/buildkite/agent/env"
 )
@@ -37,8 +38,9 @@ type Config struct {
 	// Optional refspec to override git fetch
 	RefSpec string `env:"BUILDKITE_REFSPEC"`
 
-	// Plugin definition for the job
-	Plugins string
+	// Plugin definition for the job, which tooling can also generate from
+	// the environment hook
+	Plugins string `env:"BUILDKITE_PLUGINS"`
 
 	// Should git submodules be checked out
 	GitSubmodules bool
@@ -67,6 +69,19 @@ type Config struct {
 	// Flags to pass to "git clean" command
 	GitCleanFlags string `env:"BUILDKITE_GIT_CLEAN_FLAGS"`
 
+	// Rules in the form "from=to" for rewriting repository URLs to mirrors
+	GitURLRewrites []string
+
+	// A git bundle or tarball of the repository to use if cloning it fails
+	GitArchiveURL string `env:"BUILDKITE_GIT_ARCHIVE_URL"`
+
+	// Comma-separated .env files in the checkout to load before the command
+	DotenvFiles string `env:"BUILDKITE_DOTENV_FILES"`
+
+	// Variables the .env files can set that they'd otherwise be refused,
+	// like PATH or LD_PRELOAD
+	DotenvAllowedVars []string
+
 	// Whether or not to run the hooks/commands in a PTY
 	RunInPty bool
 
@@ -82,9 +97,17 @@ type Config struct {
 	// Are local hooks enabled?
 	LocalHooksEnabled bool
 
+	// Whether to skip the hooks in the repository's .buildkite/hooks
+	// directory, rather than running them after the global hooks
+	SkipRepositoryHooks bool
+
 	// Path where the builds will be run
 	BuildPath string
 
+	// The layout of checkouts within the build path, like
+	// {organization}/{pipeline}
+	BuildPathTemplate string
+
 	// Path to the buildkite-agent binary
 	BinPath string
 
@@ -105,6 +128,61 @@ type Config struct {
 
 	// The shell used to execute commands
 	Shell string
+
+	// An OpenTelemetry collector to send traces of the bootstrap to
+	TracingOTLPEndpoint string
+
+	// The W3C traceparent of the span the bootstrap's spans belong under
+	TracingParent string
+
+	// A file to write how long each phase took to, for the agent to report
+	PhaseTimingsFile string
+
+	// The seccomp profile, AppArmor profile and SELinux context the agent
+	// confines the bootstrap with, which containers it starts don't inherit
+	SeccompProfile  string
+	AppArmorProfile string
+	SELinuxContext  string
+
+	// Whether the command phase runs in its own Linux namespaces
+	CommandSandbox bool
+
+	// A file to append a record of every hook and command that's run to
+	AuditLog string
+
+	// Whether to upload the audit log as an artifact once the job finishes
+	AuditLogUpload bool
+
+	// A file descriptor to write audit log entries to instead, which is a
+	// pipe back to the agent. The agent owns the audit log, so the job can't
+	// change what's already been recorded.
+	AuditLogFD int
+
+	// A file descriptor to explain failures caused by the agent's
+	// infrastructure on. It's a pipe back to the agent that hooks and the
+	// command don't inherit, so a job can't claim its own failures were the
+	// agent's.
+	InfraFailureFD int
+
+	// Paths to upload as artifacts when the command crashes because of a
+	// signal, like core dumps and crash logs
+	CrashArtifactPaths string
+
+	// How many minutes a debug session can last when a job that asks for
+	// one fails. 0 means the agent doesn't allow them.
+	DebugSessionTimeout int
+
+	// Path to an authorized_keys file for the people who can use a debug
+	// session. Without it, sessions are read-only.
+	DebugSessionAuthorizedKeys string
+
+	// A Docker image to run the command in, with the checkout mounted
+	DockerImage string `env:"BUILDKITE_DOCKER_IMAGE"`
+
+	// Comma separated exit codes that the command is re-run for, and how
+	// many times it's re-run for them
+	CommandRetryExitCodes string `env:"BUILDKITE_COMMAND_RETRY_EXIT_CODES"`
+	CommandRetryCount     int    `env:"BUILDKITE_COMMAND_RETRY_COUNT"`
 }
 
 // ReadFromEnvironment reads configuration from the Environment, returns a map
@@ -126,9 +204,18 @@ func (c *Config) ReadFromEnvironment(environ *env.Environment) map[string]string
 			newValue, _ := environ.Get(tag)
 
 			// We only care if the value has changed
-			if newValue != value.String() {
-				value.SetString(newValue)
-				changed[tag] = newValue
+			switch value.Kind() {
+			case reflect.Int:
+				i, err := strconv.Atoi(newValue)
+				if err == nil && int64(i) != value.Int() {
+					value.SetInt(int64(i))
+					changed[tag] = newValue
+				}
+			default:
+				if newValue != value.String() {
+					value.SetString(newValue)
+					changed[tag] = newValue
+				}
 			}
 		}
 	}
@@ -38,3 +38,44 @@ func TestEnvVarsAreMappedToConfig(t *testing.T) {
 			expected, config.GitCleanFlags)
 	}
 }
+
+func TestPluginsAreMappedToConfig(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{}
+
+	changes := config.ReadFromEnvironment(env.FromSlice([]string{
+		`BUILDKITE_PLUGINS=[{"docker#v1.0.0":{"image":"alpine"}}]`,
+	}))
+
+	if expected := `[{"docker#v1.0.0":{"image":"alpine"}}]`; config.Plugins != expected || changes["BUILDKITE_PLUGINS"] != expected {
+		t.Fatalf("Expected Plugins to be %v, got %v", expected, config.Plugins)
+	}
+}
+
+func TestIntEnvVarsAreMappedToConfig(t *testing.T) {
+	t.Parallel()
+
+	config := &Config{CommandRetryCount: 1}
+
+	changes := config.ReadFromEnvironment(env.FromSlice([]string{
+		"BUILDKITE_COMMAND_RETRY_COUNT=3",
+	}))
+
+	if expected := map[string]string{"BUILDKITE_COMMAND_RETRY_COUNT": "3"}; !reflect.DeepEqual(expected, changes) {
+		t.Fatalf("%#v wasn't equal to %#v", expected, changes)
+	}
+
+	if config.CommandRetryCount != 3 {
+		t.Fatalf("Expected CommandRetryCount to be 3, got %d", config.CommandRetryCount)
+	}
+
+	// Values that aren't numbers are ignored
+	changes = config.ReadFromEnvironment(env.FromSlice([]string{
+		"BUILDKITE_COMMAND_RETRY_COUNT=lots",
+	}))
+
+	if len(changes) != 0 || config.CommandRetryCount != 3 {
+		t.Fatalf("Expected no changes, got %#v and %d", changes, config.CommandRetryCount)
+	}
+}
@@ -0,0 +1,62 @@
+package bootstrap
+
+import (
+	"runtime"
+	"syscall"
+
+	"github.com/buildkite/agent/bootstrap/shell"
+)
+
+// The signals that mean a process crashed, rather than being asked to stop.
+// SIGKILL isn't one of them, as it's what cancelling a job that won't stop
+// ends up sending, and the OOM killer's victims don't leave a core dump.
+var crashSignals = map[syscall.Signal]string{
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGTRAP: "SIGTRAP",
+}
+
+// crashSignal returns the signal that crashed the command, if it was one.
+// Commands are run by a shell, which exits with 128 plus the signal number
+// when its child is killed, so that's treated the same as the shell itself
+// being killed.
+func crashSignal(err error) (syscall.Signal, bool) {
+	if err == nil {
+		return 0, false
+	}
+
+	if sig, ok := shell.GetExitSignal(err); ok {
+		_, crashed := crashSignals[sig]
+		return sig, crashed
+	}
+
+	if runtime.GOOS == "windows" || !shell.IsExitError(err) {
+		return 0, false
+	}
+
+	sig := syscall.Signal(shell.GetExitCode(err) - 128)
+	_, crashed := crashSignals[sig]
+	return sig, crashed
+}
+
+// uploadCrashArtifacts uploads anything matching the crash artifact paths,
+// tagged with the signal so they're easy to find. It's only a warning if it
+// fails, since the command has already failed.
+func (b *Bootstrap) uploadCrashArtifacts(sig syscall.Signal) {
+	if b.CrashArtifactPaths == "" {
+		return
+	}
+
+	name := crashSignals[sig]
+
+	b.shell.Headerf("Uploading crash artifacts")
+	b.shell.Commentf("The command was killed by %s, looking for crash artifacts in %s", name, b.CrashArtifactPaths)
+
+	args := []string{"artifact", "upload", b.CrashArtifactPaths, "--tag", "signal=" + name}
+	if err := b.shell.Run("buildkite-agent", args...); err != nil {
+		b.shell.Warningf("Failed to upload crash artifacts: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+// +build !windows
+
+package bootstrap
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/buildkite/agent/bootstrap/shell"
+)
+
+func TestCrashSignal(t *testing.T) {
+	for _, tc := range []struct {
+		err     error
+		signal  syscall.Signal
+		crashed bool
+	}{
+		{nil, 0, false},
+		{errors.New("llamas"), 0, false},
+		{&shell.ExitError{Code: 1}, 0, false},
+		{&shell.ExitError{Code: 128 + int(syscall.SIGSEGV)}, syscall.SIGSEGV, true},
+		{&shell.ExitError{Code: 128 + int(syscall.SIGKILL)}, syscall.SIGKILL, false},
+		{&shell.ExitError{Code: 128 + int(syscall.SIGTERM)}, syscall.SIGTERM, false},
+	} {
+		sig, crashed := crashSignal(tc.err)
+		if crashed != tc.crashed || (crashed && sig != tc.signal) {
+			t.Errorf("crashSignal(%v) = %v, %v, expected %v, %v", tc.err, sig, crashed, tc.signal, tc.crashed)
+		}
+	}
+}
+
+func TestCrashSignalFromKilledProcess(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "kill -SEGV $$")
+	err := cmd.Run()
+
+	sig, crashed := crashSignal(err)
+	if !crashed || sig != syscall.SIGSEGV {
+		t.Fatalf("Expected a SIGSEGV crash, got %v, %v (%v)", sig, crashed, err)
+	}
+}
@@ -0,0 +1,147 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How often we check whether everyone has left the debug session
+const debugSessionPollInterval = 5 * time.Second
+
+// debugSessionRequested returns whether the job asked for a debug session if
+// its command fails, which it does by setting BUILDKITE_DEBUG_SESSION=true.
+// The agent has to allow them too, by giving them a timeout.
+func (b *Bootstrap) debugSessionRequested() bool {
+	if b.DebugSessionTimeout <= 0 {
+		return false
+	}
+
+	requested, _ := b.shell.Env.Get(`BUILDKITE_DEBUG_SESSION`)
+	return requested == "true" || requested == "1"
+}
+
+// runDebugSession starts a tmate session in the job's environment after its
+// command has failed, so that someone can SSH in and look around. It lasts
+// until everyone who joined has left, or the timeout is up.
+//
+// Anyone who can read the job log sees how to connect, so the session is
+// read-only unless the agent has been given the keys that are allowed to use
+// it. It also runs where the bootstrap does, so it's refused when the command
+// ran somewhere else, like in a Docker image or the command sandbox.
+func (b *Bootstrap) runDebugSession() error {
+	b.shell.Headerf("Starting a debug session")
+
+	if b.DockerImage != "" {
+		return fmt.Errorf("Debug sessions can't be used when the command runs in a Docker image")
+	}
+	if b.CommandSandbox {
+		return fmt.Errorf("Debug sessions can't be used when the command runs in the command sandbox")
+	}
+
+	if _, err := b.shell.AbsolutePath("tmate"); err != nil {
+		return fmt.Errorf("Debug sessions need tmate to be installed on the agent: %v", err)
+	}
+
+	// Without authorized keys anyone could connect, so only a read-only
+	// session is shown
+	tmateArgs := []string{}
+	format := "#{tmate_ssh_ro}"
+	if b.DebugSessionAuthorizedKeys != "" {
+		if _, err := os.Stat(b.DebugSessionAuthorizedKeys); err != nil {
+			return fmt.Errorf("Failed to read the debug session's authorized keys: %v", err)
+		}
+		tmateArgs = append(tmateArgs, "-a", b.DebugSessionAuthorizedKeys)
+		format = "#{tmate_ssh}"
+	}
+
+	dir, err := ioutil.TempDir("", "buildkite-debug-session")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "tmate.sock")
+
+	// The session gets the job's environment and working directory, the
+	// same as the command did
+	if err := b.shell.Run("tmate", append(append([]string{"-S", socket}, tmateArgs...), "new-session", "-d")...); err != nil {
+		return err
+	}
+	defer func() {
+		if err := b.shell.Run("tmate", "-S", socket, "kill-server"); err != nil {
+			b.shell.Warningf("Failed to stop the debug session: %v", err)
+		}
+	}()
+
+	if err := b.shell.Run("tmate", "-S", socket, "wait", "tmate-ready"); err != nil {
+		return err
+	}
+
+	ssh, err := b.shell.RunAndCapture("tmate", "-S", socket, "display", "-p", format)
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(b.DebugSessionTimeout) * time.Minute
+
+	b.shell.Printf("^^^ +++")
+	b.shell.Printf("The command failed, so this job will wait for up to %s for you to debug it. Connect with:", timeout)
+	b.shell.Printf("")
+	b.shell.Printf("    %s", ssh)
+	b.shell.Printf("")
+	if b.DebugSessionAuthorizedKeys == "" {
+		b.shell.Printf("The session is read-only, as the agent doesn't have any authorized keys for debug sessions.")
+	}
+	b.shell.Printf("The job carries on once everyone who joined has left the session.")
+
+	// Cancelling the job shouldn't leave the session behind
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(debugSessionPollInterval)
+	defer ticker.Stop()
+
+	joined := false
+	for {
+		clients, err := b.debugSessionClients(socket)
+		if err != nil {
+			b.shell.Commentf("The debug session has ended")
+			return nil
+		}
+		if clients > 0 {
+			joined = true
+		} else if joined {
+			b.shell.Commentf("Everyone has left the debug session")
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			b.shell.Commentf("The debug session has timed out after %s", timeout)
+			return nil
+		case sig := <-signals:
+			b.shell.Commentf("Ending the debug session after receiving %v", sig)
+			return nil
+		}
+	}
+}
+
+// debugSessionClients returns how many people are connected to the session,
+// or an error if the session has gone away
+func (b *Bootstrap) debugSessionClients(socket string) (int, error) {
+	out, err := b.shell.RunAndCapture("tmate", "-S", socket, "display", "-p", "#{tmate_num_clients}")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
@@ -2,9 +2,13 @@ package bootstrap
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/buildkite/agent/bootstrap/shell"
+	"github.com/buildkite/agent/process"
 	"github.com/pkg/errors"
 )
 
@@ -17,6 +21,150 @@ var dockerEnv = []string{
 	`BUILDKITE_DOCKER_COMPOSE_LEAVE_VOLUMES`,
 }
 
+// Env that describes the machine the bootstrap is running on, rather than
+// the job, so it isn't passed into the command's container
+var dockerHostEnv = map[string]bool{
+	`HOME`:     true,
+	`HOSTNAME`: true,
+	`LOGNAME`:  true,
+	`OLDPWD`:   true,
+	`PATH`:     true,
+	`PWD`:      true,
+	`SHELL`:    true,
+	`SHLVL`:    true,
+	`TMPDIR`:   true,
+	`USER`:     true,
+	`_`:        true,
+}
+
+// runInDockerImage pulls the step's image and runs the command in it, with
+// the checkout mounted at the same path and the job's env passed through.
+// The command is run with /bin/sh, as the agent's shell may not exist in
+// the image.
+func (b *Bootstrap) runInDockerImage(command string) error {
+	cmd := []string{"/bin/sh", "-e", "-c", command}
+
+	if _, err := b.shell.AbsolutePath("docker"); err != nil {
+		return fmt.Errorf("Running the command in %s requires docker: %v", b.DockerImage, err)
+	}
+
+	b.shell.Headerf(":docker: Pulling %s", b.DockerImage)
+	if err := b.shell.Run("docker", "pull", b.DockerImage); err != nil {
+		return err
+	}
+
+	agentBinary, err := b.agentBinary()
+	if err != nil {
+		return err
+	}
+
+	securityOpts, err := dockerSecurityOpts(b.AppArmorProfile, b.SELinuxContext)
+	if err != nil {
+		return err
+	}
+
+	// The seccomp filter is in the agent's own format, and docker gives
+	// containers its default profile rather than inheriting ours
+	if b.SeccompProfile != "" {
+		b.shell.Warningf("The seccomp profile can't be applied in %s, so the command is running under docker's default seccomp profile instead", b.DockerImage)
+	}
+
+	args := dockerRunArgs(b.shell, b.DockerImage, agentBinary, securityOpts, cmd)
+
+	b.shell.Headerf(":docker: Running command in %s", b.DockerImage)
+	if b.Debug {
+		b.shell.Promptf("%s", process.FormatCommand("docker", args))
+	} else {
+		b.shell.Promptf("%s", process.FormatCommand(cmd[len(cmd)-1], nil))
+	}
+
+	return b.audited("command", "command", "docker", args, func() error {
+		return b.shell.RunWithoutPrompt("docker", args...)
+	})
+}
+
+// The path the buildkite-agent binary is mounted at in the command's container
+const dockerAgentBinary = "/usr/local/bin/buildkite-agent"
+
+// agentBinary returns the path to the buildkite-agent binary that's running
+// the bootstrap, so it can be mounted into the command's container
+func (b *Bootstrap) agentBinary() (string, error) {
+	if b.BinPath != "" {
+		return filepath.Join(b.BinPath, "buildkite-agent"), nil
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("Failed to find the buildkite-agent binary to mount into %s: %v", b.DockerImage, err)
+	}
+
+	return path, nil
+}
+
+// dockerSecurityOpts are the docker run options that confine the container
+// with the same AppArmor profile and SELinux context as the bootstrap, as
+// the container is created by the docker daemon and doesn't inherit them
+func dockerSecurityOpts(appArmorProfile string, selinuxContext string) ([]string, error) {
+	var opts []string
+
+	if appArmorProfile != "" {
+		opts = append(opts, "--security-opt", "apparmor="+appArmorProfile)
+	}
+
+	if selinuxContext != "" {
+		parts := strings.SplitN(selinuxContext, ":", 4)
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("Failed to parse SELinux context %q, expected user:role:type:level", selinuxContext)
+		}
+		for i, field := range []string{"user", "role", "type", "level"} {
+			opts = append(opts, "--security-opt", "label="+field+":"+parts[i])
+		}
+	}
+
+	return opts, nil
+}
+
+// dockerRunArgs are the arguments to docker run the command in the image.
+// The buildkite-agent binary is mounted into the container, along with the
+// agent's API socket if it has one, so the command can still use it.
+// Env is passed by name only, so that docker reads the values from its own
+// env and they don't show up in the process list.
+func dockerRunArgs(sh *shell.Shell, image string, agentBinary string, securityOpts []string, cmd []string) []string {
+	jobID, _ := sh.Env.Get(`BUILDKITE_JOB_ID`)
+	wd := sh.Getwd()
+
+	args := []string{
+		"run", "--rm",
+		"--name", fmt.Sprintf("buildkite_%s_command", jobID),
+		"--volume", wd + ":" + wd,
+		"--volume", agentBinary + ":" + dockerAgentBinary + ":ro",
+	}
+
+	if endpoint, _ := sh.Env.Get(`BUILDKITE_AGENT_ENDPOINT`); strings.HasPrefix(endpoint, "unix://") {
+		socket := strings.TrimPrefix(endpoint, "unix://")
+		args = append(args, "--volume", socket+":"+socket)
+	}
+
+	args = append(args, securityOpts...)
+	args = append(args, "--workdir", wd)
+
+	env := sh.Env.ToMap()
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		if !dockerHostEnv[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		args = append(args, "--env", key)
+	}
+
+	args = append(args, image)
+	return append(args, cmd...)
+}
+
 func hasDeprecatedDockerIntegration(sh *shell.Shell) bool {
 	for _, k := range dockerEnv {
 		if sh.Env.Exists(k) {
@@ -0,0 +1,72 @@
+package bootstrap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/buildkite/agent/bootstrap/shell"
+	"github.com/buildkite/agent/env"
+)
+
+func TestDockerRunArgs(t *testing.T) {
+	sh, err := shell.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sh.Env = env.FromSlice([]string{
+		"BUILDKITE_JOB_ID=llamas",
+		"BUILDKITE_COMMAND=make test",
+		"BUILDKITE_AGENT_ENDPOINT=unix:///tmp/agent-socket123",
+		"HOME=/home/buildkite",
+		"PATH=/usr/bin",
+		"TEST_SECRET=hunter2",
+	})
+
+	wd := sh.Getwd()
+	securityOpts := []string{"--security-opt", "apparmor=buildkite-job"}
+	args := dockerRunArgs(sh, "golang:1.11", "/opt/buildkite/bin/buildkite-agent", securityOpts, []string{"/bin/sh", "-e", "-c", "make test"})
+
+	expected := []string{
+		"run", "--rm",
+		"--name", "buildkite_llamas_command",
+		"--volume", wd + ":" + wd,
+		"--volume", "/opt/buildkite/bin/buildkite-agent:/usr/local/bin/buildkite-agent:ro",
+		"--volume", "/tmp/agent-socket123:/tmp/agent-socket123",
+		"--security-opt", "apparmor=buildkite-job",
+		"--workdir", wd,
+		"--env", "BUILDKITE_AGENT_ENDPOINT",
+		"--env", "BUILDKITE_COMMAND",
+		"--env", "BUILDKITE_JOB_ID",
+		"--env", "TEST_SECRET",
+		"golang:1.11",
+		"/bin/sh", "-e", "-c", "make test",
+	}
+
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Expected %v, got %v", expected, args)
+	}
+}
+
+func TestDockerSecurityOpts(t *testing.T) {
+	opts, err := dockerSecurityOpts("buildkite-job", "system_u:system_r:container_t:s0:c1,c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"--security-opt", "apparmor=buildkite-job",
+		"--security-opt", "label=user:system_u",
+		"--security-opt", "label=role:system_r",
+		"--security-opt", "label=type:container_t",
+		"--security-opt", "label=level:s0:c1,c2",
+	}
+
+	if !reflect.DeepEqual(opts, expected) {
+		t.Fatalf("Expected %v, got %v", expected, opts)
+	}
+
+	if _, err := dockerSecurityOpts("", "container_t"); err == nil {
+		t.Fatal("Expected an error for an incomplete SELinux context")
+	}
+}
@@ -0,0 +1,104 @@
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/buildkite/agent/env"
+)
+
+// The variables that .env files can't set unless the agent allows them, as
+// they'd change how the agent, the bootstrap or every process it runs behaves
+var dotenvRefusedVars = []string{"PATH", "BASH_ENV", "ENV"}
+
+// The prefixes of variables that .env files can't set, for the same reason
+var dotenvRefusedPrefixes = []string{"BUILDKITE_", "LD_", "DYLD_"}
+
+// loadDotenvFiles adds the variables from the configured .env files in the
+// checkout to the environment. When a variable is in more than one file the
+// later file wins, but none of them override a variable that's already set,
+// so the step's env (and anything from the agent or hooks) always comes first.
+func (b *Bootstrap) loadDotenvFiles() error {
+	loaded := env.New()
+
+	for _, file := range strings.Split(b.DotenvFiles, ",") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(b.shell.Getwd(), path)
+		}
+
+		body, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			b.shell.Commentf("Skipping %s as it doesn't exist", file)
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		fileEnv, err := env.FromDotenv(string(body))
+		if err != nil {
+			return fmt.Errorf("Failed to parse %s: %v", file, err)
+		}
+
+		b.shell.Commentf("Loading environment variables from %s", file)
+		loaded = loaded.Merge(fileEnv)
+	}
+
+	var set, ignored, refused []string
+	for key, value := range loaded.ToMap() {
+		if b.dotenvVarRefused(key) {
+			refused = append(refused, key)
+			continue
+		}
+		if b.shell.Env.Exists(key) {
+			ignored = append(ignored, key)
+			continue
+		}
+		b.shell.Env.Set(key, value)
+		set = append(set, key)
+	}
+
+	if len(set) > 0 {
+		sort.Strings(set)
+		b.shell.Commentf("Set %s", strings.Join(set, ", "))
+	}
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		b.shell.Commentf("Ignored %s as they're already set", strings.Join(ignored, ", "))
+	}
+	if len(refused) > 0 {
+		sort.Strings(refused)
+		b.shell.Warningf("Refused to set %s from .env files, as the agent doesn't allow it", strings.Join(refused, ", "))
+	}
+
+	return nil
+}
+
+// dotenvVarRefused returns whether a .env file isn't allowed to set a variable
+func (b *Bootstrap) dotenvVarRefused(key string) bool {
+	for _, allowed := range b.DotenvAllowedVars {
+		if key == allowed {
+			return false
+		}
+	}
+	for _, refused := range dotenvRefusedVars {
+		if key == refused {
+			return true
+		}
+	}
+	for _, prefix := range dotenvRefusedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
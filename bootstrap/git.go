@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 
 	"github.com/buildkite/agent/bootstrap/shell"
@@ -18,6 +19,9 @@ func gitClone(sh *shell.Shell, gitCloneFlags, repository, dir string) error {
 	}
 
 	commandArgs := []string{"clone"}
+	if runtime.GOOS == "windows" {
+		commandArgs = append(commandArgs, "--config", "core.longpaths=true")
+	}
 	commandArgs = append(commandArgs, individualCloneFlags...)
 	commandArgs = append(commandArgs, "--", repository, ".")
 
@@ -28,6 +32,21 @@ func gitClone(sh *shell.Shell, gitCloneFlags, repository, dir string) error {
 	return nil
 }
 
+// gitEnableLongPaths lets git on Windows check out paths longer than 260
+// characters, which deep trees like node_modules often need. New clones
+// have it set by gitClone.
+func gitEnableLongPaths(sh *shell.Shell) error {
+	return sh.Run("git", "config", "core.longpaths", "true")
+}
+
+// gitHasCommit returns whether the commit is already in the repository. It
+// has to be given by its full SHA, as anything else, like HEAD or a branch
+// name, may have moved on since the repository was last fetched.
+func gitHasCommit(sh *shell.Shell, commit string) bool {
+	sha, err := sh.RunAndCapture("git", "rev-parse", "--verify", "--quiet", commit+"^{commit}")
+	return err == nil && strings.EqualFold(strings.TrimSpace(sha), commit)
+}
+
 func gitClean(sh *shell.Shell, gitCleanFlags string) error {
 	individualCleanFlags, err := shellwords.Split(gitCleanFlags)
 	if err != nil {
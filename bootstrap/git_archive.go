@@ -0,0 +1,278 @@
+package bootstrap
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitArchiveKind works out what's at a git archive URL from its extension,
+// which is either a git bundle or a tarball of a checkout
+func gitArchiveKind(archiveURL string) (string, error) {
+	p := archiveURL
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+
+	switch {
+	case strings.HasSuffix(p, ".bundle"):
+		return "bundle", nil
+	case strings.HasSuffix(p, ".tar.gz"), strings.HasSuffix(p, ".tgz"):
+		return "tar.gz", nil
+	case strings.HasSuffix(p, ".tar"):
+		return "tar", nil
+	}
+
+	return "", fmt.Errorf("Unknown type of git archive %q, expected a .bundle, .tar, .tar.gz or .tgz file", archiveURL)
+}
+
+// replaceCheckoutWithGitArchive clears out the checkout directory, including
+// anything a failed clone left behind, and creates it from the git archive
+func (b *Bootstrap) replaceCheckoutWithGitArchive(repository string) error {
+	if err := b.removeCheckoutDir(); err != nil {
+		return err
+	}
+	if err := b.createCheckoutDir(); err != nil {
+		return err
+	}
+
+	return b.checkoutFromGitArchive(repository)
+}
+
+// checkoutFromGitArchive creates the checkout from the git archive instead of
+// cloning the repository, then points origin back at the repository so that
+// later fetches only need what's changed since the archive was made
+func (b *Bootstrap) checkoutFromGitArchive(repository string) error {
+	kind, err := gitArchiveKind(b.GitArchiveURL)
+	if err != nil {
+		return err
+	}
+
+	b.shell.Commentf("Downloading git archive from %s", b.GitArchiveURL)
+
+	archive, err := ioutil.TempFile("", "buildkite-git-archive")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	if err := downloadGitArchive(b.GitArchiveURL, archive); err != nil {
+		return err
+	}
+
+	if kind == "bundle" {
+		if err := b.shell.Run("git", "clone", "--", archive.Name(), "."); err != nil {
+			return err
+		}
+	} else {
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		var r io.Reader = archive
+		if kind == "tar.gz" {
+			gz, err := gzip.NewReader(archive)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			r = gz
+		}
+
+		b.shell.Commentf("Extracting git archive")
+		if err := extractGitArchive(r, b.shell.Getwd()); err != nil {
+			return err
+		}
+	}
+
+	// Archives can have any origin, or none at all
+	if err := b.shell.Run("git", "remote", "set-url", "origin", repository); err != nil {
+		return b.shell.Run("git", "remote", "add", "origin", repository)
+	}
+
+	return nil
+}
+
+func downloadGitArchive(archiveURL string, w io.Writer) error {
+	client := &http.Client{Timeout: 30 * time.Minute}
+
+	resp, err := client.Get(archiveURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Failed to download git archive %s: %s", archiveURL, resp.Status)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// extractGitArchive extracts a tarball of a checkout into dir. The checkout
+// can be at the top of the tarball, or inside a single directory like the
+// ones git hosts create.
+func extractGitArchive(r io.Reader, dir string) error {
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".buildkite-git-archive")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// Links are checked against where they really point, so the temp dir
+	// has to be resolved too
+	root, err := filepath.EvalSymlinks(tmp)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "." {
+			continue
+		}
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("Git archive contains a path outside of the checkout %q", hdr.Name)
+		}
+
+		target := filepath.Join(root, filepath.FromSlash(name))
+
+		// Earlier links in the archive might point the parent somewhere
+		// else, so check where it really is before writing anything
+		parent, err := resolveExistingPath(filepath.Dir(target))
+		if err != nil {
+			return err
+		}
+		if !pathIsInside(root, parent) {
+			return fmt.Errorf("Git archive contains a path outside of the checkout %q", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0777); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+				return err
+			}
+			// Replace rather than write through a link from an earlier entry
+			if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&os.ModePerm)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// Links can't be used to write outside of the checkout either,
+			// which is checked from the real parent so chains of links
+			// can't escape
+			link := filepath.Join(parent, filepath.FromSlash(hdr.Linkname))
+			if path.IsAbs(hdr.Linkname) || filepath.IsAbs(hdr.Linkname) || !pathIsInside(root, link) {
+				return fmt.Errorf("Git archive contains a link outside of the checkout %q", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	checkout, err := gitArchiveRoot(root)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(checkout)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(checkout, entry.Name()), filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveExistingPath resolves the links in the longest part of p that exists,
+// the rest of it can't contain any links as it hasn't been created yet
+func resolveExistingPath(p string) (string, error) {
+	rest := ""
+	for {
+		if _, err := os.Lstat(p); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			break
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, rest), nil
+}
+
+// pathIsInside returns whether p is dir or somewhere beneath it
+func pathIsInside(dir, p string) bool {
+	p = filepath.Clean(p)
+	return p == dir || strings.HasPrefix(p, dir+string(os.PathSeparator))
+}
+
+// gitArchiveRoot finds the directory in an extracted archive that has the
+// .git directory in it
+func gitArchiveRoot(dir string) (string, error) {
+	if fileExists(filepath.Join(dir, ".git")) {
+		return dir, nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(entries) == 1 && entries[0].IsDir() {
+		if nested := filepath.Join(dir, entries[0].Name()); fileExists(filepath.Join(nested, ".git")) {
+			return nested, nil
+		}
+	}
+
+	return "", fmt.Errorf("Git archive doesn't contain a .git directory")
+}
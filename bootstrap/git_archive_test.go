@@ -0,0 +1,140 @@
+package bootstrap
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tarball(t *testing.T, files map[string]string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+func TestExtractingGitArchiveFromNestedDirectory(t *testing.T) {
+	t.Parallel()
+
+	parent, err := ioutil.TempDir("", "git-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	dir := filepath.Join(parent, "checkout")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	err = extractGitArchive(tarball(t, map[string]string{
+		"agent-main/.git/HEAD":  "ref: refs/heads/main\n",
+		"agent-main/README.md":  "# Agent\n",
+		"agent-main/src/llamas": "alpacas",
+	}), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := ioutil.ReadFile(filepath.Join(dir, ".git", "HEAD"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ref: refs/heads/main\n", string(head))
+
+	llamas, err := ioutil.ReadFile(filepath.Join(dir, "src", "llamas"))
+	assert.NoError(t, err)
+	assert.Equal(t, "alpacas", string(llamas))
+}
+
+func TestExtractingGitArchiveRejectsBadArchives(t *testing.T) {
+	t.Parallel()
+
+	for _, files := range []map[string]string{
+		{"../.git/HEAD": "ref: refs/heads/main\n"},
+		{"README.md": "# No git directory\n"},
+	} {
+		dir, err := ioutil.TempDir("", "git-archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		if err := extractGitArchive(tarball(t, files), dir); err == nil {
+			t.Errorf("Expected an error extracting %v", files)
+		}
+	}
+}
+
+func TestExtractingGitArchiveRejectsChainedLinks(t *testing.T) {
+	t.Parallel()
+
+	parent, err := ioutil.TempDir("", "git-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	dir := filepath.Join(parent, "checkout")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range []tar.Header{
+		{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+		{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "a/b/evil", Typeflag: tar.TypeReg, Mode: 0600},
+	} {
+		hdr := hdr
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractGitArchive(buf, dir); err == nil {
+		t.Fatal("Expected an error extracting chained links")
+	}
+	if _, err := os.Lstat(filepath.Join(parent, "evil")); err == nil {
+		t.Fatal("Expected nothing to be written outside of the checkout")
+	}
+}
+
+func TestGitArchiveKind(t *testing.T) {
+	t.Parallel()
+
+	for url, expected := range map[string]string{
+		"https://mirror.internal/agent.bundle":          "bundle",
+		"https://mirror.internal/agent.tar.gz?token=xx": "tar.gz",
+		"https://mirror.internal/agent.tgz":             "tar.gz",
+		"https://mirror.internal/agent.tar":             "tar",
+	} {
+		kind, err := gitArchiveKind(url)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, kind, url)
+	}
+
+	if _, err := gitArchiveKind("https://mirror.internal/agent.zip"); err == nil {
+		t.Fatal("Expected an error for a zip file")
+	}
+}
@@ -70,3 +70,34 @@ func TestStrippingGitHostAliases(t *testing.T) {
 	assert.Equal(t, "github.com", stripAliasesFromGitHost("github.com-alias1"))
 	assert.Equal(t, "blargh-no-alias.com", stripAliasesFromGitHost("blargh-no-alias.com"))
 }
+
+func TestRewritingGitURLs(t *testing.T) {
+	t.Parallel()
+
+	rewrites, err := ParseGitURLRewrites([]string{
+		`git@github.com:=https://git.internal/github/`,
+		`git@github.com:buildkite/=https://git.internal/buildkite/`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, `https://git.internal/buildkite/agent.git`, rewriteGitURL(`git@github.com:buildkite/agent.git`, rewrites))
+	assert.Equal(t, `https://git.internal/github/llamas/alpacas.git`, rewriteGitURL(`git@github.com:llamas/alpacas.git`, rewrites))
+	assert.Equal(t, `https://gitlab.com/buildkite/agent.git`, rewriteGitURL(`https://gitlab.com/buildkite/agent.git`, rewrites))
+
+	assert.Equal(t, []string{
+		"-c", "url.https://git.internal/github/.insteadOf=git@github.com:",
+		"-c", "url.https://git.internal/buildkite/.insteadOf=git@github.com:buildkite/",
+	}, gitURLRewriteArgs(rewrites))
+}
+
+func TestParsingInvalidGitURLRewrites(t *testing.T) {
+	t.Parallel()
+
+	for _, rule := range []string{`git@github.com:`, `=https://git.internal/`, `a=b=c`} {
+		if _, err := ParseGitURLRewrites([]string{rule}); err == nil {
+			t.Errorf("Expected an error parsing %q", rule)
+		}
+	}
+}
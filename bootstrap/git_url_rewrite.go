@@ -0,0 +1,67 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GitURLRewrite replaces the start of a repository URL, so that agents that
+// can't reach a repository's host can use a mirror of it instead
+type GitURLRewrite struct {
+	From string
+	To   string
+}
+
+// ParseGitURLRewrites parses rewrite rules in the form "from=to", e.g.
+// "git@github.com:org/=https://git.internal/org/"
+func ParseGitURLRewrites(rules []string) ([]GitURLRewrite, error) {
+	var rewrites []GitURLRewrite
+
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid git URL rewrite %q, expected it to be in the form \"from=to\"", rule)
+		}
+
+		// The mirror ends up in the key of git's url.<base>.insteadOf
+		// config, which can't contain an equals sign
+		if strings.Contains(parts[1], "=") {
+			return nil, fmt.Errorf("Invalid git URL rewrite %q, the URL it's rewritten to can't contain \"=\"", rule)
+		}
+
+		rewrites = append(rewrites, GitURLRewrite{From: parts[0], To: parts[1]})
+	}
+
+	return rewrites, nil
+}
+
+// rewriteGitURL applies the rewrite with the longest matching prefix to the
+// URL, which is the same way git picks between url.<base>.insteadOf rules
+func rewriteGitURL(url string, rewrites []GitURLRewrite) string {
+	var match *GitURLRewrite
+
+	for i, r := range rewrites {
+		if strings.HasPrefix(url, r.From) && (match == nil || len(r.From) > len(match.From)) {
+			match = &rewrites[i]
+		}
+	}
+
+	if match == nil {
+		return url
+	}
+
+	return match.To + strings.TrimPrefix(url, match.From)
+}
+
+// gitURLRewriteArgs returns the config arguments that make git apply the
+// rewrites itself. Git passes them on to the commands it runs, which is how
+// submodules that are cloned by "git submodule update" get rewritten too.
+func gitURLRewriteArgs(rewrites []GitURLRewrite) []string {
+	var args []string
+
+	for _, r := range rewrites {
+		args = append(args, "-c", fmt.Sprintf("url.%s.insteadOf=%s", r.To, r.From))
+	}
+
+	return args
+}
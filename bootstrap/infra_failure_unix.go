@@ -0,0 +1,15 @@
+// +build !windows
+
+package bootstrap
+
+import (
+	"os"
+	"syscall"
+)
+
+// openInheritedFD opens a pipe the agent gave us, like the one to explain
+// infrastructure failures on, making sure nothing we run inherits it
+func openInheritedFD(fd int, name string) (*os.File, error) {
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), name), nil
+}
@@ -0,0 +1,12 @@
+package bootstrap
+
+import (
+	"errors"
+	"os"
+)
+
+// openInheritedFD isn't supported on Windows, where processes can't inherit
+// extra files
+func openInheritedFD(fd int, name string) (*os.File, error) {
+	return nil, errors.New("Pipes from the agent aren't supported on Windows")
+}
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -173,6 +176,167 @@ func TestCheckingOutWithSSHKeyscan(t *testing.T) {
 	tester.RunAndCheck(t, env...)
 }
 
+func TestCheckingOutWithGitURLRewrites(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	git := tester.MustMock(t, "git")
+	git.IgnoreUnexpectedInvocations()
+
+	git.Expect("clone", "-v", "--", "https://git.internal/buildkite/agent.git", ".").
+		AndExitWith(0)
+
+	env := []string{
+		`BUILDKITE_REPO=git@github.com:buildkite/agent.git`,
+		`BUILDKITE_GIT_URL_REWRITES=git@github.com:buildkite/=https://git.internal/buildkite/`,
+	}
+
+	tester.RunAndCheck(t, env...)
+}
+
+func TestCheckingOutFromGitArchiveWhenCloneFails(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	commit, err := tester.Repo.RevParse("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit = strings.TrimSpace(commit)
+
+	dir, err := ioutil.TempDir("", "git-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, "repo.bundle")
+	if out, err := tester.Repo.Execute("bundle", "create", bundle, "--all"); err != nil {
+		t.Fatalf("Creating bundle failed: %s", out)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, bundle)
+	}))
+	defer server.Close()
+
+	unreachable := filepath.Join(dir, "unreachable.git")
+
+	env := []string{
+		"BUILDKITE_REPO=" + unreachable,
+		"BUILDKITE_COMMIT=" + commit,
+		"BUILDKITE_GIT_CLONE_FLAGS=-v",
+		"BUILDKITE_GIT_CLEAN_FLAGS=-fdq",
+		"BUILDKITE_GIT_ARCHIVE_URL=" + server.URL + "/repo.bundle",
+	}
+
+	git := tester.
+		MustMock(t, "git").
+		PassthroughToLocalCommand()
+
+	git.ExpectAll([][]interface{}{
+		{"clone", "-v", "--", unreachable, "."},
+		{"clone", "--", bintest.MatchAny(), "."},
+		{"remote", "set-url", "origin", unreachable},
+		{"clean", "-fdq"},
+		{"rev-parse", "--verify", "--quiet", commit + "^{commit}"},
+		{"checkout", "-f", commit},
+		{"clean", "-fdq"},
+		{"--no-pager", "show", "HEAD", "-s", "--format=fuller", "--no-color"},
+	})
+
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(1)
+	agent.
+		Expect("meta-data", "set", "buildkite:git:commit", bintest.MatchAny()).
+		AndExitWith(0)
+
+	tester.RunAndCheck(t, env...)
+}
+
+func TestCheckingOutFromGitArchiveWhenFetchFails(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	// Create an existing checkout, which the next commit isn't in
+	out, err := tester.Repo.Execute("clone", "-v", "--", tester.Repo.Path, tester.CheckoutDir())
+	if err != nil {
+		t.Fatalf("Clone failed with %s", out)
+	}
+	if out, err := tester.Repo.Execute("commit", "--allow-empty", "-m", "Another commit"); err != nil {
+		t.Fatalf("Commit failed with %s", out)
+	}
+
+	commit, err := tester.Repo.RevParse("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit = strings.TrimSpace(commit)
+
+	dir, err := ioutil.TempDir("", "git-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, "repo.bundle")
+	if out, err := tester.Repo.Execute("bundle", "create", bundle, "--all"); err != nil {
+		t.Fatalf("Creating bundle failed: %s", out)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, bundle)
+	}))
+	defer server.Close()
+
+	env := []string{
+		"BUILDKITE_REPO=" + filepath.Join(dir, "unreachable.git"),
+		"BUILDKITE_COMMIT=" + commit,
+		"BUILDKITE_GIT_ARCHIVE_URL=" + server.URL + "/repo.bundle",
+	}
+
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(1)
+	agent.
+		Expect("meta-data", "set", "buildkite:git:commit", bintest.MatchAny()).
+		AndExitWith(0)
+
+	tester.RunAndCheck(t, env...)
+
+	if !strings.Contains(tester.Output, "falling back to the git archive") {
+		t.Fatalf("Expected the output to mention the git archive, got: %s", tester.Output)
+	}
+
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = tester.CheckoutDir()
+	head, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(head)) != commit {
+		t.Fatalf("Expected %s to be checked out, got %s", commit, head)
+	}
+}
+
 func TestCheckingOutWithoutSSHKeyscan(t *testing.T) {
 	t.Parallel()
 
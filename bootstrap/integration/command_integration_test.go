@@ -1,6 +1,11 @@
 package integration
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/buildkite/bintest"
@@ -36,3 +41,268 @@ func TestPreExitHooksRunsAfterCommandFails(t *testing.T) {
 
 	tester.CheckMocks(t)
 }
+
+func TestCommandIsRetriedForRetryExitCodes(t *testing.T) {
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(0)
+
+	env := []string{
+		`BUILDKITE_COMMAND=[ "$BUILDKITE_COMMAND_ATTEMPT" = 3 ] || exit 75`,
+		"BUILDKITE_COMMAND_RETRY_EXIT_CODES=1,75",
+		"BUILDKITE_COMMAND_RETRY_COUNT=2",
+	}
+
+	if err = tester.Run(t, env...); err != nil {
+		t.Fatal(err)
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestCommandIsNotRetriedForOtherExitCodes(t *testing.T) {
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(0)
+
+	env := []string{
+		`BUILDKITE_COMMAND=[ "$BUILDKITE_COMMAND_ATTEMPT" = 2 ] || exit 3`,
+		"BUILDKITE_COMMAND_RETRY_EXIT_CODES=75",
+	}
+
+	if err = tester.Run(t, env...); err == nil {
+		t.Fatal("Expected the bootstrap to fail")
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestCommandLoadsDotenvFilesBelowStepEnv(t *testing.T) {
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	files := map[string]string{
+		".env":    "APP_NAME=llamas\nFROM_STEP=dotenv\nOVERRIDDEN=first\n",
+		".env.ci": "OVERRIDDEN=second\n",
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(tester.Repo.Path, name), []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := tester.Repo.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tester.Repo.Commit("Add .env files"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(0)
+
+	env := []string{
+		`BUILDKITE_COMMAND=[ "$APP_NAME/$FROM_STEP/$OVERRIDDEN" = "llamas/step/second" ]`,
+		"BUILDKITE_DOTENV_FILES=.env,.env.ci,.env.missing",
+		"FROM_STEP=step",
+	}
+
+	if err = tester.Run(t, env...); err != nil {
+		t.Fatal(err)
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestCommandRefusesDangerousDotenvVarsAfterGlobalHooks(t *testing.T) {
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	dotenv := "APP_NAME=llamas\nPATH=/tmp/evil\nBASH_ENV=/tmp/evil.sh\nLD_PRELOAD=/tmp/evil.so\nBUILDKITE_REPO=evil\nLD_LIBRARY_PATH=/opt/lib\n"
+	if err := ioutil.WriteFile(filepath.Join(tester.Repo.Path, ".env"), []byte(dotenv), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := tester.Repo.Add(".env"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tester.Repo.Commit("Add .env file"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(0)
+
+	// The agent's own hook runs before the .env files are loaded
+	tester.ExpectGlobalHook("pre-command").Once().AndCallFunc(func(c *bintest.Call) {
+		if appName := c.GetEnv("APP_NAME"); appName != "" {
+			fmt.Fprintf(c.Stderr, "Expected APP_NAME to not be set yet, got %q\n", appName)
+			c.Exit(1)
+			return
+		}
+		c.Exit(0)
+	})
+
+	env := []string{
+		`BUILDKITE_COMMAND=[ "$APP_NAME" = "llamas" ] && [ "$PATH" != "/tmp/evil" ] && [ -z "$BASH_ENV" ] && [ -z "$LD_PRELOAD" ] && [ "$LD_LIBRARY_PATH" = "/opt/lib" ]`,
+		"BUILDKITE_DOTENV_FILES=.env",
+		"BUILDKITE_DOTENV_ALLOWED_VARS=LD_LIBRARY_PATH",
+	}
+
+	if err = tester.Run(t, env...); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(tester.Output, "Refused to set BASH_ENV, BUILDKITE_REPO, LD_PRELOAD, PATH") {
+		t.Fatalf("Expected the refused variables in the output, got: %s", tester.Output)
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestDebugSessionStartsWhenCommandFails(t *testing.T) {
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(0)
+
+	// Without authorized keys, only the read-only session is shown
+	tmate := tester.MustMock(t, "tmate")
+	tmate.Expect("-S", bintest.MatchAny(), "new-session", "-d").Once().AndExitWith(0)
+	tmate.Expect("-S", bintest.MatchAny(), "wait", "tmate-ready").Once().AndExitWith(0)
+	tmate.Expect("-S", bintest.MatchAny(), "display", "-p", "#{tmate_ssh_ro}").Once().
+		AndWriteToStdout("ssh ro-llama@nyc1.tmate.io\n").AndExitWith(0)
+
+	// The session has already gone by the time we check
+	tmate.Expect("-S", bintest.MatchAny(), "display", "-p", "#{tmate_num_clients}").Once().AndExitWith(1)
+	tmate.Expect("-S", bintest.MatchAny(), "kill-server").Once().AndExitWith(0)
+
+	tester.ExpectGlobalHook("post-command").Once().AndCallFunc(func(c *bintest.Call) {
+		if exitStatus := c.GetEnv(`BUILDKITE_COMMAND_EXIT_STATUS`); exitStatus != "1" {
+			t.Errorf("Expected an exit status of 1, got %v", exitStatus)
+		}
+		c.Exit(0)
+	})
+
+	err = tester.Run(t,
+		"BUILDKITE_COMMAND=false",
+		"BUILDKITE_DEBUG_SESSION=true",
+		"BUILDKITE_DEBUG_SESSION_TIMEOUT=5",
+	)
+	if err == nil {
+		t.Fatal("Expected the bootstrap to fail")
+	}
+
+	if !strings.Contains(tester.Output, "ssh ro-llama@nyc1.tmate.io") {
+		t.Fatalf("Expected the output to include how to connect, got %s", tester.Output)
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestDebugSessionIsRestrictedToAuthorizedKeys(t *testing.T) {
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	keysFile, err := ioutil.TempFile("", "authorized_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keysFile.Name())
+	keysFile.WriteString("ssh-ed25519 AAAA llama@example.com\n")
+	keysFile.Close()
+
+	keys, err := filepath.EvalSymlinks(keysFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(0)
+
+	tmate := tester.MustMock(t, "tmate")
+	tmate.Expect("-S", bintest.MatchAny(), "-a", keys, "new-session", "-d").Once().AndExitWith(0)
+	tmate.Expect("-S", bintest.MatchAny(), "wait", "tmate-ready").Once().AndExitWith(0)
+	tmate.Expect("-S", bintest.MatchAny(), "display", "-p", "#{tmate_ssh}").Once().
+		AndWriteToStdout("ssh llama@nyc1.tmate.io\n").AndExitWith(0)
+	tmate.Expect("-S", bintest.MatchAny(), "display", "-p", "#{tmate_num_clients}").Once().AndExitWith(1)
+	tmate.Expect("-S", bintest.MatchAny(), "kill-server").Once().AndExitWith(0)
+
+	err = tester.Run(t,
+		"BUILDKITE_COMMAND=false",
+		"BUILDKITE_DEBUG_SESSION=true",
+		"BUILDKITE_DEBUG_SESSION_TIMEOUT=5",
+		"BUILDKITE_DEBUG_SESSION_AUTHORIZED_KEYS="+keys,
+	)
+	if err == nil {
+		t.Fatal("Expected the bootstrap to fail")
+	}
+
+	if !strings.Contains(tester.Output, "ssh llama@nyc1.tmate.io") {
+		t.Fatalf("Expected the output to include how to connect, got %s", tester.Output)
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestDebugSessionIsNotStartedUnlessTheAgentAllowsIt(t *testing.T) {
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(0)
+
+	tmate := tester.MustMock(t, "tmate")
+	tmate.Expect().WithAnyArguments().NotCalled()
+
+	if err = tester.Run(t, "BUILDKITE_COMMAND=false", "BUILDKITE_DEBUG_SESSION=true"); err == nil {
+		t.Fatal("Expected the bootstrap to fail")
+	}
+
+	tester.CheckMocks(t)
+}
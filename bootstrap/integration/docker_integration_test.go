@@ -2,6 +2,7 @@ package integration
 
 import (
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/buildkite/bintest"
@@ -271,3 +272,35 @@ func expectCommandHooks(exitStatus string, t *testing.T, tester *BootstrapTester
 	tester.ExpectGlobalHook("pre-exit").Once().AndCallFunc(preExitFunc)
 	tester.ExpectLocalHook("pre-exit").Once().AndCallFunc(preExitFunc)
 }
+
+func TestRunningCommandInDockerImageIsRefusedInCommandSandbox(t *testing.T) {
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MustMock(t, "buildkite-agent")
+	agent.
+		Expect("meta-data", "exists", "buildkite:git:commit").
+		AndExitWith(0)
+
+	docker := tester.MustMock(t, "docker")
+	docker.Expect().NotCalled()
+
+	env := []string{
+		"BUILDKITE_DOCKER_IMAGE=golang:1.11",
+		"BUILDKITE_COMMAND_SANDBOX=true",
+	}
+
+	if err = tester.Run(t, env...); err == nil {
+		t.Fatal("Expected the bootstrap to fail")
+	}
+
+	if !strings.Contains(tester.Output, "command sandbox") {
+		t.Fatalf("Expected the output to explain why, got: %s", tester.Output)
+	}
+
+	tester.CheckMocks(t)
+}
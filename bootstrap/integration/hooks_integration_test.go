@@ -130,6 +130,51 @@ func TestCheckingOutFiresCorrectHooks(t *testing.T) {
 	tester.RunAndCheck(t)
 }
 
+func TestLocalHooksAreRefusedWhenDisabled(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("post-checkout").Once()
+	tester.ExpectLocalHook("post-checkout").NotCalled()
+	tester.ExpectGlobalHook("pre-command").NotCalled()
+	tester.ExpectLocalHook("pre-command").NotCalled()
+
+	if err = tester.Run(t, "BUILDKITE_LOCAL_HOOKS_ENABLED=false"); err == nil {
+		t.Fatal("Expected the bootstrap to fail")
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestLocalHooksAreSkippedWhenSkippingRepositoryHooks(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("post-checkout").Once()
+	tester.ExpectLocalHook("post-checkout").NotCalled()
+	tester.ExpectGlobalHook("pre-command").Once()
+	tester.ExpectLocalHook("pre-command").NotCalled()
+	tester.ExpectLocalHook("command").NotCalled()
+	tester.ExpectGlobalHook("command").Once().AndExitWith(0)
+	tester.ExpectLocalHook("post-command").NotCalled()
+
+	tester.RunAndCheck(t, "BUILDKITE_SKIP_REPOSITORY_HOOKS=true")
+
+	if !strings.Contains(tester.Output, "as this agent skips hooks from the repository") {
+		t.Fatalf("Expected a warning about the skipped hooks, got: %s", tester.Output)
+	}
+}
+
 func TestReplacingCheckoutHook(t *testing.T) {
 	t.Parallel()
 
@@ -74,6 +74,51 @@ func TestRunningPlugins(t *testing.T) {
 	tester.RunAndCheck(t, env...)
 }
 
+func TestRunningPluginsFromEnvironmentHook(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Not implemented for windows yet")
+	}
+
+	tester, err := NewBootstrapTester()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tester.Close()
+
+	pluginMock := tester.MustMock(t, "my-plugin")
+
+	p := createTestPlugin(t, map[string][]string{
+		"environment": []string{
+			"#!/bin/bash",
+			pluginMock.Path + " testing",
+		},
+	})
+
+	json, err := p.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plugins come from tooling on the agent rather than the pipeline
+	var script = []string{
+		"#!/bin/bash",
+		"export BUILDKITE_PLUGINS='" + json + "'",
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tester.HooksDir, "environment"),
+		[]byte(strings.Join(script, "\n")), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	pluginMock.Expect("testing").Once().AndExitWith(0)
+
+	tester.ExpectGlobalHook("command").Once().AndExitWith(0)
+
+	tester.RunAndCheck(t)
+}
+
 func TestExitCodesPropagateOutFromPlugins(t *testing.T) {
 	t.Parallel()
 
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -54,6 +55,10 @@ type Shell struct {
 
 	// The context for the shell
 	ctx context.Context
+
+	// The command that's running, which user signals are passed on to
+	running   *exec.Cmd
+	runningMu sync.Mutex
 }
 
 // New returns a new Shell
@@ -63,13 +68,49 @@ func New() (*Shell, error) {
 		return nil, errors.Wrapf(err, "Failed to find current working directory")
 	}
 
-	return &Shell{
+	s := &Shell{
 		Logger: StderrLogger,
 		Env:    env.FromSlice(os.Environ()),
 		Writer: os.Stdout,
 		wd:     wd,
 		ctx:    context.Background(),
-	}, nil
+	}
+	s.forwardUserSignals()
+
+	return s, nil
+}
+
+// forwardUserSignals passes user signals on to whichever command is running
+// when they arrive, and ignores them when nothing is
+func (s *Shell) forwardUserSignals() {
+	if len(userSignals) == 0 {
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, userSignals...)
+
+	go func() {
+		for sig := range signals {
+			s.runningMu.Lock()
+			cmd := s.running
+			s.runningMu.Unlock()
+
+			if cmd == nil {
+				continue
+			}
+			if err := signalProcess(cmd, sig); err != nil {
+				s.Errorf("Error passing signal to child process: %v", err)
+			}
+		}
+	}()
+}
+
+// Sets the command that user signals are passed on to
+func (s *Shell) setRunning(cmd *exec.Cmd) {
+	s.runningMu.Lock()
+	s.running = cmd
+	s.runningMu.Unlock()
 }
 
 // New returns a new Shell with provided context.Context
@@ -306,11 +347,7 @@ type executeFlags struct {
 
 func (s *Shell) executeCommand(cmd *exec.Cmd, w io.Writer, flags executeFlags) error {
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt,
-		syscall.SIGHUP,
-		syscall.SIGTERM,
-		syscall.SIGINT,
-		syscall.SIGQUIT)
+	signal.Notify(signals, forwardedSignals...)
 	defer signal.Stop(signals)
 
 	go func() {
@@ -322,6 +359,9 @@ func (s *Shell) executeCommand(cmd *exec.Cmd, w io.Writer, flags executeFlags) e
 		}
 	}()
 
+	// Only pass user signals on while the command is running
+	defer s.setRunning(nil)
+
 	cmdStr := process.FormatCommand(cmd.Path, cmd.Args[1:])
 
 	if s.Debug {
@@ -336,6 +376,7 @@ func (s *Shell) executeCommand(cmd *exec.Cmd, w io.Writer, flags executeFlags) e
 		if err != nil {
 			return fmt.Errorf("Error starting PTY: %v", err)
 		}
+		s.setRunning(cmd)
 
 		// Copy the pty to our buffer. This will block until it EOF's
 		// or something breaks.
@@ -372,6 +413,7 @@ func (s *Shell) executeCommand(cmd *exec.Cmd, w io.Writer, flags executeFlags) e
 		if err := cmd.Start(); err != nil {
 			return errors.Wrapf(err, "Error starting `%s`", cmdStr)
 		}
+		s.setRunning(cmd)
 	}
 
 	if err := cmd.Wait(); err != nil {
@@ -402,6 +444,17 @@ func GetExitCode(err error) int {
 	return 1
 }
 
+// GetExitSignal extracts the signal that killed the process from an error,
+// where the process was killed by one and the platform supports it
+func GetExitSignal(err error) (syscall.Signal, bool) {
+	if cause, ok := errors.Cause(err).(*exec.ExitError); ok {
+		if status, ok := cause.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return status.Signal(), true
+		}
+	}
+	return 0, false
+}
+
 func IsExitError(err error) bool {
 	switch errors.Cause(err).(type) {
 	case *ExitError:
@@ -9,14 +9,34 @@ import (
 	"syscall"
 )
 
+// The signals that are passed on to the commands the shell runs
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+	syscall.SIGHUP,
+	syscall.SIGTERM,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+}
+
+// Signals that are only meant for the job's commands. They're handled for
+// the shell's whole life, since they'd kill it between commands otherwise.
+var userSignals = []os.Signal{
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
 func signalProcess(cmd *exec.Cmd, sig os.Signal) error {
 	if cmd.Process == nil {
 		return errors.New("Process doesn't exist yet")
 	}
 
-	// If possible, send to the process group (linux/darwin/bsd)
+	// If possible, send to the process group (linux/darwin/bsd). Commands
+	// that aren't run in a PTY don't have their own group, so fall back to
+	// the process itself.
 	if ssig, ok := sig.(syscall.Signal); ok {
-		return syscall.Kill(-cmd.Process.Pid, ssig)
+		if err := syscall.Kill(-cmd.Process.Pid, ssig); err != syscall.ESRCH {
+			return err
+		}
 	}
 
 	return cmd.Process.Signal(sig)
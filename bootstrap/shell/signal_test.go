@@ -0,0 +1,44 @@
+// +build !windows
+
+package shell_test
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/bootstrap/shell"
+)
+
+func TestUserSignalsArePassedToTheRunningCommand(t *testing.T) {
+	sh, err := shell.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	sh.Logger = shell.DiscardLogger
+	sh.Writer = out
+
+	// Without a command running, the signal is ignored rather than
+	// killing the shell (and the test)
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	}()
+
+	if err := sh.Run("/bin/sh", "-c", "trap 'echo got usr1; kill $!; exit 0' USR1; sleep 5 & wait"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "got usr1") {
+		t.Fatalf("Expected the command to get the signal, got %q", out.String())
+	}
+}
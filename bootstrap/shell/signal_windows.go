@@ -4,8 +4,21 @@ import (
 	"errors"
 	"os"
 	"os/exec"
+	"syscall"
 )
 
+// The signals that are passed on to the commands the shell runs
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+	syscall.SIGHUP,
+	syscall.SIGTERM,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+}
+
+// Windows doesn't have user-defined signals
+var userSignals = []os.Signal{}
+
 func signalProcess(cmd *exec.Cmd, sig os.Signal) error {
 	if cmd.Process != nil {
 		return errors.New("Process doesn't exist yet")
@@ -8,8 +8,14 @@ import (
 	"time"
 
 	"github.com/buildkite/agent/agent"
+	"github.com/buildkite/agent/bootstrap"
 	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/events"
+	"github.com/buildkite/agent/isolation"
 	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/process"
+	"github.com/buildkite/agent/sandbox"
+	"github.com/buildkite/agent/tracing"
 	"github.com/buildkite/shellwords"
 	"github.com/urfave/cli"
 )
@@ -39,13 +45,18 @@ Example:
 
 type AgentStartConfig struct {
 	Config                    string   `cli:"config"`
-	Token                     string   `cli:"token" validate:"required"`
+	Token                     string   `cli:"token" from-file:"TokenFromFile" validate:"required"`
+	TokenFromFile             string   `cli:"token-from-file" normalize:"filepath"`
 	Name                      string   `cli:"name"`
 	Priority                  string   `cli:"priority"`
 	DisconnectAfterJob        bool     `cli:"disconnect-after-job"`
 	DisconnectAfterJobTimeout int      `cli:"disconnect-after-job-timeout"`
+	Preflight                 bool     `cli:"preflight"`
+	HandoverFile              string   `cli:"handover-file" normalize:"filepath"`
+	Replace                   bool     `cli:"replace"`
 	BootstrapScript           string   `cli:"bootstrap-script" normalize:"commandpath"`
 	BuildPath                 string   `cli:"build-path" normalize:"filepath" validate:"required"`
+	BuildPathTemplate         string   `cli:"build-path-template"`
 	HooksPath                 string   `cli:"hooks-path" normalize:"filepath"`
 	PluginsPath               string   `cli:"plugins-path" normalize:"filepath"`
 	Shell                     string   `cli:"shell"`
@@ -54,19 +65,68 @@ type AgentStartConfig struct {
 	TagsFromEC2Tags           bool     `cli:"tags-from-ec2-tags"`
 	TagsFromGCP               bool     `cli:"tags-from-gcp"`
 	TagsFromHost              bool     `cli:"tags-from-host"`
+	TagsFromEnvPrefix         string   `cli:"tags-from-env-prefix"`
+	MaxConcurrentTags         []string `cli:"max-concurrent-tag" normalize:"list"`
 	WaitForEC2TagsTimeout     string   `cli:"wait-for-ec2-tags-timeout"`
 	GitCloneFlags             string   `cli:"git-clone-flags"`
 	GitCleanFlags             string   `cli:"git-clean-flags"`
+	GitURLRewrites            []string `cli:"git-url-rewrite" normalize:"list"`
+	DotenvAllowedVars         []string `cli:"dotenv-allowed-vars" normalize:"list"`
 	NoGitSubmodules           bool     `cli:"no-git-submodules"`
 	NoColor                   bool     `cli:"no-color"`
+	ColorTheme                string   `cli:"color-theme"`
+	LogFormat                 string   `cli:"log-format"`
+	LogLevels                 string   `cli:"log-levels"`
+	LogTimestampFormat        string   `cli:"log-timestamp-format"`
+	LogTimezone               string   `cli:"log-timezone"`
+	LogDedupWindow            string   `cli:"log-dedup-window"`
 	NoSSHKeyscan              bool     `cli:"no-ssh-keyscan"`
 	NoCommandEval             bool     `cli:"no-command-eval"`
 	NoLocalHooks              bool     `cli:"no-local-hooks"`
+	SkipRepositoryHooks       bool     `cli:"skip-repository-hooks"`
 	NoPlugins                 bool     `cli:"no-plugins"`
 	NoPluginValidation        bool     `cli:"no-plugin-validation"`
 	NoPTY                     bool     `cli:"no-pty"`
+	WindowsPTY                bool     `cli:"windows-pty"`
 	NoHTTP2                   bool     `cli:"no-http2"`
 	TimestampLines            bool     `cli:"timestamp-lines"`
+	TimestampLinesFormat      string   `cli:"timestamp-lines-format"`
+	TimestampLinesTimezone    string   `cli:"timestamp-lines-timezone"`
+	Syslog                    string   `cli:"syslog"`
+	EventLog                  bool     `cli:"event-log"`
+	EventSinks                []string `cli:"event-sink" normalize:"list"`
+	TracingOTLPEndpoint       string   `cli:"tracing-otlp-endpoint"`
+	SecretScanning            string   `cli:"secret-scanning"`
+	JobScopedTokens           bool     `cli:"job-scoped-tokens"`
+	SeccompProfile            string   `cli:"seccomp-profile"`
+	AppArmorProfile           string   `cli:"apparmor-profile"`
+	SELinuxContext            string   `cli:"selinux-context"`
+	CommandSandbox            bool     `cli:"command-sandbox"`
+	AuditLogPath              string   `cli:"audit-log-path" normalize:"filepath"`
+	AuditLogUpload            bool     `cli:"audit-log-upload"`
+	InfraFailureExitCodes     []string `cli:"infra-failure-exit-codes" normalize:"list"`
+	InfraFailureRetries       int      `cli:"infra-failure-retries"`
+	CrashArtifactPaths        string   `cli:"crash-artifact-paths"`
+	DebugSessionTimeout       int      `cli:"debug-session-timeout"`
+	DebugSessionKeys          string   `cli:"debug-session-authorized-keys" normalize:"filepath"`
+	CollapseGroupsAfter       int      `cli:"collapse-groups-after"`
+	MaxLogSizeBytes           int      `cli:"max-log-size-bytes"`
+	MaxArtifactBytesPerJob    int      `cli:"max-artifact-bytes-per-job"`
+	JobCPUWeight              int      `cli:"job-cpu-weight"`
+	JobMemoryMax              int      `cli:"job-memory-max"`
+	JobCgroupParent           string   `cli:"job-cgroup-parent"`
+	CancelSignals             string   `cli:"cancel-signals"`
+	JobEnvAllowlist           []string `cli:"job-env-allowlist" normalize:"list"`
+	JobEnvDenylist            []string `cli:"job-env-denylist" normalize:"list"`
+	JobUser                   string   `cli:"job-user"`
+	JobIdleTimeout            string   `cli:"job-idle-timeout"`
+	Executor                  string   `cli:"executor"`
+	KubernetesNamespace       string   `cli:"kubernetes-namespace"`
+	KubernetesImage           string   `cli:"kubernetes-image"`
+	KubernetesAgentImage      string   `cli:"kubernetes-agent-image"`
+	Isolation                 string   `cli:"isolation"`
+	IsolationOptions          []string `cli:"isolation-option" normalize:"list"`
+	IsolationAPIProxyAddress  string   `cli:"isolation-api-proxy-address"`
 	Endpoint                  string   `cli:"endpoint" validate:"required"`
 	Debug                     bool     `cli:"debug"`
 	DebugHTTP                 bool     `cli:"debug-http"`
@@ -123,17 +183,18 @@ var AgentStartCommand = cli.Command{
 	Usage:       "Starts a Buildkite agent",
 	Description: StartDescription,
 	Flags: []cli.Flag{
+		ConfigFlag,
 		cli.StringFlag{
-			Name:   "config",
+			Name:   "token",
 			Value:  "",
-			Usage:  "Path to a configuration file",
-			EnvVar: "BUILDKITE_AGENT_CONFIG",
+			Usage:  "Your account agent token, or where to fetch it from (\"aws-ssm:<name>\", \"aws-secrets-manager:<id>\", \"gcp-secret-manager:projects/<project>/secrets/<secret>\" or \"vault:<path>#<field>\")",
+			EnvVar: "BUILDKITE_AGENT_TOKEN",
 		},
 		cli.StringFlag{
-			Name:   "token",
+			Name:   "token-from-file",
 			Value:  "",
-			Usage:  "Your account agent token",
-			EnvVar: "BUILDKITE_AGENT_TOKEN",
+			Usage:  "A file to read your account agent token from, instead of passing it with --token",
+			EnvVar: "BUILDKITE_AGENT_TOKEN_FILE",
 		},
 		cli.StringFlag{
 			Name:   "name",
@@ -158,6 +219,22 @@ var AgentStartCommand = cli.Command{
 			Usage:  "When --disconnect-after-job is specified, the number of seconds to wait for a job before shutting down",
 			EnvVar: "BUILDKITE_AGENT_DISCONNECT_AFTER_JOB_TIMEOUT",
 		},
+		cli.StringFlag{
+			Name:   "handover-file",
+			Value:  "",
+			Usage:  "A file the agent keeps its PID and name in, so that a new agent started with --replace can take over from it",
+			EnvVar: "BUILDKITE_AGENT_HANDOVER_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "replace",
+			Usage:  "Ask the agent in the --handover-file to stop once it has finished its job, wait for it, and then register with its name. Useful for upgrading agents without interrupting jobs.",
+			EnvVar: "BUILDKITE_AGENT_REPLACE",
+		},
+		cli.BoolFlag{
+			Name:   "preflight",
+			Usage:  "Check that git, ssh, docker, DNS, the clock and the build path are all working before registering, and exit if they aren't",
+			EnvVar: "BUILDKITE_AGENT_PREFLIGHT",
+		},
 		cli.StringFlag{
 			Name:   "shell",
 			Value:  DefaultShell(),
@@ -190,6 +267,18 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Include the host's Google Cloud meta-data as tags (instance-id, machine-type, preemptible, project-id, region, and zone)",
 			EnvVar: "BUILDKITE_AGENT_TAGS_FROM_GCP",
 		},
+		cli.StringFlag{
+			Name:   "tags-from-env-prefix",
+			Value:  "",
+			Usage:  "Include environment variables that start with this prefix as tags, with the rest of the name lowercased as the key (e.g. \"BUILDKITE_AGENT_TAG_\")",
+			EnvVar: "BUILDKITE_AGENT_TAGS_FROM_ENV_PREFIX",
+		},
+		cli.StringSliceFlag{
+			Name:   "max-concurrent-tag",
+			Value:  &cli.StringSlice{},
+			Usage:  "Limit how many jobs agents on this host with a tag can run at once, in the form \"tag:max\" (e.g. \"resource=gpu:1\"). Agents share limits when they have the same build path",
+			EnvVar: "BUILDKITE_AGENT_MAX_CONCURRENT_TAGS",
+		},
 		cli.DurationFlag{
 			Name:   "wait-for-ec2-tags-timeout",
 			Usage:  "The amount of time to wait for tags from EC2 before proceeding",
@@ -208,6 +297,18 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Flags to pass to \"git clean\" command",
 			EnvVar: "BUILDKITE_GIT_CLEAN_FLAGS",
 		},
+		cli.StringSliceFlag{
+			Name:   "git-url-rewrite",
+			Value:  &cli.StringSlice{},
+			Usage:  "Rewrite the start of repository URLs during checkout, in the form \"from=to\" (e.g. \"git@github.com:org/=https://git.internal/org/\")",
+			EnvVar: "BUILDKITE_GIT_URL_REWRITES",
+		},
+		cli.StringSliceFlag{
+			Name:   "dotenv-allowed-vars",
+			Value:  &cli.StringSlice{},
+			Usage:  "Variables that a repository's .env files can set even though they're normally refused, like PATH or LD_PRELOAD",
+			EnvVar: "BUILDKITE_DOTENV_ALLOWED_VARS",
+		},
 		cli.StringFlag{
 			Name:   "bootstrap-script",
 			Value:  "",
@@ -220,6 +321,12 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Path to where the builds will run from",
 			EnvVar: "BUILDKITE_BUILD_PATH",
 		},
+		cli.StringFlag{
+			Name:   "build-path-template",
+			Value:  bootstrap.DefaultBuildPathTemplate,
+			Usage:  "The layout of checkouts within the build path, using {agent}, {organization}, {pipeline}, {queue} and {hash}. Leaving out {agent} shares checkouts between the agents on a machine, and their jobs take turns with each checkout.",
+			EnvVar: "BUILDKITE_BUILD_PATH_TEMPLATE",
+		},
 		cli.StringFlag{
 			Name:   "hooks-path",
 			Value:  "",
@@ -237,11 +344,28 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Prepend timestamps on each line of output.",
 			EnvVar: "BUILDKITE_TIMESTAMP_LINES",
 		},
+		cli.StringFlag{
+			Name:   "timestamp-lines-format",
+			Value:  "",
+			Usage:  "How the time is shown at the start of timestamped lines, either \"rfc3339\" (the default), \"rfc3339nano\", \"epoch-millis\", \"relative\" to when the job started, or a Go time layout",
+			EnvVar: "BUILDKITE_TIMESTAMP_LINES_FORMAT",
+		},
+		cli.StringFlag{
+			Name:   "timestamp-lines-timezone",
+			Value:  "",
+			Usage:  "The timezone of the time at the start of timestamped lines, either \"utc\" (the default), \"local\" or a name like \"Europe/Berlin\"",
+			EnvVar: "BUILDKITE_TIMESTAMP_LINES_TIMEZONE",
+		},
 		cli.BoolFlag{
 			Name:   "no-pty",
 			Usage:  "Do not run jobs within a pseudo terminal",
 			EnvVar: "BUILDKITE_NO_PTY",
 		},
+		cli.BoolFlag{
+			Name:   "windows-pty",
+			Usage:  "Run jobs within a pseudo console on Windows, which needs Windows 10 1809 or Server 2019",
+			EnvVar: "BUILDKITE_WINDOWS_PTY",
+		},
 		cli.BoolFlag{
 			Name:   "no-ssh-keyscan",
 			Usage:  "Don't automatically run ssh-keyscan before checkout",
@@ -267,6 +391,11 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Don't allow local hooks to be run from checked out repositories",
 			EnvVar: "BUILDKITE_NO_LOCAL_HOOKS",
 		},
+		cli.BoolFlag{
+			Name:   "skip-repository-hooks",
+			Usage:  "Skip the hooks that checked out repositories have in .buildkite/hooks with a warning, rather than running them",
+			EnvVar: "BUILDKITE_SKIP_REPOSITORY_HOOKS",
+		},
 		cli.BoolFlag{
 			Name:   "no-git-submodules",
 			Usage:  "Don't automatically checkout git submodules",
@@ -277,9 +406,221 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Disable HTTP2 when communicating with the Agent API.",
 			EnvVar: "BUILDKITE_NO_HTTP2",
 		},
+		cli.StringFlag{
+			Name:   "syslog",
+			Value:  "",
+			Usage:  "Also send agent logs to syslog, either \"local\" or a remote address like \"udp://logs.example.com:514\"",
+			EnvVar: "BUILDKITE_AGENT_SYSLOG",
+		},
+		cli.BoolFlag{
+			Name:   "event-log",
+			Usage:  "Also send agent logs to the Windows Event Log",
+			EnvVar: "BUILDKITE_AGENT_EVENT_LOG",
+		},
+		cli.StringSliceFlag{
+			Name:   "event-sink",
+			Value:  &cli.StringSlice{},
+			Usage:  "Where to send job lifecycle events, either a http(s):// webhook URL, a file:// path or an exec: command",
+			EnvVar: "BUILDKITE_AGENT_EVENT_SINKS",
+		},
+		cli.StringFlag{
+			Name:   "tracing-otlp-endpoint",
+			Value:  "",
+			Usage:  "Send traces of each job to an OpenTelemetry collector at this OTLP/HTTP endpoint, e.g. \"http://localhost:4318\"",
+			EnvVar: "BUILDKITE_TRACING_OTLP_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "secret-scanning",
+			Value:  "off",
+			Usage:  "What to do when something like an AWS key, GitHub token or private key appears in job output, either \"off\", \"warn\", \"mask\" or \"fail\"",
+			EnvVar: "BUILDKITE_SECRET_SCANNING",
+		},
+		cli.BoolFlag{
+			Name:   "job-scoped-tokens",
+			Usage:  "Give each job a short-lived token that only works for that job and is revoked when it finishes, instead of the agent's access token. Jobs can only get the status of their own build with it. Isolated jobs always get one, and it can't be used with the kubernetes executor.",
+			EnvVar: "BUILDKITE_JOB_SCOPED_TOKENS",
+		},
+		cli.StringFlag{
+			Name:   "seccomp-profile",
+			Value:  "",
+			Usage:  "Run jobs under a seccomp filter that denies the syscalls listed in this file, one per line, or \"default\" to deny ones like ptrace and mount (Linux only)",
+			EnvVar: "BUILDKITE_SECCOMP_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "apparmor-profile",
+			Value:  "",
+			Usage:  "Run jobs under this AppArmor profile, using aa-exec",
+			EnvVar: "BUILDKITE_APPARMOR_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "selinux-context",
+			Value:  "",
+			Usage:  "Run jobs in this SELinux security context, using runcon",
+			EnvVar: "BUILDKITE_SELINUX_CONTEXT",
+		},
+		cli.BoolFlag{
+			Name:   "command-sandbox",
+			Usage:  "Run the command phase in new mount, PID and network namespaces, with empty tmpfs directories for /tmp, /var/tmp and /dev/shm (Linux only, requires unshare from util-linux). The command reaches the Agent API through a job-scoped socket, and a --seccomp-profile has to allow mount and unshare.",
+			EnvVar: "BUILDKITE_COMMAND_SANDBOX",
+		},
+		cli.StringFlag{
+			Name:   "audit-log-path",
+			Value:  "",
+			Usage:  "Directory where the agent records every hook and command each job runs in <job-id>.jsonl. Jobs can only add to their records through a pipe to the agent, except on Windows where they append to the file themselves.",
+			EnvVar: "BUILDKITE_AUDIT_LOG_PATH",
+		},
+		cli.BoolFlag{
+			Name:   "audit-log-upload",
+			Usage:  "Upload each job's audit log as an artifact once it finishes",
+			EnvVar: "BUILDKITE_AUDIT_LOG_UPLOAD",
+		},
+		cli.StringSliceFlag{
+			Name:   "infra-failure-exit-codes",
+			Value:  &cli.StringSlice{},
+			Usage:  "Exit codes that mean a job failed because of the agent's infrastructure rather than the job, as well as failed checkouts and an unreachable Docker daemon",
+			EnvVar: "BUILDKITE_INFRA_FAILURE_EXIT_CODES",
+		},
+		cli.IntFlag{
+			Name:   "infra-failure-retries",
+			Value:  0,
+			Usage:  "How many times to run a job again when it fails because of the agent's infrastructure",
+			EnvVar: "BUILDKITE_INFRA_FAILURE_RETRIES",
+		},
+		cli.StringFlag{
+			Name:   "crash-artifact-paths",
+			Value:  "",
+			Usage:  "Paths to upload as artifacts, tagged with the signal, when a job's command is killed by a signal like SIGSEGV, such as \"core\" for core dumps",
+			EnvVar: "BUILDKITE_CRASH_ARTIFACT_PATHS",
+		},
+		cli.IntFlag{
+			Name:   "debug-session-timeout",
+			Value:  0,
+			Usage:  "Let jobs that set BUILDKITE_DEBUG_SESSION=true keep running for up to this many minutes after their command fails, with a tmate session that's shown in the job log for debugging. 0 doesn't allow debug sessions.",
+			EnvVar: "BUILDKITE_DEBUG_SESSION_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "debug-session-authorized-keys",
+			Value:  "",
+			Usage:  "An authorized_keys file with the SSH keys that can use debug sessions. Without it, debug sessions are read-only, as anyone who can read the job log can connect.",
+			EnvVar: "BUILDKITE_DEBUG_SESSION_AUTHORIZED_KEYS",
+		},
+		cli.IntFlag{
+			Name:   "collapse-groups-after",
+			Value:  0,
+			Usage:  "Hide the lines of a log group after this many, leaving a marker in the log and uploading the full output as an artifact. 0 shows every line.",
+			EnvVar: "BUILDKITE_COLLAPSE_GROUPS_AFTER",
+		},
+		cli.IntFlag{
+			Name:   "max-log-size-bytes",
+			Value:  0,
+			Usage:  "Stop sending a job's log after this many bytes, uploading the full log as an artifact when the job finishes. 0 sends the whole log.",
+			EnvVar: "BUILDKITE_MAX_LOG_SIZE_BYTES",
+		},
+		cli.IntFlag{
+			Name:   "max-artifact-bytes-per-job",
+			Value:  0,
+			Usage:  "Fail artifact uploads that would take the artifacts of a job over this many bytes in total. 0 means there's no limit.",
+			EnvVar: "BUILDKITE_MAX_ARTIFACT_BYTES_PER_JOB",
+		},
+		cli.IntFlag{
+			Name:   "job-cpu-weight",
+			Value:  0,
+			Usage:  "The share of CPU time each job gets when the host is busy, from 1 to 10000, where other processes usually have 100. Needs cgroups v2 on Linux. 0 means there's no limit.",
+			EnvVar: "BUILDKITE_JOB_CPU_WEIGHT",
+		},
+		cli.IntFlag{
+			Name:   "job-memory-max",
+			Value:  0,
+			Usage:  "The most memory in bytes each job can use before it's OOM killed. Needs cgroups v2 on Linux. 0 means there's no limit.",
+			EnvVar: "BUILDKITE_JOB_MEMORY_MAX",
+		},
+		cli.StringFlag{
+			Name:   "job-cgroup-parent",
+			Value:  "",
+			Usage:  "The cgroup that limited jobs get their own cgroups in, relative to /sys/fs/cgroup. It's needed for --job-cpu-weight and --job-memory-max, and has to be delegated to the agent with no processes of its own, so it can't be the agent's cgroup.",
+			EnvVar: "BUILDKITE_JOB_CGROUP_PARENT",
+		},
+		cli.StringFlag{
+			Name:   "cancel-signals",
+			Value:  "",
+			Usage:  "The signals sent to a job's process when it's cancelled, with how long to wait after each for it to exit before a SIGKILL, e.g. \"SIGINT:10s,SIGTERM:10s\". Defaults to \"SIGTERM:10s\".",
+			EnvVar: "BUILDKITE_CANCEL_SIGNALS",
+		},
+		cli.StringSliceFlag{
+			Name:   "job-env-allowlist",
+			Value:  &cli.StringSlice{},
+			Usage:  "Only pass these of the agent's own environment variables on to jobs, which can have * wildcards, e.g. \"PATH,HOME,LANG,SSH_AUTH_SOCK\". By default jobs get all of them.",
+			EnvVar: "BUILDKITE_JOB_ENV_ALLOWLIST",
+		},
+		cli.StringSliceFlag{
+			Name:   "job-env-denylist",
+			Value:  &cli.StringSlice{},
+			Usage:  "Never pass these of the agent's own environment variables on to jobs, which can have * wildcards, e.g. \"AWS_*,VAULT_TOKEN\"",
+			EnvVar: "BUILDKITE_JOB_ENV_DENYLIST",
+		},
+		cli.StringFlag{
+			Name:   "job-user",
+			Value:  "",
+			Usage:  "Run jobs as this user instead of the agent's, given as a name or ID with an optional group after a colon, e.g. \"buildkite\" or \"1000:1000\". The agent needs to be running as root. Not supported on Windows.",
+			EnvVar: "BUILDKITE_JOB_USER",
+		},
+		cli.DurationFlag{
+			Name:   "job-idle-timeout",
+			Usage:  "Warn that a job appears to be hung once it hasn't written any output for this long, e.g. \"30m\". 0 never does.",
+			EnvVar: "BUILDKITE_JOB_IDLE_TIMEOUT",
+			Value:  0,
+		},
+		cli.StringFlag{
+			Name:   "executor",
+			Value:  "local",
+			Usage:  "How jobs are run, either \"local\" to run them on this machine, or \"kubernetes\" to run each one in its own pod using kubectl",
+			EnvVar: "BUILDKITE_EXECUTOR",
+		},
+		cli.StringFlag{
+			Name:   "kubernetes-namespace",
+			Value:  "default",
+			Usage:  "The namespace to create job pods in with the kubernetes executor",
+			EnvVar: "BUILDKITE_KUBERNETES_NAMESPACE",
+		},
+		cli.StringFlag{
+			Name:   "kubernetes-image",
+			Value:  "buildkite/agent:3",
+			Usage:  "The image to run jobs in with the kubernetes executor, when their step doesn't set BUILDKITE_KUBERNETES_IMAGE",
+			EnvVar: "BUILDKITE_KUBERNETES_DEFAULT_IMAGE",
+		},
+		cli.StringFlag{
+			Name:   "kubernetes-agent-image",
+			Value:  "buildkite/agent:3",
+			Usage:  "An image with the agent at /usr/local/bin/buildkite-agent, which is copied into each job's pod",
+			EnvVar: "BUILDKITE_KUBERNETES_AGENT_IMAGE",
+		},
+		cli.StringFlag{
+			Name:   "isolation",
+			Value:  "",
+			Usage:  "Run each job with an isolation backend, like \"firecracker\" to boot a microVM for it, for agents that run untrusted code",
+			EnvVar: "BUILDKITE_ISOLATION",
+		},
+		cli.StringSliceFlag{
+			Name:   "isolation-option",
+			Value:  &cli.StringSlice{},
+			Usage:  "An option for the isolation backend, as key=value, e.g. kernel=/var/lib/buildkite/vmlinux",
+			EnvVar: "BUILDKITE_ISOLATION_OPTIONS",
+		},
+		cli.StringFlag{
+			Name:   "isolation-api-proxy-address",
+			Value:  "",
+			Usage:  "An address on this host that isolated jobs can reach, like the tap device's, e.g. 172.16.0.1:0. Each job reaches the Agent API through a proxy listening on it, with a token that only works for the job.",
+			EnvVar: "BUILDKITE_ISOLATION_API_PROXY_ADDRESS",
+		},
 		ExperimentsFlag,
 		EndpointFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
+		LogDedupWindowFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 		/* Deprecated flags which will be removed in v4 */
@@ -330,8 +671,42 @@ var AgentStartCommand = cli.Command{
 		// Setup the any global configuration options
 		HandleGlobalFlags(cfg)
 
-		// Force some settings if on Windows (these aren't supported yet)
-		if runtime.GOOS == "windows" {
+		// The token can refer to a secret manager rather than being the
+		// token itself, e.g. aws-ssm:/buildkite/agent-token
+		token, err := agent.FetchSecret(cfg.Token)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+		cfg.Token = token
+
+		// Send logs to any OS-native logging backends as well
+		if cfg.Syslog != "" {
+			backend, err := logger.NewSyslogBackend(cfg.Syslog, "buildkite-agent")
+			if err != nil {
+				logger.Fatal("%s", err)
+			}
+			logger.AddBackend(backend)
+		}
+
+		if cfg.EventLog {
+			backend, err := logger.NewEventLogBackend("buildkite-agent")
+			if err != nil {
+				logger.Fatal("%s", err)
+			}
+			logger.AddBackend(backend)
+		}
+
+		defer logger.CloseBackends()
+
+		// Jobs on Windows only run in a pseudo console when it's asked for,
+		// as it changes how their output looks. Older versions of Windows
+		// don't have one at all.
+		if runtime.GOOS == "windows" && !cfg.WindowsPTY {
+			cfg.NoPTY = true
+		} else if !process.PTYSupported() {
+			if cfg.WindowsPTY {
+				logger.Warn("This version of Windows doesn't have a pseudo console, so jobs will run without a PTY")
+			}
 			cfg.NoPTY = true
 		}
 
@@ -366,11 +741,162 @@ var AgentStartCommand = cli.Command{
 			cfg.Shell = DefaultShell()
 		}
 
+		if cfg.Replace && cfg.HandoverFile == "" {
+			logger.Fatal("Replacing an agent needs the --handover-file it was started with")
+		}
+
 		// Make sure the DisconnectAfterJobTimeout value is correct
 		if cfg.DisconnectAfterJob && cfg.DisconnectAfterJobTimeout < 120 {
 			logger.Fatal("The timeout for `disconnect-after-job` must be at least 120 seconds")
 		}
 
+		// Make sure we know what to do when secrets are found in job output
+		if cfg.SecretScanning == "" {
+			cfg.SecretScanning = agent.SecretScanningOff
+		}
+		if err := agent.ValidateSecretScanning(cfg.SecretScanning); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Make sure the seccomp profile can be read before any jobs need it
+		if cfg.SeccompProfile != "" {
+			denied, err := sandbox.ReadSeccompProfile(cfg.SeccompProfile)
+			if err != nil {
+				logger.Fatal("%s", err)
+			}
+
+			// The command sandbox needs syscalls that profiles usually deny
+			if cfg.CommandSandbox {
+				if err := sandbox.CheckNamespaceSyscalls(denied); err != nil {
+					logger.Fatal("%s", err)
+				}
+			}
+		}
+
+		if cfg.CommandSandbox && runtime.GOOS != "linux" {
+			logger.Fatal("The command sandbox is only supported on Linux")
+		}
+
+		if cfg.JobUser != "" && runtime.GOOS == "windows" {
+			logger.Fatal("Running jobs as another user isn't supported on Windows")
+		}
+
+		if (cfg.JobCPUWeight > 0 || cfg.JobMemoryMax > 0) && runtime.GOOS != "linux" {
+			logger.Fatal("Job CPU and memory limits are only supported on Linux")
+		}
+
+		if cfg.JobCPUWeight < 0 || cfg.JobCPUWeight > 10000 {
+			logger.Fatal("The job CPU weight must be between 1 and 10000")
+		}
+
+		if (cfg.JobCPUWeight > 0 || cfg.JobMemoryMax > 0) && cfg.JobCgroupParent == "" {
+			logger.Fatal("Job CPU and memory limits need --job-cgroup-parent, a cgroup without any processes of its own to create each job's cgroup in")
+		}
+
+		if err := agent.ValidateExecutor(cfg.Executor); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Pods can't reach the proxy that hands out job-scoped tokens, so
+		// they'd quietly get the agent's own token instead
+		if cfg.Executor == agent.ExecutorKubernetes && cfg.JobScopedTokens {
+			logger.Fatal("--job-scoped-tokens can't be used with the kubernetes executor, as pods can't reach the agent's API proxy")
+		}
+
+		// These confine the process on the agent's host that starts the
+		// job's pod, rather than the job itself
+		if cfg.Executor == agent.ExecutorKubernetes {
+			for _, option := range []struct {
+				name string
+				set  bool
+			}{
+				{"apparmor-profile", cfg.AppArmorProfile != ""},
+				{"selinux-context", cfg.SELinuxContext != ""},
+				{"job-user", cfg.JobUser != ""},
+				{"job-cgroup-parent", cfg.JobCgroupParent != ""},
+			} {
+				if option.set {
+					logger.Warn("--%s can't be applied to jobs in Kubernetes pods, so they'll run without it", option.name)
+				}
+			}
+		}
+
+		if err := bootstrap.ValidateBuildPathTemplate(cfg.BuildPathTemplate); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		if _, err := bootstrap.ParseGitURLRewrites(cfg.GitURLRewrites); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		var concurrencyLimits []agent.ConcurrencyLimit
+		for _, s := range cfg.MaxConcurrentTags {
+			limit, err := agent.ParseConcurrencyLimit(s)
+			if err != nil {
+				logger.Fatal("%s", err)
+			}
+			concurrencyLimits = append(concurrencyLimits, limit)
+		}
+
+		// Make sure the isolation backend can be created before any jobs need it
+		if cfg.Isolation != "" {
+			if cfg.Executor != agent.ExecutorLocal {
+				logger.Fatal("Isolation backends can only be used with the local executor")
+			}
+
+			// Isolated jobs can't reach the proxy's unix socket, and
+			// mustn't be given the agent's own access token instead
+			if cfg.IsolationAPIProxyAddress == "" {
+				logger.Fatal("Isolation backends need an --isolation-api-proxy-address for jobs to reach the Agent API with")
+			}
+
+			options, err := isolation.ParseOptions(cfg.IsolationOptions)
+			if err != nil {
+				logger.Fatal("%s", err)
+			}
+
+			if _, err := isolation.New(cfg.Isolation, options); err != nil {
+				logger.Fatal("%s", err)
+			}
+		}
+
+		// Make sure there's somewhere for jobs to write their audit logs
+		if cfg.AuditLogPath != "" {
+			if err := os.MkdirAll(cfg.AuditLogPath, 0700); err != nil {
+				logger.Fatal("Failed to create audit log directory: %v", err)
+			}
+		}
+
+		infraFailureExitCodes, err := agent.ParseExitCodes(cfg.InfraFailureExitCodes)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		var killSequence []process.KillStep
+		if cfg.CancelSignals != "" {
+			killSequence, err = process.ParseKillSequence(cfg.CancelSignals)
+			if err != nil {
+				logger.Fatal("Invalid cancel signals: %v", err)
+			}
+		}
+
+		if err := process.ValidateTimestampFormat(cfg.TimestampLinesFormat); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		timestampLinesLocation, err := process.ParseTimestampLocation(cfg.TimestampLinesTimezone)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		var jobIdleTimeout time.Duration
+		if t := cfg.JobIdleTimeout; t != "" {
+			jobIdleTimeout, err = time.ParseDuration(t)
+			if err != nil {
+				logger.Fatal("Failed to parse job idle timeout: %v", err)
+			}
+		}
+
 		var ec2TagTimeout time.Duration
 		if t := cfg.WaitForEC2TagsTimeout; t != "" {
 			var err error
@@ -380,6 +906,28 @@ var AgentStartCommand = cli.Command{
 			}
 		}
 
+		// Setup where job lifecycle events are sent
+		eventSinks, err := events.ParseSinks(cfg.EventSinks)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		eventBus := events.NewBus(eventSinks...)
+		defer eventBus.Close()
+
+		// Setup where traces of each job are sent
+		var tracer *tracing.Tracer
+		if cfg.TracingOTLPEndpoint != "" {
+			tracer = tracing.NewTracer(tracing.NewOTLPExporter(cfg.TracingOTLPEndpoint, "buildkite-agent"))
+		}
+
+		// Make sure the host can run jobs before it's given any
+		if cfg.Preflight {
+			if err := runPreflight(cfg); err != nil {
+				logger.Fatal("%s", err)
+			}
+		}
+
 		// Setup the agent
 		pool := agent.AgentPool{
 			Token:                 cfg.Token,
@@ -390,27 +938,73 @@ var AgentStartCommand = cli.Command{
 			TagsFromEC2Tags:       cfg.TagsFromEC2Tags,
 			TagsFromGCP:           cfg.TagsFromGCP,
 			TagsFromHost:          cfg.TagsFromHost,
+			TagsFromEnvPrefix:     cfg.TagsFromEnvPrefix,
 			WaitForEC2TagsTimeout: ec2TagTimeout,
 			Endpoint:              cfg.Endpoint,
 			DisableHTTP2:          cfg.NoHTTP2,
+			Events:                eventBus,
+			Tracer:                tracer,
+			HandoverFile:          cfg.HandoverFile,
+			Replace:               cfg.Replace,
 			AgentConfiguration: &agent.AgentConfiguration{
 				BootstrapScript:           cfg.BootstrapScript,
 				BuildPath:                 cfg.BuildPath,
+				BuildPathTemplate:         cfg.BuildPathTemplate,
 				HooksPath:                 cfg.HooksPath,
 				PluginsPath:               cfg.PluginsPath,
 				GitCloneFlags:             cfg.GitCloneFlags,
 				GitCleanFlags:             cfg.GitCleanFlags,
+				GitURLRewrites:            cfg.GitURLRewrites,
+				DotenvAllowedVars:         cfg.DotenvAllowedVars,
 				GitSubmodules:             !cfg.NoGitSubmodules,
 				SSHKeyscan:                !cfg.NoSSHKeyscan,
 				CommandEval:               !cfg.NoCommandEval,
 				PluginsEnabled:            !cfg.NoPlugins,
 				PluginValidation:          !cfg.NoPluginValidation,
 				LocalHooksEnabled:         !cfg.NoLocalHooks,
+				SkipRepositoryHooks:       cfg.SkipRepositoryHooks,
 				RunInPty:                  !cfg.NoPTY,
+				WindowsPTY:                cfg.WindowsPTY,
 				TimestampLines:            cfg.TimestampLines,
+				TimestampLinesFormat:      cfg.TimestampLinesFormat,
+				TimestampLinesLocation:    timestampLinesLocation,
 				DisconnectAfterJob:        cfg.DisconnectAfterJob,
 				DisconnectAfterJobTimeout: cfg.DisconnectAfterJobTimeout,
 				Shell:                     cfg.Shell,
+				EventSinks:                cfg.EventSinks,
+				TracingOTLPEndpoint:       cfg.TracingOTLPEndpoint,
+				SecretScanning:            cfg.SecretScanning,
+				JobScopedTokens:           cfg.JobScopedTokens,
+				SeccompProfile:            cfg.SeccompProfile,
+				AppArmorProfile:           cfg.AppArmorProfile,
+				SELinuxContext:            cfg.SELinuxContext,
+				CommandSandbox:            cfg.CommandSandbox,
+				AuditLogPath:              cfg.AuditLogPath,
+				AuditLogUpload:            cfg.AuditLogUpload,
+				InfraFailureExitCodes:     infraFailureExitCodes,
+				InfraFailureRetries:       cfg.InfraFailureRetries,
+				CrashArtifactPaths:        cfg.CrashArtifactPaths,
+				DebugSessionTimeout:       cfg.DebugSessionTimeout,
+				DebugSessionKeys:          cfg.DebugSessionKeys,
+				CollapseGroupsAfter:       cfg.CollapseGroupsAfter,
+				MaxLogSizeBytes:           cfg.MaxLogSizeBytes,
+				MaxArtifactBytesPerJob:    cfg.MaxArtifactBytesPerJob,
+				JobCPUWeight:              cfg.JobCPUWeight,
+				JobMemoryMax:              int64(cfg.JobMemoryMax),
+				JobCgroupParent:           cfg.JobCgroupParent,
+				KillSequence:              killSequence,
+				JobEnvAllowlist:           cfg.JobEnvAllowlist,
+				JobEnvDenylist:            cfg.JobEnvDenylist,
+				JobUser:                   cfg.JobUser,
+				JobIdleTimeout:            jobIdleTimeout,
+				Executor:                  cfg.Executor,
+				KubernetesNamespace:       cfg.KubernetesNamespace,
+				KubernetesImage:           cfg.KubernetesImage,
+				KubernetesAgentImage:      cfg.KubernetesAgentImage,
+				Isolation:                 cfg.Isolation,
+				IsolationOptions:          cfg.IsolationOptions,
+				IsolationAPIProxyAddress:  cfg.IsolationAPIProxyAddress,
+				ConcurrencyLimits:         concurrencyLimits,
 			},
 		}
 
@@ -1,12 +1,13 @@
 package clicommand
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
-	"github.com/buildkite/agent/stdin"
-
 	"github.com/buildkite/agent/agent"
 	"github.com/buildkite/agent/api"
 	"github.com/buildkite/agent/cliconfig"
@@ -42,24 +43,41 @@ Description:
    You can also update just the style of an existing annotation by omitting the
    body entirely and providing a new style value.
 
+   Styles other than success, info, warning and error are rejected. If your
+   organization themes its build pages, use --class to add your own CSS classes
+   to the annotation as well.
+
+   If the body is output from a command that uses ANSI colors, such as a test
+   runner's summary, use --terminal to convert it into styled HTML so it shows
+   up in the annotation the same way it does in the job log.
+
 Example:
 
    $ buildkite-agent annotate "All tests passed! :rocket:"
    $ cat annotation.md | buildkite-agent annotate --style "warning"
    $ buildkite-agent annotate --style "success" --context "junit"
-   $ ./script/dynamic_annotation_generator | buildkite-agent annotate --style "success"`
+   $ ./script/dynamic_annotation_generator | buildkite-agent annotate --style "success"
+   $ ./script/test 2>&1 | tail -n 20 | buildkite-agent annotate --terminal --style "error"`
 
 type AnnotateConfig struct {
-	Body             string `cli:"arg:0" label:"annotation body"`
-	Style            string `cli:"style"`
-	Context          string `cli:"context"`
-	Append           bool   `cli:"append"`
-	Job              string `cli:"job" validate:"required"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
-	Endpoint         string `cli:"endpoint" validate:"required"`
-	NoColor          bool   `cli:"no-color"`
-	Debug            bool   `cli:"debug"`
-	DebugHTTP        bool   `cli:"debug-http"`
+	Body                     string `cli:"arg:0" label:"annotation body"`
+	Style                    string `cli:"style"`
+	Class                    string `cli:"class"`
+	Context                  string `cli:"context"`
+	Append                   bool   `cli:"append"`
+	Terminal                 bool   `cli:"terminal"`
+	Job                      string `cli:"job" validate:"required"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
 }
 
 var AnnotateCommand = cli.Command{
@@ -77,11 +95,21 @@ var AnnotateCommand = cli.Command{
 			Usage:  "The style of the annotation (`success`, `info`, `warning` or `error`)",
 			EnvVar: "BUILDKITE_ANNOTATION_STYLE",
 		},
+		cli.StringFlag{
+			Name:   "class",
+			Usage:  "Extra CSS classes for the annotation, separated by spaces, for build pages with custom themes",
+			EnvVar: "BUILDKITE_ANNOTATION_CLASS",
+		},
 		cli.BoolFlag{
 			Name:   "append",
 			Usage:  "Append to the body of an existing annotation",
 			EnvVar: "BUILDKITE_ANNOTATION_APPEND",
 		},
+		cli.BoolFlag{
+			Name:   "terminal",
+			Usage:  "Convert ANSI colors and styles in the body into HTML",
+			EnvVar: "BUILDKITE_ANNOTATION_TERMINAL",
+		},
 		cli.StringFlag{
 			Name:   "job",
 			Value:  "",
@@ -89,8 +117,16 @@ var AnnotateCommand = cli.Command{
 			EnvVar: "BUILDKITE_JOB_ID",
 		},
 		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
 		EndpointFlag,
+		StdinFlag,
+		ConfigFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 	},
@@ -107,12 +143,21 @@ var AnnotateCommand = cli.Command{
 		// Setup the any global configuration options
 		HandleGlobalFlags(cfg)
 
+		// Check these before reading the body, which could be a
+		// long-running command piped in
+		if err := validateAnnotationStyle(cfg.Style); err != nil {
+			logger.Fatal("%s", err)
+		}
+		if err := validateAnnotationClass(cfg.Class); err != nil {
+			logger.Fatal("%s", err)
+		}
+
 		var body string
 		var err error
 
 		if cfg.Body != "" {
 			body = cfg.Body
-		} else if stdin.IsReadable() {
+		} else if shouldReadStdin(c) {
 			logger.Info("Reading annotation body from STDIN")
 
 			// Actually read the file from STDIN
@@ -124,16 +169,26 @@ var AnnotateCommand = cli.Command{
 			body = string(stdin[:])
 		}
 
+		// Omitting the body only updates the style, so there's
+		// nothing to convert
+		if cfg.Terminal && body != "" {
+			body = agent.TerminalToHTML(body)
+		}
+
 		// Create the API client
 		client := agent.APIClient{
 			Endpoint: cfg.Endpoint,
 			Token:    cfg.AgentAccessToken,
 		}.Create()
 
-		// Create the annotation we'll send to the Buildkite API
+		// Create the annotation we'll send to the Buildkite API. The
+		// UUID stays the same for each attempt, so a retry after a
+		// timeout doesn't append to the annotation twice.
 		annotation := &api.Annotation{
+			UUID:    api.NewUUID(),
 			Body:    body,
 			Style:   cfg.Style,
+			Class:   cfg.Class,
 			Context: cfg.Context,
 			Append:  cfg.Append,
 		}
@@ -141,21 +196,15 @@ var AnnotateCommand = cli.Command{
 		// Retry the annotation a few times before giving up
 		err = retry.Do(func(s *retry.Stats) error {
 			// Attempt ot create the annotation
-			resp, err := client.Annotations.Create(cfg.Job, annotation)
-
-			// Don't bother retrying if the response was one of these statuses
-			if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 400) {
-				s.Break()
-				return err
-			}
+			_, err := client.Annotations.Create(cfg.Job, annotation)
 
 			// Show the unexpected error
-			if err != nil {
+			if err != nil && !api.IsPermanentError(err) {
 				logger.Warn("%s (%s)", err, s)
 			}
 
 			return err
-		}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, Jitter: true})
+		}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, Jitter: true, IsPermanent: api.IsPermanentError})
 
 		// Show a fatal error if we gave up trying to create the annotation
 		if err != nil {
@@ -165,3 +214,49 @@ var AnnotateCommand = cli.Command{
 		logger.Info("Successfully annotated build")
 	},
 }
+
+var annotationStyles = []string{"success", "info", "warning", "error"}
+
+// Styles people often try, and the one they probably meant
+var annotationStyleSuggestions = map[string]string{
+	"ok":      "success",
+	"pass":    "success",
+	"passed":  "success",
+	"notice":  "info",
+	"warn":    "warning",
+	"fail":    "error",
+	"failed":  "error",
+	"failure": "error",
+	"danger":  "error",
+}
+
+func validateAnnotationStyle(style string) error {
+	if style == "" {
+		return nil
+	}
+
+	for _, s := range annotationStyles {
+		if style == s {
+			return nil
+		}
+	}
+
+	if suggestion, ok := annotationStyleSuggestions[strings.ToLower(style)]; ok {
+		return fmt.Errorf("Invalid annotation style %q, did you mean %q?", style, suggestion)
+	}
+
+	return fmt.Errorf("Invalid annotation style %q, it must be one of %s. Use --class for custom styles.",
+		style, strings.Join(annotationStyles, ", "))
+}
+
+var annotationClassRegex = regexp.MustCompile(`\A[a-zA-Z_-][a-zA-Z0-9_-]*\z`)
+
+func validateAnnotationClass(class string) error {
+	for _, c := range strings.Fields(class) {
+		if !annotationClassRegex.MatchString(c) {
+			return fmt.Errorf("Invalid annotation class %q, classes can only contain letters, numbers, - and _", c)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package clicommand
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAnnotationStyle(t *testing.T) {
+	for _, style := range []string{"", "success", "info", "warning", "error"} {
+		if err := validateAnnotationStyle(style); err != nil {
+			t.Errorf("validateAnnotationStyle(%q) = %v", style, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		style    string
+		expected string
+	}{
+		{"warn", `did you mean "warning"?`},
+		{"Failed", `did you mean "error"?`},
+		{"passed", `did you mean "success"?`},
+		{"SUCCESS", "it must be one of success, info, warning, error"},
+		{"llamas", "Use --class for custom styles"},
+	} {
+		err := validateAnnotationStyle(tc.style)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("validateAnnotationStyle(%q) = %v, expected an error containing %q", tc.style, err, tc.expected)
+		}
+	}
+}
+
+func TestValidateAnnotationClass(t *testing.T) {
+	for _, class := range []string{"", "llamas", "team-llamas alpacas_2", "  padded  ", "_private", "-dashed"} {
+		if err := validateAnnotationClass(class); err != nil {
+			t.Errorf("validateAnnotationClass(%q) = %v", class, err)
+		}
+	}
+
+	for _, class := range []string{"2fast", "llamas alpacas!", "<script>", `"quoted"`, "a.b"} {
+		if err := validateAnnotationClass(class); err == nil {
+			t.Errorf("Expected an error validating class %q", class)
+		}
+	}
+}
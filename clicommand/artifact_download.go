@@ -1,6 +1,10 @@
 package clicommand
 
 import (
+	"fmt"
+	"os"
+	"strconv"
+
 	"github.com/buildkite/agent/agent"
 	"github.com/buildkite/agent/cliconfig"
 	"github.com/buildkite/agent/logger"
@@ -31,18 +35,49 @@ Example:
 
    $ buildkite-agent artifact download "pkg/*.tar.gz" . --step "tests" --build xxx
 
-   You can also use the step's jobs id (provided by the environment variable $BUILDKITE_JOB_ID)`
+   You can also use the step's jobs id (provided by the environment variable $BUILDKITE_JOB_ID)
+
+   Downloaded files and directories get their permissions from the umask of
+   the agent. In shared build directories you can set them explicitly instead,
+   or give files the permissions they had when they were uploaded:
+
+   $ buildkite-agent artifact download "pkg/*" . --dir-mode 0775 --umask 0002
+   $ buildkite-agent artifact download "bin/*" . --restore-modes
+
+   Artifacts uploaded to a store that's compatible with S3, like MinIO or
+   Ceph RGW, are downloaded from it with the same settings:
+
+   $ buildkite-agent artifact download "pkg/*.tar.gz" . --s3-endpoint http://minio.internal:9000 --s3-force-path-style
+
+   Artifacts in private Google Cloud Storage buckets are downloaded with the
+   same credentials as they were uploaded with, either a service account key
+   or the application default credentials, like GKE workload identity:
+
+   $ export BUILDKITE_GS_APPLICATION_CREDENTIALS=/etc/buildkite-agent/gcs-key.json
+   $ buildkite-agent artifact download "pkg/*.tar.gz" .`
 
 type ArtifactDownloadConfig struct {
-	Query            string `cli:"arg:0" label:"artifact search query" validate:"required"`
-	Destination      string `cli:"arg:1" label:"artifact download path" validate:"required"`
-	Step             string `cli:"step"`
-	Build            string `cli:"build" validate:"required"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
-	Endpoint         string `cli:"endpoint" validate:"required"`
-	NoColor          bool   `cli:"no-color"`
-	Debug            bool   `cli:"debug"`
-	DebugHTTP        bool   `cli:"debug-http"`
+	Query                    string `cli:"arg:0" label:"artifact search query" validate:"required"`
+	Destination              string `cli:"arg:1" label:"artifact download path" validate:"required"`
+	Step                     string `cli:"step"`
+	DirMode                  string `cli:"dir-mode"`
+	Umask                    string `cli:"umask"`
+	RestoreModes             bool   `cli:"restore-modes"`
+	S3Endpoint               string `cli:"s3-endpoint"`
+	S3ForcePathStyle         bool   `cli:"s3-force-path-style"`
+	S3Region                 string `cli:"s3-region"`
+	Build                    string `cli:"build" validate:"required"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
 }
 
 var ArtifactDownloadCommand = cli.Command{
@@ -61,9 +96,36 @@ var ArtifactDownloadCommand = cli.Command{
 			EnvVar: "BUILDKITE_BUILD_ID",
 			Usage:  "The build that the artifacts were uploaded to",
 		},
+		cli.StringFlag{
+			Name:   "dir-mode",
+			Value:  "",
+			Usage:  "The permissions of directories created for the artifacts, in octal (e.g. \"0755\")",
+			EnvVar: "BUILDKITE_ARTIFACT_DOWNLOAD_DIR_MODE",
+		},
+		cli.StringFlag{
+			Name:   "umask",
+			Value:  "",
+			Usage:  "Permissions to remove from downloaded files, in octal (e.g. \"0022\"), regardless of the agent's umask",
+			EnvVar: "BUILDKITE_ARTIFACT_DOWNLOAD_UMASK",
+		},
+		cli.BoolFlag{
+			Name:   "restore-modes",
+			Usage:  "Give files the permissions they had when they were uploaded",
+			EnvVar: "BUILDKITE_ARTIFACT_DOWNLOAD_RESTORE_MODES",
+		},
+		S3EndpointFlag,
+		S3ForcePathStyleFlag,
+		S3RegionFlag,
 		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
 		EndpointFlag,
+		ConfigFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 	},
@@ -79,16 +141,38 @@ var ArtifactDownloadCommand = cli.Command{
 		// Setup the any global configuration options
 		HandleGlobalFlags(cfg)
 
+		dirMode, err := parseFileMode(cfg.DirMode)
+		if err != nil {
+			logger.Fatal("Invalid --dir-mode: %s", err)
+		}
+
+		var umask *os.FileMode
+		if cfg.Umask != "" {
+			mode, err := parseFileMode(cfg.Umask)
+			if err != nil {
+				logger.Fatal("Invalid --umask: %s", err)
+			}
+			umask = &mode
+		}
+
 		// Setup the downloader
 		downloader := agent.ArtifactDownloader{
 			APIClient: agent.APIClient{
 				Endpoint: cfg.Endpoint,
 				Token:    cfg.AgentAccessToken,
 			}.Create(),
-			Query:       cfg.Query,
-			Destination: cfg.Destination,
-			BuildID:     cfg.Build,
-			Step:        cfg.Step,
+			Query:        cfg.Query,
+			Destination:  cfg.Destination,
+			BuildID:      cfg.Build,
+			Step:         cfg.Step,
+			DirMode:      dirMode,
+			Umask:        umask,
+			RestoreModes: cfg.RestoreModes,
+			S3Config: agent.S3Config{
+				Endpoint:       cfg.S3Endpoint,
+				ForcePathStyle: cfg.S3ForcePathStyle,
+				Region:         cfg.S3Region,
+			},
 		}
 
 		// Download the artifacts
@@ -97,3 +181,17 @@ var ArtifactDownloadCommand = cli.Command{
 		}
 	},
 }
+
+// parseFileMode parses permissions written in octal, like chmod takes them
+func parseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%q isn't an octal file mode like \"0755\"", s)
+	}
+
+	return os.FileMode(mode), nil
+}
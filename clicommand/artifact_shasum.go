@@ -37,14 +37,20 @@ Example:
    You can also use the step's job id (provided by the environment variable $BUILDKITE_JOB_ID)`
 
 type ArtifactShasumConfig struct {
-	Query            string `cli:"arg:0" label:"artifact search query" validate:"required"`
-	Step             string `cli:"step"`
-	Build            string `cli:"build" validate:"required"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
-	Endpoint         string `cli:"endpoint" validate:"required"`
-	NoColor          bool   `cli:"no-color"`
-	Debug            bool   `cli:"debug"`
-	DebugHTTP        bool   `cli:"debug-http"`
+	Query                    string `cli:"arg:0" label:"artifact search query" validate:"required"`
+	Step                     string `cli:"step"`
+	Build                    string `cli:"build" validate:"required"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
 }
 
 var ArtifactShasumCommand = cli.Command{
@@ -64,8 +70,15 @@ var ArtifactShasumCommand = cli.Command{
 			Usage:  "The build that the artifacts were uploaded to",
 		},
 		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
 		EndpointFlag,
+		ConfigFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 	},
@@ -1,8 +1,11 @@
 package clicommand
 
 import (
+	"strings"
+
 	"github.com/buildkite/agent/agent"
 	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/events"
 	"github.com/buildkite/agent/logger"
 	"github.com/urfave/cli"
 )
@@ -23,6 +26,26 @@ Example:
 
    $ buildkite-agent artifact upload "log/**/*.log"
 
+   Braces match any of a list of alternatives:
+
+   $ buildkite-agent artifact upload "dist/**/*.{js,map,css}"
+
+   Tags can be added to the artifacts to say more about them:
+
+   $ buildkite-agent artifact upload "core.*" --tag signal=SIGSEGV
+
+   Artifacts uploaded to S3 or Google Cloud Storage can be gzipped before
+   they're uploaded, and are decompressed again by "buildkite-agent artifact
+   download". Files that are already compressed, like .zip or .png files, are
+   uploaded as they are:
+
+   $ buildkite-agent artifact upload "log/**/*.log" --compress
+
+   A manifest of the artifacts and their checksums can be uploaded with them,
+   so that the whole set can be checked after it's downloaded:
+
+   $ buildkite-agent artifact upload "dist/**/*" --manifest dist.manifest.json
+
    You can also upload directly to Amazon S3 if you'd like to host your own artifacts:
 
    $ export BUILDKITE_S3_ACCESS_KEY_ID=xxx
@@ -31,20 +54,90 @@ Example:
    $ export BUILDKITE_S3_ACL=private # default is public-read
    $ buildkite-agent artifact upload "log/**/*.log" s3://name-of-your-s3-bucket/$BUILDKITE_JOB_ID
 
+   Or to a store that's compatible with S3, like MinIO or Ceph RGW:
+
+   $ export BUILDKITE_S3_ENDPOINT=http://minio.internal:9000
+   $ export BUILDKITE_S3_FORCE_PATH_STYLE=true
+   $ buildkite-agent artifact upload "log/**/*.log" s3://name-of-your-bucket/$BUILDKITE_JOB_ID
+
    Or upload directly to Google Cloud Storage:
 
    $ export BUILDKITE_GS_ACL=private
-   $ buildkite-agent artifact upload "log/**/*.log" gs://name-of-your-gs-bucket/$BUILDKITE_JOB_ID`
+   $ buildkite-agent artifact upload "log/**/*.log" gs://name-of-your-gs-bucket/$BUILDKITE_JOB_ID
+
+   Or to a repository in JFrog Artifactory, authenticating with an access
+   token, an API key, or BUILDKITE_ARTIFACTORY_USER and
+   BUILDKITE_ARTIFACTORY_PASSWORD. Each artifact gets the build and job IDs as
+   Artifactory properties:
+
+   $ export BUILDKITE_ARTIFACTORY_URL=https://example.jfrog.io/artifactory
+   $ export BUILDKITE_ARTIFACTORY_ACCESS_TOKEN=xxx # or BUILDKITE_ARTIFACTORY_API_KEY
+   $ buildkite-agent artifact upload "log/**/*.log" rt://name-of-your-repository/$BUILDKITE_JOB_ID
+
+   Or copy them to a directory that's shared between agents, like an NFS or
+   SMB mount, keeping their relative paths:
+
+   $ buildkite-agent artifact upload "log/**/*.log" file:///mnt/artifacts/$BUILDKITE_JOB_ID
+
+   Or anywhere else, with a command that does the uploading. It's run with
+   $BUILDKITE_ARTIFACT_UPLOAD_ACTION set to "url" to ask for the URL an
+   artifact will have, and then "upload" to upload it. The artifact's path,
+   absolute_path, file_size, sha1sum, sha256sum, content_type,
+   content_encoding and the destination are given as JSON on STDIN, and the
+   command can write {"url": "...", "error": "..."} to STDOUT. A non-zero
+   exit status or an error fails it:
+
+   $ buildkite-agent artifact upload "log/**/*.log" minio://builds/$BUILDKITE_JOB_ID \
+       --uploader /usr/local/bin/upload-to-minio`
 
 type ArtifactUploadConfig struct {
-	UploadPaths      string `cli:"arg:0" label:"upload paths" validate:"required"`
-	Destination      string `cli:"arg:1" label:"destination" env:"BUILDKITE_ARTIFACT_UPLOAD_DESTINATION"`
-	Job              string `cli:"job" validate:"required"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
-	Endpoint         string `cli:"endpoint" validate:"required"`
-	NoColor          bool   `cli:"no-color"`
-	Debug            bool   `cli:"debug"`
-	DebugHTTP        bool   `cli:"debug-http"`
+	UploadPaths              string   `cli:"arg:0" label:"upload paths" validate:"required"`
+	Destination              string   `cli:"arg:1" label:"destination" env:"BUILDKITE_ARTIFACT_UPLOAD_DESTINATION"`
+	Uploader                 string   `cli:"uploader"`
+	ArtifactoryURL           string   `cli:"artifactory-url"`
+	Compress                 bool     `cli:"compress"`
+	S3Endpoint               string   `cli:"s3-endpoint"`
+	S3ForcePathStyle         bool     `cli:"s3-force-path-style"`
+	S3Region                 string   `cli:"s3-region"`
+	Job                      string   `cli:"job" validate:"required"`
+	AgentAccessToken         string   `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string   `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string   `cli:"endpoint" validate:"required"`
+	EventSinks               []string `cli:"event-sink" normalize:"list"`
+	Tags                     []string `cli:"tag" normalize:"list"`
+	MaxFiles                 int      `cli:"max-files"`
+	MaxBytes                 int      `cli:"max-bytes"`
+	MaxJobBytes              int      `cli:"max-job-bytes"`
+	JobBytesFile             string   `cli:"job-bytes-file"`
+	Manifest                 string   `cli:"manifest"`
+	NoColor                  bool     `cli:"no-color"`
+	ColorTheme               string   `cli:"color-theme"`
+	LogFormat                string   `cli:"log-format"`
+	LogLevels                string   `cli:"log-levels"`
+	LogTimestampFormat       string   `cli:"log-timestamp-format"`
+	LogTimezone              string   `cli:"log-timezone"`
+	Debug                    bool     `cli:"debug"`
+	DebugHTTP                bool     `cli:"debug-http"`
+}
+
+var S3EndpointFlag = cli.StringFlag{
+	Name:   "s3-endpoint",
+	Value:  "",
+	Usage:  "The URL of an S3-compatible store like MinIO or Ceph to use for s3:// locations, instead of AWS",
+	EnvVar: "BUILDKITE_S3_ENDPOINT",
+}
+
+var S3ForcePathStyleFlag = cli.BoolFlag{
+	Name:   "s3-force-path-style",
+	Usage:  "Address S3 buckets in the path of the URL rather than the host, which most S3-compatible stores need",
+	EnvVar: "BUILDKITE_S3_FORCE_PATH_STYLE",
+}
+
+var S3RegionFlag = cli.StringFlag{
+	Name:   "s3-region",
+	Value:  "",
+	Usage:  "The region of the S3 bucket, which can be any name with --s3-endpoint",
+	EnvVar: "BUILDKITE_S3_DEFAULT_REGION",
 }
 
 var ArtifactUploadCommand = cli.Command{
@@ -58,9 +151,77 @@ var ArtifactUploadCommand = cli.Command{
 			Usage:  "Which job should the artifacts be uploaded to",
 			EnvVar: "BUILDKITE_JOB_ID",
 		},
+		cli.StringSliceFlag{
+			Name:   "event-sink",
+			Value:  &cli.StringSlice{},
+			Usage:  "Where to send artifact uploaded events, either a http(s):// webhook URL, a file:// path or an exec: command",
+			EnvVar: "BUILDKITE_AGENT_EVENT_SINKS",
+		},
+		cli.StringSliceFlag{
+			Name:  "tag",
+			Value: &cli.StringSlice{},
+			Usage: "A key=value tag to add to the uploaded artifacts",
+		},
+		cli.IntFlag{
+			Name:   "max-files",
+			Value:  0,
+			Usage:  "Fail without uploading anything if the paths match more than this many files. 0 means there's no limit.",
+			EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_MAX_FILES",
+		},
+		cli.IntFlag{
+			Name:   "max-bytes",
+			Value:  0,
+			Usage:  "Fail without uploading anything if the files the paths match add up to more than this many bytes. 0 means there's no limit.",
+			EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_MAX_BYTES",
+		},
+		cli.IntFlag{
+			Name:   "max-job-bytes",
+			Value:  0,
+			Usage:  "Fail without uploading anything if the files would take the job's artifacts over this many bytes in total. It's set by the agent's --max-artifact-bytes-per-job.",
+			EnvVar: "BUILDKITE_MAX_ARTIFACT_BYTES_PER_JOB",
+		},
+		cli.StringFlag{
+			Name:   "job-bytes-file",
+			Value:  "",
+			Usage:  "The file the job's artifact bytes are counted in, which the agent sets up",
+			EnvVar: "BUILDKITE_ARTIFACT_BYTES_FILE",
+		},
+		cli.StringFlag{
+			Name:   "uploader",
+			Value:  "",
+			Usage:  "A command that uploads each artifact to the destination instead, for storage the agent doesn't support. See the description above.",
+			EnvVar: "BUILDKITE_ARTIFACT_UPLOADER",
+		},
+		cli.BoolFlag{
+			Name:   "compress",
+			Usage:  "Gzip the artifacts before uploading them to S3 or Google Cloud Storage. They're decompressed again when they're downloaded.",
+			EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_COMPRESS",
+		},
+		S3EndpointFlag,
+		S3ForcePathStyleFlag,
+		S3RegionFlag,
+		cli.StringFlag{
+			Name:   "artifactory-url",
+			Value:  "",
+			Usage:  "The URL of the Artifactory instance to upload rt:// destinations to, e.g. https://example.jfrog.io/artifactory",
+			EnvVar: "BUILDKITE_ARTIFACTORY_URL",
+		},
+		cli.StringFlag{
+			Name:   "manifest",
+			Value:  "",
+			Usage:  "Also upload a JSON manifest with the path, size and sha256 of every artifact, as an artifact with this path",
+			EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_MANIFEST",
+		},
 		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
 		EndpointFlag,
+		ConfigFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 	},
@@ -76,15 +237,51 @@ var ArtifactUploadCommand = cli.Command{
 		// Setup the any global configuration options
 		HandleGlobalFlags(cfg)
 
+		// Setup where artifact uploaded events are sent
+		eventSinks, err := events.ParseSinks(cfg.EventSinks)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		eventBus := events.NewBus(eventSinks...)
+		defer eventBus.Close()
+
+		var tags map[string]string
+		for _, tag := range cfg.Tags {
+			parts := strings.SplitN(tag, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				logger.Fatal("Invalid artifact tag %q, expected key=value", tag)
+			}
+			if tags == nil {
+				tags = map[string]string{}
+			}
+			tags[parts[0]] = parts[1]
+		}
+
 		// Setup the uploader
 		uploader := agent.ArtifactUploader{
 			APIClient: agent.APIClient{
 				Endpoint: cfg.Endpoint,
 				Token:    cfg.AgentAccessToken,
 			}.Create(),
-			JobID:       cfg.Job,
-			Paths:       cfg.UploadPaths,
-			Destination: cfg.Destination,
+			JobID:           cfg.Job,
+			Paths:           cfg.UploadPaths,
+			Destination:     cfg.Destination,
+			UploaderCommand: cfg.Uploader,
+			ArtifactoryURL:  cfg.ArtifactoryURL,
+			Tags:            tags,
+			Events:          eventBus,
+			MaxFiles:        cfg.MaxFiles,
+			MaxBytes:        int64(cfg.MaxBytes),
+			MaxJobBytes:     int64(cfg.MaxJobBytes),
+			JobBytesFile:    cfg.JobBytesFile,
+			Manifest:        cfg.Manifest,
+			Compress:        cfg.Compress,
+			S3Config: agent.S3Config{
+				Endpoint:       cfg.S3Endpoint,
+				ForcePathStyle: cfg.S3ForcePathStyle,
+				Region:         cfg.S3Region,
+			},
 		}
 
 		// Upload the artifacts
@@ -7,6 +7,8 @@ import (
 	"github.com/buildkite/agent/bootstrap"
 	"github.com/buildkite/agent/cliconfig"
 	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/process"
+	"github.com/buildkite/agent/sandbox"
 	"github.com/urfave/cli"
 )
 
@@ -57,17 +59,41 @@ type BootstrapConfig struct {
 	CleanCheckout                bool     `cli:"clean-checkout"`
 	GitCloneFlags                string   `cli:"git-clone-flags"`
 	GitCleanFlags                string   `cli:"git-clean-flags"`
+	GitURLRewrites               []string `cli:"git-url-rewrite" normalize:"list"`
+	GitArchiveURL                string   `cli:"git-archive-url"`
+	DotenvFiles                  string   `cli:"dotenv-files"`
+	DotenvAllowedVars            []string `cli:"dotenv-allowed-vars" normalize:"list"`
 	BinPath                      string   `cli:"bin-path" normalize:"filepath"`
 	BuildPath                    string   `cli:"build-path" normalize:"filepath"`
+	BuildPathTemplate            string   `cli:"build-path-template"`
 	HooksPath                    string   `cli:"hooks-path" normalize:"filepath"`
 	PluginsPath                  string   `cli:"plugins-path" normalize:"filepath"`
 	CommandEval                  bool     `cli:"command-eval"`
 	PluginsEnabled               bool     `cli:"plugins-enabled"`
 	PluginValidation             bool     `cli:"plugin-validation"`
 	LocalHooksEnabled            bool     `cli:"local-hooks-enabled"`
+	SkipRepositoryHooks          bool     `cli:"skip-repository-hooks"`
 	PTY                          bool     `cli:"pty"`
+	WindowsPTY                   bool     `cli:"windows-pty"`
 	Debug                        bool     `cli:"debug"`
 	Shell                        string   `cli:"shell"`
+	TracingOTLPEndpoint          string   `cli:"tracing-otlp-endpoint"`
+	TracingParent                string   `cli:"tracing-parent"`
+	PhaseTimingsFile             string   `cli:"phase-timings-file"`
+	SeccompProfile               string   `cli:"seccomp-profile"`
+	AppArmorProfile              string   `cli:"apparmor-profile"`
+	SELinuxContext               string   `cli:"selinux-context"`
+	CommandSandbox               bool     `cli:"command-sandbox"`
+	AuditLog                     string   `cli:"audit-log"`
+	AuditLogUpload               bool     `cli:"audit-log-upload"`
+	AuditLogFD                   int      `cli:"audit-log-fd"`
+	InfraFailureFD               int      `cli:"infra-failure-fd"`
+	CrashArtifactPaths           string   `cli:"crash-artifact-paths"`
+	DebugSessionTimeout          int      `cli:"debug-session-timeout"`
+	DebugSessionAuthorizedKeys   string   `cli:"debug-session-authorized-keys" normalize:"filepath"`
+	DockerImage                  string   `cli:"docker-image"`
+	CommandRetryExitCodes        string   `cli:"command-retry-exit-codes"`
+	CommandRetryCount            int      `cli:"command-retry-count"`
 	Phases                       []string `cli:"phases" normalize:"list"`
 }
 
@@ -183,6 +209,30 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Flags to pass to \"git clean\" command",
 			EnvVar: "BUILDKITE_GIT_CLEAN_FLAGS",
 		},
+		cli.StringSliceFlag{
+			Name:   "git-url-rewrite",
+			Value:  &cli.StringSlice{},
+			Usage:  "Rewrite the start of repository URLs during checkout, in the form \"from=to\" (e.g. \"git@github.com:org/=https://git.internal/org/\")",
+			EnvVar: "BUILDKITE_GIT_URL_REWRITES",
+		},
+		cli.StringFlag{
+			Name:   "git-archive-url",
+			Value:  "",
+			Usage:  "A git bundle (.bundle) or tarball (.tar, .tar.gz or .tgz) of the repository to check out from if cloning fails",
+			EnvVar: "BUILDKITE_GIT_ARCHIVE_URL",
+		},
+		cli.StringFlag{
+			Name:   "dotenv-files",
+			Value:  "",
+			Usage:  "A comma-separated list of .env files in the checkout to load environment variables from before the command runs. They don't override variables that are already set, like the step's env.",
+			EnvVar: "BUILDKITE_DOTENV_FILES",
+		},
+		cli.StringSliceFlag{
+			Name:   "dotenv-allowed-vars",
+			Value:  &cli.StringSlice{},
+			Usage:  "Variables that .env files can set even though they're normally refused, like PATH or LD_PRELOAD",
+			EnvVar: "BUILDKITE_DOTENV_ALLOWED_VARS",
+		},
 		cli.StringFlag{
 			Name:   "bin-path",
 			Value:  "",
@@ -195,6 +245,12 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Directory where builds will be created",
 			EnvVar: "BUILDKITE_BUILD_PATH",
 		},
+		cli.StringFlag{
+			Name:   "build-path-template",
+			Value:  bootstrap.DefaultBuildPathTemplate,
+			Usage:  "The layout of checkouts within the build path, using {agent}, {organization}, {pipeline}, {queue} and {hash}",
+			EnvVar: "BUILDKITE_BUILD_PATH_TEMPLATE",
+		},
 		cli.StringFlag{
 			Name:   "hooks-path",
 			Value:  "",
@@ -227,6 +283,11 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Allow local hooks to be run",
 			EnvVar: "BUILDKITE_LOCAL_HOOKS_ENABLED",
 		},
+		cli.BoolFlag{
+			Name:   "skip-repository-hooks",
+			Usage:  "Skip the hooks in the repository's .buildkite/hooks directory with a warning",
+			EnvVar: "BUILDKITE_SKIP_REPOSITORY_HOOKS",
+		},
 		cli.BoolTFlag{
 			Name:   "ssh-keyscan",
 			Usage:  "Automatically run ssh-keyscan before checkout",
@@ -242,12 +303,117 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Run jobs within a pseudo terminal",
 			EnvVar: "BUILDKITE_PTY",
 		},
+		cli.BoolFlag{
+			Name:   "windows-pty",
+			Usage:  "Run jobs within a pseudo console on Windows too",
+			EnvVar: "BUILDKITE_WINDOWS_PTY",
+		},
 		cli.StringFlag{
 			Name:   "shell",
 			Usage:  "The shell to use to interpret build commands",
 			EnvVar: "BUILDKITE_SHELL",
 			Value:  DefaultShell(),
 		},
+		cli.StringFlag{
+			Name:   "tracing-otlp-endpoint",
+			Value:  "",
+			Usage:  "Send traces of the bootstrap to an OpenTelemetry collector at this OTLP/HTTP endpoint",
+			EnvVar: "BUILDKITE_TRACING_OTLP_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "tracing-parent",
+			Value:  "",
+			Usage:  "The W3C traceparent of the span that the bootstrap's spans belong under",
+			EnvVar: "BUILDKITE_TRACING_PARENT",
+		},
+		cli.StringFlag{
+			Name:   "phase-timings-file",
+			Value:  "",
+			Usage:  "A file to write the duration of each phase to as JSON",
+			EnvVar: "BUILDKITE_PHASE_TIMINGS_FILE",
+		},
+		cli.StringFlag{
+			Name:   "seccomp-profile",
+			Value:  "",
+			Usage:  "Deny the syscalls listed in this file, or \"default\", to the bootstrap and everything it runs",
+			EnvVar: "BUILDKITE_SECCOMP_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "apparmor-profile",
+			Value:  "",
+			Usage:  "The AppArmor profile the bootstrap runs under, which is applied to the command's container when it runs in a Docker image",
+			EnvVar: "BUILDKITE_APPARMOR_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "selinux-context",
+			Value:  "",
+			Usage:  "The SELinux context the bootstrap runs in, which is applied to the command's container when it runs in a Docker image",
+			EnvVar: "BUILDKITE_SELINUX_CONTEXT",
+		},
+		cli.BoolFlag{
+			Name:   "command-sandbox",
+			Usage:  "Run the command phase in new mount, PID and network namespaces (Linux only)",
+			EnvVar: "BUILDKITE_COMMAND_SANDBOX",
+		},
+		cli.StringFlag{
+			Name:   "audit-log",
+			Value:  "",
+			Usage:  "A file to append a record of every hook and command that's run to",
+			EnvVar: "BUILDKITE_AUDIT_LOG",
+		},
+		cli.BoolFlag{
+			Name:   "audit-log-upload",
+			Usage:  "Upload the audit log as an artifact once the job finishes",
+			EnvVar: "BUILDKITE_AUDIT_LOG_UPLOAD",
+		},
+		cli.IntFlag{
+			Name:   "audit-log-fd",
+			Value:  0,
+			Usage:  "A file descriptor that the agent reads audit log entries from, and appends to an audit log that only it can write to",
+			EnvVar: "BUILDKITE_AUDIT_LOG_FD",
+		},
+		cli.IntFlag{
+			Name:   "infra-failure-fd",
+			Value:  0,
+			Usage:  "A file descriptor that the agent reads failures caused by its infrastructure from, so it can retry the job",
+			EnvVar: "BUILDKITE_INFRA_FAILURE_FD",
+		},
+		cli.StringFlag{
+			Name:   "crash-artifact-paths",
+			Value:  "",
+			Usage:  "Paths to upload as artifacts if the command is killed by a signal like SIGSEGV, such as core dumps",
+			EnvVar: "BUILDKITE_CRASH_ARTIFACT_PATHS",
+		},
+		cli.IntFlag{
+			Name:   "debug-session-timeout",
+			Value:  0,
+			Usage:  "Allow jobs that set BUILDKITE_DEBUG_SESSION=true to start a tmate session for this many minutes when their command fails",
+			EnvVar: "BUILDKITE_DEBUG_SESSION_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "debug-session-authorized-keys",
+			Value:  "",
+			Usage:  "An authorized_keys file with the SSH keys that can use debug sessions, which are read-only without it",
+			EnvVar: "BUILDKITE_DEBUG_SESSION_AUTHORIZED_KEYS",
+		},
+		cli.StringFlag{
+			Name:   "docker-image",
+			Value:  "",
+			Usage:  "Pull this Docker image and run the command in it, with the checkout mounted",
+			EnvVar: "BUILDKITE_DOCKER_IMAGE",
+		},
+		cli.StringFlag{
+			Name:   "command-retry-exit-codes",
+			Value:  "",
+			Usage:  "Comma separated exit codes, like 75, that the command is re-run for when it exits with them",
+			EnvVar: "BUILDKITE_COMMAND_RETRY_EXIT_CODES",
+		},
+		cli.IntFlag{
+			Name:   "command-retry-count",
+			Value:  1,
+			Usage:  "How many times the command is re-run for the retry exit codes",
+			EnvVar: "BUILDKITE_COMMAND_RETRY_COUNT",
+		},
 		cli.StringSliceFlag{
 			Name:   "phases",
 			Usage:  "The specific phases to execute. The order they're defined is is irrelevant.",
@@ -264,9 +430,10 @@ var BootstrapCommand = cli.Command{
 			logger.Fatal("%s", err)
 		}
 
-		// Turn of PTY support if we're on Windows
-		runInPty := cfg.PTY
-		if runtime.GOOS == "windows" {
+		// Turn off PTY support if it isn't available, like on older
+		// versions of Windows, or on Windows unless it's been asked for
+		runInPty := cfg.PTY && process.PTYSupported()
+		if runtime.GOOS == "windows" && !cfg.WindowsPTY {
 			runInPty = false
 		}
 
@@ -280,6 +447,24 @@ var BootstrapCommand = cli.Command{
 			}
 		}
 
+		// Install the seccomp filter before anything from the job runs, so
+		// that every process it starts inherits it
+		if cfg.SeccompProfile != "" {
+			denied, err := sandbox.ReadSeccompProfile(cfg.SeccompProfile)
+			if err != nil {
+				logger.Fatal("%s", err)
+			}
+			if cfg.CommandSandbox {
+				if err := sandbox.CheckNamespaceSyscalls(denied); err != nil {
+					logger.Fatal("%s", err)
+				}
+			}
+			if err := sandbox.ApplySeccomp(denied); err != nil {
+				logger.Fatal("%s", err)
+			}
+			logger.Debug("Applied seccomp profile %s", cfg.SeccompProfile)
+		}
+
 		// Configure the bootstraper
 		bootstrap := &bootstrap.Bootstrap{
 			Phases: cfg.Phases,
@@ -296,6 +481,10 @@ var BootstrapCommand = cli.Command{
 				PullRequest:                  cfg.PullRequest,
 				GitCloneFlags:                cfg.GitCloneFlags,
 				GitCleanFlags:                cfg.GitCleanFlags,
+				GitURLRewrites:               cfg.GitURLRewrites,
+				GitArchiveURL:                cfg.GitArchiveURL,
+				DotenvFiles:                  cfg.DotenvFiles,
+				DotenvAllowedVars:            cfg.DotenvAllowedVars,
 				AgentName:                    cfg.AgentName,
 				PipelineProvider:             cfg.PipelineProvider,
 				PipelineSlug:                 cfg.PipelineSlug,
@@ -304,6 +493,7 @@ var BootstrapCommand = cli.Command{
 				ArtifactUploadDestination:    cfg.ArtifactUploadDestination,
 				CleanCheckout:                cfg.CleanCheckout,
 				BuildPath:                    cfg.BuildPath,
+				BuildPathTemplate:            cfg.BuildPathTemplate,
 				BinPath:                      cfg.BinPath,
 				HooksPath:                    cfg.HooksPath,
 				PluginsPath:                  cfg.PluginsPath,
@@ -313,8 +503,26 @@ var BootstrapCommand = cli.Command{
 				CommandEval:                  cfg.CommandEval,
 				PluginsEnabled:               cfg.PluginsEnabled,
 				LocalHooksEnabled:            cfg.LocalHooksEnabled,
+				SkipRepositoryHooks:          cfg.SkipRepositoryHooks,
 				SSHKeyscan:                   cfg.SSHKeyscan,
 				Shell:                        cfg.Shell,
+				TracingOTLPEndpoint:          cfg.TracingOTLPEndpoint,
+				TracingParent:                cfg.TracingParent,
+				PhaseTimingsFile:             cfg.PhaseTimingsFile,
+				SeccompProfile:               cfg.SeccompProfile,
+				AppArmorProfile:              cfg.AppArmorProfile,
+				SELinuxContext:               cfg.SELinuxContext,
+				CommandSandbox:               cfg.CommandSandbox,
+				AuditLog:                     cfg.AuditLog,
+				AuditLogUpload:               cfg.AuditLogUpload,
+				AuditLogFD:                   cfg.AuditLogFD,
+				InfraFailureFD:               cfg.InfraFailureFD,
+				CrashArtifactPaths:           cfg.CrashArtifactPaths,
+				DebugSessionTimeout:          cfg.DebugSessionTimeout,
+				DebugSessionAuthorizedKeys:   cfg.DebugSessionAuthorizedKeys,
+				DockerImage:                  cfg.DockerImage,
+				CommandRetryExitCodes:        cfg.CommandRetryExitCodes,
+				CommandRetryCount:            cfg.CommandRetryCount,
 			},
 		}
 
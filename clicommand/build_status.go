@@ -0,0 +1,123 @@
+package clicommand
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/buildkite/agent/agent"
+	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/retry"
+	"github.com/urfave/cli"
+)
+
+var BuildStatusHelpDescription = `Usage:
+
+   buildkite-agent build status [arguments...]
+
+Description:
+
+   Prints the state of the build as JSON, along with any block steps that are
+   waiting to be unblocked and any jobs that have failed. Later steps can use
+   it to decide what to do based on how the rest of the build went.
+
+   Agents that give jobs a job-scoped token (with --job-scoped-tokens or the
+   command sandbox) only let them get the status of their own build.
+
+Example:
+
+   $ buildkite-agent build status
+   $ buildkite-agent build status | jq -e '.failed_jobs | length == 0' && ./deploy.sh`
+
+type BuildStatusConfig struct {
+	Build                    string `cli:"build" validate:"required"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
+}
+
+var BuildStatusCommand = cli.Command{
+	Name:        "status",
+	Usage:       "Prints the state of a build as JSON",
+	Description: BuildStatusHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "build",
+			Value:  "",
+			EnvVar: "BUILDKITE_BUILD_ID",
+			Usage:  "The build to get the state of",
+		},
+		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
+		EndpointFlag,
+		ConfigFlag,
+		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
+		DebugFlag,
+		DebugHTTPFlag,
+	},
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := BuildStatusConfig{}
+
+		// Load the configuration
+		if err := cliconfig.Load(c, &cfg); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(cfg)
+
+		// Create the API client
+		client := agent.APIClient{
+			Endpoint: cfg.Endpoint,
+			Token:    cfg.AgentAccessToken,
+		}.Create()
+
+		var build *api.Build
+		err := retry.Do(func(s *retry.Stats) error {
+			var err error
+			build, _, err = client.Builds.Get(cfg.Build)
+			if err != nil && !api.IsPermanentError(err) {
+				logger.Warn("%s (%s)", err, s)
+			}
+
+			return err
+		}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, IsPermanent: api.IsPermanentError})
+		if isForbidden(err) && cfg.Build != os.Getenv("BUILDKITE_BUILD_ID") {
+			logger.Fatal("Failed to get the build: this job's token can only get the status of its own build, not %s", cfg.Build)
+		} else if err != nil {
+			logger.Fatal("Failed to get the build: %s", err)
+		}
+
+		// All logging happens to stderr, so this can be piped into
+		// other tools
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(agent.NewBuildStatus(build)); err != nil {
+			logger.Fatal("%s", err)
+		}
+	},
+}
+
+// isForbidden returns whether an API error was because the token isn't
+// allowed to access what was requested
+func isForbidden(err error) bool {
+	apierr, ok := err.(*api.ErrorResponse)
+	return ok && apierr.Response != nil && apierr.Response.StatusCode == http.StatusForbidden
+}
@@ -0,0 +1,111 @@
+package clicommand
+
+import (
+	"os"
+
+	"github.com/buildkite/agent/agent"
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/logger"
+	"github.com/urfave/cli"
+)
+
+var CacheRestoreHelpDescription = `Usage:
+
+   buildkite-agent cache restore <key> [fallback keys...] [arguments...]
+
+Description:
+
+   Restores a cache saved by "cache save" into the current directory.
+
+   Each key is tried in order. A key is first looked for exactly, and then as
+   a prefix, in which case the most recently saved cache that starts with it
+   is used. Keys are expanded in the same way as "cache save".
+
+   The command exits with a status of 0 if a cache was restored, or with a
+   status of 100 if none of the keys matched.
+
+Example:
+
+   $ buildkite-agent cache restore 'node-{{ checksum "yarn.lock" }}' node-
+   $ buildkite-agent cache restore --store s3://my-caches/gems 'gems-{{ env "BUILDKITE_BRANCH" }}' gems-master`
+
+type CacheRestoreConfig struct {
+	Key                string `cli:"arg:0" label:"cache key" validate:"required"`
+	Store              string `cli:"store" validate:"required"`
+	S3Endpoint         string `cli:"s3-endpoint"`
+	S3ForcePathStyle   bool   `cli:"s3-force-path-style"`
+	S3Region           string `cli:"s3-region"`
+	NoColor            bool   `cli:"no-color"`
+	ColorTheme         string `cli:"color-theme"`
+	LogFormat          string `cli:"log-format"`
+	LogLevels          string `cli:"log-levels"`
+	LogTimestampFormat string `cli:"log-timestamp-format"`
+	LogTimezone        string `cli:"log-timezone"`
+	Debug              bool   `cli:"debug"`
+	DebugHTTP          bool   `cli:"debug-http"`
+}
+
+var CacheRestoreCommand = cli.Command{
+	Name:        "restore",
+	Usage:       "Restores files and directories from the cache",
+	Description: CacheRestoreHelpDescription,
+	Flags: []cli.Flag{
+		CacheStoreFlag,
+		S3EndpointFlag,
+		S3ForcePathStyleFlag,
+		S3RegionFlag,
+		ConfigFlag,
+		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
+		DebugFlag,
+		DebugHTTPFlag,
+	},
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := CacheRestoreConfig{}
+
+		// Load the configuration
+		if err := cliconfig.Load(c, &cfg); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(cfg)
+
+		// Expand the key and each of the fallbacks
+		var keys []string
+		for _, key := range c.Args() {
+			expanded, err := agent.ExpandCacheKey(key)
+			if err != nil {
+				logger.Warn("Skipping cache key %q: %s", key, err)
+				continue
+			}
+			keys = append(keys, expanded)
+		}
+
+		store, err := agent.NewCacheStore(cfg.Store, agent.S3Config{
+			Endpoint:       cfg.S3Endpoint,
+			ForcePathStyle: cfg.S3ForcePathStyle,
+			Region:         cfg.S3Region,
+		})
+		if err != nil {
+			logger.Fatal("Failed to set up the cache store: %s", err)
+		}
+
+		restored, err := agent.CacheRestorer{Store: store, Keys: keys, Destination: "."}.Restore()
+		if err != nil {
+			logger.Fatal("Failed to restore cache: %s", err)
+		}
+
+		if restored == "" {
+			logger.Info("No cache found")
+			os.Exit(100)
+		}
+
+		logger.Info("Successfully restored cache %q", restored)
+	},
+}
@@ -0,0 +1,115 @@
+package clicommand
+
+import (
+	"github.com/buildkite/agent/agent"
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/logger"
+	"github.com/urfave/cli"
+)
+
+var CacheSaveHelpDescription = `Usage:
+
+   buildkite-agent cache save <key> <paths...> [arguments...]
+
+Description:
+
+   Archives the given files and directories and saves them to the cache store
+   under the key, so that later jobs can restore them with "cache restore".
+   If there's already a cache with the key it's left as it is, so keys should
+   change whenever what's being cached does.
+
+   Keys can contain {{ checksum "<path or glob>" }}, which is replaced with a
+   SHA256 of the matching files, and {{ env "<NAME>" }}, which is replaced
+   with the value of an environment variable.
+
+   The cache store is either an S3 bucket (s3://bucket/path), a Google Cloud
+   Storage bucket (gs://bucket/path), or a directory on the agent's host.
+
+Example:
+
+   $ buildkite-agent cache save 'node-{{ checksum "yarn.lock" }}' node_modules
+   $ buildkite-agent cache save --store s3://my-caches/gems 'gems-{{ env "BUILDKITE_BRANCH" }}' vendor/bundle`
+
+type CacheSaveConfig struct {
+	Key                string `cli:"arg:0" label:"cache key" validate:"required"`
+	Store              string `cli:"store" validate:"required"`
+	S3Endpoint         string `cli:"s3-endpoint"`
+	S3ForcePathStyle   bool   `cli:"s3-force-path-style"`
+	S3Region           string `cli:"s3-region"`
+	NoColor            bool   `cli:"no-color"`
+	ColorTheme         string `cli:"color-theme"`
+	LogFormat          string `cli:"log-format"`
+	LogLevels          string `cli:"log-levels"`
+	LogTimestampFormat string `cli:"log-timestamp-format"`
+	LogTimezone        string `cli:"log-timezone"`
+	Debug              bool   `cli:"debug"`
+	DebugHTTP          bool   `cli:"debug-http"`
+}
+
+var CacheStoreFlag = cli.StringFlag{
+	Name:   "store",
+	Value:  "",
+	Usage:  "Where caches are kept, either s3://bucket/path, gs://bucket/path or a local directory",
+	EnvVar: "BUILDKITE_CACHE_STORE",
+}
+
+var CacheSaveCommand = cli.Command{
+	Name:        "save",
+	Usage:       "Saves files and directories to the cache",
+	Description: CacheSaveHelpDescription,
+	Flags: []cli.Flag{
+		CacheStoreFlag,
+		S3EndpointFlag,
+		S3ForcePathStyleFlag,
+		S3RegionFlag,
+		ConfigFlag,
+		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
+		DebugFlag,
+		DebugHTTPFlag,
+	},
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := CacheSaveConfig{}
+
+		// Load the configuration
+		if err := cliconfig.Load(c, &cfg); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(cfg)
+
+		paths := c.Args().Tail()
+		if len(paths) == 0 {
+			logger.Fatal("Missing paths to cache. See `buildkite-agent cache save --help` for more information.")
+		}
+
+		key, err := agent.ExpandCacheKey(cfg.Key)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		store, err := agent.NewCacheStore(cfg.Store, agent.S3Config{
+			Endpoint:       cfg.S3Endpoint,
+			ForcePathStyle: cfg.S3ForcePathStyle,
+			Region:         cfg.S3Region,
+		})
+		if err != nil {
+			logger.Fatal("Failed to set up the cache store: %s", err)
+		}
+
+		saved, err := agent.CacheSaver{Store: store, Key: key, Paths: paths}.Save()
+		if err != nil {
+			logger.Fatal("Failed to save cache %q: %s", key, err)
+		}
+
+		if saved {
+			logger.Info("Successfully saved cache %q", key)
+		}
+	},
+}
@@ -0,0 +1,107 @@
+package clicommand
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/logger"
+	"github.com/oleiade/reflections"
+	"github.com/urfave/cli"
+)
+
+var ConfigShowDescription = `Usage:
+
+   buildkite-agent config show [arguments...]
+
+Description:
+
+   Prints the configuration that "buildkite-agent start" would use with the
+   same arguments, and where each value came from: a flag, an environment
+   variable, the config file, or the default.
+
+   Secrets like the agent token, and the passwords and query strings of
+   URLs, aren't shown.
+
+Example:
+
+   $ buildkite-agent config show --config /etc/buildkite-agent/buildkite-agent.cfg`
+
+var ConfigShowCommand = cli.Command{
+	Name:        "show",
+	Usage:       "Shows the agent's configuration and where each value came from",
+	Description: ConfigShowDescription,
+	Flags:       AgentStartCommand.Flags,
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := AgentStartConfig{}
+
+		// Load the config the same way `start` does, but don't fail on
+		// missing values since finding those is half the point
+		loader := cliconfig.Loader{
+			CLI:                    c,
+			Config:                 &cfg,
+			DefaultConfigFilePaths: DefaultConfigFilePaths(),
+			SkipValidation:         true,
+		}
+
+		if err := loader.Load(); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		writeConfig(os.Stdout, &loader, &cfg)
+	},
+}
+
+// writeConfig writes the config file that was used, and a table of each
+// value with where it came from
+func writeConfig(out io.Writer, loader *cliconfig.Loader, cfg *AgentStartConfig) {
+	if loader.File != nil {
+		fmt.Fprintf(out, "Config file: %s\n\n", loader.File.Path)
+	} else {
+		fmt.Fprintf(out, "Config file: none found\n\n")
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVALUE\tSOURCE")
+
+	fields, _ := reflections.Fields(cfg)
+	for _, fieldName := range fields {
+		cliName, _ := reflections.GetFieldTag(cfg, fieldName, "cli")
+		if cliName == "" {
+			continue
+		}
+
+		// Deprecated options are shown under their new names
+		if renamed, _ := reflections.GetFieldTag(cfg, fieldName, "deprecated-and-renamed-to"); renamed != "" {
+			continue
+		}
+
+		value, _ := reflections.GetField(cfg, fieldName)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", cliName, formatConfigValue(cliName, value), loader.Sources[cliName])
+	}
+
+	w.Flush()
+}
+
+func formatConfigValue(cliName string, value interface{}) string {
+	var formatted string
+	if list, ok := value.([]string); ok {
+		formatted = strings.Join(list, ",")
+	} else {
+		formatted = fmt.Sprintf("%v", value)
+	}
+
+	if strings.HasSuffix(cliName, "token") && formatted != "" {
+		return logger.RedactedReplacement
+	}
+
+	if formatted == "" {
+		return `""`
+	}
+
+	return logger.RedactURLs(formatted)
+}
@@ -0,0 +1,202 @@
+package clicommand
+
+import (
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/agent/agent"
+	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/retry"
+	"github.com/urfave/cli"
+)
+
+var CoverageUploadHelpDescription = `Usage:
+
+   buildkite-agent coverage upload <file> [arguments...]
+
+Description:
+
+   Reads a coverage report in the lcov, Cobertura XML or Go coverprofile
+   format, uploads it as an artifact of the job and annotates the build with
+   a summary of the coverage.
+
+   If the build has a baseline coverage percentage in its meta-data (under the
+   key given by --baseline-key) the annotation shows how coverage has changed
+   since then. Use --save-baseline to store this report's coverage as the new
+   baseline for the rest of the build.
+
+Example:
+
+   $ buildkite-agent coverage upload coverage/lcov.info
+   $ buildkite-agent coverage upload --save-baseline coverage.out`
+
+type CoverageUploadConfig struct {
+	File                     string `cli:"arg:0" label:"coverage report" validate:"required"`
+	BaselineKey              string `cli:"baseline-key"`
+	SaveBaseline             bool   `cli:"save-baseline"`
+	Context                  string `cli:"context"`
+	Job                      string `cli:"job" validate:"required"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
+}
+
+var CoverageUploadCommand = cli.Command{
+	Name:        "upload",
+	Usage:       "Uploads a coverage report and annotates the build with a summary of it",
+	Description: CoverageUploadHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "baseline-key",
+			Value:  "coverage-baseline",
+			Usage:  "The meta-data key that holds the baseline coverage percentage",
+			EnvVar: "BUILDKITE_COVERAGE_BASELINE_KEY",
+		},
+		cli.BoolFlag{
+			Name:   "save-baseline",
+			Usage:  "Save the coverage percentage of this report as the baseline",
+			EnvVar: "BUILDKITE_COVERAGE_SAVE_BASELINE",
+		},
+		cli.StringFlag{
+			Name:   "context",
+			Value:  "coverage",
+			Usage:  "The context of the coverage annotation",
+			EnvVar: "BUILDKITE_COVERAGE_CONTEXT",
+		},
+		cli.StringFlag{
+			Name:   "job",
+			Value:  "",
+			Usage:  "Which job the coverage report is from",
+			EnvVar: "BUILDKITE_JOB_ID",
+		},
+		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
+		EndpointFlag,
+		ConfigFlag,
+		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
+		DebugFlag,
+		DebugHTTPFlag,
+	},
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := CoverageUploadConfig{}
+
+		// Load the configuration
+		loader := cliconfig.Loader{CLI: c, Config: &cfg}
+		if err := loader.Load(); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(cfg)
+
+		data, err := ioutil.ReadFile(cfg.File)
+		if err != nil {
+			logger.Fatal("Failed to read coverage report: %s", err)
+		}
+
+		report, err := agent.ParseCoverage(data)
+		if err != nil {
+			logger.Fatal("Failed to parse coverage report \"%s\": %s", cfg.File, err)
+		}
+
+		logger.Info("Found %s coverage of %.2f%% across %d files", report.Format, report.Percent(), len(report.Files))
+
+		// Create the API client
+		client := agent.APIClient{
+			Endpoint: cfg.Endpoint,
+			Token:    cfg.AgentAccessToken,
+		}.Create()
+
+		// Upload the report itself so it can be looked at later
+		uploader := agent.ArtifactUploader{
+			APIClient: client,
+			JobID:     cfg.Job,
+			Paths:     cfg.File,
+		}
+		if err := uploader.Upload(); err != nil {
+			logger.Fatal("Failed to upload coverage report: %s", err)
+		}
+
+		// Find the baseline, if the build has one
+		var baseline *float64
+		var metaData *api.MetaData
+		var resp *api.Response
+		err = retry.Do(func(s *retry.Stats) error {
+			metaData, resp, err = client.MetaData.Get(cfg.Job, cfg.BaselineKey)
+			if err != nil && !api.IsPermanentError(err) {
+				logger.Warn("%s (%s)", err, s)
+			}
+
+			return err
+		}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, IsPermanent: api.IsPermanentError})
+		if err != nil && (resp == nil || resp.StatusCode != 404) {
+			logger.Fatal("Failed to get the baseline coverage: %s", err)
+		} else if err == nil {
+			if value, err := strconv.ParseFloat(metaData.Value, 64); err != nil {
+				logger.Warn("Ignoring baseline coverage \"%s\" that isn't a number", metaData.Value)
+			} else {
+				baseline = &value
+			}
+		}
+
+		annotation := &api.Annotation{
+			UUID:    api.NewUUID(),
+			Body:    agent.CoverageAnnotation(report, baseline),
+			Style:   "info",
+			Context: cfg.Context,
+		}
+		if baseline != nil && report.Percent() < *baseline {
+			annotation.Style = "warning"
+		}
+
+		err = retry.Do(func(s *retry.Stats) error {
+			_, err := client.Annotations.Create(cfg.Job, annotation)
+			if err != nil && !api.IsPermanentError(err) {
+				logger.Warn("%s (%s)", err, s)
+			}
+
+			return err
+		}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, Jitter: true, IsPermanent: api.IsPermanentError})
+		if err != nil {
+			logger.Fatal("Failed to annotate build with the coverage: %s", err)
+		}
+
+		if cfg.SaveBaseline {
+			value := strconv.FormatFloat(report.Percent(), 'f', 2, 64)
+			metaData := &api.MetaData{UUID: api.NewUUID(), Key: cfg.BaselineKey, Value: value}
+
+			err = retry.Do(func(s *retry.Stats) error {
+				_, err := client.MetaData.Set(cfg.Job, metaData)
+				if err != nil && !api.IsPermanentError(err) {
+					logger.Warn("%s (%s)", err, s)
+				}
+
+				return err
+			}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, IsPermanent: api.IsPermanentError})
+			if err != nil {
+				logger.Fatal("Failed to save the baseline coverage: %s", err)
+			}
+
+			logger.Info("Saved %s%% as the baseline coverage", value)
+		}
+
+		logger.Info("Successfully uploaded coverage report")
+	},
+}
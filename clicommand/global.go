@@ -1,9 +1,12 @@
 package clicommand
 
 import (
+	"time"
+
 	"github.com/buildkite/agent/agent"
 	"github.com/buildkite/agent/experiments"
 	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/stdin"
 	"github.com/oleiade/reflections"
 	"github.com/urfave/cli"
 )
@@ -12,6 +15,13 @@ const (
 	DefaultEndpoint = "https://agent.buildkite.com/v3"
 )
 
+var ConfigFlag = cli.StringFlag{
+	Name:   "config",
+	Value:  "",
+	Usage:  "Path to a configuration file, which can also be .yml or .toml",
+	EnvVar: "BUILDKITE_AGENT_CONFIG",
+}
+
 var AgentAccessTokenFlag = cli.StringFlag{
 	Name:   "agent-access-token",
 	Value:  "",
@@ -19,6 +29,13 @@ var AgentAccessTokenFlag = cli.StringFlag{
 	EnvVar: "BUILDKITE_AGENT_ACCESS_TOKEN",
 }
 
+var AgentAccessTokenFromFileFlag = cli.StringFlag{
+	Name:   "agent-access-token-from-file",
+	Value:  "",
+	Usage:  "A file to read the access token used to identify the agent from, instead of passing it with --agent-access-token",
+	EnvVar: "BUILDKITE_AGENT_ACCESS_TOKEN_FILE",
+}
+
 var EndpointFlag = cli.StringFlag{
 	Name:   "endpoint",
 	Value:  DefaultEndpoint,
@@ -44,6 +61,53 @@ var NoColorFlag = cli.BoolFlag{
 	EnvVar: "BUILDKITE_AGENT_NO_COLOR",
 }
 
+var ColorThemeFlag = cli.StringFlag{
+	Name:   "color-theme",
+	Value:  "default",
+	Usage:  "The colors used in logging, either \"default\", \"high-contrast\" or \"monochrome\"",
+	EnvVar: "BUILDKITE_AGENT_COLOR_THEME",
+}
+
+var LogFormatFlag = cli.StringFlag{
+	Name:   "log-format",
+	Value:  "text",
+	Usage:  "The format to use for the logger output, either \"text\" or \"json\"",
+	EnvVar: "BUILDKITE_AGENT_LOG_FORMAT",
+}
+
+var LogLevelsFlag = cli.StringFlag{
+	Name:   "log-levels",
+	Value:  "",
+	Usage:  "Override the log level of individual components, e.g. \"process=debug,api=warn\"",
+	EnvVar: "BUILDKITE_AGENT_LOG_LEVELS",
+}
+
+var LogTimestampFormatFlag = cli.StringFlag{
+	Name:   "log-timestamp-format",
+	Value:  "",
+	Usage:  "How the time is shown at the start of each log line, either \"datetime\" (the default), \"time\", \"rfc3339\", \"rfc3339nano\" or a Go time layout",
+	EnvVar: "BUILDKITE_AGENT_LOG_TIMESTAMP_FORMAT",
+}
+
+var LogTimezoneFlag = cli.StringFlag{
+	Name:   "log-timezone",
+	Value:  "",
+	Usage:  "The timezone of the time at the start of each log line, either \"local\" (the default), \"utc\" or a name like \"Europe/Berlin\"",
+	EnvVar: "BUILDKITE_AGENT_LOG_TIMEZONE",
+}
+
+var LogDedupWindowFlag = cli.StringFlag{
+	Name:   "log-dedup-window",
+	Value:  "",
+	Usage:  "Collapse identical log lines written within this duration into a single line with a repeat count, e.g. \"30s\"",
+	EnvVar: "BUILDKITE_AGENT_LOG_DEDUP_WINDOW",
+}
+
+var StdinFlag = cli.BoolFlag{
+	Name:  "stdin",
+	Usage: "Whether to read from STDIN. By default this is detected, but --stdin or --stdin=false can be used where detection gets it wrong",
+}
+
 var ExperimentsFlag = cli.StringSliceFlag{
 	Name:   "experiment",
 	Value:  &cli.StringSlice{},
@@ -51,13 +115,39 @@ var ExperimentsFlag = cli.StringSliceFlag{
 	EnvVar: "BUILDKITE_AGENT_EXPERIMENT",
 }
 
+// shouldReadStdin uses the --stdin flag if it was given, and otherwise
+// works out whether anything has been piped into the command
+func shouldReadStdin(c *cli.Context) bool {
+	if c.IsSet("stdin") {
+		return c.Bool("stdin")
+	}
+
+	return stdin.IsReadable()
+}
+
 func HandleGlobalFlags(cfg interface{}) {
+	// Switch the log format first so every following line uses it
+	logFormat, err := reflections.GetField(cfg, "LogFormat")
+	if logFormatString, ok := logFormat.(string); ok && logFormatString != "" && err == nil {
+		if err := logger.SetFormat(logFormatString); err != nil {
+			logger.Fatal("%s", err)
+		}
+	}
+
 	// Enable debugging if a Debug option is present
 	debug, err := reflections.GetField(cfg, "Debug")
 	if debug == true && err == nil {
 		logger.SetLevel(logger.DEBUG)
 	}
 
+	// Make sure any tokens never show up in the logs (including HTTP dumps)
+	for _, name := range []string{"Token", "AgentAccessToken"} {
+		token, err := reflections.GetField(cfg, name)
+		if tokenString, ok := token.(string); ok && err == nil {
+			logger.RedactValue(tokenString)
+		}
+	}
+
 	// Enable HTTP debugging
 	debugHTTP, err := reflections.GetField(cfg, "DebugHTTP")
 	if debugHTTP == true && err == nil {
@@ -70,6 +160,47 @@ func HandleGlobalFlags(cfg interface{}) {
 		logger.SetColors(false)
 	}
 
+	// Change the color theme if a ColorTheme option is present
+	colorTheme, err := reflections.GetField(cfg, "ColorTheme")
+	if colorThemeString, ok := colorTheme.(string); ok && colorThemeString != "" && err == nil {
+		if err := logger.SetTheme(colorThemeString); err != nil {
+			logger.Fatal("%s", err)
+		}
+	}
+
+	// Apply any per-component log levels
+	logLevels, err := reflections.GetField(cfg, "LogLevels")
+	if logLevelsString, ok := logLevels.(string); ok && logLevelsString != "" && err == nil {
+		if err := logger.SetComponentLevels(logLevelsString); err != nil {
+			logger.Fatal("%s", err)
+		}
+	}
+
+	// Change how the time is shown at the start of log lines
+	logTimestampFormat, err := reflections.GetField(cfg, "LogTimestampFormat")
+	if logTimestampFormatString, ok := logTimestampFormat.(string); ok && logTimestampFormatString != "" && err == nil {
+		if err := logger.SetTimestampFormat(logTimestampFormatString); err != nil {
+			logger.Fatal("%s", err)
+		}
+	}
+
+	logTimezone, err := reflections.GetField(cfg, "LogTimezone")
+	if logTimezoneString, ok := logTimezone.(string); ok && logTimezoneString != "" && err == nil {
+		if err := logger.SetTimezone(logTimezoneString); err != nil {
+			logger.Fatal("%s", err)
+		}
+	}
+
+	// Collapse bursts of identical log lines
+	logDedupWindow, err := reflections.GetField(cfg, "LogDedupWindow")
+	if logDedupWindowString, ok := logDedupWindow.(string); ok && logDedupWindowString != "" && err == nil {
+		window, err := time.ParseDuration(logDedupWindowString)
+		if err != nil {
+			logger.Fatal("Failed to parse log dedup window: %v", err)
+		}
+		logger.SetDedupWindow(window)
+	}
+
 	// Enable experiments
 	experimentNames, err := reflections.GetField(cfg, "Experiments")
 	if err == nil {
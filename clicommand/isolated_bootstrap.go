@@ -0,0 +1,146 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/isolation"
+	"github.com/buildkite/agent/logger"
+	"github.com/urfave/cli"
+)
+
+var IsolatedBootstrapHelpDescription = `Usage:
+
+   buildkite-agent isolated-bootstrap [arguments...]
+
+Description:
+
+   Runs the bootstrap for a job with an isolation backend, streaming its
+   output and exiting with the job's exit status. The agent runs this instead
+   of the bootstrap when it's started with --isolation, so there's no need to
+   run it yourself.
+
+   The firecracker backend boots a microVM for each job, and takes these
+   options:
+
+   kernel       An uncompressed Linux kernel to boot (required)
+   rootfs       An ext4 root filesystem, copied for each job (required)
+   firecracker  The firecracker binary to use
+   vcpus        How many CPUs each VM gets, 2 by default
+   memory       How much memory each VM gets in MiB, 2048 by default
+   tap          A tap device for the VM's network interface
+
+   The root filesystem needs the agent, and an init at /sbin/buildkite-init
+   that reads the job's env as JSON from /dev/vdb, runs the bootstrap with
+   it, writes the exit status to the start of /dev/vdc and reboots. The
+   bootstrap mustn't be able to write to /dev/vdc, so that the job can't
+   report its own exit status.`
+
+type IsolatedBootstrapConfig struct {
+	EnvFile            string   `cli:"env-file" validate:"required"`
+	Job                string   `cli:"job" validate:"required"`
+	Isolation          string   `cli:"isolation" validate:"required"`
+	Options            []string `cli:"isolation-option" normalize:"list"`
+	NoColor            bool     `cli:"no-color"`
+	ColorTheme         string   `cli:"color-theme"`
+	LogFormat          string   `cli:"log-format"`
+	LogLevels          string   `cli:"log-levels"`
+	LogTimestampFormat string   `cli:"log-timestamp-format"`
+	LogTimezone        string   `cli:"log-timezone"`
+	Debug              bool     `cli:"debug"`
+}
+
+var IsolatedBootstrapCommand = cli.Command{
+	Name:        "isolated-bootstrap",
+	Usage:       "Run a Buildkite job with an isolation backend",
+	Description: IsolatedBootstrapHelpDescription,
+	Hidden:      true,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "env-file",
+			Value:  "",
+			Usage:  "A JSON file with the job's environment",
+			EnvVar: "BUILDKITE_ISOLATION_ENV_FILE",
+		},
+		cli.StringFlag{
+			Name:   "job",
+			Value:  "",
+			Usage:  "The ID of the job",
+			EnvVar: "BUILDKITE_JOB_ID",
+		},
+		cli.StringFlag{
+			Name:   "isolation",
+			Value:  "",
+			Usage:  "The isolation backend to run the job with",
+			EnvVar: "BUILDKITE_ISOLATION",
+		},
+		cli.StringSliceFlag{
+			Name:   "isolation-option",
+			Value:  &cli.StringSlice{},
+			Usage:  "An option for the isolation backend, as key=value",
+			EnvVar: "BUILDKITE_ISOLATION_OPTIONS",
+		},
+		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
+		DebugFlag,
+	},
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := IsolatedBootstrapConfig{}
+
+		// Load the configuration
+		if err := cliconfig.Load(c, &cfg); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(cfg)
+
+		options, err := isolation.ParseOptions(cfg.Options)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		backend, err := isolation.New(cfg.Isolation, options)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		data, err := ioutil.ReadFile(cfg.EnvFile)
+		if err != nil {
+			logger.Fatal("Failed to read the job's env: %v", err)
+		}
+
+		var env map[string]string
+		if err := json.Unmarshal(data, &env); err != nil {
+			logger.Fatal("Failed to parse the job's env: %v", err)
+		}
+
+		// Signals stop the isolated job, rather than just this process
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			logger.Info("Received %s, stopping the job", sig)
+			cancel()
+		}()
+
+		exitStatus, err := backend.Run(ctx, isolation.Job{ID: cfg.Job, Env: env}, os.Stdout)
+		if err != nil {
+			logger.Error("Failed to run the job with the %s isolation backend: %v", cfg.Isolation, err)
+			os.Exit(1)
+		}
+
+		os.Exit(exitStatus)
+	},
+}
@@ -0,0 +1,151 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/kubernetes"
+	"github.com/buildkite/agent/logger"
+	"github.com/urfave/cli"
+)
+
+var KubernetesBootstrapHelpDescription = `Usage:
+
+   buildkite-agent kubernetes-bootstrap [arguments...]
+
+Description:
+
+   Runs the bootstrap for a job in its own Kubernetes pod, streaming the pod's
+   logs and exiting with the job's exit status. The agent runs this instead of
+   the bootstrap when it's started with --executor kubernetes, so there's no
+   need to run it yourself.
+
+   The step's env can choose the image the job runs in, the resources it gets
+   and the nodes it can run on:
+
+   BUILDKITE_KUBERNETES_IMAGE          e.g. golang:1.11
+   BUILDKITE_KUBERNETES_CPU            e.g. 500m
+   BUILDKITE_KUBERNETES_MEMORY         e.g. 1Gi
+   BUILDKITE_KUBERNETES_NODE_SELECTOR  e.g. disktype=ssd,zone=us-east-1a`
+
+type KubernetesBootstrapConfig struct {
+	EnvFile            string `cli:"env-file" validate:"required"`
+	Job                string `cli:"job" validate:"required"`
+	Namespace          string `cli:"namespace"`
+	DefaultImage       string `cli:"default-image"`
+	AgentImage         string `cli:"agent-image" validate:"required"`
+	Kubectl            string `cli:"kubectl"`
+	NoColor            bool   `cli:"no-color"`
+	ColorTheme         string `cli:"color-theme"`
+	LogFormat          string `cli:"log-format"`
+	LogLevels          string `cli:"log-levels"`
+	LogTimestampFormat string `cli:"log-timestamp-format"`
+	LogTimezone        string `cli:"log-timezone"`
+	Debug              bool   `cli:"debug"`
+}
+
+var KubernetesBootstrapCommand = cli.Command{
+	Name:        "kubernetes-bootstrap",
+	Usage:       "Run a Buildkite job in a Kubernetes pod",
+	Description: KubernetesBootstrapHelpDescription,
+	Hidden:      true,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "env-file",
+			Value:  "",
+			Usage:  "A JSON file with the job's environment",
+			EnvVar: "BUILDKITE_KUBERNETES_ENV_FILE",
+		},
+		cli.StringFlag{
+			Name:   "job",
+			Value:  "",
+			Usage:  "The ID of the job",
+			EnvVar: "BUILDKITE_JOB_ID",
+		},
+		cli.StringFlag{
+			Name:   "namespace",
+			Value:  "default",
+			Usage:  "The namespace to create the job's pod in",
+			EnvVar: "BUILDKITE_KUBERNETES_NAMESPACE",
+		},
+		cli.StringFlag{
+			Name:   "default-image",
+			Value:  "",
+			Usage:  "The image to run jobs in when their step doesn't choose one",
+			EnvVar: "BUILDKITE_KUBERNETES_DEFAULT_IMAGE",
+		},
+		cli.StringFlag{
+			Name:   "agent-image",
+			Value:  "",
+			Usage:  "An image with the agent in it, which is copied into the job's pod",
+			EnvVar: "BUILDKITE_KUBERNETES_AGENT_IMAGE",
+		},
+		cli.StringFlag{
+			Name:   "kubectl",
+			Value:  "kubectl",
+			Usage:  "The kubectl binary to use",
+			EnvVar: "BUILDKITE_KUBERNETES_KUBECTL",
+		},
+		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
+		DebugFlag,
+	},
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := KubernetesBootstrapConfig{}
+
+		// Load the configuration
+		if err := cliconfig.Load(c, &cfg); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(cfg)
+
+		data, err := ioutil.ReadFile(cfg.EnvFile)
+		if err != nil {
+			logger.Fatal("Failed to read the job's env: %v", err)
+		}
+
+		var env map[string]string
+		if err := json.Unmarshal(data, &env); err != nil {
+			logger.Fatal("Failed to parse the job's env: %v", err)
+		}
+
+		// Deleting the pod when the agent cancels the job is what stops
+		// it, so signals cancel the run rather than killing this process
+		ctx, cancel := context.WithCancel(context.Background())
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			logger.Info("Received %s, stopping the job's pod", sig)
+			cancel()
+		}()
+
+		runner := &kubernetes.Runner{Kubectl: cfg.Kubectl, Stdout: os.Stdout}
+
+		exitStatus, err := runner.Run(ctx, kubernetes.PodConfig{
+			JobID:        cfg.Job,
+			Namespace:    cfg.Namespace,
+			DefaultImage: cfg.DefaultImage,
+			AgentImage:   cfg.AgentImage,
+			Env:          env,
+		})
+		if err != nil {
+			logger.Error("Failed to run the job in Kubernetes: %v", err)
+			os.Exit(1)
+		}
+
+		os.Exit(exitStatus)
+	},
+}
@@ -21,18 +21,30 @@ Description:
    The command exits with a status of 0 if the key has been set, or it will
    exit with a status of 100 if the key doesn't exist.
 
+   Keys that exist are cached for the rest of the job, so checking the same
+   key again doesn't need another request to Buildkite. Use --no-cache to
+   always check with Buildkite.
+
 Example:
 
    $ buildkite-agent meta-data exists "foo"`
 
 type MetaDataExistsConfig struct {
-	Key              string `cli:"arg:0" label:"meta-data key" validate:"required"`
-	Job              string `cli:"job" validate:"required"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
-	Endpoint         string `cli:"endpoint" validate:"required"`
-	NoColor          bool   `cli:"no-color"`
-	Debug            bool   `cli:"debug"`
-	DebugHTTP        bool   `cli:"debug-http"`
+	Key                      string `cli:"arg:0" label:"meta-data key" validate:"required"`
+	Job                      string `cli:"job" validate:"required"`
+	CacheFile                string `cli:"cache-file"`
+	NoCache                  bool   `cli:"no-cache"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
 }
 
 var MetaDataExistsCommand = cli.Command{
@@ -46,9 +58,18 @@ var MetaDataExistsCommand = cli.Command{
 			Usage:  "Which job should the meta-data be checked for",
 			EnvVar: "BUILDKITE_JOB_ID",
 		},
+		MetaDataCacheFileFlag,
+		MetaDataNoCacheFlag,
 		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
 		EndpointFlag,
+		ConfigFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 	},
@@ -64,6 +85,15 @@ var MetaDataExistsCommand = cli.Command{
 		// Setup the any global configuration options
 		HandleGlobalFlags(cfg)
 
+		// A key that's been read or set can't stop existing
+		cache := agent.MetaDataCache{Path: cfg.CacheFile}
+		if !cfg.NoCache {
+			if cache.Exists(cfg.Job, cfg.Key) {
+				logger.Debug("Meta-data `%s` exists in the cache", cfg.Key)
+				return
+			}
+		}
+
 		// Create the API client
 		client := agent.APIClient{
 			Endpoint: cfg.Endpoint,
@@ -73,18 +103,14 @@ var MetaDataExistsCommand = cli.Command{
 		// Find the meta data value
 		var err error
 		var exists *api.MetaDataExists
-		var resp *api.Response
 		err = retry.Do(func(s *retry.Stats) error {
-			exists, resp, err = client.MetaData.Exists(cfg.Job, cfg.Key)
-			if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404) {
-				s.Break()
-			}
-			if err != nil {
+			exists, _, err = client.MetaData.Exists(cfg.Job, cfg.Key)
+			if err != nil && !api.IsPermanentError(err) {
 				logger.Warn("%s (%s)", err, s)
 			}
 
 			return err
-		}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+		}, &retry.Config{Maximum: 10, Interval: 5 * time.Second, IsPermanent: api.IsPermanentError})
 		if err != nil {
 			logger.Fatal("Failed to see if meta-data exists: %s", err)
 		}
@@ -93,5 +119,9 @@ var MetaDataExistsCommand = cli.Command{
 		if !exists.Exists {
 			os.Exit(100)
 		}
+
+		if err := cache.SetExists(cfg.Job, cfg.Key); err != nil {
+			logger.Debug("Failed to cache meta-data: %v", err)
+		}
 	},
 }
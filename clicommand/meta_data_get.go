@@ -1,7 +1,10 @@
 package clicommand
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"text/template"
 	"time"
 
 	"github.com/buildkite/agent/agent"
@@ -20,19 +23,38 @@ Description:
 
    Get data from a builds key/value store.
 
+   The value is printed as-is by default. Use --format to print it using a Go
+   template instead, where {{.Key}} and {{.Value}} are the key and value of the
+   meta-data, or "json" to print both as a JSON object.
+
+   Values are cached for the rest of the job, so getting the same key again
+   doesn't need another request to Buildkite. Use --no-cache to get the
+   latest value, like when another job might have changed it.
+
 Example:
 
-   $ buildkite-agent meta-data get "foo"`
+   $ buildkite-agent meta-data get "foo"
+   $ buildkite-agent meta-data get "foo" --format 'foo={{.Value}}'
+   $ buildkite-agent meta-data get "foo" --format json`
 
 type MetaDataGetConfig struct {
-	Key              string `cli:"arg:0" label:"meta-data key" validate:"required"`
-	Default          string `cli:"default"`
-	Job              string `cli:"job" validate:"required"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
-	Endpoint         string `cli:"endpoint" validate:"required"`
-	NoColor          bool   `cli:"no-color"`
-	Debug            bool   `cli:"debug"`
-	DebugHTTP        bool   `cli:"debug-http"`
+	Key                      string `cli:"arg:0" label:"meta-data key" validate:"required"`
+	Default                  string `cli:"default"`
+	Format                   string `cli:"format"`
+	Job                      string `cli:"job" validate:"required"`
+	CacheFile                string `cli:"cache-file"`
+	NoCache                  bool   `cli:"no-cache"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
 }
 
 var MetaDataGetCommand = cli.Command{
@@ -45,15 +67,29 @@ var MetaDataGetCommand = cli.Command{
 			Value: "",
 			Usage: "If the meta-data value doesn't exist return this instead",
 		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "",
+			Usage: "Print the meta-data using a Go template, or \"json\" to print it as a JSON object",
+		},
 		cli.StringFlag{
 			Name:   "job",
 			Value:  "",
 			Usage:  "Which job should the meta-data be retrieved from",
 			EnvVar: "BUILDKITE_JOB_ID",
 		},
+		MetaDataCacheFileFlag,
+		MetaDataNoCacheFlag,
 		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
 		EndpointFlag,
+		ConfigFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 	},
@@ -69,6 +105,22 @@ var MetaDataGetCommand = cli.Command{
 		// Setup the any global configuration options
 		HandleGlobalFlags(cfg)
 
+		// Parse the format up front so a broken template doesn't wait
+		// for the API before failing
+		format, err := parseMetaDataFormat(cfg.Format)
+		if err != nil {
+			logger.Fatal("Invalid format: %s", err)
+		}
+
+		cache := agent.MetaDataCache{Path: cfg.CacheFile}
+		if !cfg.NoCache {
+			if value, ok := cache.Get(cfg.Job, cfg.Key); ok {
+				logger.Debug("Using the cached value of meta-data `%s`", cfg.Key)
+				printMetaData(format, cfg.Key, value)
+				return
+			}
+		}
+
 		// Create the API client
 		client := agent.APIClient{
 			Endpoint: cfg.Endpoint,
@@ -77,21 +129,15 @@ var MetaDataGetCommand = cli.Command{
 
 		// Find the meta data value
 		var metaData *api.MetaData
-		var err error
 		var resp *api.Response
 		err = retry.Do(func(s *retry.Stats) error {
 			metaData, resp, err = client.MetaData.Get(cfg.Job, cfg.Key)
-			// Don't bother retrying if the response was one of these statuses
-			if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 400) {
-				s.Break()
-				return err
-			}
-			if err != nil {
+			if err != nil && !api.IsPermanentError(err) {
 				logger.Warn("%s (%s)", err, s)
 			}
 
 			return err
-		}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+		}, &retry.Config{Maximum: 10, Interval: 5 * time.Second, IsPermanent: api.IsPermanentError})
 
 		// Deal with the error if we got one
 		if err != nil {
@@ -104,14 +150,79 @@ var MetaDataGetCommand = cli.Command{
 			if resp.StatusCode == 404 && c.IsSet("default") {
 				logger.Warn("No meta-data value exists with key `%s`, returning the supplied default \"%s\"", cfg.Key, cfg.Default)
 
-				fmt.Print(cfg.Default)
+				printMetaData(format, cfg.Key, cfg.Default)
 				return
 			} else {
 				logger.Fatal("Failed to get meta-data: %s", err)
 			}
 		}
 
+		if err := cache.Set(cfg.Job, cfg.Key, metaData.Value); err != nil {
+			logger.Debug("Failed to cache meta-data: %v", err)
+		}
+
 		// Output the value to STDOUT
-		fmt.Print(metaData.Value)
+		printMetaData(format, cfg.Key, metaData.Value)
 	},
 }
+
+var MetaDataCacheFileFlag = cli.StringFlag{
+	Name:   "cache-file",
+	Value:  "",
+	Usage:  "The file meta-data values are cached in for the job, which the agent sets up",
+	EnvVar: "BUILDKITE_META_DATA_CACHE_FILE",
+}
+
+var MetaDataNoCacheFlag = cli.BoolFlag{
+	Name:   "no-cache",
+	Usage:  "Always ask Buildkite for the meta-data, rather than using a value cached earlier in the job",
+	EnvVar: "BUILDKITE_META_DATA_NO_CACHE",
+}
+
+// parseMetaDataFormat returns the template that meta-data is printed with,
+// which is nil when the value should be printed as-is
+func parseMetaDataFormat(format string) (*template.Template, error) {
+	switch format {
+	case "":
+		return nil, nil
+	case "json":
+		format = "{{json .}}"
+	}
+
+	return template.New("format").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(format)
+}
+
+func printMetaData(format *template.Template, key string, value string) {
+	output, err := formatMetaData(format, key, value)
+	if err != nil {
+		logger.Fatal("Failed to format meta-data: %s", err)
+	}
+
+	fmt.Print(output)
+}
+
+// formatMetaData returns the value as it's printed with the format, which is
+// the value on its own when there isn't one
+func formatMetaData(format *template.Template, key string, value string) (string, error) {
+	if format == nil {
+		return value, nil
+	}
+
+	// Don't leave out empty values like the API type does
+	data := struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}{key, value}
+
+	var buf bytes.Buffer
+	if err := format.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,50 @@
+package clicommand
+
+import "testing"
+
+func TestFormatMetaData(t *testing.T) {
+	for _, tc := range []struct {
+		format   string
+		value    string
+		expected string
+	}{
+		{"", "llamas", "llamas"},
+		{"", "", ""},
+		{"json", "llamas", `{"key":"animal","value":"llamas"}`},
+		{"json", "", `{"key":"animal","value":""}`},
+		{"{{.Key}}={{.Value}}", "llamas", "animal=llamas"},
+		{"{{json .Value}}", `"quoted"`, `"\"quoted\""`},
+	} {
+		format, err := parseMetaDataFormat(tc.format)
+		if err != nil {
+			t.Errorf("parseMetaDataFormat(%q) = %v", tc.format, err)
+			continue
+		}
+
+		actual, err := formatMetaData(format, "animal", tc.value)
+		if err != nil {
+			t.Errorf("formatMetaData(%q, %q) = %v", tc.format, tc.value, err)
+		} else if actual != tc.expected {
+			t.Errorf("formatMetaData(%q, %q) = %q, expected %q", tc.format, tc.value, actual, tc.expected)
+		}
+	}
+}
+
+func TestParseMetaDataFormatWithInvalidFormats(t *testing.T) {
+	for _, format := range []string{"{{.Value", "{{llamas}}", "{{end}}"} {
+		if _, err := parseMetaDataFormat(format); err == nil {
+			t.Errorf("Expected an error parsing %q", format)
+		}
+	}
+}
+
+func TestFormatMetaDataWithUnknownFields(t *testing.T) {
+	format, err := parseMetaDataFormat("{{.Llamas}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := formatMetaData(format, "animal", "llamas"); err == nil {
+		t.Fatal("Expected an error formatting a field that doesn't exist")
+	}
+}
@@ -31,14 +31,21 @@ Example:
    $ ./script/meta-data-generator | buildkite-agent meta-data set "foo"`
 
 type MetaDataSetConfig struct {
-	Key              string `cli:"arg:0" label:"meta-data key" validate:"required"`
-	Value            string `cli:"arg:1" label:"meta-data value"`
-	Job              string `cli:"job" validate:"required"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
-	Endpoint         string `cli:"endpoint" validate:"required"`
-	NoColor          bool   `cli:"no-color"`
-	Debug            bool   `cli:"debug"`
-	DebugHTTP        bool   `cli:"debug-http"`
+	Key                      string `cli:"arg:0" label:"meta-data key" validate:"required"`
+	Value                    string `cli:"arg:1" label:"meta-data value"`
+	Job                      string `cli:"job" validate:"required"`
+	CacheFile                string `cli:"cache-file"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
 }
 
 var MetaDataSetCommand = cli.Command{
@@ -52,9 +59,17 @@ var MetaDataSetCommand = cli.Command{
 			Usage:  "Which job should the meta-data be set on",
 			EnvVar: "BUILDKITE_JOB_ID",
 		},
+		MetaDataCacheFileFlag,
 		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
 		EndpointFlag,
+		ConfigFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 	},
@@ -87,26 +102,31 @@ var MetaDataSetCommand = cli.Command{
 			Token:    cfg.AgentAccessToken,
 		}.Create()
 
-		// Create the meta data to set
+		// Create the meta data to set. The UUID stays the same for
+		// each attempt so retries can be told apart from new changes.
 		metaData := &api.MetaData{
+			UUID:  api.NewUUID(),
 			Key:   cfg.Key,
 			Value: cfg.Value,
 		}
 
 		// Set the meta data
 		err := retry.Do(func(s *retry.Stats) error {
-			resp, err := client.MetaData.Set(cfg.Job, metaData)
-			if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404) {
-				s.Break()
-			}
-			if err != nil {
+			_, err := client.MetaData.Set(cfg.Job, metaData)
+			if err != nil && !api.IsPermanentError(err) {
 				logger.Warn("%s (%s)", err, s)
 			}
 
 			return err
-		}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+		}, &retry.Config{Maximum: 10, Interval: 5 * time.Second, IsPermanent: api.IsPermanentError})
 		if err != nil {
 			logger.Fatal("Failed to set meta-data: %s", err)
 		}
+
+		// Later gets in the job should see the new value
+		cache := agent.MetaDataCache{Path: cfg.CacheFile}
+		if err := cache.Set(cfg.Job, cfg.Key, cfg.Value); err != nil {
+			logger.Debug("Failed to cache meta-data: %v", err)
+		}
 	},
 }
@@ -0,0 +1,210 @@
+package clicommand
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/buildkite/agent/agent"
+	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/retry"
+	"github.com/urfave/cli"
+)
+
+var MetricsHelpDescription = `Usage:
+
+   buildkite-agent metrics [arguments...]
+
+Description:
+
+   Publishes the metrics an autoscaling group needs to scale a fleet of
+   agents to CloudWatch or Stackdriver, for the organization and for each
+   queue:
+
+   ScheduledJobsCount     Jobs waiting for an agent
+   ScheduledJobsWaitTime  How long the oldest scheduled job has waited, in seconds
+   RunningJobsCount       Jobs that are running
+   WaitingJobsCount       Jobs waiting on other jobs
+   IdleAgentCount         Agents that aren't running a job
+   BusyAgentCount         Agents that are running a job
+   TotalAgentCount        Connected agents
+
+   In CloudWatch, queue metrics have a Queue dimension. In Stackdriver,
+   they're custom metrics under custom.googleapis.com/buildkite/ with a queue
+   label.
+
+   It uses an agent registration token, and publishes every --interval seconds
+   until it's stopped, or just once with --interval 0, e.g. from a scheduled
+   function.
+
+Example:
+
+   $ buildkite-agent metrics --token xxx --backend cloudwatch
+   $ buildkite-agent metrics --token xxx --backend stackdriver --stackdriver-project my-project --queue default`
+
+type MetricsConfig struct {
+	Token               string   `cli:"token" from-file:"TokenFromFile" validate:"required"`
+	TokenFromFile       string   `cli:"token-from-file" normalize:"filepath"`
+	Backend             string   `cli:"backend"`
+	Interval            int      `cli:"interval"`
+	Queues              []string `cli:"queue" normalize:"list"`
+	CloudWatchNamespace string   `cli:"cloudwatch-namespace"`
+	StackdriverProject  string   `cli:"stackdriver-project"`
+	Endpoint            string   `cli:"endpoint" validate:"required"`
+	NoColor             bool     `cli:"no-color"`
+	ColorTheme          string   `cli:"color-theme"`
+	LogFormat           string   `cli:"log-format"`
+	LogLevels           string   `cli:"log-levels"`
+	LogTimestampFormat  string   `cli:"log-timestamp-format"`
+	LogTimezone         string   `cli:"log-timezone"`
+	Debug               bool     `cli:"debug"`
+	DebugHTTP           bool     `cli:"debug-http"`
+}
+
+var MetricsCommand = cli.Command{
+	Name:        "metrics",
+	Usage:       "Publish metrics for autoscaling agents to CloudWatch or Stackdriver",
+	Description: MetricsHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "token",
+			Value:  "",
+			Usage:  "Your account agent token, or where to fetch it from (\"aws-ssm:<name>\", \"aws-secrets-manager:<id>\", \"gcp-secret-manager:projects/<project>/secrets/<secret>\" or \"vault:<path>#<field>\")",
+			EnvVar: "BUILDKITE_AGENT_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "token-from-file",
+			Value:  "",
+			Usage:  "A file to read your account agent token from, instead of passing it with --token",
+			EnvVar: "BUILDKITE_AGENT_TOKEN_FILE",
+		},
+		cli.StringFlag{
+			Name:   "backend",
+			Value:  agent.MetricsBackendCloudWatch,
+			Usage:  "Where to publish metrics to, either \"cloudwatch\" or \"stackdriver\"",
+			EnvVar: "BUILDKITE_METRICS_BACKEND",
+		},
+		cli.IntFlag{
+			Name:   "interval",
+			Value:  60,
+			Usage:  "How often to publish metrics, in seconds. 0 publishes them once and exits.",
+			EnvVar: "BUILDKITE_METRICS_INTERVAL",
+		},
+		cli.StringSliceFlag{
+			Name:   "queue",
+			Value:  &cli.StringSlice{},
+			Usage:  "Only publish metrics for these queues, rather than all of them",
+			EnvVar: "BUILDKITE_METRICS_QUEUES",
+		},
+		cli.StringFlag{
+			Name:   "cloudwatch-namespace",
+			Value:  "Buildkite",
+			Usage:  "The CloudWatch namespace to put metrics in",
+			EnvVar: "BUILDKITE_METRICS_CLOUDWATCH_NAMESPACE",
+		},
+		cli.StringFlag{
+			Name:   "stackdriver-project",
+			Value:  "",
+			Usage:  "The Google Cloud project to write metrics to",
+			EnvVar: "BUILDKITE_METRICS_STACKDRIVER_PROJECT",
+		},
+		EndpointFlag,
+		ConfigFlag,
+		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
+		DebugFlag,
+		DebugHTTPFlag,
+	},
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := MetricsConfig{}
+
+		// Load the configuration
+		loader := cliconfig.Loader{CLI: c, Config: &cfg}
+		if err := loader.Load(); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(cfg)
+
+		// The token can refer to a secret manager rather than being the
+		// token itself, e.g. aws-ssm:/buildkite/agent-token
+		token, err := agent.FetchSecret(cfg.Token)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+		cfg.Token = token
+
+		publisher, err := agent.NewMetricsPublisher(cfg.Backend, cfg.CloudWatchNamespace, cfg.StackdriverProject)
+		if err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Create the API client
+		client := agent.APIClient{
+			Endpoint: cfg.Endpoint,
+			Token:    cfg.Token,
+		}.Create()
+
+		publish := func() error {
+			var metrics *api.Metrics
+
+			err := retry.Do(func(s *retry.Stats) error {
+				var err error
+				metrics, _, err = client.Metrics.Get()
+				if err != nil {
+					logger.Warn("%s (%s)", err, s)
+				}
+
+				return err
+			}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, IsPermanent: api.IsPermanentError})
+			if err != nil {
+				return err
+			}
+
+			published := agent.AutoscalingMetrics(metrics, cfg.Queues)
+			if err := publisher.Publish(published); err != nil {
+				return err
+			}
+
+			logger.Info("Published %d metrics for %s to %s", len(published), metrics.Organization.Slug, publisher)
+			return nil
+		}
+
+		if cfg.Interval <= 0 {
+			if err := publish(); err != nil {
+				logger.Fatal("Failed to publish metrics: %v", err)
+			}
+			return
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+		ticker := time.NewTicker(time.Duration(cfg.Interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			// Failures are only logged, so that a blip doesn't stop the
+			// metrics the fleet scales on
+			if err := publish(); err != nil {
+				logger.Error("Failed to publish metrics: %v", err)
+			}
+
+			select {
+			case <-ticker.C:
+			case sig := <-signals:
+				logger.Info("Received %s, stopping", sig)
+				return
+			}
+		}
+	},
+}
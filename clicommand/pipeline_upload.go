@@ -14,7 +14,6 @@ import (
 	"github.com/buildkite/agent/cliconfig"
 	"github.com/buildkite/agent/logger"
 	"github.com/buildkite/agent/retry"
-	"github.com/buildkite/agent/stdin"
 	"github.com/urfave/cli"
 )
 
@@ -45,16 +44,22 @@ Example:
    $ ./script/dynamic_step_generator | buildkite-agent pipeline upload`
 
 type PipelineUploadConfig struct {
-	FilePath         string `cli:"arg:0" label:"upload paths"`
-	Replace          bool   `cli:"replace"`
-	Job              string `cli:"job"`
-	AgentAccessToken string `cli:"agent-access-token"`
-	Endpoint         string `cli:"endpoint" validate:"required"`
-	DryRun           bool   `cli:"dry-run"`
-	NoColor          bool   `cli:"no-color"`
-	NoInterpolation  bool   `cli:"no-interpolation"`
-	Debug            bool   `cli:"debug"`
-	DebugHTTP        bool   `cli:"debug-http"`
+	FilePath                 string `cli:"arg:0" label:"upload paths"`
+	Replace                  bool   `cli:"replace"`
+	Job                      string `cli:"job"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	DryRun                   bool   `cli:"dry-run"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	NoInterpolation          bool   `cli:"no-interpolation"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
 }
 
 var PipelineUploadCommand = cli.Command{
@@ -84,8 +89,16 @@ var PipelineUploadCommand = cli.Command{
 			EnvVar: "BUILDKITE_PIPELINE_NO_INTERPOLATION",
 		},
 		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
 		EndpointFlag,
+		StdinFlag,
+		ConfigFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 	},
@@ -116,7 +129,7 @@ var PipelineUploadCommand = cli.Command{
 			if err != nil {
 				logger.Fatal("Failed to read file: %s", err)
 			}
-		} else if stdin.IsReadable() {
+		} else if shouldReadStdin(c) {
 			logger.Info("Reading pipeline config from STDIN")
 
 			// Actually read the file from STDIN
@@ -219,16 +232,10 @@ var PipelineUploadCommand = cli.Command{
 			_, err = client.Pipelines.Upload(cfg.Job, &api.Pipeline{UUID: uuid, Pipeline: result, Replace: cfg.Replace})
 			if err != nil {
 				logger.Warn("%s (%s)", err, s)
-
-				// 422 responses will always fail no need to retry
-				if apierr, ok := err.(*api.ErrorResponse); ok && apierr.Response.StatusCode == 422 {
-					logger.Error("Unrecoverable error, skipping retries")
-					s.Break()
-				}
 			}
 
 			return err
-		}, &retry.Config{Maximum: 5, Interval: 1 * time.Second})
+		}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, IsPermanent: api.IsPermanentError})
 		if err != nil {
 			logger.Fatal("Failed to upload and process pipeline: %s", err)
 		}
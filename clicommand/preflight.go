@@ -0,0 +1,94 @@
+package clicommand
+
+import (
+	"fmt"
+
+	"github.com/buildkite/agent/agent"
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/logger"
+	"github.com/urfave/cli"
+)
+
+var PreflightDescription = `Usage:
+
+   buildkite-agent preflight [arguments...]
+
+Description:
+
+   Checks that the host has what the agent needs to run jobs:
+
+   build path  The build path can be created and written to
+   git         git is installed
+   ssh         There are ssh keys to check out private repositories with
+   docker      If docker is installed, its daemon can be reached
+   dns         The Agent API endpoint resolves
+   clock       The clock is within 5 minutes of the Agent API's
+
+   It takes the same arguments as "buildkite-agent start", so that it checks
+   the same configuration, and exits with a non-zero status if any checks
+   fail. The ssh and docker checks only warn, as not every job needs them.
+
+   Running "buildkite-agent start --preflight" does the same checks before
+   the agent registers.
+
+Example:
+
+   $ buildkite-agent preflight --config /etc/buildkite-agent/buildkite-agent.cfg`
+
+var PreflightCommand = cli.Command{
+	Name:        "preflight",
+	Usage:       "Checks that the host is set up to run jobs",
+	Description: PreflightDescription,
+	Flags:       AgentStartCommand.Flags,
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := AgentStartConfig{}
+
+		// The token isn't needed for any of the checks, so don't insist on it
+		loader := cliconfig.Loader{
+			CLI:                    c,
+			Config:                 &cfg,
+			DefaultConfigFilePaths: DefaultConfigFilePaths(),
+			SkipValidation:         true,
+		}
+
+		if err := loader.Load(); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(cfg)
+
+		if err := runPreflight(cfg); err != nil {
+			logger.Fatal("%s", err)
+		}
+	},
+}
+
+// runPreflight logs how each of the preflight checks went, and returns an
+// error if any failed
+func runPreflight(cfg AgentStartConfig) error {
+	logger.Info("Running preflight checks")
+
+	results := agent.Preflight{
+		Endpoint:  cfg.Endpoint,
+		BuildPath: cfg.BuildPath,
+	}.Run()
+
+	for _, r := range results {
+		switch {
+		case r.Err != nil && r.Warning:
+			logger.Warn("%s: %v", r.Name, r.Err)
+		case r.Err != nil:
+			logger.Error("%s: %v", r.Name, r.Err)
+		default:
+			logger.Info("%s: %s", r.Name, r.Message)
+		}
+	}
+
+	if agent.PreflightFailed(results) {
+		return fmt.Errorf("Preflight checks failed, fix the problems above and try again")
+	}
+
+	return nil
+}
@@ -29,15 +29,21 @@ Example:
    $ ./script/label-generator | buildkite-agent step update "label"`
 
 type StepUpdateConfig struct {
-	Attribute        string `cli:"arg:0" label:"attribute" validate:"required"`
-	Value            string `cli:"arg:1" label:"value"`
-	Append           bool   `cli:"append"`
-	Job              string `cli:"job" validate:"required"`
-	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
-	Endpoint         string `cli:"endpoint" validate:"required"`
-	NoColor          bool   `cli:"no-color"`
-	Debug            bool   `cli:"debug"`
-	DebugHTTP        bool   `cli:"debug-http"`
+	Attribute                string `cli:"arg:0" label:"attribute" validate:"required"`
+	Value                    string `cli:"arg:1" label:"value"`
+	Append                   bool   `cli:"append"`
+	Job                      string `cli:"job" validate:"required"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
 }
 
 var StepUpdateCommand = cli.Command{
@@ -57,8 +63,15 @@ var StepUpdateCommand = cli.Command{
 			EnvVar: "BUILDKITE_STEP_UPDATE_APPEND",
 		},
 		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
 		EndpointFlag,
+		ConfigFlag,
 		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
 		DebugFlag,
 		DebugHTTPFlag,
 	},
@@ -106,16 +119,13 @@ var StepUpdateCommand = cli.Command{
 
 		// Post the change
 		err := retry.Do(func(s *retry.Stats) error {
-			resp, err := client.Jobs.StepUpdate(cfg.Job, update)
-			if resp != nil && (resp.StatusCode == 400 || resp.StatusCode == 401 || resp.StatusCode == 404) {
-				s.Break()
-			}
-			if err != nil {
+			_, err := client.Jobs.StepUpdate(cfg.Job, update)
+			if err != nil && !api.IsPermanentError(err) {
 				logger.Warn("%s (%s)", err, s)
 			}
 
 			return err
-		}, &retry.Config{Maximum: 10, Interval: 5 * time.Second})
+		}, &retry.Config{Maximum: 10, Interval: 5 * time.Second, IsPermanent: api.IsPermanentError})
 		if err != nil {
 			logger.Fatal("Failed to change step: %s", err)
 		}
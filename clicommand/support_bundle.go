@@ -0,0 +1,208 @@
+package clicommand
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/agent"
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/logger"
+	"github.com/urfave/cli"
+)
+
+var SupportBundleDescription = `Usage:
+
+   buildkite-agent support-bundle [arguments...]
+
+Description:
+
+   Gathers what's needed to work out what's wrong with an agent into a
+   tarball that can be attached to a support request or bug report:
+
+   version.txt      The agent version, OS and architecture
+   config.txt       The configuration "buildkite-agent start" would use
+   environment.txt  The environment the agent is running in
+   checks.txt       Whether the Agent API is reachable and the agent's
+                    directories are writable
+   system/          Disk space, network and OS details
+   logs/            The end of each of the agent's log files
+
+   It takes the same arguments as "buildkite-agent start", so that it looks
+   at the same configuration. Tokens and the passwords and query strings of
+   URLs are redacted, and only the values of BUILDKITE_* environment
+   variables are included. It's still worth looking over the tarball before
+   sending it anywhere.
+
+Example:
+
+   $ buildkite-agent support-bundle --config /etc/buildkite-agent/buildkite-agent.cfg \
+       --log-file /var/log/buildkite-agent.log`
+
+type SupportBundleConfig struct {
+	Output   string   `cli:"output"`
+	LogFiles []string `cli:"log-file" normalize:"list"`
+}
+
+// How much of the end of each log file is included
+const supportBundleLogBytes = 1024 * 1024
+
+// The log files that packages and service managers usually write to
+func defaultSupportBundleLogFiles() []string {
+	if runtime.GOOS == "windows" {
+		return []string{
+			"C:\\buildkite-agent\\buildkite-agent.log",
+		}
+	}
+
+	return []string{
+		"/var/log/buildkite-agent.log",
+		"/usr/local/var/log/buildkite-agent.log",
+		"$HOME/.buildkite-agent/buildkite-agent.log",
+	}
+}
+
+// The commands that describe the disk, network and OS of the host
+func supportBundleCommands() map[string][]string {
+	if runtime.GOOS == "windows" {
+		return map[string][]string{
+			"system/systeminfo.txt": {"systeminfo"},
+			"system/disk.txt":       {"wmic", "logicaldisk", "get", "caption,freespace,size"},
+			"system/network.txt":    {"ipconfig", "/all"},
+		}
+	}
+
+	return map[string][]string{
+		"system/uname.txt":   {"uname", "-a"},
+		"system/disk.txt":    {"df", "-h"},
+		"system/inodes.txt":  {"df", "-i"},
+		"system/network.txt": {"ifconfig", "-a"},
+	}
+}
+
+var SupportBundleCommand = cli.Command{
+	Name:        "support-bundle",
+	Usage:       "Gathers the agent's configuration, logs and environment into a tarball for support requests",
+	Description: SupportBundleDescription,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:   "output",
+			Value:  "",
+			Usage:  "Where to write the tarball (default: buildkite-agent-support-<timestamp>.tar.gz)",
+			EnvVar: "BUILDKITE_SUPPORT_BUNDLE_OUTPUT",
+		},
+		cli.StringSliceFlag{
+			Name:   "log-file",
+			Value:  &cli.StringSlice{},
+			Usage:  "A log file of the agent to include the end of. The usual locations are checked if none are given",
+			EnvVar: "BUILDKITE_SUPPORT_BUNDLE_LOG_FILES",
+		},
+	}, AgentStartCommand.Flags...),
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into these structs
+		cfg := SupportBundleConfig{}
+		agentCfg := AgentStartConfig{}
+
+		if err := cliconfig.Load(c, &cfg); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Load the agent's config the same way `config show` does
+		loader := cliconfig.Loader{
+			CLI:                    c,
+			Config:                 &agentCfg,
+			DefaultConfigFilePaths: DefaultConfigFilePaths(),
+			SkipValidation:         true,
+		}
+
+		if err := loader.Load(); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(agentCfg)
+
+		name := fmt.Sprintf("buildkite-agent-support-%s", time.Now().Format("20060102-150405"))
+		if cfg.Output == "" {
+			cfg.Output = name + ".tar.gz"
+		}
+
+		f, err := os.Create(cfg.Output)
+		if err != nil {
+			logger.Fatal("Failed to create %s: %v", cfg.Output, err)
+		}
+		defer f.Close()
+
+		bundle := agent.NewSupportBundle(f, name)
+
+		add := func(file string, body string) {
+			logger.Info("Adding %s", file)
+			if err := bundle.Add(file, body); err != nil {
+				logger.Fatal("Failed to write %s: %v", cfg.Output, err)
+			}
+		}
+
+		add("version.txt", agent.SupportVersion())
+
+		var config bytes.Buffer
+		writeConfig(&config, &loader, &agentCfg)
+		add("config.txt", config.String())
+
+		add("environment.txt", agent.SupportEnvironment(os.Environ()))
+
+		logger.Info("Checking the Agent API and directories")
+		add("checks.txt", agent.SupportChecks(agentCfg.Endpoint, []string{
+			agentCfg.BuildPath, agentCfg.PluginsPath, os.TempDir(),
+		}))
+
+		commands := supportBundleCommands()
+		files := make([]string, 0, len(commands))
+		for file := range commands {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			command := commands[file]
+			logger.Info("Adding %s", file)
+			if err := bundle.AddCommand(file, command[0], command[1:]...); err != nil {
+				logger.Fatal("Failed to write %s: %v", cfg.Output, err)
+			}
+		}
+
+		logFiles := cfg.LogFiles
+		if len(logFiles) == 0 {
+			for _, path := range defaultSupportBundleLogFiles() {
+				path = os.ExpandEnv(path)
+				if _, err := os.Stat(path); err == nil {
+					logFiles = append(logFiles, path)
+				}
+			}
+		}
+
+		if len(logFiles) == 0 {
+			logger.Warn("No log files found, use --log-file to include them")
+		}
+
+		for _, path := range logFiles {
+			file := "logs/" + strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+			file = strings.Replace(file, ":", "", -1)
+
+			logger.Info("Adding %s", file)
+			if err := bundle.AddFileTail(file, path, supportBundleLogBytes); err != nil {
+				logger.Warn("Failed to add log file %s: %v", path, err)
+			}
+		}
+
+		if err := bundle.Close(); err != nil {
+			logger.Fatal("Failed to write %s: %v", cfg.Output, err)
+		}
+
+		logger.Info("Wrote support bundle to %s", cfg.Output)
+	},
+}
@@ -0,0 +1,174 @@
+package clicommand
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/buildkite/agent/agent"
+	"github.com/buildkite/agent/api"
+	"github.com/buildkite/agent/cliconfig"
+	"github.com/buildkite/agent/logger"
+	"github.com/buildkite/agent/retry"
+	zglob "github.com/mattn/go-zglob"
+	"github.com/urfave/cli"
+)
+
+var TestResultsUploadHelpDescription = `Usage:
+
+   buildkite-agent test-results upload <pattern> [arguments...]
+
+Description:
+
+   Reads the JUnit or xUnit.net XML files that match the pattern and uploads
+   the results of the tests in them to Buildkite.
+
+   With --annotate, a summary of any failed tests is also added to the build
+   as an annotation, so there's no need to write your own.
+
+Example:
+
+   $ buildkite-agent test-results upload "**/junit-*.xml"
+   $ buildkite-agent test-results upload --annotate "tmp/test-reports/*.xml"`
+
+type TestResultsUploadConfig struct {
+	Pattern                  string `cli:"arg:0" label:"test results pattern" validate:"required"`
+	Annotate                 bool   `cli:"annotate"`
+	Job                      string `cli:"job" validate:"required"`
+	AgentAccessToken         string `cli:"agent-access-token" from-file:"AgentAccessTokenFromFile" validate:"required"`
+	AgentAccessTokenFromFile string `cli:"agent-access-token-from-file" normalize:"filepath"`
+	Endpoint                 string `cli:"endpoint" validate:"required"`
+	NoColor                  bool   `cli:"no-color"`
+	ColorTheme               string `cli:"color-theme"`
+	LogFormat                string `cli:"log-format"`
+	LogLevels                string `cli:"log-levels"`
+	LogTimestampFormat       string `cli:"log-timestamp-format"`
+	LogTimezone              string `cli:"log-timezone"`
+	Debug                    bool   `cli:"debug"`
+	DebugHTTP                bool   `cli:"debug-http"`
+}
+
+var TestResultsUploadCommand = cli.Command{
+	Name:        "upload",
+	Usage:       "Uploads the results of tests from JUnit or xUnit.net XML files",
+	Description: TestResultsUploadHelpDescription,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:   "annotate",
+			Usage:  "Annotate the build with a summary of the tests that failed",
+			EnvVar: "BUILDKITE_TEST_RESULTS_ANNOTATE",
+		},
+		cli.StringFlag{
+			Name:   "job",
+			Value:  "",
+			Usage:  "Which job the test results are from",
+			EnvVar: "BUILDKITE_JOB_ID",
+		},
+		AgentAccessTokenFlag,
+		AgentAccessTokenFromFileFlag,
+		EndpointFlag,
+		ConfigFlag,
+		NoColorFlag,
+		ColorThemeFlag,
+		LogFormatFlag,
+		LogLevelsFlag,
+		LogTimestampFormatFlag,
+		LogTimezoneFlag,
+		DebugFlag,
+		DebugHTTPFlag,
+	},
+	Action: func(c *cli.Context) {
+		// The configuration will be loaded into this struct
+		cfg := TestResultsUploadConfig{}
+
+		// Load the configuration
+		loader := cliconfig.Loader{CLI: c, Config: &cfg}
+		if err := loader.Load(); err != nil {
+			logger.Fatal("%s", err)
+		}
+
+		// Setup the any global configuration options
+		HandleGlobalFlags(cfg)
+
+		files, err := zglob.Glob(cfg.Pattern)
+		if err != nil {
+			logger.Fatal("Failed to find test results matching \"%s\": %s", cfg.Pattern, err)
+		}
+		if len(files) == 0 {
+			logger.Fatal("No test results found matching \"%s\"", cfg.Pattern)
+		}
+
+		// Parse all the files before sending anything, the results are
+		// uploaded together so the format is whichever came first
+		var cases []api.TestResult
+		var format string
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				logger.Fatal("Failed to read \"%s\": %s", file, err)
+			}
+
+			parsed, f, err := agent.ParseTestResults(data)
+			if err != nil {
+				logger.Fatal("Failed to parse test results in \"%s\": %s", file, err)
+			}
+
+			logger.Debug("Found %d tests in \"%s\"", len(parsed), file)
+
+			if format == "" {
+				format = f
+			}
+			cases = append(cases, parsed...)
+		}
+
+		results := agent.SummarizeTestResults(format, cases)
+
+		logger.Info("Found %d tests in %d files (%d passed, %d failed, %d errors, %d skipped)",
+			results.Tests, len(files), results.Passed, results.Failed, results.Errors, results.Skips)
+
+		// Create the API client
+		client := agent.APIClient{
+			Endpoint: cfg.Endpoint,
+			Token:    cfg.AgentAccessToken,
+		}.Create()
+
+		// Retry the upload a few times before giving up
+		err = retry.Do(func(s *retry.Stats) error {
+			_, err := client.TestResults.Upload(cfg.Job, results)
+			if err != nil && !api.IsPermanentError(err) {
+				logger.Warn("%s (%s)", err, s)
+			}
+
+			return err
+		}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, Jitter: true, IsPermanent: api.IsPermanentError})
+		if err != nil {
+			logger.Fatal("Failed to upload test results: %s", err)
+		}
+
+		logger.Info("Successfully uploaded test results")
+
+		if !cfg.Annotate || results.Failed+results.Errors == 0 {
+			return
+		}
+
+		annotation := &api.Annotation{
+			UUID:    api.NewUUID(),
+			Body:    agent.TestResultsAnnotation(results),
+			Style:   "error",
+			Context: "test-results",
+		}
+
+		err = retry.Do(func(s *retry.Stats) error {
+			_, err := client.Annotations.Create(cfg.Job, annotation)
+			if err != nil && !api.IsPermanentError(err) {
+				logger.Warn("%s (%s)", err, s)
+			}
+
+			return err
+		}, &retry.Config{Maximum: 5, Interval: 1 * time.Second, Jitter: true, IsPermanent: api.IsPermanentError})
+		if err != nil {
+			logger.Fatal("Failed to annotate build with the test results: %s", err)
+		}
+
+		logger.Info("Successfully annotated build with the failed tests")
+	},
+}
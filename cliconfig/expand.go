@@ -0,0 +1,22 @@
+package cliconfig
+
+import (
+	"os"
+	"regexp"
+)
+
+var envReferenceRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} with the value of the environment variable VAR,
+// or ${VAR:-default} with default if VAR isn't set or is empty. Bare $VAR
+// references are left alone, since values like bootstrap scripts are often
+// shell commands that want them.
+func expandEnv(value string) string {
+	return envReferenceRegexp.ReplaceAllStringFunc(value, func(reference string) string {
+		match := envReferenceRegexp.FindStringSubmatch(reference)
+		if envValue := os.Getenv(match[1]); envValue != "" {
+			return envValue
+		}
+		return match[2]
+	})
+}
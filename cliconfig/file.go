@@ -3,7 +3,10 @@ package cliconfig
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/buildkite/agent/utils"
@@ -18,6 +21,20 @@ type File struct {
 }
 
 func (f *File) Load() error {
+	if err := f.load(); err != nil {
+		return err
+	}
+
+	// Expand any ${VAR} references in the values, so the same file can be
+	// used on lots of different hosts
+	for key, value := range f.Config {
+		f.Config[key] = expandEnv(value)
+	}
+
+	return nil
+}
+
+func (f *File) load() error {
 	// Set the default config
 	f.Config = map[string]string{}
 
@@ -36,6 +53,15 @@ func (f *File) Load() error {
 	// Make sure the config file is closed when this function finishes
 	defer file.Close()
 
+	// YAML and TOML files get their own parsers, which understand nested
+	// sections. Everything else is the original key=value format.
+	switch strings.ToLower(filepath.Ext(absolutePath)) {
+	case ".yml", ".yaml":
+		return f.loadWith(file, parseYAML)
+	case ".toml":
+		return f.loadWith(file, parseTOML)
+	}
+
 	// Get all the lines in the file
 	var lines []string
 	scanner := bufio.NewScanner(file)
@@ -58,6 +84,22 @@ func (f *File) Load() error {
 	return nil
 }
 
+func (f *File) loadWith(file *os.File, parse func([]byte) (map[string]string, error)) error {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	config, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.Path, err)
+	}
+
+	f.Config = config
+
+	return nil
+}
+
 func (f File) AbsolutePath() (string, error) {
 	return utils.NormalizeFilePath(f.Path)
 }
@@ -0,0 +1,126 @@
+package cliconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestFile(t *testing.T, name string, contents string) map[string]string {
+	dir, err := ioutil.TempDir("", "cliconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := File{Path: path}
+	if err := f.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Config
+}
+
+func TestLoadingKeyValueFile(t *testing.T) {
+	config := loadTestFile(t, "buildkite-agent.cfg", `
+# A comment
+token="llamas"
+tags="queue=default,os=linux"
+`)
+
+	assert.Equal(t, map[string]string{
+		"token": "llamas",
+		"tags":  "queue=default,os=linux",
+	}, config)
+}
+
+func TestLoadingYAMLFile(t *testing.T) {
+	config := loadTestFile(t, "buildkite-agent.yml", `
+token: llamas
+tags:
+  - queue=default
+  - os=linux
+no-color: true
+log:
+  format: json
+  levels: api=debug
+`)
+
+	assert.Equal(t, map[string]string{
+		"token":      "llamas",
+		"tags":       "queue=default,os=linux",
+		"no-color":   "true",
+		"log-format": "json",
+		"log-levels": "api=debug",
+	}, config)
+}
+
+func TestLoadingTOMLFile(t *testing.T) {
+	config := loadTestFile(t, "buildkite-agent.toml", `
+token = "llamas" # the token
+tags = [
+  "queue=default",
+  "os=linux",
+]
+no-color = true
+priority = 5
+
+[log]
+format = 'json'
+levels = "api=debug"
+`)
+
+	assert.Equal(t, map[string]string{
+		"token":      "llamas",
+		"tags":       "queue=default,os=linux",
+		"no-color":   "true",
+		"priority":   "5",
+		"log-format": "json",
+		"log-levels": "api=debug",
+	}, config)
+}
+
+func TestLoadingInvalidTOMLFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cliconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "buildkite-agent.toml")
+	if err := ioutil.WriteFile(path, []byte("token = llamas\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := File{Path: path}
+	assert.Error(t, f.Load())
+}
+
+func TestLoadingExpandsEnvironmentVariables(t *testing.T) {
+	os.Setenv("CLICONFIG_TEST_TOKEN", "llamas")
+	os.Setenv("CLICONFIG_TEST_HOME", "/home/buildkite")
+	defer os.Unsetenv("CLICONFIG_TEST_TOKEN")
+	defer os.Unsetenv("CLICONFIG_TEST_HOME")
+
+	config := loadTestFile(t, "buildkite-agent.cfg", `
+token="${CLICONFIG_TEST_TOKEN}"
+build-path="${CLICONFIG_TEST_HOME}/builds"
+name="${CLICONFIG_TEST_MISSING:-agent-%n}"
+bootstrap-script="echo $HOME"
+`)
+
+	assert.Equal(t, map[string]string{
+		"token":            "llamas",
+		"build-path":       "/home/buildkite/builds",
+		"name":             "agent-%n",
+		"bootstrap-script": "echo $HOME",
+	}, config)
+}
@@ -1,7 +1,9 @@
 package cliconfig
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"regexp"
@@ -26,8 +28,25 @@ type Loader struct {
 
 	// The file that was used when loading this configuration
 	File *File
+
+	// Where each config value came from (a flag, an env var, the config
+	// file or its default), keyed by the cli name
+	Sources map[string]string
+
+	// Don't fail if a value doesn't pass validation. Useful when you want
+	// to inspect the configuration rather than use it.
+	SkipValidation bool
 }
 
+const (
+	SourceArgument = "argument"
+	SourceFlag     = "flag"
+	SourceEnv      = "env"
+	SourceFile     = "config file"
+	SourceDefault  = "default"
+	SourceSecret   = "secret file"
+)
+
 var argCliNameRegexp = regexp.MustCompile(`arg:(\d+)`)
 
 // A shortcut for loading a config from the CLI
@@ -73,6 +92,8 @@ func (l *Loader) Load() error {
 		}
 	}
 
+	l.Sources = map[string]string{}
+
 	// Now it's onto actually setting the fields. We start by getting all
 	// the fields from the configuration interface
 	var fields []string
@@ -102,6 +123,15 @@ func (l *Loader) Load() error {
 			}
 		}
 
+		// Secrets like tokens can be read from files, so that they don't
+		// need to be in the environment where any process can see them
+		fromFileFieldName, _ := reflections.GetFieldTag(l.Config, fieldName, "from-file")
+		if fromFileFieldName != "" {
+			if err := l.setFieldValueFromFile(fieldName, cliName, fromFileFieldName); err != nil {
+				return err
+			}
+		}
+
 		// Check for field rename deprecations
 		renamedToFieldName, _ := reflections.GetFieldTag(l.Config, fieldName, "deprecated-and-renamed-to")
 		if renamedToFieldName != "" {
@@ -127,6 +157,10 @@ func (l *Loader) Load() error {
 					if err != nil {
 						return fmt.Errorf("Could not set value `%s` to field `%s` (%s)", value, renamedToFieldName, err)
 					}
+
+					if renamedFieldCliName != "" {
+						l.Sources[renamedFieldCliName] = l.Sources[cliName]
+					}
 				}
 			}
 		}
@@ -137,13 +171,13 @@ func (l *Loader) Load() error {
 			// If the deprecated field's value isn't empty, then we
 			// return the deprecation error message.
 			if !l.fieldValueIsEmpty(fieldName) {
-				return fmt.Errorf(deprecationError)
+				return errors.New(deprecationError)
 			}
 		}
 
 		// Perform validations
 		validationRules, _ := reflections.GetFieldTag(l.Config, fieldName, "validate")
-		if validationRules != "" {
+		if validationRules != "" && !l.SkipValidation {
 			// Determine the label for the field
 			label, _ := reflections.GetFieldTag(l.Config, fieldName, "label")
 			if label == "" {
@@ -193,6 +227,7 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 		// the position to exist.
 		if len(l.CLI.Args()) > argIndex {
 			value = l.CLI.Args()[argIndex]
+			l.Sources[cliName] = SourceArgument
 		}
 
 		// Otherwise see if we can pull it from an environment variable
@@ -202,6 +237,7 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 			if err == nil {
 				if envValue, envSet := os.LookupEnv(envName); envSet {
 					value = envValue
+					l.Sources[cliName] = SourceEnv + " " + envName
 				}
 			}
 		}
@@ -225,6 +261,8 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 				} else {
 					return fmt.Errorf("Unable to convert string to type %s", fieldKind)
 				}
+
+				l.Sources[cliName] = SourceFile + " " + l.File.Path
 			}
 		}
 
@@ -242,6 +280,16 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 			} else {
 				return fmt.Errorf("Unable to handle type: %s", fieldKind)
 			}
+
+			// cli.Context#IsSet is also true for values from the environment,
+			// so look at the actual arguments to tell them apart
+			if l.CLI.IsSet(cliName) && flagWasPassed(os.Args, cliName) {
+				l.Sources[cliName] = SourceFlag
+			} else if envVar := l.flagEnvVar(cliName); envVar != "" && os.Getenv(envVar) != "" {
+				l.Sources[cliName] = SourceEnv + " " + envVar
+			} else {
+				l.Sources[cliName] = SourceDefault
+			}
 		}
 	}
 
@@ -256,6 +304,52 @@ func (l Loader) setFieldValueFromCLI(fieldName string, cliName string) error {
 	return nil
 }
 
+// setFieldValueFromFile sets a field to the contents of the file named by
+// another field, if it's set. Files mounted by Kubernetes or systemd's
+// LoadCredential usually end with a newline, so whitespace is trimmed.
+func (l Loader) setFieldValueFromFile(fieldName string, cliName string, fromFileFieldName string) error {
+	fromFileCliName, _ := reflections.GetFieldTag(l.Config, fromFileFieldName, "cli")
+	if fromFileCliName == "" {
+		return fmt.Errorf("The field `%s` named by `%s` needs a cli tag", fromFileFieldName, fieldName)
+	}
+
+	// The file's field may not have been loaded yet
+	if err := l.setFieldValueFromCLI(fromFileFieldName, fromFileCliName); err != nil {
+		return err
+	}
+	if err := l.normalizeField(fromFileFieldName, "filepath"); err != nil {
+		return err
+	}
+
+	value, _ := reflections.GetField(l.Config, fromFileFieldName)
+	path, _ := value.(string)
+	if path == "" {
+		return nil
+	}
+
+	if !l.fieldValueIsEmpty(fieldName) {
+		return fmt.Errorf("Can't set config option `%s=%s` because `%s` has already been set", fromFileCliName, path, cliName)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Could not read `%s` from %s (%s)", cliName, path, err)
+	}
+
+	secret := strings.TrimSpace(string(contents))
+	if secret == "" {
+		return fmt.Errorf("The file %s given for `%s` is empty", path, cliName)
+	}
+
+	if err := reflections.SetField(l.Config, fieldName, secret); err != nil {
+		return fmt.Errorf("Could not set field `%s` (%s)", fieldName, err)
+	}
+
+	l.Sources[cliName] = SourceSecret + " " + path
+
+	return nil
+}
+
 func (l Loader) Errorf(format string, v ...interface{}) error {
 	suffix := fmt.Sprintf(" See: `%s %s --help`", l.CLI.App.Name, l.CLI.Command.Name)
 
@@ -267,25 +361,53 @@ func (l Loader) cliValueIsSet(cliName string) bool {
 		return true
 	} else {
 		// cli.Context#IsSet only checks to see if the command was set via the cli, not
-		// via the environment. So here we do some hacks to find out the name of the
-		// EnvVar, and return true if it was set.
-		for _, flag := range l.CLI.Command.Flags {
-			name, _ := reflections.GetField(flag, "Name")
-			envVar, _ := reflections.GetField(flag, "EnvVar")
-			if name == cliName && envVar != "" {
-				// Make sure envVar is a string
-				if envVarStr, ok := envVar.(string); ok {
-					envVarStr = strings.TrimSpace(string(envVarStr))
-
-					return os.Getenv(envVarStr) != ""
-				}
-			}
+		// via the environment. So here we find out the name of the EnvVar, and return
+		// true if it was set.
+		if envVar := l.flagEnvVar(cliName); envVar != "" {
+			return os.Getenv(envVar) != ""
+		}
+	}
+
+	return false
+}
+
+// flagWasPassed returns true if the flag appears in the command line
+// arguments, either as `--name value` or `--name=value`
+func flagWasPassed(args []string, cliName string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if len(name) == len(arg) {
+			continue
+		}
+
+		if name == cliName || strings.HasPrefix(name, cliName+"=") {
+			return true
 		}
 	}
 
 	return false
 }
 
+// flagEnvVar does some hacks to find the name of the EnvVar for a flag
+func (l Loader) flagEnvVar(cliName string) string {
+	for _, flag := range l.CLI.Command.Flags {
+		name, _ := reflections.GetField(flag, "Name")
+		envVar, _ := reflections.GetField(flag, "EnvVar")
+		if name == cliName && envVar != "" {
+			// Make sure envVar is a string
+			if envVarStr, ok := envVar.(string); ok {
+				return strings.TrimSpace(string(envVarStr))
+			}
+		}
+	}
+
+	return ""
+}
+
 func (l Loader) fieldValueIsEmpty(fieldName string) bool {
 	// We need to use the field kind to determine the type of empty test.
 	value, _ := reflections.GetField(l.Config, fieldName)
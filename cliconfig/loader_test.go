@@ -0,0 +1,84 @@
+package cliconfig
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestFlagWasPassed(t *testing.T) {
+	args := []string{"buildkite-agent", "start", "--token", "llamas", "--name=foo", "--", "--tags"}
+
+	assert.True(t, flagWasPassed(args, "token"))
+	assert.True(t, flagWasPassed(args, "name"))
+	assert.False(t, flagWasPassed(args, "tags"))
+	assert.False(t, flagWasPassed(args, "start"))
+}
+
+type secretConfig struct {
+	Token         string `cli:"token" from-file:"TokenFromFile" validate:"required"`
+	TokenFromFile string `cli:"token-from-file" normalize:"filepath"`
+}
+
+func secretContext(t *testing.T, args []string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("token", "", "")
+	set.String("token-from-file", "", "")
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func TestLoadingSecretsFromFiles(t *testing.T) {
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("llamas\n")
+	f.Close()
+
+	cfg := secretConfig{}
+	l := Loader{CLI: secretContext(t, []string{"--token-from-file", f.Name()}), Config: &cfg}
+
+	assert.NoError(t, l.Load())
+	assert.Equal(t, "llamas", cfg.Token)
+	assert.Equal(t, SourceSecret+" "+f.Name(), l.Sources["token"])
+}
+
+func TestLoadingSecretsFromFilesAndFlagsFails(t *testing.T) {
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("llamas")
+	f.Close()
+
+	cfg := secretConfig{}
+	l := Loader{CLI: secretContext(t, []string{"--token", "alpacas", "--token-from-file", f.Name()}), Config: &cfg}
+
+	assert.Error(t, l.Load())
+}
+
+func TestLoadingSecretsFromEmptyFilesFails(t *testing.T) {
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	cfg := secretConfig{}
+	l := Loader{CLI: secretContext(t, []string{"--token-from-file", f.Name()}), Config: &cfg}
+
+	assert.Error(t, l.Load())
+}
@@ -0,0 +1,177 @@
+package cliconfig
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads a TOML config file. Only the parts of TOML that make sense
+// for agent config are supported: tables, strings, booleans, numbers and
+// arrays of those. Keys inside a table are flattened into the keys the CLI
+// uses, so this:
+//
+//	[log]
+//	format = "json"
+//
+// is the same as `log-format = "json"`
+func parseTOML(data []byte) (map[string]string, error) {
+	config := map[string]string{}
+	section := ""
+	lineNumber := 0
+	pending := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(stripTOMLComment(scanner.Text()))
+
+		// Arrays can be split over multiple lines, so keep collecting
+		// lines until the brackets are balanced
+		if pending != "" {
+			line = pending + " " + line
+			pending = ""
+		}
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && !strings.Contains(line, "=") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("Invalid TOML table on line %d: %s", lineNumber, line)
+			}
+			section = strings.Replace(strings.TrimSpace(strings.Trim(line, "[]")), ".", "-", -1)
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Can't separate key from value on line %d: %s", lineNumber, line)
+		}
+
+		key := strings.Trim(strings.TrimSpace(parts[0]), `"`)
+		if section != "" {
+			key = section + "-" + key
+		}
+
+		raw := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(raw, "[") && strings.Count(raw, "[") > strings.Count(raw, "]") {
+			pending = line
+			continue
+		}
+
+		value, err := parseTOMLValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for `%s` on line %d: %v", key, lineNumber, err)
+		}
+
+		config[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if pending != "" {
+		return nil, fmt.Errorf("Unterminated TOML array: %s", pending)
+	}
+
+	return config, nil
+}
+
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("unterminated array %s", raw)
+		}
+		items := []string{}
+		for _, item := range splitTOMLArray(strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]")) {
+			value, err := parseTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, `'`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `'`) {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+
+	if _, err := strconv.ParseFloat(strings.Replace(raw, "_", "", -1), 64); err == nil {
+		return strings.Replace(raw, "_", "", -1), nil
+	}
+
+	return "", fmt.Errorf("unsupported value %s", raw)
+}
+
+// splitTOMLArray splits the inside of an array on commas that aren't
+// inside a string
+func splitTOMLArray(s string) []string {
+	var items []string
+	var current strings.Builder
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			if item := strings.TrimSpace(current.String()); item != "" {
+				items = append(items, item)
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+
+	if item := strings.TrimSpace(current.String()); item != "" {
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// stripTOMLComment removes anything after a # that isn't inside a string
+func stripTOMLComment(line string) string {
+	var quote rune
+	escaped := false
+
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
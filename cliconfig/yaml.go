@@ -0,0 +1,66 @@
+package cliconfig
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/buildkite/yaml"
+)
+
+// parseYAML reads a YAML config file. Nested sections are flattened into
+// the same keys the CLI uses, so this:
+//
+//	log:
+//	  format: json
+//
+// is the same as `log-format: json`
+func parseYAML(data []byte) (map[string]string, error) {
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("Failed to parse YAML config: %v", err)
+	}
+
+	config := map[string]string{}
+	for key, value := range parsed {
+		if err := flattenValue(key, value, config); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// flattenValue adds a value to the config, recursing into nested sections
+// and joining their keys with a dash
+func flattenValue(key string, value interface{}, config map[string]string) error {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		for nestedKey, nestedValue := range v {
+			if err := flattenValue(key+"-"+fmt.Sprint(nestedKey), nestedValue, config); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for nestedKey, nestedValue := range v {
+			if err := flattenValue(key+"-"+nestedKey, nestedValue, config); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			switch item.(type) {
+			case map[interface{}]interface{}, map[string]interface{}, []interface{}:
+				return fmt.Errorf("Config option `%s` can only be a list of plain values", key)
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		config[key] = strings.Join(items, ",")
+	case nil:
+		config[key] = ""
+	default:
+		config[key] = fmt.Sprint(v)
+	}
+
+	return nil
+}
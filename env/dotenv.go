@@ -0,0 +1,114 @@
+package env
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var dotenvKeyRegex = regexp.MustCompile(`\A[a-zA-Z_][a-zA-Z0-9_.]*\z`)
+
+// FromDotenv parses environment variables from a .env file, which looks like
+// this:
+//
+//     # Comments and blank lines are ignored
+//     export DATABASE_URL=postgres://localhost/app
+//     GREETING="hello\nworld"   # escapes work in double quotes
+//     PATTERN='$literal\n'      # but not in single quotes
+//     CERT="-----BEGIN CERTIFICATE-----
+//     ...
+//     -----END CERTIFICATE-----"
+//
+// Values are used as they are, without expanding other variables in them.
+func FromDotenv(body string) (*Environment, error) {
+	env := &Environment{env: make(map[string]string)}
+
+	body = strings.Replace(body, "\r\n", "\n", -1)
+
+	for lineNumber := 1; body != ""; lineNumber++ {
+		var line string
+		if i := strings.IndexByte(body, '\n'); i >= 0 {
+			line, body = body[:i], body[i+1:]
+		} else {
+			line, body = body, ""
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		trimmed = strings.TrimPrefix(trimmed, "export ")
+
+		parts := strings.SplitN(trimmed, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Line %d isn't in the form KEY=value", lineNumber)
+		}
+
+		key := strings.TrimSpace(parts[0])
+		if !dotenvKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("Line %d has an invalid name %q", lineNumber, key)
+		}
+
+		value := strings.TrimLeft(parts[1], " \t")
+
+		switch {
+		case strings.HasPrefix(value, `"`), strings.HasPrefix(value, `'`):
+			// Quoted values can carry on over more lines
+			quote := value[0]
+			rest := value[1:] + "\n" + body
+			end := closingQuote(rest, quote)
+			if end < 0 {
+				return nil, fmt.Errorf("Line %d has an unterminated %c quote", lineNumber, quote)
+			}
+
+			quoted := rest[:end]
+			lineNumber += strings.Count(quoted, "\n")
+
+			// Whatever follows the closing quote on its line is
+			// ignored, which is usually a comment
+			after := rest[end+1:]
+			if i := strings.IndexByte(after, '\n'); i >= 0 {
+				body = after[i+1:]
+			} else {
+				body = ""
+			}
+
+			if quote == '"' {
+				quoted = unescapeDotenv(quoted)
+			}
+			value = quoted
+
+		default:
+			// Unquoted values end at a comment
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			}
+			value = strings.TrimSpace(value)
+		}
+
+		env.Set(key, value)
+	}
+
+	return env, nil
+}
+
+// closingQuote returns the index of the quote that ends a quoted value, which
+// for double quotes skips any that are escaped
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+var dotenvEscapes = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`)
+
+func unescapeDotenv(s string) string {
+	return dotenvEscapes.Replace(s)
+}
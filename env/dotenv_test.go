@@ -0,0 +1,57 @@
+package env
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromDotenv(t *testing.T) {
+	var lines = []string{
+		`# A comment`,
+		``,
+		`PLAIN=hello world`,
+		`export EXPORTED=yes`,
+		`  SPACED = padded  `,
+		`COMMENTED=value # a comment`,
+		`HASH=no#comment`,
+		`DOUBLE="escaped\nnewline \"quoted\""`,
+		`SINGLE='$literal\n' # a comment`,
+		`MULTILINE="first`,
+		`second"`,
+		`EMPTY=`,
+		`LAST=after multiline`,
+	}
+
+	env, err := FromDotenv(strings.Join(lines, "\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, map[string]string{
+		"PLAIN":     "hello world",
+		"EXPORTED":  "yes",
+		"SPACED":    "padded",
+		"COMMENTED": "value",
+		"HASH":      "no#comment",
+		"DOUBLE":    "escaped\nnewline \"quoted\"",
+		"SINGLE":    `$literal\n`,
+		"MULTILINE": "first\nsecond",
+		"EMPTY":     "",
+		"LAST":      "after multiline",
+	}, env.ToMap())
+}
+
+func TestFromDotenvReturnsErrorsWithLineNumbers(t *testing.T) {
+	for body, expected := range map[string]string{
+		"A=1\nB=\"two\nlines\"\nnot a variable": "Line 4 isn't in the form KEY=value",
+		"1BAD=value":                             `Line 1 has an invalid name "1BAD"`,
+		"A='unterminated":                        "Line 1 has an unterminated ' quote",
+	} {
+		_, err := FromDotenv(body)
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q for %q, got %v", expected, body, err)
+		}
+	}
+}
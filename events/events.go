@@ -0,0 +1,121 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// The types of events that are emitted over the course of running a job
+const (
+	JobAccepted      = "job.accepted"
+	JobStarted       = "job.started"
+	PhaseFinished    = "job.phase_finished"
+	ArtifactUploaded = "artifact.uploaded"
+	JobRetrying      = "job.retrying"
+	JobIdle          = "job.idle"
+	JobFinished      = "job.finished"
+)
+
+// How many events can be waiting to be sent before new ones are dropped
+const queueSize = 100
+
+// Event is something that happened on the agent that external systems might
+// want to react to
+type Event struct {
+	Type      string            `json:"type"`
+	Timestamp time.Time         `json:"timestamp"`
+	Agent     string            `json:"agent,omitempty"`
+	JobID     string            `json:"job_id,omitempty"`
+	Data      map[string]string `json:"data,omitempty"`
+}
+
+// Sink is somewhere that events are sent to
+type Sink interface {
+	Send(e Event) error
+	String() string
+}
+
+// Bus delivers events to all its sinks in the order they were emitted. Events
+// are sent in the background so a slow sink doesn't hold up the job.
+type Bus struct {
+	sinks []Sink
+	queue chan Event
+	done  chan struct{}
+
+	// Guards the queue being closed, so that events emitted late, like from
+	// line callbacks during shutdown, are dropped rather than sent on it
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewBus creates a bus and starts delivering events to the sinks
+func NewBus(sinks ...Sink) *Bus {
+	b := &Bus{
+		sinks: sinks,
+		queue: make(chan Event, queueSize),
+		done:  make(chan struct{}),
+	}
+
+	go b.deliver()
+
+	return b
+}
+
+// Emit queues the event to be sent to every sink. It's safe to call on a nil
+// Bus, which makes emitting events optional for callers.
+func (b *Bus) Emit(e Event) {
+	if b == nil || len(b.sinks) == 0 {
+		return
+	}
+
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		logger.Debug("[Events] Dropping %s event, as the event bus has been closed", e.Type)
+		return
+	}
+
+	select {
+	case b.queue <- e:
+	default:
+		logger.Warn("[Events] Too many events waiting to be sent, dropping %s event", e.Type)
+	}
+}
+
+// Close waits for any queued events to be sent. Events emitted after that
+// are dropped.
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	<-b.done
+}
+
+func (b *Bus) deliver() {
+	defer close(b.done)
+
+	for e := range b.queue {
+		for _, sink := range b.sinks {
+			if err := sink.Send(e); err != nil {
+				logger.Warn("[Events] Failed to send %s event to %s: %v", e.Type, sink, err)
+			} else {
+				logger.Debug("[Events] Sent %s event to %s", e.Type, sink)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusSendsEventsToFileSinkInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.json")
+
+	bus := NewBus(&FileSink{Path: path})
+	bus.Emit(Event{Type: JobStarted, JobID: "llamas"})
+	bus.Emit(Event{Type: JobFinished, JobID: "llamas", Data: map[string]string{"exit_status": "0"}})
+	bus.Close()
+
+	contents, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	assert.Len(t, lines, 2)
+
+	var e Event
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &e))
+	assert.Equal(t, JobFinished, e.Type)
+	assert.Equal(t, "0", e.Data["exit_status"])
+	assert.False(t, e.Timestamp.IsZero())
+}
+
+func TestWebhookSinkPostsJSON(t *testing.T) {
+	var received Event
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(server.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Send(Event{Type: JobAccepted, JobID: "alpacas"}))
+	assert.Equal(t, "alpacas", received.JobID)
+}
+
+func TestNewSinkRejectsUnknownSpecs(t *testing.T) {
+	_, err := NewSink("ftp://example.com")
+	assert.Error(t, err)
+}
+
+func TestEmitOnNilBusIsANoop(t *testing.T) {
+	var bus *Bus
+	bus.Emit(Event{Type: JobStarted})
+	bus.Close()
+}
+
+func TestEmitAfterCloseIsDropped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.json")
+
+	bus := NewBus(&FileSink{Path: path})
+	bus.Emit(Event{Type: JobStarted, JobID: "llamas"})
+	bus.Close()
+
+	bus.Emit(Event{Type: JobFinished, JobID: "llamas"})
+	bus.Close()
+
+	contents, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(contents)), "\n"), 1)
+}
@@ -0,0 +1,144 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildkite/shellwords"
+)
+
+// ParseSinks creates a sink for each of the specs, see NewSink
+func ParseSinks(specs []string) ([]Sink, error) {
+	sinks := []Sink{}
+
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+
+		sink, err := NewSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
+
+// NewSink creates a sink from a string like "https://example.com/hook",
+// "file:///var/log/buildkite-events.json" or "exec:/usr/local/bin/notify"
+func NewSink(spec string) (Sink, error) {
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &WebhookSink{URL: spec}, nil
+	case strings.HasPrefix(spec, "file://"):
+		return &FileSink{Path: strings.TrimPrefix(spec, "file://")}, nil
+	case strings.HasPrefix(spec, "exec:"):
+		return &CommandSink{Command: strings.TrimPrefix(spec, "exec:")}, nil
+	default:
+		return nil, fmt.Errorf("Invalid event sink `%s`, expected a http(s):// URL, file:// path or exec: command", spec)
+	}
+}
+
+// WebhookSink POSTs each event as JSON to a URL
+type WebhookSink struct {
+	URL string
+}
+
+func (s *WebhookSink) Send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	resp, err := client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", s.URL, resp.Status)
+	}
+
+	return nil
+}
+
+func (s *WebhookSink) String() string {
+	return s.URL
+}
+
+// FileSink appends each event as a line of JSON to a file
+type FileSink struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *FileSink) Send(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileSink) String() string {
+	return "file://" + s.Path
+}
+
+// CommandSink runs a command for each event, with the event as JSON on
+// STDIN and its type in $BUILDKITE_EVENT_TYPE
+type CommandSink struct {
+	Command string
+}
+
+func (s *CommandSink) Send(e Event) error {
+	args, err := shellwords.Split(s.Command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("No command to run")
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(), "BUILDKITE_EVENT_TYPE="+e.Type)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func (s *CommandSink) String() string {
+	return "exec:" + s.Command
+}
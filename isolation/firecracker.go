@@ -0,0 +1,268 @@
+package isolation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// The kernel command line for job VMs. The init is expected to read the
+// job's env from /dev/vdb, run the bootstrap with it, write the exit status
+// to the start of /dev/vdc and then reboot, which stops the VM.
+//
+// The exit status has its own drive rather than going to the console, as the
+// job can write anything it likes to the console. The init has to run the
+// bootstrap as a user that can't write to /dev/vdc for the same reason.
+const firecrackerBootArgs = "console=ttyS0 reboot=k panic=1 pci=off init=/sbin/buildkite-init"
+
+func init() {
+	Register("firecracker", NewFirecracker)
+}
+
+// Firecracker boots a microVM for each job using Firecracker, see
+// https://github.com/firecracker-microvm/firecracker. Each VM gets its own
+// copy of the root filesystem, so nothing a job does outlives it.
+type Firecracker struct {
+	// The firecracker binary to use
+	Binary string
+
+	// An uncompressed Linux kernel to boot
+	Kernel string
+
+	// An ext4 root filesystem with the agent and an init that runs the job
+	RootFS string
+
+	// How many CPUs and how much memory each VM gets
+	VCPUs     int
+	MemoryMiB int
+
+	// A tap device to give the VM a network interface on, if any
+	TapDevice string
+}
+
+// NewFirecracker creates a Firecracker backend from the options kernel,
+// rootfs, firecracker, vcpus, memory (in MiB) and tap
+func NewFirecracker(options map[string]string) (Backend, error) {
+	f := &Firecracker{
+		Binary:    "firecracker",
+		Kernel:    options["kernel"],
+		RootFS:    options["rootfs"],
+		VCPUs:     2,
+		MemoryMiB: 2048,
+		TapDevice: options["tap"],
+	}
+
+	if f.Kernel == "" || f.RootFS == "" {
+		return nil, fmt.Errorf("The firecracker isolation backend needs both the kernel and rootfs options")
+	}
+
+	if binary, ok := options["firecracker"]; ok {
+		f.Binary = binary
+	}
+
+	for key, value := range map[string]*int{"vcpus": &f.VCPUs, "memory": &f.MemoryMiB} {
+		if s, ok := options[key]; ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("Invalid firecracker %s option %q", key, s)
+			}
+			*value = n
+		}
+	}
+
+	for key := range options {
+		switch key {
+		case "kernel", "rootfs", "firecracker", "vcpus", "memory", "tap":
+		default:
+			return nil, fmt.Errorf("Unknown firecracker option %q", key)
+		}
+	}
+
+	return f, nil
+}
+
+// Run boots the job's VM and waits for it to stop
+func (f *Firecracker) Run(ctx context.Context, job Job, stdout io.Writer) (int, error) {
+	dir, err := ioutil.TempDir("", "buildkite-firecracker-"+job.ID)
+	if err != nil {
+		return -1, err
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs.ext4")
+	if err := copyFile(f.RootFS, rootfs); err != nil {
+		return -1, fmt.Errorf("Failed to copy the root filesystem: %v", err)
+	}
+
+	jobDisk := filepath.Join(dir, "job.img")
+	if err := writeJobDisk(jobDisk, job.GuestEnv()); err != nil {
+		return -1, fmt.Errorf("Failed to write the job's disk: %v", err)
+	}
+
+	statusDisk := filepath.Join(dir, "status.img")
+	if err := ioutil.WriteFile(statusDisk, make([]byte, 512), 0600); err != nil {
+		return -1, fmt.Errorf("Failed to write the job's status disk: %v", err)
+	}
+
+	config, err := json.Marshal(f.vmConfig(rootfs, jobDisk, statusDisk))
+	if err != nil {
+		return -1, err
+	}
+
+	configFile := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(configFile, config, 0600); err != nil {
+		return -1, err
+	}
+
+	logger.Info("[Firecracker] Booting a VM for job %s", job.ID)
+	cmd := exec.CommandContext(ctx, f.Binary, "--no-api", "--config-file", configFile)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stdout
+
+	err = cmd.Run()
+
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+
+	if status, ok, statusErr := readStatusDisk(statusDisk); statusErr != nil {
+		return -1, fmt.Errorf("Failed to read the job's exit status: %v", statusErr)
+	} else if ok {
+		return status, nil
+	}
+
+	if err != nil {
+		return -1, fmt.Errorf("Firecracker failed: %v", err)
+	}
+
+	return -1, fmt.Errorf("The VM stopped without reporting the job's exit status")
+}
+
+// The parts of Firecracker's configuration file that we use
+type firecrackerConfig struct {
+	BootSource        firecrackerBootSource         `json:"boot-source"`
+	Drives            []firecrackerDrive            `json:"drives"`
+	MachineConfig     firecrackerMachineConfig      `json:"machine-config"`
+	NetworkInterfaces []firecrackerNetworkInterface `json:"network-interfaces,omitempty"`
+}
+
+type firecrackerBootSource struct {
+	KernelImagePath string `json:"kernel_image_path"`
+	BootArgs        string `json:"boot_args"`
+}
+
+type firecrackerDrive struct {
+	DriveID      string `json:"drive_id"`
+	PathOnHost   string `json:"path_on_host"`
+	IsRootDevice bool   `json:"is_root_device"`
+	IsReadOnly   bool   `json:"is_read_only"`
+}
+
+type firecrackerMachineConfig struct {
+	VCPUCount  int `json:"vcpu_count"`
+	MemSizeMiB int `json:"mem_size_mib"`
+}
+
+type firecrackerNetworkInterface struct {
+	IfaceID     string `json:"iface_id"`
+	HostDevName string `json:"host_dev_name"`
+}
+
+func (f *Firecracker) vmConfig(rootfs string, jobDisk string, statusDisk string) firecrackerConfig {
+	config := firecrackerConfig{
+		BootSource: firecrackerBootSource{
+			KernelImagePath: f.Kernel,
+			BootArgs:        firecrackerBootArgs,
+		},
+		Drives: []firecrackerDrive{
+			{DriveID: "rootfs", PathOnHost: rootfs, IsRootDevice: true},
+			{DriveID: "job", PathOnHost: jobDisk, IsReadOnly: true},
+			{DriveID: "status", PathOnHost: statusDisk},
+		},
+		MachineConfig: firecrackerMachineConfig{
+			VCPUCount:  f.VCPUs,
+			MemSizeMiB: f.MemoryMiB,
+		},
+	}
+
+	if f.TapDevice != "" {
+		config.NetworkInterfaces = []firecrackerNetworkInterface{
+			{IfaceID: "eth0", HostDevName: f.TapDevice},
+		}
+	}
+
+	return config
+}
+
+// writeJobDisk writes the job's env as JSON to a raw disk image, padded
+// with zeros to a whole number of sectors
+func writeJobDisk(path string, env map[string]string) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	if rem := len(data) % 512; rem != 0 {
+		data = append(data, make([]byte, 512-rem)...)
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// readStatusDisk reads the exit status that the VM's init wrote to the start
+// of the status disk, if it wrote one
+func readStatusDisk(path string) (int, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	sector := make([]byte, 512)
+	n, err := io.ReadFull(f, sector)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, false, err
+	}
+
+	s := strings.TrimSpace(strings.TrimRight(string(sector[:n]), "\x00"))
+	if s == "" {
+		return 0, false, nil
+	}
+
+	status, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false, fmt.Errorf("Invalid exit status %q", s)
+	}
+
+	return status, true, nil
+}
@@ -0,0 +1,120 @@
+package isolation
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewFirecracker(t *testing.T) {
+	backend, err := New("firecracker", map[string]string{
+		"kernel": "/var/lib/vmlinux",
+		"rootfs": "/var/lib/rootfs.ext4",
+		"memory": "4096",
+		"tap":    "tap0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Firecracker{
+		Binary:    "firecracker",
+		Kernel:    "/var/lib/vmlinux",
+		RootFS:    "/var/lib/rootfs.ext4",
+		VCPUs:     2,
+		MemoryMiB: 4096,
+		TapDevice: "tap0",
+	}
+	if !reflect.DeepEqual(backend, expected) {
+		t.Fatalf("Expected %#v, got %#v", expected, backend)
+	}
+
+	for _, options := range []map[string]string{
+		{"kernel": "/var/lib/vmlinux"},
+		{"kernel": "/var/lib/vmlinux", "rootfs": "/var/lib/rootfs.ext4", "vcpus": "0"},
+		{"kernel": "/var/lib/vmlinux", "rootfs": "/var/lib/rootfs.ext4", "llamas": "true"},
+	} {
+		if _, err := NewFirecracker(options); err == nil {
+			t.Errorf("Expected an error for %v", options)
+		}
+	}
+}
+
+func TestFirecrackerVMConfig(t *testing.T) {
+	f := &Firecracker{Kernel: "/vmlinux", VCPUs: 1, MemoryMiB: 512}
+	config := f.vmConfig("/tmp/rootfs.ext4", "/tmp/job.img", "/tmp/status.img")
+
+	if config.BootSource.KernelImagePath != "/vmlinux" {
+		t.Errorf("Unexpected kernel %q", config.BootSource.KernelImagePath)
+	}
+	if len(config.Drives) != 3 || !config.Drives[0].IsRootDevice || !config.Drives[1].IsReadOnly || config.Drives[2].IsReadOnly {
+		t.Errorf("Unexpected drives %#v", config.Drives)
+	}
+	if config.NetworkInterfaces != nil {
+		t.Errorf("Expected no network interfaces, got %#v", config.NetworkInterfaces)
+	}
+}
+
+func TestWriteJobDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "firecracker-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "job.img")
+	if err := writeJobDisk(path, map[string]string{"BUILDKITE_JOB_ID": "llamas"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 512 {
+		t.Fatalf("Expected a single sector, got %d bytes", len(data))
+	}
+	if !bytes.HasPrefix(data, []byte(`{"BUILDKITE_JOB_ID":"llamas"}`)) {
+		t.Fatalf("Unexpected job disk %q", data)
+	}
+}
+
+func TestReadStatusDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "firecracker-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "status.img")
+
+	for _, tc := range []struct {
+		written string
+		status  int
+		ok      bool
+		err     bool
+	}{
+		{"3\n", 3, true, false},
+		{"0", 0, true, false},
+		{"", 0, false, false},
+		{"llamas\n", 0, false, true},
+	} {
+		data := make([]byte, 512)
+		copy(data, tc.written)
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		status, ok, err := readStatusDisk(path)
+		if (err != nil) != tc.err {
+			t.Errorf("Unexpected error for %q: %v", tc.written, err)
+		}
+		if status != tc.status || ok != tc.ok {
+			t.Errorf("Expected %q to be %d, %v, got %d, %v", tc.written, tc.status, tc.ok, status, ok)
+		}
+	}
+}
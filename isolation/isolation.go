@@ -0,0 +1,120 @@
+// Package isolation runs a job's bootstrap somewhere it's isolated from the
+// machine the agent is running on, for agents that run untrusted code
+package isolation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Backend runs jobs in isolation
+type Backend interface {
+	// Run runs the bootstrap for the job, writing its output to stdout, and
+	// returns the job's exit status. Cancelling the context stops the job.
+	Run(ctx context.Context, job Job, stdout io.Writer) (int, error)
+}
+
+// Job is what a backend needs to know to run a job
+type Job struct {
+	ID string
+
+	// The env the bootstrap is run with
+	Env map[string]string
+}
+
+// Env that points at files and descriptors on the agent's host, which don't
+// exist wherever the job is isolated
+var hostOnlyEnv = []string{
+	"BUILDKITE_CONFIG_PATH",
+	"BUILDKITE_ENV_FILE",
+	"BUILDKITE_PHASE_TIMINGS_FILE",
+	"BUILDKITE_INFRA_FAILURE_FD",
+	"BUILDKITE_AUDIT_LOG",
+	"BUILDKITE_SECCOMP_PROFILE",
+	"BUILDKITE_APPARMOR_PROFILE",
+	"BUILDKITE_SELINUX_CONTEXT",
+	"BUILDKITE_META_DATA_CACHE_FILE",
+	"BUILDKITE_ARTIFACT_BYTES_FILE",
+}
+
+// GuestEnv returns the env the job's bootstrap is run with, without anything
+// that's only meaningful on the agent's host
+func (j Job) GuestEnv() map[string]string {
+	env := map[string]string{}
+	for key, value := range j.Env {
+		env[key] = value
+	}
+
+	for _, key := range hostOnlyEnv {
+		delete(env, key)
+	}
+
+	return env
+}
+
+// Factory creates a backend from its options, returning an error if they
+// aren't valid
+type Factory func(options map[string]string) (Backend, error)
+
+var (
+	backends   = map[string]Factory{}
+	backendsMu sync.Mutex
+)
+
+// Register makes a backend available by name
+func Register(name string, factory Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, exists := backends[name]; exists {
+		panic(fmt.Sprintf("isolation: backend %q is already registered", name))
+	}
+
+	backends[name] = factory
+}
+
+// Names returns the names of the registered backends
+func Names() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// New creates the backend with the given name
+func New(name string, options map[string]string) (Backend, error) {
+	backendsMu.Lock()
+	factory, ok := backends[name]
+	backendsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown isolation backend %q, must be one of %s", name, strings.Join(Names(), ", "))
+	}
+
+	return factory(options)
+}
+
+// ParseOptions parses backend options given as key=value pairs
+func ParseOptions(pairs []string) (map[string]string, error) {
+	options := map[string]string{}
+
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid isolation option %q, must be key=value", pair)
+		}
+		options[parts[0]] = parts[1]
+	}
+
+	return options, nil
+}
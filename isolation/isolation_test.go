@@ -0,0 +1,47 @@
+package isolation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	options, err := ParseOptions([]string{"kernel=/var/lib/vmlinux", "memory=4096", "boot=a=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"kernel": "/var/lib/vmlinux", "memory": "4096", "boot": "a=b"}
+	if !reflect.DeepEqual(options, expected) {
+		t.Fatalf("Expected %v, got %v", expected, options)
+	}
+
+	for _, invalid := range []string{"kernel", "=llamas"} {
+		if _, err := ParseOptions([]string{invalid}); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestNewWithUnknownBackend(t *testing.T) {
+	if _, err := New("llamas", nil); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestJobGuestEnv(t *testing.T) {
+	job := Job{ID: "llamas", Env: map[string]string{
+		"BUILDKITE_JOB_ID":           "llamas",
+		"BUILDKITE_CONFIG_PATH":      "/etc/buildkite-agent/buildkite-agent.cfg",
+		"BUILDKITE_INFRA_FAILURE_FD": "3",
+	}}
+
+	expected := map[string]string{"BUILDKITE_JOB_ID": "llamas"}
+	if env := job.GuestEnv(); !reflect.DeepEqual(env, expected) {
+		t.Fatalf("Expected %v, got %v", expected, env)
+	}
+
+	if _, ok := job.Env["BUILDKITE_CONFIG_PATH"]; !ok {
+		t.Fatal("Expected the job's own env to be left alone")
+	}
+}
@@ -0,0 +1,180 @@
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// Runner runs a job in its own pod using kubectl, streaming the pod's logs
+// as they're written
+type Runner struct {
+	// The kubectl binary to use, which defaults to the one in the PATH
+	Kubectl string
+
+	// Where the job's output is written
+	Stdout io.Writer
+
+	// How often to check on the pod
+	PollInterval time.Duration
+
+	// How long the pod can be pending for, like while its image is pulled,
+	// before the job is given up on. It's 10 minutes by default.
+	PendingTimeout time.Duration
+}
+
+// How long pods can be pending for, unless the runner says otherwise
+const defaultPendingTimeout = 10 * time.Minute
+
+// Run creates the job's pod, waits for it to finish and then deletes it,
+// returning the exit status of the job. Cancelling the context deletes the
+// pod straight away.
+func (r *Runner) Run(ctx context.Context, c PodConfig) (int, error) {
+	manifest, err := Manifest(c)
+	if err != nil {
+		return -1, err
+	}
+
+	for _, name := range UnappliedConfinement(c.Env) {
+		logger.Warn("[Kubernetes] %s can't be applied in the job's pod, so the job is running without it", name)
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return -1, err
+	}
+
+	logger.Info("[Kubernetes] Creating pod %s in namespace %s", c.Name(), c.Namespace)
+	if _, err := r.kubectl(ctx, c.Namespace, bytes.NewReader(data), "create", "-f", "-"); err != nil {
+		return -1, err
+	}
+
+	// The pod is always cleaned up, even when the job is cancelled
+	defer func() {
+		logger.Info("[Kubernetes] Deleting pod %s", c.Name())
+		if _, err := r.kubectl(context.Background(), c.Namespace, nil, "delete", "pod,secret", "-l", jobIDLabel+"="+c.JobID, "--wait=false"); err != nil {
+			logger.Warn("[Kubernetes] Failed to delete pod %s: %v", c.Name(), err)
+		}
+	}()
+
+	// Logs can only be fetched once the job's container has started
+	pendingTimeout := r.PendingTimeout
+	if pendingTimeout == 0 {
+		pendingTimeout = defaultPendingTimeout
+	}
+
+	phase, err := r.waitFor(ctx, c, pendingTimeout, func(phase string) bool { return phase != "Pending" && phase != "" })
+	if err != nil {
+		return -1, err
+	}
+
+	// Pods that finished before they were seen running still have logs,
+	// they just can't be followed
+	args := []string{"logs", "pod/" + c.Name(), "-c", jobContainer}
+	if phase == "Running" {
+		args = append(args, "--follow")
+	}
+
+	logs := r.command(ctx, c.Namespace, args...)
+	logs.Stdout = r.Stdout
+	logs.Stderr = r.Stdout
+	if err := logs.Run(); err != nil && ctx.Err() == nil {
+		logger.Warn("[Kubernetes] Failed to get the logs of pod %s: %v", c.Name(), err)
+	}
+
+	// The logs finish when the container does, but the pod's status can
+	// take a moment to catch up
+	if _, err := r.waitFor(ctx, c, 0, func(phase string) bool { return phase == "Succeeded" || phase == "Failed" }); err != nil {
+		return -1, err
+	}
+
+	return r.exitStatus(ctx, c)
+}
+
+// waitFor polls the pod's phase until done returns true for it. A non-zero
+// timeout gives up on pods that are still pending after it, like ones whose
+// image can't be pulled.
+func (r *Runner) waitFor(ctx context.Context, c PodConfig, timeout time.Duration, done func(phase string) bool) (string, error) {
+	interval := r.PollInterval
+	if interval == 0 {
+		interval = 2 * time.Second
+	}
+
+	started := time.Now()
+
+	for {
+		phase, err := r.kubectl(ctx, c.Namespace, nil, "get", "pod", c.Name(), "-o", "jsonpath={.status.phase}")
+		if err != nil {
+			return "", err
+		}
+
+		if done(phase) {
+			return phase, nil
+		}
+
+		if timeout > 0 && phase == "Pending" && time.Since(started) > timeout {
+			return "", fmt.Errorf("Pod %s was still pending after %v", c.Name(), timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// exitStatus finds the exit status of the job's container
+func (r *Runner) exitStatus(ctx context.Context, c PodConfig) (int, error) {
+	jsonpath := fmt.Sprintf(`jsonpath={.status.containerStatuses[?(@.name=="%s")].state.terminated.exitCode}`, jobContainer)
+
+	output, err := r.kubectl(ctx, c.Namespace, nil, "get", "pod", c.Name(), "-o", jsonpath)
+	if err != nil {
+		return -1, err
+	}
+
+	// The job's container won't have an exit status if it never started,
+	// like when the agent couldn't be copied into the pod
+	if output == "" {
+		return -1, fmt.Errorf("The job's container in pod %s didn't run", c.Name())
+	}
+
+	return strconv.Atoi(output)
+}
+
+// kubectl runs a kubectl command and returns what it printed
+func (r *Runner) kubectl(ctx context.Context, namespace string, stdin io.Reader, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := r.command(ctx, namespace, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("kubectl %s failed: %v %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (r *Runner) command(ctx context.Context, namespace string, args ...string) *exec.Cmd {
+	kubectl := r.Kubectl
+	if kubectl == "" {
+		kubectl = "kubectl"
+	}
+
+	if namespace != "" {
+		args = append([]string{"--namespace", namespace}, args...)
+	}
+
+	return exec.CommandContext(ctx, kubectl, args...)
+}
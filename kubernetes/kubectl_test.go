@@ -0,0 +1,117 @@
+// +build !windows
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A kubectl that pretends the pod runs, prints some output and exits 3
+const fakeKubectl = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/calls"
+case "$*" in
+  *"create -f -"*) cat > /dev/null ;;
+  *"jsonpath={.status.phase}"*) echo Succeeded ;;
+  *"state.terminated.exitCode"*) echo 3 ;;
+  *" logs "*) echo "llamas" ;;
+esac
+`
+
+// A kubectl whose pod never leaves pending, like when its image can't be
+// pulled
+const pendingKubectl = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/calls"
+case "$*" in
+  *"create -f -"*) cat > /dev/null ;;
+  *"jsonpath={.status.phase}"*) echo Pending ;;
+esac
+`
+
+func TestRunnerRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kubectl := filepath.Join(dir, "kubectl")
+	if err := ioutil.WriteFile(kubectl, []byte(fakeKubectl), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	runner := &Runner{Kubectl: kubectl, Stdout: &output}
+
+	exitStatus, err := runner.Run(context.Background(), PodConfig{
+		JobID:        "1",
+		Namespace:    "ci",
+		DefaultImage: "alpine",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exitStatus != 3 {
+		t.Fatalf("Expected exit status 3, got %d", exitStatus)
+	}
+
+	calls, err := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pod finished before it could be seen running, so its logs are
+	// fetched without following them, and it still gets cleaned up
+	if output.String() != "llamas\n" {
+		t.Fatalf("Expected the pod's logs, got %q", output.String())
+	}
+	if !strings.Contains(string(calls), "--namespace ci logs pod/buildkite-1 -c job\n") {
+		t.Fatalf("Expected the logs to be fetched without following them, got calls:\n%s", calls)
+	}
+	if !strings.Contains(string(calls), "--namespace ci create -f -") {
+		t.Fatalf("Expected the pod to be created, got calls:\n%s", calls)
+	}
+	if !strings.Contains(string(calls), "--namespace ci delete pod,secret -l buildkite.com/job-id=1 --wait=false") {
+		t.Fatalf("Expected the pod to be deleted, got calls:\n%s", calls)
+	}
+}
+
+func TestRunnerGivesUpOnPendingPods(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kubectl := filepath.Join(dir, "kubectl")
+	if err := ioutil.WriteFile(kubectl, []byte(pendingKubectl), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &Runner{
+		Kubectl:        kubectl,
+		Stdout:         ioutil.Discard,
+		PollInterval:   10 * time.Millisecond,
+		PendingTimeout: 50 * time.Millisecond,
+	}
+
+	if _, err := runner.Run(context.Background(), PodConfig{JobID: "1", DefaultImage: "alpine"}); err == nil {
+		t.Fatal("Expected an error for a pod that never starts")
+	}
+
+	calls, err := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(calls), "delete pod,secret -l buildkite.com/job-id=1 --wait=false") {
+		t.Fatalf("Expected the pod to be deleted, got calls:\n%s", calls)
+	}
+}
@@ -0,0 +1,290 @@
+// Package kubernetes runs jobs in their own Kubernetes pods, using kubectl
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The step env that configures the pod a job runs in
+const (
+	ImageEnv        = "BUILDKITE_KUBERNETES_IMAGE"
+	CPUEnv          = "BUILDKITE_KUBERNETES_CPU"
+	MemoryEnv       = "BUILDKITE_KUBERNETES_MEMORY"
+	NodeSelectorEnv = "BUILDKITE_KUBERNETES_NODE_SELECTOR"
+)
+
+// Where the agent's files are inside the job's container
+const (
+	mountPath   = "/buildkite"
+	binPath     = mountPath + "/bin"
+	buildPath   = mountPath + "/builds"
+	hooksPath   = mountPath + "/hooks"
+	pluginsPath = mountPath + "/plugins"
+)
+
+// The name of the container that runs the job, as opposed to the one that
+// copies the agent into the pod
+const jobContainer = "job"
+
+// Labels every pod and secret with the job it's for
+const jobIDLabel = "buildkite.com/job-id"
+
+// Env that points at files on the agent's host, which don't exist in the pod
+var hostOnlyEnv = []string{
+	"BUILDKITE_CONFIG_PATH",
+	"BUILDKITE_ENV_FILE",
+	"BUILDKITE_PHASE_TIMINGS_FILE",
+	"BUILDKITE_INFRA_FAILURE_FD",
+	"BUILDKITE_AUDIT_LOG",
+	"BUILDKITE_SECCOMP_PROFILE",
+	"BUILDKITE_APPARMOR_PROFILE",
+	"BUILDKITE_SELINUX_CONTEXT",
+	"BUILDKITE_META_DATA_CACHE_FILE",
+	"BUILDKITE_ARTIFACT_BYTES_FILE",
+}
+
+// The env that confines the bootstrap on the agent's host, and what it's
+// called when it can't be applied in the pod
+var confinementEnv = []struct {
+	key, name string
+}{
+	{"BUILDKITE_SECCOMP_PROFILE", "The seccomp profile"},
+	{"BUILDKITE_APPARMOR_PROFILE", "The AppArmor profile"},
+	{"BUILDKITE_SELINUX_CONTEXT", "The SELinux context"},
+	{"BUILDKITE_COMMAND_SANDBOX", "The command sandbox"},
+}
+
+// PodConfig describes the pod that a job runs in
+type PodConfig struct {
+	// The ID of the job, which the pod is named after
+	JobID string
+
+	// The namespace the pod is created in
+	Namespace string
+
+	// The image to run the job in when its step doesn't choose one
+	DefaultImage string
+
+	// An image with the agent at /usr/local/bin/buildkite-agent, which is
+	// copied into the job's container to run the bootstrap
+	AgentImage string
+
+	// The job's environment
+	Env map[string]string
+}
+
+// Name is the name of the job's pod, and the secret that holds its env
+func (c PodConfig) Name() string {
+	return "buildkite-" + strings.ToLower(c.JobID)
+}
+
+// The parts of Kubernetes objects that we use
+
+type objectList struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Items      []interface{} `json:"items"`
+}
+
+type objectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type secret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   objectMeta        `json:"metadata"`
+	Type       string            `json:"type"`
+	StringData map[string]string `json:"stringData"`
+}
+
+type pod struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       podSpec    `json:"spec"`
+}
+
+type podSpec struct {
+	RestartPolicy  string            `json:"restartPolicy"`
+	NodeSelector   map[string]string `json:"nodeSelector,omitempty"`
+	Volumes        []volume          `json:"volumes"`
+	InitContainers []container       `json:"initContainers"`
+	Containers     []container       `json:"containers"`
+}
+
+type volume struct {
+	Name     string   `json:"name"`
+	EmptyDir struct{} `json:"emptyDir"`
+}
+
+type container struct {
+	Name         string        `json:"name"`
+	Image        string        `json:"image"`
+	Command      []string      `json:"command"`
+	EnvFrom      []envFrom     `json:"envFrom,omitempty"`
+	Resources    *resources    `json:"resources,omitempty"`
+	VolumeMounts []volumeMount `json:"volumeMounts"`
+}
+
+type envFrom struct {
+	SecretRef struct {
+		Name string `json:"name"`
+	} `json:"secretRef"`
+}
+
+type resources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+type volumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+}
+
+// Manifest returns a list with the secret that holds the job's env and the
+// pod that runs it, which kubectl can create in one go. The env is kept in a
+// secret so that the job's access token isn't in the pod spec.
+func Manifest(c PodConfig) (interface{}, error) {
+	env := podEnv(c.Env)
+
+	image := env[ImageEnv]
+	if image == "" {
+		image = c.DefaultImage
+	}
+	if image == "" {
+		return nil, fmt.Errorf("No image to run the job in, set %s on the step", ImageEnv)
+	}
+
+	nodeSelector, err := ParseNodeSelector(env[NodeSelectorEnv])
+	if err != nil {
+		return nil, err
+	}
+
+	meta := objectMeta{
+		Name:      c.Name(),
+		Namespace: c.Namespace,
+		Labels:    map[string]string{jobIDLabel: c.JobID},
+	}
+
+	job := container{
+		Name:         jobContainer,
+		Image:        image,
+		Command:      []string{binPath + "/buildkite-agent", "bootstrap"},
+		VolumeMounts: []volumeMount{{Name: "buildkite", MountPath: mountPath}},
+	}
+	job.EnvFrom = []envFrom{{}}
+	job.EnvFrom[0].SecretRef.Name = c.Name()
+
+	if cpu, memory := env[CPUEnv], env[MemoryEnv]; cpu != "" || memory != "" {
+		job.Resources = &resources{Requests: map[string]string{}, Limits: map[string]string{}}
+		for name, value := range map[string]string{"cpu": cpu, "memory": memory} {
+			if value != "" {
+				job.Resources.Requests[name] = value
+				job.Resources.Limits[name] = value
+			}
+		}
+	}
+
+	return objectList{
+		APIVersion: "v1",
+		Kind:       "List",
+		Items: []interface{}{
+			secret{
+				APIVersion: "v1",
+				Kind:       "Secret",
+				Metadata:   meta,
+				Type:       "Opaque",
+				StringData: env,
+			},
+			pod{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Metadata:   meta,
+				Spec: podSpec{
+					RestartPolicy: "Never",
+					NodeSelector:  nodeSelector,
+					Volumes:       []volume{{Name: "buildkite"}},
+					InitContainers: []container{{
+						Name:         "agent",
+						Image:        c.AgentImage,
+						Command:      []string{"/bin/sh", "-c", fmt.Sprintf("mkdir -p %s && cp /usr/local/bin/buildkite-agent %s/", binPath, binPath)},
+						VolumeMounts: []volumeMount{{Name: "buildkite", MountPath: mountPath}},
+					}},
+					Containers: []container{job},
+				},
+			},
+		},
+	}, nil
+}
+
+// podEnv is the job's env, with the agent's paths changed to ones in the pod
+func podEnv(jobEnv map[string]string) map[string]string {
+	env := map[string]string{}
+	for key, value := range jobEnv {
+		env[key] = value
+	}
+
+	for _, key := range hostOnlyEnv {
+		delete(env, key)
+	}
+
+	// Webhooks can be reached from the pod, but files and commands are on
+	// the agent's host
+	if sinks, ok := env["BUILDKITE_AGENT_EVENT_SINKS"]; ok {
+		var webhooks []string
+		for _, sink := range strings.Split(sinks, ",") {
+			if strings.HasPrefix(sink, "http://") || strings.HasPrefix(sink, "https://") {
+				webhooks = append(webhooks, sink)
+			}
+		}
+
+		if len(webhooks) > 0 {
+			env["BUILDKITE_AGENT_EVENT_SINKS"] = strings.Join(webhooks, ",")
+		} else {
+			delete(env, "BUILDKITE_AGENT_EVENT_SINKS")
+		}
+	}
+
+	env["BUILDKITE_BIN_PATH"] = binPath
+	env["BUILDKITE_BUILD_PATH"] = buildPath
+	env["BUILDKITE_HOOKS_PATH"] = hooksPath
+	env["BUILDKITE_PLUGINS_PATH"] = pluginsPath
+
+	return env
+}
+
+// UnappliedConfinement lists the ways the agent would confine the job that
+// can't be applied in its pod, so it's clear the job is running without them
+func UnappliedConfinement(jobEnv map[string]string) []string {
+	var unapplied []string
+	for _, c := range confinementEnv {
+		if value := jobEnv[c.key]; value != "" && value != "false" {
+			unapplied = append(unapplied, c.name)
+		}
+	}
+
+	return unapplied
+}
+
+// ParseNodeSelector reads a node selector in the form key=value,key=value
+func ParseNodeSelector(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	selector := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid node selector %q, expected key=value pairs separated by commas", s)
+		}
+		selector[parts[0]] = parts[1]
+	}
+
+	return selector, nil
+}
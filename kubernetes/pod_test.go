@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	manifest, err := Manifest(PodConfig{
+		JobID:        "ABC-123",
+		Namespace:    "ci",
+		DefaultImage: "buildkite/agent:3",
+		AgentImage:   "buildkite/agent:3",
+		Env: map[string]string{
+			"BUILDKITE_JOB_ID":             "ABC-123",
+			"BUILDKITE_AGENT_ACCESS_TOKEN": "llamas",
+			"BUILDKITE_BUILD_PATH":         "/var/lib/buildkite/builds",
+			"BUILDKITE_ENV_FILE":           "/tmp/job-env",
+			ImageEnv:                       "golang:1.11",
+			MemoryEnv:                      "1Gi",
+			NodeSelectorEnv:                "disktype=ssd, zone=a",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := manifest.(objectList)
+	s := list.Items[0].(secret)
+	p := list.Items[1].(pod)
+
+	if s.Metadata.Name != "buildkite-abc-123" || p.Metadata.Name != "buildkite-abc-123" || p.Metadata.Namespace != "ci" {
+		t.Fatalf("Unexpected names %#v %#v", s.Metadata, p.Metadata)
+	}
+
+	// The access token is only in the secret
+	data, _ := json.Marshal(p)
+	if strings.Contains(string(data), "llamas") {
+		t.Fatalf("The pod spec shouldn't contain the access token: %s", data)
+	}
+	if s.StringData["BUILDKITE_AGENT_ACCESS_TOKEN"] != "llamas" {
+		t.Fatalf("Expected the access token in the secret, got %#v", s.StringData)
+	}
+
+	// Paths on the agent's host are replaced or removed
+	if s.StringData["BUILDKITE_BUILD_PATH"] != buildPath || s.StringData["BUILDKITE_BIN_PATH"] != binPath {
+		t.Fatalf("Unexpected paths in env %#v", s.StringData)
+	}
+	if _, ok := s.StringData["BUILDKITE_ENV_FILE"]; ok {
+		t.Fatalf("Expected BUILDKITE_ENV_FILE to be removed from %#v", s.StringData)
+	}
+
+	job := p.Spec.Containers[0]
+	if job.Image != "golang:1.11" || job.EnvFrom[0].SecretRef.Name != "buildkite-abc-123" {
+		t.Fatalf("Unexpected job container %#v", job)
+	}
+	if !reflect.DeepEqual(job.Resources, &resources{
+		Requests: map[string]string{"memory": "1Gi"},
+		Limits:   map[string]string{"memory": "1Gi"},
+	}) {
+		t.Fatalf("Unexpected resources %#v", job.Resources)
+	}
+	if !reflect.DeepEqual(p.Spec.NodeSelector, map[string]string{"disktype": "ssd", "zone": "a"}) {
+		t.Fatalf("Unexpected node selector %#v", p.Spec.NodeSelector)
+	}
+}
+
+func TestManifestUsesTheDefaultImage(t *testing.T) {
+	manifest, err := Manifest(PodConfig{JobID: "1", DefaultImage: "buildkite/agent:3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := manifest.(objectList).Items[1].(pod)
+	if p.Spec.Containers[0].Image != "buildkite/agent:3" || p.Spec.Containers[0].Resources != nil {
+		t.Fatalf("Unexpected job container %#v", p.Spec.Containers[0])
+	}
+
+	if _, err := Manifest(PodConfig{JobID: "1"}); err == nil {
+		t.Fatal("Expected an error without an image")
+	}
+}
+
+func TestPodEnvRemovesHostOnlySettings(t *testing.T) {
+	env := podEnv(map[string]string{
+		"BUILDKITE_SECCOMP_PROFILE":   "/etc/buildkite-agent/seccomp.json",
+		"BUILDKITE_AGENT_EVENT_SINKS": "file:///var/log/events.json,https://example.com/hook,exec:/usr/local/bin/notify",
+	})
+
+	if _, ok := env["BUILDKITE_SECCOMP_PROFILE"]; ok {
+		t.Fatalf("Expected BUILDKITE_SECCOMP_PROFILE to be removed from %#v", env)
+	}
+	if sinks := env["BUILDKITE_AGENT_EVENT_SINKS"]; sinks != "https://example.com/hook" {
+		t.Fatalf("Expected only the webhook event sink to be kept, got %q", sinks)
+	}
+
+	env = podEnv(map[string]string{"BUILDKITE_AGENT_EVENT_SINKS": "file:///var/log/events.json"})
+	if _, ok := env["BUILDKITE_AGENT_EVENT_SINKS"]; ok {
+		t.Fatalf("Expected BUILDKITE_AGENT_EVENT_SINKS to be removed from %#v", env)
+	}
+}
+
+func TestUnappliedConfinement(t *testing.T) {
+	unapplied := UnappliedConfinement(map[string]string{
+		"BUILDKITE_SECCOMP_PROFILE": "/etc/buildkite-agent/seccomp.json",
+		"BUILDKITE_COMMAND_SANDBOX": "true",
+	})
+	if !reflect.DeepEqual(unapplied, []string{"The seccomp profile", "The command sandbox"}) {
+		t.Fatalf("Unexpected unapplied confinement %#v", unapplied)
+	}
+
+	if unapplied := UnappliedConfinement(map[string]string{"BUILDKITE_COMMAND_SANDBOX": "false"}); len(unapplied) != 0 {
+		t.Fatalf("Expected nothing to be unapplied, got %#v", unapplied)
+	}
+}
+
+func TestParseNodeSelector(t *testing.T) {
+	if selector, err := ParseNodeSelector(""); err != nil || selector != nil {
+		t.Fatalf("Expected no selector, got %#v, %v", selector, err)
+	}
+
+	if _, err := ParseNodeSelector("disktype"); err == nil {
+		t.Fatal("Expected an error for a selector without a value")
+	}
+}
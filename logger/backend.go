@@ -0,0 +1,44 @@
+package logger
+
+import "sync"
+
+// Backend is somewhere other than the terminal that log lines are sent to,
+// such as syslog or the Windows Event Log.
+type Backend interface {
+	Write(l Level, message string) error
+	Close() error
+}
+
+var backends []Backend
+var backendsMutex = sync.RWMutex{}
+
+// AddBackend sends all future log lines to the backend, in addition to the
+// terminal output.
+func AddBackend(b Backend) {
+	backendsMutex.Lock()
+	backends = append(backends, b)
+	backendsMutex.Unlock()
+}
+
+// CloseBackends closes and removes all the backends that have been added
+func CloseBackends() {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+
+	for _, b := range backends {
+		b.Close()
+	}
+
+	backends = nil
+}
+
+func writeToBackends(l Level, message string) {
+	backendsMutex.RLock()
+	defer backendsMutex.RUnlock()
+
+	for _, b := range backends {
+		// There's nowhere sensible to report a failure to log, and the
+		// line has already made it to the terminal, so errors are dropped
+		b.Write(l, message)
+	}
+}
@@ -0,0 +1,153 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// dedupWindow is how long identical lines are collapsed for. Zero turns it
+// off, which is the default.
+var dedupWindow time.Duration
+
+// The attempt counter that retry.Stats adds to messages, which changes on
+// every retry of what's otherwise the same line
+var retryStatsRegex = regexp.MustCompile(`Attempt \d+/(?:\d+|∞)(?: Retrying in [0-9.a-zµ]+)?`)
+
+// repeatedLine is the last line that was written, and how many times it's
+// been repeated since without being shown
+type repeatedLine struct {
+	level      Level
+	fields     Fields
+	message    string
+	key        string
+	since      time.Time
+	count      int
+	timer      *time.Timer
+	generation int
+}
+
+// repeatSummary is a line to write with how many times a line was repeated
+type repeatSummary struct {
+	level   Level
+	fields  Fields
+	message string
+}
+
+var repeated repeatedLine
+var repeatedGeneration int
+var repeatedMutex = sync.Mutex{}
+
+// SetDedupWindow collapses bursts of identical lines, like the same
+// connection error repeated thousands of times during an outage. The first
+// line is written as usual, and any repeats within the window are replaced
+// by a single line with how many times it was repeated.
+func SetDedupWindow(d time.Duration) {
+	repeatedMutex.Lock()
+	summary := flushRepeatedLocked()
+	dedupWindow = d
+	repeatedMutex.Unlock()
+
+	summary.write()
+}
+
+// FlushRepeats writes out the count of any lines that are currently being
+// collapsed
+func FlushRepeats() {
+	repeatedMutex.Lock()
+	summary := flushRepeatedLocked()
+	repeatedMutex.Unlock()
+
+	summary.write()
+}
+
+// flushRepeatsFor is FlushRepeats for the timer of a particular line, which
+// does nothing if another line has replaced it since the timer was started
+func flushRepeatsFor(generation int) {
+	repeatedMutex.Lock()
+	if repeated.generation != generation {
+		repeatedMutex.Unlock()
+		return
+	}
+	summary := flushRepeatedLocked()
+	repeatedMutex.Unlock()
+
+	summary.write()
+}
+
+// deduplicate returns whether a line should be written, or whether it's a
+// repeat of the last one that's been counted instead. It also returns the
+// count of the previous line if that needs writing first.
+func deduplicate(l Level, fields Fields, message string) (bool, *repeatSummary) {
+	repeatedMutex.Lock()
+	defer repeatedMutex.Unlock()
+
+	if dedupWindow <= 0 {
+		return true, nil
+	}
+
+	key := l.String() + "\x00" + fields.String() + "\x00" + retryStatsRegex.ReplaceAllString(message, "")
+
+	// Fatal lines are always shown, as they're the last thing written
+	if l != FATAL && key == repeated.key && time.Since(repeated.since) < dedupWindow {
+		repeated.count++
+
+		// Make sure the count still gets written if nothing else is
+		// logged for a while, like when the outage is over
+		if repeated.timer == nil {
+			generation := repeated.generation
+			repeated.timer = time.AfterFunc(dedupWindow-time.Since(repeated.since), func() {
+				flushRepeatsFor(generation)
+			})
+		}
+
+		return false, nil
+	}
+
+	summary := flushRepeatedLocked()
+
+	repeatedGeneration++
+	repeated = repeatedLine{
+		level:      l,
+		fields:     fields,
+		message:    message,
+		key:        key,
+		since:      time.Now(),
+		generation: repeatedGeneration,
+	}
+
+	return true, summary
+}
+
+// flushRepeatedLocked forgets about the last line so that the next identical
+// line is shown again, and returns its count if it was repeated. The count
+// is written by the caller once it's let go of repeatedMutex.
+func flushRepeatedLocked() *repeatSummary {
+	if repeated.timer != nil {
+		repeated.timer.Stop()
+	}
+
+	var summary *repeatSummary
+	if repeated.count > 0 {
+		var suffix = "times"
+		if repeated.count == 1 {
+			suffix = "time"
+		}
+		summary = &repeatSummary{
+			level:   repeated.level,
+			fields:  repeated.fields,
+			message: fmt.Sprintf("%s (repeated %d more %s)", repeated.message, repeated.count, suffix),
+		}
+	}
+
+	repeated = repeatedLine{}
+
+	return summary
+}
+
+func (s *repeatSummary) write() {
+	if s != nil {
+		write(s.level, s.fields, s.message)
+	}
+}
@@ -0,0 +1,10 @@
+// +build !windows
+
+package logger
+
+import "errors"
+
+// NewEventLogBackend is only available on Windows, use NewSyslogBackend instead
+func NewEventLogBackend(source string) (Backend, error) {
+	return nil, errors.New("The Windows Event Log is only available on Windows")
+}
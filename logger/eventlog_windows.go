@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// All events are logged with the same ID, the message carries the detail
+const eventLogEventID = 1
+
+type eventLogBackend struct {
+	log *eventlog.Log
+}
+
+// NewEventLogBackend writes log lines to the Windows Event Log under the given
+// source name, registering the source first if it hasn't been already.
+func NewEventLogBackend(source string) (Backend, error) {
+	// Registering requires administrator rights and fails if the source already
+	// exists. Either way events can still be written, just with less formatting.
+	_ = eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open the Windows Event Log: %v", err)
+	}
+
+	return &eventLogBackend{log: l}, nil
+}
+
+func (b *eventLogBackend) Write(l Level, message string) error {
+	switch l {
+	case WARN:
+		return b.log.Warning(eventLogEventID, message)
+	case ERROR, FATAL:
+		return b.log.Error(eventLogEventID, message)
+	default:
+		return b.log.Info(eventLogEventID, message)
+	}
+}
+
+func (b *eventLogBackend) Close() error {
+	return b.log.Close()
+}
@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fields are named values attached to every line written by a Logger, like
+// the name of the agent or the ID of the job being run.
+type Fields map[string]string
+
+// String returns the fields as sorted key=value pairs separated by spaces
+func (f Fields) String() string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, f[k]))
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+// Logger writes log lines in the same way as the package level functions,
+// but with a set of fields attached to each line.
+type Logger struct {
+	fields Fields
+	mu     sync.RWMutex
+}
+
+// WithFields returns a Logger that attaches the fields to every line
+func WithFields(fields Fields) *Logger {
+	l := &Logger{fields: Fields{}}
+	for k, v := range fields {
+		l.fields[k] = v
+	}
+	return l
+}
+
+// WithFields returns a new Logger with the fields of this one and the ones
+// provided, which take precedence.
+func (l *Logger) WithFields(fields Fields) *Logger {
+	merged := l.Fields()
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return WithFields(merged)
+}
+
+// SetField changes the value of a field on this Logger, which is useful for
+// things that change over time like the phase a job is in.
+func (l *Logger) SetField(key, value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.fields == nil {
+		l.fields = Fields{}
+	}
+	l.fields[key] = value
+}
+
+// Fields returns a copy of the fields attached to this Logger
+func (l *Logger) Fields() Fields {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	fields := Fields{}
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	return fields
+}
+
+func (l *Logger) Debug(format string, v ...interface{}) {
+	logWithFields(DEBUG, l.Fields(), format, v...)
+}
+
+func (l *Logger) Error(format string, v ...interface{}) {
+	logWithFields(ERROR, l.Fields(), format, v...)
+}
+
+func (l *Logger) Fatal(format string, v ...interface{}) {
+	logWithFields(FATAL, l.Fields(), format, v...)
+	CloseBackends()
+	os.Exit(1)
+}
+
+func (l *Logger) Notice(format string, v ...interface{}) {
+	logWithFields(NOTICE, l.Fields(), format, v...)
+}
+
+func (l *Logger) Info(format string, v ...interface{}) {
+	logWithFields(INFO, l.Fields(), format, v...)
+}
+
+func (l *Logger) Warn(format string, v ...interface{}) {
+	logWithFields(WARN, l.Fields(), format, v...)
+}
@@ -1,5 +1,10 @@
 package logger
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Level int
 
 const (
@@ -24,3 +29,33 @@ var levelNames = []string{
 func (p Level) String() string {
 	return levelNames[p]
 }
+
+// ParseLevel returns the level with the given name, ignoring case.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, strings.TrimSpace(name)) {
+			return Level(i), nil
+		}
+	}
+
+	return INFO, fmt.Errorf("Unknown log level `%s`", name)
+}
+
+// severity orders levels from least to most important, since the Level
+// constants themselves aren't declared in that order.
+func (p Level) severity() int {
+	switch p {
+	case DEBUG:
+		return 0
+	case INFO:
+		return 1
+	case NOTICE:
+		return 2
+	case WARN:
+		return 3
+	case ERROR:
+		return 4
+	default:
+		return 5
+	}
+}
@@ -1,9 +1,11 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"runtime"
 	"strings"
 	"sync"
@@ -13,19 +15,23 @@ import (
 )
 
 const (
-	nocolor = "0"
-	red     = "31"
-	green   = "1;32"
-	yellow  = "33"
-	blue    = "34"
-	gray    = "1;30"
-	cyan    = "1;36"
+	TextFormat = "text"
+	JSONFormat = "json"
 )
 
 var level = INFO
 var colors = true
+var format = TextFormat
 var mutex = sync.Mutex{}
 
+// componentLevels overrides the global level for log lines that start with a
+// component prefix like "[Process]". Keys are lower-cased component names.
+var componentLevels = map[string]Level{}
+var componentLevelsMutex = sync.RWMutex{}
+
+// componentPrefixRegex finds the component of a log line from its prefix
+var componentPrefixRegex = regexp.MustCompile(`^\[([A-Za-z]+)\]`)
+
 func GetLevel() Level {
 	return level
 }
@@ -38,11 +44,92 @@ func SetLevel(l Level) {
 	}
 }
 
+// SetComponentLevels configures levels for individual components from a
+// comma-separated list of component=level pairs, e.g. "process=debug,api=warn".
+// Components not in the list continue to use the global level.
+func SetComponentLevels(spec string) error {
+	levels := map[string]Level{}
+
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("Invalid component log level `%s` (expected component=level)", pair)
+		}
+
+		l, err := ParseLevel(parts[1])
+		if err != nil {
+			return err
+		}
+
+		levels[strings.ToLower(strings.TrimSpace(parts[0]))] = l
+	}
+
+	componentLevelsMutex.Lock()
+	componentLevels = levels
+	componentLevelsMutex.Unlock()
+
+	for component, l := range levels {
+		Debug("Log level for %s set to %s", component, l)
+	}
+
+	return nil
+}
+
+// enabled returns whether a line at the given level should be logged, based
+// on the component named in its format string and the configured levels.
+func enabled(l Level, f string) bool {
+	if l == FATAL {
+		return true
+	}
+
+	if match := componentPrefixRegex.FindStringSubmatch(f); match != nil {
+		componentLevelsMutex.RLock()
+		cl, ok := componentLevels[strings.ToLower(match[1])]
+		componentLevelsMutex.RUnlock()
+
+		if ok {
+			return l.severity() >= cl.severity()
+		}
+	}
+
+	return l != DEBUG || level == DEBUG
+}
+
 func SetColors(b bool) {
 	colors = b
 }
 
+// SetFormat changes how log lines are written. TextFormat is the default,
+// human-readable output, and JSONFormat writes one JSON object per line.
+func SetFormat(f string) error {
+	switch f {
+	case TextFormat, JSONFormat:
+		format = f
+		return nil
+	default:
+		return fmt.Errorf("Unknown log format `%s` (expected %q or %q)", f, TextFormat, JSONFormat)
+	}
+}
+
+func GetFormat() string {
+	return format
+}
+
 func ColorsEnabled() bool {
+	if format == JSONFormat {
+		// Escape codes would just end up inside the JSON strings
+		return false
+	}
+
+	// Respect https://no-color.org
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
 	if runtime.GOOS == "windows" {
 		// Boo, no colors on Windows.
 		return false
@@ -62,9 +149,7 @@ func OutputPipe() io.Writer {
 }
 
 func Debug(format string, v ...interface{}) {
-	if level == DEBUG {
-		log(DEBUG, format, v...)
-	}
+	log(DEBUG, format, v...)
 }
 
 func Error(format string, v ...interface{}) {
@@ -73,6 +158,7 @@ func Error(format string, v ...interface{}) {
 
 func Fatal(format string, v ...interface{}) {
 	log(FATAL, format, v...)
+	CloseBackends()
 	os.Exit(1)
 }
 
@@ -88,37 +174,100 @@ func Warn(format string, v ...interface{}) {
 	log(WARN, format, v...)
 }
 
-func log(l Level, format string, v ...interface{}) {
-	level := strings.ToUpper(l.String())
-	message := fmt.Sprintf(format, v...)
-	now := time.Now().Format("2006-01-02 15:04:05")
-	line := ""
+func log(l Level, f string, v ...interface{}) {
+	logWithFields(l, nil, f, v...)
+}
 
-	if ColorsEnabled() {
-		prefixColor := green
-		messageColor := nocolor
-
-		if l == DEBUG {
-			prefixColor = gray
-			messageColor = gray
-		} else if l == NOTICE {
-			prefixColor = cyan
-		} else if l == WARN {
-			prefixColor = yellow
-		} else if l == ERROR {
-			prefixColor = red
-		} else if l == FATAL {
-			prefixColor = red
-			messageColor = red
-		}
+func logWithFields(l Level, fields Fields, f string, v ...interface{}) {
+	if !enabled(l, f) {
+		return
+	}
+
+	// Secrets are removed before the message goes anywhere
+	message := Redact(fmt.Sprintf(f, v...))
+
+	show, summary := deduplicate(l, fields, message)
+	summary.write()
+	if !show {
+		return
+	}
+
+	write(l, fields, message)
+}
 
-		line = fmt.Sprintf("\x1b[%sm%s %-6s\x1b[0m \x1b[%sm%s\x1b[0m\n", prefixColor, now, level, messageColor, message)
+// write sends a line to the terminal and any backends
+func write(l Level, fields Fields, message string) {
+	var line string
+	if format == JSONFormat {
+		line = jsonLine(l, fields, message)
 	} else {
-		line = fmt.Sprintf("%s %-6s %s\n", now, level, message)
+		line = textLine(l, fields, message)
 	}
 
 	// Make sure we're only outputing a line one at a time
 	mutex.Lock()
 	fmt.Fprint(OutputPipe(), line)
 	mutex.Unlock()
+
+	// Backends don't know about fields, so they get them as a prefix
+	if len(fields) > 0 {
+		message = fields.String() + " " + message
+	}
+
+	writeToBackends(l, message)
+}
+
+// jsonEntry is the structure of a single line of JSON formatted output
+type jsonEntry struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Message   string `json:"message"`
+	Fields    Fields `json:"fields,omitempty"`
+}
+
+func jsonLine(l Level, fields Fields, message string) string {
+	b, err := json.Marshal(jsonEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     strings.ToLower(l.String()),
+		Message:   message,
+		Fields:    fields,
+	})
+	if err != nil {
+		// Strings always marshal, but fall back to text just in case
+		return textLine(l, fields, message)
+	}
+
+	return string(b) + "\n"
+}
+
+func textLine(l Level, fields Fields, message string) string {
+	if len(fields) > 0 {
+		message = fields.String() + " " + message
+	}
+
+	t := currentTheme()
+	level := strings.ToUpper(l.String())
+	now := timestamp(time.Now())
+	line := ""
+
+	// Pad levels so that messages line up, allowing room for any symbol
+	width := 6
+	if symbol, ok := t.symbols[l]; ok {
+		level = symbol + " " + level
+		width += 2
+	}
+
+	if ColorsEnabled() && t.prefixColors != nil {
+		prefixColor := t.prefixColors[l]
+		messageColor, ok := t.messageColors[l]
+		if !ok {
+			messageColor = nocolor
+		}
+
+		line = fmt.Sprintf("\x1b[%sm%s %-*s\x1b[0m \x1b[%sm%s\x1b[0m\n", prefixColor, now, width, level, messageColor, message)
+	} else {
+		line = fmt.Sprintf("%s %-*s %s\n", now, width, level, message)
+	}
+
+	return line
 }
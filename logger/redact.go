@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RedactedReplacement is what secrets are replaced with in log output
+const RedactedReplacement = "[REDACTED]"
+
+// Values shorter than this are too likely to appear by accident (and mask
+// innocent parts of log lines) to be worth redacting
+const minRedactedValueLength = 6
+
+var redactedValues []string
+var redactedPatterns = []*regexp.Regexp{
+	// AWS access key IDs
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+}
+var redactMutex = sync.RWMutex{}
+
+// The user and password, and the query string, of URLs in a string, which
+// are where URLs usually carry credentials or signatures
+var (
+	urlUserinfoRegex = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://)[^/\s,@]+@`)
+	urlQueryRegex    = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://[^\s,?]*\?)[^\s,]+`)
+)
+
+// RedactValue masks every occurrence of the value in log output from now on.
+// Subsystems that load tokens or keys should register them here.
+func RedactValue(value string) {
+	if len(value) < minRedactedValueLength {
+		return
+	}
+
+	redactMutex.Lock()
+	defer redactMutex.Unlock()
+
+	for _, v := range redactedValues {
+		if v == value {
+			return
+		}
+	}
+
+	redactedValues = append(redactedValues, value)
+}
+
+// RedactPattern masks every match of the regular expression in log output
+// from now on, for secrets with a recognisable shape but unknown value.
+func RedactPattern(re *regexp.Regexp) {
+	redactMutex.Lock()
+	defer redactMutex.Unlock()
+
+	redactedPatterns = append(redactedPatterns, re)
+}
+
+// Redact returns the string with all the registered values and patterns
+// replaced with RedactedReplacement
+func Redact(s string) string {
+	redactMutex.RLock()
+	defer redactMutex.RUnlock()
+
+	for _, v := range redactedValues {
+		s = strings.Replace(s, v, RedactedReplacement, -1)
+	}
+
+	for _, re := range redactedPatterns {
+		s = re.ReplaceAllLiteralString(s, RedactedReplacement)
+	}
+
+	return s
+}
+
+// RedactURLs returns the string with the user information and query strings
+// of any URLs in it replaced with RedactedReplacement, for showing settings
+// whose values we can't otherwise tell are secret
+func RedactURLs(s string) string {
+	s = urlUserinfoRegex.ReplaceAllString(s, "${1}"+RedactedReplacement+"@")
+	return urlQueryRegex.ReplaceAllString(s, "${1}"+RedactedReplacement)
+}
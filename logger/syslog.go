@@ -0,0 +1,60 @@
+// +build !windows
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+)
+
+type syslogBackend struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogBackend connects to syslog. The address is either "local" for the
+// local syslog daemon, or a URL for a remote one like "udp://logs:514".
+func NewSyslogBackend(address string, tag string) (Backend, error) {
+	var network, raddr string
+
+	if address != "" && address != "local" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse syslog address `%s`: %v", address, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("Syslog address `%s` should look like udp://host:port", address)
+		}
+
+		network = u.Scheme
+		raddr = u.Host
+	}
+
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to syslog: %v", err)
+	}
+
+	return &syslogBackend{writer: w}, nil
+}
+
+func (b *syslogBackend) Write(l Level, message string) error {
+	switch l {
+	case DEBUG:
+		return b.writer.Debug(message)
+	case NOTICE:
+		return b.writer.Notice(message)
+	case WARN:
+		return b.writer.Warning(message)
+	case ERROR:
+		return b.writer.Err(message)
+	case FATAL:
+		return b.writer.Crit(message)
+	default:
+		return b.writer.Info(message)
+	}
+}
+
+func (b *syslogBackend) Close() error {
+	return b.writer.Close()
+}
@@ -0,0 +1,8 @@
+package logger
+
+import "errors"
+
+// NewSyslogBackend isn't available on Windows, use NewEventLogBackend instead
+func NewSyslogBackend(address string, tag string) (Backend, error) {
+	return nil, errors.New("Syslog isn't supported on Windows, use the Windows Event Log instead")
+}
@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	nocolor = "0"
+	red     = "31"
+	green   = "1;32"
+	yellow  = "33"
+	blue    = "34"
+	gray    = "1;30"
+	cyan    = "1;36"
+)
+
+const (
+	DefaultTheme      = "default"
+	HighContrastTheme = "high-contrast"
+	MonochromeTheme   = "monochrome"
+)
+
+// theme controls how the level prefix and message of each line look in
+// text output. Levels without a message color use nocolor.
+type theme struct {
+	prefixColors  map[Level]string
+	messageColors map[Level]string
+
+	// Symbols are shown before the level name, which helps tell levels
+	// apart when colors aren't available
+	symbols map[Level]string
+}
+
+var themes = map[string]theme{
+	DefaultTheme: {
+		prefixColors: map[Level]string{
+			DEBUG:  gray,
+			INFO:   green,
+			NOTICE: cyan,
+			WARN:   yellow,
+			ERROR:  red,
+			FATAL:  red,
+		},
+		messageColors: map[Level]string{
+			DEBUG: gray,
+			FATAL: red,
+		},
+	},
+	HighContrastTheme: {
+		prefixColors: map[Level]string{
+			DEBUG:  "1;37",
+			INFO:   "1;92",
+			NOTICE: "1;96",
+			WARN:   "1;93",
+			ERROR:  "1;91",
+			FATAL:  "1;97;41",
+		},
+		messageColors: map[Level]string{
+			DEBUG: "37",
+			INFO:  "97",
+			ERROR: "1;91",
+			FATAL: "1;97;41",
+		},
+	},
+	MonochromeTheme: {
+		symbols: map[Level]string{
+			DEBUG:  "·",
+			INFO:   "•",
+			NOTICE: "ℹ",
+			WARN:   "⚠",
+			ERROR:  "✖",
+			FATAL:  "☠",
+		},
+	},
+}
+
+var themeName = DefaultTheme
+var themeMutex = sync.RWMutex{}
+
+// SetTheme changes the colors (and symbols) used for text output
+func SetTheme(name string) error {
+	if _, ok := themes[name]; !ok {
+		names := []string{}
+		for n := range themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		return fmt.Errorf("Unknown color theme `%s` (expected one of %s)", name, strings.Join(names, ", "))
+	}
+
+	themeMutex.Lock()
+	themeName = name
+	themeMutex.Unlock()
+
+	return nil
+}
+
+func currentTheme() theme {
+	themeMutex.RLock()
+	defer themeMutex.RUnlock()
+
+	return themes[themeName]
+}
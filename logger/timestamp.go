@@ -0,0 +1,74 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Layouts that can be given to SetTimestampFormat by name
+var timestampLayouts = map[string]string{
+	"datetime":    "2006-01-02 15:04:05",
+	"time":        "15:04:05",
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+}
+
+const DefaultTimestampFormat = "datetime"
+
+var timestampLayout = timestampLayouts[DefaultTimestampFormat]
+var timestampLocation = time.Local
+
+// SetTimestampFormat changes how the time is shown at the start of each line
+// of text output. It's either the name of one of our layouts (datetime, time,
+// rfc3339 or rfc3339nano), or a Go time layout like "Jan _2 15:04:05.000".
+// JSON output always uses RFC3339 in UTC.
+func SetTimestampFormat(f string) error {
+	if layout, ok := timestampLayouts[strings.ToLower(f)]; ok {
+		timestampLayout = layout
+		return nil
+	}
+
+	if !IsTimeLayout(f) {
+		return fmt.Errorf("Unknown log timestamp format `%s` (expected datetime, time, rfc3339, rfc3339nano or a Go time layout)", f)
+	}
+
+	timestampLayout = f
+	return nil
+}
+
+// SetTimezone changes the timezone the time at the start of each line of
+// text output is in. It's either "local", "utc" or a name from the IANA time
+// zone database like "Australia/Melbourne".
+func SetTimezone(name string) error {
+	location, err := ParseTimezone(name)
+	if err != nil {
+		return fmt.Errorf("Unknown log timezone `%s`: %v", name, err)
+	}
+
+	timestampLocation = location
+	return nil
+}
+
+// ParseTimezone returns the location for a timezone, which is either
+// "local", "utc" or a name from the IANA time zone database
+func ParseTimezone(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(name)
+}
+
+// IsTimeLayout returns whether a string is a Go time layout. A string
+// without any parts of the reference time in it formats as itself.
+func IsTimeLayout(layout string) bool {
+	return time.Now().Format(layout) != layout
+}
+
+func timestamp(t time.Time) string {
+	return t.In(timestampLocation).Format(timestampLayout)
+}
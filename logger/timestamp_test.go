@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestamps(t *testing.T) {
+	defer SetTimestampFormat(DefaultTimestampFormat)
+	defer SetTimezone("local")
+
+	at := time.Date(2018, 10, 17, 9, 30, 15, 0, time.UTC)
+
+	assert.NoError(t, SetTimezone("utc"))
+	assert.Equal(t, "2018-10-17 09:30:15", timestamp(at))
+
+	assert.NoError(t, SetTimestampFormat("time"))
+	assert.Equal(t, "09:30:15", timestamp(at))
+
+	assert.NoError(t, SetTimestampFormat("RFC3339"))
+	assert.Equal(t, "2018-10-17T09:30:15Z", timestamp(at))
+
+	assert.NoError(t, SetTimestampFormat("Jan _2 15:04:05.000"))
+	assert.Equal(t, "Oct 17 09:30:15.000", timestamp(at))
+
+	assert.NoError(t, SetTimezone("Australia/Melbourne"))
+	assert.Equal(t, "Oct 17 20:30:15.000", timestamp(at))
+}
+
+func TestInvalidTimestamps(t *testing.T) {
+	assert.Error(t, SetTimestampFormat("llamas"))
+	assert.Error(t, SetTimezone("Middle/Earth"))
+}
+
+func TestParseTimezone(t *testing.T) {
+	for name, expected := range map[string]*time.Location{"local": time.Local, "UTC": time.UTC} {
+		location, err := ParseTimezone(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, location)
+	}
+
+	location, err := ParseTimezone("Australia/Melbourne")
+	assert.NoError(t, err)
+	assert.Equal(t, "Australia/Melbourne", location.String())
+
+	_, err = ParseTimezone("Middle/Earth")
+	assert.Error(t, err)
+}
@@ -59,6 +59,13 @@ func main() {
 	app.Commands = []cli.Command{
 		clicommand.AgentStartCommand,
 		clicommand.AnnotateCommand,
+		{
+			Name:  "config",
+			Usage: "Inspect the agent's configuration",
+			Subcommands: []cli.Command{
+				clicommand.ConfigShowCommand,
+			},
+		},
 		{
 			Name:  "artifact",
 			Usage: "Upload/download artifacts from Buildkite jobs",
@@ -68,6 +75,29 @@ func main() {
 				clicommand.ArtifactShasumCommand,
 			},
 		},
+		{
+			Name:  "build",
+			Usage: "Get information about the current build",
+			Subcommands: []cli.Command{
+				clicommand.BuildStatusCommand,
+			},
+		},
+		{
+			Name:  "cache",
+			Usage: "Save and restore files between builds",
+			Subcommands: []cli.Command{
+				clicommand.CacheSaveCommand,
+				clicommand.CacheRestoreCommand,
+			},
+		},
+		{
+			Name:  "coverage",
+			Usage: "Report on how much of the code the tests cover",
+			Subcommands: []cli.Command{
+				clicommand.CoverageUploadCommand,
+			},
+		},
+		clicommand.MetricsCommand,
 		{
 			Name:  "meta-data",
 			Usage: "Get/set data from Buildkite jobs",
@@ -91,7 +121,18 @@ func main() {
 				clicommand.StepUpdateCommand,
 			},
 		},
+		{
+			Name:  "test-results",
+			Usage: "Report the results of a job's tests",
+			Subcommands: []cli.Command{
+				clicommand.TestResultsUploadCommand,
+			},
+		},
+		clicommand.PreflightCommand,
+		clicommand.SupportBundleCommand,
 		clicommand.BootstrapCommand,
+		clicommand.KubernetesBootstrapCommand,
+		clicommand.IsolatedBootstrapCommand,
 	}
 
 	// When no sub command is used
@@ -0,0 +1,62 @@
+package pool
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Errors is every error returned by the jobs in an ErrorPool
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// ErrorPool is a Pool whose jobs can fail. It hangs on to the errors that
+// jobs return so they can be dealt with once everything has finished.
+type ErrorPool struct {
+	*Pool
+
+	errors   Errors
+	errorsMu sync.Mutex
+}
+
+func NewErrorPool(concurrencyLimit int) *ErrorPool {
+	return NewErrorPoolWithContext(context.Background(), concurrencyLimit)
+}
+
+func NewErrorPoolWithContext(ctx context.Context, concurrencyLimit int) *ErrorPool {
+	return &ErrorPool{Pool: NewWithContext(ctx, concurrencyLimit)}
+}
+
+// Spawn runs the job in the pool, collecting the error it returns (if any)
+func (pool *ErrorPool) Spawn(job func() error) bool {
+	return pool.Pool.Spawn(func() {
+		if err := job(); err != nil {
+			pool.errorsMu.Lock()
+			pool.errors = append(pool.errors, err)
+			pool.errorsMu.Unlock()
+		}
+	})
+}
+
+// Wait waits for all the jobs to finish, and returns an Errors with
+// everything they returned, or nil if none of them failed
+func (pool *ErrorPool) Wait() error {
+	pool.Pool.Wait()
+
+	pool.errorsMu.Lock()
+	defer pool.errorsMu.Unlock()
+
+	if len(pool.errors) == 0 {
+		return nil
+	}
+
+	return append(Errors{}, pool.errors...)
+}
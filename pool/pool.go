@@ -1,14 +1,24 @@
 package pool
 
 import (
+	"context"
 	"runtime"
 	"sync"
 )
 
 type Pool struct {
-	wg         *sync.WaitGroup
-	completion chan bool
-	m          sync.Mutex
+	wg *sync.WaitGroup
+	m  sync.Mutex
+
+	// The context that, once done, stops any more jobs from starting
+	context context.Context
+
+	// Guards the concurrency limit and the count of running jobs, and is
+	// signalled whenever either of them change
+	state   sync.Mutex
+	changed *sync.Cond
+	limit   int
+	running int
 }
 
 const (
@@ -16,32 +26,96 @@ const (
 )
 
 func New(concurrencyLimit int) *Pool {
-	if concurrencyLimit == MaxConcurrencyLimit {
-		concurrencyLimit = runtime.NumCPU()
-	}
+	return NewWithContext(context.Background(), concurrencyLimit)
+}
 
-	wg := sync.WaitGroup{}
-	completionChan := make(chan bool, concurrencyLimit)
+// NewWithContext returns a pool that abandons any jobs that haven't started
+// yet once the context is done. Jobs that are already running are left to
+// finish, so they should watch the context themselves if they need to stop.
+func NewWithContext(ctx context.Context, concurrencyLimit int) *Pool {
+	pool := &Pool{
+		wg:      &sync.WaitGroup{},
+		context: ctx,
+		limit:   normalizeLimit(concurrencyLimit),
+	}
+	pool.changed = sync.NewCond(&pool.state)
 
-	for i := 0; i < concurrencyLimit; i++ {
-		completionChan <- true
+	// Wake up anything waiting in Spawn when the context is done
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			pool.state.Lock()
+			pool.changed.Broadcast()
+			pool.state.Unlock()
+		}()
 	}
 
-	return &Pool{&wg, completionChan, sync.Mutex{}}
+	return pool
+}
+
+func normalizeLimit(concurrencyLimit int) int {
+	if concurrencyLimit == MaxConcurrencyLimit {
+		return runtime.NumCPU()
+	}
+	if concurrencyLimit < 1 {
+		return 1
+	}
+	return concurrencyLimit
 }
 
-func (pool *Pool) Spawn(job func()) {
-	<-pool.completion
+// Spawn blocks until there's room in the pool and then runs the job in a
+// goroutine. If the pool's context is done before that, the job is dropped
+// and Spawn returns false.
+func (pool *Pool) Spawn(job func()) bool {
+	pool.state.Lock()
+	for pool.running >= pool.limit && pool.context.Err() == nil {
+		pool.changed.Wait()
+	}
+	if pool.context.Err() != nil {
+		pool.state.Unlock()
+		return false
+	}
+	pool.running++
+	pool.state.Unlock()
+
 	pool.wg.Add(1)
 
 	go func() {
 		defer func() {
-			pool.completion <- true
+			pool.state.Lock()
+			pool.running--
+			pool.changed.Broadcast()
+			pool.state.Unlock()
+
 			pool.wg.Done()
 		}()
 
 		job()
 	}()
+
+	return true
+}
+
+// Resize changes how many jobs can run at once. Shrinking the pool doesn't
+// stop jobs that are already running, it just holds back new ones until
+// enough have finished.
+func (pool *Pool) Resize(concurrencyLimit int) {
+	pool.state.Lock()
+	pool.limit = normalizeLimit(concurrencyLimit)
+	pool.changed.Broadcast()
+	pool.state.Unlock()
+}
+
+// Size returns how many jobs can currently run at once
+func (pool *Pool) Size() int {
+	pool.state.Lock()
+	defer pool.state.Unlock()
+	return pool.limit
+}
+
+// Err returns the error from the pool's context, if it's done
+func (pool *Pool) Err() error {
+	return pool.context.Err()
 }
 
 func (pool *Pool) Lock() {
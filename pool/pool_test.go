@@ -0,0 +1,110 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolRunsAllJobs(t *testing.T) {
+	p := New(2)
+
+	var m sync.Mutex
+	ran := 0
+
+	for i := 0; i < 10; i++ {
+		p.Spawn(func() {
+			m.Lock()
+			ran++
+			m.Unlock()
+		})
+	}
+	p.Wait()
+
+	assert.Equal(t, 10, ran)
+}
+
+func TestPoolAbandonsQueuedJobsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewWithContext(ctx, 1)
+
+	release := make(chan struct{})
+	assert.True(t, p.Spawn(func() { <-release }))
+
+	spawned := make(chan bool)
+	go func() {
+		spawned <- p.Spawn(func() { t.Error("Job shouldn't have run") })
+	}()
+
+	cancel()
+	assert.False(t, <-spawned)
+	assert.Equal(t, context.Canceled, p.Err())
+
+	close(release)
+	p.Wait()
+}
+
+func TestPoolResize(t *testing.T) {
+	p := New(1)
+	assert.Equal(t, 1, p.Size())
+
+	release := make(chan struct{})
+	p.Spawn(func() { <-release })
+
+	spawned := make(chan struct{})
+	go func() {
+		p.Spawn(func() {})
+		close(spawned)
+	}()
+
+	select {
+	case <-spawned:
+		t.Fatal("Spawn should block while the pool is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	p.Resize(2)
+	assert.Equal(t, 2, p.Size())
+
+	select {
+	case <-spawned:
+	case <-time.After(time.Second):
+		t.Fatal("Spawn should have run after the pool grew")
+	}
+
+	close(release)
+	p.Wait()
+}
+
+func TestErrorPoolCollectsErrors(t *testing.T) {
+	p := NewErrorPool(2)
+
+	for i := 0; i < 5; i++ {
+		i := i
+		p.Spawn(func() error {
+			if i%2 == 0 {
+				return fmt.Errorf("Job %d failed", i)
+			}
+			return nil
+		})
+	}
+
+	err := p.Wait()
+	assert.Error(t, err)
+
+	errs, ok := err.(Errors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 3)
+}
+
+func TestErrorPoolReturnsNilWithoutErrors(t *testing.T) {
+	p := NewErrorPool(2)
+
+	p.Spawn(func() error { return nil })
+
+	assert.NoError(t, p.Wait())
+}
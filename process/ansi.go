@@ -0,0 +1,95 @@
+package process
+
+import (
+	"bytes"
+	"io"
+)
+
+// The states of ansiStripper, as it works through an escape sequence
+const (
+	ansiText = iota
+	ansiEscape
+	ansiCSI
+	ansiOSC
+	ansiOSCEscape
+	ansiCharset
+)
+
+// ansiStripper is a writer that removes ANSI escape sequences (colors,
+// cursor movement, window titles and so on) from what's written to it,
+// before passing it on. It keeps track of where it is in a sequence, so
+// sequences can be split across writes.
+type ansiStripper struct {
+	w     io.Writer
+	state int
+}
+
+func newANSIStripper(w io.Writer) *ansiStripper {
+	return &ansiStripper{w: w}
+}
+
+func (s *ansiStripper) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+
+	for _, b := range p {
+		switch s.state {
+		case ansiText:
+			if b == 0x1b {
+				s.state = ansiEscape
+			} else {
+				out = append(out, b)
+			}
+
+		case ansiEscape:
+			switch b {
+			case '[':
+				s.state = ansiCSI
+			case ']':
+				s.state = ansiOSC
+			case '(', ')', '*', '+':
+				s.state = ansiCharset
+			default:
+				// Anything else is a two byte sequence, like ESC 7
+				s.state = ansiText
+			}
+
+		case ansiCSI:
+			// Parameters and intermediates are 0x20-0x3f, and the
+			// sequence ends with anything from 0x40 to 0x7e
+			if b >= 0x40 && b <= 0x7e {
+				s.state = ansiText
+			}
+
+		case ansiOSC:
+			// Ended by BEL, or ESC \
+			if b == 0x07 {
+				s.state = ansiText
+			} else if b == 0x1b {
+				s.state = ansiOSCEscape
+			}
+
+		case ansiOSCEscape:
+			if b == '\\' {
+				s.state = ansiText
+			} else {
+				s.state = ansiOSC
+			}
+
+		case ansiCharset:
+			s.state = ansiText
+		}
+	}
+
+	if _, err := s.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// stripANSI removes ANSI escape sequences from a string
+func stripANSI(str string) string {
+	var buf bytes.Buffer
+	newANSIStripper(&buf).Write([]byte(str))
+	return buf.String()
+}
@@ -0,0 +1,38 @@
+package process
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStripANSI(t *testing.T) {
+	for _, tc := range []struct {
+		input, expected string
+	}{
+		{"plain text\n", "plain text\n"},
+		{"\x1b[31mred\x1b[0m and \x1b[1;32mbold green\x1b[m", "red and bold green"},
+		{"\x1b[2K\x1b[1Gprogress 50%\r", "progress 50%\r"},
+		{"\x1b]0;window title\x07after", "after"},
+		{"\x1b]8;;https://buildkite.com\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"\x1b(Bcharset\x1b7saved", "charsetsaved"},
+	} {
+		if actual := stripANSI(tc.input); actual != tc.expected {
+			t.Errorf("stripANSI(%q) = %q, expected %q", tc.input, actual, tc.expected)
+		}
+	}
+}
+
+func TestStripANSIAcrossWrites(t *testing.T) {
+	var buf bytes.Buffer
+	s := newANSIStripper(&buf)
+
+	for _, chunk := range []string{"\x1b", "[3", "1m", "red", "\x1b[", "0m"} {
+		if n, err := s.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+
+	if buf.String() != "red" {
+		t.Fatalf("Expected %q, got %q", "red", buf.String())
+	}
+}
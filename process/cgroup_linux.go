@@ -0,0 +1,159 @@
+// +build linux
+
+package process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// Where the cgroup v2 hierarchy is mounted. It's a variable so that tests can
+// point it somewhere else.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// A cgroup that a process joins, so that its CPU and memory use can
+// be limited along with anything it starts
+type cgroup struct {
+	path string
+
+	// A pipe that the process writes to when it can't join the cgroup
+	joinErrors, joinErrorsWriter *os.File
+}
+
+// newCgroup creates a cgroup under parent with the given limits. The parent
+// has to be delegated to the agent and have no processes of its own, as
+// cgroups v2 only lets cgroups without processes hand controllers down. That
+// rules out the agent's own cgroup, so there's no default.
+func newCgroup(parent string, cpuWeight int, memoryMax int64) (*cgroup, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("Resource limits need cgroups v2, which isn't mounted at %s", cgroupRoot)
+	}
+
+	if cpuWeight != 0 && (cpuWeight < 1 || cpuWeight > 10000) {
+		return nil, fmt.Errorf("CPU weight must be between 1 and 10000, not %d", cpuWeight)
+	}
+
+	if memoryMax < 0 {
+		return nil, fmt.Errorf("Memory max can't be negative")
+	}
+
+	if parent == "" {
+		return nil, fmt.Errorf("Resource limits need a parent cgroup that doesn't have any processes in it")
+	}
+
+	parentPath := filepath.Join(cgroupRoot, parent)
+
+	// The controllers have to be handed down to the new cgroup by its parent
+	var controllers []string
+	if cpuWeight > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if memoryMax > 0 {
+		controllers = append(controllers, "+memory")
+	}
+
+	if err := writeCgroupFile(parentPath, "cgroup.subtree_control", strings.Join(controllers, " ")); err != nil {
+		// Only the root cgroup can have processes of its own and hand
+		// controllers down, which is the usual reason for this failing
+		// (with EBUSY)
+		return nil, fmt.Errorf("Failed to enable the cgroup controllers for %s, which needs a cgroup that doesn't have any processes in it: %v", parentPath, err)
+	}
+
+	path, err := ioutil.TempDir(parentPath, "buildkite-process-")
+	if err != nil {
+		return nil, err
+	}
+
+	cg := &cgroup{path: path}
+
+	if cpuWeight > 0 {
+		if err := writeCgroupFile(path, "cpu.weight", fmt.Sprintf("%d", cpuWeight)); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+
+	if memoryMax > 0 {
+		if err := writeCgroupFile(path, "memory.max", fmt.Sprintf("%d", memoryMax)); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+
+	logger.Debug("[Process] Created cgroup %s", path)
+
+	return cg, nil
+}
+
+// wrap returns the script wrapped so that the process joins the cgroup and
+// then execs the script, keeping the same PID, so that nothing it starts can
+// escape the limits. Why it couldn't join is written to a pipe that's passed
+// to it after the extra files, which joined reads once it's started.
+func (cg *cgroup) wrap(script []string, extraFiles []*os.File) ([]string, []*os.File, error) {
+	// Leave scripts that can't be found alone, so they fail to start in the
+	// same way as they would without limits
+	if _, err := exec.LookPath(script[0]); err != nil {
+		return script, extraFiles, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	cg.joinErrors, cg.joinErrorsWriter = r, w
+
+	fd := 3 + len(extraFiles)
+	wrapper := fmt.Sprintf(`{ echo $$ > "$1"; } 2>&%[1]d || { echo "Couldn't write to $1" >&%[1]d; exit 1; }; exec %[1]d>&-; shift; exec "$@"`, fd)
+
+	return append([]string{
+		"/bin/sh", "-c", wrapper, "buildkite-cgroup", filepath.Join(cg.path, "cgroup.procs"),
+	}, script...), append(append([]*os.File{}, extraFiles...), w), nil
+}
+
+// joined waits for a process started with a wrapped script to join the
+// cgroup, returning why it couldn't if it didn't
+func (cg *cgroup) joined() error {
+	if cg.joinErrors == nil {
+		return nil
+	}
+
+	// Only the process has the pipe open for writing now, until it execs
+	// the script or exits
+	cg.joinErrorsWriter.Close()
+	cg.joinErrorsWriter = nil
+
+	out, err := ioutil.ReadAll(cg.joinErrors)
+	if err != nil {
+		return err
+	}
+
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("Failed to move the process into cgroup %s: %s", cg.path, strings.Replace(msg, "\n", "; ", -1))
+	}
+
+	return nil
+}
+
+// remove removes the cgroup, which only works once everything in it has
+// exited
+func (cg *cgroup) remove() {
+	for _, f := range []*os.File{cg.joinErrors, cg.joinErrorsWriter} {
+		if f != nil {
+			f.Close()
+		}
+	}
+
+	if err := os.Remove(cg.path); err != nil {
+		logger.Warn("[Process] Failed to remove cgroup %s: %v", cg.path, err)
+	}
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
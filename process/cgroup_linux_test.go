@@ -0,0 +1,149 @@
+// +build linux
+
+package process
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewCgroupWritesLimits(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	defer func(original string) { cgroupRoot = original }(cgroupRoot)
+	cgroupRoot = root
+
+	if err := ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "agents"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cg, err := newCgroup("/agents", 200, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Dir(cg.path) != filepath.Join(root, "agents") {
+		t.Fatalf("Cgroup created in the wrong place: %s", cg.path)
+	}
+
+	for file, expected := range map[string]string{
+		filepath.Join(root, "agents", "cgroup.subtree_control"): "+cpu +memory",
+		filepath.Join(cg.path, "cpu.weight"):                   "200",
+		filepath.Join(cg.path, "memory.max"):                   "1048576",
+	} {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("Expected %s to be %q, got %q", file, expected, b)
+		}
+	}
+}
+
+func TestCgroupWrapperJoinsBeforeRunningTheScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cg := &cgroup{path: dir}
+	defer cg.remove()
+
+	script, extraFiles, err := cg.wrap([]string{"/bin/sh", "-c", "echo $$"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(script[0], script[1:]...)
+	cmd.ExtraFiles = extraFiles
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cg.joined(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The script keeps the PID that joined the cgroup
+	b, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(b)) != strings.TrimSpace(out.String()) {
+		t.Fatalf("Expected the script's PID %q in cgroup.procs, got %q", out.String(), b)
+	}
+}
+
+func TestCgroupWrapperReportsFailingToJoin(t *testing.T) {
+	cg := &cgroup{path: "/does/not/exist"}
+	defer cg.remove()
+
+	script, extraFiles, err := cg.wrap([]string{"/bin/sh", "-c", "echo llamas"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(script[0], script[1:]...)
+	cmd.ExtraFiles = extraFiles
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cg.joined(); err == nil || !strings.Contains(err.Error(), "/does/not/exist/cgroup.procs") {
+		t.Fatalf("Expected an error about joining the cgroup, got %v", err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Fatal("Expected the wrapper to exit with an error")
+	}
+	if out.Len() != 0 {
+		t.Fatalf("Expected the script not to run, got %q", out.String())
+	}
+}
+
+func TestNewCgroupValidatesLimits(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	defer func(original string) { cgroupRoot = original }(cgroupRoot)
+	cgroupRoot = root
+
+	if _, err := newCgroup("/", 100, 0); err == nil || !strings.Contains(err.Error(), "cgroups v2") {
+		t.Fatalf("Expected an error about cgroups v2, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newCgroup("/", 20000, 0); err == nil {
+		t.Fatalf("Expected an error for a CPU weight that's too high")
+	}
+
+	if _, err := newCgroup("", 100, 0); err == nil {
+		t.Fatalf("Expected an error without a parent cgroup")
+	}
+}
@@ -0,0 +1,22 @@
+// +build !linux
+
+package process
+
+import (
+	"errors"
+	"os"
+)
+
+type cgroup struct{}
+
+func newCgroup(parent string, cpuWeight int, memoryMax int64) (*cgroup, error) {
+	return nil, errors.New("Resource limits are only supported on Linux")
+}
+
+func (cg *cgroup) wrap(script []string, extraFiles []*os.File) ([]string, []*os.File, error) {
+	return script, extraFiles, nil
+}
+
+func (cg *cgroup) joined() error { return nil }
+
+func (cg *cgroup) remove() {}
@@ -0,0 +1,132 @@
+// +build !windows
+
+package process
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runAs makes the command run as another user, given as a name or ID with an
+// optional group after a colon, e.g. "buildkite" or "1000:1000". Without a
+// group, the user's primary group and supplementary groups are used. The
+// user's HOME, USER and LOGNAME are set, so they come before any of the
+// process's own Env.
+func runAs(cmd *exec.Cmd, spec string) error {
+	credential, u, err := lookupCredential(spec)
+	if err != nil {
+		return err
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = credential
+
+	cmd.Env = append(cmd.Env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+
+	return nil
+}
+
+// LookupUserIDs returns the user and group IDs that a process with the User
+// spec runs as, e.g. so that files it needs to write can be given to it
+func LookupUserIDs(spec string) (int, int, error) {
+	credential, _, err := lookupCredential(spec)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(credential.Uid), int(credential.Gid), nil
+}
+
+// lookupCredential works out the credential for a user spec, as described
+// for runAs
+func lookupCredential(spec string) (*syscall.Credential, *user.User, error) {
+	userSpec, groupSpec := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userSpec, groupSpec = spec[:i], spec[i+1:]
+	}
+
+	u, found, err := lookupUser(userSpec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("User %q has a UID that isn't a number: %s", userSpec, u.Uid)
+	}
+
+	credential := &syscall.Credential{Uid: uint32(uid)}
+
+	if groupSpec != "" {
+		gid, err := lookupGroupID(groupSpec)
+		if err != nil {
+			return nil, nil, err
+		}
+		credential.Gid = gid
+	} else {
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("User %q has a GID that isn't a number: %s", userSpec, u.Gid)
+		}
+		credential.Gid = uint32(gid)
+
+		if found {
+			groupIDs, err := u.GroupIds()
+			if err != nil {
+				return nil, nil, fmt.Errorf("Failed to find the groups of user %q: %v", userSpec, err)
+			}
+			for _, id := range groupIDs {
+				if gid, err := strconv.ParseUint(id, 10, 32); err == nil {
+					credential.Groups = append(credential.Groups, uint32(gid))
+				}
+			}
+		}
+	}
+
+	return credential, u, nil
+}
+
+// lookupUser finds a user by name, or by ID if it's a number. A user given
+// by ID doesn't need to exist, like in a container, in which case it isn't
+// found and its group has the same ID.
+func lookupUser(spec string) (*user.User, bool, error) {
+	if _, err := strconv.ParseUint(spec, 10, 32); err == nil {
+		if u, err := user.LookupId(spec); err == nil {
+			return u, true, nil
+		}
+
+		return &user.User{Uid: spec, Gid: spec, Username: spec, HomeDir: "/"}, false, nil
+	}
+
+	u, err := user.Lookup(spec)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to find user %q: %v", spec, err)
+	}
+
+	return u, true, nil
+}
+
+// lookupGroupID finds a group's ID by name, or returns it if it's a number
+func lookupGroupID(spec string) (uint32, error) {
+	if gid, err := strconv.ParseUint(spec, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+
+	g, err := user.LookupGroup(spec)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find group %q: %v", spec, err)
+	}
+
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Group %q has a GID that isn't a number: %s", spec, g.Gid)
+	}
+
+	return uint32(gid), nil
+}
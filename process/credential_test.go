@@ -0,0 +1,68 @@
+// +build !windows
+
+package process
+
+import (
+	"os/exec"
+	"os/user"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestRunAsUserAndGroupIDs(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := runAs(cmd, "54321:54322"); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := cmd.SysProcAttr.Credential; c.Uid != 54321 || c.Gid != 54322 || len(c.Groups) != 0 {
+		t.Fatalf("Unexpected credential %+v", c)
+	}
+}
+
+func TestRunAsUserName(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("Can't find the current user: %v", err)
+	}
+
+	cmd := exec.Command("true")
+	cmd.Env = []string{"PATH=/usr/bin"}
+	if err := runAs(cmd, current.Username); err != nil {
+		t.Fatal(err)
+	}
+
+	if uid := strconv.Itoa(int(cmd.SysProcAttr.Credential.Uid)); uid != current.Uid {
+		t.Fatalf("Expected UID %s, got %s", current.Uid, uid)
+	}
+	if gid := strconv.Itoa(int(cmd.SysProcAttr.Credential.Gid)); gid != current.Gid {
+		t.Fatalf("Expected GID %s, got %s", current.Gid, gid)
+	}
+
+	expected := []string{
+		"PATH=/usr/bin",
+		"HOME=" + current.HomeDir,
+		"USER=" + current.Username,
+		"LOGNAME=" + current.Username,
+	}
+	if !reflect.DeepEqual(cmd.Env, expected) {
+		t.Fatalf("Expected env %v, got %v", expected, cmd.Env)
+	}
+}
+
+func TestRunAsUnknownUser(t *testing.T) {
+	if err := runAs(exec.Command("true"), "no-such-user-llama"); err == nil {
+		t.Fatalf("Expected an error for a user that doesn't exist")
+	}
+}
+
+func TestLookupUserIDs(t *testing.T) {
+	uid, gid, err := LookupUserIDs("54321:54322")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != 54321 || gid != 54322 {
+		t.Fatalf("Expected 54321:54322, got %d:%d", uid, gid)
+	}
+}
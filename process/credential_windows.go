@@ -0,0 +1,16 @@
+package process
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func runAs(cmd *exec.Cmd, spec string) error {
+	return errors.New("Running processes as another user isn't supported on Windows")
+}
+
+// LookupUserIDs isn't supported on Windows, where processes can't be run as
+// another user
+func LookupUserIDs(spec string) (int, int, error) {
+	return 0, 0, errors.New("Running processes as another user isn't supported on Windows")
+}
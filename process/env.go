@@ -0,0 +1,51 @@
+package process
+
+import (
+	"path"
+	"runtime"
+	"strings"
+)
+
+// filterEnv returns the variables in env, as NAME=value, that match the
+// allowlist (unless it's empty) and don't match the denylist
+func filterEnv(env []string, allowlist []string, denylist []string) []string {
+	if len(allowlist) == 0 && len(denylist) == 0 {
+		return env
+	}
+
+	var filtered []string
+	for _, v := range env {
+		name := strings.SplitN(v, "=", 2)[0]
+
+		if len(allowlist) > 0 && !envNameMatches(name, allowlist) {
+			continue
+		}
+		if envNameMatches(name, denylist) {
+			continue
+		}
+
+		filtered = append(filtered, v)
+	}
+
+	return filtered
+}
+
+// envNameMatches returns whether the name of a variable matches any of the
+// patterns, which can have * wildcards
+func envNameMatches(name string, patterns []string) bool {
+	// Names aren't case sensitive on Windows
+	if runtime.GOOS == "windows" {
+		name = strings.ToUpper(name)
+	}
+
+	for _, pattern := range patterns {
+		if runtime.GOOS == "windows" {
+			pattern = strings.ToUpper(pattern)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
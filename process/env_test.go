@@ -0,0 +1,31 @@
+package process
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterEnv(t *testing.T) {
+	env := []string{
+		"PATH=/usr/bin",
+		"HOME=/home/buildkite",
+		"AWS_ACCESS_KEY_ID=xxx",
+		"AWS_SECRET_ACCESS_KEY=yyy",
+		"BUILDKITE_AGENT_TOKEN=zzz",
+		"EMPTY=",
+	}
+
+	for _, tc := range []struct {
+		allowlist, denylist, expected []string
+	}{
+		{nil, nil, env},
+		{[]string{"PATH", "HOME", "EMPTY"}, nil, []string{"PATH=/usr/bin", "HOME=/home/buildkite", "EMPTY="}},
+		{nil, []string{"AWS_*", "BUILDKITE_AGENT_TOKEN"}, []string{"PATH=/usr/bin", "HOME=/home/buildkite", "EMPTY="}},
+		{[]string{"AWS_*"}, []string{"AWS_SECRET_*"}, []string{"AWS_ACCESS_KEY_ID=xxx"}},
+		{[]string{"NOTHING"}, nil, nil},
+	} {
+		if actual := filterEnv(env, tc.allowlist, tc.denylist); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("filterEnv(%v, %v) = %v, expected %v", tc.allowlist, tc.denylist, actual, tc.expected)
+		}
+	}
+}
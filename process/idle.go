@@ -0,0 +1,87 @@
+package process
+
+import (
+	"sync"
+	"time"
+)
+
+// idleWatcher calls a function once nothing has been written to it for the
+// timeout. It's called again only if there's more output and then it goes
+// quiet again.
+type idleWatcher struct {
+	mu        sync.Mutex
+	timeout   time.Duration
+	onIdle    func(idle time.Duration)
+	lastWrite time.Time
+	fired     bool
+	closed    bool
+	stop      chan struct{}
+}
+
+func newIdleWatcher(timeout time.Duration, onIdle func(idle time.Duration)) *idleWatcher {
+	w := &idleWatcher{
+		timeout:   timeout,
+		onIdle:    onIdle,
+		lastWrite: time.Now(),
+		stop:      make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+func (w *idleWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(p) > 0 {
+		w.lastWrite = time.Now()
+		w.fired = false
+	}
+
+	return len(p), nil
+}
+
+func (w *idleWatcher) run() {
+	// Checking a few times per timeout means it's noticed soon after
+	ticker := time.NewTicker(w.timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if idle, ok := w.checkIdle(); ok {
+				w.onIdle(idle)
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *idleWatcher) checkIdle() (time.Duration, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	idle := time.Since(w.lastWrite)
+	if w.closed || w.fired || idle < w.timeout {
+		return 0, false
+	}
+
+	w.fired = true
+	return idle, true
+}
+
+// Close stops watching, after which the function isn't called again
+func (w *idleWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+	}
+
+	return nil
+}
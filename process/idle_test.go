@@ -0,0 +1,42 @@
+package process
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdleWatcherFiresOncePerQuietStretch(t *testing.T) {
+	var calls int32
+
+	w := newIdleWatcher(40*time.Millisecond, func(idle time.Duration) {
+		atomic.AddInt32(&calls, 1)
+	})
+	defer w.Close()
+
+	time.Sleep(150 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected 1 call after going quiet, got %d", n)
+	}
+
+	w.Write([]byte("llamas\n"))
+
+	time.Sleep(150 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("Expected another call after more output, got %d", n)
+	}
+}
+
+func TestIdleWatcherStopsWhenClosed(t *testing.T) {
+	var calls int32
+
+	w := newIdleWatcher(40*time.Millisecond, func(idle time.Duration) {
+		atomic.AddInt32(&calls, 1)
+	})
+	w.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("Expected no calls once closed, got %d", n)
+	}
+}
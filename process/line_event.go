@@ -0,0 +1,82 @@
+package process
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// The streams that a line of output can come from. With a PTY, the process
+// can't tell them apart, so they're both the PTY.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamPTY    = "pty"
+)
+
+// LineEvent is a line of output, along with where and when it came from, for
+// Process.LineEventCallback
+type LineEvent struct {
+	// The position of the line in the output of the process, across all of
+	// its streams, starting from 1
+	Seq int64
+
+	// When the end of the line was read
+	Time time.Time
+
+	// Which stream the line came from, one of the Stream constants
+	Stream string
+
+	// The line without its line ending. It hasn't been through the line
+	// processors.
+	Text string
+}
+
+// lineEventWriter splits what's written to it into lines, and emits each of
+// them as they end
+type lineEventWriter struct {
+	stream  string
+	emit    func(stream, text string)
+	partial []byte
+}
+
+func (w *lineEventWriter) Write(p []byte) (int, error) {
+	data := p
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, data...)
+			break
+		}
+
+		w.partial = append(w.partial, data[:i]...)
+		w.emit(w.stream, string(bytes.TrimSuffix(w.partial, []byte("\r"))))
+		w.partial = w.partial[:0]
+		data = data[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// flush emits whatever was left without a line ending
+func (w *lineEventWriter) flush() {
+	if len(w.partial) > 0 {
+		w.emit(w.stream, string(w.partial))
+		w.partial = nil
+	}
+}
+
+// lockedWriter lets stdout and stderr, which are copied by goroutines of
+// their own, share a writer
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(p)
+}
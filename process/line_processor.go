@@ -0,0 +1,17 @@
+package process
+
+// LineProcessor changes a line of output before it's given to the line
+// callbacks, like removing escape sequences or secrets from it
+type LineProcessor func(string) string
+
+// ProcessLine runs a line through each of the processors in order, with each
+// getting the output of the one before it
+func ProcessLine(processors []LineProcessor, line string) string {
+	for _, processor := range processors {
+		if processor != nil {
+			line = processor(line)
+		}
+	}
+
+	return line
+}
@@ -0,0 +1,23 @@
+package process
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProcessLineChainsProcessorsInOrder(t *testing.T) {
+	processors := []LineProcessor{
+		strings.ToUpper,
+		func(line string) string { return "> " + line },
+	}
+
+	if line := ProcessLine(processors, "llamas"); line != "> LLAMAS" {
+		t.Fatalf("Unexpected processed line: %q", line)
+	}
+}
+
+func TestProcessLineSkipsNilProcessors(t *testing.T) {
+	if line := ProcessLine([]LineProcessor{nil, strings.ToUpper, nil}, "llamas"); line != "LLAMAS" {
+		t.Fatalf("Unexpected processed line: %q", line)
+	}
+}
@@ -0,0 +1,83 @@
+package process
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// partialLineFlusher passes what's written to it on, and if a line is left
+// unfinished for the interval, like by a progress bar, ends it with a newline
+// so that it's read as a line of its own
+type partialLineFlusher struct {
+	mu        sync.Mutex
+	w         io.Writer
+	interval  time.Duration
+	partial   bool
+	lastWrite time.Time
+	closed    bool
+	stop      chan struct{}
+}
+
+func newPartialLineFlusher(w io.Writer, interval time.Duration) *partialLineFlusher {
+	f := &partialLineFlusher{
+		w:        w,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	go f.run()
+
+	return f
+}
+
+func (f *partialLineFlusher) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(p) > 0 {
+		f.partial = p[len(p)-1] != '\n'
+		f.lastWrite = time.Now()
+	}
+
+	return f.w.Write(p)
+}
+
+func (f *partialLineFlusher) run() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.flushIfIdle()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *partialLineFlusher) flushIfIdle() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed || !f.partial || time.Since(f.lastWrite) < f.interval {
+		return
+	}
+
+	f.partial = false
+	f.w.Write([]byte("\n"))
+}
+
+// Close stops the flushing, after which nothing more is written
+func (f *partialLineFlusher) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.closed {
+		f.closed = true
+		close(f.stop)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package process
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestPartialLineFlusherEndsIdleLines(t *testing.T) {
+	var buf lockedBuffer
+
+	f := newPartialLineFlusher(&buf, 20*time.Millisecond)
+	defer f.Close()
+
+	f.Write([]byte("finished line\n"))
+	f.Write([]byte("unfinished"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Only the unfinished line is ended, and only once
+	if output := buf.String(); output != "finished line\nunfinished\n" {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
+
+func TestPartialLineFlusherStopsWhenClosed(t *testing.T) {
+	var buf lockedBuffer
+
+	f := newPartialLineFlusher(&buf, 20*time.Millisecond)
+	f.Write([]byte("unfinished"))
+	f.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if output := buf.String(); output != "unfinished" {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
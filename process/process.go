@@ -3,9 +3,11 @@ package process
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"regexp"
@@ -19,12 +21,227 @@ import (
 	"github.com/buildkite/agent/logger"
 )
 
+// ProcessResult is how a process finished
+type ProcessResult struct {
+	// The exit status, or -1 if it couldn't be worked out
+	ExitStatus int
+
+	// The name of the signal that ended the process, if it was one
+	Signal string
+
+	// Whether the process was killed for running longer than its Timeout,
+	// in which case the exit status is TimedOutExitStatus
+	TimedOut bool
+
+	// What the process used while it ran
+	Usage ResourceUsage
+}
+
+// ResourceUsage is the CPU and memory a process used
+type ResourceUsage struct {
+	// The CPU time spent running the process's own code, and in the kernel
+	// on its behalf
+	UserTime   time.Duration
+	SystemTime time.Duration
+
+	// The most memory the process had resident at once, in bytes, or 0 if
+	// it isn't known
+	MaxRSS int64
+}
+
+// ProcessStats is how much output a process has written
+type ProcessStats struct {
+	// The output so far, in bytes and in lines
+	Bytes int64
+	Lines int64
+
+	// How long the process has been running, or ran for if it's finished
+	Duration time.Duration
+
+	// The average rates of output over the duration
+	BytesPerSecond float64
+	LinesPerSecond float64
+}
+
+// StartError is returned by Start when the process couldn't be started at
+// all, like when its program doesn't exist or can't be run, rather than it
+// running and failing
+type StartError struct {
+	Err error
+}
+
+func (e *StartError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// NotFound returns whether the program to run doesn't exist
+func (e *StartError) NotFound() bool {
+	err := e.execErr()
+	return err == exec.ErrNotFound || os.IsNotExist(err)
+}
+
+// PermissionDenied returns whether the program couldn't be run because of
+// its permissions
+func (e *StartError) PermissionDenied() bool {
+	return os.IsPermission(e.execErr())
+}
+
+// execErr returns the error from looking up the program, which exec wraps
+// in an *exec.Error. os.IsNotExist and os.IsPermission unwrap the rest.
+func (e *StartError) execErr() error {
+	if execErr, ok := e.Err.(*exec.Error); ok {
+		return execErr.Err
+	}
+	return e.Err
+}
+
+// IsStartError returns whether an error is from a process not starting
+func IsStartError(err error) bool {
+	_, ok := err.(*StartError)
+	return ok
+}
+
+// TimedOutExitStatus is the exit status of a process that timed out, which is
+// the same as the timeout command uses
+const TimedOutExitStatus = 124
+
+// KillStep is a signal that Kill() sends, and how long it then waits for the
+// process to exit before going on to the next one
+type KillStep struct {
+	Signal os.Signal
+	Wait   time.Duration
+}
+
+// DefaultKillSequence is how processes are killed unless they have a
+// KillSequence of their own
+var DefaultKillSequence = []KillStep{
+	{Signal: syscall.SIGTERM, Wait: 10 * time.Second},
+}
+
 type Process struct {
-	Pid        int
-	PTY        bool
-	Timestamp  bool
-	Script     []string
-	Env        []string
+	Pid       int
+	PTY       bool
+	Timestamp bool
+	Script    []string
+	Env       []string
+
+	// Which of the agent's own environment variables the process gets as
+	// well as Env, so that things like credentials don't have to be passed
+	// on. With an allowlist, only the variables that match it are, and none
+	// that match the denylist ever are. Both can have * wildcards, like
+	// "AWS_*". Env is always passed on as is.
+	EnvAllowlist []string
+	EnvDenylist  []string
+
+	// The user to run the process as, so that an agent running as root can
+	// run it as someone less privileged. It's a name or ID, with an optional
+	// group after a colon, e.g. "buildkite" or "1000:1000". Their HOME, USER
+	// and LOGNAME are set too. Not supported on Windows.
+	User string
+
+	// The directory the process runs in, instead of the agent's
+	Dir string
+
+	// The umask the process starts with on Unix, e.g. 0022, instead of the
+	// agent's. It's set by /bin/sh before it execs the script, so the
+	// agent's own umask is never changed. 0 leaves it as the agent's.
+	Umask os.FileMode
+
+	// The size of the PTY, if there is one. If either is 0, the PTY's
+	// default size is used. It can be changed once it's running with
+	// Resize().
+	PTYColumns uint16
+	PTYRows    uint16
+
+	// What the process reads from STDIN, if anything. With a PTY, what's
+	// read is typed into the terminal, so it's echoed into the output like
+	// it would be for a person, and the end of it is sent as a Ctrl-D.
+	Stdin io.Reader
+
+	// Open files that the process inherits as file descriptor 3 onwards, the
+	// same as exec.Cmd's ExtraFiles. They aren't supported on Windows.
+	ExtraFiles []*os.File
+
+	// The most output that's kept in memory for Output(), so that a chatty
+	// process can't use it all up. Anything after that is dropped, with a
+	// marker to say so. 0 means there's no limit.
+	MaxOutputBytes int
+
+	// Whether to keep all of the output in a temp file once it's gone over
+	// MaxOutputBytes, see SpillFile()
+	SpillOutput bool
+
+	// How long a line can be left unfinished before what there is of it is
+	// given to the line callbacks as a line, so that things like progress
+	// bars that don't print newlines are seen as they happen. The rest of
+	// it then becomes another line. With timestamps, the buffer gets these
+	// lines too. 0 waits for lines to end.
+	PartialLineFlushInterval time.Duration
+
+	// How the time is shown at the start of timestamped lines, either
+	// "rfc3339" (the default), "rfc3339nano", "epoch-millis", "relative" to
+	// when the process started, or a Go time layout. The time is in
+	// TimestampLocation, which defaults to UTC.
+	TimestampFormat   string
+	TimestampLocation *time.Location
+
+	// Whether to remove ANSI escape sequences, like colors and cursor
+	// movement, from Output(). The line callback still gets them.
+	StripANSI bool
+
+	// Whether to replace bytes that aren't valid UTF-8 with the replacement
+	// character (U+FFFD) in Output(), so binary output doesn't break
+	// things that expect text
+	SanitizeUTF8 bool
+
+	// Where output is also written as it happens, for streaming it instead
+	// of polling Output(). It gets what Output() does, but without the
+	// MaxOutputBytes limit. Reading the process's output waits for each
+	// write, so they need to be quick, and if one fails nothing more is
+	// written to it.
+	OutputWriter io.Writer
+
+	// Limits on the CPU and memory the process and everything it starts
+	// can use, so that it can't starve others on the same host. They're
+	// enforced with a cgroup (v2 only), so they only work on Linux.
+	//
+	// CPUWeight is the share of CPU time it gets when there's contention,
+	// from 1 to 10000, where other processes usually have 100. MemoryMax is
+	// in bytes, and the process is OOM killed if it goes over it. 0 leaves
+	// either unlimited.
+	CPUWeight int
+	MemoryMax int64
+
+	// The cgroup to create the process's cgroup under, relative to where
+	// the cgroup v2 hierarchy is mounted. It's needed for the limits, and
+	// it has to have no processes of its own, so it can't be the cgroup of
+	// the agent. The process joins its cgroup itself before it runs the
+	// script, so a process run as another user needs to be allowed to.
+	CgroupParent string
+
+	// The signals that Kill() sends, in order, waiting after each for the
+	// process to exit. If it still hasn't after the last one, it's sent a
+	// SIGKILL. Defaults to DefaultKillSequence. Processes on Windows are
+	// always killed with TASKKILL instead.
+	KillSequence []KillStep
+
+	// How long the process can run for before it's killed, the same way
+	// Kill() does it. 0 means it can run forever.
+	Timeout time.Duration
+
+	// How long the process can go without writing any output before it's
+	// thought to be hung, at which point IdleCallback is called with how
+	// long it's been quiet. Without a callback the process is killed. It
+	// happens again if the process writes more and then goes quiet again.
+	// 0 never does either.
+	IdleTimeout  time.Duration
+	IdleCallback func(idle time.Duration)
+
+	// Deprecated: use Wait() instead, which doesn't need to be polled
 	ExitStatus string
 
 	buffer  outputBuffer
@@ -34,17 +251,41 @@ type Process struct {
 	StartCallback func()
 
 	// For every line in the process output, this callback will be called
-	// with the contents of the line if its filter returns true.
+	// with the contents of the line if its filter returns true. The line
+	// is run through each of the processors, in order, before either sees
+	// it.
 	LineCallback       func(string)
-	LinePreProcessor   func(string) string
+	LineProcessors     []LineProcessor
 	LineCallbackFilter func(string) bool
 
+	// Called for every line of output with where and when it came from,
+	// and its position in the output. Unlike the line callback, it's
+	// called for each line in order, so it needs to be quick.
+	LineEventCallback func(LineEvent)
+
 	// Running is stored as an int32 so we can use atomic operations to
 	// set/get it (it's accessed by multiple goroutines)
 	running int32
 
-	mu   sync.Mutex
-	done chan struct{}
+	mu       sync.Mutex
+	done     chan struct{}
+	finished chan struct{}
+	result   ProcessResult
+	err      error
+
+	// Counted for Stats(), atomically
+	outputBytes int64
+	outputLines int64
+
+	startedAt  time.Time
+	finishedAt time.Time
+
+	// Whether it's been started, and so has to finish before it can be
+	// started again
+	started bool
+
+	// Set while the process is running in a PTY
+	resizePTY func(columns, rows uint16) error
 }
 
 // If you change header parsing here make sure to change it in the
@@ -52,51 +293,173 @@ type Process struct {
 
 var headerExpansionRegex = regexp.MustCompile("^(?:\\^\\^\\^\\s+\\+\\+\\+)\\s*$")
 
-// Start executes the command and blocks until it finishes
+// Start executes the command and blocks until it finishes. Once it has, it
+// can be started again, with the output and result of the last run cleared.
 func (p *Process) Start() error {
-	if p.IsRunning() {
-		return fmt.Errorf("Process is already running")
+	return p.StartWithContext(context.Background())
+}
+
+// StartWithContext executes the command and blocks until it finishes. If the
+// context is cancelled first, the process is killed the same way as Kill()
+// does it, and the line callback isn't called for anything it writes after
+// that.
+func (p *Process) StartWithContext(ctx context.Context) error {
+	// Clear out anything left from the last time it ran, and create the
+	// channels that we use for signaling when the process is done for
+	// Done() and Wait()
+	if err := p.reset(); err != nil {
+		return err
 	}
 
-	p.command = exec.Command(p.Script[0], p.Script[1:]...)
+	if err := ctx.Err(); err != nil {
+		return p.startFailed(err)
+	}
 
-	// Create a channel that we use for signaling when the process is
-	// done for Done()
-	p.mu.Lock()
-	if p.done == nil {
-		p.done = make(chan struct{})
+	script := withUmask(p.Umask, p.Script)
+	extraFiles := p.ExtraFiles
+
+	// Put the process in a cgroup of its own if it's limited, which it joins
+	// before it runs the script
+	var cg *cgroup
+	if p.CPUWeight > 0 || p.MemoryMax > 0 {
+		var err error
+		if cg, err = newCgroup(p.CgroupParent, p.CPUWeight, p.MemoryMax); err != nil {
+			return p.startFailed(&StartError{Err: err})
+		}
+		defer cg.remove()
+
+		if script, extraFiles, err = cg.wrap(script, extraFiles); err != nil {
+			return p.startFailed(&StartError{Err: err})
+		}
 	}
-	p.mu.Unlock()
+
+	p.command = exec.Command(script[0], script[1:]...)
+	p.command.Dir = p.Dir
+	p.command.ExtraFiles = extraFiles
+
+	p.buffer.Lock()
+	p.buffer.max = p.MaxOutputBytes
+	p.buffer.spill = p.SpillOutput
+	p.buffer.Unlock()
+	defer p.buffer.closeSpillFile()
 
 	// Copy the current processes ENV and merge in the new ones. We do this
 	// so the sub process gets PATH and stuff. We merge our path in over
 	// the top of the current one so the ENV from Buildkite and the agent
-	// take precedence over the agent
-	currentEnv := os.Environ()
-	p.command.Env = append(currentEnv, p.Env...)
+	// take precedence over the agent. Only the variables that are allowed
+	// are copied.
+	currentEnv := filterEnv(os.Environ(), p.EnvAllowlist, p.EnvDenylist)
+	p.command.Env = append([]string{}, currentEnv...)
+
+	if p.User != "" {
+		if err := runAs(p.command, p.User); err != nil {
+			return p.startFailed(&StartError{Err: err})
+		}
+	}
+
+	p.command.Env = append(p.command.Env, p.Env...)
 
 	var waitGroup sync.WaitGroup
 
+	// Only the PTY copying routine sets this, and it's only read once
+	// that routine has finished
+	var copyErr error
+
 	lineReaderPipe, lineWriterPipe := io.Pipe()
 
-	var multiWriter io.Writer
-	if p.Timestamp {
-		multiWriter = io.MultiWriter(lineWriterPipe)
-	} else {
-		multiWriter = io.MultiWriter(&p.buffer, lineWriterPipe)
+	var bufferWriter io.Writer = &p.buffer
+	if p.OutputWriter != nil {
+		bufferWriter = io.MultiWriter(bufferWriter, &outputStream{w: p.OutputWriter})
+	}
+	if p.StripANSI {
+		bufferWriter = newANSIStripper(bufferWriter)
+	}
+	var sanitizer *utf8Sanitizer
+	if p.SanitizeUTF8 {
+		sanitizer = newUTF8Sanitizer(bufferWriter)
+		bufferWriter = sanitizer
+	}
+
+	// Everything the process writes is counted for Stats()
+	atomic.StoreInt64(&p.outputBytes, 0)
+	atomic.StoreInt64(&p.outputLines, 0)
+	counter := outputCounter{n: &p.outputBytes}
+
+	var lineWriter io.Writer = lineWriterPipe
+	var lineFlusher *partialLineFlusher
+	if p.PartialLineFlushInterval > 0 {
+		lineFlusher = newPartialLineFlusher(lineWriterPipe, p.PartialLineFlushInterval)
+		defer lineFlusher.Close()
+		lineWriter = lineFlusher
 	}
 
+	writers := []io.Writer{lineWriter, counter}
+	if !p.Timestamp {
+		writers = append([]io.Writer{bufferWriter}, writers...)
+	}
+
+	if p.IdleTimeout > 0 {
+		idleWatcher := newIdleWatcher(p.IdleTimeout, p.onIdle)
+		defer idleWatcher.Close()
+		writers = append(writers, idleWatcher)
+	}
+
+	multiWriter := io.MultiWriter(writers...)
+
+	// Structured line events are emitted one at a time, in order, unless
+	// the context has been cancelled
+	var lineEventLock sync.Mutex
+	var lineEventSeq int64
+	emitLineEvent := func(stream, text string) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		lineEventLock.Lock()
+		defer lineEventLock.Unlock()
+
+		lineEventSeq++
+		p.LineEventCallback(LineEvent{Seq: lineEventSeq, Time: time.Now(), Stream: stream, Text: text})
+	}
+
+	// Only set when copying stdout and stderr without a PTY
+	var stdoutLineEvents, stderrLineEvents *lineEventWriter
+
 	// Toggle between running in a pty
 	if p.PTY {
-		pty, err := StartPTY(p.command)
+		pty, err := StartPTYWithSize(p.command, p.PTYColumns, p.PTYRows)
 		if err != nil {
-			p.ExitStatus = "1"
-			return err
+			return p.startFailed(&StartError{Err: err})
 		}
 
+		p.mu.Lock()
+		p.resizePTY = func(columns, rows uint16) error {
+			return ResizePTY(pty, columns, rows)
+		}
+		p.mu.Unlock()
+
 		p.Pid = p.command.Process.Pid
 		p.setRunning(true)
 
+		if p.Stdin != nil {
+			go func() {
+				if _, err := io.Copy(pty, p.Stdin); err != nil {
+					logger.Error("[Process] Copying STDIN to the PTY failed with error: %T: %v", err, err)
+					return
+				}
+
+				// Like a Ctrl-D, which is the end of input in a terminal
+				pty.Write(ptyEndOfInput)
+			}()
+		}
+
+		ptyWriter := multiWriter
+		var ptyLineEvents *lineEventWriter
+		if p.LineEventCallback != nil {
+			ptyLineEvents = &lineEventWriter{stream: StreamPTY, emit: emitLineEvent}
+			ptyWriter = io.MultiWriter(multiWriter, ptyLineEvents)
+		}
+
 		waitGroup.Add(1)
 
 		go func() {
@@ -104,7 +467,10 @@ func (p *Process) Start() error {
 
 			// Copy the pty to our buffer. This will block until it
 			// EOF's or something breaks.
-			_, err = io.Copy(multiWriter, pty)
+			_, err = io.Copy(ptyWriter, pty)
+			if ptyLineEvents != nil {
+				ptyLineEvents.flush()
+			}
 			if e, ok := err.(*os.PathError); ok && e.Err == syscall.EIO {
 				// We can safely ignore this error, because
 				// it's just the PTY telling us that it closed
@@ -115,6 +481,7 @@ func (p *Process) Start() error {
 
 			if err != nil {
 				logger.Error("[Process] PTY output copy failed with error: %T: %v", err, err)
+				copyErr = err
 			} else {
 				logger.Debug("[Process] PTY has finished being copied to the buffer")
 			}
@@ -122,22 +489,79 @@ func (p *Process) Start() error {
 			waitGroup.Done()
 		}()
 	} else {
-		p.command.Stdout = multiWriter
-		p.command.Stderr = multiWriter
-		p.command.Stdin = nil
+		if p.LineEventCallback != nil {
+			// Telling the streams apart means copying them separately
+			sharedWriter := &lockedWriter{w: multiWriter}
+			stdoutLineEvents = &lineEventWriter{stream: StreamStdout, emit: emitLineEvent}
+			stderrLineEvents = &lineEventWriter{stream: StreamStderr, emit: emitLineEvent}
+			p.command.Stdout = io.MultiWriter(sharedWriter, stdoutLineEvents)
+			p.command.Stderr = io.MultiWriter(sharedWriter, stderrLineEvents)
+		} else {
+			p.command.Stdout = multiWriter
+			p.command.Stderr = multiWriter
+		}
+		p.command.Stdin = p.Stdin
 
 		err := p.command.Start()
 		if err != nil {
-			p.ExitStatus = "1"
-			return err
+			return p.startFailed(&StartError{Err: err})
 		}
 
 		p.Pid = p.command.Process.Pid
 		p.setRunning(true)
 	}
 
+	// A process that can't be limited exits before it runs the script, and
+	// is treated as one that couldn't be started
+	if cg != nil {
+		if err := cg.joined(); err != nil {
+			p.command.Wait()
+			waitGroup.Wait()
+			p.setRunning(false)
+			return p.startFailed(&StartError{Err: err})
+		}
+	}
+
 	logger.Info("[Process] Process is running with PID: %d", p.Pid)
 
+	p.mu.Lock()
+	p.startedAt = time.Now()
+	p.finishedAt = time.Time{}
+	p.mu.Unlock()
+
+	// Nothing times out without a timeout, because a nil channel is never
+	// ready
+	var timeout <-chan time.Time
+	if p.Timeout > 0 {
+		timer := time.NewTimer(p.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var timedOut int32
+
+	// Kill the process if the context is cancelled or it times out before
+	// it finishes
+	done := p.Done()
+	go func() {
+		select {
+		case <-ctx.Done():
+			logger.Debug("[Process] Context cancelled, killing process with PID: %d", p.Pid)
+		case <-timeout:
+			logger.Info("[Process] Process with PID: %d timed out after %s, killing it", p.Pid, p.Timeout)
+			atomic.StoreInt32(&timedOut, 1)
+		case <-done:
+			return
+		}
+
+		if err := p.Kill(); err != nil {
+			logger.Error("[Process] Failed to kill process with PID: %d (%T: %v)", p.Pid, err, err)
+		}
+	}()
+
+	// Relative timestamps count from when the process started
+	startedAt := p.startedAt
+
 	// Add the line callback routine to the waitGroup
 	waitGroup.Add(1)
 
@@ -197,9 +621,11 @@ func (p *Process) Start() error {
 			// the timestamped buffer without breaking headers,
 			// otherwise we let the goroutines take the perf hit.
 
+			atomic.AddInt64(&p.outputLines, 1)
+
 			checkedForCallback := false
 			lineHasCallback := false
-			lineString := p.LinePreProcessor(string(line))
+			lineString := ProcessLine(p.LineProcessors, string(line))
 
 			// Create the prefixed buffer
 			if p.Timestamp {
@@ -207,13 +633,19 @@ func (p *Process) Start() error {
 				checkedForCallback = true
 				if lineHasCallback || headerExpansionRegex.MatchString(lineString) {
 					// Don't timestamp special lines (e.g. header)
-					p.buffer.WriteString(fmt.Sprintf("%s\n", line))
+					fmt.Fprintf(bufferWriter, "%s\n", line)
 				} else {
-					currentTime := time.Now().UTC().Format(time.RFC3339)
-					p.buffer.WriteString(fmt.Sprintf("[%s] %s\n", currentTime, line))
+					currentTime := formatTimestamp(p.TimestampFormat, p.TimestampLocation, time.Now(), startedAt)
+					fmt.Fprintf(bufferWriter, "[%s] %s\n", currentTime, line)
 				}
 			}
 
+			// Nobody wants to hear about lines once the context has
+			// been cancelled, but they still end up in the buffer
+			if ctx.Err() != nil {
+				continue
+			}
+
 			if lineHasCallback || !checkedForCallback {
 				lineCallbackWaitGroup.Add(1)
 				go func(line string) {
@@ -241,19 +673,44 @@ func (p *Process) Start() error {
 	// has no problems copying stdin, stdout, and stderr, and exits with a zero exit status.
 	waitResult := p.command.Wait()
 
-	// Close the line writer pipe
+	// Without a PTY, the output has all been copied by now
+	if stdoutLineEvents != nil {
+		stdoutLineEvents.flush()
+		stderrLineEvents.flush()
+	}
+
+	// Close the line writer pipe, once nothing else can write to it
+	if lineFlusher != nil {
+		lineFlusher.Close()
+	}
 	lineWriterPipe.Close()
 
 	// The process is no longer running at this point
 	p.setRunning(false)
 
+	// Find the exit status of the script
+	result := getResult(waitResult)
+	result.Usage = resourceUsage(p.command.ProcessState)
+	if atomic.LoadInt32(&timedOut) == 1 {
+		result.TimedOut = true
+		result.ExitStatus = TimedOutExitStatus
+	}
+
+	p.mu.Lock()
+	p.result = result
+	p.finishedAt = time.Now()
+	p.ExitStatus = strconv.Itoa(result.ExitStatus)
+	p.mu.Unlock()
+
+	// There's nothing to resize any more
+	p.mu.Lock()
+	p.resizePTY = nil
+	p.mu.Unlock()
+
 	// Signal waiting consumers in Done() by closing the done channel
 	close(p.done)
 
-	// Find the exit status of the script
-	p.ExitStatus = getExitStatus(waitResult)
-
-	logger.Info("Process with PID: %d finished with Exit Status: %s", p.Pid, p.ExitStatus)
+	logger.Info("Process with PID: %d finished with Exit Status: %d", p.Pid, result.ExitStatus)
 
 	// Sometimes (in docker containers) io.Copy never seems to finish. This is a mega
 	// hack around it. If it doesn't finish after 1 second, just continue.
@@ -261,58 +718,271 @@ func (p *Process) Start() error {
 	err := timeoutWait(&waitGroup)
 	if err != nil {
 		logger.Debug("[Process] Timed out waiting for wait group: (%T: %v)", err, err)
+	} else if copyErr != nil {
+		p.mu.Lock()
+		p.err = copyErr
+		p.mu.Unlock()
+	}
+
+	// Anything held back waiting for the rest of a character isn't going
+	// to get it now
+	if sanitizer != nil {
+		sanitizer.flush()
 	}
 
+	close(p.finished)
+
 	// No error occurred so we can return nil
 	return nil
 }
 
+// onIdle is called when the process hasn't written anything for the
+// IdleTimeout
+func (p *Process) onIdle(idle time.Duration) {
+	if p.IdleCallback != nil {
+		p.IdleCallback(idle)
+		return
+	}
+
+	logger.Info("[Process] Process with PID: %d hasn't written any output for %s, killing it", p.Pid, idle.Truncate(time.Second))
+
+	if err := p.Kill(); err != nil {
+		logger.Error("[Process] Failed to kill process with PID: %d (%T: %v)", p.Pid, err, err)
+	}
+}
+
+// startFailed records that the process couldn't be started, and lets
+// anything waiting for it carry on. It returns the error.
+func (p *Process) startFailed(err error) error {
+	p.mu.Lock()
+	p.result = ProcessResult{ExitStatus: 1}
+	p.err = err
+	p.ExitStatus = "1"
+	p.mu.Unlock()
+
+	close(p.done)
+	close(p.finished)
+
+	return err
+}
+
+// reset gets the process ready to start, which it can do again once it's
+// finished. Anything still waiting on the last run's Done() carries on
+// waiting on that.
+func (p *Process) reset() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.started {
+		select {
+		case <-p.finished:
+		default:
+			return fmt.Errorf("Process is already running")
+		}
+
+		p.done = nil
+		p.finished = nil
+	}
+
+	p.started = true
+	p.result = ProcessResult{}
+	p.err = nil
+	p.ExitStatus = ""
+	p.resizePTY = nil
+
+	if p.done == nil {
+		p.done = make(chan struct{})
+	}
+	if p.finished == nil {
+		p.finished = make(chan struct{})
+	}
+
+	p.buffer.reset()
+
+	return nil
+}
+
+func (p *Process) initChannels() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// These are created here in case Done() or Wait() are called before
+	// Start()
+	if p.done == nil {
+		p.done = make(chan struct{})
+	}
+	if p.finished == nil {
+		p.finished = make(chan struct{})
+	}
+}
+
+// StdinPipe returns a pipe that's connected to the process's STDIN once it
+// starts, for writing to it as it runs. It has to be called before Start, and
+// the pipe should be closed once everything has been written.
+func (p *Process) StdinPipe() (io.WriteCloser, error) {
+	if p.Stdin != nil {
+		return nil, errors.New("Stdin is already set")
+	}
+	if p.IsRunning() {
+		return nil, errors.New("StdinPipe after process started")
+	}
+
+	r, w := io.Pipe()
+	p.Stdin = r
+
+	return w, nil
+}
+
 // Output returns the current state of the output buffer and can be called incrementally
 func (p *Process) Output() string {
 	return p.buffer.String()
 }
 
+// OutputTruncated returns whether the output has gone over MaxOutputBytes,
+// so Output() doesn't have all of it
+func (p *Process) OutputTruncated() bool {
+	p.buffer.RLock()
+	defer p.buffer.RUnlock()
+	return p.buffer.truncated
+}
+
+// SpillFile returns the path of the temp file with all of the output in it,
+// if SpillOutput is set and the output has gone over MaxOutputBytes. It's up
+// to the caller to remove it.
+func (p *Process) SpillFile() string {
+	p.buffer.RLock()
+	defer p.buffer.RUnlock()
+	if p.buffer.spillFile == nil {
+		return ""
+	}
+	return p.buffer.spillFile.Name()
+}
+
+// Stats returns how much output the process has written, and how quickly
+func (p *Process) Stats() ProcessStats {
+	p.mu.Lock()
+	startedAt, finishedAt := p.startedAt, p.finishedAt
+	p.mu.Unlock()
+
+	stats := ProcessStats{
+		Bytes: atomic.LoadInt64(&p.outputBytes),
+		Lines: atomic.LoadInt64(&p.outputLines),
+	}
+
+	if startedAt.IsZero() {
+		return stats
+	}
+
+	if finishedAt.IsZero() {
+		stats.Duration = time.Since(startedAt)
+	} else {
+		stats.Duration = finishedAt.Sub(startedAt)
+	}
+
+	if seconds := stats.Duration.Seconds(); seconds > 0 {
+		stats.BytesPerSecond = float64(stats.Bytes) / seconds
+		stats.LinesPerSecond = float64(stats.Lines) / seconds
+	}
+
+	return stats
+}
+
 // Done returns a channel that is closed when the process finishes
 func (p *Process) Done() <-chan struct{} {
+	p.initChannels()
+
 	p.mu.Lock()
-	// We create this here in case this is called before Start()
-	if p.done == nil {
-		p.done = make(chan struct{})
-	}
-	d := p.done
+	defer p.mu.Unlock()
+	return p.done
+}
+
+// Wait blocks until the process has finished and all of its output has been
+// read, and returns how it finished. The error is why it couldn't be started
+// or its output couldn't be copied, if either went wrong.
+func (p *Process) Wait() (ProcessResult, error) {
+	p.initChannels()
+
+	p.mu.Lock()
+	finished := p.finished
 	p.mu.Unlock()
-	return d
+
+	<-finished
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.result, p.err
 }
 
-// Kill terminates the process gracefully. Initially a SIGTERM is sent, and
-// then 10 seconds later a SIGTERM is sent.
+// Kill terminates the process gracefully, by sending it each of the signals
+// in its KillSequence until it exits, and then a SIGKILL if it still hasn't.
 func (p *Process) Kill() error {
-	var err error
 	if runtime.GOOS == "windows" {
 		// Sending Interrupt on Windows is not implemented.
 		// https://golang.org/src/os/exec.go?s=3842:3884#L110
-		err = exec.Command("CMD", "/C", "TASKKILL", "/F", "/T", "/PID", strconv.Itoa(p.Pid)).Run()
-	} else {
-		// Send a sigterm
-		err = p.signal(syscall.SIGTERM)
+		err := exec.Command("CMD", "/C", "TASKKILL", "/F", "/T", "/PID", strconv.Itoa(p.Pid)).Run()
+		if err != nil {
+			return err
+		}
+
+		if p.waitForExit(10 * time.Second) {
+			return nil
+		}
+
+		return p.signal(syscall.SIGKILL)
 	}
-	if err != nil {
-		return err
+
+	sequence := p.KillSequence
+	if len(sequence) == 0 {
+		sequence = DefaultKillSequence
 	}
 
+	for _, step := range sequence {
+		if err := p.signal(step.Signal); err != nil {
+			return err
+		}
+
+		// There's no point waiting to escalate from a SIGKILL
+		if step.Signal == syscall.SIGKILL {
+			return nil
+		}
+
+		if p.waitForExit(step.Wait) {
+			return nil
+		}
+	}
+
+	// Forcefully kill the process if nothing else worked
+	return p.signal(syscall.SIGKILL)
+}
+
+// waitForExit returns whether the process exits within the timeout
+func (p *Process) waitForExit(timeout time.Duration) bool {
 	select {
-	// Was successfully terminated
 	case <-p.Done():
 		logger.Debug("[Process] Process with PID: %d has exited.", p.Pid)
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
 
-	// Forcefully kill the process after 10 seconds
-	case <-time.After(10 * time.Second):
-		if err = p.signal(syscall.SIGKILL); err != nil {
-			return err
-		}
+// Resize changes the size of the process's PTY while it's running, so that
+// what it draws fits, e.g. when the terminal it's shown in is resized
+func (p *Process) Resize(columns, rows uint16) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resizePTY == nil {
+		return errors.New("Process isn't running in a PTY")
 	}
 
-	return nil
+	return p.resizePTY(columns, rows)
+}
+
+// Signal sends a signal to the process, which is passed on to whatever it's
+// running if it's the bootstrap
+func (p *Process) Signal(sig os.Signal) error {
+	return p.signal(sig)
 }
 
 func (p *Process) signal(sig os.Signal) error {
@@ -353,13 +1023,16 @@ func (p *Process) setRunning(r bool) {
 
 // https://github.com/hnakamur/commango/blob/fe42b1cf82bf536ce7e24dceaef6656002e03743/os/executil/executil.go#L29
 // TODO: Can this be better?
-func getExitStatus(waitResult error) string {
-	exitStatus := -1
+func getResult(waitResult error) ProcessResult {
+	result := ProcessResult{ExitStatus: -1}
 
 	if waitResult != nil {
 		if err, ok := waitResult.(*exec.ExitError); ok {
 			if s, ok := err.Sys().(syscall.WaitStatus); ok {
-				exitStatus = s.ExitStatus()
+				result.ExitStatus = s.ExitStatus()
+				if s.Signaled() {
+					result.Signal = s.Signal().String()
+				}
 			} else {
 				logger.Error("[Process] Unimplemented for system where exec.ExitError.Sys() is not syscall.WaitStatus.")
 			}
@@ -367,10 +1040,10 @@ func getExitStatus(waitResult error) string {
 			logger.Error("[Process] Unexpected error type in getExitStatus: %#v", waitResult)
 		}
 	} else {
-		exitStatus = 0
+		result.ExitStatus = 0
 	}
 
-	return fmt.Sprintf("%d", exitStatus)
+	return result
 }
 
 func timeoutWait(waitGroup *sync.WaitGroup) error {
@@ -395,6 +1068,14 @@ func timeoutWait(waitGroup *sync.WaitGroup) error {
 type outputBuffer struct {
 	sync.RWMutex
 	buf bytes.Buffer
+
+	// The most bytes to keep, and whether we've stopped keeping them
+	max       int
+	truncated bool
+
+	// Where everything goes once we've stopped keeping it, if anywhere
+	spill     bool
+	spillFile *os.File
 }
 
 // Write appends the contents of p to the buffer, growing the buffer as needed. It returns
@@ -402,7 +1083,66 @@ type outputBuffer struct {
 func (ob *outputBuffer) Write(p []byte) (n int, err error) {
 	ob.Lock()
 	defer ob.Unlock()
-	return ob.buf.Write(p)
+
+	if ob.truncated {
+		if ob.spillFile != nil {
+			if _, err := ob.spillFile.Write(p); err != nil {
+				logger.Error("[Process] Failed to write output to %s: %v", ob.spillFile.Name(), err)
+			}
+		}
+		return len(p), nil
+	}
+
+	if ob.max <= 0 || ob.buf.Len()+len(p) <= ob.max {
+		return ob.buf.Write(p)
+	}
+
+	if ob.spill {
+		ob.startSpilling(p)
+	}
+
+	ob.buf.Write(p[:ob.max-ob.buf.Len()])
+	fmt.Fprintf(&ob.buf, "\n\n[Output truncated after %d bytes]\n", ob.max)
+	ob.truncated = true
+
+	return len(p), nil
+}
+
+// startSpilling puts everything so far, and p, in a temp file
+func (ob *outputBuffer) startSpilling(p []byte) {
+	f, err := ioutil.TempFile("", "process-output")
+	if err != nil {
+		logger.Error("[Process] Failed to create a file for the rest of the output: %v", err)
+		return
+	}
+
+	if _, err := f.Write(ob.buf.Bytes()); err != nil {
+		logger.Error("[Process] Failed to write output to %s: %v", f.Name(), err)
+	}
+	if _, err := f.Write(p); err != nil {
+		logger.Error("[Process] Failed to write output to %s: %v", f.Name(), err)
+	}
+
+	ob.spillFile = f
+}
+
+// reset empties the buffer. A file the output was spilled to is left behind.
+func (ob *outputBuffer) reset() {
+	ob.Lock()
+	defer ob.Unlock()
+
+	ob.buf.Reset()
+	ob.truncated = false
+	ob.spillFile = nil
+}
+
+func (ob *outputBuffer) closeSpillFile() {
+	ob.RLock()
+	defer ob.RUnlock()
+
+	if ob.spillFile != nil {
+		ob.spillFile.Close()
+	}
 }
 
 // WriteString appends the contents of s to the buffer, growing the buffer as needed. It returns
@@ -418,3 +1158,30 @@ func (ob *outputBuffer) String() string {
 	defer ob.RUnlock()
 	return ob.buf.String()
 }
+
+// outputCounter counts the bytes written to it
+type outputCounter struct {
+	n *int64
+}
+
+func (c outputCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.n, int64(len(p)))
+	return len(p), nil
+}
+
+// outputStream writes to Process.OutputWriter, giving up on it if it fails
+// rather than stopping the process's output from being read
+type outputStream struct {
+	w   io.Writer
+	err error
+}
+
+func (s *outputStream) Write(p []byte) (int, error) {
+	if s.err == nil {
+		if _, s.err = s.w.Write(p); s.err != nil {
+			logger.Error("[Process] Failed to write to the output writer: %v", s.err)
+		}
+	}
+
+	return len(p), nil
+}
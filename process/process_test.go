@@ -1,11 +1,18 @@
 package process_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -34,7 +41,6 @@ func TestProcessRunsAndCallsStartCallback(t *testing.T) {
 			atomic.AddInt32(&started, 1)
 		},
 		LineCallback:       func(s string) {},
-		LinePreProcessor:   func(s string) string { return s },
 		LineCallbackFilter: func(s string) bool { return false },
 	}
 
@@ -70,9 +76,14 @@ func TestProcessCallsLineCallbacksForEachOutputLine(t *testing.T) {
 			defer linesLock.Unlock()
 			lines = append(lines, s)
 		},
-		LinePreProcessor: func(s string) string {
-			lineNumber := atomic.AddInt32(&lineCounter, 1)
-			return fmt.Sprintf("#%d: chars %d", lineNumber, len(s))
+		LineProcessors: []process.LineProcessor{
+			func(s string) string {
+				return fmt.Sprintf("chars %d", len(s))
+			},
+			func(s string) string {
+				lineNumber := atomic.AddInt32(&lineCounter, 1)
+				return fmt.Sprintf("#%d: %s", lineNumber, s)
+			},
 		},
 		LineCallbackFilter: func(s string) bool {
 			return true
@@ -104,7 +115,6 @@ func TestProcessPrependsLinesWithTimestamps(t *testing.T) {
 		Env:                []string{"TEST_MAIN=tester"},
 		StartCallback:      func() {},
 		LineCallback:       func(s string) {},
-		LinePreProcessor:   func(s string) string { return s },
 		LineCallbackFilter: func(s string) bool { return strings.HasPrefix(s, "+++") },
 		Timestamp:          true,
 	}
@@ -135,7 +145,6 @@ func TestProcessOutputIsSafeFromRaces(t *testing.T) {
 		Script:             []string{os.Args[0]},
 		Env:                []string{"TEST_MAIN=tester"},
 		LineCallback:       func(s string) {},
-		LinePreProcessor:   func(s string) string { return s },
 		LineCallbackFilter: func(s string) bool { return false },
 	}
 
@@ -171,7 +180,6 @@ func TestKillingProcess(t *testing.T) {
 		LineCallback: func(s string) {
 			t.Logf("Line: %s", s)
 		},
-		LinePreProcessor:   func(s string) string { return s },
 		LineCallbackFilter: func(s string) bool { return false },
 	}
 
@@ -202,6 +210,836 @@ func TestKillingProcess(t *testing.T) {
 	}
 }
 
+func TestKillingProcessWithKillSequence(t *testing.T) {
+	p := process.Process{
+		Script: []string{os.Args[0]},
+		Env:    []string{"TEST_MAIN=tester-signal-sequence"},
+		KillSequence: []process.KillStep{
+			{Signal: syscall.SIGINT, Wait: 100 * time.Millisecond},
+			{Signal: syscall.SIGTERM, Wait: 5 * time.Second},
+		},
+		StartCallback:      func() {},
+		LineCallbackFilter: func(s string) bool { return s == "ready" },
+	}
+
+	p.LineCallback = func(s string) {
+		go func() {
+			if err := p.Kill(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if output := p.Output(); output != "ready\nSIG interrupt\nSIG terminated\n" {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
+
+func TestProcessTimesOut(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-signal-ready"},
+		Timeout:            200 * time.Millisecond,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := p.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.TimedOut || result.ExitStatus != process.TimedOutExitStatus {
+		t.Fatalf("Expected the process to time out, got %+v", result)
+	}
+}
+
+func TestProcessIsKilledWhenIdle(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-signal-ready"},
+		IdleTimeout:        200 * time.Millisecond,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if output := p.Output(); output != "ready\nSIG terminated" {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
+
+func TestProcessIdleCallback(t *testing.T) {
+	var idleFor time.Duration
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-signal-ready"},
+		IdleTimeout:        200 * time.Millisecond,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	p.IdleCallback = func(idle time.Duration) {
+		idleFor = idle
+		p.Kill()
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if idleFor < 200*time.Millisecond {
+		t.Fatalf("Expected the callback to be called after 200ms, got %s", idleFor)
+	}
+}
+
+func TestProcessFinishingBeforeItsTimeout(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-exit"},
+		Timeout:            time.Minute,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, _ := p.Wait(); result.TimedOut || result.ExitStatus != 3 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+}
+
+func TestCancellingTheContextKillsTheProcess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancel once the process is ready for signals
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-signal-ready"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) { cancel() },
+		LineCallbackFilter: func(s string) bool { return s == "ready" },
+	}
+
+	if err := p.StartWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-p.Done():
+	default:
+		t.Fatal("Expected Done to be closed")
+	}
+
+	output := p.Output()
+	if output != "ready\nSIG terminated" {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
+
+func TestProcessResourceUsage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't give the memory a process used")
+	}
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := p.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A Go program uses at least a megabyte just to start
+	if result.Usage.MaxRSS < 1024*1024 {
+		t.Fatalf("Expected the max RSS to be at least 1MB, got %d", result.Usage.MaxRSS)
+	}
+
+	if result.Usage.UserTime+result.Usage.SystemTime <= 0 {
+		t.Fatalf("Expected the process to have used some CPU time, got %+v", result.Usage)
+	}
+}
+
+func TestStartingWithACancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.StartWithContext(ctx); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if _, err := p.Wait(); err != context.Canceled {
+		t.Fatalf("Expected Wait to return context.Canceled, got %v", err)
+	}
+}
+
+func TestStartingAProgramThatDoesntExist(t *testing.T) {
+	p := process.Process{
+		Script:             []string{filepath.Join(os.TempDir(), "llamas-do-not-exist")},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	err := p.Start()
+	startErr, ok := err.(*process.StartError)
+	if !ok {
+		t.Fatalf("Expected a *process.StartError, got %#v", err)
+	}
+
+	if !startErr.NotFound() || startErr.PermissionDenied() {
+		t.Fatalf("Expected the error to be that the program wasn't found, got %v", startErr)
+	}
+
+	if !process.IsStartError(err) {
+		t.Fatalf("Expected IsStartError to be true")
+	}
+}
+
+func TestStartingAProgramThatCantBeRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Files don't have an executable bit on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "process-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "not-executable")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho llamas\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := process.Process{
+		Script:             []string{script},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	startErr, ok := p.Start().(*process.StartError)
+	if !ok {
+		t.Fatalf("Expected a *process.StartError")
+	}
+
+	if !startErr.PermissionDenied() || startErr.NotFound() {
+		t.Fatalf("Expected the error to be that permission was denied, got %v", startErr)
+	}
+}
+
+func TestProcessReadsStdin(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-stdin"},
+		Stdin:              strings.NewReader("llamas\nalpacas\n"),
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if output := p.Output(); output != "LLAMAS\nALPACAS\n" {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
+
+func TestProcessReadsStdinWithPTY(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("PTY not supported on windows")
+	}
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-stdin"},
+		PTY:                true,
+		Stdin:              strings.NewReader("llamas\n"),
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The terminal echoes what was typed
+	if output := p.Output(); !strings.Contains(output, "LLAMAS") {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
+
+func TestProcessStdinPipe(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-stdin"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	stdin, err := p.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		fmt.Fprintln(stdin, "llamas")
+		stdin.Close()
+	}()
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if output := p.Output(); output != "LLAMAS\n" {
+		t.Fatalf("Bad output: %q", output)
+	}
+
+	if _, err := p.StdinPipe(); err == nil {
+		t.Fatal("Expected an error getting a second pipe")
+	}
+}
+
+func TestProcessTruncatesOutput(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester"},
+		MaxOutputBytes:     50,
+		SpillOutput:        true,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.OutputTruncated() {
+		t.Fatal("Expected the output to be truncated")
+	}
+
+	expected := longTestOutput[:50] + "\n\n[Output truncated after 50 bytes]\n"
+	if output := p.Output(); output != expected {
+		t.Fatalf("Output was unexpected:\nWanted: %q\nGot:    %q\n", expected, output)
+	}
+
+	if p.SpillFile() == "" {
+		t.Fatal("Expected the output to spill to a file")
+	}
+	defer os.Remove(p.SpillFile())
+
+	spilled, err := ioutil.ReadFile(p.SpillFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(spilled) != longTestOutput {
+		t.Fatalf("Spilled output was unexpected:\nWanted: %q\nGot:    %q\n", longTestOutput, spilled)
+	}
+}
+
+func TestProcessOutputUnderTheLimitIsntTruncated(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester"},
+		MaxOutputBytes:     len(longTestOutput),
+		SpillOutput:        true,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.OutputTruncated() || p.SpillFile() != "" {
+		t.Fatal("Expected the output not to be truncated")
+	}
+
+	if output := p.Output(); output != longTestOutput {
+		t.Fatalf("Output was unexpected:\nWanted: %q\nGot:    %q\n", longTestOutput, output)
+	}
+}
+
+func TestProcessStripsANSIFromOutput(t *testing.T) {
+	var lines []string
+	var linesLock sync.Mutex
+
+	p := process.Process{
+		Script:        []string{os.Args[0]},
+		Env:           []string{"TEST_MAIN=tester-ansi"},
+		StripANSI:     true,
+		StartCallback: func() {},
+		LineCallback: func(s string) {
+			linesLock.Lock()
+			lines = append(lines, s)
+			linesLock.Unlock()
+		},
+		LineCallbackFilter: func(s string) bool { return true },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if output := p.Output(); output != "red\n" {
+		t.Fatalf("Bad output: %q", output)
+	}
+
+	linesLock.Lock()
+	defer linesLock.Unlock()
+	if !reflect.DeepEqual(lines, []string{"\x1b[31mred\x1b[0m"}) {
+		t.Fatalf("Bad lines: %q", lines)
+	}
+}
+
+func TestProcessStreamsOutputToTheWriter(t *testing.T) {
+	var streamed bytes.Buffer
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester"},
+		MaxOutputBytes:     50,
+		OutputWriter:       &streamed,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The writer isn't limited like the buffer is
+	if streamed.String() != longTestOutput {
+		t.Fatalf("Bad streamed output: %q", streamed.String())
+	}
+	if !p.OutputTruncated() {
+		t.Fatalf("Expected the buffered output to be truncated")
+	}
+}
+
+func TestProcessEmitsLineEventsForEachStream(t *testing.T) {
+	var events []process.LineEvent
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-streams"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+		LineEventCallback: func(e process.LineEvent) {
+			events = append(events, e)
+		},
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lines from different streams can be read in any order
+	streams := map[string][]string{}
+	for i, e := range events {
+		if e.Seq != int64(i+1) {
+			t.Errorf("Expected event %d to have Seq %d, got %d", i, i+1, e.Seq)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("Expected event %d to have a time", i)
+		}
+		streams[e.Stream] = append(streams[e.Stream], e.Text)
+	}
+
+	expected := map[string][]string{
+		process.StreamStdout: {"llamas", "alpacas", "no newline"},
+		process.StreamStderr: {"camels"},
+	}
+	if !reflect.DeepEqual(streams, expected) {
+		t.Fatalf("Expected %v, got %v", expected, streams)
+	}
+}
+
+func TestProcessEmitsLineEventsWithPTY(t *testing.T) {
+	var texts []string
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-streams"},
+		PTY:                true,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+		LineEventCallback: func(e process.LineEvent) {
+			if e.Stream != process.StreamPTY {
+				t.Errorf("Expected the PTY stream, got %q", e.Stream)
+			}
+			texts = append(texts, e.Text)
+		},
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"llamas", "camels", "alpacas", "no newline"}; !reflect.DeepEqual(texts, expected) {
+		t.Fatalf("Expected %v, got %v", expected, texts)
+	}
+}
+
+func TestResizingTheProcessPTY(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-signal-ready"},
+		PTY:                true,
+		PTYColumns:         80,
+		PTYRows:            24,
+		StartCallback:      func() {},
+		LineCallbackFilter: func(s string) bool { return strings.TrimSpace(s) == "ready" },
+	}
+
+	p.LineCallback = func(s string) {
+		if err := p.Resize(120, 40); err != nil {
+			t.Error(err)
+		}
+		p.Kill()
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Resize(120, 40); err == nil {
+		t.Fatalf("Expected an error resizing a process that has finished")
+	}
+}
+
+func TestResizingWithoutAPTY(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-exit"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Resize(120, 40); err == nil {
+		t.Fatalf("Expected an error resizing a process without a PTY")
+	}
+}
+
+func TestProcessDoesntGetDeniedEnv(t *testing.T) {
+	os.Setenv("PROCESS_TEST_SECRET", "llamas")
+	defer os.Unsetenv("PROCESS_TEST_SECRET")
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-env"},
+		EnvDenylist:        []string{"PROCESS_TEST_*"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if output := p.Output(); output != "PROCESS_TEST_SECRET=\n" {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
+
+func TestProcessStats(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if stats := p.Stats(); stats.Bytes != 0 || stats.Duration != 0 {
+		t.Fatalf("Expected no stats before starting, got %+v", stats)
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := p.Stats()
+	if stats.Bytes != int64(len(longTestOutput)) {
+		t.Errorf("Expected %d bytes, got %d", len(longTestOutput), stats.Bytes)
+	}
+	if lines := int64(strings.Count(longTestOutput, "\n")); stats.Lines != lines {
+		t.Errorf("Expected %d lines, got %d", lines, stats.Lines)
+	}
+	if stats.Duration <= 0 || stats.BytesPerSecond <= 0 || stats.LinesPerSecond <= 0 {
+		t.Errorf("Expected a duration and rates, got %+v", stats)
+	}
+
+	// It's stopped counting now the process has finished
+	if again := p.Stats(); again.Duration != stats.Duration {
+		t.Errorf("Expected the duration to stay at %v, got %v", stats.Duration, again.Duration)
+	}
+}
+
+func TestProcessSanitizesUTF8(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-binary"},
+		SanitizeUTF8:       true,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if output := p.Output(); output != "\ufffd\ufffd llamas\n" {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
+
+func TestProcessRunsInDirWithUmask(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't have a umask")
+	}
+
+	dir, err := ioutil.TempDir("", "process-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-create-file"},
+		Dir:                dir,
+		Umask:              0027,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "llamas.txt"))
+	if err != nil {
+		t.Fatalf("Expected the file to be created in the process's directory: %v", err)
+	}
+
+	if mode := info.Mode().Perm(); mode != 0640 {
+		t.Fatalf("Expected the file to have mode 0640, got %o", mode)
+	}
+}
+
+func TestProcessWithUmaskKeepsItsPID(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't have a umask")
+	}
+
+	p := process.Process{
+		Script:             []string{"/bin/sh", "-c", "echo $$; umask"},
+		Umask:              0027,
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := fmt.Sprintf("%d\n0027\n", p.Pid); p.Output() != expected {
+		t.Fatalf("Expected %q, got %q", expected, p.Output())
+	}
+}
+
+func TestProcessFlushesPartialLines(t *testing.T) {
+	var lines []string
+	var linesLock sync.Mutex
+
+	p := process.Process{
+		Script:                   []string{os.Args[0]},
+		Env:                      []string{"TEST_MAIN=tester-progress"},
+		PartialLineFlushInterval: 50 * time.Millisecond,
+		StartCallback:            func() {},
+		LineCallback: func(s string) {
+			linesLock.Lock()
+			lines = append(lines, s)
+			linesLock.Unlock()
+		},
+		LineCallbackFilter: func(s string) bool { return true },
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	linesLock.Lock()
+	defer linesLock.Unlock()
+
+	// The callbacks can be called in any order
+	sort.Strings(lines)
+	if expected := []string{" done", "progress 50%"}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Expected lines %q, got %q", expected, lines)
+	}
+
+	// Without timestamps, the buffer is left as it was
+	if output := p.Output(); output != "progress 50% done\n" {
+		t.Fatalf("Bad output: %q", output)
+	}
+}
+
+func TestProcessCanBeStartedAgain(t *testing.T) {
+	var lines int32
+
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-ansi"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) { atomic.AddInt32(&lines, 1) },
+		LineCallbackFilter: func(s string) bool { return true },
+	}
+
+	for i := 1; i <= 2; i++ {
+		if err := p.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		if output := p.Output(); output != "\x1b[31mred\x1b[0m\n" {
+			t.Fatalf("Bad output on run %d: %q", i, output)
+		}
+
+		if result, _ := p.Wait(); result.ExitStatus != 0 {
+			t.Fatalf("Bad result on run %d: %+v", i, result)
+		}
+	}
+
+	if n := atomic.LoadInt32(&lines); n != 2 {
+		t.Fatalf("Expected the line callback to be called twice, got %d", n)
+	}
+}
+
+func TestStartingAProcessThatsRunning(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-signal-ready"},
+		StartCallback:      func() {},
+		LineCallbackFilter: func(s string) bool { return s == "ready" },
+	}
+
+	p.LineCallback = func(s string) {
+		if err := p.Start(); err == nil {
+			t.Error("Expected an error starting a process that's already running")
+		}
+		p.Kill()
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitReturnsTheResult(t *testing.T) {
+	p := process.Process{
+		Script:             []string{os.Args[0]},
+		Env:                []string{"TEST_MAIN=tester-exit"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	go func() {
+		if err := p.Start(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	result, err := p.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.ExitStatus != 3 {
+		t.Fatalf("Expected ExitStatus of 3, got %d", result.ExitStatus)
+	}
+}
+
+func TestWaitReturnsStartErrors(t *testing.T) {
+	p := process.Process{
+		Script:             []string{"/does/not/exist"},
+		StartCallback:      func() {},
+		LineCallback:       func(s string) {},
+		LineCallbackFilter: func(s string) bool { return false },
+	}
+
+	if err := p.Start(); err == nil {
+		t.Fatal("Expected an error starting the process")
+	}
+
+	result, err := p.Wait()
+	if err == nil {
+		t.Fatal("Expected Wait to return the start error")
+	}
+
+	if result.ExitStatus != 1 {
+		t.Fatalf("Expected ExitStatus of 1, got %d", result.ExitStatus)
+	}
+
+	select {
+	case <-p.Done():
+	default:
+		t.Fatal("Expected Done to be closed")
+	}
+}
+
 // Invoked by `go test`, switch between helper and running tests based on env
 func TestMain(m *testing.M) {
 	switch os.Getenv("TEST_MAIN") {
@@ -212,6 +1050,9 @@ func TestMain(m *testing.M) {
 		}
 		os.Exit(0)
 
+	case "tester-exit":
+		os.Exit(3)
+
 	case "tester-signal":
 		signals := make(chan os.Signal, 1)
 		signal.Notify(signals, os.Interrupt,
@@ -223,6 +1064,69 @@ func TestMain(m *testing.M) {
 		fmt.Printf("SIG %v", sig)
 		os.Exit(0)
 
+	case "tester-signal-sequence":
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+		fmt.Println("ready")
+
+		// Carry on after an interrupt, like something cleaning up
+		for sig := range signals {
+			fmt.Printf("SIG %v\n", sig)
+			if sig == syscall.SIGTERM {
+				os.Exit(0)
+			}
+		}
+
+	case "tester-streams":
+		fmt.Fprintln(os.Stdout, "llamas")
+		fmt.Fprintln(os.Stderr, "camels")
+		fmt.Fprintln(os.Stdout, "alpacas")
+		fmt.Fprint(os.Stdout, "no newline")
+		os.Exit(0)
+
+	case "tester-env":
+		fmt.Printf("PROCESS_TEST_SECRET=%s\n", os.Getenv("PROCESS_TEST_SECRET"))
+		os.Exit(0)
+
+	case "tester-binary":
+		os.Stdout.Write([]byte("\xff\xfe llamas\n"))
+		os.Exit(0)
+
+	case "tester-create-file":
+		if err := ioutil.WriteFile("llamas.txt", []byte("llamas"), 0666); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "tester-progress":
+		fmt.Print("progress 50%")
+		time.Sleep(500 * time.Millisecond)
+		fmt.Println(" done")
+		os.Exit(0)
+
+	case "tester-ansi":
+		fmt.Println("\x1b[31mred\x1b[0m")
+		os.Exit(0)
+
+	case "tester-stdin":
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			fmt.Println(strings.ToUpper(scanner.Text()))
+		}
+		os.Exit(0)
+
+	case "tester-signal-ready":
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM)
+
+		fmt.Println("ready")
+
+		sig := <-signals
+		fmt.Printf("SIG %v", sig)
+		os.Exit(0)
+
 	default:
 		os.Exit(m.Run())
 	}
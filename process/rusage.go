@@ -0,0 +1,31 @@
+// +build !windows
+
+package process
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// resourceUsage returns what a process that has finished used
+func resourceUsage(state *os.ProcessState) ResourceUsage {
+	if state == nil {
+		return ResourceUsage{}
+	}
+
+	usage := ResourceUsage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// macOS counts it in bytes, everything else in kilobytes
+		usage.MaxRSS = int64(rusage.Maxrss)
+		if runtime.GOOS != "darwin" {
+			usage.MaxRSS *= 1024
+		}
+	}
+
+	return usage
+}
@@ -0,0 +1,16 @@
+package process
+
+import "os"
+
+// resourceUsage returns what a process that has finished used. Windows only
+// gives the CPU time once the process has gone.
+func resourceUsage(state *os.ProcessState) ResourceUsage {
+	if state == nil {
+		return ResourceUsage{}
+	}
+
+	return ResourceUsage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+}
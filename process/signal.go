@@ -0,0 +1,85 @@
+// +build !windows
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The signals that can be sent to a running job by name
+var signalsByName = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// ParseSignal finds a signal by name, e.g "SIGUSR1" or "usr1"
+func ParseSignal(name string) (os.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	if sig, ok := signalsByName[name]; ok {
+		return sig, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported signal %q", name)
+}
+
+// ParseKillSequence parses the signals for Process.KillSequence, given as a
+// comma separated list of signals with how long to wait after each, e.g.
+// "SIGINT:10s,SIGTERM:10s". It can end with a "SIGKILL", which is sent
+// anyway if nothing else worked.
+func ParseKillSequence(value string) ([]KillStep, error) {
+	var sequence []KillStep
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var step KillStep
+
+		parts := strings.SplitN(part, ":", 2)
+		if name := strings.ToUpper(strings.TrimSpace(parts[0])); name == "SIGKILL" || name == "KILL" {
+			step.Signal = syscall.SIGKILL
+		} else {
+			sig, err := ParseSignal(name)
+			if err != nil {
+				return nil, err
+			}
+			step.Signal = sig
+		}
+
+		if len(parts) == 2 {
+			wait, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid wait after %s: %v", parts[0], err)
+			}
+			step.Wait = wait
+		} else if step.Signal != syscall.SIGKILL {
+			return nil, fmt.Errorf("%q needs to say how long to wait after it, e.g. %s:10s", part, part)
+		}
+
+		if len(sequence) > 0 && sequence[len(sequence)-1].Signal == syscall.SIGKILL {
+			return nil, fmt.Errorf("Nothing can be sent after SIGKILL")
+		}
+
+		sequence = append(sequence, step)
+	}
+
+	if len(sequence) == 0 {
+		return nil, fmt.Errorf("No signals to send")
+	}
+
+	return sequence, nil
+}
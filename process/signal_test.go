@@ -0,0 +1,58 @@
+// +build !windows
+
+package process
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSignal(t *testing.T) {
+	for name, expected := range map[string]syscall.Signal{
+		"SIGUSR1": syscall.SIGUSR1,
+		"usr2":    syscall.SIGUSR2,
+		" INT ":   syscall.SIGINT,
+	} {
+		sig, err := ParseSignal(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig != expected {
+			t.Errorf("Expected %q to be %v, got %v", name, expected, sig)
+		}
+	}
+
+	if _, err := ParseSignal("SIGKILL"); err == nil {
+		t.Errorf("Expected SIGKILL to be unsupported")
+	}
+}
+
+func TestParseKillSequence(t *testing.T) {
+	sequence, err := ParseKillSequence("SIGINT:10s, term:5s,SIGKILL")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []KillStep{
+		{Signal: syscall.SIGINT, Wait: 10 * time.Second},
+		{Signal: syscall.SIGTERM, Wait: 5 * time.Second},
+		{Signal: syscall.SIGKILL},
+	}
+	if !reflect.DeepEqual(sequence, expected) {
+		t.Fatalf("Expected %v, got %v", expected, sequence)
+	}
+
+	for _, value := range []string{
+		"",
+		"SIGINT",
+		"SIGINT:soon",
+		"SIGSEGV:1s",
+		"SIGKILL,SIGTERM:1s",
+	} {
+		if _, err := ParseKillSequence(value); err == nil {
+			t.Errorf("Expected %q to be invalid", value)
+		}
+	}
+}
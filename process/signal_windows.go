@@ -0,0 +1,18 @@
+package process
+
+import (
+	"fmt"
+	"os"
+)
+
+// ParseSignal finds a signal by name. Windows processes can only be
+// interrupted or killed, so the other signals aren't supported.
+func ParseSignal(name string) (os.Signal, error) {
+	return nil, fmt.Errorf("Signals can't be sent to jobs on Windows")
+}
+
+// ParseKillSequence parses the signals for Process.KillSequence, but Windows
+// processes are always killed with TASKKILL
+func ParseKillSequence(value string) ([]KillStep, error) {
+	return nil, fmt.Errorf("Kill signals can't be configured on Windows")
+}
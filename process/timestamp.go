@@ -0,0 +1,71 @@
+package process
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// The formats the time at the start of timestamped lines can be in, as well
+// as any Go time layout
+const (
+	TimestampRFC3339     = "rfc3339"
+	TimestampRFC3339Nano = "rfc3339nano"
+	TimestampEpochMillis = "epoch-millis"
+	TimestampRelative    = "relative"
+)
+
+// ValidateTimestampFormat returns an error if lines can't be timestamped in
+// a format
+func ValidateTimestampFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "", TimestampRFC3339, TimestampRFC3339Nano, TimestampEpochMillis, TimestampRelative:
+		return nil
+	}
+
+	if !logger.IsTimeLayout(format) {
+		return fmt.Errorf("Unknown timestamp format `%s` (expected rfc3339, rfc3339nano, epoch-millis, relative or a Go time layout)", format)
+	}
+
+	return nil
+}
+
+// ParseTimestampLocation returns the timezone timestamped lines are in. It's
+// either "utc" (the default), "local" or a name from the IANA time zone
+// database like "Australia/Melbourne".
+func ParseTimestampLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	location, err := logger.ParseTimezone(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown timestamp timezone `%s`: %v", name, err)
+	}
+
+	return location, nil
+}
+
+// formatTimestamp formats the time a line was written. Relative times are
+// the seconds since the process started.
+func formatTimestamp(format string, location *time.Location, t time.Time, startedAt time.Time) string {
+	if location == nil {
+		location = time.UTC
+	}
+
+	switch strings.ToLower(format) {
+	case "", TimestampRFC3339:
+		return t.In(location).Format(time.RFC3339)
+	case TimestampRFC3339Nano:
+		return t.In(location).Format(time.RFC3339Nano)
+	case TimestampEpochMillis:
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	case TimestampRelative:
+		return fmt.Sprintf("+%.3fs", t.Sub(startedAt).Seconds())
+	}
+
+	return t.In(location).Format(format)
+}
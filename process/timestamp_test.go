@@ -0,0 +1,56 @@
+package process
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatTimestamp(t *testing.T) {
+	melbourne, err := time.LoadLocation("Australia/Melbourne")
+	if err != nil {
+		t.Skipf("No timezone database: %v", err)
+	}
+
+	startedAt := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	now := startedAt.Add(90*time.Second + 250*time.Millisecond)
+
+	for _, tc := range []struct {
+		format   string
+		location *time.Location
+		expected string
+	}{
+		{"", nil, "2019-03-04T05:07:37Z"},
+		{"rfc3339", time.UTC, "2019-03-04T05:07:37Z"},
+		{"RFC3339Nano", nil, "2019-03-04T05:07:37.25Z"},
+		{"rfc3339", melbourne, "2019-03-04T16:07:37+11:00"},
+		{"epoch-millis", melbourne, "1551676057250"},
+		{"relative", nil, "+90.250s"},
+		{"15:04:05.000", nil, "05:07:37.250"},
+	} {
+		if actual := formatTimestamp(tc.format, tc.location, now, startedAt); actual != tc.expected {
+			t.Errorf("formatTimestamp(%q, %v) = %q, expected %q", tc.format, tc.location, actual, tc.expected)
+		}
+	}
+}
+
+func TestValidateTimestampFormat(t *testing.T) {
+	for _, format := range []string{"", "rfc3339", "epoch-millis", "relative", "15:04:05"} {
+		if err := ValidateTimestampFormat(format); err != nil {
+			t.Errorf("ValidateTimestampFormat(%q) = %v", format, err)
+		}
+	}
+
+	if err := ValidateTimestampFormat("llamas"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+func TestParseTimestampLocation(t *testing.T) {
+	if location, err := ParseTimestampLocation(""); err != nil || location != time.UTC {
+		t.Errorf("Expected UTC by default, got %v (%v)", location, err)
+	}
+
+	if _, err := ParseTimestampLocation("Not/A_Timezone"); err == nil {
+		t.Errorf("Expected an error for an unknown timezone")
+	}
+}
@@ -0,0 +1,29 @@
+// +build !windows
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// withUmask returns the script wrapped so that the process sets its umask
+// and then execs the script, keeping the same PID. The umask belongs to the
+// whole agent, so it can't be changed just for the process from here. A
+// umask of 0 leaves the script as it is.
+func withUmask(umask os.FileMode, script []string) []string {
+	if umask == 0 {
+		return script
+	}
+
+	// Leave scripts that can't be found alone, so they fail to start in the
+	// same way as they would without a umask
+	if _, err := exec.LookPath(script[0]); err != nil {
+		return script
+	}
+
+	return append([]string{
+		"/bin/sh", "-c", fmt.Sprintf(`umask %04o && exec "$@"`, umask.Perm()), "buildkite-umask",
+	}, script...)
+}
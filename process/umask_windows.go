@@ -0,0 +1,8 @@
+package process
+
+import "os"
+
+// withUmask returns the script as it is, because Windows doesn't have a umask
+func withUmask(umask os.FileMode, script []string) []string {
+	return script
+}
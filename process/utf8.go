@@ -0,0 +1,76 @@
+package process
+
+import (
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// The encoding of utf8.RuneError, which replaces invalid bytes
+var utf8Replacement = []byte(string(utf8.RuneError))
+
+// utf8Sanitizer is a writer that replaces bytes that aren't valid UTF-8 with
+// the replacement character before passing them on. The start of a character
+// at the end of a write is held back until the next one, so characters can
+// be split across writes.
+type utf8Sanitizer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	partial []byte
+}
+
+func newUTF8Sanitizer(w io.Writer) *utf8Sanitizer {
+	return &utf8Sanitizer{w: w}
+}
+
+func (s *utf8Sanitizer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := p
+	if len(s.partial) > 0 {
+		data = append(s.partial, p...)
+		s.partial = nil
+	}
+
+	out := make([]byte, 0, len(data))
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 {
+			// It might just be the start of a character that's
+			// finished in the next write
+			if !utf8.FullRune(data) {
+				s.partial = append([]byte{}, data...)
+				break
+			}
+
+			out = append(out, utf8Replacement...)
+			data = data[1:]
+			continue
+		}
+
+		out = append(out, data[:size]...)
+		data = data[size:]
+	}
+
+	if _, err := s.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// flush replaces a character that was never finished
+func (s *utf8Sanitizer) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.partial) == 0 {
+		return nil
+	}
+
+	s.partial = nil
+	_, err := s.w.Write(utf8Replacement)
+	return err
+}
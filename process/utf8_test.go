@@ -0,0 +1,37 @@
+package process
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUTF8Sanitizer(t *testing.T) {
+	for _, tc := range []struct {
+		writes   []string
+		expected string
+	}{
+		{[]string{"plain text"}, "plain text"},
+		{[]string{"llamas 🦙"}, "llamas 🦙"},
+		{[]string{"bad \xff\xfe bytes"}, "bad �� bytes"},
+		{[]string{"split \xf0\x9f", "\xa6\x99 llama"}, "split 🦙 llama"},
+		{[]string{"\xf0", "\x9f", "\xa6", "\x99"}, "🦙"},
+		{[]string{"unfinished \xe2\x82"}, "unfinished �"},
+		{[]string{"broken \xe2\x82", "x"}, "broken ��x"},
+	} {
+		var buf bytes.Buffer
+		s := newUTF8Sanitizer(&buf)
+
+		for _, w := range tc.writes {
+			if n, err := s.Write([]byte(w)); err != nil || n != len(w) {
+				t.Fatalf("Write(%q) = %d, %v", w, n, err)
+			}
+		}
+		if err := s.flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != tc.expected {
+			t.Errorf("Writing %q gave %q, expected %q", tc.writes, buf.String(), tc.expected)
+		}
+	}
+}
@@ -5,10 +5,62 @@ package process
 import (
 	"os"
 	"os/exec"
+	"syscall"
 
 	"github.com/kr/pty"
 )
 
+// The end of a PTY that the agent reads and writes
+type ptyFile = *os.File
+
+// What's typed into a PTY to say there's no more input, which is Ctrl-D
+var ptyEndOfInput = []byte{4}
+
+// PTYSupported returns whether processes can be run in a PTY here
+func PTYSupported() bool {
+	return true
+}
+
 func StartPTY(c *exec.Cmd) (*os.File, error) {
-	return pty.Start(c)
+	return StartPTYWithSize(c, 0, 0)
+}
+
+// StartPTYWithSize starts the command in a PTY with the given number of
+// columns and rows. If either is 0, the PTY's default size is used.
+func StartPTYWithSize(c *exec.Cmd, columns, rows uint16) (*os.File, error) {
+	f, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	// It's set before the command starts so that it never sees another
+	if columns > 0 && rows > 0 {
+		if err := pty.Setsize(f, &pty.Winsize{Cols: columns, Rows: rows}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	c.Stdout = tty
+	c.Stdin = tty
+	c.Stderr = tty
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setctty = true
+	c.SysProcAttr.Setsid = true
+
+	if err := c.Start(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// ResizePTY changes the size of a PTY, which the process in it is told about
+// with a SIGWINCH
+func ResizePTY(f *os.File, columns, rows uint16) error {
+	return pty.Setsize(f, &pty.Winsize{Cols: columns, Rows: rows})
 }
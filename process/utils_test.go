@@ -0,0 +1,53 @@
+// +build !windows
+
+package process
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/kr/pty"
+)
+
+func TestStartPTYWithSize(t *testing.T) {
+	cmd := exec.Command("stty", "size")
+
+	f, err := StartPTYWithSize(cmd, 132, 43)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Reading fails once the command has exited and closed its end
+	output, _ := ioutil.ReadAll(f)
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if size := strings.TrimSpace(string(output)); size != "43 132" {
+		t.Fatalf("Expected the size to be 43 rows and 132 columns, got %q", size)
+	}
+}
+
+func TestResizePTY(t *testing.T) {
+	f, tty, err := pty.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer tty.Close()
+
+	if err := ResizePTY(f, 100, 30); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, columns, err := pty.Getsize(tty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 30 || columns != 100 {
+		t.Fatalf("Expected 30 rows and 100 columns, got %d and %d", rows, columns)
+	}
+}
@@ -2,10 +2,273 @@ package process
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// PTYs on Windows use ConPTY, which was added in Windows 10 1809 and Server
+// 2019. It isn't in x/sys yet, so it's called directly.
+var (
+	kernel32                              = windows.NewLazySystemDLL("kernel32.dll")
+	procCreatePseudoConsole               = kernel32.NewProc("CreatePseudoConsole")
+	procClosePseudoConsole                = kernel32.NewProc("ClosePseudoConsole")
+	procInitializeProcThreadAttributeList = kernel32.NewProc("InitializeProcThreadAttributeList")
+	procUpdateProcThreadAttribute         = kernel32.NewProc("UpdateProcThreadAttribute")
+	procDeleteProcThreadAttributeList     = kernel32.NewProc("DeleteProcThreadAttributeList")
+	procResizePseudoConsole               = kernel32.NewProc("ResizePseudoConsole")
+)
+
+const procThreadAttributePseudoConsole = 0x00020016
+
+// The size of the console that processes in a PTY see, unless it's given.
+// It's wide so that long lines in job logs aren't wrapped by the console.
+const (
+	conPTYColumns = 300
+	conPTYRows    = 50
 )
 
-func StartPTY(c *exec.Cmd) (*os.File, error) {
-	return nil, errors.New("PTY is not supported on Windows")
+// STARTUPINFOEXW, which is how the pseudo console is given to the process
+type startupInfoEx struct {
+	windows.StartupInfo
+	attributeList *byte
+}
+
+// PTYSupported returns whether processes can be run in a PTY here
+func PTYSupported() bool {
+	return procCreatePseudoConsole.Find() == nil
+}
+
+// The end of a PTY that the agent reads and writes
+type ptyFile = io.ReadWriteCloser
+
+// What's typed into a PTY to say there's no more input, which is Ctrl-Z and
+// Enter in a Windows console
+var ptyEndOfInput = []byte{26, '\r', '\n'}
+
+// conPTY is the end of a pseudo console that the agent reads the process's
+// output from and writes its input to
+type conPTY struct {
+	in  *os.File
+	out *os.File
+
+	// The pseudo console is closed once the process exits, and can't be
+	// resized after that
+	mu      sync.Mutex
+	console windows.Handle
+	closed  bool
+}
+
+func (c *conPTY) Read(p []byte) (int, error) {
+	return c.out.Read(p)
+}
+
+func (c *conPTY) Write(p []byte) (int, error) {
+	return c.in.Write(p)
+}
+
+func (c *conPTY) Close() error {
+	c.in.Close()
+	return c.out.Close()
+}
+
+// StartPTY starts the command in a new pseudo console. Reading from what's
+// returned gets its output, including the escape sequences the console uses
+// to draw it, until it exits.
+func StartPTY(c *exec.Cmd) (io.ReadWriteCloser, error) {
+	return StartPTYWithSize(c, 0, 0)
+}
+
+// StartPTYWithSize starts the command in a new pseudo console with the given
+// number of columns and rows. If either is 0, the default size is used.
+func StartPTYWithSize(c *exec.Cmd, columns, rows uint16) (io.ReadWriteCloser, error) {
+	if !PTYSupported() {
+		return nil, errors.New("PTY is not supported on this version of Windows, it needs Windows 10 1809 or Server 2019")
+	}
+
+	if c.Err != nil {
+		return nil, c.Err
+	}
+
+	// The pseudo console reads input from one pipe and writes output to
+	// another, and we get the other ends of them
+	var ptyIn, inWrite, outRead, ptyOut windows.Handle
+	if err := windows.CreatePipe(&ptyIn, &inWrite, nil, 0); err != nil {
+		return nil, fmt.Errorf("Failed to create the PTY's input pipe: %v", err)
+	}
+	if err := windows.CreatePipe(&outRead, &ptyOut, nil, 0); err != nil {
+		windows.CloseHandle(ptyIn)
+		windows.CloseHandle(inWrite)
+		return nil, fmt.Errorf("Failed to create the PTY's output pipe: %v", err)
+	}
+
+	if columns == 0 || rows == 0 {
+		columns, rows = conPTYColumns, conPTYRows
+	}
+
+	var console windows.Handle
+	hr, _, _ := procCreatePseudoConsole.Call(consoleSize(columns, rows), uintptr(ptyIn), uintptr(ptyOut), 0, uintptr(unsafe.Pointer(&console)))
+
+	// The pseudo console has its own copies of these
+	windows.CloseHandle(ptyIn)
+	windows.CloseHandle(ptyOut)
+
+	pty := &conPTY{
+		in:      os.NewFile(uintptr(inWrite), "|0"),
+		out:     os.NewFile(uintptr(outRead), "|1"),
+		console: console,
+	}
+
+	if hr != 0 {
+		pty.Close()
+		return nil, fmt.Errorf("Failed to create a pseudo console: HRESULT 0x%x", hr)
+	}
+
+	process, err := createConPTYProcess(c, console)
+	if err != nil {
+		procClosePseudoConsole.Call(uintptr(console))
+		pty.Close()
+		return nil, err
+	}
+
+	// The output pipe only closes once the pseudo console does, so it's
+	// closed once the process exits for reading it to finish
+	go func() {
+		windows.WaitForSingleObject(process, windows.INFINITE)
+		windows.CloseHandle(process)
+
+		pty.mu.Lock()
+		pty.closed = true
+		pty.mu.Unlock()
+
+		procClosePseudoConsole.Call(uintptr(console))
+	}()
+
+	return pty, nil
+}
+
+// ResizePTY changes the size of a pseudo console started by StartPTY
+func ResizePTY(pty io.ReadWriteCloser, columns, rows uint16) error {
+	c, ok := pty.(*conPTY)
+	if !ok {
+		return errors.New("Only pseudo consoles started by StartPTY can be resized")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errors.New("The pseudo console has been closed")
+	}
+
+	if hr, _, _ := procResizePseudoConsole.Call(uintptr(c.console), consoleSize(columns, rows)); hr != 0 {
+		return fmt.Errorf("Failed to resize the pseudo console: HRESULT 0x%x", hr)
+	}
+
+	return nil
+}
+
+// consoleSize packs a size into a COORD, which is passed by value
+func consoleSize(columns, rows uint16) uintptr {
+	return uintptr(columns) | uintptr(rows)<<16
+}
+
+// createConPTYProcess starts the command attached to the pseudo console, and
+// sets its Process so that it can be waited for like it was started by Start
+func createConPTYProcess(c *exec.Cmd, console windows.Handle) (windows.Handle, error) {
+	var size uintptr
+	procInitializeProcThreadAttributeList.Call(0, 1, 0, uintptr(unsafe.Pointer(&size)))
+
+	attributeList := make([]byte, size)
+	if ok, _, err := procInitializeProcThreadAttributeList.Call(uintptr(unsafe.Pointer(&attributeList[0])), 1, 0, uintptr(unsafe.Pointer(&size))); ok == 0 {
+		return 0, fmt.Errorf("Failed to create the process's attributes: %v", err)
+	}
+	defer procDeleteProcThreadAttributeList.Call(uintptr(unsafe.Pointer(&attributeList[0])))
+
+	if ok, _, err := procUpdateProcThreadAttribute.Call(
+		uintptr(unsafe.Pointer(&attributeList[0])), 0, procThreadAttributePseudoConsole,
+		uintptr(console), unsafe.Sizeof(console), 0, 0); ok == 0 {
+		return 0, fmt.Errorf("Failed to attach the pseudo console: %v", err)
+	}
+
+	si := startupInfoEx{attributeList: &attributeList[0]}
+	si.Cb = uint32(unsafe.Sizeof(si))
+
+	// Without this, the process gets the agent's own STDIN and friends
+	// rather than the pseudo console's
+	si.Flags = windows.STARTF_USESTDHANDLES
+
+	appName, err := windows.UTF16PtrFromString(c.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	var commandLine string
+	if c.SysProcAttr != nil {
+		commandLine = c.SysProcAttr.CmdLine
+	}
+	if commandLine == "" {
+		args := make([]string, len(c.Args))
+		for i, arg := range c.Args {
+			args[i] = windows.EscapeArg(arg)
+		}
+		commandLine = strings.Join(args, " ")
+	}
+
+	commandLinePtr, err := windows.UTF16PtrFromString(commandLine)
+	if err != nil {
+		return 0, err
+	}
+
+	var dir *uint16
+	if c.Dir != "" {
+		if dir, err = windows.UTF16PtrFromString(c.Dir); err != nil {
+			return 0, err
+		}
+	}
+
+	env := c.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	var pi windows.ProcessInformation
+	err = windows.CreateProcess(appName, commandLinePtr, nil, nil, false,
+		windows.EXTENDED_STARTUPINFO_PRESENT|windows.CREATE_UNICODE_ENVIRONMENT,
+		createEnvBlock(env), dir, &si.StartupInfo, &pi)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to start %s: %v", c.Path, err)
+	}
+	windows.CloseHandle(pi.Thread)
+
+	if c.Process, err = os.FindProcess(int(pi.ProcessId)); err != nil {
+		windows.CloseHandle(pi.Process)
+		return 0, err
+	}
+
+	return pi.Process, nil
+}
+
+// createEnvBlock turns the environment into the null separated, double null
+// terminated block that CreateProcess wants
+func createEnvBlock(env []string) *uint16 {
+	var block []uint16
+	for _, v := range env {
+		block = append(block, utf16.Encode([]rune(v))...)
+		block = append(block, 0)
+	}
+
+	if len(block) == 0 {
+		block = append(block, 0)
+	}
+
+	block = append(block, 0)
+	return &block[0]
 }
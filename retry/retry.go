@@ -1,6 +1,7 @@
 package retry
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -12,6 +13,10 @@ type Stats struct {
 	Interval  time.Duration
 	Config    *Config
 	breakNext bool
+
+	// Context is done when the attempt times out or the retry loop is
+	// cancelled, so callbacks should pass it to anything that can block
+	Context context.Context
 }
 
 type Config struct {
@@ -19,6 +24,124 @@ type Config struct {
 	Interval time.Duration
 	Forever  bool
 	Jitter   bool
+
+	// Backoff multiplies the interval after every attempt, so a Backoff of 2
+	// doubles the time between each one. Values of 1 or less keep the
+	// interval constant.
+	Backoff float64
+
+	// MaxInterval caps how long the interval can grow when backing off
+	MaxInterval time.Duration
+
+	// MaxElapsed gives up once this much time has passed since the first
+	// attempt, regardless of how many attempts are left
+	MaxElapsed time.Duration
+
+	// AttemptTimeout counts an attempt as failed with ErrAttemptTimeout
+	// once it's taken this long, so that one hung attempt can't use up all
+	// of the time there is for retrying. The attempt's context is
+	// cancelled, but the callback is left to finish on its own.
+	AttemptTimeout time.Duration
+
+	// JitterStrategy randomizes each interval to stop lots of agents
+	// retrying in lockstep, see FullJitter and EqualJitter
+	JitterStrategy JitterStrategy
+
+	// IsPermanent is called with every error the callback returns, and if
+	// it returns true we give up straight away. This lets callers classify
+	// errors (like 4xx responses) in one place rather than in every callback.
+	IsPermanent func(err error) bool
+}
+
+// Where ExponentialBackoff's interval starts, and the most it backs off to
+const (
+	DefaultBackoffInterval    = 1 * time.Second
+	DefaultBackoffMaxInterval = 30 * time.Second
+)
+
+// ExponentialBackoff is the config most API calls are retried with. It tries
+// up to maximum times, doubling the interval after every attempt from
+// DefaultBackoffInterval up to DefaultBackoffMaxInterval, with EqualJitter so
+// that lots of agents don't retry in lockstep. Callers can change anything
+// else they need on what it returns.
+func ExponentialBackoff(maximum int) *Config {
+	return &Config{
+		Maximum:        maximum,
+		Interval:       DefaultBackoffInterval,
+		Backoff:        2,
+		MaxInterval:    DefaultBackoffMaxInterval,
+		JitterStrategy: EqualJitter,
+	}
+}
+
+// ErrAttemptTimeout is the error an attempt fails with when it takes longer
+// than the AttemptTimeout
+var ErrAttemptTimeout = errors.New("Attempt timed out")
+
+// permanentError wraps an error that shouldn't be retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error as not worth retrying. When a callback returns
+// one, Do gives up immediately and returns the original error.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns whether the error was wrapped with Permanent, either
+// directly or by any of the errors it wraps
+func IsPermanent(err error) bool {
+	for err != nil {
+		if _, ok := err.(*permanentError); ok {
+			return true
+		}
+
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+
+	return false
+}
+
+// JitterStrategy takes an interval and returns a randomized version of it
+type JitterStrategy func(interval time.Duration) time.Duration
+
+// FullJitter picks an interval anywhere between zero and the interval
+func FullJitter(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return interval
+	}
+	return time.Duration(rand.Int63n(int64(interval) + 1))
+}
+
+// EqualJitter keeps half the interval and randomizes the other half
+func EqualJitter(interval time.Duration) time.Duration {
+	if interval <= 1 {
+		return interval
+	}
+	half := interval / 2
+	return half + time.Duration(rand.Int63n(int64(interval-half)+1))
+}
+
+// AdditiveJitter adds up to a second to the interval, which is what the
+// Jitter option does
+func AdditiveJitter(interval time.Duration) time.Duration {
+	return interval + time.Duration(rand.Int63n(int64(time.Second)))
 }
 
 // A human readable representation often useful for debugging.
@@ -44,6 +167,12 @@ func (s *Stats) Break() {
 }
 
 func Do(callback func(*Stats) error, config *Config) error {
+	return DoWithContext(context.Background(), callback, config)
+}
+
+// DoWithContext is like Do, but stops retrying as soon as the context is
+// done, returning the context's error instead of waiting out the interval.
+func DoWithContext(ctx context.Context, callback func(*Stats) error, config *Config) error {
 	var err error
 
 	// Setup a default config for the retry
@@ -65,23 +194,45 @@ func Do(callback func(*Stats) error, config *Config) error {
 	// The stats struct that is passed to every attempt of the callback
 	stats := &Stats{Attempt: 1, Config: config}
 
-	// Needed for jitter calcs
-	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// The interval before any jitter is applied, which grows after each
+	// attempt if we're backing off
+	interval := config.Interval
+	startedAt := time.Now()
 
 	for {
+		// Don't bother starting an attempt if we've been cancelled
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
 		// Preconfigure the interval that will be used (so that we have
 		// access to it in the callback)
-		stats.Interval = config.Interval
+		stats.Interval = interval
 		if config.Jitter {
-			stats.Interval = stats.Interval + (time.Duration(1000*random.Float32()) * time.Millisecond)
+			stats.Interval = AdditiveJitter(stats.Interval)
+		}
+		if config.JitterStrategy != nil {
+			stats.Interval = config.JitterStrategy(stats.Interval)
 		}
 
 		// Attempt the callback
-		err = callback(stats)
+		err = attempt(ctx, callback, stats)
 		if err == nil {
 			return nil
 		}
 
+		// Don't retry errors that will just happen again. Ones wrapped
+		// with context of their own are returned with it.
+		if p, ok := err.(*permanentError); ok {
+			return p.err
+		}
+		if IsPermanent(err) {
+			return err
+		}
+		if config.IsPermanent != nil && config.IsPermanent(err) {
+			return err
+		}
+
 		// If the loop has callen stats.Break(), we should cancel out
 		// of the loop
 		if stats.breakNext {
@@ -91,8 +242,20 @@ func Do(callback func(*Stats) error, config *Config) error {
 		// Bump the attempt number
 		stats.Attempt = stats.Attempt + 1
 
-		// Try the callback again after the interval
-		time.Sleep(stats.Interval)
+		// Give up if the next attempt would be past the deadline
+		if config.MaxElapsed > 0 && time.Now().Sub(startedAt)+stats.Interval > config.MaxElapsed {
+			return err
+		}
+
+		// Try the callback again after the interval, unless we're
+		// cancelled while waiting
+		timer := time.NewTimer(stats.Interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
 
 		if !stats.Config.Forever {
 			// Should we give up?
@@ -100,7 +263,48 @@ func Do(callback func(*Stats) error, config *Config) error {
 				break
 			}
 		}
+
+		// Back off for the next attempt
+		if config.Backoff > 1 {
+			interval = time.Duration(float64(interval) * config.Backoff)
+			if config.MaxInterval > 0 && interval > config.MaxInterval {
+				interval = config.MaxInterval
+			}
+		}
 	}
 
 	return err
 }
+
+// attempt calls the callback once, giving up on it if it takes longer than
+// the AttemptTimeout
+func attempt(ctx context.Context, callback func(*Stats) error, stats *Stats) error {
+	if stats.Config.AttemptTimeout <= 0 {
+		stats.Context = ctx
+		return callback(stats)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, stats.Config.AttemptTimeout)
+	defer cancel()
+
+	// A callback that times out keeps running, so it gets its own copy of
+	// the stats that later attempts won't touch
+	attemptStats := *stats
+	attemptStats.Context = attemptCtx
+
+	result := make(chan error, 1)
+	go func() {
+		result <- callback(&attemptStats)
+	}()
+
+	select {
+	case err := <-result:
+		stats.breakNext = attemptStats.breakNext
+		return err
+	case <-attemptCtx.Done():
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return Permanent(ctxErr)
+		}
+		return ErrAttemptTimeout
+	}
+}
@@ -0,0 +1,207 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoRetriesUntilMaximum(t *testing.T) {
+	attempts := 0
+
+	err := Do(func(s *Stats) error {
+		attempts++
+		return errors.New("Nope")
+	}, &Config{Maximum: 3, Interval: time.Millisecond})
+
+	assert.EqualError(t, err, "Nope")
+	assert.Equal(t, 3, attempts)
+}
+
+func TestDoStopsWhenBroken(t *testing.T) {
+	attempts := 0
+
+	err := Do(func(s *Stats) error {
+		attempts++
+		s.Break()
+		return errors.New("Nope")
+	}, &Config{Maximum: 3, Interval: time.Millisecond})
+
+	assert.EqualError(t, err, "Nope")
+	assert.Equal(t, 1, attempts)
+}
+
+func TestDoWithContextStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	err := DoWithContext(ctx, func(s *Stats) error {
+		attempts++
+		return errors.New("Nope")
+	}, &Config{Forever: true, Interval: time.Hour})
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, attempts)
+	assert.True(t, time.Now().Sub(start) < time.Second)
+}
+
+func TestDoWithContextDoesNothingWhenAlreadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := DoWithContext(ctx, func(s *Stats) error {
+		t.Fatal("Callback shouldn't be called")
+		return nil
+	}, nil)
+
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDoBacksOffExponentiallyUpToMaxInterval(t *testing.T) {
+	intervals := []time.Duration{}
+
+	Do(func(s *Stats) error {
+		intervals = append(intervals, s.Interval)
+		return errors.New("Nope")
+	}, &Config{Maximum: 5, Interval: time.Millisecond, Backoff: 2, MaxInterval: 5 * time.Millisecond})
+
+	assert.Equal(t, []time.Duration{
+		1 * time.Millisecond,
+		2 * time.Millisecond,
+		4 * time.Millisecond,
+		5 * time.Millisecond,
+		5 * time.Millisecond,
+	}, intervals)
+}
+
+func TestDoGivesUpAfterMaxElapsed(t *testing.T) {
+	attempts := 0
+
+	err := Do(func(s *Stats) error {
+		attempts++
+		return errors.New("Nope")
+	}, &Config{Forever: true, Interval: 20 * time.Millisecond, MaxElapsed: 50 * time.Millisecond})
+
+	assert.EqualError(t, err, "Nope")
+	assert.True(t, attempts >= 2 && attempts <= 3)
+}
+
+func TestJitterStrategiesStayInRange(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		full := FullJitter(time.Second)
+		assert.True(t, full >= 0 && full <= time.Second)
+
+		equal := EqualJitter(time.Second)
+		assert.True(t, equal >= time.Second/2 && equal <= time.Second)
+	}
+}
+
+func TestDoStopsOnPermanentError(t *testing.T) {
+	attempts := 0
+
+	err := Do(func(s *Stats) error {
+		attempts++
+		return Permanent(errors.New("Nope"))
+	}, &Config{Maximum: 3, Interval: time.Millisecond})
+
+	assert.EqualError(t, err, "Nope")
+	assert.False(t, IsPermanent(err))
+	assert.Equal(t, 1, attempts)
+}
+
+// An error with context around another, like fmt.Errorf's %w makes
+type wrappedError struct {
+	msg string
+	err error
+}
+
+func (e *wrappedError) Error() string { return e.msg + ": " + e.err.Error() }
+func (e *wrappedError) Unwrap() error { return e.err }
+
+func TestDoStopsOnWrappedPermanentError(t *testing.T) {
+	attempts := 0
+
+	err := Do(func(s *Stats) error {
+		attempts++
+		return &wrappedError{msg: "Uploading", err: Permanent(errors.New("Nope"))}
+	}, &Config{Maximum: 3, Interval: time.Millisecond})
+
+	assert.EqualError(t, err, "Uploading: Nope")
+	assert.Equal(t, 1, attempts)
+}
+
+func TestIsPermanentWalksWrappedErrors(t *testing.T) {
+	assert.True(t, IsPermanent(&wrappedError{msg: "outer", err: &wrappedError{msg: "inner", err: Permanent(errors.New("Nope"))}}))
+	assert.False(t, IsPermanent(&wrappedError{msg: "outer", err: errors.New("Nope")}))
+}
+
+func TestDoStopsWhenIsPermanentMatches(t *testing.T) {
+	attempts := 0
+	fatal := errors.New("Fatal")
+
+	err := Do(func(s *Stats) error {
+		attempts++
+		if attempts == 2 {
+			return fatal
+		}
+		return errors.New("Nope")
+	}, &Config{Maximum: 5, Interval: time.Millisecond, IsPermanent: func(err error) bool {
+		return err == fatal
+	}})
+
+	assert.Equal(t, fatal, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestPermanentIsNilSafe(t *testing.T) {
+	assert.Nil(t, Permanent(nil))
+	assert.False(t, IsPermanent(nil))
+}
+
+func TestDoTimesOutHungAttempts(t *testing.T) {
+	var attempts int32
+
+	start := time.Now()
+	err := Do(func(s *Stats) error {
+		// The first attempt hangs until it's given up on
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			<-s.Context.Done()
+			time.Sleep(time.Second)
+		}
+		return nil
+	}, &Config{Maximum: 3, Interval: time.Millisecond, AttemptTimeout: 20 * time.Millisecond})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.True(t, time.Now().Sub(start) < time.Second)
+}
+
+func TestDoReturnsAttemptTimeoutWhenEveryAttemptHangs(t *testing.T) {
+	err := Do(func(s *Stats) error {
+		<-s.Context.Done()
+		return s.Context.Err()
+	}, &Config{Maximum: 2, Interval: time.Millisecond, AttemptTimeout: 10 * time.Millisecond})
+
+	assert.Equal(t, ErrAttemptTimeout, err)
+}
+
+func TestExponentialBackoffConfigsAreIndependent(t *testing.T) {
+	config := ExponentialBackoff(5)
+	assert.Equal(t, 5, config.Maximum)
+	assert.Equal(t, DefaultBackoffInterval, config.Interval)
+	assert.Equal(t, DefaultBackoffMaxInterval, config.MaxInterval)
+
+	// Changing one doesn't change the next
+	config.Forever = true
+	assert.False(t, ExponentialBackoff(5).Forever)
+}
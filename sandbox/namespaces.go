@@ -0,0 +1,135 @@
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultNamespaceTmpfs are the directories outside of the checkout that a
+// sandboxed command is most likely to write to
+var DefaultNamespaceTmpfs = []string{"/tmp", "/var/tmp", "/dev/shm"}
+
+// NamespaceSyscalls are the syscalls NamespaceWrap needs to set up the
+// namespaces, which a seccomp profile can't deny if it's used as well
+var NamespaceSyscalls = []string{"mount", "unshare"}
+
+// CheckNamespaceSyscalls returns an error if a seccomp profile denies any of
+// the syscalls that NamespaceWrap needs
+func CheckNamespaceSyscalls(denied []string) error {
+	var conflicts []string
+	for _, name := range NamespaceSyscalls {
+		for _, d := range denied {
+			if d == name {
+				conflicts = append(conflicts, name)
+				break
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("The seccomp profile denies %s, which the command sandbox needs. Use a profile that allows them, or don't use the command sandbox.",
+			strings.Join(conflicts, " and "))
+	}
+
+	return nil
+}
+
+// NamespaceOptions configures how NamespaceWrap isolates a command
+type NamespaceOptions struct {
+	// Directories to hide behind an empty tmpfs, so that anything written to
+	// them is thrown away when the command finishes
+	Tmpfs []string
+
+	// Directories that have to stay visible, like the checkout and the one
+	// with the agent's socket in it. Ones inside a tmpfs directory are
+	// mounted back in on top of it, and a tmpfs isn't mounted over a
+	// directory that's inside one of them.
+	Keep []string
+
+	// Map the current user to root in a new user namespace, which is needed
+	// to create the other namespaces without being root
+	MapRootUser bool
+}
+
+// The file descriptors that kept directories are held open on while the
+// tmpfs is mounted over them, counting down from 9 as that's the highest a
+// POSIX shell can redirect
+const (
+	firstKeepFd = 9
+	lastKeepFd  = 3
+)
+
+// NamespaceWrap prefixes a command so that it runs in new mount, PID and
+// network namespaces using unshare from util-linux. The command can't see or
+// signal processes outside the sandbox, only has a loopback network, and
+// writes to the tmpfs directories don't outlive it.
+func NamespaceWrap(cmd []string, opts NamespaceOptions) []string {
+	wrapped := []string{"unshare", "--mount", "--pid", "--net", "--fork", "--mount-proc"}
+	if opts.MapRootUser {
+		wrapped = append(wrapped, "--map-root-user")
+	}
+
+	var open, mount, rebind, close []string
+	fd := firstKeepFd
+
+	for _, dir := range opts.Tmpfs {
+		if withinAny(dir, opts.Keep) {
+			continue
+		}
+
+		// Kept directories inside this one are opened before the tmpfs
+		// hides them, and then mounted back in from the open directory
+		var kept []string
+		for _, path := range opts.Keep {
+			if withinAny(path, []string{dir}) {
+				kept = append(kept, path)
+			}
+		}
+		if fd-len(kept) < lastKeepFd-1 {
+			continue
+		}
+
+		mount = append(mount, "mount -t tmpfs -o mode=1777 tmpfs "+shellQuote(dir))
+		for _, path := range kept {
+			open = append(open, fmt.Sprintf("exec %d<%s", fd, shellQuote(path)))
+			rebind = append(rebind,
+				"mkdir -p "+shellQuote(path),
+				fmt.Sprintf("mount --no-canonicalize --bind /proc/self/fd/%d %s", fd, shellQuote(path)),
+			)
+			close = append(close, fmt.Sprintf("exec %d<&-", fd))
+			fd--
+		}
+	}
+
+	script := []string{"set -e"}
+	script = append(script, open...)
+	script = append(script, mount...)
+	script = append(script, rebind...)
+	script = append(script, close...)
+	script = append(script,
+		"ip link set lo up 2>/dev/null || true",
+		`exec "$@"`,
+	)
+
+	wrapped = append(wrapped, "--", "/bin/sh", "-c", strings.Join(script, "\n"), "buildkite-sandbox")
+	return append(wrapped, cmd...)
+}
+
+// withinAny returns whether path is, or is inside, any of the directories
+func withinAny(path string, dirs []string) bool {
+	path = filepath.Clean(path)
+
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func shellQuote(s string) string {
+	return `'` + strings.Replace(s, `'`, `'\''`, -1) + `'`
+}
@@ -0,0 +1,54 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestNamespaceWrapHidesTmpButKeepsDirectoriesInIt(t *testing.T) {
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare isn't available")
+	}
+
+	kept, err := ioutil.TempDir("/tmp", "sandbox-kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(kept)
+
+	other, err := ioutil.TempDir("/tmp", "sandbox-other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+
+	if err := ioutil.WriteFile(filepath.Join(kept, "checkout"), []byte("llamas"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	script := `test -f "$1/checkout" && ! test -e "$2" && echo alpacas > "$1/written" && echo junk > "$2.junk"`
+	wrapped := NamespaceWrap([]string{"/bin/sh", "-c", script, "test", kept, other}, NamespaceOptions{
+		Tmpfs:       DefaultNamespaceTmpfs,
+		Keep:        []string{kept},
+		MapRootUser: os.Getuid() != 0,
+	})
+
+	if output, err := exec.Command(wrapped[0], wrapped[1:]...).CombinedOutput(); err != nil {
+		// Check namespaces work at all before failing
+		if plain, err := exec.Command("unshare", "--mount", "--map-root-user", "true").CombinedOutput(); err != nil {
+			t.Skipf("Namespaces aren't available: %v: %s", err, plain)
+		}
+		t.Fatalf("Expected the sandbox to keep %s and hide the rest of /tmp: %v: %s", kept, err, output)
+	}
+
+	if _, err := os.Stat(filepath.Join(kept, "written")); err != nil {
+		t.Fatalf("Expected writes to the kept directory to outlive the sandbox: %v", err)
+	}
+	if _, err := os.Stat(other + ".junk"); err == nil {
+		os.Remove(other + ".junk")
+		t.Fatal("Expected writes to the rest of /tmp to be thrown away")
+	}
+}
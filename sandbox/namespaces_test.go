@@ -0,0 +1,71 @@
+package sandbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamespaceWrap(t *testing.T) {
+	wrapped := NamespaceWrap([]string{"/bin/bash", "-c", "make test"}, NamespaceOptions{
+		Tmpfs:       []string{"/tmp", "/var/tmp", "/dev/shm"},
+		Keep:        []string{"/var/tmp/builds/my-pipeline", "/tmp/agent-socket123", "/dev"},
+		MapRootUser: true,
+	})
+
+	expected := []string{
+		"unshare", "--mount", "--pid", "--net", "--fork", "--mount-proc", "--map-root-user", "--",
+		"/bin/sh", "-c", "set -e\n" +
+			"exec 9<'/tmp/agent-socket123'\n" +
+			"exec 8<'/var/tmp/builds/my-pipeline'\n" +
+			"mount -t tmpfs -o mode=1777 tmpfs '/tmp'\n" +
+			"mount -t tmpfs -o mode=1777 tmpfs '/var/tmp'\n" +
+			"mkdir -p '/tmp/agent-socket123'\n" +
+			"mount --no-canonicalize --bind /proc/self/fd/9 '/tmp/agent-socket123'\n" +
+			"mkdir -p '/var/tmp/builds/my-pipeline'\n" +
+			"mount --no-canonicalize --bind /proc/self/fd/8 '/var/tmp/builds/my-pipeline'\n" +
+			"exec 9<&-\n" +
+			"exec 8<&-\n" +
+			"ip link set lo up 2>/dev/null || true\n" +
+			`exec "$@"`,
+		"buildkite-sandbox",
+		"/bin/bash", "-c", "make test",
+	}
+
+	if !reflect.DeepEqual(wrapped, expected) {
+		t.Fatalf("Expected %q, got %q", expected, wrapped)
+	}
+}
+
+func TestWithinAny(t *testing.T) {
+	for _, tc := range []struct {
+		path     string
+		dirs     []string
+		expected bool
+	}{
+		{"/tmp", []string{"/tmp"}, true},
+		{"/tmp/builds/checkout", []string{"/tmp"}, true},
+		{"/tmpfoo/checkout", []string{"/tmp"}, false},
+		{"/home/buildkite", []string{"/"}, true},
+		{"/tmp", []string{"/tmp/builds"}, false},
+		{"/var/tmp", nil, false},
+	} {
+		if actual := withinAny(tc.path, tc.dirs); actual != tc.expected {
+			t.Errorf("withinAny(%q, %q) = %v, expected %v", tc.path, tc.dirs, actual, tc.expected)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if actual := shellQuote(`/tmp/it's here`); actual != `'/tmp/it'\''s here'` {
+		t.Fatalf("Unexpected quoting %s", actual)
+	}
+}
+
+func TestCheckNamespaceSyscalls(t *testing.T) {
+	if err := CheckNamespaceSyscalls(DefaultDeniedSyscalls); err == nil {
+		t.Fatal("Expected the default seccomp profile to conflict with the command sandbox")
+	}
+	if err := CheckNamespaceSyscalls([]string{"ptrace", "bpf"}); err != nil {
+		t.Fatalf("Expected no conflict, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Package sandbox restricts what the processes that run a job are allowed to
+// do, using the security modules available on Linux
+package sandbox
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultSeccompProfile is the name of the built-in seccomp profile, which
+// blocks DefaultDeniedSyscalls
+const DefaultSeccompProfile = "default"
+
+// DefaultDeniedSyscalls are syscalls that builds shouldn't need, and that an
+// untrusted pipeline could use to interfere with the host or other jobs
+var DefaultDeniedSyscalls = []string{
+	"acct",
+	"add_key",
+	"bpf",
+	"clock_settime",
+	"delete_module",
+	"finit_module",
+	"init_module",
+	"kexec_load",
+	"keyctl",
+	"mount",
+	"open_by_handle_at",
+	"perf_event_open",
+	"pivot_root",
+	"process_vm_readv",
+	"process_vm_writev",
+	"ptrace",
+	"reboot",
+	"request_key",
+	"setdomainname",
+	"sethostname",
+	"setns",
+	"settimeofday",
+	"swapoff",
+	"swapon",
+	"umount2",
+	"unshare",
+	"userfaultfd",
+}
+
+// ReadSeccompProfile returns the syscalls that a seccomp profile denies. The
+// profile is either "default", or the path to a file with the name of a
+// syscall on each line. Blank lines and lines starting with # are ignored.
+func ReadSeccompProfile(profile string) ([]string, error) {
+	if profile == DefaultSeccompProfile {
+		return DefaultDeniedSyscalls, nil
+	}
+
+	f, err := os.Open(profile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read seccomp profile: %v", err)
+	}
+	defer f.Close()
+
+	var syscalls []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		syscalls = append(syscalls, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read seccomp profile: %v", err)
+	}
+
+	return syscalls, nil
+}
+
+// Wrap prefixes a command so that it's launched under an AppArmor profile
+// and/or an SELinux context, using aa-exec and runcon. Either can be empty.
+func Wrap(cmd []string, apparmorProfile string, selinuxContext string) []string {
+	var wrapped []string
+
+	if apparmorProfile != "" {
+		wrapped = append(wrapped, "aa-exec", "-p", apparmorProfile, "--")
+	}
+
+	if selinuxContext != "" {
+		wrapped = append(wrapped, "runcon", selinuxContext)
+	}
+
+	return append(wrapped, cmd...)
+}
@@ -0,0 +1,55 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadSeccompProfile(t *testing.T) {
+	f, err := ioutil.TempFile("", "seccomp-profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("# No debugging\nptrace\n\n  mount  \n")
+	f.Close()
+
+	syscalls, err := ReadSeccompProfile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"ptrace", "mount"}; !reflect.DeepEqual(syscalls, expected) {
+		t.Fatalf("Expected %v, got %v", expected, syscalls)
+	}
+}
+
+func TestReadDefaultSeccompProfile(t *testing.T) {
+	syscalls, err := ReadSeccompProfile(DefaultSeccompProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(syscalls, DefaultDeniedSyscalls) {
+		t.Fatalf("Expected the default syscalls, got %v", syscalls)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	for _, tc := range []struct {
+		apparmor, selinux string
+		expected          []string
+	}{
+		{"", "", []string{"buildkite-agent", "bootstrap"}},
+		{"builds", "", []string{"aa-exec", "-p", "builds", "--", "buildkite-agent", "bootstrap"}},
+		{"", "system_u:system_r:build_t:s0", []string{"runcon", "system_u:system_r:build_t:s0", "buildkite-agent", "bootstrap"}},
+	} {
+		wrapped := Wrap([]string{"buildkite-agent", "bootstrap"}, tc.apparmor, tc.selinux)
+		if !reflect.DeepEqual(wrapped, tc.expected) {
+			t.Errorf("Expected %v, got %v", tc.expected, wrapped)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// +build linux,amd64 linux,arm64
+
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The syscalls that can be named in a seccomp profile
+var syscallNumbers = map[string]uint32{
+	"acct":              unix.SYS_ACCT,
+	"add_key":           unix.SYS_ADD_KEY,
+	"bpf":               unix.SYS_BPF,
+	"chroot":            unix.SYS_CHROOT,
+	"clock_settime":     unix.SYS_CLOCK_SETTIME,
+	"delete_module":     unix.SYS_DELETE_MODULE,
+	"finit_module":      unix.SYS_FINIT_MODULE,
+	"init_module":       unix.SYS_INIT_MODULE,
+	"kexec_load":        unix.SYS_KEXEC_LOAD,
+	"keyctl":            unix.SYS_KEYCTL,
+	"mount":             unix.SYS_MOUNT,
+	"open_by_handle_at": unix.SYS_OPEN_BY_HANDLE_AT,
+	"perf_event_open":   unix.SYS_PERF_EVENT_OPEN,
+	"pivot_root":        unix.SYS_PIVOT_ROOT,
+	"process_vm_readv":  unix.SYS_PROCESS_VM_READV,
+	"process_vm_writev": unix.SYS_PROCESS_VM_WRITEV,
+	"ptrace":            unix.SYS_PTRACE,
+	"reboot":            unix.SYS_REBOOT,
+	"request_key":       unix.SYS_REQUEST_KEY,
+	"setdomainname":     unix.SYS_SETDOMAINNAME,
+	"sethostname":       unix.SYS_SETHOSTNAME,
+	"setns":             unix.SYS_SETNS,
+	"settimeofday":      unix.SYS_SETTIMEOFDAY,
+	"swapoff":           unix.SYS_SWAPOFF,
+	"swapon":            unix.SYS_SWAPON,
+	"umount2":           unix.SYS_UMOUNT2,
+	"unshare":           unix.SYS_UNSHARE,
+	"userfaultfd":       unix.SYS_USERFAULTFD,
+}
+
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+
+	seccompRetAllow = 0x7fff0000
+	seccompRetErrno = 0x00050000
+
+	// Syscalls made using the x32 ABI have this bit set
+	x32SyscallBit = 0x40000000
+)
+
+// ApplySeccomp installs a seccomp filter on every thread of the current
+// process that makes the given syscalls fail with EPERM. The filter is
+// inherited by child processes and can't be removed.
+func ApplySeccomp(denied []string) error {
+	filter, err := seccompFilter(denied)
+	if err != nil {
+		return err
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// Required to install a filter without CAP_SYS_ADMIN, and stops the
+	// filter being escaped by running a setuid binary
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("Failed to set no_new_privs: %v", err)
+	}
+
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to install seccomp filter: %v", errno)
+	}
+
+	return nil
+}
+
+// seccompFilter builds a BPF program that returns EPERM for the denied
+// syscalls, and for any syscall made with an architecture or ABI that the
+// syscall numbers don't apply to
+func seccompFilter(denied []string) ([]unix.SockFilter, error) {
+	numbers := map[uint32]bool{}
+	for _, name := range denied {
+		nr, ok := syscallNumbers[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown syscall %q in seccomp profile", name)
+		}
+		numbers[nr] = true
+	}
+
+	sorted := make([]uint32, 0, len(numbers))
+	for nr := range numbers {
+		sorted = append(sorted, nr)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	n := len(sorted)
+	if n > 250 {
+		return nil, fmt.Errorf("Too many syscalls in seccomp profile")
+	}
+
+	errno := uint32(seccompRetErrno | uint32(unix.EPERM))
+
+	filter := []unix.SockFilter{
+		// Check the architecture matches the syscall numbers
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 4),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch, 1, 0),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, errno),
+
+		// Load the syscall number, and reject the x32 ABI
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, 0),
+		bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, uint8(n+1), 0),
+	}
+
+	for i, nr := range sorted {
+		filter = append(filter, bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, uint8(n-i), 0))
+	}
+
+	return append(filter,
+		bpfStmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, errno),
+	), nil
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt uint8, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
@@ -0,0 +1,4 @@
+package sandbox
+
+// AUDIT_ARCH_X86_64 from linux/audit.h
+const auditArch = 0xc000003e
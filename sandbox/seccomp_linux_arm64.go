@@ -0,0 +1,4 @@
+package sandbox
+
+// AUDIT_ARCH_AARCH64 from linux/audit.h
+const auditArch = 0xc00000b7
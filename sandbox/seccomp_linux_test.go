@@ -0,0 +1,108 @@
+// +build linux,amd64 linux,arm64
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompFilterRejectsUnknownSyscalls(t *testing.T) {
+	if _, err := seccompFilter([]string{"llamas"}); err == nil {
+		t.Fatalf("Expected an error for an unknown syscall")
+	}
+}
+
+func TestSeccompFilterForDefaultProfile(t *testing.T) {
+	filter, err := seccompFilter(DefaultDeniedSyscalls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := 5 + len(DefaultDeniedSyscalls) + 2; len(filter) != expected {
+		t.Fatalf("Expected %d instructions, got %d", expected, len(filter))
+	}
+}
+
+// process_vm_readv with nothing to read succeeds for the current process, so
+// it's a safe syscall to check is denied
+func processVMReadv() error {
+	_, _, errno := unix.Syscall6(unix.SYS_PROCESS_VM_READV, uintptr(os.Getpid()), 0, 0, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func TestApplySeccompDeniesSyscalls(t *testing.T) {
+	if os.Getenv("SANDBOX_TEST_APPLY_SECCOMP") == "1" {
+		if err := ApplySeccomp([]string{"process_vm_readv"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := processVMReadv(); err != unix.EPERM {
+			t.Fatalf("Expected EPERM, got %v", err)
+		}
+		return
+	}
+
+	if err := processVMReadv(); err != nil {
+		t.Skipf("process_vm_readv isn't available: %v", err)
+	}
+
+	// The filter can't be removed, so apply it in a separate test process
+	cmd := exec.Command(os.Args[0], "-test.run=TestApplySeccompDeniesSyscalls")
+	cmd.Env = append(os.Environ(), "SANDBOX_TEST_APPLY_SECCOMP=1")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+}
+
+// The default profile without the syscalls the command sandbox needs, which
+// is what a profile has to allow to use both
+func namespaceSeccompProfile() []string {
+	var denied []string
+	for _, name := range DefaultDeniedSyscalls {
+		if name != "mount" && name != "unshare" {
+			denied = append(denied, name)
+		}
+	}
+	return denied
+}
+
+func TestNamespaceWrapUnderSeccomp(t *testing.T) {
+	wrapped := NamespaceWrap([]string{"/bin/sh", "-c", "test -d /tmp"}, NamespaceOptions{
+		Tmpfs:       DefaultNamespaceTmpfs,
+		MapRootUser: os.Getuid() != 0,
+	})
+
+	if os.Getenv("SANDBOX_TEST_NAMESPACE_SECCOMP") == "1" {
+		denied := namespaceSeccompProfile()
+		if err := CheckNamespaceSyscalls(denied); err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplySeccomp(denied); err != nil {
+			t.Fatal(err)
+		}
+		if output, err := exec.Command(wrapped[0], wrapped[1:]...).CombinedOutput(); err != nil {
+			t.Fatalf("Expected the sandbox to work under seccomp: %v: %s", err, output)
+		}
+		return
+	}
+
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare isn't available")
+	}
+	if output, err := exec.Command(wrapped[0], wrapped[1:]...).CombinedOutput(); err != nil {
+		t.Skipf("Namespaces aren't available: %v: %s", err, output)
+	}
+
+	// The filter can't be removed, so apply it in a separate test process
+	cmd := exec.Command(os.Args[0], "-test.run=TestNamespaceWrapUnderSeccomp")
+	cmd.Env = append(os.Environ(), "SANDBOX_TEST_NAMESPACE_SECCOMP=1")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+}
@@ -0,0 +1,13 @@
+// +build !linux !amd64,!arm64
+
+package sandbox
+
+import (
+	"errors"
+	"runtime"
+)
+
+// ApplySeccomp isn't supported on this platform
+func ApplySeccomp(denied []string) error {
+	return errors.New("Seccomp profiles aren't supported on " + runtime.GOOS + "/" + runtime.GOARCH)
+}
@@ -1,24 +1,12 @@
 package stdin
 
-import (
-	"os"
-)
-
 // This is a tricky problem and we have gone through several iterations before
 // settling on something that works well for recent golang across windows,
-// linux and macos.
+// linux and macos. Where it can't be worked out, commands that read from
+// stdin have a --stdin flag to say so explicitly.
 
+// IsReadable returns true if something has been piped or redirected into
+// stdin, rather than it being an interactive terminal (or nothing at all)
 func IsReadable() bool {
-	fi, err := os.Stdin.Stat()
-	if err != nil {
-		return false
-	}
-
-	// Character devices in Linux/Unix are unbuffered devices that have
-	// direct access to underlying hardware and don't allow reading single characters at a time
-	if (fi.Mode() & os.ModeCharDevice) == os.ModeCharDevice {
-		return false
-	}
-
-	return true
+	return isReadable()
 }
@@ -0,0 +1,22 @@
+// +build !windows
+
+package stdin
+
+import (
+	"os"
+)
+
+func isReadable() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+
+	// Character devices in Linux/Unix are unbuffered devices that have
+	// direct access to underlying hardware and don't allow reading single characters at a time
+	if (fi.Mode() & os.ModeCharDevice) == os.ModeCharDevice {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,41 @@
+package stdin
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// On Windows os.Stdin.Stat() isn't much help, since consoles, the NUL device
+// and pipes from PowerShell all look a bit alike. Instead we ask Windows
+// what kind of handle stdin is.
+func isReadable() bool {
+	handle := windows.Handle(os.Stdin.Fd())
+	if handle == windows.InvalidHandle || handle == 0 {
+		return false
+	}
+
+	// If it has a console mode, it's an interactive console
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err == nil {
+		return false
+	}
+
+	fileType, err := windows.GetFileType(handle)
+	if err != nil {
+		return false
+	}
+
+	switch fileType {
+	case windows.FILE_TYPE_PIPE:
+		// Anonymous pipes from cmd and PowerShell, and named pipes
+		return true
+	case windows.FILE_TYPE_DISK:
+		// Redirected from a file with <
+		return true
+	default:
+		// FILE_TYPE_CHAR is the NUL device (or a serial port), and
+		// FILE_TYPE_UNKNOWN is anything else we can't read from
+		return false
+	}
+}
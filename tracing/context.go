@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TraceID identifies every span that's part of the same trace
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceID) IsZero() bool {
+	return t == TraceID{}
+}
+
+// SpanID identifies a single span within a trace
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsZero() bool {
+	return s == SpanID{}
+}
+
+// SpanContext is what's needed to make a span the parent of another one,
+// even when that other span is in a different process
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (c SpanContext) IsValid() bool {
+	return !c.TraceID.IsZero() && !c.SpanID.IsZero()
+}
+
+// Traceparent formats the span context as a W3C Trace Context traceparent
+// header, see https://www.w3.org/TR/trace-context/#traceparent-header
+func (c SpanContext) Traceparent() string {
+	if !c.IsValid() {
+		return ""
+	}
+
+	return fmt.Sprintf("00-%s-%s-01", c.TraceID, c.SpanID)
+}
+
+// ParseTraceparent reads a span context from a W3C traceparent header
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	var c SpanContext
+
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[3]) != 2 {
+		return c, fmt.Errorf("Invalid traceparent %q", traceparent)
+	}
+
+	if parts[0] == "ff" {
+		return c, fmt.Errorf("Invalid traceparent version %q", parts[0])
+	}
+
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(c.TraceID) {
+		return c, fmt.Errorf("Invalid trace id in traceparent %q", traceparent)
+	}
+
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(c.SpanID) {
+		return c, fmt.Errorf("Invalid span id in traceparent %q", traceparent)
+	}
+
+	copy(c.TraceID[:], traceID)
+	copy(c.SpanID[:], spanID)
+
+	if !c.IsValid() {
+		return c, fmt.Errorf("Invalid traceparent %q", traceparent)
+	}
+
+	return c, nil
+}
+
+// NewSpanContext creates the context for a new span beneath parent, or at
+// the root of a new trace if the parent isn't valid. It doesn't need a
+// Tracer, so jobs can be given a trace context even when they aren't being
+// traced themselves.
+func NewSpanContext(parent SpanContext) SpanContext {
+	c := SpanContext{TraceID: parent.TraceID, SpanID: newSpanID()}
+	if !parent.IsValid() {
+		c.TraceID = newTraceID()
+	}
+
+	return c
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return
+}
@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/logger"
+)
+
+// Exporter sends finished spans somewhere they can be looked at
+type Exporter interface {
+	Export(spans []*Span) error
+	String() string
+}
+
+// Tracer creates spans and holds on to them once they've finished until
+// they're flushed to the exporter
+type Tracer struct {
+	exporter Exporter
+
+	finished []*Span
+	mu       sync.Mutex
+}
+
+// NewTracer creates a tracer that sends spans to the exporter
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// StartSpan starts a span with the given parent, or a new trace if the parent
+// isn't valid. It's safe to call on a nil Tracer, which returns a nil Span
+// that ignores everything done to it, so tracing is optional for callers.
+func (t *Tracer) StartSpan(name string, parent SpanContext) *Span {
+	return t.StartSpanAt(name, parent, time.Now())
+}
+
+// StartSpanAt is like StartSpan, but for a span that started in the past
+func (t *Tracer) StartSpanAt(name string, parent SpanContext, startTime time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+
+	s := &Span{
+		tracer:     t,
+		Name:       name,
+		Context:    NewSpanContext(parent),
+		StartTime:  startTime,
+		Attributes: map[string]string{},
+	}
+
+	if parent.IsValid() {
+		s.ParentID = parent.SpanID
+	}
+
+	return s
+}
+
+// Flush sends all the finished spans to the exporter
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	spans := t.finished
+	t.finished = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := t.exporter.Export(spans); err != nil {
+		logger.Warn("[Tracing] Failed to send %d spans to %s: %v", len(spans), t.exporter, err)
+	}
+}
+
+func (t *Tracer) finish(s *Span) {
+	t.mu.Lock()
+	t.finished = append(t.finished, s)
+	t.mu.Unlock()
+}
+
+// Span is a single timed operation within a trace
+type Span struct {
+	Name       string
+	Context    SpanContext
+	ParentID   SpanID
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]string
+	Error      string
+
+	tracer *Tracer
+	mu     sync.Mutex
+}
+
+// SpanContext returns the context needed to start child spans, which is
+// empty for a nil Span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.Context
+}
+
+// StartChild starts a new span with this one as its parent
+func (s *Span) StartChild(name string) *Span {
+	return s.StartChildAt(name, time.Now())
+}
+
+// StartChildAt is like StartChild, but for a span that started in the past
+func (s *Span) StartChildAt(name string, startTime time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return s.tracer.StartSpanAt(name, s.Context, startTime)
+}
+
+// SetAttribute records some extra detail about the span
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed, if the error isn't nil
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// Finish ends the span. It'll be sent the next time the tracer is flushed.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if !s.EndTime.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.finish(s)
+}
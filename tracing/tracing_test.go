@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	c := SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}
+
+	parsed, err := ParseTraceparent(c.Traceparent())
+	assert.NoError(t, err)
+	assert.Equal(t, c, parsed)
+}
+
+func TestParseTraceparentRejectsInvalidValues(t *testing.T) {
+	for _, traceparent := range []string{
+		"",
+		"00-nope-nope-01",
+		"00-00000000000000000000000000000000-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(traceparent)
+		assert.Error(t, err, traceparent)
+	}
+}
+
+func TestNilTracerAndSpansAreNoOps(t *testing.T) {
+	var tracer *Tracer
+
+	span := tracer.StartSpan("job", SpanContext{})
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("Nope"))
+	span.StartChild("child").Finish()
+	span.Finish()
+	tracer.Flush()
+
+	assert.False(t, span.SpanContext().IsValid())
+}
+
+func TestChildSpansShareATrace(t *testing.T) {
+	tracer := NewTracer(nil)
+
+	parent := tracer.StartSpan("job", SpanContext{})
+	child := parent.StartChild("checkout")
+
+	assert.Equal(t, parent.Context.TraceID, child.Context.TraceID)
+	assert.Equal(t, parent.Context.SpanID, child.ParentID)
+	assert.NotEqual(t, parent.Context.SpanID, child.Context.SpanID)
+}
+
+func TestOTLPExporterSendsFinishedSpans(t *testing.T) {
+	var received otlpRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	tracer := NewTracer(NewOTLPExporter(server.URL, "buildkite-agent"))
+
+	span := tracer.StartSpan("command", SpanContext{})
+	span.SetAttribute("buildkite.job_id", "123")
+	span.RecordError(errors.New("exited with status 1"))
+	span.Finish()
+	tracer.Flush()
+
+	if assert.Len(t, received.ResourceSpans, 1) {
+		spans := received.ResourceSpans[0].ScopeSpans[0].Spans
+		if assert.Len(t, spans, 1) {
+			assert.Equal(t, "command", spans[0].Name)
+			assert.Equal(t, span.Context.TraceID.String(), spans[0].TraceID)
+			assert.Equal(t, otlpStatusError, spans[0].Status.Code)
+			assert.Equal(t, []otlpAttribute{{Key: "buildkite.job_id", Value: otlpValue{StringValue: "123"}}}, spans[0].Attributes)
+		}
+	}
+}
+
+func TestNewSpanContextContinuesATrace(t *testing.T) {
+	parent, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NoError(t, err)
+
+	child := NewSpanContext(parent)
+	assert.Equal(t, parent.TraceID, child.TraceID)
+	assert.NotEqual(t, parent.SpanID, child.SpanID)
+
+	root := NewSpanContext(SpanContext{})
+	assert.True(t, root.IsValid())
+	assert.NotEqual(t, parent.TraceID, root.TraceID)
+}
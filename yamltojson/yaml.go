@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
 
 	// This is a fork of gopkg.in/yaml.v2 that fixes anchors with MapSlice
 	"github.com/buildkite/yaml"
@@ -65,7 +66,25 @@ func marshalInterfaceJSON(i interface{}) ([]byte, error) {
 		return marshalSliceJSON(s)
 	case []interface{}:
 		return marshalSliceJSON(t)
+	case map[interface{}]interface{}:
+		return MarshalMapSliceJSON(sortedMapSlice(t))
 	default:
 		return json.Marshal(i)
 	}
 }
+
+// sortedMapSlice converts an unordered map into a MapSlice sorted by key, so
+// that maps that didn't come from the original document still marshal the
+// same way every time
+func sortedMapSlice(m map[interface{}]interface{}) yaml.MapSlice {
+	s := make(yaml.MapSlice, 0, len(m))
+	for k, v := range m {
+		s = append(s, yaml.MapItem{Key: k, Value: v})
+	}
+
+	sort.Slice(s, func(i, j int) bool {
+		return fmt.Sprint(s[i].Key) < fmt.Sprint(s[j].Key)
+	})
+
+	return s
+}
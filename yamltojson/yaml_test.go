@@ -0,0 +1,31 @@
+package yamltojson
+
+import (
+	"testing"
+
+	"github.com/buildkite/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalMapSliceJSONPreservesOrder(t *testing.T) {
+	var m yaml.MapSlice
+	if err := yaml.Unmarshal([]byte("env:\n  ZULU: 1\n  ALPHA: 2\n  MIKE: [a, b]\n"), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := MarshalMapSliceJSON(m)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"env":{"ZULU":1,"ALPHA":2,"MIKE":["a","b"]}}`, string(j))
+}
+
+func TestMarshalMapSliceJSONSortsUnorderedMaps(t *testing.T) {
+	m := yaml.MapSlice{
+		{Key: "env", Value: map[interface{}]interface{}{"ZULU": 1, "ALPHA": 2, "MIKE": 3}},
+	}
+
+	for i := 0; i < 10; i++ {
+		j, err := MarshalMapSliceJSON(m)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"env":{"ALPHA":2,"MIKE":3,"ZULU":1}}`, string(j))
+	}
+}